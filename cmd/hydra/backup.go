@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/backup"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/storage"
+	"io"
+	"log"
+	"os"
+)
+
+// runBackup - команда "hydra backup": создание или проверка резервной копии
+//
+//	hydra backup -out FILE [-incremental-from PREV]
+//	hydra backup -verify FILE
+func runBackup(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("out", "", "файл создаваемой резервной копии")
+	incrementalFrom := fs.String("incremental-from", "", "предыдущая копия: в новую попадут только измененные объекты")
+	verify := fs.String("verify", "", "проверить целостность резервной копии")
+	fs.Parse(args)
+
+	key, err := backup.ParseKey(cfg.BackupKey)
+	if err != nil {
+		return fmt.Errorf("BACKUP_KEY: %w", err)
+	}
+
+	if *verify != "" {
+		file, err := os.Open(*verify)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		manifest, err := backup.Verify(file, key)
+		if err != nil {
+			return fmt.Errorf("резервная копия повреждена: %w", err)
+		}
+		log.Printf("Резервная копия %s в порядке: %d таблиц, %d объектов", manifest.ID, len(manifest.Tables), len(manifest.Blobs))
+		return nil
+	}
+
+	if *out == "" {
+		return fmt.Errorf("укажите -out или -verify")
+	}
+
+	var base *backup.Manifest
+	if *incrementalFrom != "" {
+		file, err := os.Open(*incrementalFrom)
+		if err != nil {
+			return err
+		}
+		base, err = backup.ReadManifest(file, key)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать базовую копию: %w", err)
+		}
+	}
+
+	db, err := storage.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	blobs, err := blobstore.New(cfg.BlobStoragePath)
+	if err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем только после проверки
+	tmp := *out + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup.Create(context.Background(), file, key, db, blobs, base)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyFile(tmp, key)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+
+	included := 0
+	for _, blob := range manifest.Blobs {
+		if blob.Included {
+			included++
+		}
+	}
+	log.Printf("Резервная копия %s записана в %s: %d таблиц, %d из %d объектов", manifest.ID, *out, len(manifest.Tables), included, len(manifest.Blobs))
+	return nil
+}
+
+// runRestore - команда "hydra restore FULL [INCREMENTAL...]": восстановление цепочки копий
+func runRestore(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("укажите файлы резервных копий: полная, затем инкрементальные по порядку")
+	}
+
+	key, err := backup.ParseKey(cfg.BackupKey)
+	if err != nil {
+		return fmt.Errorf("BACKUP_KEY: %w", err)
+	}
+
+	// Перед изменением данных проверяем все архивы цепочки
+	for _, path := range fs.Args() {
+		if err := verifyFile(path, key); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	db, err := storage.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	blobs, err := blobstore.New(cfg.BlobStoragePath)
+	if err != nil {
+		return err
+	}
+
+	var archives []io.Reader
+	for _, path := range fs.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		archives = append(archives, file)
+	}
+
+	manifest, err := backup.Restore(context.Background(), archives, key, db, blobs)
+	if err != nil {
+		return err
+	}
+	log.Printf("Восстановлена резервная копия %s от %s", manifest.ID, manifest.CreatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func verifyFile(path string, key []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = backup.Verify(file, key)
+	return err
+}
@@ -2,37 +2,210 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"hydra/internal/config"
 	"hydra/internal/server"
+	"hydra/pkg/discovery"
+	"hydra/pkg/seal"
 	"hydra/pkg/storage"
+	"hydra/pkg/transport/fronting"
 	"hydra/pkg/transport/manager"
+	"hydra/pkg/transport/mesh"
 	"log"
+	"os"
 	"time"
 )
 
 func main() {
-	log.Println("Запуск Hydra Messenger...")
-
 	// Загрузка конфигурации
 	cfg, err := config.Load()
 	if err != nil {
 		log.Printf("Предупреждение: не удалось загрузить .env файл (%v), используются значения по умолчанию", err)
 	}
 
+	// Служебные команды резервного копирования, миграций схемы, ключей и отдельный ретранслятор сигнализации
+	if len(os.Args) > 1 {
+		var cmdErr error
+		switch os.Args[1] {
+		case "backup":
+			cmdErr = runBackup(cfg, os.Args[2:])
+		case "restore":
+			cmdErr = runRestore(cfg, os.Args[2:])
+		case "signaling":
+			cmdErr = runSignaling(cfg, os.Args[2:])
+		case "migrate":
+			cmdErr = runMigrate(cfg, os.Args[2:])
+		case "rotate-keys":
+			cmdErr = runRotateKeys(cfg, os.Args[2:])
+		case "vapid-keys":
+			cmdErr = runVAPIDKeys()
+		default:
+			log.Fatalf("Неизвестная команда %q (доступны: backup, restore, migrate, rotate-keys, signaling, vapid-keys)", os.Args[1])
+		}
+		if cmdErr != nil {
+			log.Fatalf("Ошибка %s: %v", os.Args[1], cmdErr)
+		}
+		return
+	}
+
+	log.Println("Запуск Hydra Messenger...")
+
 	// Инициализируем менеджер транспортов с автоматическим переключением
 	log.Println("Инициализация менеджера транспортов...")
 
-	transportManager := manager.New()
+	var frontingOpts []fronting.Option
+	if cfg.FrontingProxy != "" {
+		proxyURL, err := fronting.ParseProxyURL(cfg.FrontingProxy)
+		if err != nil {
+			log.Fatalf("Ошибка конфигурации FRONTING_PROXY: %v", err)
+		}
+		log.Printf("Domain Fronting через прокси %s://%s", proxyURL.Scheme, proxyURL.Host)
+		frontingOpts = append(frontingOpts, fronting.WithProxy(proxyURL))
+	}
+
+	frontPool := fronting.NewPool(cfg.HiddenDomain, cfg.FrontDomains, frontingOpts...)
+	frontPool.Configure(func(t *fronting.Transport) {
+		t.StreamTimeout = cfg.FrontingTimeout
+		t.Retry = fronting.RetryPolicy{
+			MaxAttempts: cfg.FrontingRetries,
+			BaseDelay:   cfg.FrontingRetryDelay,
+			MaxDelay:    cfg.FrontingRetryMax,
+		}
+		if err := t.SetProtocol(cfg.FrontingProtocol); err != nil {
+			log.Printf("Предупреждение: %v, используется HTTP/2", err)
+		}
+	})
+	if cfg.FrontListURL != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.FrontListPublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Printf("Предупреждение: некорректный FRONT_LIST_PUBLIC_KEY, удаленный список фронтов отключен")
+		} else {
+			frontPool.SetRemoteList(cfg.FrontListURL, ed25519.PublicKey(key))
+		}
+	}
+	frontPool.Start(cfg.FrontCheckInterval)
 
-	// Инициализация хранилища
+	// Инициализация хранилища (нужно и для статических пиров mesh)
 	log.Printf("Подключение к БД: %s", cfg.DatabaseURL)
 	db, err := storage.New(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Ошибка инициализации хранилища: %v", err)
 	}
+	db.SetPool(storage.PoolConfig{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+		ConnMaxIdleTime: cfg.DBConnMaxIdleTime,
+	})
+	keys, err := seal.ParseKeys(cfg.AtRestKeys, []byte(cfg.AtRestSalt))
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации AT_REST_KEYS: %v", err)
+	}
+	if keys.Enabled() {
+		log.Printf("Шифрование данных в базе включено, текущий ключ %d", keys.Current())
+	}
+	db.SetKeyring(keys)
+
+	transportManager := manager.New(frontPool)
+
+	// Bootstrap узлы: из конфигурации и подписанного удаленного списка
+	bootstrapOpts := discovery.Options{BootstrapPeers: cfg.MeshBootstrapPeers, BootstrapListURL: cfg.MeshBootstrapURL}
+	if cfg.MeshBootstrapURL != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.MeshBootstrapListKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Printf("Предупреждение: некорректный MESH_BOOTSTRAP_PUBLIC_KEY, удаленный список bootstrap узлов отключен")
+			bootstrapOpts.BootstrapListURL = ""
+		} else {
+			bootstrapOpts.BootstrapListKey = ed25519.PublicKey(key)
+		}
+	}
+
+	// Постоянный ключ узла: по нему другие узлы узнают этот узел (MESH_TRUSTED_KEYS)
+	var nodeKey ed25519.PrivateKey
+	if cfg.MeshNodeKey != "" {
+		if nodeKey, err = mesh.ParseNodeKey(cfg.MeshNodeKey); err != nil {
+			log.Fatalf("Ошибка настройки MESH_NODE_KEY: %v", err)
+		}
+	}
+
+	// С автоматическим обнаружением пирами mesh управляет discovery (mDNS, bootstrap, статические пиры)
+	var peerManager *discovery.AutoPeerManager
+	if cfg.MeshDiscovery {
+		opts := bootstrapOpts
+		opts.ListenPort = cfg.MeshPort
+		opts.BindInterface = cfg.MeshBindInterface
+		opts.AdvertiseAddr = cfg.MeshAdvertiseAddr
+		opts.PeerStore = db
+		opts.NodeKey = nodeKey
+		for _, encoded := range cfg.MeshTrustedKeys {
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				log.Fatalf("Некорректный ключ в MESH_TRUSTED_KEYS: %q", encoded)
+			}
+			opts.TrustedKeys = append(opts.TrustedKeys, ed25519.PublicKey(key))
+		}
+		if peerManager, err = discovery.NewAutoPeerManager(opts); err != nil {
+			log.Fatalf("Ошибка запуска обнаружения пиров: %v", err)
+		}
+		transportManager.SetMesh(peerManager.GetMeshTransport())
+	} else {
+		meshListenAddr, err := mesh.BindAddr(cfg.MeshBindInterface, cfg.MeshPort)
+		if err != nil {
+			log.Fatalf("Ошибка настройки mesh: %v", err)
+		}
+		transportManager.Mesh().SetListenAddr(meshListenAddr)
+		transportManager.Mesh().SetAdvertiseAddr(cfg.MeshAdvertiseAddr)
+		if nodeKey != nil {
+			transportManager.Mesh().SetIdentity(nodeKey)
+		}
+
+		// Bootstrap узлы заменяют встроенный список пиров mesh
+		if peers := discovery.BootstrapPeers(context.Background(), bootstrapOpts); len(peers) > 0 {
+			transportManager.Mesh().UpdatePeers(peers)
+		}
+	}
+	transportManager.Mesh().SetQUIC(cfg.MeshQUIC)
+	transportManager.Mesh().SetResourceLimits(mesh.ResourceLimits{
+		MaxQueueBytes:     cfg.MeshMaxQueueBytes,
+		MaxRelayBandwidth: cfg.MeshMaxRelayBandwidth,
+		BatterySaver:      cfg.MeshBatterySaver,
+	})
+	if cfg.MeshRelayNode {
+		transportManager.Mesh().EnableRelayNode()
+	}
+	if cfg.MeshNAT {
+		transportManager.Mesh().EnableNAT(cfg.MeshSTUNServers)
+	}
+	if cfg.MeshDTN {
+		var dtnKey []byte
+		if cfg.MeshDTNKey != "" {
+			if dtnKey, err = mesh.ParseDTNKey(cfg.MeshDTNKey); err != nil {
+				log.Fatalf("Ошибка настройки DTN: %v", err)
+			}
+		}
+		err = transportManager.Mesh().EnableDTN(mesh.DTNOptions{
+			Key:        dtnKey,
+			Lifetime:   cfg.MeshDTNLifetime,
+			MaxBundles: cfg.MeshDTNMaxBundles,
+			MaxBytes:   int64(cfg.MeshDTNMaxBytes),
+			StorePath:  cfg.MeshDTNStorePath,
+		})
+		if err != nil {
+			log.Fatalf("Ошибка настройки DTN: %v", err)
+		}
+	}
+	if peerManager != nil {
+		if err := peerManager.Start(); err != nil {
+			log.Printf("Предупреждение: %v", err)
+		}
+	}
 
 	// Инициализация сервера
 	srv := server.New(cfg, transportManager, db)
+	if peerManager != nil {
+		srv.SetPeerManager(peerManager)
+	}
 
 	// Запускаем сервер в отдельной горутине
 	go func() {
@@ -43,7 +216,11 @@ func main() {
 		}
 	}()
 
-	log.Printf("Веб-интерфейс доступен по адресу: http://localhost:%s", cfg.ServerPort)
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	log.Printf("Веб-интерфейс доступен по адресу: %s://localhost:%s", scheme, cfg.ServerPort)
 	log.Println("Для остановки нажмите Ctrl+C")
 
 	// Демонстрационная отправка сообщения (опционально)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/storage"
+	"log"
+)
+
+// runMigrate - команда "hydra migrate": состояние и ручное управление версией схемы.
+// Сервер при запуске сам применяет недостающие миграции; команда нужна, чтобы
+// откатить схему перед возвратом на предыдущую версию сервера.
+//
+//	hydra migrate -status
+//	hydra migrate [-to VERSION]
+//	hydra migrate -down N
+func runMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := fs.Bool("status", false, "показать текущую версию схемы и доступные миграции")
+	to := fs.Int("to", -1, "перевести схему на указанную версию (по умолчанию - последняя)")
+	down := fs.Int("down", 0, "откатить указанное число последних миграций")
+	fs.Parse(args)
+
+	db, err := storage.Open(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	migrations, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *status {
+		for _, m := range migrations {
+			mark := " "
+			if m.Version <= current {
+				mark = "*"
+			}
+			fmt.Printf("%s %04d_%s\n", mark, m.Version, m.Name)
+		}
+		log.Printf("Версия схемы: %d из %d", current, len(migrations))
+		return nil
+	}
+
+	target := *to
+	if *down > 0 {
+		if target >= 0 {
+			return fmt.Errorf("укажите -to или -down, но не оба")
+		}
+		target = current - *down
+		if target < 0 {
+			return fmt.Errorf("нельзя откатить %d миграций: применено %d", *down, current)
+		}
+	}
+
+	if err := db.Migrate(ctx, target); err != nil {
+		return err
+	}
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Версия схемы: %d", version)
+	return nil
+}
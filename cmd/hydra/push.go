@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"hydra/pkg/push"
+)
+
+// runVAPIDKeys создает ключ VAPID для Web Push и печатает строку для .env и открытый ключ
+//
+//	hydra vapid-keys
+func runVAPIDKeys() error {
+	private, err := push.GenerateVAPIDKey()
+	if err != nil {
+		return err
+	}
+	wp, err := push.NewWebPush(private, "mailto:admin@example.com")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("PUSH_VAPID_PRIVATE_KEY=%s\n", private)
+	fmt.Printf("# открытый ключ (applicationServerKey): %s\n", wp.PublicKey())
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/seal"
+	"hydra/pkg/storage"
+	"log"
+)
+
+// runRotateKeys - команда "hydra rotate-keys": перешифровывает текущим ключом AT_REST_KEYS
+// все значения, записанные открыто или прежними ключами. Сервер может работать во
+// время ротации; после нее прежние ключи можно убрать из AT_REST_KEYS.
+//
+//	hydra rotate-keys [-batch N]
+func runRotateKeys(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	batch := fs.Int("batch", 500, "число строк, читаемых за один запрос")
+	fs.Parse(args)
+
+	keys, err := seal.ParseKeys(cfg.AtRestKeys, []byte(cfg.AtRestSalt))
+	if err != nil {
+		return fmt.Errorf("AT_REST_KEYS: %w", err)
+	}
+	if !keys.Enabled() {
+		return fmt.Errorf("AT_REST_KEYS не задан")
+	}
+
+	db, err := storage.New(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	db.SetKeyring(keys)
+
+	n, err := db.RotateKeys(context.Background(), *batch)
+	if err != nil {
+		return err
+	}
+	log.Printf("Перешифровано значений: %d (ключ %d)", n, keys.Current())
+	return nil
+}
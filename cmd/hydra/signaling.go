@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/signaling"
+	"log"
+	"net/http"
+)
+
+// runSignaling - команда "hydra signaling": отдельный ретранслятор сигнализации звонков
+// без базы и транспортов. Токены клиентов выдает сервер сообщений с тем же SIGNALING_SECRET.
+//
+//	hydra signaling [-addr :8082] [-path /signal/]
+func runSignaling(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("signaling", flag.ExitOnError)
+	addr := fs.String("addr", ":"+cfg.SignalingPort, "адрес HTTP слушателя")
+	path := fs.String("path", "/signal/", "префикс путей ретранслятора")
+	fs.Parse(args)
+
+	if cfg.SignalingSecret == "" {
+		return fmt.Errorf("SIGNALING_SECRET не задан: без общего секрета ретранслятор не примет токены сервера")
+	}
+
+	relay := signaling.NewRelay([]byte(cfg.SignalingSecret))
+	mux := http.NewServeMux()
+	mux.Handle(*path, relay.Handler(*path))
+
+	log.Printf("Ретранслятор сигнализации запущен на %s%s", *addr, *path)
+	return http.ListenAndServe(*addr, mux)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"hydra/pkg/relay"
 	"log"
 	"net/http"
 	"os"
@@ -15,6 +16,8 @@ func main() {
 		port = "8081"
 	}
 
+	hidden := relay.New("secret-chat.appspot.com")
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Логируем все заголовки для отладки
 		log.Printf("=== ВХОДЯЩИЙ ЗАПРОС ===")
@@ -27,16 +30,10 @@ func main() {
 		}
 
 		// Проверяем Domain Fronting
-		if r.Host == "secret-chat.appspot.com" {
+		if r.Host == hidden.HiddenDomain {
 			// Это запрос через Domain Fronting!
 			log.Printf("✓ Обнаружен Domain Fronting запрос!")
-
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"status":    "success",
-				"message":   "Domain Fronting работает! Сообщение доставлено.",
-				"technique": "SNI: ajax.googleapis.com, Host: secret-chat.appspot.com",
-			})
+			hidden.ServeHTTP(w, r)
 			return
 		}
 
@@ -43,7 +43,7 @@ go mod download
 
 # Собираем бинарник
 echo "🔨 Сборка бинарного файла..."
-go build -o hydra-messenger cmd/hydra/main.go
+go build -o hydra-messenger ./cmd/hydra
 
 # Создаем конфигурационный файл
 if [ ! -f "config.yaml" ]; then
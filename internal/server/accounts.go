@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/storage"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// reauthWindow - сколько после входа сессия подтверждает личность вместо пароля
+// (у аккаунтов, созданных входом через поставщика, пароля нет)
+const reauthWindow = 10 * time.Minute
+
+// handleUserAccount обрабатывает POST /api/users/{id}/deactivate и /api/users/{id}/reactivate.
+// Деактивированный пользователь пропадает из поиска и присутствия, входящие сообщения
+// копятся на сервере или отклоняются (inbound_mode), данные аккаунта сохраняются.
+// POST /api/users/{id}/delete удаляет аккаунт вместе с данными (см. deleteAccount).
+// Все действия доступны только самому пользователю по токену входа.
+func (s *Server) handleUserAccount(w http.ResponseWriter, r *http.Request, userID, action string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if action == "delete" {
+		s.deleteAccount(w, r, userID)
+		return
+	}
+
+	if _, err := s.db.GetUser(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	switch action {
+	case "deactivate":
+		var req struct {
+			InboundMode string `json:"inbound_mode"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+				return
+			}
+		}
+		if req.InboundMode == "" {
+			req.InboundMode = s.config.DeactivatedInboundMode
+		}
+
+		state, err := s.db.DeactivateUser(r.Context(), userID, req.InboundMode)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		log.Printf("User %s deactivated (inbound: %s)", userID, state.InboundMode)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "account": state})
+
+	case "reactivate":
+		queued, err := s.db.ReactivateUser(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to reactivate user"})
+			return
+		}
+		if queued == nil {
+			queued = []*storage.QueuedMessage{}
+		}
+		s.touchUser(userID)
+		log.Printf("User %s reactivated, %d queued messages delivered", userID, len(queued))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "messages": queued})
+	}
+}
+
+// deleteAccount удаляет аккаунт userID и все его данные без возможности восстановления
+// (право на удаление): сообщения, вложения, голосовые сообщения, сессии, устройства,
+// ключи и приглашения. Нужен токен входа самого пользователя и повторный ввод пароля
+// {password}, чтобы украденный токен не позволял удалить аккаунт. Аккаунт, созданный
+// входом через поставщика, пароля не знает: вместо него нужна сессия, выданная не
+// раньше reauthWindow назад (повторный вход через поставщика).
+func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request, userID string) {
+	caller, sess, err := s.bearerSession(r)
+	if err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	user, err := s.db.GetUser(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+	passwordless, err := s.passwordless(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to check account"})
+		return
+	}
+	if passwordless {
+		if sess == nil || time.Since(sess.CreatedAt) > reauthWindow {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recent sign-in required"})
+			return
+		}
+	} else {
+		contact := user.Email
+		if contact == "" {
+			contact = user.Phone
+		}
+		// Пароль подбирается с тем же ограничением неудачных попыток, что и при входе
+		if s.loginLocked(w, r, contact) {
+			return
+		}
+		if checked, err := s.db.ValidateUser(r.Context(), contact, req.Password); err != nil || checked.ID != userID {
+			s.loginFailed(r, contact, user)
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid password"})
+			return
+		}
+		s.loginSucceeded(contact)
+	}
+
+	if err := s.audit(userID, "user.delete", userID, nil); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+	if _, err := s.deleteAvatar(r.Context(), userID); err != nil {
+		log.Printf("Failed to delete avatar of %s: %v", userID, err)
+	}
+	erased, err := s.db.PurgeUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to delete account %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete user"})
+		return
+	}
+	s.eraseFiles(erased)
+
+	s.mu.Lock()
+	delete(s.contacts, userID)
+	s.mu.Unlock()
+
+	log.Printf("Account %s deleted: %d messages, %d attachments, %d voice messages",
+		userID, erased.Messages, len(erased.AttachmentKeys), len(erased.VoicePaths))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "erased": map[string]interface{}{
+		"messages":       erased.Messages,
+		"attachments":    len(erased.AttachmentKeys),
+		"voice_messages": len(erased.VoicePaths),
+	}})
+}
+
+// passwordless сообщает, создан ли аккаунт входом через поставщика: пароль такого
+// аккаунта случайный и пользователю неизвестен (см. oidcLogin)
+func (s *Server) passwordless(ctx context.Context, userID string) (bool, error) {
+	identities, err := s.db.ListExternalIdentities(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, identity := range identities {
+		if identity.Signup {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// eraseFiles удаляет файлы удаленного аккаунта; ошибки только логируются, записей
+// о файлах в базе уже нет
+func (s *Server) eraseFiles(erased *storage.ErasedAccount) {
+	if s.blobs != nil {
+		for _, key := range erased.AttachmentKeys {
+			if err := s.blobs.Delete(key); err != nil {
+				log.Printf("Failed to delete attachment %s of %s: %v", key, erased.UserID, err)
+			}
+		}
+	}
+	for _, path := range erased.VoicePaths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to delete voice message %s of %s: %v", path, erased.UserID, err)
+		}
+	}
+}
+
+// accountState возвращает состояние деактивации пользователя; nil - аккаунт активен или неизвестен
+func (s *Server) accountState(userID string) *storage.AccountState {
+	if s.db == nil || userID == "" {
+		return nil
+	}
+	state, err := s.db.GetAccountState(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to get account state of %s: %v", userID, err)
+		return nil
+	}
+	return state
+}
+
+// splitAccountPath разбирает "{id}/deactivate" и "{id}/reactivate"
+func splitAccountPath(path string) (userID, action string, ok bool) {
+	userID, action, found := strings.Cut(strings.Trim(path, "/"), "/")
+	if !found || (action != "deactivate" && action != "reactivate" && action != "delete") {
+		return "", "", false
+	}
+	return userID, action, true
+}
+
+// holdForDeactivated ставит сообщение в очередь деактивированного получателя или отклоняет его
+func (s *Server) holdForDeactivated(w http.ResponseWriter, state *storage.AccountState, req *sendRequest) {
+	if state.InboundMode == storage.InboundBounce {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recipient is unavailable"})
+		return
+	}
+
+	msg := &storage.QueuedMessage{UserID: req.To, SenderID: req.From, Body: req.Message}
+	if err := s.db.QueueMessage(context.Background(), msg); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to queue message"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "queued": true, "id": msg.ID})
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Обзорные эндпоинты администратора /api/admin/*: список пользователей и блокировка
+// аккаунтов, использование приглашений, история состояния транспортов и размер
+// хранилища. Доступны с ADMIN_TOKEN и пользователям с ролью admin (см. adminActor).
+
+// withAdmin пропускает к обработчику только администраторов
+func (s *Server) withAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if s.db == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Database is not configured"})
+			return
+		}
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminUsers обрабатывает GET /api/admin/users: пользователи по возрастанию ID с
+// ролью admin, режимом отпуска и блокировкой
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r, defaultPageLimit)
+	if !ok {
+		return
+	}
+	users, err := s.db.ListUserSummaries(r.Context(), page.After, page.Limit+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list users"})
+		return
+	}
+	next := ""
+	if len(users) > page.Limit {
+		users = users[:page.Limit]
+		next = pageCursor(users[len(users)-1].ID)
+	}
+	if users == nil {
+		users = []*storage.UserSummary{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "users": users, "next_cursor": next})
+}
+
+// handleAdminUserDisable обрабатывает POST /api/admin/users/{id}/disable {reason}:
+// аккаунт блокируется, его сессии завершаются
+func (s *Server) handleAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+	}
+	if _, err := s.db.GetUser(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+	actor, _ := s.adminActor(r)
+	// Администратор с ролью не может заблокировать сам себя и потерять доступ
+	if actor == userID {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Cannot disable your own account"})
+		return
+	}
+
+	if err := s.audit(actor, "user.disable", userID, map[string]string{"reason": req.Reason}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+	disabled, err := s.db.DisableUser(r.Context(), userID, actor, strings.TrimSpace(req.Reason))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to disable user"})
+		return
+	}
+	if err := s.db.RevokeUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Failed to revoke sessions of disabled user %s: %v", userID, err)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "disabled": disabled})
+}
+
+// handleAdminUserEnable обрабатывает POST /api/admin/users/{id}/enable: снятие блокировки
+func (s *Server) handleAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	actor, _ := s.adminActor(r)
+	if err := s.audit(actor, "user.enable", userID, nil); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+	found, err := s.db.EnableUser(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to enable user"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User is not disabled"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// adminInvite - приглашение с регистрациями по нему
+type adminInvite struct {
+	*storage.Invite
+	Registrations []*storage.InviteUse `json:"registrations"`
+}
+
+// handleAdminInvites обрабатывает GET /api/admin/invites[?created_by=]: приглашения
+// всех авторов, новые первыми, с зарегистрированными по ним пользователями и итогами
+func (s *Server) handleAdminInvites(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r, defaultPageLimit)
+	if !ok {
+		return
+	}
+	invites, err := s.db.ListInvites(r.Context(), r.URL.Query().Get("created_by"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list invites"})
+		return
+	}
+
+	now := time.Now()
+	totals := map[string]int{"issued": len(invites), "active": 0, "uses": 0}
+	for _, inv := range invites {
+		totals["uses"] += inv.Uses
+		if inv.RevokedAt.IsZero() && now.Before(inv.ExpiresAt) && inv.Uses < inv.MaxUses {
+			totals["active"]++
+		}
+	}
+
+	invites, next := paginate(invites, page, func(inv *storage.Invite) string { return newestFirstKey(inv.CreatedAt, inv.Token) })
+	result := make([]adminInvite, 0, len(invites))
+	for _, inv := range invites {
+		uses, err := s.db.ListInviteUses(r.Context(), inv.Token)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load invite uses"})
+			return
+		}
+		if uses == nil {
+			uses = []*storage.InviteUse{}
+		}
+		result = append(result, adminInvite{Invite: inv, Registrations: uses})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "invites": result, "totals": totals, "next_cursor": next})
+}
+
+// handleAdminTransportHistory обрабатывает GET /api/admin/transport/history[?domain=&limit=]:
+// последние события транспортов, сводка блокировок за окно истории и состояние фронтов
+func (s *Server) handleAdminTransportHistory(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	events, err := s.db.ListTransportEvents(r.Context(), r.URL.Query().Get("domain"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load transport events"})
+		return
+	}
+	blocks, err := s.db.GetDomainBlockStats(r.Context(), time.Now().Add(-s.config.FrontBlockWindow))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load block history"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"events":  events,
+		"blocks":  blocks,
+		"window":  s.config.FrontBlockWindow.String(),
+	}
+	if s.transportManager != nil {
+		response["fronts"] = s.transportManager.FrontPool().Status()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminStorage обрабатывает GET /api/admin/storage: число записей в таблицах БД
+// и объем файлового хранилища по разделам (первый сегмент ключа)
+func (s *Server) handleAdminStorage(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.db.GetStorageStats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load storage statistics"})
+		return
+	}
+
+	response := map[string]interface{}{"success": true, "database": stats}
+	if s.blobs != nil {
+		type usage struct {
+			Objects int   `json:"objects"`
+			Bytes   int64 `json:"bytes"`
+		}
+		blobs := map[string]*usage{}
+		err := s.blobs.Walk(func(info blobstore.BlobInfo) error {
+			section, _, _ := strings.Cut(info.Key, "/")
+			u := blobs[section]
+			if u == nil {
+				u = &usage{}
+				blobs[section] = u
+			}
+			u.Objects++
+			u.Bytes += info.Size
+			return nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to scan blob storage"})
+			return
+		}
+		response["blobs"] = blobs
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/archive"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Холодное хранение истории (см. pkg/archive). Раз в сутки беседы без сообщений дольше
+// ARCHIVE_AFTER упаковываются в архивы по ARCHIVE_BATCH_SIZE сообщений и удаляются из
+// базы. GET /api/conversations/{id}/messages отдает историю страницами назад от before:
+// когда сообщения в базе кончаются, страница дополняется из архивов.
+
+// archiveCacheSize - сколько регидрированных архивов держать в памяти
+const archiveCacheSize = 32
+
+// newArchiveBackend создает холодное хранилище по ARCHIVE_BACKEND; nil - хранение выключено
+func newArchiveBackend(cfg *config.Config) (archive.Backend, error) {
+	switch cfg.ArchiveBackend {
+	case "":
+		return nil, nil
+	case "local":
+		return archive.NewLocal(cfg.ArchivePath)
+	case "s3":
+		return archive.NewS3(cfg.ArchiveS3Endpoint, cfg.ArchiveS3Bucket, cfg.ArchiveS3Region,
+			cfg.ArchiveS3AccessKey, cfg.ArchiveS3SecretKey, cfg.ArchiveS3StorageClass)
+	default:
+		return nil, fmt.Errorf("unknown ARCHIVE_BACKEND %q", cfg.ArchiveBackend)
+	}
+}
+
+// runArchival раз в сутки архивирует неактивные беседы
+func (s *Server) runArchival() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		s.archiveInactive(time.Now().Add(-s.config.ArchiveAfter))
+		<-ticker.C
+	}
+}
+
+// archiveInactive архивирует все беседы без сообщений новее before
+func (s *Server) archiveInactive(before time.Time) {
+	for {
+		conversations, err := s.db.ListInactiveConversations(context.Background(), before, 100)
+		if err != nil {
+			log.Printf("Failed to list inactive conversations: %v", err)
+			return
+		}
+		if len(conversations) == 0 {
+			return
+		}
+		for _, conversationID := range conversations {
+			n, err := s.archiveConversation(conversationID)
+			if err != nil {
+				// Беседа останется в выдаче: не крутимся на ней, повторим завтра
+				log.Printf("Failed to archive conversation %s: %v", conversationID, err)
+				return
+			}
+			log.Printf("Archived %d messages of conversation %s to cold storage", n, conversationID)
+		}
+	}
+}
+
+// archiveConversation переносит все сообщения беседы в архивы и возвращает их число
+func (s *Server) archiveConversation(conversationID string) (int, error) {
+	batch := s.config.ArchiveBatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+
+	total := 0
+	for {
+		messages, err := s.db.ListMessages(context.Background(), conversationID, batch)
+		if err != nil {
+			return total, err
+		}
+		if len(messages) == 0 {
+			return total, nil
+		}
+
+		data, err := archive.Pack(messages)
+		if err != nil {
+			return total, err
+		}
+		a := &storage.MessageArchive{
+			ID:             "archive-" + ids.New(),
+			ConversationID: conversationID,
+			Messages:       len(messages),
+			SizeBytes:      int64(len(data)),
+			FirstAt:        messages[0].CreatedAt,
+			LastAt:         messages[len(messages)-1].CreatedAt,
+		}
+		a.Key = archive.Key(a.ID)
+		if _, err := s.archives.Put(a.Key, bytes.NewReader(data)); err != nil {
+			return total, err
+		}
+
+		ids := make([]string, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+		if err := s.db.CreateMessageArchive(context.Background(), a, ids); err != nil {
+			// Сообщения остались в базе: архив без записи о нем не нужен
+			if delErr := s.archives.Delete(a.Key); delErr != nil {
+				log.Printf("Failed to delete orphaned archive %s: %v", a.Key, delErr)
+			}
+			return total, err
+		}
+		total += len(messages)
+	}
+}
+
+// conversationHistory возвращает до limit последних сообщений беседы старше before в
+// хронологическом порядке, дополняя сообщения из базы регидрированными из архивов.
+// Второе значение - сколько сообщений прочитано из архивов.
+func (s *Server) conversationHistory(conversationID string, before time.Time, limit int) ([]*storage.Message, int, error) {
+	messages, err := s.db.ListMessagesBefore(context.Background(), conversationID, before, limit)
+	if err != nil || len(messages) >= limit || s.archiveCache == nil {
+		return messages, 0, err
+	}
+
+	archives, err := s.db.ListMessageArchives(context.Background(), conversationID)
+	if err != nil {
+		return nil, 0, err
+	}
+	hot := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		hot[msg.ID] = true
+	}
+
+	rehydrated := 0
+	for _, a := range archives {
+		if len(messages) >= limit {
+			break
+		}
+		if !a.FirstAt.Before(before) {
+			continue
+		}
+		archived, err := s.archiveCache.Load(a.Key)
+		if err != nil {
+			return nil, 0, err
+		}
+		var older []*storage.Message
+		for _, msg := range archived {
+			if msg.CreatedAt.Before(before) && !hot[msg.ID] {
+				older = append(older, msg)
+			}
+		}
+		if need := limit - len(messages); len(older) > need {
+			older = older[len(older)-need:]
+		}
+		messages = append(older, messages...)
+		rehydrated += len(older)
+	}
+	return messages, rehydrated, nil
+}
+
+// conversationMember сообщает, участвует ли пользователь в беседе: состоит в группе или
+// писал в беседу либо получал ее сообщения, в том числе ушедшие в архив
+func (s *Server) conversationMember(ctx context.Context, conversationID, userID string) (bool, error) {
+	member, err := s.db.GetGroupMember(ctx, conversationID, userID)
+	if err != nil || member != nil {
+		return member != nil, err
+	}
+	ok, err := s.db.IsConversationParticipant(ctx, conversationID, userID)
+	if err != nil || ok || s.archiveCache == nil {
+		return ok, err
+	}
+
+	archives, err := s.db.ListMessageArchives(ctx, conversationID)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range archives {
+		archived, err := s.archiveCache.Load(a.Key)
+		if err != nil {
+			return false, err
+		}
+		for _, msg := range archived {
+			if msg.SenderID == userID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// handleConversation обрабатывает /api/conversations/{id}/messages (история, см. ниже) и
+// /api/conversations/{id}/retention (срок хранения, см. retention.go). Нужен токен входа
+// участника беседы; остальным беседа не видна.
+func (s *Server) handleConversation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	conversationID, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/conversations/"), "/")
+	if conversationID != "" {
+		member, err := s.conversationMember(r.Context(), conversationID, userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load conversation"})
+			return
+		}
+		if !member {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Conversation not found"})
+			return
+		}
+	}
+	switch {
+	case conversationID == "":
+	case resource == "messages":
+		s.handleConversationMessages(w, r, conversationID)
+		return
+	case resource == "retention":
+		s.handleConversationRetention(w, r, userID, conversationID)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Not found"})
+}
+
+// handleConversationMessages обрабатывает GET /api/conversations/{id}/messages?limit=&cursor=:
+// страница истории беседы от новых к старым (сообщения страницы - по возрастанию времени).
+// Следующая, более старая, страница запрашивается с cursor из next_cursor. Вместо курсора
+// можно указать before (RFC 3339) - страница до этого момента.
+func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Request, conversationID string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	page, ok := parsePage(w, r, 50)
+	if !ok {
+		return
+	}
+	before := time.Now()
+	value := r.URL.Query().Get("before")
+	if page.After != "" {
+		value = page.After
+	}
+	if value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "before must be an RFC 3339 time"})
+			return
+		}
+		before = t
+	}
+
+	messages, rehydrated, err := s.conversationHistory(conversationID, before, page.Limit)
+	if err != nil {
+		log.Printf("Failed to load history of conversation %s: %v", conversationID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load messages"})
+		return
+	}
+	// Сообщения старше срока хранения, еще не удаленные очисткой, не выдаются
+	cutoff, err := s.retentionCutoff(r.Context(), conversationID)
+	if err != nil {
+		log.Printf("Failed to load retention policy of %s: %v", conversationID, err)
+	}
+	kept := []*storage.Message{}
+	for _, msg := range messages {
+		if !msg.CreatedAt.Before(cutoff) {
+			kept = append(kept, msg)
+		}
+	}
+	// Курсор - время самого старого сообщения страницы; неполная страница - последняя
+	next := ""
+	if len(messages) == page.Limit && len(kept) == len(messages) {
+		next = pageCursor(messages[0].CreatedAt.Format(time.RFC3339Nano))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"messages":    kept,
+		"rehydrated":  rehydrated,
+		"next_cursor": next,
+	})
+}
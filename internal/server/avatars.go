@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/archive"
+	"hydra/pkg/avatar"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Аватар загружается multipart формой (поле file) в PUT /api/users/{id}/avatar; сервер
+// проверяет тип по содержимому и сохраняет квадратные варианты размеров avatar.Sizes.
+// GET /api/users/{id}/avatar?size=N отдает наименьший вариант не меньше N. Ссылка с
+// текущей версией (?v=, поле url ответа загрузки) кешируется навсегда: новая загрузка
+// меняет версию. Без версии ответ кешируется ненадолго и проверяется по ETag.
+
+// defaultAvatarSize - вариант, который отдается без ?size
+const defaultAvatarSize = 256
+
+// avatarMaxAge - срок кеширования аватара по ссылке без версии
+const avatarMaxAge = 5 * 60
+
+// newAvatarBackend создает хранилище аватаров по AVATAR_BACKEND
+func newAvatarBackend(cfg *config.Config) (archive.Backend, error) {
+	switch cfg.AvatarBackend {
+	case "", "local":
+		if cfg.BlobStoragePath == "" {
+			return nil, nil
+		}
+		return archive.NewLocal(cfg.BlobStoragePath)
+	case "s3":
+		return archive.NewS3(cfg.AvatarS3Endpoint, cfg.AvatarS3Bucket, cfg.AvatarS3Region,
+			cfg.AvatarS3AccessKey, cfg.AvatarS3SecretKey, "")
+	default:
+		return nil, fmt.Errorf("unknown AVATAR_BACKEND %q", cfg.AvatarBackend)
+	}
+}
+
+// avatarKey - ключ варианта аватара в хранилище
+func avatarKey(userID, version string, size int) string {
+	return fmt.Sprintf("avatars/%s/%s/%d", userID, version, size)
+}
+
+// avatarURL - ссылка на аватар текущей версии
+func avatarURL(a *storage.Avatar) string {
+	return apiPrefixV1 + "/users/" + a.UserID + "/avatar?v=" + a.Version
+}
+
+// handleUserAvatar обрабатывает /api/users/{id}/avatar: GET - изображение, PUT -
+// загрузка нового аватара, DELETE - удаление. Изменять аватар может только сам пользователь.
+func (s *Server) handleUserAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if s.avatars == nil || s.db == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Avatar storage is not configured"})
+		return
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		s.serveAvatar(w, r, userID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		s.uploadAvatar(w, r, userID)
+
+	case http.MethodDelete:
+		ok, err := s.deleteAvatar(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete avatar"})
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Avatar not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// uploadAvatar сохраняет варианты нового аватара и затем переключает на него версию
+func (s *Server) uploadAvatar(w http.ResponseWriter, r *http.Request, userID string) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.AvatarMaxBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "No file provided: " + err.Error()})
+		return
+	}
+	defer file.Close()
+	if header.Size > s.config.AvatarMaxBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("image is larger than %d bytes", s.config.AvatarMaxBytes)})
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to read file"})
+		return
+	}
+
+	variants, err := avatar.Process(data)
+	if errors.Is(err, avatar.ErrUnsupported) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Avatar must be a JPEG, PNG or GIF image"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	previous, err := s.db.GetAvatar(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save avatar"})
+		return
+	}
+	a := &storage.Avatar{UserID: userID, Version: ids.New(), ContentType: variants[0].ContentType}
+	for _, v := range variants {
+		if _, err := s.avatars.Put(avatarKey(userID, a.Version, v.Size), bytes.NewReader(v.Data)); err != nil {
+			log.Printf("Failed to store avatar of %s: %v", userID, err)
+			s.deleteAvatarVariants(userID, a.Version)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save avatar"})
+			return
+		}
+	}
+	if err := s.db.SaveAvatar(r.Context(), a); err != nil {
+		s.deleteAvatarVariants(userID, a.Version)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save avatar"})
+		return
+	}
+	if previous != nil {
+		s.deleteAvatarVariants(userID, previous.Version)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "avatar": a, "url": avatarURL(a)})
+}
+
+// serveAvatar отдает вариант аватара с заголовками кеширования
+func (s *Server) serveAvatar(w http.ResponseWriter, r *http.Request, userID string) {
+	size := defaultAvatarSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid size"})
+			return
+		}
+		size = avatarVariant(n)
+	}
+
+	a, err := s.db.GetAvatar(r.Context(), userID)
+	if err == nil && a == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Avatar not found"})
+		return
+	}
+	var data []byte
+	if err == nil {
+		data, err = s.readAvatar(avatarKey(userID, a.Version, size))
+	}
+	if err != nil {
+		log.Printf("Failed to load avatar of %s: %v", userID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load avatar"})
+		return
+	}
+
+	if r.URL.Query().Get("v") == a.Version {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", avatarMaxAge))
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, a.Version, size))
+	http.ServeContent(w, r, "", a.UpdatedAt, bytes.NewReader(data))
+}
+
+func (s *Server) readAvatar(key string) ([]byte, error) {
+	rc, err := s.avatars.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// avatarVariant возвращает наименьший размер варианта, не меньший n
+func avatarVariant(n int) int {
+	for _, size := range avatar.Sizes {
+		if size >= n {
+			return size
+		}
+	}
+	return avatar.Sizes[len(avatar.Sizes)-1]
+}
+
+// deleteAvatar удаляет аватар пользователя вместе с вариантами; false - аватара не было
+func (s *Server) deleteAvatar(ctx context.Context, userID string) (bool, error) {
+	if s.avatars == nil {
+		return false, nil
+	}
+	current, err := s.db.GetAvatar(ctx, userID)
+	if err != nil || current == nil {
+		return false, err
+	}
+	if _, err := s.db.DeleteAvatar(ctx, userID); err != nil {
+		return false, err
+	}
+	s.deleteAvatarVariants(userID, current.Version)
+	return true, nil
+}
+
+// deleteAvatarVariants удаляет варианты версии аватара; ошибка только логируется
+func (s *Server) deleteAvatarVariants(userID, version string) {
+	for _, size := range avatar.Sizes {
+		if err := s.avatars.Delete(avatarKey(userID, version, size)); err != nil {
+			log.Printf("Failed to delete avatar of %s: %v", userID, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/storage"
+	"hydra/pkg/transport"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// blackoutCheckInterval - как часто проверяется окончание окон отключения для доставки исходящих
+const blackoutCheckInterval = time.Minute
+
+// loadBlackouts передает политике транспортов окна отключения из конфигурации и базы
+func (s *Server) loadBlackouts() error {
+	blackouts, err := transport.ParseBlackouts(s.config.TransportBlackouts, s.config.TransportBlackoutTimezone)
+	if err != nil {
+		return err
+	}
+	for i := range blackouts {
+		blackouts[i].ID = fmt.Sprintf("config-%d", i+1)
+	}
+
+	stored, err := s.db.ListTransportBlackouts(context.Background())
+	if err != nil {
+		return err
+	}
+	s.policy.SetBlackouts(append(blackouts, stored...))
+	return nil
+}
+
+// messagesBlackedOut сообщает, что сообщения сейчас некуда отправить из-за окон отключения,
+// и возвращает действующие окна
+func (s *Server) messagesBlackedOut() ([]transport.ActiveBlackout, bool) {
+	active := s.policy.ActiveBlackouts(transport.TrafficMessages)
+	if len(active) == 0 {
+		return nil, false
+	}
+	return active, !s.transportManager.AvailableFor(transport.TrafficMessages)
+}
+
+// blackoutEnd возвращает момент, когда закончатся все действующие окна
+func blackoutEnd(active []transport.ActiveBlackout) time.Time {
+	var until time.Time
+	for _, b := range active {
+		if b.Until.After(until) {
+			until = b.Until
+		}
+	}
+	return until
+}
+
+// queueForBlackout откладывает сообщение в исходящие до окончания окна отключения
+func (s *Server) queueForBlackout(w http.ResponseWriter, req *sendRequest, active []transport.ActiveBlackout) {
+	msg := &storage.OutboxMessage{SenderID: req.From, RecipientID: req.To, Body: req.Message}
+	if err := s.db.EnqueueOutbox(context.Background(), msg); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to queue message", "blackout": true})
+		return
+	}
+
+	reasons := make([]string, 0, len(active))
+	for _, b := range active {
+		if b.Reason != "" {
+			reasons = append(reasons, b.Reason)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"queued":   true,
+		"id":       msg.ID,
+		"blackout": true,
+		"until":    blackoutEnd(active),
+		"reason":   strings.Join(reasons, "; "),
+	})
+}
+
+// runBlackoutDelivery доставляет исходящие, отложенные на время окон отключения, когда
+// для сообщений снова появляется разрешенный транспорт
+func (s *Server) runBlackoutDelivery() {
+	ticker := time.NewTicker(blackoutCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if enabled, _ := s.inMaintenance(); enabled {
+			continue
+		}
+		if _, blocked := s.messagesBlackedOut(); blocked {
+			continue
+		}
+		if queued, err := s.db.CountOutbox(context.Background()); err != nil || queued == 0 {
+			continue
+		}
+		s.flushOutbox()
+	}
+}
+
+// handleAdminBlackouts показывает и создает окна отключения транспортов.
+// POST {"transports": ["mesh"], "traffic": "messages", "days": [1,2,3,4,5], "start": "22:00", "end": "06:00",
+// "timezone": "Europe/Moscow", "reason": "curfew"}
+func (s *Server) handleAdminBlackouts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"blackouts": s.policy.Blackouts(),
+			"active":    s.policy.ActiveBlackouts(""),
+		})
+
+	case http.MethodPost:
+		var b transport.Blackout
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		b.ID = ""
+		if err := b.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid blackout: " + err.Error()})
+			return
+		}
+
+		if err := s.db.CreateTransportBlackout(r.Context(), &b); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save blackout"})
+			return
+		}
+		s.policy.SetBlackouts(append(s.policy.Blackouts(), b))
+		log.Printf("Transport blackout %s added: %v %s-%s", b.ID, b.Transports, b.Start, b.End)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "blackout": b})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleAdminBlackout удаляет окно отключения: DELETE /api/admin/blackouts/{id}.
+// Окна из конфигурации удаляются только правкой TRANSPORT_BLACKOUTS.
+func (s *Server) handleAdminBlackout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/blackouts/")
+	if strings.HasPrefix(id, "config-") {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Blackout is set by configuration"})
+		return
+	}
+
+	found, err := s.db.DeleteTransportBlackout(r.Context(), id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete blackout"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Blackout not found"})
+		return
+	}
+
+	var remaining []transport.Blackout
+	for _, b := range s.policy.Blackouts() {
+		if b.ID != id {
+			remaining = append(remaining, b)
+		}
+	}
+	s.policy.SetBlackouts(remaining)
+	log.Printf("Transport blackout %s removed", id)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
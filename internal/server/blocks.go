@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Пользователь может заблокировать другого: сообщения заблокированного отправителя не
+// доставляются (BLOCKED_SENDER_MODE: drop - молча отбрасываются, reject - отклоняются),
+// в группах заблокировавший не получает его сообщений. Жалобы на пользователей попадают
+// к администратору (/api/admin/reports).
+
+// maxReportDetails - наибольшая длина пояснения к жалобе в символах
+const maxReportDetails = 2000
+
+// blockedBy сообщает, заблокировал ли userID отправителя senderID
+func (s *Server) blockedBy(userID, senderID string) bool {
+	if s.db == nil || userID == "" || senderID == "" || userID == senderID {
+		return false
+	}
+	blocked, err := s.db.IsBlocked(context.Background(), userID, senderID)
+	if err != nil {
+		log.Printf("Failed to check block of %s by %s: %v", senderID, userID, err)
+		return false
+	}
+	return blocked
+}
+
+// refuseBlocked отвечает на сообщение, получатель которого заблокировал отправителя. В
+// режиме drop отправитель получает обычный ответ, а сообщение попадает только в его
+// журнал, чтобы он не узнал о блокировке.
+func (s *Server) refuseBlocked(w http.ResponseWriter, req *sendRequest) {
+	if s.config.BlockedSenderMode == storage.BlockedReject {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recipient is unavailable"})
+		return
+	}
+
+	id := "msg-" + ids.New()
+	s.appendEvent(req.From, storage.EventMessageCreated, map[string]interface{}{"id": id, "from": req.From, "to": req.To, "body": req.Message})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"transport":  s.transportManager.GetCurrentTransport().Name(),
+		"message_id": id,
+	})
+}
+
+// handleBlocks обрабатывает /api/blocks: GET - заблокированные пользователи,
+// POST {user_id} - блокировка
+func (s *Server) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		blocks, err := s.db.ListBlocks(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list blocks"})
+			return
+		}
+		if blocks == nil {
+			blocks = []*storage.Block{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "blocks": blocks})
+
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "user_id required"})
+			return
+		}
+		if req.UserID == userID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Cannot block yourself"})
+			return
+		}
+		if _, err := s.db.GetUser(r.Context(), req.UserID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+			return
+		}
+		added, err := s.db.BlockUser(r.Context(), userID, req.UserID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to block user"})
+			return
+		}
+		if added {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "user_id": req.UserID})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleBlock обрабатывает DELETE /api/blocks/{user_id}: снятие блокировки
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	ok, err := s.db.UnblockUser(r.Context(), userID, r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to unblock user"})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User is not blocked"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleReport обрабатывает POST /api/reports {user_id, reason, message_id, details, block}:
+// жалоба на пользователя; при block - сразу и блокировка
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		UserID    string `json:"user_id"`
+		Reason    string `json:"reason"`
+		MessageID string `json:"message_id"`
+		Details   string `json:"details"`
+		Block     bool   `json:"block"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "user_id required"})
+		return
+	}
+	req.Details = strings.TrimSpace(req.Details)
+	switch {
+	case req.UserID == userID:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Cannot report yourself"})
+		return
+	case !storage.KnownReportReason(req.Reason):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown report reason"})
+		return
+	case utf8.RuneCountInString(req.Details) > maxReportDetails:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Report details are too long"})
+		return
+	}
+	if _, err := s.db.GetUser(r.Context(), req.UserID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	report := &storage.AbuseReport{
+		ReporterID: userID,
+		ReportedID: req.UserID,
+		Reason:     req.Reason,
+		MessageID:  req.MessageID,
+		Details:    req.Details,
+	}
+	if err := s.db.CreateAbuseReport(r.Context(), report); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save report"})
+		return
+	}
+	log.Printf("Abuse report %s: %s reported %s (%s)", report.ID, userID, req.UserID, req.Reason)
+	if req.Block {
+		if _, err := s.db.BlockUser(r.Context(), userID, req.UserID); err != nil {
+			log.Printf("Failed to block %s for %s: %v", req.UserID, userID, err)
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "report": report})
+}
+
+// handleAdminReports обрабатывает GET /api/admin/reports[?status=&limit=]: жалобы, новые первыми
+func (s *Server) handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != storage.ReportOpen && status != storage.ReportResolved {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "status must be open or resolved"})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	reports, err := s.db.ListAbuseReports(r.Context(), status, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list reports"})
+		return
+	}
+	if reports == nil {
+		reports = []*storage.AbuseReport{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "reports": reports})
+}
+
+// handleAdminReportResolve обрабатывает POST /api/admin/reports/{id}/resolve {resolution}:
+// закрытие жалобы с записью в журнал аудита
+func (s *Server) handleAdminReportResolve(w http.ResponseWriter, r *http.Request) {
+	reportID := r.PathValue("id")
+	var req struct {
+		Resolution string `json:"resolution"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+	}
+	req.Resolution = strings.TrimSpace(req.Resolution)
+
+	actor, _ := s.adminActor(r)
+	if err := s.audit(actor, "report.resolve", reportID, map[string]string{"resolution": req.Resolution}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+	found, err := s.db.ResolveAbuseReport(r.Context(), reportID, actor, req.Resolution)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to resolve report"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Open report not found"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/internal/config"
+	"hydra/pkg/i18n"
+	"hydra/pkg/mail"
+	"hydra/pkg/ratelimit"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Защита от подбора паролей и кодов подтверждения. Неудачные входы считаются по
+// аккаунту и по IP, неверные коды - по телефону или email; после LOGIN_MAX_FAILURES,
+// LOGIN_IP_MAX_FAILURES или CODE_MAX_ATTEMPTS неудач ключ блокируется на LOGIN_LOCKOUT
+// или CODE_LOCKOUT (429 с Retry-After). Счетчики хранятся в памяти экземпляра.
+
+// bruteForceGuard - счетчики неудачных попыток
+type bruteForceGuard struct {
+	accounts *ratelimit.Lockout // вход по логину
+	ips      *ratelimit.Lockout // вход с IP
+	codes    *ratelimit.Lockout // коды подтверждения на телефон или email
+}
+
+func newBruteForceGuard(cfg *config.Config) *bruteForceGuard {
+	return &bruteForceGuard{
+		accounts: ratelimit.NewLockout(cfg.LoginMaxFailures, cfg.LoginLockout, cfg.LoginLockout),
+		ips:      ratelimit.NewLockout(cfg.LoginIPMaxFailures, cfg.LoginLockout, cfg.LoginLockout),
+		codes:    ratelimit.NewLockout(cfg.CodeMaxAttempts, cfg.CodeLockout, cfg.CodeLockout),
+	}
+}
+
+// writeLocked отвечает 429 на попытку, заблокированную еще на left
+func writeLocked(w http.ResponseWriter, left time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many failed attempts"})
+}
+
+// loginLocked отвечает 429, если вход в аккаунт login или с IP запроса заблокирован
+func (s *Server) loginLocked(w http.ResponseWriter, r *http.Request, login string) bool {
+	left, locked := s.bruteForce.ips.Locked(clientIP(r))
+	if !locked {
+		left, locked = s.bruteForce.accounts.Locked(strings.ToLower(login))
+	}
+	if locked {
+		writeLocked(w, left)
+	}
+	return locked
+}
+
+// loginFailed учитывает неудачный вход в аккаунт login; user - владелец логина, если
+// известен. При блокировке аккаунта владелец получает предупреждение (LOGIN_ALERTS).
+func (s *Server) loginFailed(r *http.Request, login string, user *storage.User) {
+	ip := clientIP(r)
+	if s.bruteForce.ips.Fail(ip) {
+		log.Printf("Sign-in from %s locked after repeated failures", ip)
+	}
+	if !s.bruteForce.accounts.Fail(strings.ToLower(login)) {
+		return
+	}
+	log.Printf("Sign-in to %s locked after repeated failures, last from %s", login, ip)
+	if !s.config.LoginAlerts {
+		return
+	}
+	if user == nil {
+		user = s.userByLogin(r.Context(), login)
+	}
+	if user != nil {
+		go s.sendLoginAlert(user, ip, s.userLocale(r, user.ID))
+	}
+}
+
+// loginSucceeded сбрасывает счетчик неудачных входов в аккаунт
+func (s *Server) loginSucceeded(login string) {
+	s.bruteForce.accounts.Reset(strings.ToLower(login))
+}
+
+// userByLogin возвращает пользователя по email или телефону; nil - не найден
+func (s *Server) userByLogin(ctx context.Context, login string) *storage.User {
+	var user *storage.User
+	var err error
+	if strings.Contains(login, "@") {
+		user, err = s.db.GetUserByEmail(ctx, login)
+	} else {
+		user, err = s.db.GetUserByPhone(ctx, login)
+	}
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// sendLoginAlert сообщает владельцу аккаунта о блокировке входа: письмом, если есть
+// email и настроена отправка писем, иначе SMS
+func (s *Server) sendLoginAlert(user *storage.User, ip, locale string) {
+	minutes := int(s.config.LoginLockout.Minutes())
+	var err error
+	switch {
+	case user.Email != "" && s.mailer != nil:
+		text := i18n.Format(locale, i18n.LoginAlertEmail, ip, minutes)
+		err = s.sendTemplatedEmail(user.Email, mail.SecurityAlert, &mail.Content{
+			Lang:       locale,
+			Subject:    i18n.Format(locale, i18n.LoginAlertSubject),
+			Text:       text,
+			Paragraphs: mail.Paragraphs(text),
+		})
+	case user.Phone != "":
+		err = s.sendSMS(user.Phone, i18n.Format(locale, i18n.LoginAlertSMS, ip, minutes))
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to send sign-in alert to %s: %v", user.ID, err)
+	}
+}
+
+// codeLocked отвечает 429, если проверка кодов на телефон или email заблокирована
+func (s *Server) codeLocked(w http.ResponseWriter, contact string) bool {
+	left, locked := s.bruteForce.codes.Locked(strings.ToLower(contact))
+	if locked {
+		writeLocked(w, left)
+	}
+	return locked
+}
+
+// codeChecked учитывает результат проверки кода на телефон или email
+func (s *Server) codeChecked(contact string, valid bool) {
+	key := strings.ToLower(contact)
+	if valid {
+		s.bruteForce.codes.Reset(key)
+		return
+	}
+	if s.bruteForce.codes.Fail(key) {
+		log.Printf("Verification codes for %s locked after repeated failures", contact)
+	}
+}
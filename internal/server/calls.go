@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/capability"
+	"hydra/pkg/folders"
+	"hydra/pkg/storage"
+	"hydra/pkg/transport"
+	"hydra/pkg/webrtc"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Режимы связи с собеседником с учетом политики транспортов
+const (
+	callModeCall         = "call"
+	callModeVoiceMessage = "voice_message" // звонок невозможен - обмен голосовыми сообщениями
+)
+
+// callControlRequest - общий формат запросов управления звонком
+type callControlRequest struct {
+	CallID        string `json:"call_id"`
+	ConsultCallID string `json:"consult_call_id,omitempty"` // для перевода: звонок с целью перевода
+	RoomID        string `json:"room_id,omitempty"`
+	To            string `json:"to,omitempty"`    // для start: ID вызываемого пользователя
+	Media         string `json:"media,omitempty"` // для start: audio (по умолчанию) или video
+}
+
+// handleCallControl обрабатывает hold/resume/transfer/upgrade/join.
+// Операции, меняющие состав медиа, возвращают новые SDP предложения для каждого затронутого звонка;
+// клиенты отвечают на них через /api/call/answer.
+func (s *Server) handleCallControl(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+			return
+		}
+
+		var req callControlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CallID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "call_id required"})
+			return
+		}
+
+		response := map[string]interface{}{"success": true, "call_id": req.CallID}
+		var err error
+
+		switch action {
+		case "hold":
+			err = s.callManager.Hold(req.CallID)
+		case "resume":
+			err = s.callManager.Resume(req.CallID)
+		case "transfer":
+			if req.ConsultCallID == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "consult_call_id required"})
+				return
+			}
+			offers, transferErr := s.callManager.Transfer(req.CallID, req.ConsultCallID)
+			err = transferErr
+			response["offers"] = offers
+		case "upgrade":
+			roomID, offers, upgradeErr := s.callManager.UpgradeToRoom(req.CallID)
+			err = upgradeErr
+			response["room_id"] = roomID
+			response["offers"] = offers
+		case "join":
+			offers, joinErr := s.callManager.JoinRoom(req.RoomID, req.CallID)
+			err = joinErr
+			response["room_id"] = req.RoomID
+			response["offers"] = offers
+			// Событие входа пишется в журнал только вошедшему по токену пользователю
+			if caller, err := s.bearerUser(r); joinErr == nil && err == nil {
+				s.appendEvent(caller, storage.EventMembership, map[string]string{"room_id": req.RoomID, "call_id": req.CallID, "change": "joined"})
+			}
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+
+		if state, roomID, err := s.callManager.GetCallState(req.CallID); err == nil {
+			response["state"] = state
+			if roomID != "" {
+				response["room_id"] = roomID
+				response["participants"], _ = s.callManager.GetRoomParticipants(roomID)
+			}
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// callPath определяет, можно ли сейчас звонить с учетом политики транспортов.
+// Если разрешенного пути нет, возвращается режим голосовых сообщений и причина.
+func (s *Server) callPath() (mode, reason string) {
+	if err := s.callManager.CallPathAvailable(); err != nil {
+		return callModeVoiceMessage, "Calls are not allowed over the available media paths: " + err.Error()
+	}
+	if !s.transportManager.AvailableFor(transport.TrafficCalls) {
+		if active := s.policy.ActiveBlackouts(transport.TrafficCalls); len(active) > 0 {
+			return callModeVoiceMessage, "Call transports are blacked out until " + blackoutEnd(active).Format(time.RFC3339)
+		}
+		return callModeVoiceMessage, "No approved transport is available for call signaling"
+	}
+	return callModeCall, ""
+}
+
+// writeVoiceMessageFallback сообщает клиенту, что звонок заменен обменом голосовыми сообщениями
+func writeVoiceMessageFallback(w http.ResponseWriter, reason string) {
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   false,
+		"mode":      callModeVoiceMessage,
+		"error":     reason,
+		"voice_url": "/api/voice/send",
+	})
+}
+
+// handleCallPath сообщает клиенту доступный режим связи: звонок или голосовые сообщения
+func (s *Server) handleCallPath(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	mode, reason := s.callPath()
+	response := map[string]interface{}{"success": true, "mode": mode}
+	if reason != "" {
+		response["reason"] = reason
+		response["voice_url"] = "/api/voice/send"
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCallStart создает предложение для нового звонка. Если политика транспортов
+// не оставляет разрешенного пути, клиенту предлагается перейти на голосовые сообщения.
+func (s *Server) handleCallStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	var req callControlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CallID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "call_id required"})
+		return
+	}
+
+	if mode, reason := s.callPath(); mode != callModeCall {
+		writeVoiceMessageFallback(w, reason)
+		return
+	}
+
+	offer, err := s.callManager.CreateOffer(r.Context(), req.CallID)
+	if err != nil {
+		if errors.Is(err, webrtc.ErrNoCallPath) {
+			writeVoiceMessageFallback(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	// Пользователь звонка нужен, чтобы управлять записью и получать ее файлы
+	if caller, err := s.bearerUser(r); err == nil {
+		s.callManager.SetCallUser(req.CallID, caller)
+	}
+
+	response := map[string]interface{}{"success": true, "mode": callModeCall, "call_id": req.CallID, "offer": offer}
+
+	// Предложение звонка получают только устройства, способные принять этот вид медиа.
+	// Звонок попадает в дайджест получателя, если тот давно не заходил.
+	if req.To != "" && s.db != nil {
+		s.recordNotification(req.To, storage.NotificationCall, req.CallID)
+		media := req.Media
+		if media == "" {
+			media = capability.MediaAudio
+		}
+		ringDevices := []string{}
+		for _, device := range s.devicesSupporting(req.To, media, 0) {
+			ringDevices = append(ringDevices, device.ID)
+		}
+		response["ring_devices"] = ringDevices
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCallChat: GET - сообщения чата звонка, POST - отправка сообщения/реакции в звонок.
+// Нужен токен пользователя звонка; он же отправитель сообщения. Привязать чат можно
+// только к беседе, в которой пользователь участвует.
+func (s *Server) handleCallChat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	caller, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	callOwner := func(callID string) bool {
+		if owner, err := s.callManager.CallUser(callID); err != nil || owner != caller {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Call does not belong to the caller"})
+			return false
+		}
+		return true
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		callID := r.URL.Query().Get("call_id")
+		if !callOwner(callID) {
+			return
+		}
+		chatLog, err := s.callManager.GetChatLog(callID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "messages": chatLog})
+
+	case http.MethodPost:
+		var req struct {
+			CallID         string `json:"call_id"`
+			ConversationID string `json:"conversation_id"`
+			webrtc.ChatMessage
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if !callOwner(req.CallID) {
+			return
+		}
+		req.SenderID = caller
+
+		if req.ConversationID != "" {
+			member, err := s.conversationMember(r.Context(), req.ConversationID, caller)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load conversation"})
+				return
+			}
+			if !member {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Conversation not found"})
+				return
+			}
+			if err := s.callManager.SetConversation(req.CallID, req.ConversationID); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+		}
+
+		msg, err := s.callManager.SendChat(req.CallID, req.ChatMessage)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": msg})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleCallAudio: GET - действующие настройки аудио звонка (без call_id - по умолчанию),
+// POST - настройки для звонка. Неуказанные поля сохраняют текущие значения.
+func (s *Server) handleCallAudio(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		callID := r.URL.Query().Get("call_id")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "call_id": callID, "audio": s.callManager.GetAudioConfig(callID)})
+
+	case http.MethodPost:
+		var raw json.RawMessage
+		var req struct {
+			CallID string `json:"call_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil || json.Unmarshal(raw, &req) != nil || req.CallID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "call_id required"})
+			return
+		}
+
+		audio := s.callManager.GetAudioConfig(req.CallID)
+		if err := json.Unmarshal(raw, &audio); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid audio settings"})
+			return
+		}
+		if err := s.callManager.SetCallAudioConfig(req.CallID, audio); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "call_id": req.CallID, "audio": audio})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// saveCallChat сохраняет чат завершенного звонка в беседу
+func (s *Server) saveCallChat(session *webrtc.CallSession) {
+	if len(session.ChatLog) == 0 {
+		return
+	}
+
+	conversationID := session.ConversationID
+	if conversationID == "" {
+		conversationID = "call-" + session.ID
+	}
+
+	// При политике reject сообщения сверх квоты группы не сохраняются
+	chatLog := session.ChatLog
+	if headroom := s.messageHeadroom(conversationID); headroom >= 0 && int64(len(chatLog)) > headroom {
+		log.Printf("Conversation %s is over its message quota, %d chat messages of call %s dropped", conversationID, int64(len(chatLog))-headroom, session.ID)
+		chatLog = chatLog[:headroom]
+	}
+
+	for _, chat := range chatLog {
+		msg := &storage.Message{
+			ConversationID: conversationID,
+			SenderID:       chat.SenderID,
+			Type:           chat.Type,
+			Body:           chat.Body,
+			ReplyTo:        chat.ReplyTo,
+			CreatedAt:      chat.SentAt,
+		}
+		if err := s.db.CreateMessage(context.Background(), msg); err != nil {
+			log.Printf("Failed to save chat of call %s: %v", session.ID, err)
+			return
+		}
+	}
+	log.Printf("Saved %d chat messages of call %s to conversation %s", len(chatLog), session.ID, conversationID)
+	s.enforceQuota(conversationID)
+
+	// Беседа раскладывается по папкам участников чата по сообщениям других участников
+	participants := make(map[string]bool)
+	for _, chat := range chatLog {
+		participants[chat.SenderID] = true
+	}
+	for participant := range participants {
+		var received []folders.Message
+		for _, chat := range chatLog {
+			if chat.SenderID != participant {
+				received = append(received, folders.Message{ConversationID: conversationID, SenderID: chat.SenderID, Body: chat.Body})
+			}
+		}
+		s.fileMessages(participant, received...)
+	}
+}
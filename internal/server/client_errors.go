@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Отчеты клиентов об ошибках: клиент отправляет их только с согласия пользователя, сервер
+// дополнительно вычищает из текста персональные данные и секреты, обрезает поля и сохраняет
+// выборку (падения - всегда). Помогают разбирать сбои транспортов у пользователей.
+
+// Ограничения размера полей отчета
+const (
+	maxClientErrorMessage = 1000
+	maxClientErrorStack   = 8000
+	maxClientErrorField   = 100
+	maxClientErrorContext = 20 // полей context
+	maxClientErrorBody    = 32 << 10
+)
+
+// Виды отчетов
+var clientErrorKinds = map[string]bool{"crash": true, "error": true, "transport": true}
+
+// scrubPatterns заменяют в тексте отчета то, что может идентифицировать пользователя или
+// дать доступ к аккаунту. Порядок важен: сначала URL параметры и токены, затем адреса и номера.
+var scrubPatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)bearer\s+\S+`), "Bearer <redacted>"},
+	{regexp.MustCompile(`\?[^\s"'<>]+`), "?<redacted>"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "<email>"},
+	{regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`), "<redacted>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`), "<ip>"},
+	{regexp.MustCompile(`\+\d[\d\s()-]{7,}\d|\b\d{10,15}\b`), "<phone>"},
+}
+
+// sensitiveContextKeys - поля context, которые отбрасываются целиком
+var sensitiveContextKeys = []string{"user", "email", "phone", "name", "token", "password", "secret", "key", "contact", "address"}
+
+// scrubClientText удаляет из текста персональные данные и обрезает его до limit байт
+func scrubClientText(text string, limit int) string {
+	for _, p := range scrubPatterns {
+		text = p.re.ReplaceAllString(text, p.replacement)
+	}
+	if len(text) > limit {
+		text = strings.ToValidUTF8(text[:limit], "")
+	}
+	return text
+}
+
+// scrubClientContext очищает дополнительные поля отчета и возвращает их в JSON
+func scrubClientContext(fields map[string]string) string {
+	clean := make(map[string]string)
+	for key, value := range fields {
+		if len(clean) >= maxClientErrorContext {
+			break
+		}
+		lower := strings.ToLower(key)
+		sensitive := false
+		for _, s := range sensitiveContextKeys {
+			if strings.Contains(lower, s) {
+				sensitive = true
+				break
+			}
+		}
+		if !sensitive {
+			clean[scrubClientText(key, maxClientErrorField)] = scrubClientText(value, maxClientErrorField)
+		}
+	}
+	if len(clean) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(clean)
+	return string(data)
+}
+
+// sampleClientError решает, сохранять ли отчет: падения сохраняются всегда, остальное - с
+// вероятностью CLIENT_ERROR_SAMPLE_PERCENT
+func (s *Server) sampleClientError(kind string) bool {
+	if kind == "crash" {
+		return true
+	}
+	return rand.IntN(100) < s.config.ClientErrorSamplePercent
+}
+
+// handleClientErrors принимает отчет клиента об ошибке.
+// POST {"consent": true, "kind": "transport", "message": "...", "stack": "...", "transport": "mesh",
+// "client_version": "1.4.0", "platform": "android", "context": {"front": "..."}}
+func (s *Server) handleClientErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	if !s.errorLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many reports"})
+		return
+	}
+
+	var req struct {
+		Consent       bool              `json:"consent"`
+		Kind          string            `json:"kind"`
+		Message       string            `json:"message"`
+		Stack         string            `json:"stack"`
+		Transport     string            `json:"transport"`
+		ClientVersion string            `json:"client_version"`
+		Platform      string            `json:"platform"`
+		Context       map[string]string `json:"context"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxClientErrorBody)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if !req.Consent {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Error reporting requires user consent"})
+		return
+	}
+	if !clientErrorKinds[req.Kind] || req.Message == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "kind (crash, error, transport) and message are required"})
+		return
+	}
+
+	if !s.sampleClientError(req.Kind) {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "stored": false})
+		return
+	}
+
+	report := &storage.ClientError{
+		Kind:          req.Kind,
+		Message:       scrubClientText(req.Message, maxClientErrorMessage),
+		Stack:         scrubClientText(req.Stack, maxClientErrorStack),
+		Transport:     scrubClientText(req.Transport, maxClientErrorField),
+		ClientVersion: scrubClientText(req.ClientVersion, maxClientErrorField),
+		Platform:      scrubClientText(req.Platform, maxClientErrorField),
+		Context:       scrubClientContext(req.Context),
+	}
+	if err := s.db.CreateClientError(r.Context(), report); err != nil {
+		log.Printf("Failed to store client error report: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to store report"})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "stored": true, "id": report.ID})
+}
+
+// handleAdminClientErrors показывает последние отчеты клиентов и самые частые ошибки за сутки.
+// GET /api/admin/client-errors?kind=transport&limit=100
+func (s *Server) handleAdminClientErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	reports, err := s.db.ListClientErrors(r.Context(), r.URL.Query().Get("kind"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load reports"})
+		return
+	}
+	stats, err := s.db.GetClientErrorStats(r.Context(), time.Now().Add(-24*time.Hour), 20)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load report stats"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "reports": reports, "top": stats})
+}
+
+// runClientErrorRetention удаляет отчеты клиентов старше CLIENT_ERROR_RETENTION
+func (s *Server) runClientErrorRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := s.db.DeleteClientErrorsBefore(context.Background(), time.Now().Add(-s.config.ClientErrorRetention)); err != nil {
+			log.Printf("Client error reports cleanup failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
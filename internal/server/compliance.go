@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hydra/pkg/compliance"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditActorAdmin - автор записей аудита, сделанных с общим токеном администратора
+const auditActorAdmin = "admin"
+
+// errAccountDisabled - токен принадлежит аккаунту, заблокированному администратором
+var errAccountDisabled = errors.New("account disabled")
+
+// audit записывает действие в журнал аудита. Ошибка возвращается вызывающему: действия,
+// которые должны быть записаны, без записи не выполняются.
+func (s *Server) audit(actor, action, target string, details interface{}) error {
+	entry, err := s.db.AppendAudit(context.Background(), actor, action, target, details)
+	if err != nil {
+		log.Printf("Failed to record audit entry %s by %s: %v", action, actor, err)
+		return err
+	}
+	log.Printf("Audit #%d: %s %s %s", entry.ID, actor, action, target)
+	return nil
+}
+
+// isAdminToken сообщает, предъявлен ли токен администратора, не отвечая клиенту
+func (s *Server) isAdminToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// adminActor определяет администратора запроса: общий токен ADMIN_TOKEN (автор записей
+// аудита - auditActorAdmin) или токен входа пользователя с ролью admin (автор - он сам)
+func (s *Server) adminActor(r *http.Request) (string, bool) {
+	if s.isAdminToken(r) {
+		return auditActorAdmin, true
+	}
+	if s.db == nil {
+		return "", false
+	}
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		return "", false
+	}
+	ok, err := s.db.HasRole(r.Context(), userID, storage.RoleAdmin)
+	if err != nil {
+		log.Printf("Failed to check admin role of %s: %v", userID, err)
+		return "", false
+	}
+	return userID, ok
+}
+
+// requireRole проверяет токен входа пользователя и наличие у него роли. Отказ пользователю
+// без роли записывается в журнал аудита.
+func (s *Server) requireRole(w http.ResponseWriter, r *http.Request, role string) (string, bool) {
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return "", false
+	}
+	ok, err := s.db.HasRole(r.Context(), userID, role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to check role"})
+		return "", false
+	}
+	if !ok {
+		s.audit(userID, "access.denied", r.URL.Path, map[string]string{"role": role})
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Role " + role + " required"})
+		return "", false
+	}
+	return userID, true
+}
+
+// handleAdminRoles обрабатывает GET /api/admin/roles (выданные роли) и
+// POST /api/admin/roles {user_id, role} (выдача роли)
+func (s *Server) handleAdminRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	actor, ok := s.requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := s.db.ListRoles(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list roles"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "roles": grants})
+
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+			Role   string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "user_id and role are required"})
+			return
+		}
+		if !storage.KnownRole(req.Role) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown role"})
+			return
+		}
+		if _, err := s.db.GetUser(r.Context(), req.UserID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+			return
+		}
+		if err := s.audit(actor, "role.grant", req.UserID, map[string]string{"role": req.Role}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+			return
+		}
+		if _, err := s.db.GrantRole(r.Context(), req.UserID, req.Role, actor); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to grant role"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleAdminRole обрабатывает DELETE /api/admin/roles/{user_id}/{role} (отзыв роли)
+func (s *Server) handleAdminRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	actor, ok := s.requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	userID, role, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/roles/"), "/")
+	if !ok || userID == "" || !storage.KnownRole(role) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Expected /api/admin/roles/{user_id}/{role}"})
+		return
+	}
+	if err := s.audit(actor, "role.revoke", userID, map[string]string{"role": role}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+	found, err := s.db.RevokeRole(r.Context(), userID, role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke role"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Role not granted"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleAdminAudit обрабатывает GET /api/admin/audit?after=ID&limit=N: записи журнала
+// аудита и результат проверки их цепочки. Доступен администратору и аудиторам.
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	actor, admin := s.adminActor(r)
+	if !admin {
+		userID, ok := s.requireRole(w, r, storage.RoleAuditor)
+		if !ok {
+			return
+		}
+		actor = userID
+	}
+
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	entries, err := s.db.ListAudit(r.Context(), after, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load audit log"})
+		return
+	}
+	chainErr := storage.VerifyAuditChain(entries)
+	if chainErr != nil {
+		log.Printf("Audit chain verification failed: %v", chainErr)
+	}
+	if actor != auditActorAdmin {
+		s.audit(actor, "audit.read", "", map[string]interface{}{"after": after, "limit": limit})
+	}
+
+	resp := map[string]interface{}{"success": true, "entries": entries, "chain_valid": chainErr == nil}
+	if chainErr != nil {
+		resp["chain_error"] = chainErr.Error()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleComplianceExport обрабатывает POST /api/compliance/export {user_ids, reason}:
+// согласованный снимок метаданных аккаунтов с подписанным манифестом. Только для
+// сотрудников с ролью compliance_officer; выгрузка не выдается, если ее не удалось
+// записать в журнал аудита.
+func (s *Server) handleComplianceExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	officer, ok := s.requireRole(w, r, storage.RoleComplianceOfficer)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserIDs []string `json:"user_ids"`
+		Reason  string   `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if err := compliance.ValidateRequest(req.UserIDs, req.Reason); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	snapshotAt, sections, err := s.db.ComplianceSnapshot(ctx, req.UserIDs)
+	if err != nil {
+		log.Printf("Compliance export by %s failed: %v", officer, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to take snapshot"})
+		return
+	}
+	id := "export-" + ids.New()
+	export, err := compliance.Build(s.timeSigner, id, officer, req.Reason, req.UserIDs, snapshotAt, sections)
+	if err != nil {
+		log.Printf("Compliance export by %s rejected: %v", officer, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to build export"})
+		return
+	}
+
+	// Хеш манифеста в журнале аудита связывает выданный файл с запросом
+	details := map[string]interface{}{
+		"export_id":       export.Manifest.ID,
+		"user_ids":        export.Manifest.UserIDs,
+		"reason":          export.Manifest.Reason,
+		"snapshot_at":     export.Manifest.SnapshotAt,
+		"manifest_sha256": export.Manifest.SHA256,
+	}
+	if err := s.audit(officer, "compliance.export", export.Manifest.ID, details); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to record audit entry"})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Manifest.ID+".json"))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "export": export})
+}
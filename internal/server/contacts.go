@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"hydra/pkg/lookup"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+// newContactSecret возвращает секрет соли хешей контактов; без CONTACT_SYNC_SECRET -
+// случайный, и соль меняется с каждым запуском сервера
+func newContactSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// contactSalt возвращает соль хешей контактов текущего периода
+func (s *Server) contactSalt() *lookup.ContactSalt {
+	period := s.config.ContactSyncSaltPeriod
+	if period <= 0 {
+		period = 24 * time.Hour
+	}
+	return lookup.NewContactSalt(s.contactSecret, period, time.Now())
+}
+
+// contactMatch - пользователь, найденный по хешу контакта из адресной книги
+type contactMatch struct {
+	Hash string `json:"hash"`
+	*storage.DirectoryEntry
+}
+
+// handleContactSync обрабатывает /api/contacts/sync: GET - соль текущего периода,
+// POST {epoch, hashes} - сверка адресной книги. Клиент хеширует телефоны (E.164) и
+// email солью (см. lookup.HashContact) и получает только совпавшие хеши: находятся
+// пользователи, разрешившие поиск по этому контакту. Принимается соль текущего и
+// предыдущего периода; на более старую - 409 с текущей солью.
+func (s *Server) handleContactSync(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	salt := s.contactSalt()
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "salt": salt, "max_hashes": s.config.ContactSyncMaxHashes})
+
+	case http.MethodPost:
+		var req struct {
+			Epoch  int64    `json:"epoch"`
+			Hashes []string `json:"hashes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if max := s.config.ContactSyncMaxHashes; max > 0 && len(req.Hashes) > max {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many contact hashes", "max_hashes": max})
+			return
+		}
+		switch req.Epoch {
+		case 0, salt.Epoch:
+		case salt.Epoch - 1:
+			salt = salt.PreviousSalt()
+		default:
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Contact salt expired", "salt": salt})
+			return
+		}
+		if !s.syncLimiter.Allow(userID) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many contact syncs"})
+			return
+		}
+
+		contacts, err := s.db.ListDiscoverableContacts(r.Context())
+		if err != nil {
+			log.Printf("Failed to list discoverable contacts: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Search failed"})
+			return
+		}
+		wanted := make(map[string]bool, len(req.Hashes))
+		for _, hash := range req.Hashes {
+			wanted[hash] = true
+		}
+
+		// Себя и заблокировавших пользователя не показываем
+		matches := []contactMatch{}
+		for _, c := range contacts {
+			hash := salt.Hash(c.Contact)
+			if !wanted[hash] || c.UserID == userID || s.blockedBy(c.UserID, userID) {
+				continue
+			}
+			delete(wanted, hash)
+			entry := c.DirectoryEntry
+			matches = append(matches, contactMatch{Hash: hash, DirectoryEntry: &entry})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "epoch": salt.Epoch, "matches": matches})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/capability"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// deviceView - устройство в списке устройств аккаунта
+type deviceView struct {
+	*storage.Device
+	Online  bool `json:"online"`            // открыт WebSocket журнала событий
+	Current bool `json:"current,omitempty"` // устройство, с которого пришел запрос (X-Device-ID)
+}
+
+// handleUserDevices обрабатывает /api/users/{id}/devices[/{device_id}]:
+// GET - устройства и их возможности, POST {name, platform, capabilities} - регистрация
+// нового устройства с сессией входа на нем, PUT - объявление возможностей устройства,
+// DELETE - отключение устройства: его сессии завершаются, ключи и подписка push удаляются.
+// Доступно только самому пользователю (токен входа в заголовке Authorization).
+func (s *Server) handleUserDevices(w http.ResponseWriter, r *http.Request, userID, deviceID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if deviceID != "" {
+			device, err := s.db.GetDevice(r.Context(), deviceID)
+			if err != nil || device.UserID != userID {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device not found"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "device": device})
+			return
+		}
+
+		devices, err := s.db.ListDevices(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list devices"})
+			return
+		}
+		online, _ := s.live.devices(userID)
+		current := r.Header.Get("X-Device-ID")
+		views := make([]deviceView, len(devices))
+		for i, device := range devices {
+			views[i] = deviceView{Device: device, Online: online[device.ID], Current: device.ID == current}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "devices": views})
+
+	case http.MethodPost:
+		if deviceID != "" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+			return
+		}
+
+		var device storage.Device
+		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		device.ID = "device-" + ids.New()
+		device.UserID = userID
+
+		if err := s.db.UpsertDevice(r.Context(), &device); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to register device"})
+			return
+		}
+		// Новое устройство получает собственную сессию; отзыв устройства завершает только ее
+		ttl, refreshTTL := s.sessionTTLs()
+		sess, err := s.db.CreateSession(r.Context(), userID, device.ID, sessionClient(r), ttl, refreshTTL)
+		if err != nil {
+			log.Printf("Failed to create session for device %s: %v", device.ID, err)
+		}
+		s.touchUser(userID)
+		s.appendEvent(userID, storage.EventDevices, map[string]interface{}{"change": "registered", "device": device})
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "device": device, "session": sess})
+
+	case http.MethodPut:
+		if deviceID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device ID required"})
+			return
+		}
+
+		var device storage.Device
+		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		device.ID = deviceID
+		device.UserID = userID
+
+		if err := s.db.UpsertDevice(r.Context(), &device); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		s.touchUser(userID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "device": device})
+
+	case http.MethodDelete:
+		if deviceID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device ID required"})
+			return
+		}
+		if err := s.db.DeleteDevice(r.Context(), userID, deviceID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete device"})
+			return
+		}
+		if err := s.db.RevokeDeviceSessions(r.Context(), userID, deviceID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke session"})
+			return
+		}
+		// Собеседники не должны шифровать для удаленного устройства
+		if err := s.db.DeleteKeys(r.Context(), userID, deviceID); err != nil {
+			log.Printf("Failed to delete keys of device %s: %v", deviceID, err)
+		}
+		if _, err := s.db.DeletePushSubscription(r.Context(), userID, deviceID); err != nil {
+			log.Printf("Failed to delete push subscription of device %s: %v", deviceID, err)
+		}
+		s.appendEvent(userID, storage.EventDevices, map[string]interface{}{"change": "revoked", "device_id": deviceID})
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleCapabilitiesNegotiate возвращает общие возможности устройства device_id с каждым
+// устройством пользователя user_id (собеседника или своего аккаунта). Нужен токен входа
+// владельца device_id; устройства деактивированного собеседника не выдаются.
+func (s *Server) handleCapabilitiesNegotiate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	caller, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	self, err := s.db.GetDevice(r.Context(), r.URL.Query().Get("device_id"))
+	if err != nil || self.UserID != caller {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device not found"})
+		return
+	}
+
+	negotiated := make(map[string]capability.Capabilities)
+	userID := r.URL.Query().Get("user_id")
+	if userID != caller && s.accountState(userID) != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "device_id": self.ID, "negotiated": negotiated})
+		return
+	}
+	devices, err := s.db.ListDevices(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list devices"})
+		return
+	}
+
+	for _, device := range devices {
+		if device.ID == self.ID {
+			continue
+		}
+		negotiated[device.ID] = capability.Negotiate(self.Capabilities, device.Capabilities)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "device_id": self.ID, "negotiated": negotiated})
+}
+
+// devicesSupporting возвращает устройства пользователя, способные принять медиа данного вида
+// и размера (size 0 - не проверяется). Устройства, не объявившие кодеки, считаются совместимыми.
+// У деактивированного пользователя подходящих устройств нет.
+func (s *Server) devicesSupporting(userID, media string, size int64) []*storage.Device {
+	// Деактивированному пользователю не звонят
+	if s.accountState(userID) != nil {
+		return nil
+	}
+
+	devices, err := s.db.ListDevices(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to list devices of %s: %v", userID, err)
+		return nil
+	}
+
+	var result []*storage.Device
+	for _, device := range devices {
+		caps := device.Capabilities
+		if len(caps.Codecs) > 0 && !caps.Supports(media) {
+			continue
+		}
+		if size > 0 && !caps.AcceptsSize(size) {
+			continue
+		}
+		result = append(result, device)
+	}
+	return result
+}
+
+// splitDevicesPath разбирает "{id}/devices[/{device_id}]"
+func splitDevicesPath(path string) (userID, deviceID string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[1] != "devices" {
+		return "", "", false
+	}
+	if len(parts) > 2 {
+		deviceID = parts[2]
+	}
+	return parts[0], deviceID, true
+}
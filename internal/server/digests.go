@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digestCheckInterval = 15 * time.Minute
+	mailQueueInterval   = 30 * time.Second
+	mailRetryDelay      = 5 * time.Minute
+	mailRetention       = 7 * 24 * time.Hour
+
+	// notificationRetention - события старше этого в дайджест уже не попадут
+	notificationRetention = 30 * 24 * time.Hour
+)
+
+// handleUserDigest обрабатывает /api/users/{id}/digest: GET - настройки дайджеста,
+// PUT - {enabled, interval (Go duration), privacy}. Нужен токен входа самого пользователя.
+func (s *Server) handleUserDigest(w http.ResponseWriter, r *http.Request, userID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	settings, err := s.db.GetDigestSettings(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load digest settings"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "digest": digestSettingsResponse(settings)})
+
+	case http.MethodPut:
+		var req struct {
+			Enabled  *bool  `json:"enabled"`
+			Interval string `json:"interval"`
+			Privacy  *bool  `json:"privacy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+
+		if req.Enabled != nil {
+			settings.Enabled = *req.Enabled
+		}
+		if req.Privacy != nil {
+			settings.Privacy = *req.Privacy
+		}
+		if req.Interval != "" {
+			interval, err := time.ParseDuration(req.Interval)
+			if err != nil || interval < time.Hour {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "interval must be a duration of at least 1h"})
+				return
+			}
+			settings.Interval = interval
+		}
+
+		if err := s.db.UpdateDigestSettings(r.Context(), settings); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update digest settings"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "digest": digestSettingsResponse(settings)})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+func digestSettingsResponse(settings *storage.DigestSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":        settings.Enabled,
+		"interval":       settings.Interval.String(),
+		"privacy":        settings.Privacy,
+		"last_digest_at": settings.LastDigestAt,
+	}
+}
+
+// handleDigestMute - ссылка отписки в один клик из письма: GET /api/digest/mute?token=...
+func (s *Server) handleDigestMute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := s.db.MuteDigests(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "Invalid or expired link", http.StatusNotFound)
+		return
+	}
+	log.Printf("Digest emails muted for %s", userID)
+	fmt.Fprintln(w, "Digest emails are turned off. You can turn them back on in Hydra settings.")
+}
+
+// recordNotification сохраняет событие для дайджеста получателя и отправляет push его
+// устройствам, если он не подключен
+func (s *Server) recordNotification(userID, kind, conversationID string) {
+	if s.db == nil || userID == "" {
+		return
+	}
+	if err := s.db.RecordNotification(context.Background(), userID, kind, conversationID); err != nil {
+		log.Printf("Failed to record notification for %s: %v", userID, err)
+	}
+	s.notifyPush(userID, kind, conversationID)
+}
+
+// touchUser отмечает активность пользователя (события до этого момента не попадут в дайджест)
+func (s *Server) touchUser(userID string) {
+	if s.db == nil || userID == "" {
+		return
+	}
+	if err := s.db.TouchUser(context.Background(), userID); err != nil {
+		log.Printf("Failed to update last seen of %s: %v", userID, err)
+	}
+}
+
+// runDigests периодически ставит в почтовую очередь дайджесты пользователям,
+// которые давно не заходили
+func (s *Server) runDigests() {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		if enabled, _ := s.inMaintenance(); enabled {
+			continue
+		}
+		now := time.Now()
+
+		summaries, err := s.db.ListDigestSummaries(context.Background(), now.Add(-s.config.DigestOfflineAfter), now)
+		if err != nil {
+			log.Printf("Digest check failed: %v", err)
+			continue
+		}
+		for _, summary := range summaries {
+			subject, body := s.composeDigest(summary)
+			if err := s.db.EnqueueMail(context.Background(), summary.Email, subject, body); err != nil {
+				log.Printf("Failed to enqueue digest for %s: %v", summary.Settings.UserID, err)
+				continue
+			}
+			if err := s.db.MarkDigestSent(context.Background(), summary.Settings.UserID, now); err != nil {
+				log.Printf("Failed to mark digest for %s: %v", summary.Settings.UserID, err)
+			}
+		}
+
+		if err := s.db.DeleteNotificationsBefore(context.Background(), now.Add(-notificationRetention)); err != nil {
+			log.Printf("Notification cleanup failed: %v", err)
+		}
+	}
+}
+
+// composeDigest формирует письмо. В приватном режиме письмо содержит только счетчики;
+// содержимое сообщений не попадает в письмо ни в каком режиме.
+func (s *Server) composeDigest(summary *storage.DigestSummary) (subject, body string) {
+	var parts []string
+	if summary.Conversations > 0 {
+		parts = append(parts, plural(summary.Conversations, "new conversation", "new conversations"))
+	}
+	if summary.MissedCalls > 0 {
+		parts = append(parts, plural(summary.MissedCalls, "missed call", "missed calls"))
+	}
+	overview := strings.Join(parts, ", ")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "While you were away: %s.\r\n", overview)
+	if !summary.Settings.Privacy && len(summary.Senders) > 0 {
+		fmt.Fprintf(&b, "\r\nMessages from: %s\r\n", strings.Join(summary.Senders, ", "))
+	}
+	fmt.Fprintf(&b, "\r\nOpen Hydra to read them: %s\r\n", s.config.PublicURL)
+	fmt.Fprintf(&b, "\r\nTo stop these emails: %s/api/digest/mute?token=%s\r\n",
+		strings.TrimRight(s.config.PublicURL, "/"), url.QueryEscape(summary.Settings.MuteToken))
+
+	return "Hydra: " + overview, b.String()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// runMailQueue отправляет письма из очереди, повторяя неудачные попытки с задержкой
+func (s *Server) runMailQueue() {
+	ticker := time.NewTicker(mailQueueInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		if enabled, _ := s.inMaintenance(); enabled {
+			continue
+		}
+		now := time.Now()
+
+		mails, err := s.db.ListPendingMail(context.Background(), now, 50)
+		if err != nil {
+			log.Printf("Mail queue check failed: %v", err)
+			continue
+		}
+		for _, mail := range mails {
+			if err := s.sendEmail(mail.Recipient, mail.Subject, mail.Body); err != nil {
+				log.Printf("Failed to send queued mail %d: %v", mail.ID, err)
+				retryAt := now.Add(mailRetryDelay * time.Duration(mail.Attempts+1))
+				if err := s.db.MarkMailFailed(context.Background(), mail.ID, err, retryAt); err != nil {
+					log.Printf("Failed to update queued mail %d: %v", mail.ID, err)
+				}
+				continue
+			}
+			if err := s.db.MarkMailSent(context.Background(), mail.ID); err != nil {
+				log.Printf("Failed to update queued mail %d: %v", mail.ID, err)
+			}
+		}
+
+		if err := s.db.DeleteSentMailBefore(context.Background(), now.Add(-mailRetention)); err != nil {
+			log.Printf("Mail queue cleanup failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/i18n"
+	"hydra/pkg/mail"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// mailSendTimeout - на отправку письма вместе с повторами
+	mailSendTimeout = 2 * time.Minute
+	maxBounceBytes  = 1 << 20
+)
+
+// newMailer создает отправителя писем по MAIL_PROVIDER; nil - отправка писем не настроена
+func newMailer(cfg *config.Config) mail.Mailer {
+	var m mail.Mailer
+	switch cfg.MailProvider {
+	case "", "smtp":
+		if cfg.SMTPHost == "" || cfg.SMTPUser == "" {
+			return nil
+		}
+		m = smtpMailer(cfg)
+	case mail.ProviderSendGrid:
+		if cfg.MailAPIKey == "" {
+			log.Printf("Warning: email disabled: SendGrid needs MAIL_API_KEY")
+			return nil
+		}
+		m = &mail.SendGrid{APIKey: cfg.MailAPIKey, Endpoint: cfg.MailAPIURL}
+	case mail.ProviderMailgun:
+		if cfg.MailAPIKey == "" || cfg.MailgunDomain == "" {
+			log.Printf("Warning: email disabled: Mailgun needs MAIL_API_KEY and MAILGUN_DOMAIN")
+			return nil
+		}
+		m = &mail.Mailgun{Domain: cfg.MailgunDomain, APIKey: cfg.MailAPIKey, Endpoint: cfg.MailAPIURL}
+	case mail.ProviderSES:
+		if cfg.SESRegion == "" || cfg.SESAccessKeyID == "" || cfg.SESSecretKey == "" {
+			log.Printf("Warning: email disabled: SES needs SES_REGION, SES_ACCESS_KEY_ID and SES_SECRET_ACCESS_KEY")
+			return nil
+		}
+		m = &mail.SES{Region: cfg.SESRegion, AccessKeyID: cfg.SESAccessKeyID, SecretAccessKey: cfg.SESSecretKey, Endpoint: cfg.MailAPIURL}
+	default:
+		log.Printf("Warning: email disabled: unknown MAIL_PROVIDER %q", cfg.MailProvider)
+		return nil
+	}
+	if cfg.MailRetries > 1 {
+		m = &mail.Retry{Mailer: m, Attempts: cfg.MailRetries, Backoff: cfg.MailRetryBackoff}
+	}
+	return m
+}
+
+func smtpMailer(cfg *config.Config) *mail.SMTP {
+	return &mail.SMTP{Host: cfg.SMTPHost, Port: cfg.SMTPPort, User: cfg.SMTPUser, Password: cfg.SMTPPassword}
+}
+
+// deliverMail отправляет письмо настроенной службой
+func (s *Server) deliverMail(m *mail.Message) error {
+	if s.mailer == nil {
+		return fmt.Errorf("email is not configured")
+	}
+	log.Printf("📧 Sending email from %s to %s...", mail.Address(m.From), m.To)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+	defer cancel()
+	if err := s.mailer.Send(ctx, m); err != nil {
+		return err
+	}
+	log.Printf("✅ Email sent successfully to %s", m.To)
+	return nil
+}
+
+// sendTemplatedEmail отправляет письмо вида kind с текстовой и HTML-версией
+func (s *Server) sendTemplatedEmail(to, kind string, c *mail.Content) error {
+	c.Logo = s.emailLogo
+	m, err := mail.Compose(kind, s.config.SMTPFrom, to, c)
+	if err != nil {
+		return err
+	}
+	return s.deliverMail(m)
+}
+
+// verificationEmail - письмо с кодом подтверждения: textKey - шаблон текстовой версии,
+// introKey - строка перед кодом в HTML-версии
+func verificationEmail(locale, textKey, introKey, code string) *mail.Content {
+	return &mail.Content{
+		Lang:       locale,
+		Subject:    i18n.Format(locale, i18n.VerificationSubject),
+		Text:       i18n.Format(locale, textKey, code),
+		Paragraphs: []string{i18n.Format(locale, introKey)},
+		Code:       code,
+		Note:       i18n.Format(locale, i18n.CodeIgnoreNote),
+	}
+}
+
+// handleEmailBounces обрабатывает POST /api/email/bounces/{provider}?token=...: уведомления
+// почтовой службы о недоставленных письмах и жалобах на спам
+func (s *Server) handleEmailBounces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	token := r.URL.Query().Get("token")
+	if s.config.MailWebhookToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.MailWebhookToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBounceBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to read request"})
+		return
+	}
+	bounces, subscribeURL, err := mail.ParseBounces(r.PathValue("provider"), body)
+	if errors.Is(err, mail.ErrUnknownProvider) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown email provider"})
+		return
+	}
+	if err != nil {
+		log.Printf("Invalid bounce notification: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid notification"})
+		return
+	}
+
+	if subscribeURL != "" {
+		if err := confirmSNSSubscription(r.Context(), subscribeURL); err != nil {
+			log.Printf("Failed to confirm SNS subscription: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to confirm subscription"})
+			return
+		}
+	}
+	for _, b := range bounces {
+		s.handleBounce(r.Context(), b)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "bounces": len(bounces)})
+}
+
+// handleBounce обрабатывает недоставку письма: на адрес, который письма не принимает
+// или пожаловался на спам, перестают уходить дайджесты
+func (s *Server) handleBounce(ctx context.Context, b mail.Bounce) {
+	log.Printf("Email to %s bounced (permanent: %v): %s", b.Recipient, b.Permanent, b.Reason)
+	if !b.Permanent {
+		return
+	}
+	user, err := s.db.GetUserByEmail(ctx, strings.ToLower(b.Recipient))
+	if err != nil {
+		return
+	}
+	settings, err := s.db.GetDigestSettings(ctx, user.ID)
+	if err != nil || !settings.Enabled {
+		return
+	}
+	settings.Enabled = false
+	if err := s.db.UpdateDigestSettings(ctx, settings); err != nil {
+		log.Printf("Failed to disable digests for %s: %v", user.ID, err)
+	}
+}
+
+// confirmSNSSubscription подтверждает подписку Amazon SNS на уведомления SES. Открываются
+// только адреса SNS, чтобы уведомление не заставило сервер обращаться к чужим адресам.
+func confirmSNSSubscription(ctx context.Context, subscribeURL string) error {
+	u, err := url.Parse(subscribeURL)
+	if err != nil || u.Scheme != "https" || !strings.HasPrefix(u.Host, "sns.") || !strings.HasSuffix(u.Host, ".amazonaws.com") {
+		return fmt.Errorf("unexpected subscribe URL: %s", subscribeURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SNS returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/folders"
+	"hydra/pkg/ids"
+	"hydra/pkg/receipts"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// eventPollInterval - как часто подписчик перечитывает журнал без уведомлений
+	// (события могли записать другие экземпляры сервера)
+	eventPollInterval = 15 * time.Second
+	// maxEventWait - максимальное ожидание новых событий при long polling
+	maxEventWait = 60 * time.Second
+)
+
+// eventHub будит подписчиков журнала пользователя после записи нового события
+type eventHub struct {
+	mu      sync.Mutex
+	waiters map[string]chan struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{waiters: make(map[string]chan struct{})}
+}
+
+// wait возвращает канал, который закроется при следующем событии пользователя
+func (h *eventHub) wait(userID string) <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch, exists := h.waiters[userID]
+	if !exists {
+		ch = make(chan struct{})
+		h.waiters[userID] = ch
+	}
+	return ch
+}
+
+func (h *eventHub) notify(userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ch, exists := h.waiters[userID]; exists {
+		close(ch)
+		delete(h.waiters, userID)
+	}
+}
+
+// appendEvent записывает событие в журнал пользователя и будит его подписчиков
+func (s *Server) appendEvent(userID, eventType string, payload interface{}) {
+	if userID == "" || s.db == nil {
+		return
+	}
+	if _, err := s.db.AppendEvent(context.Background(), userID, eventType, payload); err != nil {
+		log.Printf("Failed to append %s event for %s: %v", eventType, userID, err)
+		return
+	}
+	s.events.notify(userID)
+}
+
+// followEvents передает send все события пользователя после afterSeq по порядку и ждет новые,
+// пока не отменен ctx или send не вернет ошибку
+func (s *Server) followEvents(ctx context.Context, userID string, afterSeq int64, send func(*storage.Event) error) error {
+	for {
+		// Канал берется до чтения журнала, чтобы не пропустить событие между чтением и ожиданием
+		wake := s.events.wait(userID)
+
+		events, err := s.db.ListEvents(ctx, userID, afterSeq, 100)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := send(event); err != nil {
+				return err
+			}
+			afterSeq = event.Seq
+		}
+		if len(events) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-time.After(eventPollInterval):
+		}
+	}
+}
+
+// splitEventsPath разбирает "{id}/events", "{id}/events/stream" и "{id}/events/ws"
+func splitEventsPath(path string) (userID, mode string, ok bool) {
+	for _, mode := range []string{"stream", "ws"} {
+		if userID, found := strings.CutSuffix(path, "/events/"+mode); found && userID != "" {
+			return userID, mode, true
+		}
+	}
+	if userID, found := strings.CutSuffix(path, "/events"); found && userID != "" {
+		return userID, "poll", true
+	}
+	return "", "", false
+}
+
+// handleUserEvents отдает журнал событий пользователя. Все способы доставки читают один журнал
+// и продолжают с номера, переданного клиентом, поэтому события не теряются при переподключении:
+//   - GET /api/users/{id}/events?after=N&limit=M&wait=30s - (long) polling
+//   - GET /api/users/{id}/events/stream?after=N - Server-Sent Events (или заголовок Last-Event-ID)
+//   - GET /api/users/{id}/events/ws?after=N - WebSocket, события в JSON
+//
+// Читатель подтверждает, что он владелец журнала, токеном входа в Authorization или
+// одноразовым билетом ?ticket=... (для WebSocket и EventSource в браузере).
+// Параметр lite=1 (или заголовок X-Hydra-Lite) включает для сессии облегченный режим (см. lite.go).
+// Параметр device_id - зарегистрированное устройство пользователя: сервер запоминает номер
+// последнего переданного ему события, а push не отправляется на устройство, пока открыт WebSocket.
+func (s *Server) handleUserEvents(w http.ResponseWriter, r *http.Request, userID, mode string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	// Журнал содержит тексты сообщений: читает только владелец, при любом способе доставки
+	caller, ok := s.eventsCaller(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Valid token or connect ticket required"})
+		return
+	}
+	if caller != userID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the owner can read the event log"})
+		return
+	}
+
+	after := r.URL.Query().Get("after")
+	if lastID := r.Header.Get("Last-Event-ID"); after == "" && lastID != "" {
+		after = lastID
+	}
+	afterSeq, err := strconv.ParseInt(after, 10, 64)
+	if after != "" && (err != nil || afterSeq < 0) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid event sequence"})
+		return
+	}
+
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID != "" {
+		// Переданный after подтверждает получение устройством предыдущих событий
+		if ok, err := s.db.TouchDevice(r.Context(), userID, deviceID, afterSeq); err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device not found"})
+			return
+		}
+	}
+
+	lite := negotiateLite(w, r)
+	switch mode {
+	case "stream":
+		s.streamEvents(w, r, userID, deviceID, afterSeq, lite)
+	case "ws":
+		s.websocketEvents(w, r, userID, deviceID, afterSeq, lite)
+	default:
+		s.pollEvents(w, r, userID, deviceID, afterSeq, lite)
+	}
+}
+
+// eventsCaller возвращает читателя журнала: по одноразовому билету (?ticket=..., см.
+// handleWSTicket) - браузер не передает заголовки при открытии WebSocket и EventSource -
+// или по токену входа в заголовке Authorization. Билет погашается и при отказе.
+func (s *Server) eventsCaller(r *http.Request) (string, bool) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return s.tickets.consume(ticket)
+	}
+	userID, err := s.bearerUser(r)
+	return userID, err == nil
+}
+
+// ackDevice продвигает курсор доставки устройства deviceID до события seq
+func (s *Server) ackDevice(userID, deviceID string, seq int64) {
+	if deviceID == "" {
+		return
+	}
+	if _, err := s.db.TouchDevice(context.Background(), userID, deviceID, seq); err != nil {
+		log.Printf("Failed to update cursor of device %s: %v", deviceID, err)
+	}
+}
+
+func (s *Server) pollEvents(w http.ResponseWriter, r *http.Request, userID, deviceID string, afterSeq int64, lite bool) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	if wait > maxEventWait {
+		wait = maxEventWait
+	}
+
+	wake := s.events.wait(userID)
+	events, err := s.db.ListEvents(r.Context(), userID, afterSeq, limit)
+	if err == nil && len(events) == 0 && wait > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-time.After(wait):
+		}
+		events, err = s.db.ListEvents(r.Context(), userID, afterSeq, limit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load events"})
+		return
+	}
+
+	// last_seq учитывает и отброшенные облегченным режимом события, чтобы не запрашивать их снова
+	lastSeq := afterSeq
+	if len(events) > 0 {
+		lastSeq = events[len(events)-1].Seq
+	}
+	if lite {
+		events = liteEvents(events)
+	}
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "events": events, "last_seq": lastSeq, "lite": lite}); err == nil && lastSeq > afterSeq {
+		s.ackDevice(userID, deviceID, lastSeq)
+	}
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, userID, deviceID string, afterSeq int64, lite bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Streaming is not supported"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := s.followEvents(r.Context(), userID, afterSeq, func(event *storage.Event) error {
+		if lite {
+			if event = liteEvent(event); event == nil {
+				return nil
+			}
+		}
+		data, _ := json.Marshal(event)
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		s.ackDevice(userID, deviceID, event.Seq)
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Event stream for %s stopped: %v", userID, err)
+	}
+}
+
+// websocketEvents передает журнал по WebSocket. Владельца журнала проверяет handleUserEvents.
+func (s *Server) websocketEvents(w http.ResponseWriter, r *http.Request, userID, deviceID string, afterSeq int64, lite bool) {
+	// Подтверждение облегченного режима уходит в ответе на рукопожатие
+	var config websocket.Config
+	if lite {
+		config.Header = http.Header{liteHeader: {"1"}}
+	}
+	websocket.Server{Config: config, Handler: func(ws *websocket.Conn) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Отправка идет из нескольких горутин: журнал, обновление билетов и эфемерные
+		// события других пользователей (присутствие, набор текста)
+		var sendMu sync.Mutex
+		send := func(v interface{}) error {
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			return websocket.JSON.Send(ws, v)
+		}
+		go s.refreshTickets(ctx, userID, send)
+
+		conn := s.live.add(userID, deviceID, send)
+		defer s.live.remove(userID, conn)
+		s.presence.Connect(userID)
+		defer s.presence.Disconnect(userID)
+
+		// Клиент присылает heartbeat и набор текста (см. handleWSFrame); чтение также
+		// замечает закрытие соединения
+		go func() {
+			defer cancel()
+			var data []byte
+			for websocket.Message.Receive(ws, &data) == nil {
+				s.handleWSFrame(ctx, userID, data)
+			}
+		}()
+
+		err := s.followEvents(ctx, userID, afterSeq, func(event *storage.Event) error {
+			if lite {
+				if event = liteEvent(event); event == nil {
+					return nil
+				}
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+			s.ackDevice(userID, deviceID, event.Seq)
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Event websocket for %s stopped: %v", userID, err)
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// refreshTickets присылает по открытому соединению свежий билет для переподключения
+// до истечения предыдущего. Неиспользованный предыдущий билет отзывается.
+func (s *Server) refreshTickets(ctx context.Context, userID string, send func(interface{}) error) {
+	ttl := s.ticketTTL()
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	var current string
+	defer func() { s.tickets.revoke(current) }()
+	for {
+		next, err := s.tickets.mint(userID, ttl)
+		if err != nil {
+			log.Printf("Failed to refresh connect ticket for %s: %v", userID, err)
+			return
+		}
+		s.tickets.revoke(current)
+		current = next
+
+		if err := send(map[string]interface{}{"type": "ticket", "ticket": next, "expires_in": int(ttl.Seconds())}); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordMessageCreated записывает новое сообщение в журналы отправителя (для других его
+// устройств) и получателя. Возвращает ID сообщения для последующих правок и квитанций.
+func (s *Server) recordMessageCreated(from, to, body string, attachments ...*storage.Attachment) string {
+	id := "msg-" + ids.New()
+	payload := map[string]interface{}{"id": id, "from": from, "to": to, "body": body}
+	if len(attachments) > 0 {
+		payload["attachments"] = attachments
+	}
+	s.appendEvent(from, storage.EventMessageCreated, payload)
+	if to != from {
+		s.trackDelivery(id, from, to)
+		s.appendEvent(to, storage.EventMessageCreated, payload)
+		// Личная беседа у получателя - беседа с отправителем
+		s.fileMessages(to, folders.Message{ConversationID: from, SenderID: from, Body: body})
+	}
+	return id
+}
+
+// handleReceipt обрабатывает POST /api/receipts {message_id, sender_id, status, group_id}:
+// квитанция (delivered или read) попадает в журналы получателя и автора сообщения.
+// Получатель - владелец токена входа; user_id в теле необязателен и должен с ним совпадать.
+// Квитанции групповых сообщений (group_id) автор получает пакетами сводок, см. receipts.go.
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	caller, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		UserID    string `json:"user_id"`
+		MessageID string `json:"message_id"`
+		SenderID  string `json:"sender_id"`
+		Status    string `json:"status"`
+		GroupID   string `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "message_id required"})
+		return
+	}
+	if req.UserID != "" && req.UserID != caller {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User does not match the token"})
+		return
+	}
+	req.UserID = caller
+	if req.Status != receipts.StatusDelivered && req.Status != receipts.StatusRead {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "status must be delivered or read"})
+		return
+	}
+
+	payload := map[string]string{"message_id": req.MessageID, "user_id": req.UserID, "status": req.Status}
+	if req.GroupID != "" {
+		payload["group_id"] = req.GroupID
+	}
+	s.appendEvent(req.UserID, storage.EventReceipt, payload)
+	if req.SenderID == req.UserID {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		return
+	}
+
+	if req.GroupID != "" && s.receipts != nil {
+		if err := s.receipts.Add(receipts.Receipt{MessageID: req.MessageID, GroupID: req.GroupID,
+			SenderID: req.SenderID, ReaderID: req.UserID, Status: req.Status}); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "batched": true})
+		return
+	}
+	if s.db != nil {
+		// Личные сообщения, отправленные через /api/send, имеют состояние доставки
+		if _, _, err := s.db.UpdateReceipt(r.Context(), req.MessageID, req.UserID, req.Status, time.Now()); err != nil {
+			log.Printf("Receipt for %s not saved: %v", req.MessageID, err)
+		}
+	}
+	s.appendEvent(req.SenderID, storage.EventReceipt, payload)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
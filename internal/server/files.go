@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Вложения (изображения, документы) загружаются в blob-хранилище до отправки сообщения,
+// а сообщение ссылается на них по ID (поле attachments в /api/send).
+//
+// Небольшой файл загружается одним запросом POST /api/files/upload (multipart, поле file).
+// Большой - по частям: POST /api/files/upload с JSON {conversation_id, name, mime_type,
+// size, sha256} создает загрузку, затем части отправляются PATCH /api/files/{id} с
+// заголовком Content-Range: bytes начало-конец/размер. Части идут строго подряд; после
+// обрыва клиент узнает, сколько принято (GET /api/files/{id}/meta, поле received), и
+// продолжает с этого места. Когда принят весь размер, хеш содержимого сверяется с
+// заявленным и вложение становится доступным: GET /api/files/{id}.
+
+// fileChunkMaxBytes - наибольшая часть загрузки в одном запросе
+const fileChunkMaxBytes = 8 << 20
+
+// attachmentKey - ключ содержимого вложения в blob-хранилище
+func attachmentKey(id string) string {
+	return "attachments/" + id
+}
+
+// handleFileUpload обрабатывает POST /api/files/upload: загрузку файла целиком (multipart)
+// или начало загрузки по частям (JSON)
+func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	owner, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if s.blobs == nil || s.db == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "File storage is not configured"})
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		s.uploadWholeFile(w, r, owner)
+		return
+	}
+
+	var req struct {
+		ConversationID string `json:"conversation_id"`
+		Name           string `json:"name"`
+		MimeType       string `json:"mime_type"`
+		Size           int64  `json:"size"`
+		SHA256         string `json:"sha256"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if req.Size <= 0 || req.Size > s.config.FileMaxBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("size must be between 1 and %d bytes", s.config.FileMaxBytes)})
+		return
+	}
+	if !s.acceptAttachment(w, req.ConversationID) {
+		return
+	}
+	if req.MimeType == "" {
+		req.MimeType = "application/octet-stream"
+	}
+
+	a := &storage.Attachment{
+		ID:             "file-" + ids.New(),
+		OwnerID:        owner,
+		ConversationID: req.ConversationID,
+		Name:           req.Name,
+		MimeType:       req.MimeType,
+		Size:           req.Size,
+		SHA256:         strings.ToLower(req.SHA256),
+	}
+	a.Key = attachmentKey(a.ID)
+	if err := s.db.CreateAttachment(r.Context(), a); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create upload"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"attachment": a,
+		"upload_url": "/api/files/" + a.ID,
+		"chunk_size": fileChunkMaxBytes,
+	})
+}
+
+// uploadWholeFile сохраняет файл из multipart формы (поля file и conversation_id)
+func (s *Server) uploadWholeFile(w http.ResponseWriter, r *http.Request, owner string) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.FileMaxBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "No file provided: " + err.Error()})
+		return
+	}
+	defer file.Close()
+	if header.Size > s.config.FileMaxBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("file is larger than %d bytes", s.config.FileMaxBytes)})
+		return
+	}
+	conversationID := r.FormValue("conversation_id")
+	if !s.acceptAttachment(w, conversationID) {
+		return
+	}
+
+	// Тип определяется по содержимому, если клиент его не указал
+	mimeType := header.Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		mimeType = http.DetectContentType(head[:n])
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to read file"})
+			return
+		}
+	}
+
+	a := &storage.Attachment{
+		ID:             "file-" + ids.New(),
+		OwnerID:        owner,
+		ConversationID: conversationID,
+		Name:           header.Filename,
+		MimeType:       mimeType,
+		Size:           header.Size,
+	}
+	a.Key = attachmentKey(a.ID)
+
+	hash := sha256.New()
+	if _, err := s.blobs.Put(a.Key, io.TeeReader(file, hash)); err != nil {
+		log.Printf("Failed to store attachment %s: %v", a.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to store file"})
+		return
+	}
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	a.Complete = true
+	if err := s.db.CreateAttachment(r.Context(), a); err != nil {
+		s.blobs.Delete(a.Key)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save file"})
+		return
+	}
+	if a.ConversationID != "" {
+		s.enforceQuota(a.ConversationID)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "attachment": a, "url": "/api/files/" + a.ID})
+}
+
+// acceptAttachment проверяет, что группа с политикой reject еще принимает медиа
+func (s *Server) acceptAttachment(w http.ResponseWriter, conversationID string) bool {
+	if conversationID != "" && s.mediaQuotaExceeded(conversationID) {
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Group media quota exceeded"})
+		return false
+	}
+	return true
+}
+
+// handleFile обрабатывает /api/files/{id}: GET - содержимое (с поддержкой Range),
+// GET {id}/meta - метаданные и принятый объем, PATCH - очередная часть загрузки,
+// DELETE - удаление (только владелец)
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	id, meta := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/meta")
+	if _, err := s.bearerUser(r); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if s.blobs == nil || s.db == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "File storage is not configured"})
+		return
+	}
+	a, err := s.db.GetAttachment(r.Context(), id)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "File not found"})
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && meta:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "attachment": a, "received": s.uploadedBytes(a)})
+	case r.Method == http.MethodGet:
+		s.serveAttachment(w, r, a)
+	case r.Method == http.MethodPatch:
+		s.uploadChunk(w, r, a)
+	case r.Method == http.MethodDelete:
+		s.deleteAttachment(w, r, a)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// uploadedBytes возвращает, сколько байт загрузки уже принято
+func (s *Server) uploadedBytes(a *storage.Attachment) int64 {
+	if a.Complete {
+		return a.Size
+	}
+	info, err := s.blobs.Stat(a.Key)
+	if err != nil {
+		return 0
+	}
+	return info.Size
+}
+
+// parseContentRange разбирает "bytes start-end/total"
+func parseContentRange(value string) (start, end, total int64, ok bool) {
+	spec, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rangePart, totalPart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	startPart, endPart, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	var err1, err2, err3 error
+	start, err1 = strconv.ParseInt(startPart, 10, 64)
+	end, err2 = strconv.ParseInt(endPart, 10, 64)
+	total, err3 = strconv.ParseInt(totalPart, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || start < 0 || end < start || end >= total {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+// uploadChunk дописывает часть загрузки; часть должна начинаться с уже принятого объема
+func (s *Server) uploadChunk(w http.ResponseWriter, r *http.Request, a *storage.Attachment) {
+	w.Header().Set("Content-Type", "application/json")
+	if caller, _ := s.bearerUser(r); caller != a.OwnerID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the uploader can send file parts"})
+		return
+	}
+	if a.Complete {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Upload already complete", "received": a.Size})
+		return
+	}
+	start, end, total, ok := parseContentRange(r.Header.Get("Content-Range"))
+	if !ok || total != a.Size || end-start+1 > fileChunkMaxBytes {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("Content-Range must be bytes start-end/%d with at most %d bytes", a.Size, fileChunkMaxBytes)})
+		return
+	}
+
+	// Части одной загрузки не должны писаться параллельно
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+
+	received := s.uploadedBytes(a)
+	if start != received {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Part does not continue the upload", "received": received})
+		return
+	}
+	n, err := s.blobs.Append(a.Key, io.LimitReader(r.Body, end-start+1))
+	received += n
+	if err != nil {
+		log.Printf("Failed to append to attachment %s: %v", a.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to store part", "received": received})
+		return
+	}
+	if received < a.Size {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "received": received, "complete": false})
+		return
+	}
+
+	sum, err := s.blobSHA256(a.Key)
+	if err != nil || (a.SHA256 != "" && sum != a.SHA256) {
+		// Содержимое не совпало с заявленным: загрузка начинается заново
+		s.blobs.Delete(a.Key)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Checksum mismatch, upload restarted", "received": 0})
+		return
+	}
+	if err := s.db.CompleteAttachment(r.Context(), a.ID, sum); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to complete upload"})
+		return
+	}
+	a.SHA256, a.Complete = sum, true
+	if a.ConversationID != "" {
+		s.enforceQuota(a.ConversationID)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "received": received, "complete": true, "attachment": a, "url": "/api/files/" + a.ID})
+}
+
+// blobSHA256 считает hex SHA-256 содержимого объекта
+func (s *Server) blobSHA256(key string) (string, error) {
+	file, err := s.blobs.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request, a *storage.Attachment) {
+	if !a.Complete {
+		http.Error(w, "Upload is not complete", http.StatusConflict)
+		return
+	}
+	file, err := s.blobs.Open(a.Key)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", a.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	http.ServeContent(w, r, "", a.CreatedAt, file)
+}
+
+func (s *Server) deleteAttachment(w http.ResponseWriter, r *http.Request, a *storage.Attachment) {
+	w.Header().Set("Content-Type", "application/json")
+	if caller, _ := s.bearerUser(r); caller != a.OwnerID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the uploader can delete the file"})
+		return
+	}
+	if err := s.removeAttachment(a); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete file"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func (s *Server) removeAttachment(a *storage.Attachment) error {
+	if err := s.blobs.Delete(a.Key); err != nil {
+		return err
+	}
+	return s.db.DeleteAttachment(context.Background(), a.ID)
+}
+
+// messageAttachments проверяет вложения сообщения: загрузка завершена, отправитель - владелец
+func (s *Server) messageAttachments(from string, ids []string) ([]*storage.Attachment, error) {
+	attachments := make([]*storage.Attachment, 0, len(ids))
+	for _, id := range ids {
+		a, err := s.db.GetAttachment(context.Background(), id)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s not found", id)
+		}
+		if !a.Complete || a.OwnerID != from {
+			return nil, fmt.Errorf("attachment %s is not available", id)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// runUploadCleanup периодически удаляет загрузки, не завершенные за FILE_UPLOAD_TTL
+func (s *Server) runUploadCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		stale, err := s.db.ListStaleUploads(context.Background(), time.Now().Add(-s.config.FileUploadTTL))
+		if err != nil {
+			log.Printf("Stale uploads check failed: %v", err)
+		}
+		for _, a := range stale {
+			if err := s.removeAttachment(a); err != nil {
+				log.Printf("Failed to delete stale upload %s: %v", a.ID, err)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/folders"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// splitFoldersPath разбирает "{id}/folders" и "{id}/folders/{folderID}"
+func splitFoldersPath(path string) (userID, folderID string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[1] != "folders" || len(parts) > 3 {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		folderID = parts[2]
+	}
+	return parts[0], folderID, true
+}
+
+// fileMessages раскладывает беседы полученных пользователем сообщений по его папкам и
+// сообщает об этом всем устройствам пользователя. Беседа попадает в папку последнего
+// подошедшего сообщения; беседа, не подходящая ни под одно правило, остается там,
+// куда была разложена раньше.
+func (s *Server) fileMessages(userID string, msgs ...folders.Message) {
+	if userID == "" || s.db == nil || len(msgs) == 0 {
+		return
+	}
+	list, err := s.db.ListFolders(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to load folders of %s: %v", userID, err)
+		return
+	}
+	if len(list) == 0 {
+		return
+	}
+
+	var order []string
+	matched := make(map[string]string)
+	for _, msg := range msgs {
+		folder := folders.Match(list, msg)
+		if folder == nil {
+			continue
+		}
+		if _, seen := matched[msg.ConversationID]; !seen {
+			order = append(order, msg.ConversationID)
+		}
+		matched[msg.ConversationID] = folder.ID
+	}
+
+	for _, conversationID := range order {
+		folderID := matched[conversationID]
+		changed, err := s.db.AssignFolder(context.Background(), userID, conversationID, folderID)
+		if err != nil {
+			log.Printf("Failed to file conversation %s of %s: %v", conversationID, userID, err)
+			continue
+		}
+		if changed {
+			s.appendEvent(userID, storage.EventFolderAssigned, map[string]string{
+				"conversation_id": conversationID,
+				"folder_id":       folderID,
+			})
+		}
+	}
+}
+
+// handleUserFolders обрабатывает папки чатов пользователя (только сам пользователь):
+// GET {id}/folders - папки и раскладка бесед, POST {id}/folders - новая папка,
+// PUT и DELETE {id}/folders/{folderID} - изменение и удаление папки.
+// Каждое изменение попадает в журнал пользователя, откуда его получают другие устройства.
+func (s *Server) handleUserFolders(w http.ResponseWriter, r *http.Request, userID, folderID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && folderID == "":
+		list, err := s.db.ListFolders(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load folders"})
+			return
+		}
+		assignments, err := s.db.ListFolderAssignments(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load folder assignments"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "folders": list, "assignments": assignments})
+
+	case (r.Method == http.MethodPost && folderID == "") || (r.Method == http.MethodPut && folderID != ""):
+		var folder folders.Folder
+		if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		folder.ID, folder.UserID, folder.Name = folderID, userID, strings.TrimSpace(folder.Name)
+		if err := folders.Validate(&folder); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		if folderID == "" {
+			list, err := s.db.ListFolders(r.Context(), userID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load folders"})
+				return
+			}
+			if len(list) >= folders.MaxFolders {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many folders"})
+				return
+			}
+		}
+
+		found, err := s.db.SaveFolder(r.Context(), &folder)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save folder"})
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Folder not found"})
+			return
+		}
+		s.appendEvent(userID, storage.EventFolders, map[string]interface{}{"change": "saved", "folder": folder})
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "folder": folder})
+
+	case r.Method == http.MethodDelete && folderID != "":
+		found, err := s.db.DeleteFolder(r.Context(), userID, folderID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete folder"})
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Folder not found"})
+			return
+		}
+		s.appendEvent(userID, storage.EventFolders, map[string]interface{}{"change": "deleted", "folder_id": folderID})
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/folders"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Групповые беседы. Участники видят группу, ее участников и историю; посторонним группа
+// неотличима от несуществующей. Участников добавляют администраторы группы, выйти может
+// любой участник. Сообщение группы сохраняется в истории беседы с ID группы и расходится
+// по журналам событий участников (WebSocket, SSE) и через транспорты.
+
+// Изменения состава группы в событиях membership.changed
+const (
+	groupMemberJoined = "joined"
+	groupMemberLeft   = "left"
+)
+
+// groupFanOutTimeout - сколько отправка сообщения группы ждет транспорт для одного участника
+const groupFanOutTimeout = 30 * time.Second
+
+// notifyGroup записывает событие в журналы всех участников группы и extra
+func (s *Server) notifyGroup(groupID, eventType string, payload interface{}, extra ...string) {
+	members, err := s.db.ListGroupMembers(context.Background(), groupID)
+	if err != nil {
+		log.Printf("Failed to list members of group %s: %v", groupID, err)
+		return
+	}
+	for _, m := range members {
+		s.appendEvent(m.UserID, eventType, payload)
+	}
+	for _, userID := range extra {
+		s.appendEvent(userID, eventType, payload)
+	}
+}
+
+// groupMembership возвращает участие пользователя в группе. Если группы нет или
+// пользователь в ней не состоит, пишет 404 и возвращает nil.
+func (s *Server) groupMembership(w http.ResponseWriter, r *http.Request, groupID, userID string) *storage.GroupMember {
+	member, err := s.db.GetGroupMember(r.Context(), groupID, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load group"})
+		return nil
+	}
+	if member == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Group not found"})
+		return nil
+	}
+	return member
+}
+
+// handleGroups обрабатывает /api/groups: GET - группы пользователя,
+// POST {name, members} - новая группа, автор становится ее администратором
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		groups, err := s.db.ListUserGroups(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list groups"})
+			return
+		}
+		if groups == nil {
+			groups = []*storage.Group{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "groups": groups})
+
+	case http.MethodPost:
+		var req struct {
+			Name    string   `json:"name"`
+			Members []string `json:"members"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "name required"})
+			return
+		}
+		for _, member := range req.Members {
+			if _, err := s.db.GetUser(r.Context(), member); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown member " + member})
+				return
+			}
+		}
+
+		group := &storage.Group{Name: strings.TrimSpace(req.Name), CreatedBy: userID}
+		if err := s.db.CreateGroup(r.Context(), group, req.Members); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create group"})
+			return
+		}
+		// Каждый участник новой группы узнает о ней из своего журнала
+		members, _ := s.db.ListGroupMembers(r.Context(), group.ID)
+		for _, m := range members {
+			s.appendEvent(m.UserID, storage.EventMembership, map[string]string{"group_id": group.ID, "user_id": m.UserID, "change": groupMemberJoined})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "group": group, "members": members})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleGroup обрабатывает /api/groups/{id}[/members[/{user_id}]|/messages]
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/"), "/")
+	groupID := parts[0]
+	member := s.groupMembership(w, r, groupID, userID)
+	if member == nil {
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		group, err := s.db.GetGroup(r.Context(), groupID)
+		if err != nil || group == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load group"})
+			return
+		}
+		members, err := s.db.ListGroupMembers(r.Context(), groupID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list members"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "group": group, "members": members})
+	case (len(parts) == 2 || len(parts) == 3) && parts[1] == "members":
+		target := ""
+		if len(parts) == 3 {
+			target = parts[2]
+		}
+		s.handleGroupMembers(w, r, member, target)
+	case len(parts) == 2 && parts[1] == "messages":
+		s.handleGroupMessages(w, r, member)
+	case len(parts) == 1:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Not found"})
+	}
+}
+
+// handleGroupMembers обрабатывает /api/groups/{id}/members: POST {user_id} - добавление
+// участника администратором; DELETE /api/groups/{id}/members/{user_id} - выход из группы
+// или удаление участника администратором
+func (s *Server) handleGroupMembers(w http.ResponseWriter, r *http.Request, caller *storage.GroupMember, target string) {
+	groupID := caller.GroupID
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "user_id required"})
+			return
+		}
+		if caller.Role != storage.GroupRoleAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only group admins can add members"})
+			return
+		}
+		if _, err := s.db.GetUser(r.Context(), req.UserID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+			return
+		}
+		added, err := s.db.AddGroupMember(r.Context(), groupID, req.UserID, storage.GroupRoleMember)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to add member"})
+			return
+		}
+		if added {
+			s.notifyGroup(groupID, storage.EventMembership, map[string]string{"group_id": groupID, "user_id": req.UserID, "change": groupMemberJoined})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "added": added})
+
+	case http.MethodDelete:
+		if target == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User ID required"})
+			return
+		}
+		if target != caller.UserID && caller.Role != storage.GroupRoleAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only group admins can remove members"})
+			return
+		}
+		removed, err := s.db.RemoveGroupMember(r.Context(), groupID, target)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to remove member"})
+			return
+		}
+		// Вышедший получает событие, хотя больше не состоит в группе
+		if removed {
+			s.notifyGroup(groupID, storage.EventMembership, map[string]string{"group_id": groupID, "user_id": target, "change": groupMemberLeft}, target)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "removed": removed})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleGroupMessages обрабатывает /api/groups/{id}/messages: GET - история группы (как
+// /api/conversations/{id}/messages), POST {body, type, reply_to} - сообщение участникам.
+// Упомянутые как @имя участники перечисляются в mentions события и ответа.
+func (s *Server) handleGroupMessages(w http.ResponseWriter, r *http.Request, sender *storage.GroupMember) {
+	if r.Method == http.MethodGet {
+		s.handleConversationMessages(w, r, sender.GroupID)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		Body    string `json:"body"`
+		Type    string `json:"type"`
+		ReplyTo string `json:"reply_to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Body == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message cannot be empty"})
+		return
+	}
+	if !s.allowSend(sender.UserID) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many messages"})
+		return
+	}
+	// При политике reject сообщения сверх квоты группы не принимаются
+	if s.messageHeadroom(sender.GroupID) == 0 {
+		w.WriteHeader(http.StatusInsufficientStorage)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Group message quota exceeded"})
+		return
+	}
+
+	msg := &storage.Message{
+		ConversationID: sender.GroupID,
+		SenderID:       sender.UserID,
+		Type:           req.Type,
+		Body:           req.Body,
+		ReplyTo:        req.ReplyTo,
+	}
+	if err := s.db.CreateMessage(r.Context(), msg); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save message"})
+		return
+	}
+	s.enforceQuota(sender.GroupID)
+	s.touchUser(sender.UserID)
+
+	members, err := s.db.ListGroupMembers(r.Context(), sender.GroupID)
+	if err != nil {
+		log.Printf("Failed to list members of group %s: %v", sender.GroupID, err)
+	}
+	memberIDs := make([]string, 0, len(members))
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.UserID)
+	}
+	mentions := s.resolveMentions(r.Context(), msg.Body, memberIDs)
+	payload := map[string]interface{}{
+		"id":         msg.ID,
+		"group_id":   msg.ConversationID,
+		"from":       msg.SenderID,
+		"type":       msg.Type,
+		"body":       msg.Body,
+		"reply_to":   msg.ReplyTo,
+		"created_at": msg.CreatedAt,
+	}
+	if len(mentions) > 0 {
+		payload["mentions"] = mentions
+	}
+	var recipients []string
+	for _, m := range members {
+		// Участник, заблокировавший отправителя, не получает его сообщений
+		if s.blockedBy(m.UserID, sender.UserID) {
+			continue
+		}
+		s.appendEvent(m.UserID, storage.EventMessageCreated, payload)
+		if m.UserID == sender.UserID {
+			continue
+		}
+		recipients = append(recipients, m.UserID)
+		s.recordNotification(m.UserID, storage.NotificationMessage, msg.ConversationID)
+		s.fileMessages(m.UserID, folders.Message{ConversationID: msg.ConversationID, SenderID: msg.SenderID, Body: msg.Body})
+	}
+	if len(recipients) > 0 {
+		if err := s.db.CreateReceipts(r.Context(), msg.ID, msg.SenderID, recipients); err != nil {
+			log.Printf("Failed to track delivery of %s: %v", msg.ID, err)
+		}
+		go s.fanOutGroupMessage(msg, recipients)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": msg, "mentions": mentions})
+}
+
+// fanOutGroupMessage отправляет сообщение группы каждому участнику через транспорты в
+// формате, который принимает /api/messages/incoming. В режиме обслуживания и в окне
+// отключения транспортов участники получают сообщение только через журнал событий.
+func (s *Server) fanOutGroupMessage(msg *storage.Message, recipients []string) {
+	if enabled, _ := s.inMaintenance(); enabled {
+		return
+	}
+	if _, blocked := s.messagesBlackedOut(); blocked {
+		return
+	}
+
+	for _, to := range recipients {
+		data, err := json.Marshal(map[string]interface{}{
+			"id":       msg.ID,
+			"from":     msg.SenderID,
+			"to":       to,
+			"body":     msg.Body,
+			"sent_at":  msg.CreatedAt.UnixMilli(),
+			"group_id": msg.ConversationID,
+		})
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), groupFanOutTimeout)
+		_, err = s.transportManager.Exchange(ctx, data)
+		cancel()
+		if err != nil {
+			log.Printf("Transport delivery of group message %s to %s failed: %v", msg.ID, to, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"hydra/pkg/transport"
+	hwebrtc "hydra/pkg/webrtc"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// requireAdmin проверяет токен администратора или роль admin пользователя.
+// При ошибке пишет ответ и возвращает false.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := s.requireAdminActor(w, r)
+	return ok
+}
+
+// requireAdminActor как requireAdmin, но возвращает автора действия для аудита (см. adminActor)
+func (s *Server) requireAdminActor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	actor, ok := s.adminActor(r)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Admin access required"})
+		return "", false
+	}
+	return actor, true
+}
+
+// handleICEConfig отдает клиенту актуальный список ICE серверов с временными учетными данными
+func (s *Server) handleICEConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	servers, err := s.db.ListICEServers(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load ICE servers"})
+		return
+	}
+
+	iceServers := s.buildICEServers(servers, r.URL.Query().Get("user"))
+
+	// Клиент применяет ту же политику, что и сервер: без TURN или только через relay
+	allowTURN := s.policy.Allows(transport.TrafficCalls, transport.PathTURN)
+	var allowed []webrtc.ICEServer
+	for _, ice := range iceServers {
+		if allowTURN || !hwebrtc.IsTURNURL(ice.URLs[0]) {
+			allowed = append(allowed, ice)
+		}
+	}
+	policy := webrtc.ICETransportPolicyAll
+	if !s.policy.Allows(transport.TrafficCalls, transport.PathDirect) {
+		policy = webrtc.ICETransportPolicyRelay
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"ice_servers":          allowed,
+		"ice_transport_policy": policy.String(),
+		"ttl":                  int64(s.config.TURNCredentialTTL.Seconds()),
+	})
+}
+
+// buildICEServers формирует список для клиента: только включенные и здоровые серверы
+// (если здоровых нет - все включенные), в порядке приоритета и задержки.
+func (s *Server) buildICEServers(servers []*storage.ICEServer, user string) []webrtc.ICEServer {
+	var healthy, enabled []webrtc.ICEServer
+	for _, srv := range servers {
+		if !srv.Enabled {
+			continue
+		}
+
+		ice := webrtc.ICEServer{URLs: []string{srv.URL}}
+		if srv.Secret != "" {
+			ice.Username, ice.Credential = hwebrtc.EphemeralCredentials(srv.Secret, user, s.config.TURNCredentialTTL)
+		} else if srv.Username != "" {
+			ice.Username = srv.Username
+			ice.Credential = srv.Credential
+		}
+
+		enabled = append(enabled, ice)
+		if srv.Healthy {
+			healthy = append(healthy, ice)
+		}
+	}
+
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return enabled
+}
+
+func (s *Server) handleAdminICEServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		servers, err := s.db.ListICEServers(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list ICE servers"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "ice_servers": servers})
+
+	case http.MethodPost:
+		var req storage.ICEServer
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if req.URL == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "URL required"})
+			return
+		}
+		req.ID = ""
+		if err := s.db.CreateICEServer(r.Context(), &req); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create ICE server"})
+			return
+		}
+		go s.checkICEServer(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "ice_server": req})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+func (s *Server) handleAdminICEServer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/ice-servers/")
+
+	// POST /api/admin/ice-servers/{id}/check - внеочередная проверка доступности
+	if strings.HasSuffix(id, "/check") && r.Method == http.MethodPost {
+		server, err := s.db.GetICEServer(r.Context(), strings.TrimSuffix(id, "/check"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "ICE server not found"})
+			return
+		}
+		s.checkICEServer(server)
+		server, _ = s.db.GetICEServer(r.Context(), server.ID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "ice_server": server})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		server, err := s.db.GetICEServer(r.Context(), id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "ICE server not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "ice_server": server})
+
+	case http.MethodPut:
+		var req storage.ICEServer
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		req.ID = id
+		if err := s.db.UpdateICEServer(r.Context(), &req); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update ICE server"})
+			return
+		}
+		go s.checkICEServer(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	case http.MethodDelete:
+		if err := s.db.DeleteICEServer(r.Context(), id); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete ICE server"})
+			return
+		}
+		s.refreshCallICEServers()
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// seedICEServers переносит ICE_SERVERS из конфигурации в БД, если таблица пуста
+func (s *Server) seedICEServers() {
+	servers, err := s.db.ListICEServers(context.Background())
+	if err != nil {
+		log.Printf("Failed to load ICE servers: %v", err)
+		return
+	}
+	if len(servers) > 0 {
+		return
+	}
+
+	for i, url := range s.config.ICEServers {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		server := &storage.ICEServer{URL: url, Priority: i, Enabled: true}
+		if err := s.db.CreateICEServer(context.Background(), server); err != nil {
+			log.Printf("Failed to seed ICE server %s: %v", url, err)
+		}
+	}
+}
+
+// runICEHealthChecks периодически проверяет все ICE серверы
+func (s *Server) runICEHealthChecks() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		servers, err := s.db.ListICEServers(context.Background())
+		if err != nil {
+			log.Printf("ICE health check: failed to list servers: %v", err)
+		}
+		for _, server := range servers {
+			if server.Enabled {
+				s.checkICEServer(server)
+			}
+		}
+		s.refreshCallICEServers()
+
+		<-ticker.C
+	}
+}
+
+// checkICEServer проверяет один сервер и сохраняет результат
+func (s *Server) checkICEServer(server *storage.ICEServer) {
+	latency, err := hwebrtc.CheckICEServer(context.Background(), server.URL)
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		log.Printf("ICE server %s is unhealthy: %v", server.URL, err)
+	}
+
+	if err := s.db.UpdateICEServerHealth(context.Background(), server.ID, err == nil, latency, lastError); err != nil {
+		log.Printf("Failed to save ICE server health: %v", err)
+	}
+}
+
+// refreshCallICEServers передает актуальный список серверов менеджеру звонков
+func (s *Server) refreshCallICEServers() {
+	servers, err := s.db.ListICEServers(context.Background())
+	if err != nil {
+		return
+	}
+	s.callManager.SetICEServers(s.buildICEServers(servers, "hydra-server"))
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/i18n"
+	"hydra/pkg/mail"
+	"hydra/pkg/storage"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// identifierChangeTTL - за какое время нужно подтвердить смену идентификатора обоими кодами
+const identifierChangeTTL = 15 * time.Minute
+
+// splitIdentifierPath разбирает "{id}/identifier" и "{id}/identifier/confirm"
+func splitIdentifierPath(path string) (userID, action string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[1] != "identifier" || len(parts) > 3 {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		if parts[2] != "confirm" {
+			return "", "", false
+		}
+		action = parts[2]
+	}
+	return parts[0], action, true
+}
+
+// identifierValue возвращает идентификатор пользователя указанного вида
+func identifierValue(user *storage.User, kind string) string {
+	if kind == storage.IdentifierEmail {
+		return user.Email
+	}
+	return user.Phone
+}
+
+// validIdentifier проверяет формат нового идентификатора
+func validIdentifier(kind, value string) string {
+	switch kind {
+	case storage.IdentifierEmail:
+		if !strings.Contains(value, "@") {
+			return "Invalid email"
+		}
+	case storage.IdentifierPhone:
+		if value == "" || strings.Contains(value, "@") {
+			return "Invalid phone"
+		}
+	default:
+		return "kind must be phone or email"
+	}
+	return ""
+}
+
+// verificationCode возвращает случайный 6-значный код подтверждения телефона или email.
+// Код не выводится из времени: такой код можно предсказать, а коды на старый и новый
+// идентификатор при смене выдаются одновременно.
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// sendIdentifierCode сохраняет и отправляет код подтверждения на телефон или email на языке locale
+func (s *Server) sendIdentifierCode(kind, to, locale string) error {
+	code, err := verificationCode()
+	if err != nil {
+		return err
+	}
+
+	if kind == storage.IdentifierEmail {
+		if err := s.db.CreateEmailVerification(context.Background(), to, code); err != nil {
+			return err
+		}
+		if s.mailer == nil {
+			log.Printf("Email config missing. Code for %s: %s", to, code)
+			return nil
+		}
+		go func() {
+			if err := s.sendTemplatedEmail(to, mail.Verification, verificationEmail(locale, i18n.IdentifierChangeEmail, i18n.IdentifierChangeIntro, code)); err != nil {
+				log.Printf("Failed to send email to %s: %v", to, err)
+			}
+		}()
+		return nil
+	}
+
+	if err := s.db.CreateSMSVerification(context.Background(), to, code); err != nil {
+		return err
+	}
+	go func() {
+		if err := s.sendSMS(to, i18n.Format(locale, i18n.IdentifierChangeSMS, code)); err != nil {
+			log.Printf("Failed to send SMS to %s: %v", to, err)
+		}
+	}()
+	return nil
+}
+
+// checkIdentifierCode проверяет код, отправленный на телефон или email; при блокировке
+// проверки кодов (CODE_MAX_ATTEMPTS) код не принимается
+func (s *Server) checkIdentifierCode(kind, to, code string) bool {
+	if _, locked := s.bruteForce.codes.Locked(strings.ToLower(to)); locked {
+		return false
+	}
+	var valid bool
+	var err error
+	if kind == storage.IdentifierEmail {
+		valid, err = s.db.ValidateEmailVerification(context.Background(), to, code)
+	} else {
+		valid, err = s.db.ValidateSMSVerification(context.Background(), to, code)
+	}
+	s.codeChecked(to, err == nil && valid)
+	return err == nil && valid
+}
+
+// handleUserIdentifier меняет телефон или email аккаунта:
+//   - POST /api/users/{id}/identifier {kind, value} - начать смену: коды уходят на текущий
+//     идентификатор (или другой, если этого вида еще нет) и на новый
+//   - POST /api/users/{id}/identifier/confirm {old_code, new_code} - подтвердить смену
+//   - GET - незавершенная смена, DELETE - отменить ее
+//
+// Все действия доступны только самому пользователю по токену входа.
+// После смены контактам приходит событие identifier.changed: сменился идентификатор,
+// ключ и ID пользователя остались прежними, поэтому переписку заново проверять не нужно.
+func (s *Server) handleUserIdentifier(w http.ResponseWriter, r *http.Request, userID, action string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	user, err := s.db.GetUser(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		change, err := s.db.GetIdentifierChange(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load identifier change"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "change": change})
+
+	case action == "" && r.Method == http.MethodPost:
+		s.startIdentifierChange(w, r, user)
+
+	case action == "" && r.Method == http.MethodDelete:
+		if _, err := s.db.DeleteIdentifierChange(r.Context(), userID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to cancel identifier change"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	case action == "confirm" && r.Method == http.MethodPost:
+		s.confirmIdentifierChange(w, r, user)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+func (s *Server) startIdentifierChange(w http.ResponseWriter, r *http.Request, user *storage.User) {
+	var req struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	req.Value = strings.TrimSpace(req.Value)
+	if msg := validIdentifier(req.Kind, req.Value); msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": msg})
+		return
+	}
+
+	change := &storage.IdentifierChange{
+		UserID:    user.ID,
+		Kind:      req.Kind,
+		OldValue:  identifierValue(user, req.Kind),
+		NewValue:  req.Value,
+		ExpiresAt: time.Now().Add(identifierChangeTTL),
+	}
+	if change.NewValue == change.OldValue {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Identifier is unchanged"})
+		return
+	}
+
+	// Владение аккаунтом подтверждается текущим идентификатором того же вида, а если его нет - другим
+	change.VerifyKind, change.VerifyValue = change.Kind, change.OldValue
+	if change.VerifyValue == "" {
+		change.VerifyKind = storage.IdentifierPhone
+		if change.Kind == storage.IdentifierPhone {
+			change.VerifyKind = storage.IdentifierEmail
+		}
+		change.VerifyValue = identifierValue(user, change.VerifyKind)
+	}
+	if change.VerifyValue == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Account has no identifier to verify the change"})
+		return
+	}
+
+	var owner *storage.User
+	var err error
+	if change.Kind == storage.IdentifierEmail {
+		owner, err = s.db.GetUserByEmail(r.Context(), change.NewValue)
+	} else {
+		owner, err = s.db.GetUserByPhone(r.Context(), change.NewValue)
+	}
+	if err == nil && owner.ID != user.ID {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Identifier is already in use"})
+		return
+	}
+
+	if err := s.db.SaveIdentifierChange(r.Context(), change); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to start identifier change"})
+		return
+	}
+	locale := s.userLocale(r, user.ID)
+	if err := s.sendIdentifierCode(change.VerifyKind, change.VerifyValue, locale); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
+		return
+	}
+	if err := s.sendIdentifierCode(change.Kind, change.NewValue, locale); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
+		return
+	}
+
+	log.Printf("User %s started %s change", user.ID, change.Kind)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "change": change})
+}
+
+func (s *Server) confirmIdentifierChange(w http.ResponseWriter, r *http.Request, user *storage.User) {
+	var req struct {
+		OldCode string `json:"old_code"`
+		NewCode string `json:"new_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+
+	change, err := s.db.GetIdentifierChange(r.Context(), user.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load identifier change"})
+		return
+	}
+	if change == nil || time.Now().After(change.ExpiresAt) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "No pending identifier change"})
+		return
+	}
+
+	// Оба кода проверяются всегда, чтобы по ответу нельзя было узнать, какой из них верный
+	oldValid := s.checkIdentifierCode(change.VerifyKind, change.VerifyValue, req.OldCode)
+	newValid := s.checkIdentifierCode(change.Kind, change.NewValue, req.NewCode)
+	if !oldValid || !newValid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid verification code"})
+		return
+	}
+
+	contacts, err := s.db.ApplyIdentifierChange(r.Context(), change)
+	if err != nil {
+		log.Printf("Failed to change %s of user %s: %v", change.Kind, user.ID, err)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to change identifier"})
+		return
+	}
+
+	// Контакты узнают только о самом факте смены, новый идентификатор им не передается
+	notice := map[string]interface{}{"user_id": user.ID, "kind": change.Kind, "identity_key_changed": false}
+	for _, contact := range contacts {
+		s.appendEvent(contact, storage.EventIdentifierChanged, notice)
+	}
+	s.appendEvent(user.ID, storage.EventIdentifierChanged, map[string]interface{}{
+		"user_id": user.ID, "kind": change.Kind, "value": change.NewValue, "identity_key_changed": false,
+	})
+
+	log.Printf("User %s changed %s, %d contacts notified", user.ID, change.Kind, len(contacts))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "kind": change.Kind, "value": change.NewValue, "notified": len(contacts)})
+}
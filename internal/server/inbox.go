@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hydra/pkg/folders"
+	"hydra/pkg/storage"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Прием сообщений. Транспорты передают принятые сообщения серверу: внешние (скрытый
+// сервис, ретранслятор) - в POST /api/messages/incoming с подписью INBOUND_SECRET, mesh -
+// напрямую. Транспортное шифрование снимает сам транспорт (например, DTN бандлы mesh);
+// body хранится как есть - при сквозном шифровании его расшифровывает клиент. Принятое
+// сообщение попадает во входящие получателя и в его журнал событий (WebSocket, SSE), а
+// клиент забирает входящие через /api/inbox и подтверждает их получение.
+
+const (
+	// inboundSignatureHeader - заголовок с hex(HMAC-SHA256(INBOUND_SECRET, тело запроса))
+	inboundSignatureHeader = "X-Hydra-Signature"
+	// inboxDedupWindow - сколько подтвержденные входящие защищают от повторной доставки
+	inboxDedupWindow = 7 * 24 * time.Hour
+	// maxInboxPage - наибольшее число входящих в одном ответе
+	maxInboxPage = 100
+)
+
+var (
+	errInboundUnknownRecipient = errors.New("recipient not found")
+	errInboundUnavailable      = errors.New("recipient is unavailable")
+	errInboundStore            = errors.New("failed to store message")
+)
+
+// inboundMessage - сообщение, принятое транспортом. ID назначает отправитель, по нему
+// отбрасываются повторные доставки; SentAt - время отправки в миллисекундах.
+type inboundMessage struct {
+	ID     string `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Body   string `json:"body"`
+	SentAt int64  `json:"sent_at"`
+}
+
+// receiveInbound проверяет сообщение, принятое транспортом, и кладет его во входящие
+// получателя. Возвращает false без ошибки, если сообщение уже было доставлено или
+// отброшено, потому что получатель заблокировал отправителя.
+func (s *Server) receiveInbound(ctx context.Context, data []byte, transport string) (*storage.InboxMessage, bool, error) {
+	if max := s.config.InboundMaxBytes; max > 0 && len(data) > max {
+		return nil, false, fmt.Errorf("message exceeds %d bytes", max)
+	}
+	var in inboundMessage
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, false, fmt.Errorf("invalid message: %w", err)
+	}
+	if in.ID == "" || in.From == "" || in.To == "" || in.Body == "" {
+		return nil, false, fmt.Errorf("id, from, to and body required")
+	}
+	if _, err := s.db.GetUser(ctx, in.To); err != nil {
+		return nil, false, errInboundUnknownRecipient
+	}
+	if state := s.accountState(in.To); state != nil && state.InboundMode == storage.InboundBounce {
+		return nil, false, errInboundUnavailable
+	}
+	if s.blockedBy(in.To, in.From) {
+		if s.config.BlockedSenderMode == storage.BlockedReject {
+			return nil, false, errInboundUnavailable
+		}
+		return nil, false, nil
+	}
+
+	msg := &storage.InboxMessage{
+		UserID:    in.To,
+		MessageID: in.ID,
+		SenderID:  in.From,
+		Body:      in.Body,
+		Transport: transport,
+		SentAt:    time.UnixMilli(in.SentAt),
+	}
+	if in.SentAt == 0 {
+		msg.SentAt = time.Now()
+	}
+	delivered, err := s.db.DeliverInbox(ctx, msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errInboundStore, err)
+	}
+	if !delivered {
+		return nil, false, nil
+	}
+
+	payload := map[string]interface{}{
+		"id":          msg.MessageID,
+		"inbox_id":    msg.ID,
+		"from":        msg.SenderID,
+		"to":          msg.UserID,
+		"body":        msg.Body,
+		"sent_at":     msg.SentAt,
+		"received_at": msg.ReceivedAt,
+	}
+	s.appendEvent(msg.UserID, storage.EventMessageCreated, payload)
+	s.recordNotification(msg.UserID, storage.NotificationMessage, msg.SenderID)
+	s.fileMessages(msg.UserID, folders.Message{ConversationID: msg.SenderID, SenderID: msg.SenderID, Body: msg.Body})
+	return msg, true, nil
+}
+
+// receiveMesh принимает сообщения mesh сети. Сообщения расходятся по всем узлам,
+// поэтому адресованные не нашим пользователям и не похожие на сообщения пропускаются.
+func (s *Server) receiveMesh(data []byte) {
+	_, _, err := s.receiveInbound(context.Background(), data, "mesh")
+	if err != nil && !errors.Is(err, errInboundUnknownRecipient) && json.Valid(data) {
+		log.Printf("Rejected mesh message: %v", err)
+	}
+}
+
+// receiveReplyMessages принимает входящие, которые скрытый сервис вернул в ответе
+// на отправку ({"messages": [...]})
+func (s *Server) receiveReplyMessages(ctx context.Context, reply []byte, transport string) {
+	var batch struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(reply, &batch); err != nil {
+		return
+	}
+	for _, data := range batch.Messages {
+		if _, _, err := s.receiveInbound(ctx, data, transport); err != nil {
+			log.Printf("Rejected message from %s reply: %v", transport, err)
+		}
+	}
+}
+
+// validInboundSignature проверяет подпись тела запроса секретом INBOUND_SECRET
+func (s *Server) validInboundSignature(r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get(inboundSignatureHeader))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.config.InboundSecret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// handleIncoming обрабатывает POST /api/messages/incoming от транспорта: тело - одно
+// сообщение {id, from, to, body, sent_at}. Повторная доставка успешна (duplicate), чтобы
+// транспорт не повторял ее снова.
+func (s *Server) handleIncoming(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if s.config.InboundSecret == "" || s.db == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Incoming messages are not accepted"})
+		return
+	}
+
+	// Лишний байт показывает, что тело больше лимита
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(s.config.InboundMaxBytes)+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to read request body"})
+		return
+	}
+	if !s.validInboundSignature(r, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid signature"})
+		return
+	}
+
+	transport := r.URL.Query().Get("transport")
+	if transport == "" {
+		transport = "external"
+	}
+	msg, delivered, err := s.receiveInbound(r.Context(), body, transport)
+	switch {
+	case errors.Is(err, errInboundUnknownRecipient):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recipient not found"})
+	case errors.Is(err, errInboundUnavailable):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recipient is unavailable"})
+	case errors.Is(err, errInboundStore):
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to store message"})
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+	case !delivered:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "duplicate": true})
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "delivery_id": msg.ID})
+	}
+}
+
+// handleInbox обрабатывает GET /api/inbox?limit=N: неподтвержденные входящие
+// пользователя по токену входа в порядке поступления
+func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > maxInboxPage {
+		limit = maxInboxPage
+	}
+	messages, err := s.db.ListInbox(r.Context(), userID, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list inbox"})
+		return
+	}
+	if messages == nil {
+		messages = []*storage.InboxMessage{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "messages": messages})
+}
+
+// handleInboxAck обрабатывает POST /api/inbox/ack {ids}: клиент получил входящие, и
+// они больше не выдаются
+func (s *Server) handleInboxAck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 || len(req.IDs) > maxInboxPage {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("ids required (at most %d)", maxInboxPage)})
+		return
+	}
+	acked, err := s.db.AckInbox(r.Context(), userID, req.IDs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to ack inbox"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "acked": acked})
+}
+
+// runInboxCleanup раз в сутки удаляет подтвержденные входящие старше окна дедупликации
+func (s *Server) runInboxCleanup() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.db.DeleteAckedInbox(context.Background(), time.Now().Add(-inboxDedupWindow))
+		if err != nil {
+			log.Printf("Failed to clean up inbox: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d acknowledged inbox messages", n)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/i18n"
+	"hydra/pkg/mail"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Управление приглашениями. Пользователь создает приглашения от своего имени и видит
+// только их; администратор (ADMIN_TOKEN) видит и отзывает все. Приглашение может быть
+// многоразовым (max_uses, 0 - без ограничения) и действовать дольше INVITE_TTL, но не
+// дольше INVITE_MAX_TTL. Приглашенный по многоразовому приглашению подтверждает свой
+// email или телефон кодом (proof из /sms/verify или /email/verify).
+
+// inviteLink возвращает ссылку на регистрацию по приглашению на внешнем адресе
+// веб-интерфейса (PUBLIC_URL)
+func (s *Server) inviteLink(token string) string {
+	return strings.TrimRight(s.config.PublicURL, "/") + "/register.html?token=" + url.QueryEscape(token)
+}
+
+// Состояния доставки ссылки приглашения
+const (
+	inviteSent          = "sent"
+	inviteFailed        = "failed"
+	inviteNotConfigured = "not_configured" // SMTP не настроен
+	inviteNotSent       = "not_sent"       // приглашение без контакта или send: false
+)
+
+// inviteDelivery - результат отправки ссылки приглашения на его email или телефон
+type inviteDelivery struct {
+	Channel string `json:"channel,omitempty"` // email или sms
+	Status  string `json:"status"`
+}
+
+// deliverInvite отправляет ссылку приглашения на его контакт письмом или SMS на языке
+// locale. Приглашение без контакта (многоразовое) раздается ссылкой и не отправляется.
+func (s *Server) deliverInvite(inv *storage.Invite, locale string) *inviteDelivery {
+	if inv.ContactInfo == "" {
+		return &inviteDelivery{Status: inviteNotSent}
+	}
+
+	inviter := i18n.Format(locale, i18n.InviteAdmin)
+	if inv.CreatedBy != "" {
+		if user, err := s.db.GetUser(context.Background(), inv.CreatedBy); err == nil && user.Name != "" {
+			inviter = user.Name
+		}
+	}
+	link := s.inviteLink(inv.Token)
+
+	var d *inviteDelivery
+	var err error
+	if strings.Contains(inv.ContactInfo, "@") {
+		d = &inviteDelivery{Channel: "email"}
+		if s.mailer == nil {
+			log.Printf("Email config missing. Invite link for %s: %s", inv.ContactInfo, link)
+			d.Status = inviteNotConfigured
+			return d
+		}
+		expires := inv.ExpiresAt.Format("2006-01-02")
+		err = s.sendTemplatedEmail(inv.ContactInfo, mail.Invite, &mail.Content{
+			Lang:        locale,
+			Subject:     i18n.Format(locale, i18n.InviteSubject, inviter),
+			Text:        i18n.Format(locale, i18n.InviteEmail, inviter, link, expires),
+			Paragraphs:  []string{i18n.Format(locale, i18n.InviteIntro, inviter)},
+			ActionURL:   link,
+			ActionLabel: i18n.Format(locale, i18n.InviteButton),
+			Note:        i18n.Format(locale, i18n.InviteValidUntil, expires),
+		})
+	} else {
+		d = &inviteDelivery{Channel: "sms"}
+		err = s.sendSMS(inv.ContactInfo, i18n.Format(locale, i18n.InviteSMS, inviter, link))
+	}
+	if err != nil {
+		log.Printf("Failed to send invite to %s: %v", inv.ContactInfo, err)
+		d.Status = inviteFailed
+		return d
+	}
+	d.Status = inviteSent
+	return d
+}
+
+// inviteExpiry возвращает срок действия приглашения, запрошенный expiresIn
+// (длительность, например 72h); пустая строка - INVITE_TTL
+func (s *Server) inviteExpiry(expiresIn string) (time.Time, error) {
+	ttl := s.config.InviteTTL
+	if expiresIn != "" {
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("expires_in must be a positive duration")
+		}
+		ttl = d
+	}
+	if ttl <= 0 {
+		ttl = storage.DefaultInviteTTL
+	}
+	if s.config.InviteMaxTTL > 0 && ttl > s.config.InviteMaxTTL {
+		return time.Time{}, fmt.Errorf("expires_in must not exceed %s", s.config.InviteMaxTTL)
+	}
+	return time.Now().Add(ttl), nil
+}
+
+// inviteActor определяет, кто управляет приглашениями: администратор (admin) или
+// пользователь по токену входа. При ошибке пишет ответ и возвращает false.
+func (s *Server) inviteActor(w http.ResponseWriter, r *http.Request) (userID string, admin, ok bool) {
+	if _, admin := s.adminActor(r); admin {
+		return "", true, true
+	}
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return "", false, false
+	}
+	return userID, false, true
+}
+
+// handleInvites обрабатывает /api/invites: GET - список приглашений от новых к старым
+// постранично (администратору - все или автора ?created_by=), POST {email|phone, expires_in, max_uses, send} - новое
+// приглашение. Ссылка приглашения с контактом отправляется на него письмом или SMS
+// (send: false - не отправлять). Администратор может указать автора приглашения в inviter_id.
+func (s *Server) handleInvites(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, admin, ok := s.inviteActor(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		page, ok := parsePage(w, r, defaultPageLimit)
+		if !ok {
+			return
+		}
+		createdBy := userID
+		if admin {
+			createdBy = r.URL.Query().Get("created_by")
+		}
+		invites, err := s.db.ListInvites(r.Context(), createdBy)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list invites"})
+			return
+		}
+		invites, next := paginate(invites, page, func(inv *storage.Invite) string { return newestFirstKey(inv.CreatedAt, inv.Token) })
+		if invites == nil {
+			invites = []*storage.Invite{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "invites": invites, "next_cursor": next})
+
+	case http.MethodPost:
+		var req struct {
+			Email     string `json:"email"`
+			Phone     string `json:"phone"`
+			ExpiresIn string `json:"expires_in"`
+			MaxUses   *int   `json:"max_uses"`
+			InviterID string `json:"inviter_id"`
+			Send      *bool  `json:"send"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+
+		contactInfo, err := inviteContact(req.Email, req.Phone)
+		if err != nil {
+			s.writeValidationErrors(w, r, err)
+			return
+		}
+		inv := &storage.Invite{ContactInfo: contactInfo, CreatedBy: userID, MaxUses: 1}
+		if admin {
+			inv.CreatedBy = req.InviterID
+		}
+		if req.MaxUses != nil {
+			inv.MaxUses = *req.MaxUses
+		}
+		if inv.MaxUses < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "max_uses must not be negative"})
+			return
+		}
+		// Многоразовое приглашение раздается ссылкой: контакт указывает каждый
+		// регистрирующийся
+		if inv.MaxUses == 1 && inv.ContactInfo == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Email or phone required"})
+			return
+		}
+		if inv.MaxUses != 1 && inv.ContactInfo != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Multi-use invites are not bound to an email or phone"})
+			return
+		}
+		expiresAt, err := s.inviteExpiry(req.ExpiresIn)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		inv.ExpiresAt = expiresAt
+
+		if err := s.db.IssueInvite(r.Context(), inv); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create invite"})
+			return
+		}
+		delivery := &inviteDelivery{Status: inviteNotSent}
+		if req.Send == nil || *req.Send {
+			delivery = s.deliverInvite(inv, s.requestLocale(r))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"invite":      inv,
+			"invite_link": s.inviteLink(inv.Token),
+			"delivery":    delivery,
+		})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleInviteItem обрабатывает /api/invites/{token}: GET - приглашение и
+// зарегистрированные по нему пользователи, DELETE - отзыв. Доступно автору и администратору.
+func (s *Server) handleInviteItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, admin, ok := s.inviteActor(w, r)
+	if !ok {
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/api/invites/")
+	inv, err := s.db.GetInvite(r.Context(), token)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load invite"})
+		return
+	}
+	// Чужое приглашение неотличимо от несуществующего
+	if inv == nil || (!admin && inv.CreatedBy != userID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invite not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		uses, err := s.db.ListInviteUses(r.Context(), token)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load invite uses"})
+			return
+		}
+		if uses == nil {
+			uses = []*storage.InviteUse{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "invite": inv, "uses": uses})
+
+	case http.MethodDelete:
+		if _, err := s.db.RevokeInvite(r.Context(), token); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke invite"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"net/http"
+	"strings"
+)
+
+// Ключи сквозного шифрования: устройство публикует свои открытые ключи, собеседник
+// перед первым сообщением забирает набор ключей каждого устройства получателя. Ключи
+// публикует только владелец; получить наборы может любой вошедший пользователь.
+
+const (
+	maxKeySize       = 256 // байт в ключе или подписи
+	maxPreKeysUpload = 100 // одноразовых ключей в одном запросе
+)
+
+// validKey сообщает, что ключ - непустая строка base64 разумной длины
+func validKey(key string) bool {
+	data, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(data) > 0 && len(data) <= maxKeySize
+}
+
+// handleUserKeys обрабатывает /api/users/{id}/keys[/{device_id}]:
+// GET - наборы ключей устройств (по одному одноразовому ключу на устройство),
+// PUT {identity_key, signed_prekey, one_time_prekeys} - публикация ключей устройства,
+// DELETE - удаление ключей устройства
+func (s *Server) handleUserKeys(w http.ResponseWriter, r *http.Request, userID, deviceID string) {
+	caller, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if r.Method != http.MethodGet && caller != userID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the owner can change device keys"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bundles, err := s.db.TakeKeyBundles(r.Context(), userID, deviceID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load keys"})
+			return
+		}
+		if len(bundles) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "No published keys"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "bundles": bundles})
+
+	case http.MethodPut:
+		if deviceID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device ID required"})
+			return
+		}
+		var req struct {
+			IdentityKey    string            `json:"identity_key"`
+			SignedPreKey   *storage.PreKey   `json:"signed_prekey"`
+			OneTimePreKeys []*storage.PreKey `json:"one_time_prekeys"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if !validKey(req.IdentityKey) || req.SignedPreKey == nil || !validKey(req.SignedPreKey.PublicKey) || !validKey(req.SignedPreKey.Signature) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "identity_key and signed_prekey with signature required (base64)"})
+			return
+		}
+		if len(req.OneTimePreKeys) > maxPreKeysUpload {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many one-time prekeys"})
+			return
+		}
+		for _, k := range req.OneTimePreKeys {
+			if k == nil || !validKey(k.PublicKey) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid one-time prekey"})
+				return
+			}
+		}
+
+		keys := &storage.KeyBundle{UserID: userID, DeviceID: deviceID, IdentityKey: req.IdentityKey, SignedPreKey: req.SignedPreKey}
+		if err := s.db.PublishKeys(r.Context(), keys, req.OneTimePreKeys); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save keys"})
+			return
+		}
+		// Клиент пополняет запас одноразовых ключей, когда он подходит к концу
+		remaining, err := s.db.CountPreKeys(r.Context(), userID, deviceID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to count prekeys"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "one_time_prekeys": remaining})
+
+	case http.MethodDelete:
+		if deviceID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Device ID required"})
+			return
+		}
+		if err := s.db.DeleteKeys(r.Context(), userID, deviceID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete keys"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// splitKeysPath разбирает "{id}/keys[/{device_id}]"
+func splitKeysPath(path string) (userID, deviceID string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "keys" {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		deviceID = parts[2]
+	}
+	return parts[0], deviceID, true
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Привязка нового устройства по QR-коду. Устройство, вошедшее в аккаунт, запрашивает
+// одноразовый токен (POST /api/devices/link/start) и показывает QR-код со ссылкой
+// link_uri, дописав к ней фрагмент #key=... - ключ, которым оно зашифровало переданный
+// key_material (ключи сквозного шифрования). Новое устройство сканирует код и обменивает
+// токен на сессию (POST /api/devices/link/complete), получая key_material и расшифровывая
+// его ключом из фрагмента. Пароль и коды на новом устройстве не вводятся, а сервер не
+// видит ни ключа, ни ключей шифрования.
+
+// maxKeyMaterial - наибольший размер зашифрованных ключей в приглашении
+const maxKeyMaterial = 64 << 10
+
+// handleDeviceLinkStart обрабатывает POST /api/devices/link/start {key_material}: токен
+// привязки устройства, действующий DEVICE_LINK_TTL
+func (s *Server) handleDeviceLinkStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		KeyMaterial string `json:"key_material"`
+	}
+	// Тело необязательно: без key_material устройство получает только сессию
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKeyMaterial+4<<10)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if len(req.KeyMaterial) > maxKeyMaterial {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Key material is too large"})
+		return
+	}
+
+	link, err := s.db.CreateDeviceLink(r.Context(), userID, req.KeyMaterial, s.config.DeviceLinkTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to start device linking"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"token":      link.Token,
+		"expires_at": link.ExpiresAt,
+		"link_uri":   s.deviceLinkURI(link.Token),
+	})
+}
+
+// deviceLinkURI - ссылка для QR-кода: адрес сервера и токен привязки
+func (s *Server) deviceLinkURI(token string) string {
+	return "hydra://link?server=" + url.QueryEscape(strings.TrimRight(s.config.PublicURL, "/")) + "&token=" + url.QueryEscape(token)
+}
+
+// handleDeviceLinkComplete обрабатывает POST /api/devices/link/complete {token, device:
+// {name, platform, capabilities}}: новое устройство регистрируется в аккаунте и получает
+// сессию входа и key_material. Токен действует один раз.
+func (s *Server) handleDeviceLinkComplete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Token  string         `json:"token"`
+		Device storage.Device `json:"device"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Token required"})
+		return
+	}
+
+	link, err := s.db.ConsumeDeviceLink(r.Context(), req.Token)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to complete device linking"})
+		return
+	}
+	if link == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid or expired link token"})
+		return
+	}
+	// Аккаунт могли заблокировать, пока QR-код был на экране
+	if disabled, err := s.db.GetDisabledAccount(r.Context(), link.UserID); err != nil || disabled != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Account disabled"})
+		return
+	}
+	user, err := s.db.GetUser(r.Context(), link.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	device := req.Device
+	device.ID = "device-" + ids.New()
+	device.UserID = user.ID
+	if err := s.db.UpsertDevice(r.Context(), &device); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to register device"})
+		return
+	}
+	ttl, refreshTTL := s.sessionTTLs()
+	sess, err := s.db.CreateSession(r.Context(), user.ID, device.ID, sessionClient(r), ttl, refreshTTL)
+	if err != nil {
+		log.Printf("Failed to create session for device %s: %v", device.ID, err)
+	}
+	s.touchUser(user.ID)
+	s.appendEvent(user.ID, storage.EventDevices, map[string]interface{}{"change": "linked", "device": device})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"user":         user,
+		"device":       device,
+		"signaling":    s.signalingSession(user.ID),
+		"session":      sess,
+		"key_material": link.KeyMaterial,
+	})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"hydra/pkg/storage"
+	"net/http"
+)
+
+// Облегченный режим для каналов 2G и DNS-туннелей: только текст. Клиент запрашивает его
+// для сессии параметром ?lite=1 или заголовком X-Hydra-Lite: 1, сервер подтверждает тем же
+// заголовком в ответе (для WebSocket - в ответе на рукопожатие). В сессии не доставляются
+// квитанции, из событий убираются аватары, превью и вложения, контакты отдаются без аватаров.
+// Вложения клиент загружает только по явному запросу пользователя; устройства, объявившие
+// Lite в возможностях, сервер исключает из рассылки медиа.
+
+const liteHeader = "X-Hydra-Lite"
+
+// liteDroppedEvents - события, которые в облегченной сессии не доставляются
+var liteDroppedEvents = map[string]bool{
+	storage.EventReceipt:      true,
+	storage.EventReceiptBatch: true,
+}
+
+// liteStrippedFields - поля полезной нагрузки событий, которые убираются в облегченной сессии
+var liteStrippedFields = []string{"avatar", "preview", "link_preview", "thumbnail", "media", "attachments"}
+
+// liteRequested сообщает, запросил ли клиент облегченный режим для этого запроса
+func liteRequested(r *http.Request) bool {
+	value := r.URL.Query().Get("lite")
+	if value == "" {
+		value = r.Header.Get(liteHeader)
+	}
+	return value == "1" || value == "true"
+}
+
+// negotiateLite подтверждает облегченный режим заголовком ответа и возвращает,
+// включен ли он для сессии
+func negotiateLite(w http.ResponseWriter, r *http.Request) bool {
+	if !liteRequested(r) {
+		return false
+	}
+	w.Header().Set(liteHeader, "1")
+	return true
+}
+
+// liteEvent возвращает событие для облегченной сессии: nil - событие не доставляется.
+// Из объекта полезной нагрузки убираются тяжелые поля, вместо вложений остается признак
+// media_omitted, чтобы клиент мог предложить загрузить их вручную.
+func liteEvent(event *storage.Event) *storage.Event {
+	if liteDroppedEvents[event.Type] {
+		return nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return event
+	}
+	stripped := false
+	for _, field := range liteStrippedFields {
+		if _, ok := payload[field]; ok {
+			delete(payload, field)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return event
+	}
+	payload["media_omitted"] = json.RawMessage("true")
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return event
+	}
+	c := *event
+	c.Payload = data
+	return &c
+}
+
+// liteEvents применяет liteEvent к списку событий
+func liteEvents(events []*storage.Event) []*storage.Event {
+	result := make([]*storage.Event, 0, len(events))
+	for _, event := range events {
+		if e := liteEvent(event); e != nil {
+			result = append(result, e)
+		}
+	}
+	return result
+}
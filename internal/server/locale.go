@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/i18n"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Тексты ошибок API пишутся в обработчиках по-английски; withLocale переводит поле
+// error JSON ответов с ошибкой на язык клиента (см. pkg/i18n). Язык - выбранный
+// пользователем (PUT /api/users/{id}/locale), иначе - по заголовку Accept-Language.
+
+// requestLocale возвращает язык ответа на запрос: язык пользователя из токена входа,
+// если он выбран, иначе - по Accept-Language
+func (s *Server) requestLocale(r *http.Request) string {
+	userID := ""
+	if s.db != nil && r.Header.Get("Authorization") != "" {
+		userID, _ = s.bearerUser(r)
+	}
+	return s.userLocale(r, userID)
+}
+
+// userLocale возвращает выбранный пользователем язык, иначе - язык по Accept-Language запроса
+func (s *Server) userLocale(r *http.Request, userID string) string {
+	if s.db != nil && userID != "" {
+		locale, err := s.db.GetUserLocale(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to load locale of %s: %v", userID, err)
+		}
+		if i18n.Supported(locale) {
+			return locale
+		}
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// withLocale переводит сообщения об ошибках в JSON ответах API. Язык определяется только
+// для ответов с ошибкой; успешные ответы и потоки проходят без буферизации.
+func (s *Server) withLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(apiPath(r.URL.Path), signalingPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lw := &localeWriter{ResponseWriter: w, locale: func() string { return s.requestLocale(r) }}
+		next.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+// localeWriter задерживает JSON ответ с ошибкой, чтобы перевести его поле error
+type localeWriter struct {
+	http.ResponseWriter
+	locale      func() string
+	wroteHeader bool
+	status      int
+	lang        string
+	buf         *bytes.Buffer // не nil - ответ задерживается для перевода
+}
+
+func (lw *localeWriter) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if code >= http.StatusBadRequest && strings.HasPrefix(lw.Header().Get("Content-Type"), "application/json") {
+		if lang := lw.locale(); lang != i18n.Default {
+			lw.status, lw.lang, lw.buf = code, lang, &bytes.Buffer{}
+			return
+		}
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *localeWriter) Write(p []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.buf != nil {
+		return lw.buf.Write(p)
+	}
+	return lw.ResponseWriter.Write(p)
+}
+
+func (lw *localeWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok && lw.buf == nil {
+		f.Flush()
+	}
+}
+
+func (lw *localeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	return h.Hijack()
+}
+
+func (lw *localeWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// finish отправляет задержанный ответ с переведенным полем error
+func (lw *localeWriter) finish() {
+	if lw.buf == nil {
+		return
+	}
+	body := lw.buf.Bytes()
+	var resp map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err == nil {
+		if msg, ok := resp["error"].(string); ok {
+			resp["error"] = i18n.Translate(lw.lang, msg)
+			if translated, err := json.Marshal(resp); err == nil {
+				body = append(translated, '\n')
+			}
+		}
+	}
+	lw.Header().Del("Content-Length")
+	lw.Header().Set("Content-Language", lw.lang)
+	lw.Header().Add("Vary", "Accept-Language")
+	lw.ResponseWriter.WriteHeader(lw.status)
+	lw.ResponseWriter.Write(body)
+}
+
+// handleUserLocale обрабатывает /api/users/{id}/locale: GET - выбранный и действующий
+// язык и список поддерживаемых, PUT {locale} - выбор языка (пусто - по Accept-Language)
+func (s *Server) handleUserLocale(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID := r.PathValue("id")
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		locale, err := s.db.GetUserLocale(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load locale"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"locale":    locale,
+			"effective": s.requestLocale(r),
+			"supported": i18n.Locales(),
+		})
+
+	case http.MethodPut:
+		var req struct {
+			Locale string `json:"locale"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		locale := ""
+		if req.Locale != "" {
+			if locale = i18n.Normalize(req.Locale); locale == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unsupported locale", "supported": i18n.Locales()})
+				return
+			}
+		}
+		if err := s.db.SetUserLocale(r.Context(), userID, locale); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save locale"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "locale": locale})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/lookup"
+	"hydra/pkg/storage"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Поиск по каталогу пользователей
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	minSearchLength    = 2 // по более коротким строкам поиск выдавал бы весь каталог
+)
+
+// phonePattern - запрос поиска, похожий на номер телефона
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,}$`)
+
+// handleLookup ищет пользователя по имени: GET /api/lookup?username=...&nonce=...
+// Поиск по телефону или email не поддерживается, чтобы исключить перебор контактов.
+// Ответ подписан ключом сервера (тем же, что /api/time): клиент проверяет, что
+// промежуточный узел не подменил собеседника.
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	if !s.lookupLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many lookups"})
+		return
+	}
+
+	username, err := lookup.NormalizeUsername(r.URL.Query().Get("username"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	// Скрытые, деактивированные и несуществующие пользователи неотличимы
+	user, err := s.db.FindUserByUsername(r.Context(), username)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	result := lookup.Sign(s.timeSigner, username, user.ID, user.Name, r.URL.Query().Get("nonce"))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+}
+
+// handleUserLookup обрабатывает /api/users/{id}/lookup: GET - имя и видимость в поиске,
+// PUT - {username, discoverable, discoverable_by_email, discoverable_by_phone}. Нужен
+// токен входа самого пользователя.
+func (s *Server) handleUserLookup(w http.ResponseWriter, r *http.Request, userID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	profile, err := s.db.GetLookupProfile(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load lookup settings"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "lookup": profile})
+
+	case http.MethodPut:
+		var req struct {
+			Username            *string `json:"username"`
+			Discoverable        *bool   `json:"discoverable"`
+			DiscoverableByEmail *bool   `json:"discoverable_by_email"`
+			DiscoverableByPhone *bool   `json:"discoverable_by_phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+
+		if req.Username != nil && !s.changeUsername(w, r, profile, *req.Username) {
+			return
+		}
+		if req.Discoverable != nil {
+			profile.Discoverable = *req.Discoverable
+		}
+		if req.DiscoverableByEmail != nil {
+			profile.DiscoverableByEmail = *req.DiscoverableByEmail
+		}
+		if req.DiscoverableByPhone != nil {
+			profile.DiscoverableByPhone = *req.DiscoverableByPhone
+		}
+
+		if err := s.db.SaveLookupProfile(r.Context(), profile); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Username is already taken"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "lookup": profile})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleUserUsername обрабатывает /api/users/{id}/username: GET - имя пользователя, PUT
+// {username} - занять или сменить имя, DELETE - освободить. Имя не связано с телефоном и
+// email: по нему собеседника находят (/api/lookup, если он разрешил поиск) и упоминают.
+func (s *Server) handleUserUsername(w http.ResponseWriter, r *http.Request, userID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	profile, err := s.db.GetLookupProfile(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load username"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Username required"})
+			return
+		}
+		if !s.changeUsername(w, r, profile, req.Username) {
+			return
+		}
+	case http.MethodDelete:
+		if !s.changeUsername(w, r, profile, "") {
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	resp := map[string]interface{}{"success": true, "username": profile.Username}
+	if profile.UsernameChangedAt != nil {
+		resp["changed_at"] = profile.UsernameChangedAt
+		resp["next_change_at"] = profile.UsernameChangedAt.Add(s.config.UsernameChangeInterval)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// changeUsername меняет имя пользователя в profile (пусто - освобождает). Смена чаще
+// USERNAME_CHANGE_INTERVAL отклоняется; прежнее имя USERNAME_HOLD удерживается за
+// владельцем. При ошибке пишет ответ и возвращает false.
+func (s *Server) changeUsername(w http.ResponseWriter, r *http.Request, profile *storage.LookupProfile, username string) bool {
+	if username != "" {
+		normalized, err := lookup.NormalizeUsername(username)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return false
+		}
+		username = normalized
+	}
+	if username == profile.Username {
+		return true
+	}
+
+	if profile.UsernameChangedAt != nil {
+		if wait := time.Until(profile.UsernameChangedAt.Add(s.config.UsernameChangeInterval)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Username was changed recently"})
+			return false
+		}
+	}
+
+	ok, err := s.db.ChangeUsername(r.Context(), profile.UserID, username, s.config.UsernameHold)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to change username"})
+		return false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Username is already taken"})
+		return false
+	}
+	now := time.Now()
+	profile.Username, profile.UsernameChangedAt = username, &now
+	return true
+}
+
+// handleUsernameAvailability обрабатывает GET /api/usernames/{username}: свободно ли имя.
+// Имя, удерживаемое за прежним владельцем, свободно только для него.
+func (s *Server) handleUsernameAvailability(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if !s.lookupLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many lookups"})
+		return
+	}
+
+	username, err := lookup.NormalizeUsername(r.PathValue("username"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	owner, err := s.db.UsernameOwner(r.Context(), username, s.config.UsernameHold)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to check username"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "username": username, "available": owner == "" || owner == userID})
+}
+
+// mention - участник беседы, упомянутый в сообщении как @имя
+type mention struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// resolveMentions находит упомянутых в тексте участников беседы. Упоминания тех, кто не
+// состоит в беседе, не раскрываются - иначе по ним можно было бы узнавать ID скрытых из
+// поиска пользователей.
+func (s *Server) resolveMentions(ctx context.Context, text string, participants []string) []mention {
+	mentions := []mention{}
+	usernames := lookup.Mentions(text)
+	if len(usernames) == 0 {
+		return mentions
+	}
+	resolved, err := s.db.ResolveUsernames(ctx, usernames)
+	if err != nil {
+		log.Printf("Failed to resolve mentions: %v", err)
+		return mentions
+	}
+	inConversation := make(map[string]bool, len(participants))
+	for _, id := range participants {
+		inConversation[id] = true
+	}
+	for _, username := range usernames {
+		if userID, ok := resolved[username]; ok && inConversation[userID] {
+			mentions = append(mentions, mention{UserID: userID, Username: username})
+		}
+	}
+	return mentions
+}
+
+// handleUserSearch обрабатывает GET /api/users/search?q=...&limit=N: поиск собеседника в
+// каталоге. "@имя" ищет по началу имени пользователя, email и телефон - точное совпадение
+// (только у разрешивших поиск по ним), иначе - по имени пользователя и части отображаемого
+// имени. Находятся только пользователи, разрешившие поиск (см. handleUserLookup).
+func (s *Server) handleUserSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if !s.lookupLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many lookups"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)})
+			return
+		}
+		limit = n
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	isContact := !strings.HasPrefix(q, "@") && (strings.Contains(q, "@") || phonePattern.MatchString(q))
+	if !isContact && utf8.RuneCountInString(strings.TrimPrefix(q, "@")) < minSearchLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("q must be at least %d characters", minSearchLength)})
+		return
+	}
+
+	var entries []*storage.DirectoryEntry
+	switch {
+	case strings.HasPrefix(q, "@"):
+		entries, err = s.db.SearchDirectory(r.Context(), strings.ToLower(strings.TrimPrefix(q, "@")), "", limit+1)
+	case strings.Contains(q, "@"):
+		entries, err = s.findDirectoryContact(r, q, "")
+	case isContact:
+		entries, err = s.findDirectoryContact(r, "", strings.Map(func(c rune) rune {
+			if c == '+' || (c >= '0' && c <= '9') {
+				return c
+			}
+			return -1
+		}, q))
+	default:
+		entries, err = s.db.SearchDirectory(r.Context(), q, q, limit+1)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Search failed"})
+		return
+	}
+
+	// Себя не показываем; запрошен limit+1, чтобы после этого осталось limit
+	users := []*storage.DirectoryEntry{}
+	for _, entry := range entries {
+		if entry.UserID != userID && len(users) < limit {
+			users = append(users, entry)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "users": users})
+}
+
+// findDirectoryContact ищет пользователя по точному email или телефону
+func (s *Server) findDirectoryContact(r *http.Request, email, phone string) ([]*storage.DirectoryEntry, error) {
+	entry, err := s.db.FindDirectoryContact(r.Context(), email, phone)
+	if err != nil || entry == nil {
+		return []*storage.DirectoryEntry{}, err
+	}
+	return []*storage.DirectoryEntry{entry}, nil
+}
+
+// clientIP возвращает IP клиента для ограничения частоты запросов
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
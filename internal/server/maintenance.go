@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maintenanceRetryAfter - подсказка клиентам (заголовок Retry-After), когда повторить запись
+const maintenanceRetryAfter = "120"
+
+// maintenanceWritable - изменяющие запросы, разрешенные в режиме обслуживания: админ API,
+// вход и отправка сообщений (сообщения откладываются в исходящие)
+var maintenanceWritable = []string{"/api/admin/", "/api/login", "/api/send"}
+
+// inMaintenance сообщает, включен ли режим обслуживания, и его причину
+func (s *Server) inMaintenance() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintenance, s.maintenanceReason
+}
+
+// setMaintenance включает или выключает режим обслуживания. При выключении
+// накопившиеся исходящие доставляются в фоне.
+func (s *Server) setMaintenance(enabled bool, reason string) {
+	s.mu.Lock()
+	wasEnabled := s.maintenance
+	s.maintenance = enabled
+	s.maintenanceReason = ""
+	if enabled {
+		s.maintenanceReason = reason
+		if !wasEnabled {
+			s.maintenanceSince = time.Now()
+		}
+	}
+	s.mu.Unlock()
+
+	if enabled && !wasEnabled {
+		log.Printf("Maintenance mode enabled: %s", reason)
+	}
+	if !enabled && wasEnabled {
+		log.Println("Maintenance mode disabled, delivering outbox")
+		go s.flushOutbox()
+	}
+}
+
+// withMaintenance в режиме обслуживания пропускает чтение и отклоняет изменяющие запросы с 503
+func (s *Server) withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled, reason := s.inMaintenance()
+		if !enabled || isReadOnlyRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range maintenanceWritable {
+			if strings.HasPrefix(apiPath(r.URL.Path), prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     false,
+			"error":       "Server is in read-only maintenance mode",
+			"maintenance": true,
+			"reason":      reason,
+		})
+	})
+}
+
+func isReadOnlyRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// queueForMaintenance откладывает сообщение в исходящие до окончания обслуживания
+func (s *Server) queueForMaintenance(w http.ResponseWriter, req *sendRequest, reason string) {
+	msg := &storage.OutboxMessage{SenderID: req.From, RecipientID: req.To, Body: req.Message}
+	if err := s.db.EnqueueOutbox(context.Background(), msg); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to queue message", "maintenance": true})
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"queued":      true,
+		"id":          msg.ID,
+		"maintenance": true,
+		"reason":      reason,
+	})
+}
+
+// flushOutbox доставляет сообщения, накопленные в режиме обслуживания или в окне отключения
+// транспортов, в порядке поступления.
+// При ошибке транспорта доставка прерывается, оставшиеся сообщения ждут следующего запуска.
+func (s *Server) flushOutbox() {
+	for {
+		if enabled, _ := s.inMaintenance(); enabled {
+			return
+		}
+		if _, blocked := s.messagesBlackedOut(); blocked {
+			return
+		}
+
+		messages, err := s.db.ListOutbox(context.Background(), 100)
+		if err != nil {
+			log.Printf("Outbox delivery failed: %v", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+
+		for _, msg := range messages {
+			if err := s.deliverOutbox(msg); err != nil {
+				log.Printf("Outbox delivery of message %d failed: %v", msg.ID, err)
+				return
+			}
+			if err := s.db.DeleteOutbox(context.Background(), msg.ID); err != nil {
+				log.Printf("Failed to remove delivered outbox message %d: %v", msg.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// deliverOutbox доставляет одно отложенное сообщение так же, как /api/send
+func (s *Server) deliverOutbox(msg *storage.OutboxMessage) error {
+	if s.blockedBy(msg.RecipientID, msg.SenderID) {
+		log.Printf("Outbox message %d dropped: sender %s is blocked by %s", msg.ID, msg.SenderID, msg.RecipientID)
+		return nil
+	}
+	if msg.RecipientID != "" && msg.RecipientID != msg.SenderID {
+		s.recordNotification(msg.RecipientID, storage.NotificationMessage, msg.SenderID)
+	}
+
+	if state := s.accountState(msg.RecipientID); state != nil {
+		if state.InboundMode == storage.InboundBounce {
+			log.Printf("Outbox message %d dropped: recipient %s is unavailable", msg.ID, msg.RecipientID)
+			return nil
+		}
+		return s.db.QueueMessage(context.Background(), &storage.QueuedMessage{UserID: msg.RecipientID, SenderID: msg.SenderID, Body: msg.Body})
+	}
+
+	s.recordMessageCreated(msg.SenderID, msg.RecipientID, msg.Body)
+	_, err := s.transportManager.Exchange(context.Background(), []byte(msg.Body))
+	return err
+}
+
+// handleAdminMaintenance показывает и переключает режим обслуживания.
+// PUT {"enabled": true, "reason": "..."}
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		s.setMaintenance(req.Enabled, req.Reason)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	status := map[string]interface{}{"success": true, "enabled": s.maintenance, "reason": s.maintenanceReason}
+	if s.maintenance {
+		status["since"] = s.maintenanceSince
+	}
+	s.mu.Unlock()
+
+	if queued, err := s.db.CountOutbox(r.Context()); err == nil {
+		status["outbox"] = queued
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Правка и удаление сохраненных сообщений (группы, чаты звонков). Только автор правит и
+// удаляет свои сообщения; участники беседы получают события message.edited и
+// message.deleted в журнал. Удаленное сообщение остается в истории надгробием без текста,
+// чтобы клиенты заменили его у себя, а не потеряли место в ленте.
+//
+// Личные сообщения (/api/send) сервер не хранит: получатель узнает о них из журнала
+// событий. Поэтому для них PATCH и DELETE отвечают 404 - правка и удаление доступны
+// только для сообщений, сохраненных в беседе.
+
+// storedMessage возвращает сообщение по ID. Если его нет, пишет 404 и возвращает nil.
+func (s *Server) storedMessage(w http.ResponseWriter, r *http.Request, messageID string) *storage.Message {
+	msg, err := s.db.GetMessage(r.Context(), messageID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load message"})
+		return nil
+	}
+	if msg == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message not found"})
+		return nil
+	}
+	return msg
+}
+
+// messageParticipants возвращает участников беседы сообщения: автора, текущих участников
+// группы и получателей, которым сообщение было доставлено
+func (s *Server) messageParticipants(ctx context.Context, msg *storage.Message) []string {
+	seen := map[string]bool{msg.SenderID: true}
+	participants := []string{msg.SenderID}
+	add := func(userID string) {
+		if !seen[userID] {
+			seen[userID] = true
+			participants = append(participants, userID)
+		}
+	}
+
+	members, err := s.db.ListGroupMembers(ctx, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to list members of group %s: %v", msg.ConversationID, err)
+	}
+	for _, m := range members {
+		add(m.UserID)
+	}
+	receipts, err := s.db.ListReceipts(ctx, msg.ID)
+	if err != nil {
+		log.Printf("Failed to list receipts of %s: %v", msg.ID, err)
+	}
+	for _, receipt := range receipts {
+		add(receipt.UserID)
+	}
+	return participants
+}
+
+// handleStoredMessage обрабатывает /api/messages/{id}: PATCH {body} - правка, DELETE -
+// удаление сообщения. Нужен токен входа автора сообщения.
+func (s *Server) handleStoredMessage(w http.ResponseWriter, r *http.Request, messageID string) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	var body string
+	if r.Method == http.MethodPatch {
+		var req struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Body == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message cannot be empty"})
+			return
+		}
+		body = req.Body
+	}
+
+	msg := s.storedMessage(w, r, messageID)
+	if msg == nil {
+		return
+	}
+	if msg.SenderID != userID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the author can change a message"})
+		return
+	}
+
+	var ok bool
+	var eventType string
+	if r.Method == http.MethodPatch {
+		ok, err = s.db.EditMessage(r.Context(), messageID, body, s.config.MessageEditHistory > 0)
+		eventType = storage.EventMessageEdited
+	} else {
+		ok, err = s.db.DeleteMessage(r.Context(), messageID)
+		eventType = storage.EventMessageDeleted
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update message"})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message was deleted"})
+		return
+	}
+
+	if msg = s.storedMessage(w, r, messageID); msg == nil {
+		return
+	}
+	participants := s.messageParticipants(r.Context(), msg)
+	payload := map[string]interface{}{"id": msg.ID, "conversation_id": msg.ConversationID, "from": msg.SenderID}
+	if msg.DeletedAt != nil {
+		payload["deleted_at"] = msg.DeletedAt
+	} else {
+		payload["body"] = msg.Body
+		payload["edited_at"] = msg.EditedAt
+		if mentions := s.resolveMentions(r.Context(), msg.Body, participants); len(mentions) > 0 {
+			payload["mentions"] = mentions
+		}
+	}
+	for _, participant := range participants {
+		s.appendEvent(participant, eventType, payload)
+	}
+	s.touchUser(userID)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": msg})
+}
+
+// handleMessageEdits обрабатывает GET /api/messages/{id}/edits: прежние тексты сообщения
+// (участникам беседы). Хранятся MESSAGE_EDIT_HISTORY.
+func (s *Server) handleMessageEdits(w http.ResponseWriter, r *http.Request, messageID string) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	msg := s.storedMessage(w, r, messageID)
+	if msg == nil {
+		return
+	}
+	participant := false
+	for _, id := range s.messageParticipants(r.Context(), msg) {
+		if id == userID {
+			participant = true
+			break
+		}
+	}
+	if !participant {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message not found"})
+		return
+	}
+
+	edits, err := s.db.ListMessageEdits(r.Context(), messageID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load message edits"})
+		return
+	}
+	// Правки старше срока хранения, еще не удаленные очисткой, не выдаются
+	kept := []*storage.MessageEdit{}
+	for _, e := range edits {
+		if time.Since(e.EditedAt) < s.config.MessageEditHistory {
+			kept = append(kept, e)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": msg, "edits": kept})
+}
+
+// purgeMessageEdits удаляет прежние тексты сообщений старше срока хранения истории правок
+// (при MESSAGE_EDIT_HISTORY=0 - все сохраненные раньше)
+func (s *Server) purgeMessageEdits(now time.Time) {
+	n, err := s.db.DeleteMessageEditsBefore(context.Background(), now.Add(-s.config.MessageEditHistory))
+	if err != nil {
+		log.Printf("Failed to purge message edits: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired message edits", n)
+	}
+}
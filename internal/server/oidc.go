@@ -0,0 +1,541 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hydra/internal/config"
+	"hydra/pkg/oidc"
+	"hydra/pkg/storage"
+	"hydra/pkg/trust"
+	"hydra/pkg/validate"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Вход через внешних поставщиков (см. pkg/oidc). Клиент начинает вход
+// (POST /api/auth/oidc/{provider}/start) и открывает в браузере auth_url. Поставщик
+// возвращает браузер на /api/auth/oidc/{provider}/callback; сервер проверяет учетную
+// запись и перенаправляет браузер на страницу входа (PUBLIC_URL/login.html) или в
+// приложение (hydra://oidc) с одноразовым кодом oidc_code либо с oidc_error. Клиент
+// обменивает код на сессию (POST /api/auth/oidc/complete), поэтому токены сессии не
+// проходят через адресную строку браузера. Начатые входы и коды хранятся в памяти
+// экземпляра сервера: вход нужно завершить на том же экземпляре.
+//
+// Привязанная учетная запись поставщика входит в аккаунт пользователя. Непривязанная
+// создает аккаунт, если это разрешает OIDC_SIGNUP: по приглашению (invite при начале
+// входа; уровень доверия - как при регистрации по приглашению) или свободно. Контакт
+// нового аккаунта - контакт приглашения или email, подтвержденный поставщиком. Аккаунт
+// с тем же email сам не привязывается: владелец входит прежним способом и привязывает
+// учетную запись, начав вход с токеном входа в Authorization.
+
+// Режимы регистрации через поставщиков (OIDC_SIGNUP)
+const (
+	oidcSignupOpen   = "open"
+	oidcSignupInvite = "invite"
+	oidcSignupClosed = "closed"
+)
+
+// Причины отказа, передаваемые клиенту в oidc_error
+const (
+	oidcErrorState         = "invalid_state"   // вход не начинался или истек
+	oidcErrorDenied        = "access_denied"   // пользователь отказался у поставщика
+	oidcErrorProvider      = "provider_error"  // не удалось получить учетную запись
+	oidcErrorTaken         = "identity_taken"  // учетная запись привязана к другому аккаунту
+	oidcErrorSignupClosed  = "signup_closed"   // регистрация через поставщиков выключена
+	oidcErrorInvite        = "invite_required" // регистрация только по приглашению
+	oidcErrorInviteInvalid = "invite_invalid"  // приглашение использовано, отозвано или истекло
+	oidcErrorEmail         = "email_required"  // поставщик не подтвердил email
+	oidcErrorAccountExists = "account_exists"  // аккаунт с этим контактом уже есть
+	oidcErrorRegistration  = "signup_failed"   // не удалось создать аккаунт
+)
+
+const (
+	defaultOIDCFlowTTL  = 10 * time.Minute
+	oidcCallbackTimeout = 30 * time.Second
+	oidcAppRedirect     = "hydra://oidc" // возврат в приложение
+)
+
+// newOIDCProviders создает настроенных поставщиков входа по имени
+func newOIDCProviders(cfg *config.Config) map[string]oidc.Provider {
+	providers := make(map[string]oidc.Provider)
+	if cfg.OIDCGoogleClientID != "" {
+		providers["google"] = &oidc.OpenID{Issuer: oidc.Google, ClientID: cfg.OIDCGoogleClientID, ClientSecret: cfg.OIDCGoogleClientSecret}
+	}
+	if cfg.OIDCGitHubClientID != "" {
+		providers["github"] = &oidc.GitHub{ClientID: cfg.OIDCGitHubClientID, ClientSecret: cfg.OIDCGitHubClientSecret}
+	}
+	if cfg.OIDCKeycloakClientID != "" {
+		if cfg.OIDCKeycloakIssuer == "" {
+			log.Printf("Warning: OIDC_KEYCLOAK_ISSUER is not set, Keycloak login disabled")
+		} else {
+			providers["keycloak"] = &oidc.OpenID{Issuer: cfg.OIDCKeycloakIssuer, ClientID: cfg.OIDCKeycloakClientID, ClientSecret: cfg.OIDCKeycloakClientSecret}
+		}
+	}
+	return providers
+}
+
+// oidcFlow - начатый вход у поставщика
+type oidcFlow struct {
+	provider string
+	verifier string // секрет PKCE
+	nonce    string
+	invite   string // приглашение для регистрации
+	linkUser string // привязка учетной записи к аккаунту вошедшего пользователя
+	app      bool   // вернуть в приложение, а не на страницу входа
+	expires  time.Time
+}
+
+// oidcResult - завершенный у поставщика вход, ожидающий обмена кода на сессию
+type oidcResult struct {
+	userID   string
+	identity *storage.ExternalIdentity
+	linked   bool        // учетная запись привязана к уже вошедшему пользователю
+	created  bool        // аккаунт создан
+	level    trust.Level // уровень доверия созданного аккаунта
+	expires  time.Time
+}
+
+// oidcStore хранит начатые входы по state и результаты по одноразовому коду
+type oidcStore struct {
+	mu      sync.Mutex
+	flows   map[string]*oidcFlow
+	results map[string]*oidcResult
+}
+
+func newOIDCStore() *oidcStore {
+	return &oidcStore{flows: make(map[string]*oidcFlow), results: make(map[string]*oidcResult)}
+}
+
+func (st *oidcStore) putFlow(state string, flow *oidcFlow) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expire(time.Now())
+	st.flows[state] = flow
+}
+
+// takeFlow возвращает и забывает вход; nil - не начинался или истек
+func (st *oidcStore) takeFlow(state string) *oidcFlow {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	flow := st.flows[state]
+	delete(st.flows, state)
+	if flow == nil || !time.Now().Before(flow.expires) {
+		return nil
+	}
+	return flow
+}
+
+func (st *oidcStore) putResult(code string, result *oidcResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expire(time.Now())
+	st.results[code] = result
+}
+
+// takeResult возвращает и забывает результат входа; nil - кода нет или он истек
+func (st *oidcStore) takeResult(code string) *oidcResult {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	result := st.results[code]
+	delete(st.results, code)
+	if result == nil || !time.Now().Before(result.expires) {
+		return nil
+	}
+	return result
+}
+
+func (st *oidcStore) expire(now time.Time) {
+	for state, flow := range st.flows {
+		if !now.Before(flow.expires) {
+			delete(st.flows, state)
+		}
+	}
+	for code, result := range st.results {
+		if !now.Before(result.expires) {
+			delete(st.results, code)
+		}
+	}
+}
+
+// oidcFlowTTL возвращает срок, за который нужно завершить вход
+func (s *Server) oidcFlowTTL() time.Duration {
+	if s.config.OIDCFlowTTL <= 0 {
+		return defaultOIDCFlowTTL
+	}
+	return s.config.OIDCFlowTTL
+}
+
+// oidcCallbackURL - адрес возврата от поставщика, зарегистрированный у него
+func (s *Server) oidcCallbackURL(provider string) string {
+	return strings.TrimRight(s.config.PublicURL, "/") + apiPrefixV1 + "/auth/oidc/" + provider + "/callback"
+}
+
+// oidcRedirect перенаправляет браузер к клиенту с кодом или причиной отказа
+func (s *Server) oidcRedirect(w http.ResponseWriter, r *http.Request, app bool, param, value string) {
+	target := strings.TrimRight(s.config.PublicURL, "/") + "/login.html"
+	if app {
+		target = oidcAppRedirect
+	}
+	http.Redirect(w, r, target+"?"+url.Values{param: {value}}.Encode(), http.StatusFound)
+}
+
+// handleOIDCProviders обрабатывает GET /api/auth/oidc: настроенные поставщики входа
+func (s *Server) handleOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	names := make([]string, 0, len(s.oidcProviders))
+	for name := range s.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	signup := s.config.OIDCSignup
+	if signup == "" {
+		signup = oidcSignupInvite
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "providers": names, "signup": signup})
+}
+
+// handleOIDCStart обрабатывает POST /api/auth/oidc/{provider}/start {invite, app}: адрес
+// страницы входа поставщика. С токеном входа в Authorization учетная запись поставщика
+// привязывается к аккаунту вошедшего пользователя.
+func (s *Server) handleOIDCStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	name := r.PathValue("provider")
+	provider := s.oidcProviders[name]
+	if provider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown provider"})
+		return
+	}
+
+	var req struct {
+		Invite string `json:"invite"`
+		App    bool   `json:"app"`
+	}
+	// Тело необязательно
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+
+	flow := &oidcFlow{provider: name, invite: req.Invite, app: req.App, expires: time.Now().Add(s.oidcFlowTTL())}
+	if r.Header.Get("Authorization") != "" {
+		userID, err := s.bearerUser(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+			return
+		}
+		flow.linkUser, flow.invite = userID, ""
+	} else if req.Invite != "" {
+		invite, err := s.db.GetInvite(r.Context(), req.Invite)
+		if err != nil || invite == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid or expired token"})
+			return
+		}
+	}
+
+	state, err := oidc.NewState()
+	if err == nil {
+		flow.nonce, err = oidc.NewState()
+	}
+	if err == nil {
+		flow.verifier, err = oidc.NewVerifier()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to start login"})
+		return
+	}
+	authURL, err := provider.AuthURL(r.Context(), s.oidcCallbackURL(name), state, flow.nonce, oidc.Challenge(flow.verifier))
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", name, err)
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Identity provider unavailable"})
+		return
+	}
+	s.oidcFlows.putFlow(state, flow)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"auth_url":   authURL,
+		"expires_at": flow.expires,
+	})
+}
+
+// handleOIDCCallback обрабатывает GET /api/auth/oidc/{provider}/callback - возврат
+// браузера от поставщика
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	query := r.URL.Query()
+	flow := s.oidcFlows.takeFlow(query.Get("state"))
+	if flow == nil || flow.provider != name {
+		s.oidcRedirect(w, r, false, "oidc_error", oidcErrorState)
+		return
+	}
+	if query.Get("error") != "" || query.Get("code") == "" {
+		s.oidcRedirect(w, r, flow.app, "oidc_error", oidcErrorDenied)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcCallbackTimeout)
+	defer cancel()
+	identity, err := s.oidcProviders[name].Exchange(ctx, s.oidcCallbackURL(name), query.Get("code"), flow.verifier, flow.nonce)
+	if err != nil {
+		log.Printf("Failed %s login: %v", name, err)
+		s.oidcRedirect(w, r, flow.app, "oidc_error", oidcErrorProvider)
+		return
+	}
+
+	result, reason := s.oidcLogin(ctx, flow, identity)
+	if result == nil {
+		s.oidcRedirect(w, r, flow.app, "oidc_error", reason)
+		return
+	}
+	code, err := randomOIDCCode()
+	if err != nil {
+		s.oidcRedirect(w, r, flow.app, "oidc_error", oidcErrorProvider)
+		return
+	}
+	result.expires = time.Now().Add(s.oidcFlowTTL())
+	s.oidcFlows.putResult(code, result)
+	s.oidcRedirect(w, r, flow.app, "oidc_code", code)
+}
+
+// oidcLogin находит, привязывает или создает аккаунт учетной записи поставщика. При
+// отказе возвращает nil и причину для клиента.
+func (s *Server) oidcLogin(ctx context.Context, flow *oidcFlow, identity *oidc.Identity) (*oidcResult, string) {
+	linked, err := s.db.GetExternalIdentity(ctx, flow.provider, identity.Subject)
+	if err != nil {
+		log.Printf("Failed to look up %s identity: %v", flow.provider, err)
+		return nil, oidcErrorProvider
+	}
+	email := ""
+	if identity.EmailVerified {
+		email, _ = validate.Email(identity.Email)
+	}
+
+	// Привязка к аккаунту вошедшего пользователя
+	if flow.linkUser != "" {
+		if linked != nil {
+			if linked.UserID != flow.linkUser {
+				return nil, oidcErrorTaken
+			}
+			return &oidcResult{userID: linked.UserID, identity: linked, linked: true}, ""
+		}
+		record := &storage.ExternalIdentity{UserID: flow.linkUser, Provider: flow.provider, Subject: identity.Subject, Email: email}
+		if ok, err := s.db.LinkExternalIdentity(ctx, record); err != nil || !ok {
+			return nil, oidcErrorTaken
+		}
+		return &oidcResult{userID: flow.linkUser, identity: record, linked: true}, ""
+	}
+	if linked != nil {
+		return &oidcResult{userID: linked.UserID, identity: linked}, ""
+	}
+
+	// Регистрация
+	switch s.config.OIDCSignup {
+	case oidcSignupOpen:
+	case oidcSignupClosed:
+		return nil, oidcErrorSignupClosed
+	default:
+		if flow.invite == "" {
+			return nil, oidcErrorInvite
+		}
+	}
+	var inviterID, contact string
+	if flow.invite != "" {
+		invite, err := s.db.GetInvite(ctx, flow.invite)
+		if err != nil || invite == nil {
+			return nil, oidcErrorInviteInvalid
+		}
+		inviterID, contact = invite.CreatedBy, normalizeLogin(invite.ContactInfo)
+	}
+	if contact == "" {
+		contact = email
+	}
+	if contact == "" {
+		return nil, oidcErrorEmail
+	}
+	if _, err := s.db.GetUserByEmail(ctx, contact); err == nil {
+		return nil, oidcErrorAccountExists
+	}
+	if _, err := s.db.GetUserByPhone(ctx, contact); err == nil {
+		return nil, oidcErrorAccountExists
+	}
+	level := trust.Unknown
+	if flow.invite != "" {
+		if _, err := s.db.ValidateInvite(ctx, flow.invite); err != nil {
+			return nil, oidcErrorInviteInvalid
+		}
+		level = s.inviteeTrust(inviterID)
+	}
+
+	// Пароль аккаунта неизвестен пользователю: он входит через поставщика, а удаление
+	// аккаунта подтверждает свежим входом (см. deleteAccount)
+	password, err := randomOIDCCode()
+	if err != nil {
+		return nil, oidcErrorRegistration
+	}
+	user, err := s.db.CreateUser(ctx, oidcUserName(identity, contact), password, contact)
+	if err != nil {
+		log.Printf("Failed to create user for %s identity: %v", flow.provider, err)
+		return nil, oidcErrorRegistration
+	}
+	record := &storage.ExternalIdentity{UserID: user.ID, Provider: flow.provider, Subject: identity.Subject, Email: email, Signup: true}
+	if _, err := s.db.LinkExternalIdentity(ctx, record); err != nil {
+		log.Printf("Failed to link %s identity of %s: %v", flow.provider, user.ID, err)
+	}
+	if flow.invite != "" {
+		if err := s.db.RecordInviteUse(ctx, flow.invite, user.ID); err != nil {
+			log.Printf("Failed to record invite use: %v", err)
+		}
+	}
+	s.saveTrust(user.ID, level, inviterID)
+	return &oidcResult{userID: user.ID, identity: record, created: true, level: level}, ""
+}
+
+// oidcUserName выбирает имя нового аккаунта: имя у поставщика или начало email
+func oidcUserName(identity *oidc.Identity, contact string) string {
+	if name, err := validate.Name(identity.Name); err == nil {
+		return name
+	}
+	local, _, _ := strings.Cut(contact, "@")
+	if name, err := validate.Name(local); err == nil {
+		return name
+	}
+	return "Hydra user"
+}
+
+func randomOIDCCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// handleOIDCComplete обрабатывает POST /api/auth/oidc/complete {code}: сессия входа
+// через поставщика или привязанная учетная запись
+func (s *Server) handleOIDCComplete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	result := s.oidcFlows.takeResult(req.Code)
+	if result == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid or expired code"})
+		return
+	}
+	if result.linked {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "identity": result.identity})
+		return
+	}
+
+	disabled, err := s.db.GetDisabledAccount(r.Context(), result.userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to check account"})
+		return
+	}
+	if disabled != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Account disabled"})
+		return
+	}
+	user, err := s.db.GetUser(r.Context(), result.userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load user"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"user":      user,
+		"identity":  result.identity,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
+	}
+	if result.created {
+		response["message"] = "Registration successful"
+		response["trust"] = result.level
+	} else {
+		response["message"] = "Login successful"
+		s.touchUser(user.ID)
+		if state := s.accountState(user.ID); state != nil {
+			response["account"] = state
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleUserIdentities обрабатывает /api/users/{id}/identities[/{provider}]: GET -
+// привязанные учетные записи поставщиков, DELETE .../{provider} - отвязка. Учетную
+// запись, через которую создан аккаунт, нельзя отвязать, пока она единственная.
+func (s *Server) handleUserIdentities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID := r.PathValue("id")
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	identities, err := s.db.ListExternalIdentities(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list identities"})
+		return
+	}
+	provider := r.PathValue("provider")
+
+	switch {
+	case r.Method == http.MethodGet && provider == "":
+		if identities == nil {
+			identities = []*storage.ExternalIdentity{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "identities": identities})
+
+	case r.Method == http.MethodDelete && provider != "":
+		for _, identity := range identities {
+			if identity.Provider == provider && identity.Signup && len(identities) == 1 {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Cannot unlink the only sign-in identity"})
+				return
+			}
+		}
+		unlinked, err := s.db.UnlinkExternalIdentity(r.Context(), userID, provider)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to unlink identity"})
+			return
+		}
+		if !unlinked {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Identity not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Постраничная выдача списков: GET ...?limit=N&cursor=C. Ответ содержит next_cursor -
+// непрозрачный курсор следующей страницы (пусто - страница последняя). Курсор указывает
+// на последний выданный элемент, а не на номер страницы, поэтому добавление и удаление
+// элементов между запросами не приводит к пропускам и повторам.
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 200
+)
+
+// pageRequest - запрошенная страница списка
+type pageRequest struct {
+	Limit int
+	After string // ключ последнего элемента предыдущей страницы; пусто - первая страница
+}
+
+// parsePage разбирает limit и cursor запроса (defaultLimit - если limit не указан).
+// При ошибке пишет 400 и возвращает false.
+func parsePage(w http.ResponseWriter, r *http.Request, defaultLimit int) (pageRequest, bool) {
+	page := pageRequest{Limit: defaultLimit}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+			return page, false
+		}
+		page.Limit = n
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid cursor"})
+			return page, false
+		}
+		page.After = string(after)
+	}
+	return page, true
+}
+
+// pageCursor возвращает курсор, указывающий на элемент с ключом key
+func pageCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// paginate возвращает страницу items и курсор следующей страницы. Ключи элементов
+// уникальны и возрастают в порядке items.
+func paginate[T any](items []T, page pageRequest, key func(T) string) ([]T, string) {
+	start := 0
+	if page.After != "" {
+		for start < len(items) && key(items[start]) <= page.After {
+			start++
+		}
+	}
+	end := min(start+page.Limit, len(items))
+	next := ""
+	if end < len(items) {
+		next = pageCursor(key(items[end-1]))
+	}
+	return items[start:end], next
+}
+
+// newestFirstKey - ключ для списков от новых к старым: возрастает при убывании at,
+// при равном времени - по id
+func newestFirstKey(at time.Time, id string) string {
+	return fmt.Sprintf("%020d/%s", math.MaxInt64-at.UnixNano(), id)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/discovery"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// peerUpdate - событие пира для веб-клиента и контакты, чье присутствие оно изменило
+type peerUpdate struct {
+	discovery.PeerEvent
+	Contacts []string `json:"contacts,omitempty"`
+}
+
+// peerFeed рассылает события пиров подключенным веб-клиентам (/api/peers/events)
+type peerFeed struct {
+	mu   sync.Mutex
+	subs map[chan peerUpdate]struct{}
+}
+
+func newPeerFeed() *peerFeed {
+	return &peerFeed{subs: make(map[chan peerUpdate]struct{})}
+}
+
+func (f *peerFeed) subscribe() chan peerUpdate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan peerUpdate, 16)
+	f.subs[ch] = struct{}{}
+	return ch
+}
+
+func (f *peerFeed) unsubscribe(ch chan peerUpdate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, ch)
+}
+
+// publish передает событие подписчикам; медленный клиент пропускает события
+// и перечитывает список пиров при следующем
+func (f *peerFeed) publish(update peerUpdate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// SetPeerManager подключает управление пирами mesh (MESH_DISCOVERY) к /api/peers
+// и присутствию контактов
+func (s *Server) SetPeerManager(peers *discovery.AutoPeerManager) {
+	s.mu.Lock()
+	s.peerManager = peers
+	s.mu.Unlock()
+
+	peers.Subscribe(s.handlePeerEvent)
+}
+
+// handlePeerEvent обновляет присутствие контактов, связанных с узлом пира, и передает
+// событие веб-клиентам
+func (s *Server) handlePeerEvent(event discovery.PeerEvent) {
+	status := "offline"
+	if event.Type == discovery.PeerJoined {
+		status = "online"
+	}
+
+	var changed []string
+	s.mu.Lock()
+	for id, contact := range s.contacts {
+		if contact.NodeID != "" && contact.NodeID == event.Peer.NodeID && contact.Status != status {
+			contact.Status = status
+			s.contacts[id] = contact
+			changed = append(changed, id)
+		}
+	}
+	s.mu.Unlock()
+
+	s.peerFeed.publish(peerUpdate{PeerEvent: event, Contacts: changed})
+}
+
+// nodePresence возвращает присутствие контакта по его узлу mesh: online, если узел
+// сейчас обнаружен и отвечает
+func (s *Server) nodePresence(nodeID string) string {
+	if peers := s.peers(); peers != nil {
+		for _, info := range peers.GetPeerInfos() {
+			if info.NodeID == nodeID && !info.Stale && info.Compatible {
+				return "online"
+			}
+		}
+	}
+	return "offline"
+}
+
+func (s *Server) peers() *discovery.AutoPeerManager {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerManager
+}
+
+// writePeerList отвечает текущим списком пиров: используемые mesh транспортом,
+// статические и обнаруженные через mDNS со временем последней доступности. Обнаруженных
+// пиров может быть много, они выдаются постранично.
+func writePeerList(w http.ResponseWriter, peers *discovery.AutoPeerManager, page pageRequest) {
+	discovered, next := paginate(peers.GetPeerInfos(), page, func(info discovery.PeerInfo) string { return info.ID })
+	if discovered == nil {
+		discovered = []discovery.PeerInfo{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"peers":       peers.GetPeerList(),
+		"static":      peers.GetStaticPeers(),
+		"discovered":  discovered,
+		"next_cursor": next,
+	})
+}
+
+// handlePeers: GET - список пиров, POST {"addr": "host:port"} - добавить статический пир
+// (только администратор)
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	peers := s.peers()
+	if peers == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Peer discovery is disabled"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		page, ok := parsePage(w, r, defaultPageLimit)
+		if !ok {
+			return
+		}
+		writePeerList(w, peers, page)
+
+	case http.MethodPost:
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		var req struct {
+			Addr string `json:"addr"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "addr is required"})
+			return
+		}
+		if err := peers.AddStaticPeer(strings.TrimSpace(req.Addr)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writePeerList(w, peers, pageRequest{Limit: defaultPageLimit})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handlePeerEvents передает веб-клиенту появление и исчезновение пиров через Server-Sent Events:
+// GET /api/peers/events. В событии перечислены контакты, чье присутствие изменилось.
+func (s *Server) handlePeerEvents(w http.ResponseWriter, r *http.Request) {
+	if s.peers() == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Peer discovery is disabled"})
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Streaming is not supported"})
+		return
+	}
+
+	updates := s.peerFeed.subscribe()
+	defer s.peerFeed.unsubscribe(updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update := <-updates:
+			data, _ := json.Marshal(update)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", update.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handlePeer удаляет статический пир: DELETE /api/peers/{host:port}. Только администратор.
+func (s *Server) handlePeer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	peers := s.peers()
+	if peers == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Peer discovery is disabled"})
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	addr, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/api/peers/"))
+	if err != nil || addr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid peer address"})
+		return
+	}
+	if err := peers.RemovePeer(addr); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	writePeerList(w, peers, pageRequest{Limit: defaultPageLimit})
+}
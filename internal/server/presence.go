@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/internal/config"
+	"hydra/pkg/presence"
+	"log"
+	"sync"
+	"time"
+)
+
+// Присутствие и набор текста - эфемерные события: они не записываются в журнал и
+// доставляются только в открытые соединения WebSocket (см. websocketEvents). Клиент,
+// подключившийся позже, узнает статусы из /api/contacts.
+
+const (
+	defaultPresenceAwayAfter = 5 * time.Minute
+	defaultPresenceTimeout   = 90 * time.Second
+)
+
+// liveConn - открытое соединение WebSocket пользователя
+type liveConn struct {
+	deviceID string // пусто - клиент не назвал устройство
+	send     func(interface{}) error
+}
+
+// liveConns - открытые соединения пользователей для эфемерных событий
+type liveConns struct {
+	mu    sync.Mutex
+	conns map[string]map[*liveConn]struct{}
+}
+
+func newLiveConns() *liveConns {
+	return &liveConns{conns: make(map[string]map[*liveConn]struct{})}
+}
+
+func (l *liveConns) add(userID, deviceID string, send func(interface{}) error) *liveConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conn := &liveConn{deviceID: deviceID, send: send}
+	if l.conns[userID] == nil {
+		l.conns[userID] = make(map[*liveConn]struct{})
+	}
+	l.conns[userID][conn] = struct{}{}
+	return conn
+}
+
+func (l *liveConns) remove(userID string, conn *liveConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.conns[userID], conn)
+	if len(l.conns[userID]) == 0 {
+		delete(l.conns, userID)
+	}
+}
+
+func (l *liveConns) list(userID string) []*liveConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]*liveConn, 0, len(l.conns[userID]))
+	for conn := range l.conns[userID] {
+		list = append(list, conn)
+	}
+	return list
+}
+
+// devices возвращает устройства пользователя с открытыми соединениями; all - есть
+// соединение без устройства, которое считается подключением всех устройств
+func (l *liveConns) devices(userID string) (devices map[string]bool, all bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	devices = make(map[string]bool)
+	for conn := range l.conns[userID] {
+		if conn.deviceID == "" {
+			all = true
+		}
+		devices[conn.deviceID] = true
+	}
+	return devices, all
+}
+
+// pushEphemeral отправляет событие во все открытые соединения пользователя. Ошибки
+// отправки не важны: соединение закроется, и его обработчик удалит себя сам.
+func (s *Server) pushEphemeral(userID string, v interface{}) {
+	for _, conn := range s.live.list(userID) {
+		conn.send(v)
+	}
+}
+
+// chatPeers возвращает собеседников пользователя по его группам (без него самого)
+func (s *Server) chatPeers(ctx context.Context, userID string) ([]string, error) {
+	groups, err := s.db.ListUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{userID: true}
+	var peers []string
+	for _, g := range groups {
+		members, err := s.db.ListGroupMembers(ctx, g.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if !seen[m.UserID] {
+				seen[m.UserID] = true
+				peers = append(peers, m.UserID)
+			}
+		}
+	}
+	return peers, nil
+}
+
+// broadcastPresence сообщает о смене статуса пользователя его собеседникам и другим
+// его устройствам
+func (s *Server) broadcastPresence(userID, status string) {
+	if s.db == nil {
+		return
+	}
+	peers, err := s.chatPeers(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to list chat peers of %s: %v", userID, err)
+		return
+	}
+	event := map[string]interface{}{"type": "presence", "user_id": userID, "status": status}
+	if status == presence.Offline {
+		event["last_seen"] = s.presence.LastSeen(userID)
+	}
+	for _, peer := range append(peers, userID) {
+		s.pushEphemeral(peer, event)
+	}
+}
+
+// wsFrame - сообщение клиента по WebSocket журнала событий
+type wsFrame struct {
+	Type    string `json:"type"`               // heartbeat или typing
+	Active  bool   `json:"active,omitempty"`   // heartbeat: пользователь был активен
+	To      string `json:"to,omitempty"`       // typing: собеседник в личной беседе
+	GroupID string `json:"group_id,omitempty"` // typing: группа
+	Typing  bool   `json:"typing,omitempty"`   // typing: начал (true) или закончил набор
+}
+
+// handleWSFrame обрабатывает сообщение клиента; неизвестные типы игнорируются
+func (s *Server) handleWSFrame(ctx context.Context, userID string, data []byte) {
+	var frame wsFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return
+	}
+	switch frame.Type {
+	case "heartbeat":
+		s.presence.Heartbeat(userID, frame.Active)
+	case "typing":
+		s.presence.Touch(userID)
+		s.relayTyping(ctx, userID, frame)
+	}
+}
+
+// relayTyping передает признак набора текста участникам беседы. В личной беседе
+// conversation_id у получателя - отправитель, в группе - ID группы.
+func (s *Server) relayTyping(ctx context.Context, userID string, frame wsFrame) {
+	event := map[string]interface{}{"type": "typing", "from": userID, "typing": frame.Typing}
+
+	if frame.GroupID != "" {
+		if s.db == nil {
+			return
+		}
+		member, err := s.db.GetGroupMember(ctx, frame.GroupID, userID)
+		if err != nil || member == nil {
+			return
+		}
+		members, err := s.db.ListGroupMembers(ctx, frame.GroupID)
+		if err != nil {
+			log.Printf("Failed to list members of group %s: %v", frame.GroupID, err)
+			return
+		}
+		event["conversation_id"] = frame.GroupID
+		for _, m := range members {
+			if m.UserID != userID {
+				s.pushEphemeral(m.UserID, event)
+			}
+		}
+		return
+	}
+
+	if frame.To == "" || frame.To == userID {
+		return
+	}
+	event["conversation_id"] = userID
+	s.pushEphemeral(frame.To, event)
+}
+
+// newPresence создает трекер присутствия по настройкам (нулевые - по умолчанию)
+func newPresence(cfg *config.Config) *presence.Tracker {
+	return presence.New(presenceSetting(cfg.PresenceAwayAfter, defaultPresenceAwayAfter),
+		presenceSetting(cfg.PresenceTimeout, defaultPresenceTimeout))
+}
+
+func presenceSetting(value, fallback time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// contactStatus возвращает статус контакта: присутствие его соединений, а для контактов
+// на узлах mesh без соединения с этим сервером - присутствие узла (см. handlePeerEvent)
+func (s *Server) contactStatus(c Contact) string {
+	if status := s.presence.Status(c.ID); status != presence.Offline || c.NodeID == "" {
+		return status
+	}
+	if c.Status == "" {
+		return presence.Offline
+	}
+	return c.Status
+}
+
+// runPresenceSweep переводит в away и offline пользователей, чьи соединения
+// затихли, и сообщает об этом собеседникам
+func (s *Server) runPresenceSweep() {
+	ticker := time.NewTicker(presenceSetting(s.config.PresenceTimeout, defaultPresenceTimeout) / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.presence.Sweep()
+	}
+}
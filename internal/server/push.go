@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/internal/config"
+	"hydra/pkg/push"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Push уведомления получают устройства пользователя без открытого WebSocket: открытое
+// соединение само доставляет события журнала. Уведомление не содержит текста сообщения -
+// только вид события и беседу; клиент забирает содержимое из журнала событий.
+
+const (
+	// callPushTTL - уведомление о звонке бесполезно после того, как звонок перестал звонить
+	callPushTTL = time.Minute
+	pushTimeout = 30 * time.Second
+)
+
+// newPushSenders создает отправителей для настроенных видов подписок; пусто - push выключен
+func newPushSenders(cfg *config.Config) map[string]push.Sender {
+	senders := make(map[string]push.Sender)
+	if cfg.PushVAPIDPrivateKey != "" {
+		wp, err := push.NewWebPush(cfg.PushVAPIDPrivateKey, cfg.PushVAPIDSubject)
+		if err != nil {
+			log.Printf("Warning: Web Push disabled: %v", err)
+		} else {
+			senders[storage.PushWebPush] = wp
+		}
+	}
+	if cfg.PushFCMCredentials != "" {
+		fcm, err := push.NewFCM(cfg.PushFCMCredentials)
+		if err != nil {
+			log.Printf("Warning: FCM disabled: %v", err)
+		} else {
+			senders[storage.PushFCM] = fcm
+		}
+	}
+	return senders
+}
+
+// handlePushConfig обрабатывает GET /api/push/config: включенные виды подписок и открытый
+// ключ VAPID (applicationServerKey для PushManager.subscribe в браузере)
+func (s *Server) handlePushConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	kinds := []string{}
+	for kind := range s.pushSenders {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	response := map[string]interface{}{"success": true, "kinds": kinds}
+	if wp, ok := s.pushSenders[storage.PushWebPush].(*push.WebPush); ok {
+		response["vapid_public_key"] = wp.PublicKey()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePushSubscriptions обрабатывает /api/push/subscriptions: GET - подписки устройств
+// пользователя, POST {device_id, kind, endpoint, keys: {p256dh, auth}} - подписка
+// устройства вместо прежней. Для Web Push тело совпадает с PushSubscription.toJSON()
+// браузера, для FCM endpoint - токен регистрации.
+func (s *Server) handlePushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := s.db.ListPushSubscriptions(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list push subscriptions"})
+			return
+		}
+		if subs == nil {
+			subs = []*storage.PushSubscription{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "subscriptions": subs})
+
+	case http.MethodPost:
+		var req struct {
+			DeviceID string `json:"device_id"`
+			Kind     string `json:"kind"`
+			Endpoint string `json:"endpoint"`
+			Keys     struct {
+				P256dh string `json:"p256dh"`
+				Auth   string `json:"auth"`
+			} `json:"keys"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceID == "" || req.Endpoint == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "device_id and endpoint required"})
+			return
+		}
+		if _, ok := s.pushSenders[req.Kind]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Push kind is not enabled on this server"})
+			return
+		}
+		if req.Kind == storage.PushWebPush {
+			u, err := url.Parse(req.Endpoint)
+			if err != nil || u.Scheme != "https" || u.Host == "" || req.Keys.P256dh == "" || req.Keys.Auth == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Web Push needs an https endpoint and keys"})
+				return
+			}
+		}
+
+		sub := &storage.PushSubscription{
+			UserID:   userID,
+			DeviceID: req.DeviceID,
+			Kind:     req.Kind,
+			Endpoint: req.Endpoint,
+			P256dh:   req.Keys.P256dh,
+			Auth:     req.Keys.Auth,
+		}
+		if err := s.db.SavePushSubscription(r.Context(), sub); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save push subscription"})
+			return
+		}
+		s.touchUser(userID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "subscription": sub})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handlePushSubscription обрабатывает DELETE /api/push/subscriptions/{device_id}: отписка
+// устройства (выход, отключение уведомлений)
+func (s *Server) handlePushSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	ok, err := s.db.DeletePushSubscription(r.Context(), userID, r.PathValue("device_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete push subscription"})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Push subscription not found"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// notifyPush отправляет push о новом сообщении или входящем звонке (kind - вид
+// уведомления дайджеста) на устройства пользователя без открытого WebSocket
+func (s *Server) notifyPush(userID, kind, conversationID string) {
+	if len(s.pushSenders) == 0 || s.db == nil {
+		return
+	}
+	online, all := s.live.devices(userID)
+	if all {
+		return
+	}
+
+	var n push.Notification
+	switch kind {
+	case storage.NotificationMessage:
+		n = push.Notification{
+			Title: "Hydra",
+			Body:  "New message",
+			Data:  map[string]string{"type": "message", "conversation_id": conversationID},
+			TTL:   s.config.PushTTL,
+		}
+	case storage.NotificationCall:
+		n = push.Notification{
+			Title:  "Hydra",
+			Body:   "Incoming call",
+			Data:   map[string]string{"type": "call", "call_id": conversationID},
+			TTL:    callPushTTL,
+			Urgent: true,
+		}
+	default:
+		return
+	}
+	go s.sendPush(userID, n, online)
+}
+
+// sendPush доставляет уведомление на подписанные устройства пользователя, кроме
+// подключенных (skip). Подписки, отозванные службой push, удаляются.
+func (s *Server) sendPush(userID string, n push.Notification, skip map[string]bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	subs, err := s.db.ListPushSubscriptions(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to list push subscriptions of %s: %v", userID, err)
+		return
+	}
+	for _, sub := range subs {
+		sender, ok := s.pushSenders[sub.Kind]
+		if !ok || skip[sub.DeviceID] {
+			continue
+		}
+		err := sender.Send(ctx, push.Subscription{Endpoint: sub.Endpoint, P256dh: sub.P256dh, Auth: sub.Auth}, n)
+		if errors.Is(err, push.ErrGone) {
+			if _, err := s.db.DeletePushSubscription(ctx, userID, sub.DeviceID); err != nil {
+				log.Printf("Failed to delete push subscription of %s: %v", sub.DeviceID, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to push to device %s (%s): %v", sub.DeviceID, sub.Kind, err)
+		}
+	}
+}
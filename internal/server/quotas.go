@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// quotaWarnInterval - как часто администраторы группы получают повторное предупреждение
+const quotaWarnInterval = 24 * time.Hour
+
+// quotaFor возвращает квоту группы: заданную администратором или квоту по умолчанию из конфигурации
+func (s *Server) quotaFor(groupID string) (*storage.Quota, error) {
+	q, err := s.db.GetQuota(context.Background(), groupID)
+	if err != nil || q != nil {
+		return q, err
+	}
+	return &storage.Quota{
+		GroupID:       groupID,
+		MaxMessages:   s.config.QuotaMaxMessages,
+		MaxMediaBytes: s.config.QuotaMaxMediaBytes,
+		Eviction:      s.config.QuotaEviction,
+	}, nil
+}
+
+// planEviction выбирает самые старые записи, удаление которых возвращает объем медиа в квоту,
+// и число сообщений, которые нужно оставить. recordings упорядочены от старых к новым;
+// keepMessages < 0 - удалять сообщения не нужно.
+func planEviction(q *storage.Quota, usage *storage.QuotaUsage, recordings []*storage.Recording) (evict []*storage.Recording, keepMessages int64) {
+	keepMessages = -1
+	if q.Eviction != storage.QuotaEvictOldest {
+		return nil, keepMessages
+	}
+
+	if q.MaxMediaBytes > 0 {
+		media := usage.MediaBytes
+		for _, rec := range recordings {
+			if media <= q.MaxMediaBytes {
+				break
+			}
+			evict = append(evict, rec)
+			media -= rec.SizeBytes
+		}
+	}
+	if q.MaxMessages > 0 && usage.Messages > q.MaxMessages {
+		keepMessages = q.MaxMessages
+	}
+	return evict, keepMessages
+}
+
+// quotaFill возвращает наибольшее заполнение квоты в процентах; 0 - квота без ограничений
+func quotaFill(q *storage.Quota, usage *storage.QuotaUsage) int {
+	fill := 0
+	if q.MaxMessages > 0 {
+		fill = max(fill, int(usage.Messages*100/q.MaxMessages))
+	}
+	if q.MaxMediaBytes > 0 {
+		fill = max(fill, int(usage.MediaBytes*100/q.MaxMediaBytes))
+	}
+	return fill
+}
+
+// enforceQuota применяет политику квоты группы после сохранения новых сообщений или медиа
+// и предупреждает администраторов группы о заполнении
+func (s *Server) enforceQuota(groupID string) {
+	q, err := s.quotaFor(groupID)
+	if err != nil {
+		log.Printf("Failed to load quota of group %s: %v", groupID, err)
+		return
+	}
+	if q.MaxMessages <= 0 && q.MaxMediaBytes <= 0 {
+		return
+	}
+	usage, err := s.db.GetQuotaUsage(context.Background(), groupID)
+	if err != nil {
+		log.Printf("Failed to count usage of group %s: %v", groupID, err)
+		return
+	}
+
+	var recordings []*storage.Recording
+	if q.Eviction == storage.QuotaEvictOldest && q.MaxMediaBytes > 0 && usage.MediaBytes > q.MaxMediaBytes {
+		if recordings, err = s.db.ListRoomRecordings(context.Background(), groupID); err != nil {
+			log.Printf("Failed to list recordings of group %s: %v", groupID, err)
+		}
+	}
+	evict, keepMessages := planEviction(q, usage, recordings)
+	for _, rec := range evict {
+		if err := s.deleteRecording(rec); err != nil {
+			log.Printf("Failed to evict recording %s of group %s: %v", rec.ID, groupID, err)
+			continue
+		}
+		usage.MediaBytes -= rec.SizeBytes
+		log.Printf("Evicted recording %s of group %s (%d bytes) over quota", rec.ID, groupID, rec.SizeBytes)
+	}
+	if keepMessages >= 0 {
+		deleted, err := s.db.DeleteOldestMessages(context.Background(), groupID, keepMessages)
+		if err != nil {
+			log.Printf("Failed to evict messages of group %s: %v", groupID, err)
+		} else {
+			usage.Messages -= deleted
+			log.Printf("Evicted %d oldest messages of group %s over quota", deleted, groupID)
+		}
+	}
+
+	s.warnQuota(q, usage)
+}
+
+// warnQuota предупреждает администраторов группы, если заполнение достигло QUOTA_WARN_PERCENT,
+// не чаще раза в quotaWarnInterval
+func (s *Server) warnQuota(q *storage.Quota, usage *storage.QuotaUsage) {
+	fill := quotaFill(q, usage)
+	if len(q.Admins) == 0 || s.config.QuotaWarnPercent <= 0 || fill < s.config.QuotaWarnPercent {
+		return
+	}
+
+	s.mu.Lock()
+	if s.quotaWarned == nil {
+		s.quotaWarned = make(map[string]time.Time)
+	}
+	if time.Since(s.quotaWarned[q.GroupID]) < quotaWarnInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.quotaWarned[q.GroupID] = time.Now()
+	s.mu.Unlock()
+
+	payload := map[string]interface{}{
+		"group_id": q.GroupID,
+		"percent":  fill,
+		"quota":    q,
+		"usage":    usage,
+	}
+	for _, admin := range q.Admins {
+		s.appendEvent(admin, storage.EventQuotaWarning, payload)
+	}
+	log.Printf("Group %s is at %d%% of its quota, %d admins warned", q.GroupID, fill, len(q.Admins))
+}
+
+// messageHeadroom возвращает, сколько сообщений еще примет группа с политикой reject;
+// -1 - без ограничения
+func (s *Server) messageHeadroom(groupID string) int64 {
+	q, err := s.quotaFor(groupID)
+	if err != nil || q.Eviction != storage.QuotaReject || q.MaxMessages <= 0 {
+		return -1
+	}
+	usage, err := s.db.GetQuotaUsage(context.Background(), groupID)
+	if err != nil {
+		return -1
+	}
+	return max(q.MaxMessages-usage.Messages, 0)
+}
+
+// mediaQuotaExceeded сообщает, что группа с политикой reject исчерпала квоту медиа
+func (s *Server) mediaQuotaExceeded(groupID string) bool {
+	if s.db == nil {
+		return false
+	}
+	q, err := s.quotaFor(groupID)
+	if err != nil || q.Eviction != storage.QuotaReject || q.MaxMediaBytes <= 0 {
+		return false
+	}
+	usage, err := s.db.GetQuotaUsage(context.Background(), groupID)
+	return err == nil && usage.MediaBytes >= q.MaxMediaBytes
+}
+
+// validQuota проверяет квоту, заданную администратором
+func validQuota(q *storage.Quota) string {
+	if q.MaxMessages < 0 || q.MaxMediaBytes < 0 {
+		return "Limits must not be negative"
+	}
+	if q.Eviction != storage.QuotaEvictOldest && q.Eviction != storage.QuotaReject {
+		return "eviction must be evict_oldest or reject"
+	}
+	return ""
+}
+
+// handleAdminQuotas возвращает квоты групп с текущим заполнением: GET /api/admin/quotas
+func (s *Server) handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	quotas, err := s.db.ListQuotas(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list quotas"})
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(quotas))
+	for _, q := range quotas {
+		usage, err := s.db.GetQuotaUsage(r.Context(), q.GroupID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to count usage"})
+			return
+		}
+		list = append(list, map[string]interface{}{"quota": q, "usage": usage, "percent": quotaFill(q, usage)})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"quotas":  list,
+		"default": map[string]interface{}{
+			"max_messages":    s.config.QuotaMaxMessages,
+			"max_media_bytes": s.config.QuotaMaxMediaBytes,
+			"eviction":        s.config.QuotaEviction,
+			"warn_percent":    s.config.QuotaWarnPercent,
+		},
+	})
+}
+
+// handleAdminQuota управляет квотой группы: GET (квота и заполнение), PUT (задать квоту и
+// сразу применить ее), DELETE (вернуть квоту по умолчанию) /api/admin/quotas/{group_id}
+func (s *Server) handleAdminQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	groupID := strings.TrimPrefix(r.URL.Path, "/api/admin/quotas/")
+	if groupID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "group_id is required"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := s.quotaFor(groupID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to get quota"})
+			return
+		}
+		usage, err := s.db.GetQuotaUsage(r.Context(), groupID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to count usage"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "quota": q, "usage": usage, "percent": quotaFill(q, usage)})
+
+	case http.MethodPut:
+		q := storage.Quota{Eviction: s.config.QuotaEviction}
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		q.GroupID = groupID
+		if msg := validQuota(&q); msg != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": msg})
+			return
+		}
+		if err := s.db.SetQuota(r.Context(), &q); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to set quota"})
+			return
+		}
+		log.Printf("Quota of group %s set: %d messages, %d media bytes, %s", groupID, q.MaxMessages, q.MaxMediaBytes, q.Eviction)
+		s.enforceQuota(groupID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "quota": q})
+
+	case http.MethodDelete:
+		found, err := s.db.DeleteQuota(r.Context(), groupID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete quota"})
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Quota not found"})
+			return
+		}
+		log.Printf("Quota of group %s removed", groupID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hydra/pkg/reachability"
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleReachabilityReport принимает зашифрованный отчет клиента о работающих в его
+// регионе фронтах и ретрансляторах: POST /api/reachability/report (reachability.SealedReport)
+func (s *Server) handleReachabilityReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	if !s.reportLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many reports"})
+		return
+	}
+
+	var sealed reachability.SealedReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&sealed); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+
+	report, reporter, err := reachability.Open(&sealed, s.reportKey)
+	if err == nil {
+		err = s.reachability.Add(reporter, report, time.Now())
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleBridges раздает подписанные ключом сервера региональные подсказки о доступности
+// путей (по отчетам клиентов и телеметрии узлов) и ключ для шифрования отчетов:
+// GET /api/bridges?region=...
+func (s *Server) handleBridges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	list := &reachability.HintList{
+		Region:    region,
+		IssuedAt:  time.Now().UnixMilli(),
+		ReportKey: base64.StdEncoding.EncodeToString(s.reportKey.PublicKey().Bytes()),
+		Hints:     append(s.reachability.Hints(region, time.Now()), s.telemetry.Hints(region, time.Now())...),
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "bridges": reachability.SignHints(s.timeSigner, list)})
+}
+
+// regionBlockedFronts возвращает фронты, заблокированные по отчетам клиентов в регионе сервера
+func (s *Server) regionBlockedFronts() []string {
+	if s.config.ReachabilityRegion == "" {
+		return nil
+	}
+
+	var blocked []string
+	for _, hint := range s.reachability.Hints(s.config.ReachabilityRegion, time.Now()) {
+		if hint.Kind == reachability.KindFront && hint.Status == reachability.StatusBlocked {
+			blocked = append(blocked, hint.Target)
+		}
+	}
+	if len(blocked) > 0 {
+		log.Printf("Fronts reported blocked by clients in %s: %v", s.config.ReachabilityRegion, blocked)
+	}
+	return blocked
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+// receiptStateTTL - сколько хранится сводка сообщения без новых квитанций
+const receiptStateTTL = 24 * time.Hour
+
+// runReceiptBatches раз в ReceiptBatchInterval отправляет авторам пакеты сводок квитанций
+// групповых сообщений: одно событие на автора вместо квитанции от каждого участника
+func (s *Server) runReceiptBatches() {
+	interval := s.config.ReceiptBatchInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.flushReceipts()
+		s.receipts.Forget(time.Now().Add(-receiptStateTTL))
+	}
+}
+
+// flushReceipts записывает накопившиеся сводки в журналы авторов
+func (s *Server) flushReceipts() {
+	for _, batch := range s.receipts.Flush() {
+		s.appendEvent(batch.SenderID, storage.EventReceiptBatch, batch)
+	}
+}
+
+// trackDelivery отмечает личное сообщение отправленным получателю: дальше его состояние
+// (delivered, read) продвигают квитанции /api/messages/{id}/receipt
+func (s *Server) trackDelivery(messageID, from, to string) {
+	if s.db == nil || to == "" {
+		return
+	}
+	if err := s.db.CreateReceipts(context.Background(), messageID, from, []string{to}); err != nil {
+		log.Printf("Failed to track delivery of %s: %v", messageID, err)
+	}
+}
+
+// handleMessageReceipt обрабатывает /api/messages/{id}/receipt:
+// POST {status} - получатель сообщает delivered или read, при изменении состояния
+// автор получает событие receipt; GET - состояние доставки всем получателям
+// (автору сообщения или его получателю). Пользователь определяется по токену входа;
+// user_id в запросе необязателен и должен с ним совпадать.
+func (s *Server) handleMessageReceipt(w http.ResponseWriter, r *http.Request, messageID string) {
+	w.Header().Set("Content-Type", "application/json")
+	caller, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if req.UserID != "" && req.UserID != caller {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User does not match the token"})
+			return
+		}
+		req.UserID = caller
+		if req.Status != storage.ReceiptDelivered && req.Status != storage.ReceiptRead {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "status must be delivered or read"})
+			return
+		}
+
+		receipt, changed, err := s.db.UpdateReceipt(r.Context(), messageID, req.UserID, req.Status, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message not found"})
+			return
+		}
+		if changed {
+			s.appendEvent(receipt.SenderID, storage.EventReceipt, receipt)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "receipt": receipt, "changed": changed})
+
+	case http.MethodGet:
+		if userID := r.URL.Query().Get("user_id"); userID != "" && userID != caller {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User does not match the token"})
+			return
+		}
+		receipts, err := s.db.ListReceipts(r.Context(), messageID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list receipts"})
+			return
+		}
+		allowed := false
+		for _, receipt := range receipts {
+			allowed = allowed || receipt.SenderID == caller || receipt.UserID == caller
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "receipts": receipts})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"hydra/pkg/webrtc"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// recordingRequest - запрос управления записью комнаты
+type recordingRequest struct {
+	CallID  string `json:"call_id"`
+	RoomID  string `json:"room_id,omitempty"`
+	Granted bool   `json:"granted,omitempty"` // для consent: согласие участника
+}
+
+// handleCallRecording управляет записью комнаты: start (запрос согласия у всех участников),
+// consent (ответ участника), stop; GET возвращает текущее состояние записи комнаты.
+// start и consent принимаются только от пользователя звонка call_id, stop - от участника
+// комнаты или администратора.
+func (s *Server) handleCallRecording(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodGet && action == "status" {
+			rec, err := s.callManager.GetRecording(r.URL.Query().Get("room_id"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "recording": rec})
+			return
+		}
+		if r.Method != http.MethodPost || action == "status" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+			return
+		}
+
+		caller, err := s.bearerUser(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+			return
+		}
+		var req recordingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if action == "stop" {
+			if !s.inRecordedRoom(r, req.RoomID, caller) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only room participants can stop recording"})
+				return
+			}
+		} else if owner, err := s.callManager.CallUser(req.CallID); err != nil || owner != caller {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Call does not belong to the caller"})
+			return
+		}
+
+		var rec *webrtc.Recording
+
+		switch action {
+		case "start":
+			if s.mediaQuotaExceeded(req.RoomID) {
+				w.WriteHeader(http.StatusInsufficientStorage)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Group media quota exceeded"})
+				return
+			}
+			rec, err = s.callManager.RequestRecording(req.RoomID, req.CallID)
+		case "consent":
+			rec, err = s.callManager.SetRecordingConsent(req.CallID, req.Granted)
+		case "stop":
+			rec, err = s.callManager.StopRecording(req.RoomID)
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "recording": rec})
+	}
+}
+
+// handleRecordings возвращает список сохраненных записей: администратору - все,
+// пользователю - записи с его участием
+func (s *Server) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	_, admin := s.adminActor(r)
+	caller, err := s.bearerUser(r)
+	if !admin && err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	recordings, err := s.db.ListRecordings(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load recordings"})
+		return
+	}
+	visible := []*storage.Recording{}
+	for _, rec := range recordings {
+		if admin || slices.Contains(rec.Users, caller) {
+			visible = append(visible, rec)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "recordings": visible})
+}
+
+// handleRecording обрабатывает /api/recordings/{id} (GET - метаданные, DELETE - удаление)
+// и /api/recordings/{id}/{call_id}.ogg (скачивание записи участника). Метаданные и файлы
+// доступны участникам записи и администраторам, удаляет запись только администратор.
+func (s *Server) handleRecording(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/recordings/"), "/"), "/")
+	id := parts[0]
+
+	_, admin := s.adminActor(r)
+	caller, authErr := s.bearerUser(r)
+	if !admin && authErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	rec, err := s.db.GetRecording(r.Context(), id)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recording not found"})
+		return
+	}
+	if !admin && (r.Method == http.MethodDelete || !slices.Contains(rec.Users, caller)) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Access to the recording denied"})
+		return
+	}
+
+	if len(parts) == 2 && r.Method == http.MethodGet {
+		s.serveRecordingFile(w, r, rec, strings.TrimSuffix(parts[1], ".ogg"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		files := make(map[string]string, len(rec.Participants))
+		for _, callID := range rec.Participants {
+			files[callID] = "/api/recordings/" + rec.ID + "/" + callID + ".ogg"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "recording": rec, "files": files})
+
+	case http.MethodDelete:
+		if err := s.deleteRecording(rec); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// inRecordedRoom сообщает, может ли пользователь остановить запись комнаты: он
+// администратор или один из звонков комнаты - его
+func (s *Server) inRecordedRoom(r *http.Request, roomID, userID string) bool {
+	if _, admin := s.adminActor(r); admin {
+		return true
+	}
+	participants, err := s.callManager.GetRoomParticipants(roomID)
+	if err != nil {
+		return false
+	}
+	for _, callID := range participants {
+		if owner, err := s.callManager.CallUser(callID); err == nil && owner == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveRecordingFile(w http.ResponseWriter, r *http.Request, rec *storage.Recording, callID string) {
+	found := false
+	for _, p := range rec.Participants {
+		if p == callID {
+			found = true
+			break
+		}
+	}
+	if !found || s.blobs == nil {
+		http.Error(w, "Recording file not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := s.blobs.Open(recordingKey(rec.ID, callID))
+	if err != nil {
+		http.Error(w, "Recording file not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "audio/ogg")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+rec.ID+"-"+callID+".ogg\"")
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Failed to send recording %s: %v", rec.ID, err)
+	}
+}
+
+// saveRecording сохраняет метаданные завершенной записи со сроком хранения
+func (s *Server) saveRecording(rec *webrtc.Recording) {
+	meta := &storage.Recording{
+		ID:          rec.ID,
+		RoomID:      rec.RoomID,
+		RequestedBy: rec.RequestedBy,
+		StartedAt:   rec.StartedAt,
+		StoppedAt:   rec.StoppedAt,
+		ExpiresAt:   rec.StoppedAt.Add(s.config.RecordingRetention),
+	}
+	for callID, key := range rec.Files {
+		meta.Participants = append(meta.Participants, callID)
+		if userID := rec.Users[callID]; userID != "" && !slices.Contains(meta.Users, userID) {
+			meta.Users = append(meta.Users, userID)
+		}
+		if info, err := s.blobs.Stat(key); err == nil {
+			meta.SizeBytes += info.Size
+		}
+	}
+
+	if err := s.db.CreateRecording(context.Background(), meta); err != nil {
+		log.Printf("Failed to save recording %s: %v", rec.ID, err)
+		return
+	}
+	log.Printf("Saved recording %s (%d participants, %d bytes)", rec.ID, len(meta.Participants), meta.SizeBytes)
+	s.enforceQuota(rec.RoomID)
+}
+
+func (s *Server) deleteRecording(rec *storage.Recording) error {
+	if s.blobs != nil {
+		if err := s.blobs.DeletePrefix(path.Dir(recordingKey(rec.ID, ""))); err != nil {
+			return err
+		}
+	}
+	return s.db.DeleteRecording(context.Background(), rec.ID)
+}
+
+// runRecordingRetention периодически удаляет записи с истекшим сроком хранения
+func (s *Server) runRecordingRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		expired, err := s.db.ListExpiredRecordings(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Recording retention check failed: %v", err)
+		}
+		for _, rec := range expired {
+			if err := s.deleteRecording(rec); err != nil {
+				log.Printf("Failed to delete expired recording %s: %v", rec.ID, err)
+				continue
+			}
+			log.Printf("Deleted expired recording %s", rec.ID)
+		}
+		<-ticker.C
+	}
+}
+
+// recordingKey - ключ файла участника в blob-хранилище (совпадает с ключом, который пишет CallManager)
+func recordingKey(recordingID, callID string) string {
+	return "recordings/" + recordingID + "/" + callID + ".ogg"
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hydra/pkg/recovery"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Восстановление аккаунта поручителями (см. pkg/recovery). Пользователь назначает
+// поручителей (PUT /api/users/{id}/guardians). Потеряв устройство, он создает запрос
+// (POST /api/recovery) и получает секрет запроса; поручители, убедившись лично, что
+// просит именно он, одобряют запрос (POST /api/recovery/{id}/approve). После порога
+// одобрений и RECOVERY_DELAY владелец секрета задает новый пароль
+// (POST /api/recovery/{id}/complete). Владелец аккаунта получает событие recovery
+// на каждом шаге и может отменить запрос (POST /api/recovery/{id}/cancel).
+
+// bearerUser возвращает пользователя по токену входа из заголовка Authorization
+func (s *Server) bearerUser(r *http.Request) (string, error) {
+	userID, _, err := s.bearerSession(r)
+	return userID, err
+}
+
+// hashRecoverySecret возвращает хеш секрета запроса восстановления, хранящийся в БД
+func hashRecoverySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// notifyRecovery сообщает владельцу и поручителям об изменении запроса восстановления
+func (s *Server) notifyRecovery(req *storage.RecoveryRequest, guardians []string, change string) {
+	payload := map[string]interface{}{
+		"recovery_id":  req.ID,
+		"user_id":      req.UserID,
+		"change":       change,
+		"requested_at": req.RequestedAt,
+	}
+	s.appendEvent(req.UserID, storage.EventRecovery, payload)
+	for _, g := range guardians {
+		s.appendEvent(g, storage.EventRecovery, payload)
+	}
+}
+
+// handleUserGuardians обрабатывает /api/users/{id}/guardians: GET - поручители,
+// PUT {guardians, threshold} - назначить, DELETE - отключить восстановление поручителями.
+// Доступно только самому пользователю (токен входа в заголовке Authorization).
+func (s *Server) handleUserGuardians(w http.ResponseWriter, r *http.Request, userID string) {
+	if caller, err := s.bearerUser(r); err != nil || caller != userID {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		guardians, err := s.db.GetRecoveryGuardians(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load guardians"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "guardians": guardians})
+
+	case http.MethodPut:
+		var req struct {
+			Guardians []string `json:"guardians"`
+			Threshold int      `json:"threshold"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		if err := recovery.ValidateGuardians(userID, req.Guardians, req.Threshold); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		for _, g := range req.Guardians {
+			if _, err := s.db.GetUser(r.Context(), g); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Guardian not found: " + g})
+				return
+			}
+		}
+
+		previous, err := s.db.GetRecoveryGuardians(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load guardians"})
+			return
+		}
+		guardians := &storage.RecoveryGuardians{UserID: userID, Guardians: req.Guardians, Threshold: req.Threshold}
+		if err := s.db.SetRecoveryGuardians(r.Context(), guardians); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save guardians"})
+			return
+		}
+
+		// Новые поручители узнают о назначении, чтобы ждать возможных запросов
+		known := make(map[string]bool)
+		if previous != nil {
+			for _, g := range previous.Guardians {
+				known[g] = true
+			}
+		}
+		for _, g := range req.Guardians {
+			if !known[g] {
+				s.appendEvent(g, storage.EventRecovery, map[string]interface{}{"user_id": userID, "change": "guardian_added"})
+			}
+		}
+		log.Printf("User %s set %d recovery guardians, threshold %d", userID, len(req.Guardians), req.Threshold)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "guardians": guardians})
+
+	case http.MethodDelete:
+		if _, err := s.db.DeleteRecoveryGuardians(r.Context(), userID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete guardians"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleRecovery создает запрос восстановления: POST /api/recovery {user_id}.
+// Секрет запроса возвращается один раз; им подтверждаются просмотр и завершение запроса.
+func (s *Server) handleRecovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	if !s.recoveryLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many recovery requests"})
+		return
+	}
+
+	var body struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+
+	guardians, err := s.db.GetRecoveryGuardians(r.Context(), body.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load guardians"})
+		return
+	}
+	if guardians == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery by guardians is not set up"})
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create recovery request"})
+		return
+	}
+	secret := hex.EncodeToString(buf)
+
+	now := time.Now()
+	req := &storage.RecoveryRequest{
+		UserID:      body.UserID,
+		SecretHash:  hashRecoverySecret(secret),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(s.config.RecoveryTTL),
+	}
+	if err := s.db.CreateRecoveryRequest(r.Context(), req); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create recovery request"})
+		return
+	}
+	s.notifyRecovery(req, guardians.Guardians, "requested")
+
+	log.Printf("Recovery %s requested for user %s", req.ID, req.UserID)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"recovery":  req,
+		"secret":    secret,
+		"guardians": len(guardians.Guardians),
+		"threshold": guardians.Threshold,
+		"ready_at":  now.Add(s.config.RecoveryDelay),
+	})
+}
+
+// handleRecoveryRequest обрабатывает /api/recovery/{id}: GET - состояние (владельцу секрета
+// в заголовке X-Recovery-Secret, владельцу аккаунта или поручителю по токену входа),
+// POST approve, cancel и complete {secret, password}
+func (s *Server) handleRecoveryRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recovery/"), "/")
+	if (action == "" && r.Method != http.MethodGet) || (action != "" && r.Method != http.MethodPost) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	req, err := s.db.GetRecoveryRequest(r.Context(), id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load recovery request"})
+		return
+	}
+	if req == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery request not found"})
+		return
+	}
+	guardians, err := s.db.GetRecoveryGuardians(r.Context(), req.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load guardians"})
+		return
+	}
+	if guardians == nil {
+		guardians = &storage.RecoveryGuardians{UserID: req.UserID}
+	}
+
+	switch action {
+	case "":
+		s.recoveryStatus(w, r, req, guardians)
+	case "approve":
+		s.approveRecovery(w, r, req, guardians)
+	case "cancel":
+		s.cancelRecovery(w, r, req, guardians)
+	case "complete":
+		s.completeRecovery(w, r, req, guardians)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown action"})
+	}
+}
+
+// isGuardian сообщает, что userID - текущий поручитель
+func isGuardian(guardians *storage.RecoveryGuardians, userID string) bool {
+	for _, g := range guardians.Guardians {
+		if g == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) recoveryStatus(w http.ResponseWriter, r *http.Request, req *storage.RecoveryRequest, guardians *storage.RecoveryGuardians) {
+	allowed := false
+	if secret := r.Header.Get("X-Recovery-Secret"); secret != "" {
+		allowed = subtle.ConstantTimeCompare([]byte(hashRecoverySecret(secret)), []byte(req.SecretHash)) == 1
+	} else if caller, err := s.bearerUser(r); err == nil {
+		allowed = caller == req.UserID || isGuardian(guardians, caller)
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	approvals, err := s.db.ListRecoveryApprovals(r.Context(), req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load approvals"})
+		return
+	}
+	if approvals == nil {
+		approvals = []*recovery.Approval{}
+	}
+	approved := recovery.Counted(approvals, guardians.Guardians)
+	response := map[string]interface{}{
+		"success":   true,
+		"recovery":  req,
+		"active":    req.Active(time.Now()),
+		"approvals": approvals,
+		"approved":  approved,
+		"threshold": guardians.Threshold,
+		"ready_at":  req.RequestedAt.Add(s.config.RecoveryDelay),
+	}
+	if err := recovery.Ready(approved, guardians.Threshold, req.RequestedAt, time.Now(), s.config.RecoveryDelay); err != nil {
+		response["waiting"] = err.Error()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) approveRecovery(w http.ResponseWriter, r *http.Request, req *storage.RecoveryRequest, guardians *storage.RecoveryGuardians) {
+	caller, err := s.bearerUser(r)
+	if err != nil || !isGuardian(guardians, caller) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only a guardian can approve recovery"})
+		return
+	}
+	if !req.Active(time.Now()) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery request is no longer active"})
+		return
+	}
+
+	approval := recovery.SignApproval(s.timeSigner, req.ID, req.UserID, caller, time.Now())
+	added, err := s.db.AddRecoveryApproval(r.Context(), approval)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save approval"})
+		return
+	}
+	if added {
+		s.appendEvent(req.UserID, storage.EventRecovery, map[string]interface{}{
+			"recovery_id": req.ID, "user_id": req.UserID, "change": "approved", "guardian_id": caller,
+		})
+		log.Printf("Recovery %s of user %s approved by %s", req.ID, req.UserID, caller)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "approval": approval})
+}
+
+func (s *Server) cancelRecovery(w http.ResponseWriter, r *http.Request, req *storage.RecoveryRequest, guardians *storage.RecoveryGuardians) {
+	if caller, err := s.bearerUser(r); err != nil || caller != req.UserID {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Only the account owner can cancel recovery"})
+		return
+	}
+
+	cancelled, err := s.db.CancelRecoveryRequests(r.Context(), req.UserID, req.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to cancel recovery"})
+		return
+	}
+	if cancelled == 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery request is no longer active"})
+		return
+	}
+	s.notifyRecovery(req, guardians.Guardians, "cancelled")
+	log.Printf("Recovery %s cancelled by user %s", req.ID, req.UserID)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func (s *Server) completeRecovery(w http.ResponseWriter, r *http.Request, req *storage.RecoveryRequest, guardians *storage.RecoveryGuardians) {
+	var body struct {
+		Secret   string `json:"secret"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRecoverySecret(body.Secret)), []byte(req.SecretHash)) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid recovery secret"})
+		return
+	}
+	if body.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Password is required"})
+		return
+	}
+	if !req.Active(time.Now()) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery request is no longer active"})
+		return
+	}
+
+	approvals, err := s.db.ListRecoveryApprovals(r.Context(), req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load approvals"})
+		return
+	}
+	if guardians.Threshold == 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Recovery by guardians is not set up"})
+		return
+	}
+	approved := recovery.Counted(approvals, guardians.Guardians)
+	if err := recovery.Ready(approved, guardians.Threshold, req.RequestedAt, time.Now(), s.config.RecoveryDelay); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	if err := s.db.CompleteRecovery(r.Context(), req, body.Password); err != nil {
+		log.Printf("Failed to complete recovery %s: %v", req.ID, err)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to complete recovery"})
+		return
+	}
+	// Сессии и токены утерянного устройства больше не действуют
+	if err := s.db.RevokeUserSessions(r.Context(), req.UserID); err != nil {
+		log.Printf("Failed to revoke sessions of %s: %v", req.UserID, err)
+	}
+	if err := s.revokeTokens(r.Context(), req.UserID); err != nil {
+		log.Printf("Failed to revoke tokens of %s: %v", req.UserID, err)
+	}
+	user, err := s.db.GetUser(r.Context(), req.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load user"})
+		return
+	}
+	s.notifyRecovery(req, guardians.Guardians, "completed")
+	log.Printf("Recovery %s of user %s completed with %d of %d approvals", req.ID, req.UserID, approved, guardians.Threshold)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"user":      user,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
+		"approvals": approvals,
+	})
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/storage"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Сроки хранения истории. Участник беседы выбирает, сколько хранить ее историю
+// (PUT /api/conversations/{id}/retention {keep}); раз в час сообщения, вложения,
+// голосовые сообщения и архивы холодного хранения старше срока удаляются. Архив
+// удаляется, когда срок истек для всех его сообщений; до этого более старые сообщения
+// архива не выдаются в истории.
+
+// retentionOptions - допустимые сроки хранения; 0 - бессрочно
+var retentionOptions = map[string]time.Duration{
+	"7d":      7 * 24 * time.Hour,
+	"30d":     30 * 24 * time.Hour,
+	"forever": 0,
+}
+
+// retentionKeep возвращает название срока хранения политики
+func retentionKeep(p *storage.RetentionPolicy) string {
+	if p == nil {
+		return "forever"
+	}
+	for keep, ttl := range retentionOptions {
+		if ttl == p.TTL {
+			return keep
+		}
+	}
+	return p.TTL.String()
+}
+
+// runRetention раз в час удаляет историю бесед старше их срока хранения
+func (s *Server) runRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		s.purgeExpired(time.Now())
+		<-ticker.C
+	}
+}
+
+// purgeExpired удаляет историю бесед, срок хранения которой истек к now
+func (s *Server) purgeExpired(now time.Time) {
+	ctx := context.Background()
+	s.purgeMessageEdits(now)
+
+	policies, err := s.db.ListRetention(ctx)
+	if err != nil {
+		log.Printf("Failed to list retention policies: %v", err)
+		return
+	}
+
+	for _, p := range policies {
+		before := now.Add(-p.TTL)
+		if n, err := s.db.DeleteMessagesBefore(ctx, p.ConversationID, before); err != nil {
+			log.Printf("Failed to purge messages of %s: %v", p.ConversationID, err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired messages of conversation %s", n, p.ConversationID)
+		}
+
+		if s.blobs != nil {
+			attachments, err := s.db.ListAttachmentsBefore(ctx, p.ConversationID, before)
+			if err != nil {
+				log.Printf("Failed to list expired attachments of %s: %v", p.ConversationID, err)
+			}
+			for _, a := range attachments {
+				if err := s.removeAttachment(a); err != nil {
+					log.Printf("Failed to delete expired attachment %s: %v", a.ID, err)
+				}
+			}
+		}
+
+		voiceFiles, err := s.db.ListVoiceFilesBefore(ctx, p.ConversationID, before)
+		if err != nil {
+			log.Printf("Failed to list expired voice messages of %s: %v", p.ConversationID, err)
+		}
+		for _, v := range voiceFiles {
+			if err := os.Remove(v.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Failed to delete expired voice message %s: %v", v.ID, err)
+				continue
+			}
+			if err := s.db.DeleteVoiceFile(ctx, v.ID); err != nil {
+				log.Printf("Failed to delete voice message record %s: %v", v.ID, err)
+			}
+		}
+
+		if s.archives != nil {
+			archives, err := s.db.ListMessageArchives(ctx, p.ConversationID)
+			if err != nil {
+				log.Printf("Failed to list archives of %s: %v", p.ConversationID, err)
+			}
+			for _, a := range archives {
+				if !a.LastAt.Before(before) {
+					continue
+				}
+				if err := s.archives.Delete(a.Key); err != nil {
+					log.Printf("Failed to delete expired archive %s: %v", a.Key, err)
+					continue
+				}
+				if err := s.db.DeleteMessageArchive(ctx, a.ID); err != nil {
+					log.Printf("Failed to delete archive record %s: %v", a.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// retentionCutoff возвращает момент, раньше которого история беседы не выдается;
+// нулевое время - беседа хранится бессрочно
+func (s *Server) retentionCutoff(ctx context.Context, conversationID string) (time.Time, error) {
+	p, err := s.db.GetRetention(ctx, conversationID)
+	if err != nil || p == nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-p.TTL), nil
+}
+
+// handleConversationRetention обрабатывает /api/conversations/{id}/retention:
+// GET - срок хранения истории, PUT {keep: 7d, 30d или forever} - выбрать срок
+func (s *Server) handleConversationRetention(w http.ResponseWriter, r *http.Request, userID, conversationID string) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := s.db.GetRetention(r.Context(), conversationID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load retention policy"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "retention": retentionJSON(conversationID, p)})
+
+	case http.MethodPut:
+		var req struct {
+			Keep string `json:"keep"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+		ttl, ok := retentionOptions[req.Keep]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "keep must be 7d, 30d or forever"})
+			return
+		}
+
+		p := &storage.RetentionPolicy{ConversationID: conversationID, TTL: ttl, UpdatedBy: userID, UpdatedAt: time.Now()}
+		if err := s.db.SetRetention(r.Context(), p); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save retention policy"})
+			return
+		}
+		if ttl == 0 {
+			p = nil
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "retention": retentionJSON(conversationID, p)})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// retentionJSON - политика беседы в ответе API; nil - бессрочное хранение
+func retentionJSON(conversationID string, p *storage.RetentionPolicy) map[string]interface{} {
+	result := map[string]interface{}{"conversation_id": conversationID, "keep": retentionKeep(p), "ttl_seconds": 0}
+	if p != nil {
+		result["ttl_seconds"] = int64(p.TTL / time.Second)
+		result["updated_by"] = p.UpdatedBy
+		result["updated_at"] = p.UpdatedAt
+	}
+	return result
+}
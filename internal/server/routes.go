@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// API доступен по /api/v1/... Прежние пути /api/... остаются псевдонимами v1 для уже
+// выпущенных клиентов. Обработчики разбирают пути без версии: запрос к /api/v1/x
+// доходит до них как /api/x, параметры пути ({id}) доступны через r.PathValue.
+
+const (
+	apiPrefix    = "/api"
+	apiVersionV1 = "/v1"
+	apiPrefixV1  = apiPrefix + apiVersionV1
+	anyMethod    = ""
+)
+
+// router - маршруты API: путь (шаблон ServeMux без метода) -> метод -> обработчик
+type router struct {
+	routes map[string]map[string]http.HandlerFunc
+	order  []string
+}
+
+func newRouter() *router {
+	return &router{routes: make(map[string]map[string]http.HandlerFunc)}
+}
+
+// handle регистрирует обработчик метода (anyMethod - любого) для пути относительно /api
+func (rt *router) handle(method, pattern string, h http.HandlerFunc) {
+	if rt.routes[pattern] == nil {
+		rt.routes[pattern] = make(map[string]http.HandlerFunc)
+		rt.order = append(rt.order, pattern)
+	}
+	rt.routes[pattern][method] = h
+}
+
+// register добавляет маршруты в mux под /api/v1 и под прежним /api
+func (rt *router) register(mux *http.ServeMux) {
+	for _, pattern := range rt.order {
+		h := dispatchMethod(rt.routes[pattern])
+		mux.Handle(apiPrefixV1+pattern, unversioned(h))
+		mux.Handle(apiPrefix+pattern, h)
+	}
+}
+
+// dispatchMethod выбирает обработчик по методу запроса. HEAD обслуживается как GET,
+// на неподдерживаемый метод - 405 с заголовком Allow.
+func dispatchMethod(methods map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := methods[r.Method]
+		if !ok && r.Method == http.MethodHead {
+			h, ok = methods[http.MethodGet]
+		}
+		if !ok {
+			h, ok = methods[anyMethod]
+		}
+		if ok {
+			h(w, r)
+			return
+		}
+
+		allow := make([]string, 0, len(methods))
+		for method := range methods {
+			allow = append(allow, method)
+		}
+		if slices.Contains(allow, http.MethodGet) {
+			allow = append(allow, http.MethodHead)
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// unversioned передает обработчику запрос к /api/v1/... с путем /api/...
+func unversioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = apiPath(r.URL.Path)
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// apiPath возвращает путь API без версии: /api/v1/x -> /api/x
+func apiPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, apiPrefixV1+"/"); ok {
+		return apiPrefix + "/" + rest
+	}
+	return path
+}
+
+// middleware - обертка обработчика (журнал, авторизация, ограничение частоты и т.п.)
+type middleware func(http.Handler) http.Handler
+
+// chain оборачивает h в middlewares; первая в списке выполняется первой
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Handler возвращает HTTP обработчик сервера: статические файлы веб-клиента, API и
+// ретранслятор сигнализации, обернутые в общие middleware
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(s.config.WebStaticPath)))
+	s.routes().register(mux)
+	if s.signaling != nil {
+		v1 := apiPrefixV1 + strings.TrimPrefix(signalingPath, apiPrefix)
+		mux.Handle(signalingPath, s.signaling.Handler(signalingPath))
+		mux.Handle(v1, s.signaling.Handler(v1))
+	}
+	return chain(mux, s.withSecurityHeaders, s.withCORS, s.withLocale, s.withMaintenance)
+}
+
+// routes описывает API. Пути относительно /api; путь с / на конце обслуживает и все
+// вложенные пути.
+func (s *Server) routes() *router {
+	rt := newRouter()
+
+	rt.handle(anyMethod, "/contacts", s.handleContacts)
+	rt.handle(anyMethod, "/contacts/sync", s.handleContactSync)
+	rt.handle(anyMethod, "/send", s.handleSend)
+	rt.handle(anyMethod, "/status", s.handleStatus)
+	rt.handle(anyMethod, "/time", s.handleTime)
+
+	// Транспорт и mesh
+	rt.handle(anyMethod, "/transport/events", s.withAdmin(s.handleTransportEvents))
+	rt.handle(anyMethod, "/transport/blocks", s.withAdmin(s.handleTransportBlocks))
+	rt.handle(anyMethod, "/mesh/topology", s.handleMeshTopology)
+	rt.handle(anyMethod, "/mesh/connect", s.handleMeshConnect)
+	rt.handle(anyMethod, "/mesh/resources", s.handleMeshResources)
+	rt.handle(anyMethod, "/peers", s.handlePeers)
+	rt.handle(anyMethod, "/peers/events", s.handlePeerEvents)
+	rt.handle(anyMethod, "/peers/", s.handlePeer)
+	rt.handle(anyMethod, "/reachability/report", s.handleReachabilityReport)
+	rt.handle(anyMethod, "/bridges", s.handleBridges)
+	rt.handle(anyMethod, "/telemetry", s.handleTelemetry)
+
+	// Голосовые сообщения и звонки
+	rt.handle(anyMethod, "/voice/send", s.handleVoiceSend)
+	rt.handle(anyMethod, "/voice/", s.handleVoiceGet)
+	rt.handle(anyMethod, "/call/start", s.handleCallStart)
+	rt.handle(anyMethod, "/call/answer", s.handleCallAnswer)
+	rt.handle(anyMethod, "/call/offer", s.handleCallOffer)
+	rt.handle(anyMethod, "/call/end", s.handleCallEnd)
+	rt.handle(anyMethod, "/call/status", s.handleCallStatus)
+	rt.handle(anyMethod, "/call/ice-config", s.handleICEConfig)
+	rt.handle(anyMethod, "/call/hold", s.handleCallControl("hold"))
+	rt.handle(anyMethod, "/call/resume", s.handleCallControl("resume"))
+	rt.handle(anyMethod, "/call/transfer", s.handleCallControl("transfer"))
+	rt.handle(anyMethod, "/call/upgrade", s.handleCallControl("upgrade"))
+	rt.handle(anyMethod, "/call/room/join", s.handleCallControl("join"))
+	rt.handle(anyMethod, "/call/chat", s.handleCallChat)
+	rt.handle(anyMethod, "/call/audio", s.handleCallAudio)
+	rt.handle(anyMethod, "/call/path", s.handleCallPath)
+	rt.handle(anyMethod, "/call/recording", s.handleCallRecording("status"))
+	rt.handle(anyMethod, "/call/recording/start", s.handleCallRecording("start"))
+	rt.handle(anyMethod, "/call/recording/consent", s.handleCallRecording("consent"))
+	rt.handle(anyMethod, "/call/recording/stop", s.handleCallRecording("stop"))
+	rt.handle(anyMethod, "/recordings", s.handleRecordings)
+	rt.handle(anyMethod, "/recordings/", s.handleRecording)
+	rt.handle(anyMethod, "/files/upload", s.handleFileUpload)
+	rt.handle(anyMethod, "/files/", s.handleFile)
+
+	// Администрирование
+	rt.handle(anyMethod, "/admin/ice-servers", s.handleAdminICEServers)
+	rt.handle(anyMethod, "/admin/ice-servers/", s.handleAdminICEServer)
+	rt.handle(anyMethod, "/admin/maintenance", s.handleAdminMaintenance)
+	rt.handle(anyMethod, "/admin/trust/", s.handleAdminTrust)
+	rt.handle(anyMethod, "/admin/blackouts", s.handleAdminBlackouts)
+	rt.handle(anyMethod, "/admin/blackouts/", s.handleAdminBlackout)
+	rt.handle(anyMethod, "/admin/client-errors", s.handleAdminClientErrors)
+	rt.handle(anyMethod, "/admin/telemetry", s.handleAdminTelemetry)
+	rt.handle(anyMethod, "/admin/quotas", s.handleAdminQuotas)
+	rt.handle(anyMethod, "/admin/quotas/", s.handleAdminQuota)
+	rt.handle(anyMethod, "/admin/roles", s.handleAdminRoles)
+	rt.handle(anyMethod, "/admin/roles/", s.handleAdminRole)
+	rt.handle(anyMethod, "/admin/audit", s.handleAdminAudit)
+	rt.handle(http.MethodGet, "/admin/users", s.withAdmin(s.handleAdminUsers))
+	rt.handle(http.MethodPost, "/admin/users/{id}/disable", s.withAdmin(s.handleAdminUserDisable))
+	rt.handle(http.MethodPost, "/admin/users/{id}/enable", s.withAdmin(s.handleAdminUserEnable))
+	rt.handle(http.MethodGet, "/admin/invites", s.withAdmin(s.handleAdminInvites))
+	rt.handle(http.MethodGet, "/admin/transport/history", s.withAdmin(s.handleAdminTransportHistory))
+	rt.handle(http.MethodGet, "/admin/storage", s.withAdmin(s.handleAdminStorage))
+	rt.handle(http.MethodGet, "/admin/reports", s.withAdmin(s.handleAdminReports))
+	rt.handle(http.MethodPost, "/admin/reports/{id}/resolve", s.withAdmin(s.handleAdminReportResolve))
+	rt.handle(anyMethod, "/compliance/export", s.handleComplianceExport)
+	rt.handle(anyMethod, "/client-errors", s.handleClientErrors)
+
+	// Приглашения, учетные записи и вход
+	rt.handle(anyMethod, "/invite", s.handleInvite)
+	rt.handle(anyMethod, "/invites", s.handleInvites)
+	rt.handle(anyMethod, "/invites/", s.handleInviteItem)
+	rt.handle(anyMethod, "/register", s.handleRegister)
+	rt.handle(anyMethod, "/login", s.handleLogin)
+	rt.handle(anyMethod, "/users/", s.handleUser)
+	rt.handle(http.MethodGet, "/users/search", s.handleUserSearch)
+	rt.handle(anyMethod, "/users/{id}/avatar", s.handleUserAvatar)
+	rt.handle(anyMethod, "/users/{id}/locale", s.handleUserLocale)
+	rt.handle(http.MethodGet, "/users/{id}/identities", s.handleUserIdentities)
+	rt.handle(http.MethodDelete, "/users/{id}/identities/{provider}", s.handleUserIdentities)
+	rt.handle(anyMethod, "/recovery", s.handleRecovery)
+	rt.handle(anyMethod, "/recovery/", s.handleRecoveryRequest)
+	rt.handle(anyMethod, "/ws/ticket", s.handleWSTicket)
+	rt.handle(http.MethodGet, "/challenge", s.handleChallenge)
+	rt.handle(anyMethod, "/sms/send", s.handleSMSSend)
+	rt.handle(anyMethod, "/sms/verify", s.handleSMSVerify)
+	rt.handle(anyMethod, "/sms/status/{provider}", s.handleSMSStatus)
+	rt.handle(anyMethod, "/auth/phone", s.handlePhoneAuth)
+	rt.handle(anyMethod, "/email/send", s.handleEmailSend)
+	rt.handle(anyMethod, "/email/verify", s.handleEmailVerify)
+	rt.handle(http.MethodPost, "/email/bounces/{provider}", s.handleEmailBounces)
+	rt.handle(anyMethod, "/auth/email", s.handleEmailAuth)
+	rt.handle(anyMethod, "/auth/refresh", s.handleAuthRefresh)
+	rt.handle(anyMethod, "/auth/logout", s.handleAuthLogout)
+	rt.handle(anyMethod, "/sessions", s.handleSessions)
+	rt.handle(http.MethodDelete, "/sessions/{id}", s.handleSessionRevoke)
+	rt.handle(http.MethodGet, "/auth/oidc", s.handleOIDCProviders)
+	rt.handle(http.MethodPost, "/auth/oidc/{provider}/start", s.handleOIDCStart)
+	rt.handle(http.MethodGet, "/auth/oidc/{provider}/callback", s.handleOIDCCallback)
+	rt.handle(http.MethodPost, "/auth/oidc/complete", s.handleOIDCComplete)
+	rt.handle(http.MethodPost, "/devices/link/start", s.handleDeviceLinkStart)
+	rt.handle(http.MethodPost, "/devices/link/complete", s.handleDeviceLinkComplete)
+
+	// Push уведомления
+	rt.handle(http.MethodGet, "/push/config", s.handlePushConfig)
+	rt.handle(anyMethod, "/push/subscriptions", s.handlePushSubscriptions)
+	rt.handle(http.MethodDelete, "/push/subscriptions/{device_id}", s.handlePushSubscription)
+
+	// Сообщения, беседы и группы
+	rt.handle(anyMethod, "/messages/incoming", s.handleIncoming)
+	rt.handle(anyMethod, "/messages/{id}/receipt", func(w http.ResponseWriter, r *http.Request) {
+		s.handleMessageReceipt(w, r, r.PathValue("id"))
+	})
+	rt.handle(http.MethodGet, "/messages/{id}/edits", func(w http.ResponseWriter, r *http.Request) {
+		s.handleMessageEdits(w, r, r.PathValue("id"))
+	})
+	storedMessage := func(w http.ResponseWriter, r *http.Request) {
+		s.handleStoredMessage(w, r, r.PathValue("id"))
+	}
+	rt.handle(http.MethodPatch, "/messages/{id}", storedMessage)
+	rt.handle(http.MethodDelete, "/messages/{id}", storedMessage)
+	rt.handle(anyMethod, "/inbox", s.handleInbox)
+	rt.handle(anyMethod, "/inbox/ack", s.handleInboxAck)
+	rt.handle(anyMethod, "/conversations/", s.handleConversation)
+	rt.handle(anyMethod, "/groups", s.handleGroups)
+	rt.handle(anyMethod, "/groups/", s.handleGroup)
+	rt.handle(anyMethod, "/receipts", s.handleReceipt)
+	rt.handle(anyMethod, "/capabilities/negotiate", s.handleCapabilitiesNegotiate)
+	rt.handle(anyMethod, "/lookup", s.handleLookup)
+	rt.handle(http.MethodGet, "/usernames/{username}", s.handleUsernameAvailability)
+	rt.handle(anyMethod, "/digest/mute", s.handleDigestMute)
+
+	// Блокировки и жалобы
+	rt.handle(anyMethod, "/blocks", s.handleBlocks)
+	rt.handle(http.MethodDelete, "/blocks/{user_id}", s.handleBlock)
+	rt.handle(http.MethodPost, "/reports", s.handleReport)
+
+	return rt
+}
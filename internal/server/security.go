@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsAllowedHeaders - заголовки запросов, которые веб-клиент с другого источника может
+// передавать API
+const corsAllowedHeaders = "Authorization, Content-Type, Last-Event-ID, " + liteHeader
+
+// corsExposedHeaders - заголовки ответов, доступные веб-клиенту с другого источника
+const corsExposedHeaders = "Retry-After, ETag, Content-Disposition, " + liteHeader
+
+// withSecurityHeaders добавляет к ответам заголовки защиты веб-клиента: запрет угадывания
+// типа содержимого и встраивания во фреймы, CSP и HSTS для запросов по HTTPS
+func (s *Server) withSecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		if s.config.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", s.config.ContentSecurityPolicy)
+		}
+		if s.config.HSTSMaxAge > 0 && isHTTPS(r) {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(s.config.HSTSMaxAge.Seconds()))+"; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isHTTPS сообщает, пришел ли запрос по HTTPS напрямую или через прокси, завершающий TLS
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// withCORS разрешает запросы к API веб-клиенту из источников CORS_ALLOWED_ORIGINS и
+// отвечает на их предварительные запросы. Запросы из других источников проходят без
+// заголовков CORS - браузер не отдаст ответ странице; их предварительные запросы
+// отклоняются. Ретранслятор сигнализации отвечает на CORS сам.
+func (s *Server) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || strings.HasPrefix(apiPath(r.URL.Path), signalingPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := s.corsAllowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowed сообщает, входит ли источник в CORS_ALLOWED_ORIGINS
+func (s *Server) corsAllowed(origin string) bool {
+	for _, allowed := range s.config.CORSAllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,19 +1,39 @@
 package server
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
+	"crypto/ecdh"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hydra/internal/config"
+	"hydra/pkg/archive"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/challenge"
+	"hydra/pkg/discovery"
+	"hydra/pkg/i18n"
+	"hydra/pkg/ids"
+	"hydra/pkg/mail"
+	"hydra/pkg/oidc"
+	"hydra/pkg/presence"
+	"hydra/pkg/push"
+	"hydra/pkg/ratelimit"
+	"hydra/pkg/reachability"
+	"hydra/pkg/receipts"
+	"hydra/pkg/signaling"
+	"hydra/pkg/sms"
 	"hydra/pkg/storage"
+	"hydra/pkg/telemetry"
+	"hydra/pkg/timesync"
+	"hydra/pkg/transport"
 	"hydra/pkg/transport/manager"
+	"hydra/pkg/trust"
+	"hydra/pkg/validate"
 	"hydra/pkg/voice"
 	"hydra/pkg/webrtc"
 	"log"
-	"net"
 	"net/http"
-	"net/smtp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,8 +42,9 @@ import (
 type Contact struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
-	Avatar string `json:"avatar"`
+	Avatar string `json:"avatar,omitempty"` // в облегченном режиме не отдается
 	Status string `json:"status"`
+	NodeID string `json:"node_id,omitempty"` // узел mesh контакта: присутствие по обнаружению пиров
 }
 
 type Server struct {
@@ -31,18 +52,74 @@ type Server struct {
 	transportManager *manager.TransportManager
 	voiceProcessor   *voice.VoiceProcessor
 	callManager      *webrtc.CallManager
-	db               *storage.Storage
+	blobs            *blobstore.Store
+	db               storage.Store
 	contacts         map[string]Contact
-	mu               sync.Mutex
+	timeSigner       *timesync.Signer
+	replayGuard      *timesync.ReplayGuard
+	policy           *transport.Policy
+	lookupLimiter    *ratelimit.Limiter
+	syncLimiter      *ratelimit.Limiter
+	contactSecret    []byte
+	events           *eventHub
+	trust            *trust.Policy
+	sendLimiters     map[trust.Level]*ratelimit.Limiter
+	reachability     *reachability.Aggregator
+	reportKey        *ecdh.PrivateKey
+	reportLimiter    *ratelimit.Limiter
+	signaling        *signaling.Relay // встроенный ретранслятор сигнализации; nil - отдельный
+	signalingSecret  []byte
+	tickets          *ticketStore
+	oidcProviders    map[string]oidc.Provider
+	oidcFlows        *oidcStore
+	proofs           *proofStore // токены подтверждения телефона и email для регистрации
+	bruteForce       *bruteForceGuard
+	challenge        challenge.Provider // испытание перед регистрацией и отправкой кодов; nil - выключено
+	mailer           mail.Mailer        // отправка писем; nil - не настроена
+	smsProvider      sms.Provider       // отправка SMS; nil - неизвестный SMS_PROVIDER
+	emailLogo        *mail.Attachment   // логотип в шапке HTML-писем; nil - название текстом
+	presence         *presence.Tracker
+	live             *liveConns                 // открытые соединения WebSocket для эфемерных событий
+	pushSenders      map[string]push.Sender     // отправители push по виду подписки; пусто - push выключен
+	peerManager      *discovery.AutoPeerManager // nil - обнаружение пиров выключено
+	errorLimiter     *ratelimit.Limiter
+	telemetry        *telemetry.Aggregator // оценки по отчетам узлов
+	usage            *telemetry.Collector  // статистика этого узла; nil - телеметрия выключена
+	peerFeed         *peerFeed
+	recoveryLimiter  *ratelimit.Limiter
+	quotaWarned      map[string]time.Time // когда администраторы группы последний раз предупреждены
+	receipts         *receipts.Aggregator // сводки квитанций больших групп
+	archives         archive.Backend      // холодное хранилище истории; nil - выключено
+	archiveCache     *archive.Cache
+	avatars          archive.Backend // хранилище аватаров; nil - загрузка выключена
+	uploadMu         sync.Mutex      // последовательная запись частей загрузок файлов
+
+	// Режим обслуживания: только чтение, сообщения копятся в исходящих
+	maintenance       bool
+	maintenanceReason string
+	maintenanceSince  time.Time
+
+	mu sync.Mutex
 }
 
-func New(cfg *config.Config, tm *manager.TransportManager, db *storage.Storage) *Server {
+func New(cfg *config.Config, tm *manager.TransportManager, db storage.Store) *Server {
 	// Создаем процессор голосовых сообщений
 	voiceProcessor := voice.New(tm, "./voice_storage")
 
 	// Создаем менеджер звонков
 	callManager := webrtc.NewCallManager(cfg.ICEServers)
 
+	// Настройки аудио звонков для каналов с потерями
+	err := callManager.SetDefaultAudioConfig(webrtc.AudioConfig{
+		JitterBufferTargetMs: cfg.AudioJitterBufferMs,
+		FEC:                  cfg.AudioFEC,
+		DTX:                  cfg.AudioDTX,
+		MaxBitrate:           cfg.AudioMaxBitrate,
+	})
+	if err != nil {
+		log.Printf("Warning: invalid call audio settings (%v), using defaults", err)
+	}
+
 	// Запускаем очистку старых файлов каждые 24 часа
 	go func() {
 		ticker := time.NewTicker(24 * time.Hour)
@@ -54,101 +131,233 @@ func New(cfg *config.Config, tm *manager.TransportManager, db *storage.Storage)
 		}
 	}()
 
-	return &Server{
+	// Подписчик серверного времени для клиентов с неточными часами
+	timeSigner, err := timesync.NewSigner(decodeSigningKey(cfg.ServerSigningKey))
+	if err != nil {
+		log.Printf("Warning: invalid SERVER_SIGNING_KEY (%v), using random key", err)
+		timeSigner, _ = timesync.NewSigner(nil)
+	}
+
+	if db != nil {
+		db.SetClockSkewTolerance(cfg.ClockSkewTolerance)
+	}
+
+	// Квитанции больших групп копятся и уходят авторам пакетами сводок
+	receiptAggregator, err := receipts.NewAggregator(cfg.ReceiptDetail, cfg.ReceiptSampleSize)
+	if err != nil {
+		log.Printf("Warning: %v, using %s", err, receipts.DetailSampled)
+		receiptAggregator, _ = receipts.NewAggregator(receipts.DetailSampled, cfg.ReceiptSampleSize)
+	}
+
+	// Ключ для расшифровки отчетов клиентов о доступности фронтов и ретрансляторов
+	reportKey, err := reachability.NewServerKey(decodeSigningKey(cfg.ReachabilityKey))
+	if err != nil {
+		log.Printf("Warning: invalid REACHABILITY_KEY (%v), using random key", err)
+		reportKey, _ = reachability.NewServerKey(nil)
+	}
+
+	srv := &Server{
 		config:           cfg,
 		transportManager: tm,
 		voiceProcessor:   voiceProcessor,
 		callManager:      callManager,
 		db:               db,
 		contacts:         make(map[string]Contact),
+		timeSigner:       timeSigner,
+		replayGuard:      timesync.NewReplayGuard(cfg.ClockSkewTolerance),
+		lookupLimiter:    ratelimit.New(cfg.LookupRatePerMinute, cfg.LookupBurst),
+		syncLimiter:      ratelimit.New(cfg.ContactSyncRate, cfg.ContactSyncRate),
+		contactSecret:    newContactSecret(cfg.ContactSyncSecret),
+		events:           newEventHub(),
+		tickets:          newTicketStore(),
+		bruteForce:       newBruteForceGuard(cfg),
+		presence:         newPresence(cfg),
+		live:             newLiveConns(),
+		pushSenders:      newPushSenders(cfg),
+		trust:            newTrustPolicy(cfg),
+		reachability:     reachability.NewAggregator(cfg.ReachabilityWindow, cfg.ReachabilityMinReporters),
+		reportKey:        reportKey,
+		reportLimiter:    ratelimit.New(cfg.ReachabilityRatePerMinute, cfg.ReachabilityRatePerMinute),
+		errorLimiter:     ratelimit.New(cfg.ClientErrorRatePerMinute, cfg.ClientErrorRatePerMinute),
+		telemetry:        telemetry.NewAggregator(cfg.TelemetryWindow, cfg.TelemetryMinReports),
+		peerFeed:         newPeerFeed(),
+		quotaWarned:      make(map[string]time.Time),
+		recoveryLimiter:  ratelimit.New(cfg.LookupRatePerMinute, cfg.LookupBurst),
+		receipts:         receiptAggregator,
+	}
+	srv.sendLimiters = newSendLimiters(srv.trust)
+	srv.signalingSecret, srv.signaling = newSignaling(cfg)
+	srv.proofs = newProofStore(srv.signalingSecret)
+	srv.challenge = newChallengeProvider(cfg, srv.signalingSecret)
+	srv.mailer = newMailer(cfg)
+	srv.smsProvider = newSMSProvider(cfg)
+	srv.oidcProviders = newOIDCProviders(cfg)
+	srv.oidcFlows = newOIDCStore()
+	if cfg.EmailLogoPath != "" {
+		if logo, err := mail.LoadLogo(cfg.EmailLogoPath); err != nil {
+			log.Printf("Warning: email logo disabled: %v", err)
+		} else {
+			srv.emailLogo = logo
+		}
 	}
-}
+	srv.presence.OnChange(srv.broadcastPresence)
 
-func (s *Server) Start(addr string) error {
-	http.Handle("/", http.FileServer(http.Dir(s.config.WebStaticPath)))
-	http.HandleFunc("/api/contacts", s.handleContacts)
-	http.HandleFunc("/api/send", s.handleSend)
-	http.HandleFunc("/api/status", s.handleStatus)
-	http.HandleFunc("/api/voice/send", s.handleVoiceSend)
-	http.HandleFunc("/api/voice/", s.handleVoiceGet)
-	http.HandleFunc("/api/call/start", s.handleCallStart)
-	http.HandleFunc("/api/call/answer", s.handleCallAnswer)
-	http.HandleFunc("/api/call/offer", s.handleCallOffer)
-	http.HandleFunc("/api/call/end", s.handleCallEnd)
-	http.HandleFunc("/api/call/status", s.handleCallStatus)
-	http.HandleFunc("/api/invite", s.handleInvite)
-	http.HandleFunc("/api/register", s.handleRegister)
-	http.HandleFunc("/api/login", s.handleLogin)
-	http.HandleFunc("/api/users/", s.handleUser)
-	http.HandleFunc("/api/sms/send", s.handleSMSSend)
-	http.HandleFunc("/api/sms/verify", s.handleSMSVerify)
-	http.HandleFunc("/api/auth/phone", s.handlePhoneAuth)
-	http.HandleFunc("/api/email/send", s.handleEmailSend)
-	http.HandleFunc("/api/email/verify", s.handleEmailVerify)
-	http.HandleFunc("/api/auth/email", s.handleEmailAuth)
-
-	log.Printf("Web Interface started at http://localhost%s", addr)
+	// Чат звонка сохраняется в беседу после завершения звонка
+	callManager.OnCallEnded(srv.saveCallChat)
 
-	// Проверяем SMTP соединение асинхронно при старте
-	if s.config.SMTPHost != "" {
-		go func() {
-			log.Println("Checking SMTP connection...")
-			if err := s.checkSMTPConnection(); err != nil {
-				log.Printf("❌ SMTP Connection Error: %v", err)
-				log.Println("Tip: Check your internet connection, firewall, or SMTP settings in .env")
-			} else {
-				log.Println("✅ SMTP Connection Established Successfully")
-			}
-		}()
+	if cfg.MaintenanceMode {
+		srv.setMaintenance(true, "Enabled by configuration")
 	}
 
-	return http.ListenAndServe(addr, nil)
-}
+	// Политика транспортов: через что можно звонить и отправлять сообщения
+	srv.policy = transport.NewPolicy(cfg.CallTransports, cfg.MessageTransports)
+	tm.SetPolicy(srv.policy)
+	callManager.SetMediaPolicy(srv.policy)
 
-func (s *Server) checkSMTPConnection() error {
-	addr := fmt.Sprintf("%s:%s", s.config.SMTPHost, s.config.SMTPPort)
-	timeout := 10 * time.Second
-
-	// Если порт 465, используем TLS
-	if s.config.SMTPPort == "465" {
-		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{
-			InsecureSkipVerify: false,
-			ServerName:         s.config.SMTPHost,
-		})
+	// Обезличенная статистика транспортов копится, только если владелец узла ее включил
+	if cfg.TelemetryEnabled {
+		srv.usage = telemetry.NewCollector()
+		tm.OnSend(srv.usage.Record)
+	}
+
+	// Сбои фронтов сохраняются в историю блокировок
+	if db != nil {
+		tm.FrontPool().OnEvent(srv.recordTransportEvent)
+	}
+
+	// Хранилище вложений и записей звонков
+	if cfg.BlobStoragePath != "" {
+		blobs, err := blobstore.New(cfg.BlobStoragePath)
 		if err != nil {
-			return err
+			log.Printf("Warning: file storage disabled: %v", err)
+		} else {
+			srv.blobs = blobs
 		}
-		defer conn.Close()
+	}
 
-		client, err := smtp.NewClient(conn, s.config.SMTPHost)
+	// Серверная запись групповых звонков (только с согласия участников)
+	if cfg.RecordingEnabled && srv.blobs != nil {
+		callManager.SetRecordingSink(srv.blobs)
+		callManager.OnRecordingFinished(srv.saveRecording)
+	}
+
+	// Аватары пользователей
+	if db != nil {
+		avatars, err := newAvatarBackend(cfg)
 		if err != nil {
-			return err
+			log.Printf("Warning: avatars disabled: %v", err)
+		} else if avatars != nil {
+			srv.avatars = avatars
 		}
-		defer client.Quit()
+	}
 
-		auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPassword, s.config.SMTPHost)
-		if err := client.Auth(auth); err != nil {
-			return fmt.Errorf("authentication failed: %w", err)
+	// История давно неактивных бесед уходит в холодное хранилище
+	if db != nil {
+		archives, err := newArchiveBackend(cfg)
+		if err != nil {
+			log.Printf("Warning: cold storage disabled: %v", err)
+		} else if archives != nil {
+			srv.archives = archives
+			srv.archiveCache = archive.NewCache(archives, archiveCacheSize)
 		}
-		return nil
 	}
 
-	// Для остальных портов
-	client, err := smtp.Dial(addr)
-	if err != nil {
-		return err
+	return srv
+}
+
+func (s *Server) Start(addr string) error {
+	// Заполняем список ICE серверов из конфигурации и запускаем проверки доступности
+	s.seedICEServers()
+	go s.runICEHealthChecks()
+
+	// По истории блокировок избегаем фронтов, стабильно блокируемых в регионе
+	go s.runFrontAvoidance()
+
+	// Удаляем записи звонков с истекшим сроком хранения
+	go s.runRecordingRetention()
+
+	// Удаляем брошенные незавершенные загрузки файлов
+	if s.blobs != nil && s.db != nil {
+		go s.runUploadCleanup()
+	}
+
+	// Архивируем историю неактивных бесед в холодное хранилище
+	if s.archives != nil && s.config.ArchiveAfter > 0 {
+		go s.runArchival()
+	}
+
+	// Удаляем сессии с истекшим токеном обновления
+	if s.db != nil {
+		go s.runSessionCleanup()
+	}
+
+	// Удаляем историю бесед старше их срока хранения
+	if s.db != nil {
+		go s.runRetention()
 	}
-	defer client.Quit()
 
-	if err := client.StartTLS(&tls.Config{ServerName: s.config.SMTPHost}); err != nil {
-		return fmt.Errorf("StartTLS failed: %w", err)
+	// Сообщения из mesh сети попадают во входящие получателей; подтвержденные входящие
+	// хранятся, пока защищают от повторной доставки
+	if s.db != nil {
+		if mesh := s.transportManager.Mesh(); mesh != nil {
+			mesh.OnMessage(s.receiveMesh)
+		}
+		go s.runInboxCleanup()
+	}
+
+	// Пользователи с затихшими соединениями становятся away и offline
+	go s.runPresenceSweep()
+
+	// Удаляем старые отчеты клиентов об ошибках
+	go s.runClientErrorRetention()
+	go s.runSMSRetention()
+
+	// Отправляем обезличенную статистику транспортов, если она включена
+	if s.usage != nil {
+		go s.runTelemetry()
+	}
+
+	// Окна отключения транспортов по расписанию; отложенные на время окон сообщения
+	// доставляются после их окончания
+	if err := s.loadBlackouts(); err != nil {
+		log.Printf("Warning: transport blackouts not loaded: %v", err)
+	}
+	go s.runBlackoutDelivery()
+
+	// Сообщения, отложенные в режиме обслуживания до перезапуска
+	go s.flushOutbox()
+
+	// Пакеты сводок квитанций больших групп
+	go s.runReceiptBatches()
+
+	// Дайджесты для давно не заходивших пользователей отправляются через почтовую очередь
+	go s.runMailQueue()
+	if s.config.DigestEnabled {
+		go s.runDigests()
 	}
 
-	auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPassword, s.config.SMTPHost)
-	if err := client.Auth(auth); err != nil {
-		return fmt.Errorf("authentication failed: %w", err)
+	// Проверяем SMTP соединение асинхронно при старте
+	if s.mailer != nil && (s.config.MailProvider == "" || s.config.MailProvider == "smtp") {
+		go func() {
+			log.Println("Checking SMTP connection...")
+			if err := s.checkSMTPConnection(); err != nil {
+				log.Printf("❌ SMTP Connection Error: %v", err)
+				log.Println("Tip: Check your internet connection, firewall, or SMTP settings in .env")
+			} else {
+				log.Println("✅ SMTP Connection Established Successfully")
+			}
+		}()
 	}
-	return nil
+
+	// Заголовки защиты и CORS добавляются ко всем ответам, в том числе отклоненным
+	// режимом обслуживания
+	return s.serve(addr, s.Handler())
+}
+
+func (s *Server) checkSMTPConnection() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return smtpMailer(s.config).Check(ctx)
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -170,17 +379,47 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.db.ValidateUser(req.ContactInfo, req.Password)
+	login := normalizeLogin(req.ContactInfo)
+	if s.loginLocked(w, r, login) {
+		return
+	}
+	user, err := s.db.ValidateUser(r.Context(), login, req.Password)
+	// Аккаунты, созданные до нормализации телефонов и email, хранят исходную запись
+	if err != nil && login != req.ContactInfo {
+		user, err = s.db.ValidateUser(r.Context(), req.ContactInfo, req.Password)
+	}
 	if err != nil {
+		s.loginFailed(r, login, nil)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid credentials"})
 		return
 	}
+	s.loginSucceeded(login)
+	disabled, err := s.db.GetDisabledAccount(r.Context(), user.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to check account"})
+		return
+	}
+	if disabled != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Account disabled"})
+		return
+	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"user":    user,
-	})
+	response := map[string]interface{}{
+		"success":   true,
+		"user":      user,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
+	}
+	s.touchUser(user.ID)
+
+	// Клиент предлагает реактивацию, если аккаунт в режиме отпуска
+	if state := s.accountState(user.ID); state != nil {
+		response["account"] = state
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -192,9 +431,13 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Token    string `json:"token"`
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Token     string `json:"token"`
+		Name      string `json:"name"`
+		Password  string `json:"password"`
+		Captcha   string `json:"captcha"`   // прежнее имя challenge
+		Contact   string `json:"contact"`   // email или телефон для многоразового приглашения
+		Proof     string `json:"proof"`     // подтверждение contact из /sms/verify или /email/verify
+		Challenge string `json:"challenge"` // ответ на испытание из /challenge
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -203,23 +446,93 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contactInfo, err := s.db.ValidateInvite(req.Token)
+	// Уровень доверия определяется пригласившим; проверка CAPTCHA до использования приглашения
+	invite, err := s.db.GetInvite(r.Context(), req.Token)
+	if err != nil {
+		log.Printf("Failed to load invite: %v", err)
+	}
+	var inviterID string
+	if invite != nil {
+		inviterID = invite.CreatedBy
+		if invite.ContactInfo == "" && req.Contact == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Email or phone required"})
+			return
+		}
+	}
+
+	var errs validate.Errors
+	name, err := validate.Name(req.Name)
+	errs.Check("name", err)
+	contact := req.Contact
+	if contact != "" {
+		contact, err = validate.Contact(contact)
+		errs.Check("contact", err)
+	}
+	hints := []string{name, contact}
+	if invite != nil {
+		hints = append(hints, invite.ContactInfo)
+	}
+	errs.Check("password", validate.Password(req.Password, hints...))
+	if errs.Err() != nil {
+		s.writeValidationErrors(w, r, errs)
+		return
+	}
+	level := s.inviteeTrust(inviterID)
+	if req.Challenge == "" {
+		req.Challenge = req.Captcha
+	}
+	if !s.passChallenge(w, r, level, req.Challenge) {
+		return
+	}
+	// Многоразовое приглашение не называет контакт: его, как при регистрации по телефону
+	// или email, подтверждает код, отправленный на него
+	if invite != nil && invite.ContactInfo == "" {
+		kind, message := proofPhone, "Phone number is not verified"
+		if strings.Contains(contact, "@") {
+			kind, message = proofEmail, "Email is not verified"
+		}
+		if !s.proofs.consume(req.Proof, kind, contact) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": message})
+			return
+		}
+	}
+
+	contactInfo, err := s.db.ValidateInvite(r.Context(), req.Token)
+	if errors.Is(err, storage.ErrInviteUsed) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invite already used"})
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid or expired token"})
 		return
 	}
+	if contactInfo == "" {
+		contactInfo = contact
+	} else {
+		contactInfo = normalizeLogin(contactInfo)
+	}
 
-	user, err := s.db.CreateUser(req.Name, req.Password, contactInfo)
+	user, err := s.db.CreateUser(r.Context(), name, req.Password, contactInfo)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create user"})
 		return
 	}
+	if err := s.db.RecordInviteUse(r.Context(), req.Token, user.ID); err != nil {
+		log.Printf("Failed to record invite use: %v", err)
+	}
+	s.saveTrust(user.ID, level, inviterID)
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"user":    user,
+		"success":   true,
+		"user":      user,
+		"trust":     level,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
 	})
 }
 
@@ -242,30 +555,33 @@ func (s *Server) handleInvite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	contactInfo := req.Email
-	if contactInfo == "" {
-		contactInfo = req.Phone
+	contactInfo, err := inviteContact(req.Email, req.Phone)
+	if err != nil {
+		s.writeValidationErrors(w, r, err)
+		return
 	}
-
 	if contactInfo == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Email or phone required"})
 		return
 	}
 
-	token, err := s.db.CreateInvite(contactInfo)
-	if err != nil {
+	// Приглашение от участника передает его доверие приглашенному (см. TRUST_INVITER_MIN_LEVEL),
+	// поэтому пригласивший - только владелец токена входа; без токена приглашение анонимное
+	inviter, _ := s.bearerUser(r)
+	expiresAt, _ := s.inviteExpiry("")
+	invite := &storage.Invite{ContactInfo: contactInfo, CreatedBy: inviter, ExpiresAt: expiresAt, MaxUses: 1}
+	if err := s.db.IssueInvite(r.Context(), invite); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create invite"})
 		return
 	}
 
-	inviteLink := fmt.Sprintf("http://localhost:8081/register.html?token=%s", token)
-
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":     true,
-		"token":       token,
-		"invite_link": inviteLink,
+		"token":       invite.Token,
+		"invite_link": s.inviteLink(invite.Token),
+		"delivery":    s.deliverInvite(invite, s.requestLocale(r)),
 	})
 }
 
@@ -273,14 +589,82 @@ func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	id := strings.TrimPrefix(r.URL.Path, "/api/users/")
 
+	// Временная деактивация аккаунта (режим отпуска)
+	if userID, action, ok := splitAccountPath(id); ok {
+		s.handleUserAccount(w, r, userID, action)
+		return
+	}
+
+	// Поручители для восстановления аккаунта
+	if userID, found := strings.CutSuffix(id, "/guardians"); found {
+		s.handleUserGuardians(w, r, userID)
+		return
+	}
+
+	// Папки чатов с правилами
+	if userID, folderID, ok := splitFoldersPath(id); ok {
+		s.handleUserFolders(w, r, userID, folderID)
+		return
+	}
+
+	// Смена телефона или email с подтверждением старого и нового
+	if userID, action, ok := splitIdentifierPath(id); ok {
+		s.handleUserIdentifier(w, r, userID, action)
+		return
+	}
+
+	// Имя пользователя и видимость в поиске
+	if userID, found := strings.CutSuffix(id, "/lookup"); found {
+		s.handleUserLookup(w, r, userID)
+		return
+	}
+
+	// Занять, сменить или освободить имя пользователя
+	if userID, found := strings.CutSuffix(id, "/username"); found {
+		s.handleUserUsername(w, r, userID)
+		return
+	}
+
+	// Настройки email-дайджеста
+	if userID, found := strings.CutSuffix(id, "/digest"); found {
+		s.handleUserDigest(w, r, userID)
+		return
+	}
+
+	// Журнал событий: polling, SSE и WebSocket
+	if userID, mode, ok := splitEventsPath(id); ok {
+		s.handleUserEvents(w, r, userID, mode)
+		return
+	}
+
+	// Устройства пользователя и их возможности
+	if userID, deviceID, ok := splitDevicesPath(id); ok {
+		s.handleUserDevices(w, r, userID, deviceID)
+		return
+	}
+
+	// Открытые ключи сквозного шифрования
+	if userID, deviceID, ok := splitKeysPath(id); ok {
+		s.handleUserKeys(w, r, userID, deviceID)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		user, err := s.db.GetUser(id)
+		user, err := s.db.GetUser(r.Context(), id)
+		// Деактивированный пользователь не находится поиском
+		if err == nil && s.accountState(id) != nil {
+			err = fmt.Errorf("user deactivated")
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
 			return
 		}
+		// Телефон и email видит только сам пользователь: ID из каталога не должен их раскрывать
+		if caller, err := s.bearerUser(r); err != nil || caller != id {
+			user.Email, user.Phone = "", ""
+		}
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "user": user})
 
 	case http.MethodPut:
@@ -291,21 +675,31 @@ func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		user.ID = id
-		if err := s.db.UpdateUser(&user); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update user"})
+
+		// Телефон и email меняются только через /identifier с подтверждением кодами
+		current, err := s.db.GetUser(r.Context(), id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		if (user.Email != "" && user.Email != current.Email) || (user.Phone != "" && user.Phone != current.Phone) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Use /api/users/{id}/identifier to change phone or email"})
+			return
+		}
+		user.Email, user.Phone = current.Email, current.Phone
 
-	case http.MethodDelete:
-		if err := s.db.DeleteUser(id); err != nil {
+		if err := s.db.UpdateUser(r.Context(), &user); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to delete user"})
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update user"})
 			return
 		}
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 
+	case http.MethodDelete:
+		s.deleteAccount(w, r, id)
+
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
@@ -316,17 +710,33 @@ func (s *Server) handleContacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == http.MethodGet {
+		page, ok := parsePage(w, r, defaultPageLimit)
+		if !ok {
+			return
+		}
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
+		lite := negotiateLite(w, r)
 		list := make([]Contact, 0, len(s.contacts))
 		for _, c := range s.contacts {
+			// Деактивированные пользователи не показываются в присутствии
+			if s.accountState(c.ID) != nil {
+				continue
+			}
+			if lite {
+				c.Avatar = ""
+			}
+			c.Status = s.contactStatus(c)
 			list = append(list, c)
 		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		list, next := paginate(list, page, func(c Contact) string { return c.ID })
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":  true,
-			"contacts": list,
+			"success":     true,
+			"contacts":    list,
+			"next_cursor": next,
 		})
 		return
 	}
@@ -346,14 +756,17 @@ func (s *Server) handleContacts(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if req.ID == "" {
-			req.ID = fmt.Sprintf("user%d", time.Now().UnixNano())
+			req.ID = "user-" + ids.New()
 		}
 		if req.Avatar == "" {
 			req.Avatar = "#999999"
 		}
-		if req.Status == "" {
-			req.Status = "offline"
+		// Статус задает не клиент, а присутствие контакта
+		req.Status = ""
+		if req.NodeID != "" {
+			req.Status = s.nodePresence(req.NodeID)
 		}
+		req.Status = s.contactStatus(req)
 
 		s.mu.Lock()
 		s.contacts[req.ID] = req
@@ -373,6 +786,16 @@ func (s *Server) handleContacts(w http.ResponseWriter, r *http.Request) {
 type sendRequest struct {
 	Message string `json:"message"`
 	To      string `json:"to"`
+	From    string `json:"from,omitempty"`
+
+	// ID загруженных вложений (см. /api/files/upload); сообщение с вложениями может быть без текста
+	Attachments []string `json:"attachments,omitempty"`
+
+	// Необязательные поля защиты от повторов.
+	// Timestamp - время клиента в миллисекундах, ClockOffset - поправка, полученная из /api/time.
+	Nonce       string `json:"nonce,omitempty"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	ClockOffset int64  `json:"clock_offset,omitempty"`
 }
 
 func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
@@ -390,24 +813,117 @@ func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Message == "" {
+	if req.Message == "" && len(req.Attachments) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Message cannot be empty"})
 		return
 	}
 
+	// Отправитель - владелец токена входа, без токена - анонимный. От него зависят лимит,
+	// блокировки и вложения, поэтому поле from принимается, только если совпадает с ним.
+	sender, _ := s.bearerUser(r)
+	if req.From != "" && req.From != sender {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Sender does not match the token"})
+		return
+	}
+	req.From = sender
+
+	var attachments []*storage.Attachment
+	if len(req.Attachments) > 0 {
+		if s.db == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Attachments are not available"})
+			return
+		}
+		var err error
+		if attachments, err = s.messageAttachments(req.From, req.Attachments); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
+	if req.Nonce != "" || req.Timestamp != 0 {
+		clientTime := time.UnixMilli(req.Timestamp)
+		offset := time.Duration(req.ClockOffset) * time.Millisecond
+		if err := s.replayGuard.Check(req.Nonce, clientTime, offset); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":     false,
+				"error":       err.Error(),
+				"server_time": s.timeSigner.Sign(req.Nonce),
+			})
+			return
+		}
+	}
+
+	// Лимит отправки зависит от уровня доверия отправителя
+	if !s.allowSend(sender) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many messages"})
+		return
+	}
+
 	log.Printf("Received message from UI: %s to %s", req.Message, req.To)
 
+	// Режим обслуживания: сообщение ждет в исходящих, доставок нет
+	if enabled, reason := s.inMaintenance(); enabled {
+		s.queueForMaintenance(w, &req, reason)
+		return
+	}
+
+	// Окно отключения запретило все транспорты для сообщений: ждем его окончания в исходящих
+	if active, blocked := s.messagesBlackedOut(); blocked {
+		s.queueForBlackout(w, &req, active)
+		return
+	}
+
+	s.touchUser(req.From)
+
+	// Получатель заблокировал отправителя: сообщение не доставляется
+	if s.blockedBy(req.To, req.From) {
+		s.refuseBlocked(w, &req)
+		return
+	}
+
+	if req.To != "" && req.To != req.From {
+		s.recordNotification(req.To, storage.NotificationMessage, req.From)
+	}
+
+	// Получатель в режиме отпуска: сообщение ждет реактивации или отклоняется
+	if state := s.accountState(req.To); state != nil {
+		s.holdForDeactivated(w, state, &req)
+		return
+	}
+
+	messageID := s.recordMessageCreated(req.From, req.To, req.Message, attachments...)
+
 	// Отправляем через менеджер транспортов (автоматическое переключение)
 	// В будущем можно использовать req.To для маршрутизации
-	err := s.transportManager.Send(r.Context(), []byte(req.Message))
+	reply, err := s.transportManager.Exchange(r.Context(), []byte(req.Message))
 
 	// Получаем текущий активный транспорт для статуса
 	currentTransport := s.transportManager.GetCurrentTransport()
 
 	response := map[string]interface{}{
-		"success":   true,
-		"transport": currentTransport.Name(),
+		"success":    true,
+		"transport":  currentTransport.Name(),
+		"message_id": messageID,
+	}
+
+	// Ответ скрытого сервиса (ID доставки, входящие сообщения) передаем клиенту как есть;
+	// входящие из ответа также попадают во входящие получателей
+	if len(reply) > 0 {
+		if s.db != nil {
+			s.receiveReplyMessages(r.Context(), reply, currentTransport.Name())
+		}
+		if json.Valid(reply) {
+			response["reply"] = json.RawMessage(reply)
+		} else {
+			response["reply"] = string(reply)
+		}
 	}
 
 	if err != nil {
@@ -430,7 +946,8 @@ func (s *Server) handleSMSSend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Phone string `json:"phone"`
+		Phone     string `json:"phone"`
+		Challenge string `json:"challenge"` // ответ на испытание из /challenge
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -438,20 +955,37 @@ func (s *Server) handleSMSSend(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
 		return
 	}
+	phone, err := validate.Phone(req.Phone)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("phone", err))
+		return
+	}
+	req.Phone = phone
+
+	// Испытание против рассылки SMS на чужие номера
+	if !s.passChallenge(w, r, trust.Unknown, req.Challenge) {
+		return
+	}
 
 	// Генерируем 6-значный код
-	code := fmt.Sprintf("%06d", time.Now().UnixNano()%1000000)
+	code, err := verificationCode()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
+		return
+	}
 
 	// Сохраняем код в базу данных
-	if err := s.db.CreateSMSVerification(req.Phone, code); err != nil {
+	if err := s.db.CreateSMSVerification(r.Context(), req.Phone, code); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
 		return
 	}
 
 	// Отправляем SMS асинхронно
+	locale := s.requestLocale(r)
 	go func() {
-		msg := fmt.Sprintf("Your Hydra verification code is: %s", code)
+		msg := i18n.Format(locale, i18n.VerificationSMS, code)
 		if err := s.sendSMS(req.Phone, msg); err != nil {
 			log.Printf("❌ Failed to send SMS to %s: %v", req.Phone, err)
 		} else {
@@ -461,56 +995,10 @@ func (s *Server) handleSMSSend(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"message": "Verification code sent",
+		"message": i18n.Translate(locale, "Verification code sent"),
 	})
 }
 
-func (s *Server) sendSMS(to, message string) error {
-	// 1. Console Provider (Default)
-	if s.config.SMSProvider == "console" || s.config.SMSProvider == "" {
-		log.Printf("[SMS-CONSOLE] To: %s | Message: %s", to, message)
-		return nil
-	}
-
-	// 2. HTTP Provider (Generic)
-	if s.config.SMSProvider == "http" {
-		if s.config.SMSAPIURL == "" {
-			return fmt.Errorf("SMS_API_URL is not configured")
-		}
-
-		payload := map[string]string{
-			"to":      to,
-			"message": message,
-			"key":     s.config.SMSAPIKey,
-		}
-
-		jsonBody, err := json.Marshal(payload)
-		if err != nil {
-			return fmt.Errorf("failed to marshal SMS payload: %w", err)
-		}
-
-		req, err := http.NewRequest("POST", s.config.SMSAPIURL, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			return fmt.Errorf("failed to create SMS request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to send SMS request: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode >= 300 {
-			return fmt.Errorf("SMS API returned status: %d", resp.StatusCode)
-		}
-		return nil
-	}
-
-	return fmt.Errorf("unknown SMS provider: %s", s.config.SMSProvider)
-}
-
 func (s *Server) handleSMSVerify(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
@@ -529,9 +1017,19 @@ func (s *Server) handleSMSVerify(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
 		return
 	}
+	phone, err := validate.Phone(req.Phone)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("phone", err))
+		return
+	}
+	req.Phone = phone
 
-	// Проверяем код
-	valid, err := s.db.ValidateSMSVerification(req.Phone, req.Code)
+	// Проверяем код; после CODE_MAX_ATTEMPTS неверных кодов проверка блокируется
+	if s.codeLocked(w, req.Phone) {
+		return
+	}
+	valid, err := s.db.ValidateSMSVerification(r.Context(), req.Phone, req.Code)
+	s.codeChecked(req.Phone, err == nil && valid)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
@@ -547,6 +1045,7 @@ func (s *Server) handleSMSVerify(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"message": "Phone number verified successfully",
+		"proof":   s.issueProof(proofPhone, req.Phone),
 	})
 }
 
@@ -559,7 +1058,8 @@ func (s *Server) handleEmailSend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Email string `json:"email"`
+		Email     string `json:"email"`
+		Challenge string `json:"challenge"` // ответ на испытание из /challenge
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -567,19 +1067,35 @@ func (s *Server) handleEmailSend(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
 		return
 	}
+	email, err := validate.Email(req.Email)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("email", err))
+		return
+	}
+	req.Email = email
 
-	code := fmt.Sprintf("%06d", time.Now().UnixNano()%1000000)
+	if !s.passChallenge(w, r, trust.Unknown, req.Challenge) {
+		return
+	}
 
-	if err := s.db.CreateEmailVerification(req.Email, code); err != nil {
+	code, err := verificationCode()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
+		return
+	}
+
+	if err := s.db.CreateEmailVerification(r.Context(), req.Email, code); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create verification code"})
 		return
 	}
 
 	// Send Email
-	if s.config.SMTPHost != "" && s.config.SMTPUser != "" {
+	locale := s.requestLocale(r)
+	if s.mailer != nil {
 		go func() {
-			err := s.sendEmail(req.Email, "Hydra Verification Code", fmt.Sprintf("Your verification code is: %s", code))
+			err := s.sendTemplatedEmail(req.Email, mail.Verification, verificationEmail(locale, i18n.VerificationEmail, i18n.VerificationIntro, code))
 			if err != nil {
 				log.Printf("Failed to send email to %s: %v", req.Email, err)
 			}
@@ -590,94 +1106,13 @@ func (s *Server) handleEmailSend(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"message": "Verification code sent",
+		"message": i18n.Translate(locale, "Verification code sent"),
 	})
 }
 
+// sendEmail отправляет письмо только с текстовой версией
 func (s *Server) sendEmail(to, subject, body string) error {
-	addr := fmt.Sprintf("%s:%s", s.config.SMTPHost, s.config.SMTPPort)
-	
-	// Формируем заголовки письма
-	// Важно: Mail.ru и другие провайдеры требуют правильных заголовков From и Content-Type
-	header := make(map[string]string)
-	header["From"] = s.config.SMTPFrom
-	header["To"] = to
-	header["Subject"] = subject
-	header["MIME-Version"] = "1.0"
-	header["Content-Type"] = "text/plain; charset=\"utf-8\""
-
-	message := ""
-	for k, v := range header {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + body
-
-	msg := []byte(message)
-	
-	// Получаем чистый email отправителя для команды MAIL FROM
-	// Если SMTPFrom в формате "Name <email>", нужно извлечь email
-	senderEmail := s.config.SMTPFrom
-	if start := strings.LastIndex(s.config.SMTPFrom, "<"); start != -1 {
-		if end := strings.LastIndex(s.config.SMTPFrom, ">"); end != -1 && end > start {
-			senderEmail = s.config.SMTPFrom[start+1 : end]
-		}
-	}
-
-	log.Printf("📧 Sending email from %s (auth: %s) to %s...", senderEmail, s.config.SMTPUser, to)
-
-	// Если порт 465, используем неявный SSL/TLS (Implicit SSL)
-	if s.config.SMTPPort == "465" {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: false,
-			ServerName:         s.config.SMTPHost,
-		}
-
-		conn, err := tls.Dial("tcp", addr, tlsConfig)
-		if err != nil {
-			return fmt.Errorf("failed to dial TLS: %w", err)
-		}
-		defer conn.Close()
-
-		client, err := smtp.NewClient(conn, s.config.SMTPHost)
-		if err != nil {
-			return fmt.Errorf("failed to create SMTP client: %w", err)
-		}
-		defer client.Quit()
-
-		auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPassword, s.config.SMTPHost)
-		if err := client.Auth(auth); err != nil {
-			return fmt.Errorf("failed to authenticate: %w", err)
-		}
-
-		if err := client.Mail(senderEmail); err != nil {
-			return fmt.Errorf("failed to set sender (MAIL FROM): %w", err)
-		}
-		if err := client.Rcpt(to); err != nil {
-			return fmt.Errorf("failed to set recipient (RCPT TO): %w", err)
-		}
-		w, err := client.Data()
-		if err != nil {
-			return fmt.Errorf("failed to create data writer: %w", err)
-		}
-		_, err = w.Write(msg)
-		if err != nil {
-			return fmt.Errorf("failed to write message: %w", err)
-		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to close writer: %w", err)
-		}
-		log.Printf("✅ Email sent successfully to %s", to)
-		return nil
-	}
-
-	// Для остальных портов (587, 25) используем стандартный sendMail (STARTTLS)
-	auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPassword, s.config.SMTPHost)
-	err := smtp.SendMail(addr, auth, senderEmail, []string{to}, msg)
-	if err != nil {
-		return fmt.Errorf("smtp.SendMail failed: %w", err)
-	}
-	log.Printf("✅ Email sent successfully to %s", to)
-	return nil
+	return s.deliverMail(&mail.Message{From: s.config.SMTPFrom, To: to, Subject: subject, Text: body})
 }
 
 func (s *Server) handleEmailVerify(w http.ResponseWriter, r *http.Request) {
@@ -698,8 +1133,18 @@ func (s *Server) handleEmailVerify(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
 		return
 	}
+	email, err := validate.Email(req.Email)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("email", err))
+		return
+	}
+	req.Email = email
 
-	valid, err := s.db.ValidateEmailVerification(req.Email, req.Code)
+	if s.codeLocked(w, req.Email) {
+		return
+	}
+	valid, err := s.db.ValidateEmailVerification(r.Context(), req.Email, req.Code)
+	s.codeChecked(req.Email, err == nil && valid)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
@@ -715,6 +1160,7 @@ func (s *Server) handleEmailVerify(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"message": "Email verified successfully",
+		"proof":   s.issueProof(proofEmail, req.Email),
 	})
 }
 
@@ -727,9 +1173,12 @@ func (s *Server) handlePhoneAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Phone    string `json:"phone"`
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Phone     string `json:"phone"`
+		Name      string `json:"name"`
+		Password  string `json:"password"`
+		Captcha   string `json:"captcha"`   // прежнее имя challenge
+		Proof     string `json:"proof"`     // токен из /sms/verify или /email/verify, нужен для регистрации
+		Challenge string `json:"challenge"` // ответ на испытание из /challenge
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -738,36 +1187,75 @@ func (s *Server) handlePhoneAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	phone, err := validate.Phone(req.Phone)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("phone", err))
+		return
+	}
+
 	// Проверяем, существует ли пользователь с таким номером
-	existingUser, err := s.db.GetUserByPhone(req.Phone)
+	existingUser, err := s.db.GetUserByPhone(r.Context(), phone)
+	if err != nil && phone != req.Phone {
+		// Аккаунт, созданный до нормализации номеров
+		existingUser, err = s.db.GetUserByPhone(r.Context(), req.Phone)
+	}
 	if err == nil {
 		// Пользователь существует - выполняем вход
-		if existingUser.Password != req.Password {
+		if s.loginLocked(w, r, phone) {
+			return
+		}
+		if _, err := s.db.ValidateUser(r.Context(), existingUser.Phone, req.Password); err != nil {
+			s.loginFailed(r, phone, existingUser)
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid password"})
 			return
 		}
+		s.loginSucceeded(phone)
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"user":    existingUser,
-			"message": "Login successful",
+			"success":   true,
+			"user":      existingUser,
+			"message":   "Login successful",
+			"signaling": s.signalingSession(existingUser.ID),
+			"session":   s.issueSession(r, existingUser.ID),
 		})
 		return
 	}
 
 	// Пользователь не существует - создаем нового
-	user, err := s.db.CreateUser(req.Name, req.Password, req.Phone)
+	// Регистрация без приглашения: неизвестный пользователь
+	if req.Challenge == "" {
+		req.Challenge = req.Captcha
+	}
+	if !s.passChallenge(w, r, trust.Unknown, req.Challenge) {
+		return
+	}
+
+	name, err := validateNewAccount(req.Name, req.Password, phone)
+	if err != nil {
+		s.writeValidationErrors(w, r, err)
+		return
+	}
+	if !s.proofs.consume(req.Proof, proofPhone, phone) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Phone number is not verified"})
+		return
+	}
+	user, err := s.db.CreateUser(r.Context(), name, req.Password, phone)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create user"})
 		return
 	}
+	s.saveTrust(user.ID, trust.Unknown, "")
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"user":    user,
-		"message": "Registration successful",
+		"success":   true,
+		"user":      user,
+		"message":   "Registration successful",
+		"trust":     trust.Unknown,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
 	})
 }
 
@@ -780,9 +1268,12 @@ func (s *Server) handleEmailAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Email    string `json:"email"`
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Email     string `json:"email"`
+		Name      string `json:"name"`
+		Password  string `json:"password"`
+		Captcha   string `json:"captcha"`   // прежнее имя challenge
+		Proof     string `json:"proof"`     // токен из /sms/verify или /email/verify, нужен для регистрации
+		Challenge string `json:"challenge"` // ответ на испытание из /challenge
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -791,33 +1282,71 @@ func (s *Server) handleEmailAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser, err := s.db.GetUserByEmail(req.Email)
+	email, err := validate.Email(req.Email)
+	if err != nil {
+		s.writeValidationErrors(w, r, validate.Field("email", err))
+		return
+	}
+
+	existingUser, err := s.db.GetUserByEmail(r.Context(), email)
+	if err != nil && email != req.Email {
+		existingUser, err = s.db.GetUserByEmail(r.Context(), req.Email)
+	}
 	if err == nil {
-		if existingUser.Password != req.Password {
+		if s.loginLocked(w, r, email) {
+			return
+		}
+		if _, err := s.db.ValidateUser(r.Context(), existingUser.Email, req.Password); err != nil {
+			s.loginFailed(r, email, existingUser)
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid password"})
 			return
 		}
+		s.loginSucceeded(email)
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"user":    existingUser,
-			"message": "Login successful",
+			"success":   true,
+			"user":      existingUser,
+			"message":   "Login successful",
+			"signaling": s.signalingSession(existingUser.ID),
+			"session":   s.issueSession(r, existingUser.ID),
 		})
 		return
 	}
 
-	user, err := s.db.CreateUser(req.Name, req.Password, req.Email)
+	// Регистрация без приглашения: неизвестный пользователь
+	if req.Challenge == "" {
+		req.Challenge = req.Captcha
+	}
+	if !s.passChallenge(w, r, trust.Unknown, req.Challenge) {
+		return
+	}
+
+	name, err := validateNewAccount(req.Name, req.Password, email)
+	if err != nil {
+		s.writeValidationErrors(w, r, err)
+		return
+	}
+	if !s.proofs.consume(req.Proof, proofEmail, email) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Email is not verified"})
+		return
+	}
+	user, err := s.db.CreateUser(r.Context(), name, req.Password, email)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to create user"})
 		return
 	}
+	s.saveTrust(user.ID, trust.Unknown, "")
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"user":    user,
-		"message": "Registration successful",
+		"success":   true,
+		"user":      user,
+		"message":   "Registration successful",
+		"trust":     trust.Unknown,
+		"signaling": s.signalingSession(user.ID),
+		"session":   s.issueSession(r, user.ID),
 	})
 }
 
@@ -825,8 +1354,20 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	status := s.transportManager.GetStatus()
 
 	response := map[string]interface{}{
-		"transports": status,
-		"status":     "active",
+		"transports":     status,
+		"fronting_modes": s.transportManager.GetFrontingModes(),
+		"fronts":         s.transportManager.FrontPool().Status(),
+		"status":         "active",
+	}
+	if meshTransport := s.transportManager.Mesh(); meshTransport != nil {
+		response["mesh_resources"] = meshTransport.ResourceStatus()
+	}
+
+	// Режим связи: звонки или (если политика не оставляет пути) голосовые сообщения
+	mode, reason := s.callPath()
+	response["call_mode"] = mode
+	if reason != "" {
+		response["call_mode_reason"] = reason
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -864,10 +1405,25 @@ func (s *Server) handleVoiceSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Голосовое сообщение беседы удаляется по ее сроку хранения
+	if conversationID := r.FormValue("conversation_id"); conversationID != "" && s.db != nil {
+		vf := &storage.VoiceFile{ID: voiceMsg.ID, ConversationID: conversationID, Path: voiceMsg.FilePath}
+		// Автор нужен, чтобы удалить его голосовые сообщения вместе с аккаунтом
+		if sender, err := s.bearerUser(r); err == nil {
+			vf.SenderID = sender
+		}
+		if err := s.db.CreateVoiceFile(r.Context(), vf); err != nil {
+			log.Printf("Failed to record voice message %s: %v", voiceMsg.ID, err)
+		}
+	}
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":  true,
 		"voice_id": voiceMsg.ID,
 		"duration": voiceMsg.Duration,
+		"format":   voiceMsg.Format,
+		"codec":    voiceMsg.Codec,
+		"bitrate":  voiceMsg.Bitrate,
 		"url":      fmt.Sprintf("/api/voice/%s.mp3", voiceMsg.ID),
 	})
 }
@@ -885,10 +1441,6 @@ func (s *Server) handleVoiceGet(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filePath)
 }
 
-func (s *Server) handleCallStart(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
-}
-
 func (s *Server) handleCallAnswer(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
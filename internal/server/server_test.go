@@ -2,36 +2,56 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"hydra/internal/config"
+	"hydra/pkg/archive"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/challenge"
+	"hydra/pkg/discovery"
+	"hydra/pkg/lookup"
+	"hydra/pkg/oidc"
+	"hydra/pkg/push"
+	"hydra/pkg/ratelimit"
+	"hydra/pkg/reachability"
+	"hydra/pkg/relay/relaytest"
+	"hydra/pkg/signaling"
+	"hydra/pkg/sms"
 	"hydra/pkg/storage"
+	"hydra/pkg/telemetry"
+	"hydra/pkg/timesync"
 	"hydra/pkg/transport/manager"
+	"hydra/pkg/trust"
+	"hydra/pkg/validate"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func setupTestServer() (*Server, func()) {
-	// Use the same credentials as in main.go, but potentially a different DB or the same one.
-	// WARNING: This runs against the real DB if configured so.
-	// For now, we use the provided credentials.
-	connStr := "user=postgres password=postgres dbname=hydra sslmode=disable"
-
-	// Initialize storage
-	store, err := storage.New(connStr)
-	if err != nil {
-		// If DB is not available, we can't run tests.
-		// In a real CI environment, we'd handle this better (skip or fail).
-		// For this local setup, we'll panic to indicate failure.
-		panic(err)
-	}
+	// In-memory storage keeps tests independent of a live database
+	store := storage.NewMemory()
 
 	// Initialize transport manager (mock or minimal)
-	tm := manager.New()
+	tm := manager.New(nil)
 
 	// Create config
 	cfg := &config.Config{
-		DatabaseURL:      connStr,
 		ServerPort:       "8081",
 		VoiceStoragePath: "./test_voice_storage",
 		WebStaticPath:    "./test_web",
@@ -40,6 +60,12 @@ func setupTestServer() (*Server, func()) {
 		SMTPUser:         "user",
 		SMTPPassword:     "pass",
 		SMTPFrom:         "test@example.com",
+
+		ReceiptDetail:     "sampled",
+		ReceiptSampleSize: 5,
+
+		// Испытания перед регистрацией проверяются отдельными тестами
+		ChallengeProvider: "none",
 	}
 
 	// Create server
@@ -54,13 +80,6 @@ func setupTestServer() (*Server, func()) {
 }
 
 func TestSMSFlow(t *testing.T) {
-	// Recover from panic if DB is not available
-	defer func() {
-		if r := recover(); r != nil {
-			t.Skipf("Skipping test due to DB connection error: %v", r)
-		}
-	}()
-
 	srv, cleanup := setupTestServer()
 	defer cleanup()
 
@@ -78,7 +97,7 @@ func TestSMSFlow(t *testing.T) {
 
 	// 2. Inject Code manually for verification test
 	knownCode := "123456"
-	err := srv.db.CreateSMSVerification(phone, knownCode)
+	err := srv.db.CreateSMSVerification(t.Context(), phone, knownCode)
 	if err != nil {
 		t.Fatalf("Failed to inject code: %v", err)
 	}
@@ -92,23 +111,37 @@ func TestSMSFlow(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200 for verify, got %d. Body: %s", w.Code, w.Body.String())
 	}
+	var verified struct {
+		Proof string `json:"proof"`
+	}
+	json.NewDecoder(w.Body).Decode(&verified)
 
-	// 4. Register/Login with Phone
-	w = httptest.NewRecorder()
-	body, _ = json.Marshal(map[string]string{
-		"phone":    phone,
-		"name":     "Test User",
-		"password": "password123",
-	})
-	req = httptest.NewRequest("POST", "/api/auth/phone", bytes.NewBuffer(body))
-	srv.handlePhoneAuth(w, req)
+	// 4. Register/Login with Phone: без подтверждения кода аккаунт не создается
+	register := func(proof string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]string{
+			"phone":    phone,
+			"name":     "Test User",
+			"password": "correct-horse-42",
+			"proof":    proof,
+		})
+		srv.handlePhoneAuth(w, httptest.NewRequest("POST", "/api/auth/phone", bytes.NewBuffer(body)))
+		return w
+	}
+	if w := register(""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without proof, got %d", w.Code)
+	}
+	if proof := srv.issueProof(proofPhone, "+1234567891"); register(proof).Code != http.StatusForbidden {
+		t.Error("proof for another phone accepted")
+	}
+	w = register(verified.Proof)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200 for auth, got %d. Body: %s", w.Code, w.Body.String())
 	}
 
 	// Check if user was created
-	user, err := srv.db.GetUserByPhone(phone)
+	user, err := srv.db.GetUserByPhone(t.Context(), phone)
 	if err != nil {
 		t.Errorf("User was not created: %v", err)
 	} else if user.Name != "Test User" {
@@ -117,17 +150,11 @@ func TestSMSFlow(t *testing.T) {
 
 	// Cleanup test user
 	if user != nil {
-		srv.db.DeleteUser(user.ID)
+		srv.db.DeleteUser(t.Context(), user.ID)
 	}
 }
 
 func TestEmailFlow(t *testing.T) {
-	defer func() {
-		if r := recover(); r != nil {
-			t.Skipf("Skipping test due to DB connection error: %v", r)
-		}
-	}()
-
 	srv, cleanup := setupTestServer()
 	defer cleanup()
 
@@ -145,7 +172,7 @@ func TestEmailFlow(t *testing.T) {
 	}
 
 	// 2. Inject Code manually for verification test
-	err := srv.db.CreateEmailVerification(email, knownCode)
+	err := srv.db.CreateEmailVerification(t.Context(), email, knownCode)
 	if err != nil {
 		t.Fatalf("Failed to inject code: %v", err)
 	}
@@ -159,13 +186,18 @@ func TestEmailFlow(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200 for verify, got %d. Body: %s", w.Code, w.Body.String())
 	}
+	var verified struct {
+		Proof string `json:"proof"`
+	}
+	json.NewDecoder(w.Body).Decode(&verified)
 
 	// 4. Register/Login with Email
 	w = httptest.NewRecorder()
 	body, _ = json.Marshal(map[string]string{
 		"email":    email,
 		"name":     "Test Email User",
-		"password": "password123",
+		"password": "correct-horse-42",
+		"proof":    verified.Proof,
 	})
 	req = httptest.NewRequest("POST", "/api/auth/email", bytes.NewBuffer(body))
 	srv.handleEmailAuth(w, req)
@@ -175,15 +207,3262 @@ func TestEmailFlow(t *testing.T) {
 	}
 
 	// Check if user was created
-	user, err := srv.db.GetUserByEmail(email)
+	user, err := srv.db.GetUserByEmail(t.Context(), email)
 	if err != nil {
 		t.Errorf("User was not created: %v", err)
 	} else if user.Name != "Test Email User" {
 		t.Errorf("Expected name 'Test Email User', got '%s'", user.Name)
 	}
 
+	// Токен подтверждения одноразовый
+	if srv.proofs.consume(verified.Proof, proofEmail, email) {
+		t.Error("proof consumed twice")
+	}
+
 	// Cleanup test user
 	if user != nil {
-		srv.db.DeleteUser(user.ID)
+		srv.db.DeleteUser(t.Context(), user.ID)
+	}
+}
+
+func TestLoginSessions(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	if _, err := srv.db.CreateUser(t.Context(), "Alice", "secret", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	type sessionResponse struct {
+		Success bool             `json:"success"`
+		Session *storage.Session `json:"session"`
+	}
+	post := func(handler http.HandlerFunc, token string, payload interface{}) (int, sessionResponse) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+		req.Header.Set("X-Device-ID", "laptop")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var resp sessionResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, login := post(srv.handleLogin, "", map[string]string{"contact_info": "alice@example.com", "password": "secret"})
+	if code != http.StatusOK || login.Session == nil || login.Session.Token == "" || login.Session.DeviceID != "laptop" {
+		t.Fatalf("login: %d %+v", code, login.Session)
+	}
+
+	// Токен обновления обменивается на новую пару один раз
+	code, refreshed := post(srv.handleAuthRefresh, "", map[string]string{"refresh_token": login.Session.RefreshToken})
+	if code != http.StatusOK || refreshed.Session == nil || refreshed.Session.ID != login.Session.ID {
+		t.Fatalf("refresh: %d %+v", code, refreshed.Session)
+	}
+	if code, _ := post(srv.handleAuthRefresh, "", map[string]string{"refresh_token": login.Session.RefreshToken}); code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: %d", code)
+	}
+
+	if code, _ := post(srv.handleAuthLogout, login.Session.Token, nil); code != http.StatusUnauthorized {
+		t.Errorf("logout with replaced token: %d", code)
+	}
+	if code, _ := post(srv.handleAuthLogout, refreshed.Session.Token, nil); code != http.StatusOK {
+		t.Fatalf("logout: %d", code)
+	}
+	if _, err := srv.db.ValidateSession(t.Context(), refreshed.Session.Token, storage.SessionClient{}); err == nil {
+		t.Error("session valid after logout")
+	}
+}
+
+// TestSessionManagement проверяет список сессий и их завершение с отзывом токенов
+func TestSessionManagement(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	alice, err := srv.db.CreateUser(t.Context(), "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, _ := srv.db.CreateSession(t.Context(), alice.ID, "laptop", storage.SessionClient{}, time.Hour, time.Hour)
+	phone, _ := srv.db.CreateSession(t.Context(), alice.ID, "phone", storage.SessionClient{}, time.Hour, time.Hour)
+	// Токен сигнализации, выданный две секунды назад
+	issued := signaling.IssueToken(srv.signalingSecret, alice.ID, srv.signalingTTL()-2*time.Second)
+
+	call := func(method, path, token string, header ...string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, "/api/v1/sessions"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "Hydra/2.0 (Linux)")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	current := func(resp map[string]interface{}) (ids []string) {
+		sessions, _ := resp["sessions"].([]interface{})
+		for _, item := range sessions {
+			if sess, _ := item.(map[string]interface{}); sess["current"] == true {
+				ids = append(ids, sess["id"].(string))
+			}
+		}
+		return ids
+	}
+
+	if code, _ := call(http.MethodGet, "", "bogus"); code != http.StatusUnauthorized {
+		t.Errorf("list with invalid token: %d", code)
+	}
+	code, listed := call(http.MethodGet, "", laptop.Token)
+	sessions, _ := listed["sessions"].([]interface{})
+	if code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("list: %d %v", code, listed)
+	}
+	if first, _ := sessions[0].(map[string]interface{}); first["id"] != laptop.ID || first["user_agent"] != "Hydra/2.0 (Linux)" || first["ip"] != "192.0.2.1" {
+		t.Errorf("last used session: %v", first)
+	}
+	if ids := current(listed); len(ids) != 1 || ids[0] != laptop.ID {
+		t.Errorf("current by session token: %v", ids)
+	}
+	if _, listed := call(http.MethodGet, "", issued, "X-Device-ID", "phone"); fmt.Sprint(current(listed)) != fmt.Sprint([]string{phone.ID}) {
+		t.Errorf("current by device: %v", current(listed))
+	}
+
+	// Все сессии, кроме текущей, завершаются только с токеном сессии
+	if code, _ := call(http.MethodDelete, "", issued); code != http.StatusBadRequest {
+		t.Errorf("revoke others with signaling token: %d", code)
+	}
+	code, revoked := call(http.MethodDelete, "", laptop.Token)
+	if code != http.StatusOK || revoked["revoked"] != float64(1) {
+		t.Fatalf("revoke others: %d %v", code, revoked)
+	}
+	fresh, _ := revoked["signaling"].(map[string]interface{})["token"].(string)
+	if code, _ := call(http.MethodGet, "", phone.Token); code != http.StatusUnauthorized {
+		t.Errorf("revoked session token: %d", code)
+	}
+	if code, _ := call(http.MethodGet, "", issued); code != http.StatusUnauthorized {
+		t.Errorf("signaling token issued before revoke: %d", code)
+	}
+	if code, _ := call(http.MethodGet, "", fresh); code != http.StatusOK {
+		t.Errorf("fresh signaling token: %d", code)
+	}
+
+	// Отдельная сессия: чужую не найти
+	bob, _ := srv.db.CreateUser(t.Context(), "Bob", "secret", "bob@example.com")
+	if code, _ := call(http.MethodDelete, "/"+laptop.ID, srv.signalingSession(bob.ID)["token"].(string)); code != http.StatusNotFound {
+		t.Errorf("revoke another user's session: %d", code)
+	}
+	if code, _ := call(http.MethodDelete, "/"+laptop.ID, fresh); code != http.StatusOK {
+		t.Fatalf("revoke session: %d", code)
+	}
+	if code, _ := call(http.MethodGet, "", laptop.Token); code != http.StatusUnauthorized {
+		t.Errorf("token of revoked session: %d", code)
+	}
+}
+
+// fakeIdP - поставщик входа, возвращающий учетную запись по коду
+type fakeIdP struct {
+	identities map[string]*oidc.Identity
+}
+
+func (p *fakeIdP) AuthURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	return "https://idp.example/auth?" + url.Values{"state": {state}, "redirect_uri": {redirectURI}}.Encode(), nil
+}
+
+func (p *fakeIdP) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*oidc.Identity, error) {
+	if identity := p.identities[code]; identity != nil && verifier != "" {
+		return identity, nil
+	}
+	return nil, fmt.Errorf("invalid code")
+}
+
+// TestOIDCLogin проверяет вход, регистрацию по приглашению и привязку учетных записей
+// внешнего поставщика
+func TestOIDCLogin(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.PublicURL = "https://hydra.example"
+	srv.oidcProviders = map[string]oidc.Provider{"keycloak": &fakeIdP{identities: map[string]*oidc.Identity{
+		"alice": {Subject: "kc-alice", Email: "Alice@Example.com", EmailVerified: true, Name: "Alice Liddell"},
+		"bob":   {Subject: "kc-bob", Email: "bob@example.com", EmailVerified: true, Name: "Bob"},
+		"carol": {Subject: "kc-carol", Email: "carol@example.com", EmailVerified: false},
+	}}}
+	handler := srv.Handler()
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+	// login проходит вход у поставщика с кодом code и возвращает адрес возврата к клиенту
+	login := func(token, body, code string) *url.URL {
+		t.Helper()
+		rec := call(http.MethodPost, "/auth/oidc/keycloak/start", token, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("start: %d %s", rec.Code, rec.Body)
+		}
+		authURL, _ := url.Parse(decode(rec)["auth_url"].(string))
+		if got := authURL.Query().Get("redirect_uri"); got != "https://hydra.example/api/v1/auth/oidc/keycloak/callback" {
+			t.Errorf("redirect uri: %s", got)
+		}
+		rec = call(http.MethodGet, "/auth/oidc/keycloak/callback?"+url.Values{"state": {authURL.Query().Get("state")}, "code": {code}}.Encode(), "", "")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+		}
+		location, _ := url.Parse(rec.Header().Get("Location"))
+		return location
+	}
+	complete := func(location *url.URL) (int, map[string]interface{}) {
+		t.Helper()
+		code := location.Query().Get("oidc_code")
+		if code == "" {
+			t.Fatalf("no login code: %s", location)
+		}
+		rec := call(http.MethodPost, "/auth/oidc/complete", "", fmt.Sprintf(`{"code": %q}`, code))
+		return rec.Code, decode(rec)
+	}
+
+	if providers := decode(call(http.MethodGet, "/auth/oidc", "", ""))["providers"]; fmt.Sprint(providers) != "[keycloak]" {
+		t.Errorf("providers: %v", providers)
+	}
+	if rec := call(http.MethodPost, "/auth/oidc/google/start", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: %d", rec.Code)
+	}
+
+	// По умолчанию регистрация только по приглашению
+	if got := login("", "", "alice").Query().Get("oidc_error"); got != oidcErrorInvite {
+		t.Errorf("signup without invite: %q", got)
+	}
+	invite := &storage.Invite{ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1}
+	if err := srv.db.IssueInvite(t.Context(), invite); err != nil {
+		t.Fatal(err)
+	}
+	location := login("", fmt.Sprintf(`{"invite": %q}`, invite.Token), "alice")
+	if location.Host != "hydra.example" || location.Path != "/login.html" {
+		t.Errorf("web redirect: %s", location)
+	}
+	code, registered := complete(location)
+	user, _ := registered["user"].(map[string]interface{})
+	if code != http.StatusOK || registered["message"] != "Registration successful" || user["email"] != "Alice@example.com" ||
+		user["name"] != "Alice Liddell" || registered["session"] == nil {
+		t.Fatalf("signup: %d %v", code, registered)
+	}
+	aliceID := user["id"].(string)
+	if rec := call(http.MethodPost, "/auth/oidc/complete", "", fmt.Sprintf(`{"code": %q}`, location.Query().Get("oidc_code"))); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login code reused: %d", rec.Code)
+	}
+
+	// Повторный вход - в тот же аккаунт, без приглашения; приложение получает код по своей схеме
+	location = login("", `{"app": true}`, "alice")
+	if location.Scheme != "hydra" || location.Host != "oidc" {
+		t.Errorf("app redirect: %s", location)
+	}
+	if code, again := complete(location); code != http.StatusOK || again["message"] != "Login successful" || again["user"].(map[string]interface{})["id"] != aliceID {
+		t.Errorf("login: %d %v", code, again)
+	}
+
+	// Свободная регистрация: аккаунт с тем же email не привязывается сам, неподтвержденный email не принимается
+	srv.config.OIDCSignup = oidcSignupOpen
+	bob, _ := srv.db.CreateUser(t.Context(), "Bob", "secret", "bob@example.com")
+	if got := login("", "", "bob").Query().Get("oidc_error"); got != oidcErrorAccountExists {
+		t.Errorf("existing email: %q", got)
+	}
+	if got := login("", "", "carol").Query().Get("oidc_error"); got != oidcErrorEmail {
+		t.Errorf("unverified email: %q", got)
+	}
+
+	// Привязка учетной записи к вошедшему пользователю
+	bobToken := srv.signalingSession(bob.ID)["token"].(string)
+	if got := login(bobToken, "", "alice").Query().Get("oidc_error"); got != oidcErrorTaken {
+		t.Errorf("linking identity of another account: %q", got)
+	}
+	if code, linked := complete(login(bobToken, "", "bob")); code != http.StatusOK || linked["identity"].(map[string]interface{})["provider"] != "keycloak" || linked["session"] != nil {
+		t.Errorf("link: %d %v", code, linked)
+	}
+	if code, again := complete(login("", "", "bob")); code != http.StatusOK || again["user"].(map[string]interface{})["id"] != bob.ID {
+		t.Errorf("login with linked identity: %d %v", code, again)
+	}
+
+	// Отказ у поставщика и повтор state
+	rec := call(http.MethodPost, "/auth/oidc/keycloak/start", "", "")
+	authURL, _ := url.Parse(decode(rec)["auth_url"].(string))
+	state := authURL.Query().Get("state")
+	rec = call(http.MethodGet, "/auth/oidc/keycloak/callback?error=access_denied&state="+url.QueryEscape(state), "", "")
+	if location, _ := url.Parse(rec.Header().Get("Location")); location.Query().Get("oidc_error") != oidcErrorDenied {
+		t.Errorf("denied: %s", location)
+	}
+	rec = call(http.MethodGet, "/auth/oidc/keycloak/callback?code=bob&state="+url.QueryEscape(state), "", "")
+	if location, _ := url.Parse(rec.Header().Get("Location")); location.Query().Get("oidc_error") != oidcErrorState {
+		t.Errorf("state reused: %s", location)
+	}
+
+	// Учетная запись, через которую создан аккаунт, не отвязывается, пока она единственная
+	aliceToken := srv.signalingSession(aliceID)["token"].(string)
+	if rec := call(http.MethodGet, "/users/"+aliceID+"/identities", bobToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("identities of another user: %d", rec.Code)
+	}
+	if identities, _ := decode(call(http.MethodGet, "/users/"+aliceID+"/identities", aliceToken, ""))["identities"].([]interface{}); len(identities) != 1 {
+		t.Errorf("identities: %v", identities)
+	}
+	if rec := call(http.MethodDelete, "/users/"+aliceID+"/identities/keycloak", aliceToken, ""); rec.Code != http.StatusConflict {
+		t.Errorf("unlink signup identity: %d", rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/users/"+bob.ID+"/identities/keycloak", bobToken, ""); rec.Code != http.StatusOK {
+		t.Errorf("unlink: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(http.MethodDelete, "/users/"+bob.ID+"/identities/keycloak", bobToken, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unlink twice: %d", rec.Code)
+	}
+}
+
+// TestBruteForceLockout проверяет блокировку входа и проверки кодов после неудачных попыток
+func TestBruteForceLockout(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.bruteForce = newBruteForceGuard(&config.Config{
+		LoginMaxFailures:   3,
+		LoginIPMaxFailures: 10,
+		LoginLockout:       time.Minute,
+		CodeMaxAttempts:    3,
+		CodeLockout:        time.Minute,
+	})
+	for _, contact := range []string{"alice@example.com", "bob@example.com"} {
+		if _, err := srv.db.CreateUser(t.Context(), "User", "correct-horse-42", contact); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := func(handler http.HandlerFunc, payload map[string]string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/", bytes.NewBuffer(body)))
+		return w
+	}
+	login := func(contact, password string) int {
+		return post(srv.handleLogin, map[string]string{"contact_info": contact, "password": password}).Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := login("alice@example.com", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: %d", i+1, code)
+		}
+	}
+	w := post(srv.handleLogin, map[string]string{"contact_info": "Alice@Example.com", "password": "correct-horse-42"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login to locked account: %d", w.Code)
+	}
+	if code := login("bob@example.com", "correct-horse-42"); code != http.StatusOK {
+		t.Errorf("other account locked: %d", code)
+	}
+
+	// Неверные коды блокируют проверку, даже если следующий код верный
+	phone := "+15551234567"
+	if err := srv.db.CreateSMSVerification(t.Context(), phone, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		post(srv.handleSMSVerify, map[string]string{"phone": phone, "code": fmt.Sprintf("00000%d", i)})
+	}
+	if w := post(srv.handleSMSVerify, map[string]string{"phone": phone, "code": "123456"}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("verify after failed attempts: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMaintenanceModeRejectsWrites(t *testing.T) {
+	srv := &Server{config: &config.Config{}}
+	handler := srv.withMaintenance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	srv.setMaintenance(true, "migration")
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/contacts", http.StatusOK},
+		{http.MethodPost, "/api/register", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/api/users/u1/devices/d1", http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/send", http.StatusOK},
+		{http.MethodPut, "/api/admin/maintenance", http.StatusOK},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.path, tc.want, rr.Code)
+		}
+		if rr.Code == http.StatusServiceUnavailable && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s: expected Retry-After header", tc.method, tc.path)
+		}
+	}
+
+	srv.mu.Lock()
+	srv.maintenance = false // без flushOutbox: в тесте нет БД
+	srv.mu.Unlock()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/register", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected writes to pass after maintenance, got %d", rr.Code)
+	}
+}
+
+func TestSplitEventsPath(t *testing.T) {
+	cases := map[string][2]string{
+		"u1/events":        {"u1", "poll"},
+		"u1/events/stream": {"u1", "stream"},
+		"u1/events/ws":     {"u1", "ws"},
+	}
+	for path, want := range cases {
+		userID, mode, ok := splitEventsPath(path)
+		if !ok || userID != want[0] || mode != want[1] {
+			t.Errorf("splitEventsPath(%q) = %q, %q, %v", path, userID, mode, ok)
+		}
+	}
+	for _, path := range []string{"u1", "/events", "u1/devices"} {
+		if _, _, ok := splitEventsPath(path); ok {
+			t.Errorf("Expected %q not to be an events path", path)
+		}
+	}
+}
+
+func TestEventHubWakesWaiters(t *testing.T) {
+	hub := newEventHub()
+	wake := hub.wait("u1")
+	other := hub.wait("u2")
+
+	hub.notify("u1")
+	select {
+	case <-wake:
+	default:
+		t.Fatal("Expected waiter of u1 to be woken")
+	}
+	select {
+	case <-other:
+		t.Fatal("Waiter of u2 must not be woken by u1 events")
+	default:
+	}
+
+	// После уведомления новый ожидающий ждет следующего события
+	select {
+	case <-hub.wait("u1"):
+		t.Fatal("Expected a fresh wait channel after notify")
+	default:
+	}
+}
+
+func TestCaptchaChallengeForUnknownUsers(t *testing.T) {
+	captcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": r.Form.Get("secret") == "s3cret" && r.Form.Get("response") == "ok",
+		})
+	}))
+	defer captcha.Close()
+
+	cfg := &config.Config{TrustChallengeBelow: "invited", CaptchaVerifyURL: captcha.URL, CaptchaSecret: "s3cret"}
+	srv := &Server{config: cfg, trust: newTrustPolicy(cfg), challenge: newChallengeProvider(cfg, nil)}
+
+	cases := []struct {
+		level    trust.Level
+		response string
+		want     bool
+	}{
+		{trust.Invited, "", true}, // приглашенные проверенными участниками проходят без CAPTCHA
+		{trust.Unknown, "", false},
+		{trust.Unknown, "bad", false},
+		{trust.Unknown, "ok", true},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/email", nil)
+		if got := srv.passChallenge(rr, req, tc.level, tc.response); got != tc.want {
+			t.Errorf("passChallenge(%s, %q) = %v, want %v", tc.level, tc.response, got, tc.want)
+		}
+		if !tc.want && rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for rejected challenge, got %d", rr.Code)
+		}
+	}
+}
+
+// TestProofOfWorkChallenge проверяет, что отправка кода требует решенного испытания pow
+func TestProofOfWorkChallenge(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.challenge = challenge.NewProofOfWork([]byte("secret"), 8, time.Minute)
+	handler := srv.Handler()
+
+	send := func(response string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]string{"phone": "+15551234567", "challenge": response})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/sms/send", bytes.NewBuffer(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	if rec, resp := send(""); rec.Code != http.StatusForbidden || resp["challenge"] == nil {
+		t.Fatalf("send without challenge: %d %v", rec.Code, resp)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/challenge", nil))
+	var issued struct {
+		Challenge *challenge.Challenge `json:"challenge"`
+	}
+	json.NewDecoder(rec.Body).Decode(&issued)
+	if rec.Code != http.StatusOK || issued.Challenge == nil || issued.Challenge.Kind != challenge.KindPoW {
+		t.Fatalf("issue challenge: %d %+v", rec.Code, issued.Challenge)
+	}
+	response := challenge.Solve(issued.Challenge.Challenge, issued.Challenge.Difficulty)
+	if rec, resp := send(response); rec.Code != http.StatusOK {
+		t.Errorf("send with solved challenge: %d %v", rec.Code, resp)
+	}
+	if rec, _ := send(response); rec.Code != http.StatusForbidden {
+		t.Errorf("reused challenge: %d", rec.Code)
+	}
+}
+
+func TestSendLimitByTrust(t *testing.T) {
+	cfg := &config.Config{TrustSendRateUnknown: 2, TrustSendRateVerified: 0}
+	srv := &Server{config: cfg, trust: newTrustPolicy(cfg)}
+	srv.sendLimiters = newSendLimiters(srv.trust)
+
+	if _, limited := srv.sendLimiters[trust.Verified]; limited {
+		t.Error("Expected no limiter for a zero rate")
+	}
+
+	// Без БД отправитель неизвестен и получает лимит уровня unknown
+	for i := 0; i < 2; i++ {
+		if !srv.allowSend("u1") {
+			t.Fatalf("Message %d should be allowed", i+1)
+		}
+	}
+	if srv.allowSend("u1") {
+		t.Error("Expected unknown sender to be rate limited")
+	}
+	if !srv.allowSend("u2") {
+		t.Error("Limits must be per sender")
+	}
+
+	// Без токена действует лимит анонимных отправителей
+	srv.allowSend("")
+	srv.allowSend("")
+	rec := httptest.NewRecorder()
+	srv.handleSend(rec, httptest.NewRequest(http.MethodPost, "/api/send", strings.NewReader(`{"message": "hi", "to": "u1"}`)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected anonymous limit for an unauthenticated sender, got %d", rec.Code)
+	}
+}
+
+func TestReachabilityReportsAndBridges(t *testing.T) {
+	signer, _ := timesync.NewSigner(nil)
+	reportKey, _ := reachability.NewServerKey(nil)
+	srv := &Server{
+		config:        &config.Config{},
+		timeSigner:    signer,
+		reachability:  reachability.NewAggregator(time.Hour, 2),
+		telemetry:     telemetry.NewAggregator(time.Hour, 2),
+		reportKey:     reportKey,
+		reportLimiter: ratelimit.New(60, 10),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, reporterKey, _ := ed25519.GenerateKey(nil)
+		sealed, err := reachability.Seal(&reachability.Report{
+			Region:       "KZ",
+			ObservedAt:   time.Now().UnixMilli(),
+			Observations: []reachability.Observation{{Kind: reachability.KindFront, Target: "cdn.example.com"}},
+		}, reportKey.PublicKey(), reporterKey)
+		if err != nil {
+			t.Fatalf("Seal failed: %v", err)
+		}
+		body, _ := json.Marshal(sealed)
+		rr := httptest.NewRecorder()
+		srv.handleReachabilityReport(rr, httptest.NewRequest(http.MethodPost, "/api/reachability/report", bytes.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Report rejected: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	srv.handleBridges(rr, httptest.NewRequest(http.MethodGet, "/api/bridges?region=KZ", nil))
+	var resp struct {
+		Bridges reachability.SignedHintList `json:"bridges"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	list, err := reachability.VerifyHints(signer.PublicKey(), &resp.Bridges)
+	if err != nil {
+		t.Fatalf("VerifyHints failed: %v", err)
+	}
+	if len(list.Hints) != 1 || list.Hints[0].Status != reachability.StatusBlocked || list.ReportKey == "" {
+		t.Errorf("Unexpected hints: %+v", list)
+	}
+}
+
+func TestWebSocketConnectTickets(t *testing.T) {
+	srv := &Server{config: &config.Config{}, signalingSecret: []byte("secret"), tickets: newTicketStore()}
+
+	mint := func(token string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/ws/ticket", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.handleWSTicket(rec, req)
+		var resp struct {
+			Ticket string `json:"ticket"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp.Ticket
+	}
+
+	if code, _ := mint(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", code)
+	}
+	code, ticket := mint(signaling.IssueToken(srv.signalingSecret, "u1", time.Minute))
+	if code != http.StatusOK || ticket == "" {
+		t.Fatalf("Expected ticket, got %d", code)
+	}
+
+	// Без токена и билета журнал не отдается ни одним способом доставки
+	for _, mode := range []string{"poll", "stream", "ws"} {
+		rec := httptest.NewRecorder()
+		srv.handleUserEvents(rec, httptest.NewRequest(http.MethodGet, "/api/users/u1/events", nil), "u1", mode)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for unauthenticated %s, got %d", mode, rec.Code)
+		}
+	}
+
+	// Билет другого пользователя не открывает чужой журнал и при этом погашается
+	rec := httptest.NewRecorder()
+	srv.handleUserEvents(rec, httptest.NewRequest(http.MethodGet, "/api/users/u2/events/ws?ticket="+ticket, nil), "u2", "ws")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's ticket, got %d", rec.Code)
+	}
+	if _, ok := srv.tickets.consume(ticket); ok {
+		t.Error("Ticket must be single-use")
+	}
+
+	// Погашенный билет не открывает и поток событий
+	rec = httptest.NewRecorder()
+	srv.handleUserEvents(rec, httptest.NewRequest(http.MethodGet, "/api/users/u1/events/stream?ticket="+ticket, nil), "u1", "stream")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a used ticket, got %d", rec.Code)
+	}
+
+	_, ticket = mint(signaling.IssueToken(srv.signalingSecret, "u1", time.Minute))
+	if user, ok := srv.tickets.consume(ticket); !ok || user != "u1" {
+		t.Errorf("Expected ticket of u1, got %q %v", user, ok)
+	}
+
+	expired, _ := srv.tickets.mint("u1", -time.Second)
+	if _, ok := srv.tickets.consume(expired); ok {
+		t.Error("Expired ticket must be rejected")
+	}
+}
+
+func TestPeersAPIWithoutDiscovery(t *testing.T) {
+	srv := &Server{config: &config.Config{}}
+
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+	}{
+		{http.MethodGet, "/api/peers", srv.handlePeers},
+		{http.MethodDelete, "/api/peers/10.0.0.1:8080", srv.handlePeer},
+	} {
+		rec := httptest.NewRecorder()
+		tc.handler(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404 with discovery disabled, got %d", tc.method, tc.path, rec.Code)
+		}
+	}
+}
+
+// Изменять постоянные пиры mesh, подключать узлы и менять ограничения ресурсов может
+// только администратор
+func TestPeersAPIRequiresAdmin(t *testing.T) {
+	srv := &Server{config: &config.Config{AdminToken: "admin-token"}, peerManager: &discovery.AutoPeerManager{}}
+
+	for _, tc := range []struct {
+		method, path, body string
+		handler            http.HandlerFunc
+	}{
+		{http.MethodPost, "/api/peers", `{"addr": "10.0.0.1:8080"}`, srv.handlePeers},
+		{http.MethodDelete, "/api/peers/10.0.0.1:8080", "", srv.handlePeer},
+		{http.MethodPost, "/api/mesh/connect", `{"node_id": "node-1"}`, srv.handleMeshConnect},
+		{http.MethodPut, "/api/mesh/resources", `{"battery_saver": false}`, srv.handleMeshResources},
+	} {
+		for _, token := range []string{"", "wrong"} {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			tc.handler(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s with token %q: expected 403, got %d", tc.method, tc.path, token, rec.Code)
+			}
+		}
+	}
+}
+
+func TestClientErrorReports(t *testing.T) {
+	text := "send to alice@example.com via https://front.example/api?token=abc failed: dial 192.168.1.5:443, phone +7 (999) 123-45-67, Bearer eyJhbGciOiJIUzI1NiJ9"
+	clean := scrubClientText(text, 1000)
+	for _, leaked := range []string{"alice@example.com", "token=abc", "192.168.1.5", "123-45-67", "eyJhbGci"} {
+		if strings.Contains(clean, leaked) {
+			t.Errorf("scrubbed text still contains %q: %s", leaked, clean)
+		}
+	}
+	if !strings.Contains(clean, "https://front.example/api") {
+		t.Errorf("scrubbing removed non-personal data: %s", clean)
+	}
+	if ctx := scrubClientContext(map[string]string{"user_id": "u1", "front": "cdn.example"}); strings.Contains(ctx, "u1") || !strings.Contains(ctx, "cdn.example") {
+		t.Errorf("unexpected context: %s", ctx)
+	}
+
+	srv := &Server{config: &config.Config{ClientErrorSamplePercent: 0}, errorLimiter: ratelimit.New(2, 2)}
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		srv.handleClientErrors(rec, httptest.NewRequest(http.MethodPost, "/api/client-errors", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := post(`{"kind": "error", "message": "boom"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without consent, got %d", code)
+	}
+	// Выборка 0%: отчет принят, но не сохраняется (БД не нужна)
+	if code := post(`{"consent": true, "kind": "error", "message": "boom"}`); code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a sampled-out report, got %d", code)
+	}
+	if code := post(`{"consent": true, "kind": "error", "message": "boom"}`); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 over the rate limit, got %d", code)
+	}
+}
+
+func TestPeerEventsUpdatePresence(t *testing.T) {
+	srv := &Server{
+		config:   &config.Config{},
+		contacts: map[string]Contact{"alice": {ID: "alice", Status: "offline", NodeID: "node-a"}, "bob": {ID: "bob", Status: "offline"}},
+		peerFeed: newPeerFeed(),
+	}
+	updates := srv.peerFeed.subscribe()
+
+	srv.handlePeerEvent(discovery.PeerEvent{Type: discovery.PeerJoined, Peer: discovery.PeerInfo{NodeID: "node-a"}})
+	if srv.contacts["alice"].Status != "online" || srv.contacts["bob"].Status != "offline" {
+		t.Fatalf("unexpected presence: %+v", srv.contacts)
+	}
+	if update := <-updates; update.Type != discovery.PeerJoined || len(update.Contacts) != 1 || update.Contacts[0] != "alice" {
+		t.Errorf("unexpected update: %+v", update)
+	}
+
+	srv.handlePeerEvent(discovery.PeerEvent{Type: discovery.PeerLeft, Peer: discovery.PeerInfo{NodeID: "node-a"}})
+	if srv.contacts["alice"].Status != "offline" {
+		t.Errorf("expected alice offline after peer left, got %s", srv.contacts["alice"].Status)
+	}
+}
+
+func TestQuotaEvictionPlan(t *testing.T) {
+	q := &storage.Quota{GroupID: "g", MaxMessages: 100, MaxMediaBytes: 1000, Eviction: storage.QuotaEvictOldest}
+	recordings := []*storage.Recording{{ID: "old", SizeBytes: 300}, {ID: "mid", SizeBytes: 300}, {ID: "new", SizeBytes: 600}}
+
+	evict, keep := planEviction(q, &storage.QuotaUsage{Messages: 150, MediaBytes: 1200}, recordings)
+	if len(evict) != 1 || evict[0].ID != "old" || keep != 100 {
+		t.Errorf("unexpected plan: evict %d recordings, keep %d messages", len(evict), keep)
+	}
+	if evict, keep := planEviction(q, &storage.QuotaUsage{Messages: 50, MediaBytes: 900}, recordings); len(evict) != 0 || keep >= 0 {
+		t.Errorf("nothing should be evicted under quota, got %d recordings, keep %d", len(evict), keep)
+	}
+	q.Eviction = storage.QuotaReject
+	if evict, keep := planEviction(q, &storage.QuotaUsage{Messages: 150, MediaBytes: 1200}, recordings); len(evict) != 0 || keep >= 0 {
+		t.Error("reject policy must not evict")
+	}
+
+	if fill := quotaFill(q, &storage.QuotaUsage{Messages: 50, MediaBytes: 900}); fill != 90 {
+		t.Errorf("expected 90%% fill, got %d", fill)
+	}
+	if validQuota(&storage.Quota{Eviction: "drop"}) == "" || validQuota(&storage.Quota{MaxMessages: -1, Eviction: storage.QuotaReject}) == "" {
+		t.Error("invalid quota accepted")
+	}
+}
+
+func TestQuotaWarningThrottled(t *testing.T) {
+	srv := &Server{config: &config.Config{QuotaWarnPercent: 80}}
+	q := &storage.Quota{GroupID: "g", MaxMessages: 100, Admins: []string{"alice"}}
+
+	srv.warnQuota(q, &storage.QuotaUsage{Messages: 50})
+	if _, warned := srv.quotaWarned["g"]; warned {
+		t.Fatal("warned below the threshold")
+	}
+	srv.warnQuota(q, &storage.QuotaUsage{Messages: 85})
+	first, warned := srv.quotaWarned["g"]
+	if !warned {
+		t.Fatal("expected a warning at 85%")
+	}
+	srv.warnQuota(q, &storage.QuotaUsage{Messages: 95})
+	if !srv.quotaWarned["g"].Equal(first) {
+		t.Error("warning repeated within the interval")
+	}
+}
+
+func TestIdentifierChangeRequests(t *testing.T) {
+	for path, want := range map[string]string{"u1/identifier": "", "u1/identifier/confirm": "confirm"} {
+		if userID, action, ok := splitIdentifierPath(path); !ok || userID != "u1" || action != want {
+			t.Errorf("%s: got %q %q %v", path, userID, action, ok)
+		}
+	}
+	for _, path := range []string{"u1", "u1/lookup", "u1/identifier/other", "u1/identifier/confirm/x"} {
+		if _, _, ok := splitIdentifierPath(path); ok {
+			t.Errorf("%s parsed as identifier path", path)
+		}
+	}
+
+	if validIdentifier(storage.IdentifierEmail, "alice@example.com") != "" || validIdentifier(storage.IdentifierPhone, "+79990000000") != "" {
+		t.Error("valid identifier rejected")
+	}
+	if validIdentifier(storage.IdentifierEmail, "alice") == "" || validIdentifier(storage.IdentifierPhone, "a@b") == "" ||
+		validIdentifier("username", "alice") == "" {
+		t.Error("invalid identifier accepted")
+	}
+
+	code, err := verificationCode()
+	if err != nil || len(code) != 6 {
+		t.Errorf("unexpected code %q: %v", code, err)
+	}
+
+	// Незавершенную смену видит и отменяет только сам пользователь
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+	alice, _ := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	call := func(method, caller, body string) int {
+		req := httptest.NewRequest(method, "/api/v1/users/"+alice.ID+"/identifier", strings.NewReader(body))
+		if caller != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, caller, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, caller := range []string{"", "mallory"} {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			if got := call(method, caller, `{"kind": "email", "value": "mallory@example.com"}`); got != http.StatusUnauthorized {
+				t.Errorf("%s by %q: %d", method, caller, got)
+			}
+		}
+	}
+	if got := call(http.MethodGet, alice.ID, ""); got != http.StatusOK {
+		t.Errorf("owner reading identifier change: %d", got)
+	}
+	if got := call(http.MethodDelete, alice.ID, ""); got != http.StatusOK {
+		t.Errorf("owner cancelling identifier change: %d", got)
+	}
+}
+
+func TestGuardiansRequireOwner(t *testing.T) {
+	srv := &Server{config: &config.Config{}, signalingSecret: []byte("secret")}
+	for _, token := range []string{"", signaling.IssueToken(srv.signalingSecret, "mallory", time.Minute)} {
+		req := httptest.NewRequest(http.MethodPut, "/api/users/alice/guardians", strings.NewReader(`{"guardians": ["mallory"], "threshold": 1}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.handleUser(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+}
+
+// TestFoldersRequireOwner проверяет разбор пути папок и то, что чужие папки недоступны
+func TestFoldersRequireOwner(t *testing.T) {
+	if userID, folderID, ok := splitFoldersPath("alice/folders/folder-1"); !ok || userID != "alice" || folderID != "folder-1" {
+		t.Errorf("unexpected split: %q %q %v", userID, folderID, ok)
+	}
+	if _, _, ok := splitFoldersPath("alice/folders/folder-1/extra"); ok {
+		t.Error("path with extra segment must not be a folders path")
+	}
+
+	srv := &Server{config: &config.Config{}, signalingSecret: []byte("secret")}
+	for _, token := range []string{"", signaling.IssueToken(srv.signalingSecret, "mallory", time.Minute)} {
+		req := httptest.NewRequest(http.MethodPost, "/api/users/alice/folders", strings.NewReader(`{"name": "Каналы", "rules": [{"keyword": "релиз"}]}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.handleUser(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+}
+
+// TestComplianceExportRequiresLogin проверяет, что выгрузка и журнал аудита недоступны без
+// личного токена, а роли выдаются только администратором
+func TestComplianceExportRequiresLogin(t *testing.T) {
+	srv := &Server{config: &config.Config{AdminToken: "admin-token"}, signalingSecret: []byte("secret")}
+
+	for _, c := range []struct {
+		handler http.HandlerFunc
+		method  string
+		path    string
+		token   string
+		want    int
+	}{
+		{srv.handleComplianceExport, http.MethodPost, "/api/compliance/export", "", http.StatusUnauthorized},
+		// Общий токен администратора не заменяет личную роль: выгрузка должна быть атрибутирована
+		{srv.handleComplianceExport, http.MethodPost, "/api/compliance/export", "admin-token", http.StatusUnauthorized},
+		{srv.handleAdminAudit, http.MethodGet, "/api/admin/audit", "forged", http.StatusUnauthorized},
+		{srv.handleAdminRoles, http.MethodPost, "/api/admin/roles", signaling.IssueToken(srv.signalingSecret, "mallory", time.Minute), http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(`{"user_ids": ["alice"], "reason": "case", "user_id": "mallory", "role": "auditor"}`))
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		c.handler(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s with token %q: expected %d, got %d", c.method, c.path, c.token, c.want, rec.Code)
+		}
+	}
+}
+
+// TestLiteModeEvents проверяет облегченную сессию: подтверждение заголовком, без квитанций
+// и превью, но с номером последнего события, включая отброшенные
+func TestLiteModeEvents(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+
+	srv.appendEvent("u1", storage.EventMessageCreated, map[string]string{"id": "m1", "body": "hi", "preview": "https://example.com/big.jpg"})
+	srv.appendEvent("u1", storage.EventReceipt, map[string]string{"message_id": "m1", "status": "read"})
+
+	srv.signalingSecret = []byte("secret")
+	token := signaling.IssueToken(srv.signalingSecret, "u1", time.Minute)
+	poll := func(query string) (*httptest.ResponseRecorder, []*storage.Event, int64) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/users/u1/events"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		srv.handleUser(rec, req)
+		var resp struct {
+			Events  []*storage.Event `json:"events"`
+			LastSeq int64            `json:"last_seq"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec, resp.Events, resp.LastSeq
+	}
+
+	// Журнал читает только владелец
+	for _, c := range []struct {
+		token string
+		want  int
+	}{{"", http.StatusUnauthorized}, {signaling.IssueToken(srv.signalingSecret, "u2", time.Minute), http.StatusForbidden}} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/users/u1/events", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		srv.handleUser(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("Events with token %q: expected %d, got %d", c.token, c.want, rec.Code)
+		}
+	}
+
+	rec, events, _ := poll("")
+	if len(events) != 2 || rec.Header().Get(liteHeader) != "" {
+		t.Fatalf("Full session: %d events, lite header %q", len(events), rec.Header().Get(liteHeader))
+	}
+
+	rec, events, lastSeq := poll("?lite=1")
+	if rec.Header().Get(liteHeader) != "1" {
+		t.Error("Lite mode was not confirmed")
+	}
+	if len(events) != 1 || events[0].Type != storage.EventMessageCreated || lastSeq != 2 {
+		t.Fatalf("Lite session: %d events, last_seq %d", len(events), lastSeq)
+	}
+	var payload map[string]interface{}
+	json.Unmarshal(events[0].Payload, &payload)
+	if _, ok := payload["preview"]; ok || payload["media_omitted"] != true || payload["body"] != "hi" {
+		t.Errorf("Lite payload: %v", payload)
+	}
+}
+
+// TestGroupReceiptsBatched проверяет, что квитанции участников группы не доходят до автора
+// по одной, а приходят одним пакетом сводок
+func TestGroupReceiptsBatched(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+
+	receipt := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/receipts", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.handleReceipt(rec, req)
+		return rec
+	}
+	for _, reader := range []string{"u1", "u2", "u3"} {
+		body := `{"message_id": "m1", "sender_id": "author", "status": "read", "group_id": "g1"}`
+		if rec := receipt(signaling.IssueToken(srv.signalingSecret, reader, time.Minute), body); rec.Code != http.StatusOK {
+			t.Fatalf("Receipt from %s: %d %s", reader, rec.Code, rec.Body.String())
+		}
+	}
+	// Квитанцию нельзя отправить без токена или от имени другого пользователя
+	forged := `{"user_id": "u4", "message_id": "m1", "sender_id": "author", "status": "read", "group_id": "g1"}`
+	if rec := receipt("bad", forged); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Receipt without token: %d", rec.Code)
+	}
+	if rec := receipt(signaling.IssueToken(srv.signalingSecret, "u1", time.Minute), forged); rec.Code != http.StatusForbidden {
+		t.Errorf("Receipt for another user: %d", rec.Code)
+	}
+	if seq, _ := srv.db.LastEventSeq(t.Context(), "author"); seq != 0 {
+		t.Fatalf("Author got %d events before the batch", seq)
+	}
+
+	srv.flushReceipts()
+	events, err := srv.db.ListEvents(t.Context(), "author", 0, 10)
+	if err != nil || len(events) != 1 || events[0].Type != storage.EventReceiptBatch {
+		t.Fatalf("Expected one receipt batch, got %+v (%v)", events, err)
+	}
+	var batch struct {
+		Summaries []struct {
+			Read    int      `json:"read"`
+			Readers []string `json:"readers"`
+		} `json:"summaries"`
+	}
+	json.Unmarshal(events[0].Payload, &batch)
+	if len(batch.Summaries) != 1 || batch.Summaries[0].Read != 3 || len(batch.Summaries[0].Readers) != 3 {
+		t.Errorf("Unexpected batch: %+v", batch)
+	}
+}
+
+func TestMessageDeliveryReceipts(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+
+	srv.signalingSecret = []byte("secret")
+
+	messageID := srv.recordMessageCreated("alice", "bob", "привет")
+	handler := srv.Handler()
+	receiptAs := func(user, method, body string, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/messages/"+messageID+"/receipt"+query, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	receipt := func(method, body string, query string) *httptest.ResponseRecorder {
+		return receiptAs("bob", method, body, query)
+	}
+	lastEvent := func() string {
+		events, _ := srv.db.ListEvents(t.Context(), "alice", 0, 100)
+		return events[len(events)-1].Type
+	}
+
+	if rec := receipt(http.MethodPost, `{"user_id": "bob", "status": "delivered"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("delivered: %d %s", rec.Code, rec.Body.String())
+	}
+	if lastEvent() != storage.EventReceipt {
+		t.Error("sender got no receipt event")
+	}
+	seq, _ := srv.db.LastEventSeq(t.Context(), "alice")
+	receipt(http.MethodPost, `{"user_id": "bob", "status": "read"}`, "")
+	receipt(http.MethodPost, `{"user_id": "bob", "status": "delivered"}`, "")
+	if after, _ := srv.db.LastEventSeq(t.Context(), "alice"); after != seq+1 {
+		t.Errorf("expected one event for read only, got %d", after-seq)
+	}
+	if rec := receiptAs("mallory", http.MethodPost, `{"status": "read"}`, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("receipt from a non-recipient: %d", rec.Code)
+	}
+	// Получатель определяется токеном, а не user_id из запроса
+	if rec := receiptAs("", http.MethodPost, `{"user_id": "bob", "status": "read"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("receipt without token: %d", rec.Code)
+	}
+	if rec := receiptAs("mallory", http.MethodPost, `{"user_id": "bob", "status": "read"}`, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("receipt forged for another user: %d", rec.Code)
+	}
+
+	rec := receiptAs("alice", http.MethodGet, "", "")
+	var resp struct {
+		Receipts []*storage.MessageReceipt `json:"receipts"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Receipts) != 1 || resp.Receipts[0].Status != storage.ReceiptRead {
+		t.Fatalf("receipts: %d %+v", rec.Code, resp.Receipts)
+	}
+	if rec := receiptAs("mallory", http.MethodGet, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("receipts for a stranger: %d", rec.Code)
+	}
+	if rec := receiptAs("mallory", http.MethodGet, "", "?user_id=alice"); rec.Code != http.StatusForbidden {
+		t.Errorf("receipts read as another user: %d", rec.Code)
+	}
+}
+
+// TestUserKeys проверяет публикацию ключей шифрования владельцем и выдачу
+// одноразового ключа собеседнику только один раз
+func TestUserKeys(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+
+	call := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		srv.handleUser(rec, req)
+		return rec
+	}
+
+	upload := `{"identity_key": "aWQ=", "signed_prekey": {"key_id": 1, "public_key": "c3Br", "signature": "c2ln"},
+		"one_time_prekeys": [{"key_id": 7, "public_key": "b3Rr"}]}`
+	if rec := call(http.MethodPut, "/api/users/bob/keys/phone", "alice", upload); rec.Code != http.StatusForbidden {
+		t.Errorf("keys published by a stranger: %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/api/users/bob/keys/phone", "bob", `{"identity_key": "not base64"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid keys: %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/api/users/bob/keys/phone", "bob", upload); rec.Code != http.StatusOK {
+		t.Fatalf("publish keys: %d %s", rec.Code, rec.Body.String())
+	}
+
+	fetch := func() *storage.KeyBundle {
+		rec := call(http.MethodGet, "/api/users/bob/keys", "alice", "")
+		var resp struct {
+			Bundles []*storage.KeyBundle `json:"bundles"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK || len(resp.Bundles) != 1 {
+			t.Fatalf("fetch bundles: %d %s", rec.Code, rec.Body.String())
+		}
+		return resp.Bundles[0]
+	}
+	if b := fetch(); b.IdentityKey != "aWQ=" || b.OneTimePreKey == nil || b.OneTimePreKey.KeyID != 7 {
+		t.Errorf("first bundle: %+v", b)
+	}
+	if b := fetch(); b.OneTimePreKey != nil {
+		t.Error("one-time prekey handed out twice")
+	}
+}
+
+// TestInviteManagement проверяет многоразовое приглашение: счетчик использований,
+// список приглашенных, доступ только автору и отзыв
+func TestInviteManagement(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.InviteMaxTTL = 720 * time.Hour
+
+	call := func(handler http.HandlerFunc, method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := call(srv.handleInvites, http.MethodPost, "/api/invites", "alice", `{"max_uses": 2, "expires_in": "1000h"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expiry beyond INVITE_MAX_TTL: %d", rec.Code)
+	}
+	rec := call(srv.handleInvites, http.MethodPost, "/api/invites", "alice", `{"max_uses": 2, "expires_in": "48h"}`)
+	var created struct {
+		Invite *storage.Invite `json:"invite"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	if rec.Code != http.StatusOK || created.Invite == nil || created.Invite.CreatedBy != "alice" {
+		t.Fatalf("create invite: %d %+v", rec.Code, created.Invite)
+	}
+	token := created.Invite.Token
+
+	// Ссылка строится от PUBLIC_URL, приглашение с телефоном уходит по SMS
+	srv.config.PublicURL = "https://hydra.example.com/"
+	rec = call(srv.handleInvites, http.MethodPost, "/api/invites", "alice", `{"phone": "+15551234567"}`)
+	var sent struct {
+		InviteLink string          `json:"invite_link"`
+		Delivery   *inviteDelivery `json:"delivery"`
+	}
+	json.NewDecoder(rec.Body).Decode(&sent)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(sent.InviteLink, "https://hydra.example.com/register.html?token=") {
+		t.Fatalf("phone invite: %d %+v", rec.Code, sent)
+	}
+	if sent.Delivery == nil || sent.Delivery.Channel != "sms" || sent.Delivery.Status != inviteSent {
+		t.Errorf("phone invite delivery: %+v", sent.Delivery)
+	}
+	rec = call(srv.handleInvites, http.MethodPost, "/api/invites", "alice", `{"phone": "+15551234568", "send": false}`)
+	sent.Delivery = nil
+	json.NewDecoder(rec.Body).Decode(&sent)
+	if sent.Delivery == nil || sent.Delivery.Status != inviteNotSent {
+		t.Errorf("invite with send: false delivered: %+v", sent.Delivery)
+	}
+
+	// Контакт многоразового приглашения подтверждается кодом: без подтверждения или с
+	// подтверждением другого адреса регистрация не проходит и не тратит приглашение
+	for _, proof := range []string{"", srv.issueProof(proofEmail, "mallory@example.com")} {
+		body := fmt.Sprintf(`{"token": %q, "name": "user", "password": "correct-horse-42", "contact": "bob@example.com", "proof": %q}`, token, proof)
+		rec := httptest.NewRecorder()
+		srv.handleRegister(rec, httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body)))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("registration with unverified contact: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	for i, contact := range []string{"bob@example.com", "carol@example.com", "dave@example.com"} {
+		body := fmt.Sprintf(`{"token": %q, "name": "user", "password": "correct-horse-42", "contact": %q, "proof": %q}`,
+			token, contact, srv.issueProof(proofEmail, contact))
+		rec := httptest.NewRecorder()
+		srv.handleRegister(rec, httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body)))
+		if want := i < 2; (rec.Code == http.StatusOK) != want {
+			t.Errorf("registration %d: %d %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = call(srv.handleInviteItem, http.MethodGet, "/api/invites/"+token, "alice", "")
+	var details struct {
+		Invite *storage.Invite      `json:"invite"`
+		Uses   []*storage.InviteUse `json:"uses"`
+	}
+	json.NewDecoder(rec.Body).Decode(&details)
+	if rec.Code != http.StatusOK || details.Invite.Uses != 2 || len(details.Uses) != 2 {
+		t.Fatalf("invite details: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(srv.handleInviteItem, http.MethodGet, "/api/invites/"+token, "mallory", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("invite visible to a stranger: %d", rec.Code)
+	}
+	if rec := call(srv.handleInviteItem, http.MethodDelete, "/api/invites/"+token, "alice", ""); rec.Code != http.StatusOK {
+		t.Errorf("revoke: %d", rec.Code)
+	}
+	if inv, _ := srv.db.GetInvite(t.Context(), token); inv == nil || inv.RevokedAt.IsZero() {
+		t.Error("invite not revoked")
+	}
+}
+
+// TestConversationRetention проверяет выбор срока хранения беседы и удаление
+// сообщений старше него
+func TestConversationRetention(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+
+	call := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		srv.handleConversation(rec, req)
+		return rec
+	}
+
+	srv.db.CreateMessage(t.Context(), &storage.Message{ID: "old", ConversationID: "c1", SenderID: "alice", Body: "old", CreatedAt: time.Now().Add(-8 * 24 * time.Hour)})
+	srv.db.CreateMessage(t.Context(), &storage.Message{ID: "new", ConversationID: "c1", SenderID: "alice", Body: "new"})
+	srv.db.CreateMessage(t.Context(), &storage.Message{ID: "other", ConversationID: "c2", SenderID: "alice", Body: "other", CreatedAt: time.Now().Add(-8 * 24 * time.Hour)})
+
+	// Не участник беседы не видит ни ее историю, ни срок хранения
+	if rec := call("mallory", http.MethodGet, "/api/conversations/c1/messages", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("non-member read history: %d", rec.Code)
+	}
+	if rec := call("mallory", http.MethodPut, "/api/conversations/c2/retention", `{"keep": "7d"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("non-member set retention: %d", rec.Code)
+	}
+
+	if rec := call("alice", http.MethodPut, "/api/conversations/c1/retention", `{"keep": "1y"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid keep: %d", rec.Code)
+	}
+	if rec := call("alice", http.MethodPut, "/api/conversations/c1/retention", `{"keep": "7d"}`); rec.Code != http.StatusOK {
+		t.Fatalf("set retention: %d %s", rec.Code, rec.Body.String())
+	}
+	rec := call("alice", http.MethodGet, "/api/conversations/c1/retention", "")
+	if !strings.Contains(rec.Body.String(), `"keep":"7d"`) {
+		t.Errorf("retention: %s", rec.Body.String())
+	}
+
+	if rec := call("alice", http.MethodGet, "/api/conversations/c1/messages", ""); strings.Contains(rec.Body.String(), `"old"`) {
+		t.Errorf("expired message listed: %s", rec.Body.String())
+	}
+	srv.purgeExpired(time.Now())
+	if msgs, _ := srv.db.ListMessages(t.Context(), "c1", 10); len(msgs) != 1 || msgs[0].ID != "new" {
+		t.Errorf("expected only the new message left, got %d", len(msgs))
+	}
+	if msgs, _ := srv.db.ListMessages(t.Context(), "c2", 10); len(msgs) != 1 {
+		t.Error("conversation without a policy was purged")
+	}
+}
+
+// TestArchiveAndRehydrate проверяет, что история неактивной беседы уходит в холодное
+// хранилище и возвращается при листании назад, в том числе на стыке с новыми сообщениями
+func TestArchiveAndRehydrate(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+
+	backend, err := archive.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.archives = backend
+	srv.archiveCache = archive.NewCache(backend, 4)
+	srv.config.ArchiveBatchSize = 2
+	srv.signalingSecret = []byte("secret")
+
+	start := time.Now().Add(-365 * 24 * time.Hour)
+	for i := 0; i < 5; i++ {
+		msg := &storage.Message{ID: fmt.Sprintf("m%d", i), ConversationID: "c1", SenderID: "alice", Body: fmt.Sprint(i), CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := srv.db.CreateMessage(t.Context(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.archiveInactive(time.Now().Add(-24 * time.Hour))
+
+	if archives, _ := srv.db.ListMessageArchives(t.Context(), "c1"); len(archives) != 3 {
+		t.Fatalf("expected 3 archives, got %d", len(archives))
+	}
+	if hot, _ := srv.db.ListMessages(t.Context(), "c1", 10); len(hot) != 0 {
+		t.Fatalf("%d messages left in the database", len(hot))
+	}
+	srv.db.CreateMessage(t.Context(), &storage.Message{ID: "m5", ConversationID: "c1", SenderID: "bob", Body: "5"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/conversations/c1/messages", nil)
+	req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, "mallory", time.Minute))
+	rec := httptest.NewRecorder()
+	srv.handleConversation(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("non-member read archived history: %d", rec.Code)
+	}
+
+	page := func(before string) ([]*storage.Message, int) {
+		url := "/api/conversations/c1/messages?limit=3"
+		if before != "" {
+			url += "&before=" + before
+		}
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, "alice", time.Minute))
+		rec := httptest.NewRecorder()
+		srv.handleConversation(rec, req)
+		var resp struct {
+			Messages   []*storage.Message `json:"messages"`
+			Rehydrated int                `json:"rehydrated"`
+		}
+		if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil {
+			t.Fatalf("history request failed: %d", rec.Code)
+		}
+		return resp.Messages, resp.Rehydrated
+	}
+
+	var ids []string
+	before := ""
+	for {
+		messages, _ := page(before)
+		if len(messages) == 0 {
+			break
+		}
+		for i := len(messages) - 1; i >= 0; i-- {
+			ids = append(ids, messages[i].ID)
+		}
+		before = messages[0].CreatedAt.Format(time.RFC3339Nano)
+	}
+	if strings.Join(ids, ",") != "m5,m4,m3,m2,m1,m0" {
+		t.Errorf("unexpected history order: %v", ids)
+	}
+
+	if _, rehydrated := page(""); rehydrated != 2 {
+		t.Errorf("expected 2 rehydrated messages on the first page, got %d", rehydrated)
+	}
+}
+
+func TestChunkedFileUpload(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+
+	blobs, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.blobs = blobs
+	srv.config.FileMaxBytes = 1 << 20
+	srv.signalingSecret = []byte("secret")
+	token := signaling.IssueToken(srv.signalingSecret, "alice", time.Minute)
+
+	content := []byte("hello, attachments")
+	sum := sha256.Sum256(content)
+	call := func(handler http.HandlerFunc, method, url string, body []byte, header map[string]string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	start, _ := json.Marshal(map[string]interface{}{"name": "note.txt", "mime_type": "text/plain", "size": len(content), "sha256": hex.EncodeToString(sum[:])})
+	code, resp := call(srv.handleFileUpload, http.MethodPost, "/api/files/upload", start, nil)
+	if code != http.StatusOK {
+		t.Fatalf("upload not started: %d %v", code, resp)
+	}
+	url := resp["upload_url"].(string)
+
+	code, _ = call(srv.handleFile, http.MethodPatch, url, content[:5], map[string]string{"Content-Range": fmt.Sprintf("bytes 0-4/%d", len(content))})
+	if code != http.StatusOK {
+		t.Fatalf("first part rejected: %d", code)
+	}
+	// Часть не с принятого смещения отклоняется с подсказкой, откуда продолжить
+	code, resp = call(srv.handleFile, http.MethodPatch, url, content[3:], map[string]string{"Content-Range": fmt.Sprintf("bytes 3-%d/%d", len(content)-1, len(content))})
+	if code != http.StatusConflict || resp["received"] != float64(5) {
+		t.Fatalf("expected conflict at offset 5, got %d %v", code, resp)
+	}
+	code, resp = call(srv.handleFile, http.MethodPatch, url, content[5:], map[string]string{"Content-Range": fmt.Sprintf("bytes 5-%d/%d", len(content)-1, len(content))})
+	if code != http.StatusOK || resp["complete"] != true {
+		t.Fatalf("upload not completed: %d %v", code, resp)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.handleFile(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(content) || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected download: %d %q", rec.Code, rec.Body.String())
+	}
+
+	// Сообщение может ссылаться только на завершенное вложение отправителя
+	id := strings.TrimPrefix(url, "/api/files/")
+	send := func(from string) int {
+		body, _ := json.Marshal(map[string]interface{}{"from": from, "to": "bob", "attachments": []string{id}})
+		req := httptest.NewRequest(http.MethodPost, "/api/send", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, from, time.Minute))
+		rec := httptest.NewRecorder()
+		srv.handleSend(rec, req)
+		return rec.Code
+	}
+	if code := send("mallory"); code != http.StatusBadRequest {
+		t.Errorf("foreign attachment accepted: %d", code)
+	}
+	if code := send("alice"); code != http.StatusOK {
+		t.Errorf("message with attachment rejected: %d", code)
+	}
+
+	// Несовпадение хеша сбрасывает загрузку
+	start, _ = json.Marshal(map[string]interface{}{"name": "bad.bin", "size": 3, "sha256": hex.EncodeToString(sum[:])})
+	_, resp = call(srv.handleFileUpload, http.MethodPost, "/api/files/upload", start, nil)
+	url = resp["upload_url"].(string)
+	code, resp = call(srv.handleFile, http.MethodPatch, url, []byte("abc"), map[string]string{"Content-Range": "bytes 0-2/3"})
+	if code != http.StatusUnprocessableEntity || resp["received"] != float64(0) {
+		t.Fatalf("checksum mismatch not detected: %d %v", code, resp)
+	}
+}
+
+// TestSendThroughRelay отправляет сообщение через настоящий клиент fronting до
+// ретранслятора в процессе теста и возвращает клиенту ID доставки
+func TestSendThroughRelay(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	srv := New(&config.Config{ReceiptDetail: "sampled"}, relay.Manager(), storage.NewMemory())
+	body, _ := json.Marshal(map[string]string{"message": "через фронт", "to": "bob"})
+	rec := httptest.NewRecorder()
+	srv.handleSend(rec, httptest.NewRequest(http.MethodPost, "/api/send", bytes.NewReader(body)))
+
+	var resp struct {
+		Transport string `json:"transport"`
+		Reply     struct {
+			DeliveryID string `json:"delivery_id"`
+		} `json:"reply"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil {
+		t.Fatalf("send failed: %d", rec.Code)
+	}
+	if resp.Transport != "domain-fronting" || resp.Reply.DeliveryID != "1" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if messages, _ := relay.After(""); len(messages) != 1 || string(messages[0].Data) != "через фронт" {
+		t.Errorf("relay queue: %+v", messages)
+	}
+}
+
+// TestInboundPipeline проверяет прием сообщения транспортом: подпись, входящие
+// получателя, событие в журнале, отбрасывание повторов и подтверждение
+func TestInboundPipeline(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.InboundSecret = "inbound"
+	srv.config.InboundMaxBytes = 1024
+	bob, err := srv.db.CreateUser(t.Context(), "Bob", "secret", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func(body, secret string) *httptest.ResponseRecorder {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest(http.MethodPost, "/api/messages/incoming", strings.NewReader(body))
+		req.Header.Set(inboundSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		srv.handleIncoming(rec, req)
+		return rec
+	}
+
+	msg := fmt.Sprintf(`{"id": "m1", "from": "alice@remote", "to": %q, "body": "привет", "sent_at": 1700000000000}`, bob.ID)
+	if rec := deliver(msg, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: %d", rec.Code)
+	}
+	if rec := deliver(`{"id": "m2", "from": "alice@remote", "to": "nobody", "body": "x"}`, "inbound"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown recipient: %d", rec.Code)
+	}
+	if rec := deliver(`{"id": "m3"}`, "inbound"); rec.Code != http.StatusBadRequest {
+		t.Errorf("incomplete message: %d", rec.Code)
+	}
+	if rec := deliver(msg, "inbound"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "delivery_id") {
+		t.Fatalf("deliver: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := deliver(msg, "inbound"); !strings.Contains(rec.Body.String(), `"duplicate":true`) {
+		t.Errorf("duplicate delivery: %s", rec.Body.String())
+	}
+
+	// Сообщения из mesh сети проходят тот же путь
+	srv.receiveMesh([]byte(fmt.Sprintf(`{"id": "m4", "from": "carol@mesh", "to": %q, "body": "из mesh"}`, bob.ID)))
+
+	events, _ := srv.db.ListEvents(t.Context(), bob.ID, 0, 10)
+	if len(events) != 2 || events[0].Type != storage.EventMessageCreated {
+		t.Errorf("expected 2 message events, got %+v", events)
+	}
+
+	call := func(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/inbox", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, bob.ID, time.Minute))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	var inbox struct {
+		Messages []*storage.InboxMessage `json:"messages"`
+	}
+	json.NewDecoder(call(srv.handleInbox, http.MethodGet, "").Body).Decode(&inbox)
+	if len(inbox.Messages) != 2 || inbox.Messages[0].Body != "привет" || inbox.Messages[1].Transport != "mesh" {
+		t.Fatalf("inbox: %+v", inbox.Messages)
+	}
+
+	ack := fmt.Sprintf(`{"ids": [%q]}`, inbox.Messages[0].ID)
+	if rec := call(srv.handleInboxAck, http.MethodPost, ack); !strings.Contains(rec.Body.String(), `"acked":1`) {
+		t.Errorf("ack: %d %s", rec.Code, rec.Body.String())
+	}
+	inbox.Messages = nil
+	json.NewDecoder(call(srv.handleInbox, http.MethodGet, "").Body).Decode(&inbox)
+	if len(inbox.Messages) != 1 || inbox.Messages[0].MessageID != "m4" {
+		t.Errorf("inbox after ack: %+v", inbox.Messages)
+	}
+}
+
+// TestGroupMessaging проверяет группу: доступ только участникам, сообщение в журналах
+// участников и через транспорт, события о входе и выходе участников
+func TestGroupMessaging(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	srv := New(&config.Config{ReceiptDetail: "sampled"}, relay.Manager(), storage.NewMemory())
+	srv.signalingSecret = []byte("secret")
+	var users []*storage.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := srv.db.CreateUser(t.Context(), name, "secret", name+"@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	call := func(handler http.HandlerFunc, method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := call(srv.handleGroups, http.MethodPost, "/api/groups", alice.ID, fmt.Sprintf(`{"name": "Команда", "members": [%q]}`, bob.ID))
+	var created struct {
+		Group *storage.Group `json:"group"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	if rec.Code != http.StatusOK || created.Group == nil {
+		t.Fatalf("create group: %d %s", rec.Code, rec.Body.String())
+	}
+	base := "/api/groups/" + created.Group.ID
+
+	if rec := call(srv.handleGroup, http.MethodPost, base+"/messages", carol.ID, `{"body": "можно?"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("stranger posted to the group: %d", rec.Code)
+	}
+	if rec := call(srv.handleGroup, http.MethodPost, base+"/members", bob.ID, fmt.Sprintf(`{"user_id": %q}`, carol.ID)); rec.Code != http.StatusForbidden {
+		t.Errorf("member added a member: %d", rec.Code)
+	}
+	if rec := call(srv.handleGroup, http.MethodPost, base+"/messages", bob.ID, `{"body": "всем привет"}`); rec.Code != http.StatusOK {
+		t.Fatalf("send to group: %d %s", rec.Code, rec.Body.String())
+	}
+
+	events, _ := srv.db.ListEvents(t.Context(), alice.ID, 0, 10)
+	if len(events) != 2 || events[0].Type != storage.EventMembership || events[1].Type != storage.EventMessageCreated {
+		t.Errorf("alice events: %+v", events)
+	}
+	rec = call(srv.handleGroup, http.MethodGet, base+"/messages", alice.ID, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "всем привет") {
+		t.Errorf("group history: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Сообщение уходит через транспорт каждому участнику, кроме автора
+	deadline := time.Now().Add(5 * time.Second)
+	messages, _ := relay.After("")
+	for len(messages) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		messages, _ = relay.After("")
+	}
+	if len(messages) != 1 || !strings.Contains(string(messages[0].Data), alice.ID) {
+		t.Errorf("relay queue: %+v", messages)
+	}
+
+	if rec := call(srv.handleGroup, http.MethodPost, base+"/members", alice.ID, fmt.Sprintf(`{"user_id": %q}`, carol.ID)); rec.Code != http.StatusOK {
+		t.Fatalf("add member: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(srv.handleGroup, http.MethodDelete, base+"/members/"+bob.ID, bob.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("leave group: %d %s", rec.Code, rec.Body.String())
+	}
+	events, _ = srv.db.ListEvents(t.Context(), carol.ID, 0, 10)
+	if len(events) != 2 || !strings.Contains(string(events[1].Payload), `"change":"left"`) {
+		t.Errorf("carol events: %+v", events)
+	}
+	if rec := call(srv.handleGroup, http.MethodGet, base, bob.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("former member sees the group: %d", rec.Code)
+	}
+}
+
+func TestPresenceAndTyping(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+
+	var users []*storage.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := srv.db.CreateUser(t.Context(), name, "secret", name+"@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+	group := &storage.Group{Name: "Команда", CreatedBy: alice.ID}
+	if err := srv.db.CreateGroup(t.Context(), group, []string{bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Эфемерные события, полученные открытыми соединениями
+	var mu sync.Mutex
+	received := map[string][]map[string]interface{}{}
+	for _, user := range []*storage.User{bob, carol} {
+		userID := user.ID
+		srv.live.add(userID, "", func(v interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received[userID] = append(received[userID], v.(map[string]interface{}))
+			return nil
+		})
+	}
+	last := func(userID string) map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(received[userID]) == 0 {
+			return nil
+		}
+		return received[userID][len(received[userID])-1]
+	}
+
+	srv.presence.Connect(alice.ID)
+	if event := last(bob.ID); event == nil || event["type"] != "presence" || event["user_id"] != alice.ID || event["status"] != "online" {
+		t.Errorf("group member did not get presence: %v", event)
+	}
+	if event := last(carol.ID); event != nil {
+		t.Errorf("stranger got presence: %v", event)
+	}
+
+	srv.handleWSFrame(t.Context(), alice.ID, []byte(fmt.Sprintf(`{"type": "typing", "group_id": %q, "typing": true}`, group.ID)))
+	if event := last(bob.ID); event["type"] != "typing" || event["conversation_id"] != group.ID || event["from"] != alice.ID {
+		t.Errorf("unexpected group typing event: %v", event)
+	}
+	srv.handleWSFrame(t.Context(), carol.ID, []byte(fmt.Sprintf(`{"type": "typing", "group_id": %q, "typing": true}`, group.ID)))
+	if event := last(bob.ID); event["from"] != alice.ID {
+		t.Errorf("non-member typing was relayed: %v", event)
+	}
+	srv.handleWSFrame(t.Context(), alice.ID, []byte(fmt.Sprintf(`{"type": "typing", "to": %q, "typing": true}`, carol.ID)))
+	if event := last(carol.ID); event == nil || event["type"] != "typing" || event["conversation_id"] != alice.ID {
+		t.Errorf("unexpected direct typing event: %v", event)
+	}
+
+	// Контакты показывают присутствие, а не статус, присланный клиентом
+	contactStatus := func() string {
+		rec := httptest.NewRecorder()
+		srv.handleContacts(rec, httptest.NewRequest(http.MethodGet, "/api/contacts", nil))
+		var resp struct {
+			Contacts []Contact `json:"contacts"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		for _, c := range resp.Contacts {
+			if c.ID == alice.ID {
+				return c.Status
+			}
+		}
+		return ""
+	}
+	rec := httptest.NewRecorder()
+	srv.handleContacts(rec, httptest.NewRequest(http.MethodPost, "/api/contacts", strings.NewReader(fmt.Sprintf(`{"id": %q, "name": "Alice", "status": "away"}`, alice.ID))))
+	if !strings.Contains(rec.Body.String(), `"status":"online"`) {
+		t.Errorf("unexpected contact: %s", rec.Body.String())
+	}
+	if status := contactStatus(); status != "online" {
+		t.Errorf("expected alice online, got %q", status)
+	}
+	srv.presence.Disconnect(alice.ID)
+	if status := contactStatus(); status != "offline" {
+		t.Errorf("expected alice offline after disconnect, got %q", status)
+	}
+	if event := last(bob.ID); event["type"] != "presence" || event["status"] != "offline" {
+		t.Errorf("group member did not get offline presence: %v", event)
+	}
+}
+
+func TestMessageEditAndDelete(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.MessageEditHistory = time.Hour
+
+	var users []*storage.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := srv.db.CreateUser(t.Context(), name, "secret", name+"@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+	group := &storage.Group{Name: "Команда", CreatedBy: alice.ID}
+	if err := srv.db.CreateGroup(t.Context(), group, []string{bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	msg := &storage.Message{ConversationID: group.ID, SenderID: alice.ID, Body: "привет"}
+	if err := srv.db.CreateMessage(t.Context(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := srv.Handler()
+	call := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	path := "/api/messages/" + msg.ID
+
+	if rec := call(http.MethodPatch, path, bob.ID, `{"body": "чужое"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("non-author edited a message: %d", rec.Code)
+	}
+	if rec := call(http.MethodPatch, path, alice.ID, `{"body": "привет всем"}`); rec.Code != http.StatusOK {
+		t.Fatalf("edit: %d %s", rec.Code, rec.Body.String())
+	}
+	rec := call(http.MethodGet, path+"/edits", bob.ID, "")
+	var history struct {
+		Edits []*storage.MessageEdit `json:"edits"`
+	}
+	json.NewDecoder(rec.Body).Decode(&history)
+	if rec.Code != http.StatusOK || len(history.Edits) != 1 || history.Edits[0].Body != "привет" {
+		t.Errorf("edit history: %d %+v", rec.Code, history.Edits)
+	}
+	if rec := call(http.MethodGet, path+"/edits", carol.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("stranger read edit history: %d", rec.Code)
+	}
+
+	if rec := call(http.MethodDelete, path, alice.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPatch, path, alice.ID, `{"body": "снова"}`); rec.Code != http.StatusGone {
+		t.Errorf("edited a deleted message: %d", rec.Code)
+	}
+	if stored, _ := srv.db.GetMessage(t.Context(), msg.ID); stored == nil || stored.DeletedAt == nil || stored.Body != "" {
+		t.Errorf("expected tombstone, got %+v", stored)
+	}
+
+	// Участники получают правку и надгробие в журнал
+	events, _ := srv.db.ListEvents(t.Context(), bob.ID, 0, 10)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	if len(types) != 2 || types[0] != storage.EventMessageEdited || types[1] != storage.EventMessageDeleted {
+		t.Errorf("unexpected events: %v", types)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), carol.ID, 0, 10); len(events) != 0 {
+		t.Errorf("stranger got events: %d", len(events))
+	}
+
+	// Личные сообщения не хранятся: править и удалять нечего, прежний PUT без проверки
+	// автора не принимается
+	direct := srv.recordMessageCreated(alice.ID, bob.ID, "лично")
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		if rec := call(method, "/api/messages/"+direct, alice.ID, `{"body": "правка"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("%s of a direct message: %d", method, rec.Code)
+		}
+	}
+	if rec := call(http.MethodPut, "/api/messages/"+direct, carol.ID, `{"user_id": "`+alice.ID+`", "to": "`+bob.ID+`", "body": "подделка"}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT edit: %d", rec.Code)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), bob.ID, 0, 10); events[len(events)-1].Type != storage.EventMessageCreated {
+		t.Errorf("forged edit reached the recipient: %s", events[len(events)-1].Type)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	for _, id := range []string{"c", "a", "e", "b", "d"} {
+		srv.contacts[id] = Contact{ID: id, Name: id}
+	}
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("pagination does not end: %v", seen)
+		}
+		rec := httptest.NewRecorder()
+		srv.handleContacts(rec, httptest.NewRequest(http.MethodGet, "/api/contacts?limit=2&cursor="+cursor, nil))
+		var resp struct {
+			Contacts   []Contact `json:"contacts"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		for _, c := range resp.Contacts {
+			seen = append(seen, c.ID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+		// Удаление выданного контакта между запросами не сдвигает следующие страницы
+		delete(srv.contacts, resp.Contacts[0].ID)
+	}
+	if strings.Join(seen, "") != "abcde" {
+		t.Errorf("unexpected pages: %v", seen)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.handleContacts(rec, httptest.NewRequest(http.MethodGet, "/api/contacts?cursor=!!", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursor accepted: %d", rec.Code)
+	}
+
+	// Списки от новых к старым
+	now := time.Now()
+	invites := []*storage.Invite{{Token: "new", CreatedAt: now}, {Token: "a", CreatedAt: now.Add(-time.Hour)}, {Token: "b", CreatedAt: now.Add(-time.Hour)}}
+	key := func(inv *storage.Invite) string { return newestFirstKey(inv.CreatedAt, inv.Token) }
+	first, next := paginate(invites, pageRequest{Limit: 2}, key)
+	if len(first) != 2 || first[1].Token != "a" || next == "" {
+		t.Fatalf("first page: %v, %q", first, next)
+	}
+	after, _ := base64.RawURLEncoding.DecodeString(next)
+	if rest, next := paginate(invites, pageRequest{Limit: 2, After: string(after)}, key); len(rest) != 1 || rest[0].Token != "b" || next != "" {
+		t.Errorf("second page: %v, %q", rest, next)
+	}
+}
+
+func TestSecurityHeadersAndCORS(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.config.CORSAllowedOrigins = []string{"https://app.example.com/"}
+	srv.config.ContentSecurityPolicy = config.DefaultContentSecurityPolicy
+	srv.config.HSTSMaxAge = time.Hour
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := srv.withSecurityHeaders(srv.withCORS(api))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/contacts", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	h := rec.Header()
+	if h.Get("X-Frame-Options") != "DENY" || h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Content-Security-Policy") == "" {
+		t.Errorf("missing security headers: %v", h)
+	}
+	if h.Get("Strict-Transport-Security") != "max-age=3600; includeSubDomains" {
+		t.Errorf("unexpected HSTS: %q", h.Get("Strict-Transport-Security"))
+	}
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("allowed origin rejected: %v", h)
+	}
+
+	// HSTS только по HTTPS; чужой источник не получает CORS, его предварительный запрос отклоняется
+	req = httptest.NewRequest(http.MethodOptions, "/api/contacts", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("foreign preflight: %d %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/api/messages/m1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "PATCH") ||
+		!strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("allowed preflight: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	cases := []struct{ tlsAddr, method, host, target string }{
+		{":443", http.MethodGet, "chat.example.com", "https://chat.example.com/api/status?x=1"},
+		{":8443", http.MethodGet, "chat.example.com:80", "https://chat.example.com:8443/api/status?x=1"},
+		{":443", http.MethodPost, "[::1]:80", "https://[::1]/api/status?x=1"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/api/status?x=1", nil)
+		req.Host = c.host
+		rec := httptest.NewRecorder()
+		httpsRedirect(c.tlsAddr).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); got != c.target {
+			t.Errorf("%s %s: redirected to %q, want %q", c.method, c.host, got, c.target)
+		}
+		if c.method == http.MethodPost && rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("POST must keep its method: %d", rec.Code)
+		}
+	}
+
+	srv := &Server{config: &config.Config{TLSCertFile: "cert.pem"}}
+	if _, _, err := srv.tlsConfig(); err == nil {
+		t.Error("certificate without key accepted")
+	}
+	srv.config = &config.Config{}
+	if tlsConfig, _, err := srv.tlsConfig(); tlsConfig != nil || err != nil {
+		t.Errorf("TLS enabled without configuration: %v, %v", tlsConfig, err)
+	}
+}
+
+func TestVersionedRouter(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	handler := srv.Handler()
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader("{}")))
+		return rec
+	}
+
+	// /api/v1 и прежний /api ведут к одним обработчикам
+	for _, path := range []string{"/api/v1/time", "/api/time"} {
+		if rec := serve(http.MethodGet, path); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: %d", path, rec.Code)
+		}
+	}
+	if rec := serve(http.MethodGet, "/api/v1/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown route: %d", rec.Code)
+	}
+
+	// Параметр пути и выбор обработчика по методу
+	if rec := serve(http.MethodPatch, "/api/v1/messages/m1"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("PATCH message without token: %d", rec.Code)
+	}
+	rec := serve(http.MethodPost, "/api/v1/messages/m1/edits")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" ||
+		!strings.Contains(rec.Body.String(), "Method not allowed") {
+		t.Errorf("unsupported method: %d %q %s", rec.Code, rec.Header().Get("Allow"), rec.Body.String())
+	}
+
+	// Middleware применяются и к путям /api/v1
+	if rec := serve(http.MethodGet, "/api/v1/time"); rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("security headers missing: %v", rec.Header())
+	}
+	srv.setMaintenance(true, "обновление")
+	if rec := serve(http.MethodPost, "/api/v1/contacts"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("write allowed during maintenance: %d", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/v1/login"); rec.Code == http.StatusServiceUnavailable {
+		t.Errorf("login rejected during maintenance")
+	}
+}
+
+// fakePush - отправитель push, передающий уведомления в канал
+type fakePush struct {
+	sent chan push.Notification
+}
+
+func (f *fakePush) Send(ctx context.Context, sub push.Subscription, n push.Notification) error {
+	if sub.Endpoint == "stale-token" {
+		return push.ErrGone
+	}
+	f.sent <- n
+	return nil
+}
+
+func TestPushNotifications(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.PushTTL = time.Hour
+	sender := &fakePush{sent: make(chan push.Notification, 10)}
+	srv.pushSenders = map[string]push.Sender{storage.PushFCM: sender}
+	handler := srv.Handler()
+
+	call := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	expectPush := func() push.Notification {
+		t.Helper()
+		select {
+		case n := <-sender.sent:
+			return n
+		case <-time.After(2 * time.Second):
+			t.Fatal("push not sent")
+			return push.Notification{}
+		}
+	}
+
+	if rec := call(http.MethodGet, "/api/v1/push/config", "bob", ""); !strings.Contains(rec.Body.String(), `"kinds":["fcm"]`) || strings.Contains(rec.Body.String(), "vapid") {
+		t.Errorf("push config: %s", rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/v1/push/subscriptions", "bob", `{"device_id":"laptop","kind":"webpush","endpoint":"https://push.example/1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("disabled push kind accepted: %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/api/v1/push/subscriptions", "bob", `{"device_id":"phone","kind":"fcm","endpoint":"fcm-token"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("subscribe: %d %s", rec.Code, rec.Body.String())
+	}
+	call(http.MethodPost, "/api/v1/push/subscriptions", "bob", `{"device_id":"tablet","kind":"fcm","endpoint":"stale-token"}`)
+
+	// Без открытого WebSocket звонок приходит срочным push; отозванная подписка удаляется
+	srv.recordNotification("bob", storage.NotificationCall, "call-1")
+	if n := expectPush(); !n.Urgent || n.Data["call_id"] != "call-1" || n.TTL != callPushTTL {
+		t.Errorf("unexpected call push: %+v", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		subs, _ := srv.db.ListPushSubscriptions(t.Context(), "bob")
+		if len(subs) == 1 && subs[0].DeviceID == "phone" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stale subscription kept: %+v", subs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Подключенный пользователь получает события по WebSocket, push не нужен
+	conn := srv.live.add("bob", "", func(interface{}) error { return nil })
+	srv.recordNotification("bob", storage.NotificationMessage, "alice")
+	srv.live.remove("bob", conn)
+	srv.recordNotification("bob", storage.NotificationMessage, "carol")
+	if n := expectPush(); n.Data["conversation_id"] != "carol" || n.Urgent || n.TTL != time.Hour || strings.Contains(n.Body, "carol") {
+		t.Errorf("unexpected message push: %+v", n)
+	}
+
+	if rec := call(http.MethodGet, "/api/v1/push/subscriptions", "bob", ""); !strings.Contains(rec.Body.String(), `"device_id":"phone"`) {
+		t.Errorf("list subscriptions: %s", rec.Body.String())
+	}
+	if rec := call(http.MethodDelete, "/api/v1/push/subscriptions/phone", "alice", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("foreign subscription deleted: %d", rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/api/v1/push/subscriptions/phone", "bob", ""); rec.Code != http.StatusOK {
+		t.Errorf("unsubscribe: %d", rec.Code)
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.lookupLimiter = ratelimit.New(600, 100)
+	handler := srv.Handler()
+
+	ctx := t.Context()
+	alice, _ := srv.db.CreateUser(ctx, "Alice Smith", "secret", "alice@example.com")
+	bob, _ := srv.db.CreateUser(ctx, "Bob Smith", "secret", "+70000000001")
+	carol, _ := srv.db.CreateUser(ctx, "Carol Smith", "secret", "carol@example.com")
+	srv.db.SaveLookupProfile(ctx, &storage.LookupProfile{UserID: alice.ID, Username: "alice", Discoverable: true})
+	srv.db.SaveLookupProfile(ctx, &storage.LookupProfile{UserID: bob.ID, Username: "bobby", Discoverable: true, DiscoverableByPhone: true})
+	srv.db.SaveLookupProfile(ctx, &storage.LookupProfile{UserID: carol.ID, Username: "carol"})
+
+	search := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, alice.ID, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp struct {
+			Users []storage.DirectoryEntry `json:"users"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		var ids []string
+		for _, u := range resp.Users {
+			ids = append(ids, u.UserID)
+		}
+		return rec.Code, ids
+	}
+
+	// Себя и скрытых из каталога не находим
+	if code, ids := search("q=smith"); code != http.StatusOK || len(ids) != 1 || ids[0] != bob.ID {
+		t.Errorf("search by name: %d %v", code, ids)
+	}
+	if _, ids := search("q=%40bob"); len(ids) != 1 || ids[0] != bob.ID {
+		t.Errorf("search by handle: %v", ids)
+	}
+	if _, ids := search("q=%2B7+000+000-00-01"); len(ids) != 1 || ids[0] != bob.ID {
+		t.Errorf("search by phone: %v", ids)
+	}
+	// Поиск по email выключен, пока пользователь его не разрешил
+	if code, ids := search("q=carol%40example.com"); code != http.StatusOK || len(ids) != 0 {
+		t.Errorf("hidden email found: %d %v", code, ids)
+	}
+	if code, _ := search("q=a"); code != http.StatusBadRequest {
+		t.Errorf("short query accepted: %d", code)
+	}
+	if code, _ := search("q=smith&limit=100"); code != http.StatusBadRequest {
+		t.Errorf("oversized limit accepted: %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/search?q=smith", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous search: %d", rec.Code)
+	}
+
+	// По ID из каталога контакты найденного не выдаются - только ему самому
+	for user, want := range map[string]bool{"": false, alice.ID: false, bob.ID: true} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/"+bob.ID, nil)
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := strings.Contains(rec.Body.String(), "+70000000001"); rec.Code != http.StatusOK || got != want {
+			t.Errorf("profile for %q: %d %s", user, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestUsernames(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.lookupLimiter = ratelimit.New(600, 100)
+	srv.config.UsernameChangeInterval = time.Hour
+	srv.config.UsernameHold = time.Hour
+	handler := srv.Handler()
+
+	ctx := t.Context()
+	var users []*storage.User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := srv.db.CreateUser(ctx, name, "secret", name+"@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	call := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	available := func(user, username string) bool {
+		var resp struct {
+			Available bool `json:"available"`
+		}
+		rec := call(http.MethodGet, "/usernames/"+username, user, "")
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("availability of %s: %d %s", username, rec.Code, rec.Body.String())
+		}
+		return resp.Available
+	}
+
+	if rec := call(http.MethodPut, "/users/"+alice.ID+"/username", bob.ID, `{"username": "mallory"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("changed another user's username: %d", rec.Code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		body := `{"username": "pwned", "discoverable_by_email": true}`
+		if rec := call(method, "/users/"+alice.ID+"/lookup", bob.ID, body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s another user's lookup settings: %d", method, rec.Code)
+		}
+	}
+	if p, _ := srv.db.GetLookupProfile(ctx, alice.ID); p.Username != "" || p.DiscoverableByEmail {
+		t.Errorf("lookup settings changed by another user: %+v", p)
+	}
+	if rec := call(http.MethodPut, "/users/"+alice.ID+"/username", alice.ID, `{"username": "a"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid username: %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/users/"+alice.ID+"/username", alice.ID, `{"username": "@Alice"}`); rec.Code != http.StatusOK {
+		t.Fatalf("claim username: %d %s", rec.Code, rec.Body.String())
+	}
+	if available(bob.ID, "alice") || !available(alice.ID, "alice") {
+		t.Error("claimed username reported as available")
+	}
+	if rec := call(http.MethodPut, "/users/"+bob.ID+"/username", bob.ID, `{"username": "alice"}`); rec.Code != http.StatusConflict {
+		t.Errorf("taken username claimed: %d", rec.Code)
+	}
+
+	// Частая смена запрещена, прежнее имя удерживается за владельцем
+	rec := call(http.MethodPut, "/users/"+alice.ID+"/username", alice.ID, `{"username": "alice_w"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("username changed too often: %d", rec.Code)
+	}
+	srv.config.UsernameChangeInterval = 0
+	if rec := call(http.MethodPut, "/users/"+alice.ID+"/username", alice.ID, `{"username": "alice_w"}`); rec.Code != http.StatusOK {
+		t.Fatalf("change username: %d %s", rec.Code, rec.Body.String())
+	}
+	if available(bob.ID, "alice") {
+		t.Error("held username available to another user")
+	}
+	if rec := call(http.MethodPut, "/users/"+bob.ID+"/username", bob.ID, `{"username": "bob"}`); rec.Code != http.StatusOK {
+		t.Fatalf("claim username: %d", rec.Code)
+	}
+	call(http.MethodPut, "/users/"+carol.ID+"/username", carol.ID, `{"username": "carol"}`)
+
+	// Упоминаются только участники группы; скрытые из поиска тоже
+	group := &storage.Group{Name: "Команда", CreatedBy: alice.ID}
+	if err := srv.db.CreateGroup(ctx, group, []string{bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	rec = call(http.MethodPost, "/groups/"+group.ID+"/messages", alice.ID, `{"body": "@Bob, ask @carol and @nobody"}`)
+	var sent struct {
+		Mentions []mention `json:"mentions"`
+	}
+	json.NewDecoder(rec.Body).Decode(&sent)
+	if rec.Code != http.StatusOK || len(sent.Mentions) != 1 || sent.Mentions[0] != (mention{UserID: bob.ID, Username: "bob"}) {
+		t.Errorf("mentions: %d %+v", rec.Code, sent.Mentions)
+	}
+	events, _ := srv.db.ListEvents(ctx, bob.ID, 0, 10)
+	if len(events) == 0 || !strings.Contains(string(events[len(events)-1].Payload), `"mentions"`) {
+		t.Errorf("message event has no mentions: %+v", events)
+	}
+
+	if rec := call(http.MethodDelete, "/users/"+bob.ID+"/username", bob.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("release username: %d", rec.Code)
+	}
+	if p, _ := srv.db.GetLookupProfile(ctx, bob.ID); p.Username != "" {
+		t.Errorf("username not released: %+v", p)
+	}
+}
+
+func TestUserAvatar(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.AvatarMaxBytes = 1 << 20
+	backend, err := archive.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.avatars = backend
+	handler := srv.Handler()
+
+	upload := func(user, target string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "me.png")
+		part.Write(content)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+target+"/avatar", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 200)))
+	if rec := upload("bob", "alice", img.Bytes()); rec.Code != http.StatusUnauthorized {
+		t.Errorf("avatar of another user replaced: %d", rec.Code)
+	}
+	if rec := upload("alice", "alice", []byte("<html><script>alert(1)</script></html>")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-image accepted: %d", rec.Code)
+	}
+	if rec := get("/api/v1/users/alice/avatar", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("avatar before upload: %d", rec.Code)
+	}
+
+	rec := upload("alice", "alice", img.Bytes())
+	var resp struct {
+		URL    string         `json:"url"`
+		Avatar storage.Avatar `json:"avatar"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %v", rec.Code, err)
+	}
+
+	// Ссылка с версией кешируется навсегда, ETag позволяет проверить кеш без нее
+	rec = get(resp.URL+"&size=100", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("get avatar: %d %v", rec.Code, rec.Header())
+	}
+	if decoded, err := png.Decode(rec.Body); err != nil || decoded.Bounds().Dx() != 128 {
+		t.Errorf("expected 128px variant: %v", err)
+	}
+	rec = get("/api/users/alice/avatar", nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("unversioned avatar: %d %v", rec.Code, rec.Header())
+	}
+	if rec := get("/api/users/alice/avatar", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("cached avatar: %d", rec.Code)
+	}
+
+	// Новая загрузка меняет версию, прежние варианты удаляются
+	previous := resp.Avatar.Version
+	upload("alice", "alice", img.Bytes())
+	if _, err := backend.Get(avatarKey("alice", previous, 256)); err == nil {
+		t.Error("previous avatar not deleted")
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/alice/avatar", nil)
+	req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, "alice", time.Minute))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("delete avatar: %d", rec.Code)
+	}
+	if rec := get("/api/users/alice/avatar", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted avatar served: %d", rec.Code)
+	}
+}
+
+// TestAdminAPI проверяет доступ к /api/admin/* по роли admin и блокировку аккаунта
+func TestAdminAPI(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.AdminToken = "admin-token"
+	handler := srv.Handler()
+
+	alice, err := srv.db.CreateUser(t.Context(), "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := srv.db.CreateUser(t.Context(), "Bob", "secret", "+15550100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceToken := signaling.IssueToken(srv.signalingSecret, alice.ID, time.Minute)
+	bobToken := signaling.IssueToken(srv.signalingSecret, bob.ID, time.Minute)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/v1/admin/users", "/api/v1/transport/events", "/api/v1/transport/blocks"} {
+		if rec := do(http.MethodGet, path, aliceToken, ""); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 without admin role, got %d", path, rec.Code)
+		}
+	}
+	if rec := do(http.MethodPost, "/api/v1/admin/roles", "admin-token", `{"user_id": "`+alice.ID+`", "role": "admin"}`); rec.Code != http.StatusOK {
+		t.Fatalf("grant admin role: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(http.MethodGet, "/api/v1/admin/users?limit=1", aliceToken, "")
+	var users struct {
+		Users []struct {
+			ID      string `json:"id"`
+			IsAdmin bool   `json:"is_admin"`
+		} `json:"users"`
+		NextCursor string `json:"next_cursor"`
+	}
+	json.NewDecoder(rec.Body).Decode(&users)
+	if rec.Code != http.StatusOK || len(users.Users) != 1 || users.NextCursor == "" {
+		t.Fatalf("unexpected users page: %d %+v", rec.Code, users)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/admin/users/"+alice.ID+"/disable", aliceToken, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("admin disabled own account: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/admin/users/"+bob.ID+"/disable", aliceToken, `{"reason": "spam"}`); rec.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", rec.Code, rec.Body)
+	}
+	// Токен и вход заблокированного пользователя больше не принимаются
+	if rec := do(http.MethodGet, "/api/v1/invites", bobToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("disabled user token accepted: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/login", "", `{"contact_info": "+15550100", "password": "secret"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("disabled user logged in: %d", rec.Code)
+	}
+	entries, _ := srv.db.ListAudit(t.Context(), 0, 0)
+	if last := entries[len(entries)-1]; last.Action != "user.disable" || last.Actor != alice.ID {
+		t.Errorf("disable not attributed to admin: %+v", last)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/admin/users/"+bob.ID+"/enable", "admin-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("enable: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/login", "", `{"contact_info": "+15550100", "password": "secret"}`); rec.Code != http.StatusOK {
+		t.Errorf("enabled user cannot log in: %d", rec.Code)
+	}
+
+	for _, path := range []string{"/api/v1/admin/invites", "/api/v1/admin/transport/history", "/api/v1/admin/storage", "/api/v1/transport/events", "/api/v1/transport/blocks"} {
+		rec := do(http.MethodGet, path, aliceToken, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", path, rec.Code, rec.Body)
+		}
+	}
+	rec = do(http.MethodGet, "/api/v1/admin/storage", aliceToken, "")
+	var storageStats struct {
+		Database storage.StorageStats `json:"database"`
+	}
+	json.NewDecoder(rec.Body).Decode(&storageStats)
+	if storageStats.Database.Tables["users"] != 2 {
+		t.Errorf("unexpected storage stats: %+v", storageStats.Database)
+	}
+}
+
+// TestLocalizedErrors проверяет перевод ошибок API по Accept-Language и выбранному языку
+func TestLocalizedErrors(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	alice, err := srv.db.CreateUser(t.Context(), "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signaling.IssueToken(srv.signalingSecret, alice.ID, time.Minute)
+
+	do := func(method, path, token, language, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if language != "" {
+			req.Header.Set("Accept-Language", language)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if _, resp := do(http.MethodGet, "/api/v1/users/nobody", "", "", ""); resp["error"] != "User not found" {
+		t.Errorf("default language changed: %v", resp["error"])
+	}
+	rec, resp := do(http.MethodGet, "/api/v1/users/nobody", "", "ru-RU,ru;q=0.9,en;q=0.8", "")
+	if rec.Code != http.StatusNotFound || resp["error"] != "Пользователь не найден" || rec.Header().Get("Content-Language") != "ru" {
+		t.Errorf("unexpected localized error: %d %v %q", rec.Code, resp, rec.Header().Get("Content-Language"))
+	}
+	// Успешные ответы не изменяются
+	if rec, resp := do(http.MethodGet, "/api/v1/users/"+alice.ID, "", "ru", ""); rec.Code != http.StatusOK || resp["success"] != true {
+		t.Errorf("successful response changed: %d %v", rec.Code, resp)
+	}
+
+	// Выбранный язык важнее Accept-Language
+	if rec, _ := do(http.MethodPut, "/api/v1/users/"+alice.ID+"/locale", token, "", `{"locale": "de"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported locale accepted: %d", rec.Code)
+	}
+	if rec, _ := do(http.MethodPut, "/api/v1/users/"+alice.ID+"/locale", token, "", `{"locale": "ru-RU"}`); rec.Code != http.StatusOK {
+		t.Fatalf("set locale: %d", rec.Code)
+	}
+	if _, resp := do(http.MethodGet, "/api/v1/users/"+alice.ID+"/locale", token, "en", ""); resp["locale"] != "ru" || resp["effective"] != "ru" {
+		t.Errorf("unexpected locale: %v", resp)
+	}
+	if _, resp := do(http.MethodPost, "/api/v1/invites", token, "en", "{"); resp["error"] != "Некорректный JSON" {
+		t.Errorf("user locale not applied: %v", resp["error"])
+	}
+}
+
+// TestInputValidation проверяет ошибки полей и нормализацию телефона и email при регистрации и входе
+func TestInputValidation(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	handler := srv.Handler()
+
+	post := func(path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	fieldCodes := func(resp map[string]interface{}) map[string]string {
+		codes := map[string]string{}
+		fields, _ := resp["fields"].([]interface{})
+		for _, f := range fields {
+			f := f.(map[string]interface{})
+			codes[f["field"].(string)] = f["code"].(string)
+		}
+		return codes
+	}
+
+	rec, resp := post("/api/v1/auth/phone", `{"phone": "+1 555", "name": "Bob", "password": "correct-horse-42"}`)
+	if rec.Code != http.StatusBadRequest || fieldCodes(resp)["phone"] != validate.CodeInvalidPhone {
+		t.Errorf("invalid phone accepted: %d %v", rec.Code, resp)
+	}
+	rec, resp = post("/api/v1/auth/phone", `{"phone": "+1 (555) 123-4567", "name": "\u200b", "password": "bob12"}`)
+	if codes := fieldCodes(resp); rec.Code != http.StatusBadRequest || codes["name"] != validate.CodeRequired || codes["password"] != validate.CodeTooShort {
+		t.Errorf("expected name and password errors: %d %v", rec.Code, resp)
+	}
+	proof := srv.issueProof(proofPhone, "+15551234567")
+	rec, _ = post("/api/v1/auth/phone", `{"phone": "+1 (555) 123-4567", "name": "  Bob   Smith ", "password": "correct-horse-42", "proof": "`+proof+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("phone registration: %d %s", rec.Code, rec.Body)
+	}
+	user, err := srv.db.GetUserByPhone(t.Context(), "+15551234567")
+	if err != nil || user.Name != "Bob Smith" {
+		t.Fatalf("phone not normalized: %+v, %v", user, err)
+	}
+	// Вход по тому же номеру в другой записи
+	if rec, _ := post("/api/v1/login", `{"contact_info": "001 555 123 4567", "password": "correct-horse-42"}`); rec.Code != http.StatusOK {
+		t.Errorf("login with unnormalized phone: %d", rec.Code)
+	}
+
+	rec, resp = post("/api/v1/invite", `{"email": "Alice <alice@example.com>"}`)
+	if rec.Code != http.StatusBadRequest || fieldCodes(resp)["email"] != validate.CodeInvalidEmail {
+		t.Errorf("invalid invite email accepted: %d %v", rec.Code, resp)
+	}
+	// Пригласивший не берется из тела запроса: без токена приглашение анонимное
+	rec, _ = post("/api/v1/invite", `{"email": "alice@EXAMPLE.com", "inviter_id": "`+user.ID+`"}`)
+	var invite struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &invite)
+	if issued, _ := srv.db.GetInvite(t.Context(), invite.Token); issued == nil || issued.CreatedBy != "" {
+		t.Errorf("inviter taken from the request body: %+v", issued)
+	}
+	rec, resp = post("/api/v1/register", `{"token": "`+invite.Token+`", "name": "Alice", "password": "alice1234"}`)
+	if rec.Code != http.StatusBadRequest || fieldCodes(resp)["password"] != validate.CodeWeakPassword {
+		t.Errorf("password with email accepted: %d %v", rec.Code, resp)
+	}
+	if rec, _ := post("/api/v1/register", `{"token": "`+invite.Token+`", "name": "Alice", "password": "correct-horse-42"}`); rec.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := srv.db.GetUserByEmail(t.Context(), "alice@example.com"); err != nil {
+		t.Errorf("email not normalized: %v", err)
+	}
+}
+
+// endpointPush - отправитель push, передающий в канал адреса подписок
+type endpointPush chan string
+
+func (p endpointPush) Send(ctx context.Context, sub push.Subscription, n push.Notification) error {
+	p <- sub.Endpoint
+	return nil
+}
+
+func TestDevices(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	pushed := make(endpointPush, 10)
+	srv.pushSenders = map[string]push.Sender{storage.PushFCM: pushed}
+	handler := srv.Handler()
+
+	call := func(method, path, user, body string, header ...string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if rec, _ := call(http.MethodGet, "/api/v1/users/alice/devices", "mallory", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("foreign device list: %d", rec.Code)
+	}
+
+	// Регистрация выдает ID устройства и сессию, привязанную к нему
+	rec, resp := call(http.MethodPost, "/api/v1/users/alice/devices", "alice", `{"name": "Pixel", "platform": "android"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register device: %d %s", rec.Code, rec.Body)
+	}
+	phoneID := resp["device"].(map[string]interface{})["id"].(string)
+	sess := resp["session"].(map[string]interface{})
+	if !strings.HasPrefix(phoneID, "device-") || sess["device_id"] != phoneID {
+		t.Fatalf("unexpected registration: %v", resp)
+	}
+	if rec, _ := call(http.MethodPut, "/api/v1/users/alice/devices/laptop", "alice", `{"name": "Laptop"}`); rec.Code != http.StatusOK {
+		t.Fatalf("declare device: %d", rec.Code)
+	}
+	srv.db.SavePushSubscription(t.Context(), &storage.PushSubscription{UserID: "alice", DeviceID: phoneID, Kind: storage.PushFCM, Endpoint: "phone-token"})
+	srv.db.SavePushSubscription(t.Context(), &storage.PushSubscription{UserID: "alice", DeviceID: "laptop", Kind: storage.PushFCM, Endpoint: "laptop-token"})
+
+	// Push получают только устройства без открытого WebSocket
+	conn := srv.live.add("alice", phoneID, func(interface{}) error { return nil })
+	srv.notifyPush("alice", storage.NotificationMessage, "bob")
+	select {
+	case endpoint := <-pushed:
+		if endpoint != "laptop-token" {
+			t.Errorf("push sent to connected device: %s", endpoint)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("push not sent")
+	}
+
+	_, resp = call(http.MethodGet, "/api/v1/users/alice/devices", "alice", "", "X-Device-ID", "laptop")
+	devices, _ := resp["devices"].([]interface{})
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices: %v", resp)
+	}
+	for _, d := range devices {
+		d := d.(map[string]interface{})
+		if online, current := d["online"] == true, d["current"] == true; online != (d["id"] == phoneID) || current != (d["id"] == "laptop") {
+			t.Errorf("unexpected device state: %v", d)
+		}
+	}
+	srv.live.remove("alice", conn)
+
+	// Согласование возможностей - только от своего устройства
+	negotiate := "/api/v1/capabilities/negotiate?device_id=laptop&user_id=alice"
+	if rec, resp := call(http.MethodGet, negotiate, "alice", ""); rec.Code != http.StatusOK || len(resp["negotiated"].(map[string]interface{})) != 1 {
+		t.Errorf("negotiate: %d %v", rec.Code, resp)
+	}
+	if rec, _ := call(http.MethodGet, negotiate, "mallory", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("negotiate from a foreign device: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, negotiate, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous negotiate: %d", rec.Code)
+	}
+
+	// Курсор доставки устройства сдвигается при чтении журнала
+	rec, resp = call(http.MethodGet, "/api/v1/users/alice/events?device_id="+phoneID, "alice", "")
+	if rec.Code != http.StatusOK || len(resp["events"].([]interface{})) == 0 {
+		t.Fatalf("events: %d %v", rec.Code, resp)
+	}
+	if device, _ := srv.db.GetDevice(t.Context(), phoneID); device.EventSeq != int64(resp["last_seq"].(float64)) {
+		t.Errorf("device cursor %d, last seq %v", device.EventSeq, resp["last_seq"])
+	}
+	if rec, _ := call(http.MethodGet, "/api/v1/users/bob/events?device_id="+phoneID, "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("foreign device cursor: %d", rec.Code)
+	}
+
+	// Отключение устройства завершает его сессию
+	if rec, _ := call(http.MethodDelete, "/api/v1/users/alice/devices/"+phoneID, "alice", ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke device: %d", rec.Code)
+	}
+	if _, err := srv.db.ValidateSession(t.Context(), sess["token"].(string), storage.SessionClient{}); err == nil {
+		t.Error("session of revoked device still valid")
+	}
+	if subs, _ := srv.db.ListPushSubscriptions(t.Context(), "alice"); len(subs) != 1 || subs[0].DeviceID != "laptop" {
+		t.Errorf("push subscriptions after revoke: %+v", subs)
+	}
+	events, _ := srv.db.ListEvents(t.Context(), "alice", 0, 10)
+	if last := events[len(events)-1]; last.Type != storage.EventDevices || !strings.Contains(string(last.Payload), `"revoked"`) {
+		t.Errorf("unexpected last event: %+v", last)
+	}
+}
+
+func TestDeviceLinking(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.PublicURL = "https://hydra.example/"
+	srv.config.DeviceLinkTTL = time.Minute
+	handler := srv.Handler()
+
+	alice, err := srv.db.CreateUser(t.Context(), "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path, user, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/link/"+path, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if rec, _ := call("start", "", `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous link start: %d", rec.Code)
+	}
+	rec, started := call("start", alice.ID, `{"key_material": "c2VhbGVk"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link start: %d %s", rec.Code, rec.Body)
+	}
+	token, _ := started["token"].(string)
+	if uri, _ := started["link_uri"].(string); !strings.HasPrefix(uri, "hydra://link?server=https%3A%2F%2Fhydra.example&token=") {
+		t.Errorf("link uri: %q", uri)
+	}
+
+	rec, linked := call("complete", "", fmt.Sprintf(`{"token": %q, "device": {"name": "Laptop", "platform": "web"}}`, token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link complete: %d %s", rec.Code, rec.Body)
+	}
+	device, _ := linked["device"].(map[string]interface{})
+	session, _ := linked["session"].(map[string]interface{})
+	if linked["key_material"] != "c2VhbGVk" || device["user_id"] != alice.ID || session["device_id"] != device["id"] || session["token"] == "" {
+		t.Errorf("linked device: %v", linked)
+	}
+	if devices, _ := srv.db.ListDevices(t.Context(), alice.ID); len(devices) != 1 || devices[0].Name != "Laptop" {
+		t.Errorf("devices after linking: %+v", devices)
+	}
+
+	// Токен одноразовый
+	if rec, _ := call("complete", "", fmt.Sprintf(`{"token": %q}`, token)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("link token reused: %d", rec.Code)
+	}
+	if rec, _ := call("complete", "", `{"token": "guess"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown link token: %d", rec.Code)
+	}
+	_, started = call("start", alice.ID, "")
+	srv.db.DisableUser(t.Context(), alice.ID, "admin", "test")
+	if rec, _ := call("complete", "", fmt.Sprintf(`{"token": %q}`, started["token"])); rec.Code != http.StatusForbidden {
+		t.Errorf("linked a disabled account: %d", rec.Code)
+	}
+}
+
+// Деактивировать аккаунт и забрать накопленные сообщения может только сам пользователь
+func TestAccountDeactivationOwner(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	user, _ := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	call := func(action, caller, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+user.ID+"/"+action, strings.NewReader(body))
+		if caller != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, caller, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, caller := range []string{"", "mallory"} {
+		if code := call("deactivate", caller, `{"inbound_mode": "bounce"}`); code != http.StatusUnauthorized {
+			t.Errorf("deactivated by %q: %d", caller, code)
+		}
+		if code := call("reactivate", caller, ""); code != http.StatusUnauthorized {
+			t.Errorf("reactivated by %q: %d", caller, code)
+		}
+	}
+	if state := srv.accountState(user.ID); state != nil {
+		t.Errorf("account changed by a stranger: %+v", state)
+	}
+	if code := call("deactivate", user.ID, `{"inbound_mode": "bounce"}`); code != http.StatusOK {
+		t.Errorf("deactivate by owner: %d", code)
+	}
+	if code := call("reactivate", user.ID, ""); code != http.StatusOK {
+		t.Errorf("reactivate by owner: %d", code)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	user, err := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	voicePath := filepath.Join(t.TempDir(), "voice.mp3")
+	os.WriteFile(voicePath, []byte("audio"), 0o600)
+	srv.db.CreateVoiceFile(t.Context(), &storage.VoiceFile{ID: "voice-1", ConversationID: "c1", SenderID: user.ID, Path: voicePath})
+	srv.db.CreateMessage(t.Context(), &storage.Message{ConversationID: "c1", SenderID: user.ID, Body: "hello"})
+	sess, _ := srv.db.CreateSession(t.Context(), user.ID, "", storage.SessionClient{}, time.Hour, time.Hour)
+
+	erase := func(caller, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+user.ID+"/delete", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, caller, time.Minute))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := erase("mallory", `{"password": "correct-horse-42"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("deleted by another user: %d", rec.Code)
+	}
+	if rec := erase(user.ID, `{"password": "wrong"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("deleted without re-authentication: %d", rec.Code)
+	}
+	rec := erase(user.ID, `{"password": "correct-horse-42"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"voice_messages":1`) {
+		t.Fatalf("delete account: %d %s", rec.Code, rec.Body)
+	}
+
+	if _, err := srv.db.GetUser(t.Context(), user.ID); err == nil {
+		t.Error("user still exists")
+	}
+	if _, err := os.Stat(voicePath); !os.IsNotExist(err) {
+		t.Errorf("voice file kept: %v", err)
+	}
+	if msgs, _ := srv.db.ListMessages(t.Context(), "c1", 10); len(msgs) != 0 {
+		t.Errorf("messages kept: %+v", msgs)
+	}
+	if _, err := srv.db.ValidateSession(t.Context(), sess.Token, storage.SessionClient{}); err == nil {
+		t.Error("session kept")
+	}
+	entries, _ := srv.db.ListAudit(t.Context(), 0, 10)
+	if len(entries) != 1 || entries[0].Action != "user.delete" {
+		t.Errorf("deletion not audited: %+v", entries)
+	}
+	if rec := erase(user.ID, `{"password": "correct-horse-42"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted twice: %d", rec.Code)
+	}
+}
+
+// Аккаунт, созданный через поставщика, пароля не знает: удаление подтверждает свежая сессия
+func TestPasswordlessAccountDeletion(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	user, _ := srv.db.CreateUser(t.Context(), "Alice", "unknown-random-password", "alice@example.com")
+	srv.db.LinkExternalIdentity(t.Context(), &storage.ExternalIdentity{UserID: user.ID, Provider: "keycloak", Subject: "kc-alice", Signup: true})
+	erase := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+user.ID+"/delete", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Токен сигнализации не говорит, когда был вход
+	if rec := erase(signaling.IssueToken(srv.signalingSecret, user.ID, time.Minute), `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("deleted without a recent sign-in: %d", rec.Code)
+	}
+	sess, _ := srv.db.CreateSession(t.Context(), user.ID, "", storage.SessionClient{}, time.Hour, time.Hour)
+	if rec := erase(sess.Token, `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("delete with a recent sign-in: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := srv.db.GetUser(t.Context(), user.ID); err == nil {
+		t.Error("user still exists")
+	}
+}
+
+// Записи звонков видят и скачивают их участники и администраторы, удаляют - администраторы
+func TestRecordingAccess(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.AdminToken = "admin-token"
+	handler := srv.Handler()
+
+	srv.db.CreateRecording(t.Context(), &storage.Recording{ID: "rec-1", RoomID: "room-1", RequestedBy: "call-a",
+		Participants: []string{"call-a"}, Users: []string{"alice"}, StartedAt: time.Now(), StoppedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+	call := func(method, path, token, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	alice := signaling.IssueToken(srv.signalingSecret, "alice", time.Minute)
+	bob := signaling.IssueToken(srv.signalingSecret, "bob", time.Minute)
+
+	if code, _ := call(http.MethodGet, "/recordings", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("list without token: %d", code)
+	}
+	for token, want := range map[string]int{alice: 1, bob: 0, "admin-token": 1} {
+		if code, resp := call(http.MethodGet, "/recordings", token, ""); code != http.StatusOK || len(resp["recordings"].([]interface{})) != want {
+			t.Errorf("list: %d %v, want %d recordings", code, resp, want)
+		}
+	}
+	for _, path := range []string{"/recordings/rec-1", "/recordings/rec-1/call-a.ogg"} {
+		if code, _ := call(http.MethodGet, path, bob, ""); code != http.StatusForbidden {
+			t.Errorf("%s by a non-participant: %d", path, code)
+		}
+	}
+	if code, _ := call(http.MethodGet, "/recordings/rec-1", alice, ""); code != http.StatusOK {
+		t.Errorf("recording for a participant: %d", code)
+	}
+	if code, _ := call(http.MethodDelete, "/recordings/rec-1", alice, ""); code != http.StatusForbidden {
+		t.Errorf("deleted by a participant: %d", code)
+	}
+	if code, _ := call(http.MethodDelete, "/recordings/rec-1", "admin-token", ""); code != http.StatusOK {
+		t.Errorf("deleted by admin: %d", code)
+	}
+
+	// Управлять записью можно только от своего звонка
+	for _, action := range []string{"start", "consent", "stop"} {
+		body := `{"call_id": "call-a", "room_id": "room-1", "granted": true}`
+		if code, _ := call(http.MethodPost, "/call/recording/"+action, "", body); code != http.StatusUnauthorized {
+			t.Errorf("%s without token: %d", action, code)
+		}
+		if code, _ := call(http.MethodPost, "/call/recording/"+action, bob, body); code != http.StatusForbidden {
+			t.Errorf("%s for a foreign call: %d", action, code)
+		}
+	}
+}
+
+// Чат звонка доступен только пользователю звонка
+func TestCallChatAccess(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	call := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	bob := signaling.IssueToken(srv.signalingSecret, "bob", time.Minute)
+	body := `{"call_id": "call-a", "conversation_id": "group-1", "sender_id": "alice", "type": "text", "body": "hi"}`
+
+	if code := call(http.MethodPost, "/call/chat", "", body); code != http.StatusUnauthorized {
+		t.Errorf("send without token: %d", code)
+	}
+	if code := call(http.MethodGet, "/call/chat?call_id=call-a", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("read without token: %d", code)
+	}
+	if code := call(http.MethodPost, "/call/chat", bob, body); code != http.StatusForbidden {
+		t.Errorf("send to a foreign call: %d", code)
+	}
+	if code := call(http.MethodGet, "/call/chat?call_id=call-a", bob, ""); code != http.StatusForbidden {
+		t.Errorf("read a foreign call: %d", code)
+	}
+}
+
+func TestBlocksAndReports(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.config.AdminToken = "admin-token"
+	handler := srv.Handler()
+
+	alice, _ := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	bob, _ := srv.db.CreateUser(t.Context(), "Bob", "correct-horse-42", "+15550100")
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	aliceToken := signaling.IssueToken(srv.signalingSecret, alice.ID, time.Minute)
+	bobToken := signaling.IssueToken(srv.signalingSecret, bob.ID, time.Minute)
+	send := func() *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/api/v1/send", bobToken, `{"message": "hi", "from": "`+bob.ID+`", "to": "`+alice.ID+`"}`)
+	}
+
+	if rec := call(http.MethodPost, "/api/v1/blocks", "bad", `{"user_id": "`+bob.ID+`"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("block without token: %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/api/v1/blocks", aliceToken, `{"user_id": "`+alice.ID+`"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("self block: %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/api/v1/blocks", aliceToken, `{"user_id": "`+bob.ID+`"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("block: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(http.MethodGet, "/api/v1/blocks", aliceToken, ""); !strings.Contains(rec.Body.String(), `"blocked_id":"`+bob.ID+`"`) {
+		t.Errorf("blocks: %s", rec.Body)
+	}
+
+	// drop: отправитель видит обычный ответ, получатель сообщения не получает
+	if rec := send(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"message_id"`) {
+		t.Errorf("dropped send: %d %s", rec.Code, rec.Body)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), alice.ID, 0, 10); len(events) != 0 {
+		t.Errorf("blocked message delivered: %+v", events)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), bob.ID, 0, 10); len(events) != 1 {
+		t.Errorf("sender journal: %+v", events)
+	}
+	srv.config.BlockedSenderMode = storage.BlockedReject
+	if rec := send(); rec.Code != http.StatusConflict {
+		t.Errorf("rejected send: %d", rec.Code)
+	}
+	// Блокировку не обойти, подставив в from другого отправителя
+	if rec := call(http.MethodPost, "/api/v1/send", bobToken, `{"message": "hi", "from": "someone-else", "to": "`+alice.ID+`"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("send with a forged sender: %d", rec.Code)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), alice.ID, 0, 10); len(events) != 0 {
+		t.Errorf("message with a forged sender delivered: %+v", events)
+	}
+
+	if rec := call(http.MethodDelete, "/api/v1/blocks/"+bob.ID, aliceToken, ""); rec.Code != http.StatusOK {
+		t.Errorf("unblock: %d", rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/api/v1/blocks/"+bob.ID, aliceToken, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unblock twice: %d", rec.Code)
+	}
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Errorf("send after unblock: %d", rec.Code)
+	}
+	if events, _ := srv.db.ListEvents(t.Context(), alice.ID, 0, 10); len(events) != 1 {
+		t.Errorf("message after unblock not delivered: %+v", events)
+	}
+
+	// Жалоба с блокировкой попадает к администратору
+	if rec := call(http.MethodPost, "/api/v1/reports", aliceToken, `{"user_id": "`+bob.ID+`", "reason": "rude"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown reason: %d", rec.Code)
+	}
+	rec := call(http.MethodPost, "/api/v1/reports", aliceToken, `{"user_id": "`+bob.ID+`", "reason": "spam", "details": "ads", "block": true}`)
+	var created struct {
+		Report storage.AbuseReport `json:"report"`
+	}
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&created) != nil || created.Report.Status != storage.ReportOpen {
+		t.Fatalf("report: %d %+v", rec.Code, created)
+	}
+	if blocked, _ := srv.db.IsBlocked(t.Context(), alice.ID, bob.ID); !blocked {
+		t.Error("reported user not blocked")
+	}
+	if rec := call(http.MethodGet, "/api/v1/admin/reports?status=open", aliceToken, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("reports listed without admin access: %d", rec.Code)
+	}
+	if rec := call(http.MethodGet, "/api/v1/admin/reports?status=open", "admin-token", ""); !strings.Contains(rec.Body.String(), created.Report.ID) {
+		t.Errorf("admin reports: %d %s", rec.Code, rec.Body)
+	}
+	resolve := "/api/v1/admin/reports/" + created.Report.ID + "/resolve"
+	if rec := call(http.MethodPost, resolve, "admin-token", `{"resolution": "warned"}`); rec.Code != http.StatusOK {
+		t.Errorf("resolve: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(http.MethodPost, resolve, "admin-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("resolved twice: %d", rec.Code)
+	}
+	if entries, _ := srv.db.ListAudit(t.Context(), 0, 10); len(entries) != 2 || entries[0].Action != "report.resolve" {
+		t.Errorf("resolution not audited: %+v", entries)
+	}
+}
+
+func TestContactSync(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	srv.syncLimiter = ratelimit.New(1, 1)
+	handler := srv.Handler()
+
+	alice, _ := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	bob, _ := srv.db.CreateUser(t.Context(), "Bob", "correct-horse-42", "+15550100")
+	hidden, _ := srv.db.CreateUser(t.Context(), "Hidden", "correct-horse-42", "+15550199")
+	srv.db.SaveLookupProfile(t.Context(), &storage.LookupProfile{UserID: bob.ID, DiscoverableByPhone: true})
+	srv.db.SaveLookupProfile(t.Context(), &storage.LookupProfile{UserID: hidden.ID, Discoverable: true})
+	token := signaling.IssueToken(srv.signalingSecret, alice.ID, time.Minute)
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/contacts/sync", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var got struct {
+		Salt    lookup.ContactSalt `json:"salt"`
+		Matches []struct {
+			Hash   string `json:"hash"`
+			UserID string `json:"user_id"`
+		} `json:"matches"`
+	}
+	if rec := call(http.MethodGet, ""); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&got) != nil {
+		t.Fatalf("salt: %d", rec.Code)
+	}
+	salt, _ := base64.StdEncoding.DecodeString(got.Salt.Salt)
+	hashes := []string{
+		lookup.HashContact(salt, "+15550100"),
+		lookup.HashContact(salt, "+15550199"),
+		lookup.HashContact(salt, "carol@example.com"),
+	}
+	body, _ := json.Marshal(map[string]interface{}{"epoch": got.Salt.Epoch, "hashes": hashes})
+	rec := call(http.MethodPost, string(body))
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&got) != nil {
+		t.Fatalf("sync: %d %s", rec.Code, rec.Body)
+	}
+	if len(got.Matches) != 1 || got.Matches[0].UserID != bob.ID || got.Matches[0].Hash != hashes[0] {
+		t.Errorf("matches: %+v", got.Matches)
+	}
+	if strings.Contains(rec.Body.String(), "+15550100") {
+		t.Error("raw contact leaked")
+	}
+
+	if rec := call(http.MethodPost, `{"epoch": 1, "hashes": []}`); rec.Code != http.StatusConflict {
+		t.Errorf("stale salt: %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, string(body)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("sync not rate limited: %d", rec.Code)
+	}
+}
+
+// Настройки дайджеста меняет только сам пользователь
+func TestUserDigestSettings(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.signalingSecret = []byte("secret")
+	handler := srv.Handler()
+
+	call := func(method, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/alice/digest", strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+signaling.IssueToken(srv.signalingSecret, user, time.Minute))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, user := range []string{"", "mallory"} {
+		if rec := call(http.MethodPut, user, `{"privacy": false}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("digest changed by %q: %d", user, rec.Code)
+		}
+		if rec := call(http.MethodGet, user, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("digest read by %q: %d", user, rec.Code)
+		}
+	}
+	if rec := call(http.MethodPut, "alice", `{"enabled": true, "interval": "2h"}`); rec.Code != http.StatusOK {
+		t.Fatalf("update digest: %d %s", rec.Code, rec.Body)
+	}
+	if settings, _ := srv.db.GetDigestSettings(t.Context(), "alice"); !settings.Enabled || settings.Interval != 2*time.Hour {
+		t.Errorf("digest settings: %+v", settings)
+	}
+}
+
+func TestEmailBounces(t *testing.T) {
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.config.MailWebhookToken = "hook-token"
+	handler := srv.Handler()
+
+	user, err := srv.db.CreateUser(t.Context(), "Alice", "correct-horse-42", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, _ := srv.db.GetDigestSettings(t.Context(), user.ID)
+	settings.Enabled = true
+	srv.db.UpdateDigestSettings(t.Context(), settings)
+
+	notify := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/email/bounces/"+path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := notify("sendgrid?token=wrong", `[]`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", rec.Code)
+	}
+	if rec := notify("postmark?token=hook-token", `[]`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: %d", rec.Code)
+	}
+
+	// Временная недоставка дайджесты не выключает
+	rec := notify("sendgrid?token=hook-token", `[{"email":"alice@example.com","event":"bounce","type":"blocked","reason":"try later"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bounce notification: %d %s", rec.Code, rec.Body)
+	}
+	if settings, _ := srv.db.GetDigestSettings(t.Context(), user.ID); !settings.Enabled {
+		t.Error("digests disabled by a temporary bounce")
+	}
+
+	rec = notify("mailgun?token=hook-token", `{"event-data":{"event":"failed","severity":"permanent","recipient":"Alice@example.com","delivery-status":{"description":"No such user"}}}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"bounces":1`) {
+		t.Fatalf("bounce notification: %d %s", rec.Code, rec.Body)
+	}
+	if settings, _ := srv.db.GetDigestSettings(t.Context(), user.ID); settings.Enabled {
+		t.Error("digests still enabled after a permanent bounce")
+	}
+}
+
+func TestSMSDeliveryReports(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id": "msg-1"})
+	}))
+	defer gateway.Close()
+
+	srv, cleanup := setupTestServer()
+	defer cleanup()
+	srv.config.SMSProvider = "gateway"
+	srv.config.SMSAPIURL = gateway.URL
+	srv.config.SMSCallbackToken = "sms-token"
+	srv.smsProvider = newSMSProvider(srv.config)
+	handler := srv.Handler()
+
+	if err := srv.sendSMS("+79990001122", "code 123456"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := srv.db.GetSMS(t.Context(), "gateway", "msg-1")
+	if err != nil || msg.Recipient != "+79990001122" || msg.Status != sms.StatusQueued {
+		t.Fatalf("recorded SMS = %+v, %v", msg, err)
+	}
+
+	report := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sms/status/"+path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	delivered := `{"event":"sms:delivered","payload":{"messageId":"msg-1"}}`
+
+	if rr := report("gateway?token=wrong", delivered); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", rr.Code)
+	}
+	if rr := report("twilio?token=sms-token", delivered); rr.Code != http.StatusNotFound {
+		t.Errorf("other provider: status %d", rr.Code)
+	}
+	if rr := report("gateway?token=sms-token", "not json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid report: status %d", rr.Code)
+	}
+	if rr := report("gateway?token=sms-token", delivered); rr.Code != http.StatusOK {
+		t.Fatalf("report: status %d: %s", rr.Code, rr.Body.String())
+	}
+	msg, _ = srv.db.GetSMS(t.Context(), "gateway", "msg-1")
+	if msg.Status != sms.StatusDelivered {
+		t.Errorf("status = %s, want delivered", msg.Status)
+	}
+
+	// Запоздавший отчет не меняет окончательное состояние
+	report("gateway?token=sms-token", `{"event":"sms:sent","payload":{"messageId":"msg-1"}}`)
+	if msg, _ = srv.db.GetSMS(t.Context(), "gateway", "msg-1"); msg.Status != sms.StatusDelivered {
+		t.Errorf("final status changed to %s", msg.Status)
 	}
 }
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/signaling"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Сессии входа. При входе и регистрации клиент получает пару токенов: токен доступа
+// (SESSION_TTL) и токен обновления (SESSION_REFRESH_TTL). По истечении токена доступа
+// клиент обменивает токен обновления на новую пару (POST /api/auth/refresh), при
+// выходе сессия завершается (POST /api/auth/logout). Устройство клиента передается
+// заголовком X-Device-ID.
+//
+// Токен доступа сессии принимается в Authorization наравне с токеном сигнализации.
+// Пользователь видит свои сессии (GET /api/sessions) и завершает отдельные
+// (DELETE /api/sessions/{id}) или все, кроме текущей (DELETE /api/sessions). Токены
+// сигнализации не привязаны к сессии, поэтому при завершении сессий отзываются все
+// токены сигнализации пользователя, выданные раньше: остальные устройства получают новый,
+// обновив сессию. Ретранслятор сигнализации отзыв не проверяет - отозванный токен
+// действует в нем до истечения (SIGNALING_TOKEN_TTL).
+
+// maxUserAgent - длина сохраняемого в сессии User-Agent
+const maxUserAgent = 256
+
+// errTokenRevoked - токен сигнализации выдан до завершения сессий пользователя
+var errTokenRevoked = errors.New("token revoked")
+
+// bearerSession возвращает пользователя по токену входа из заголовка Authorization и
+// сессию, если это токен доступа сессии
+func (s *Server) bearerSession(r *http.Request) (string, *storage.Session, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.db == nil {
+		userID, err := signaling.VerifyToken(s.signalingSecret, token)
+		return userID, nil, err
+	}
+
+	var userID string
+	var sess *storage.Session
+	if token != "" && !strings.Contains(token, ".") {
+		// Токен доступа сессии: завершенная сессия не найдется
+		var err error
+		if sess, err = s.db.ValidateSession(r.Context(), token, sessionClient(r)); err != nil {
+			return "", nil, err
+		}
+		userID = sess.UserID
+	} else {
+		id, expires, err := signaling.VerifyTokenExpiry(s.signalingSecret, token)
+		if err != nil {
+			return "", nil, err
+		}
+		// Время выдачи токена - истечение минус срок действия
+		revokedAt, err := s.db.TokensRevokedAt(r.Context(), id)
+		if err != nil {
+			return "", nil, err
+		}
+		if expires.Add(-s.signalingTTL()).Before(revokedAt) {
+			return "", nil, errTokenRevoked
+		}
+		userID = id
+	}
+
+	// Токены заблокированного аккаунта перестают действовать сразу, не дожидаясь истечения
+	disabled, err := s.db.GetDisabledAccount(r.Context(), userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if disabled != nil {
+		return "", nil, errAccountDisabled
+	}
+	return userID, sess, nil
+}
+
+// sessionClient возвращает клиента, от которого пришел запрос
+func sessionClient(r *http.Request) storage.SessionClient {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgent {
+		ua = strings.ToValidUTF8(ua[:maxUserAgent], "")
+	}
+	return storage.SessionClient{IP: clientIP(r), UserAgent: ua}
+}
+
+// revokeTokens отзывает токены сигнализации пользователя, выданные до этого момента.
+// Время округляется до секунды: с такой точностью известно время выдачи токена.
+func (s *Server) revokeTokens(ctx context.Context, userID string) error {
+	return s.db.RevokeTokens(ctx, userID, time.Now().Truncate(time.Second))
+}
+
+// issueSession открывает сессию для пользователя, вошедшего запросом r. Ошибка не
+// мешает входу: клиент получает ответ без сессии.
+func (s *Server) issueSession(r *http.Request, userID string) *storage.Session {
+	ttl, refreshTTL := s.sessionTTLs()
+	sess, err := s.db.CreateSession(r.Context(), userID, r.Header.Get("X-Device-ID"), sessionClient(r), ttl, refreshTTL)
+	if err != nil {
+		log.Printf("Failed to create session for %s: %v", userID, err)
+		return nil
+	}
+	return sess
+}
+
+// sessionTTLs возвращает сроки действия токена доступа и токена обновления
+func (s *Server) sessionTTLs() (time.Duration, time.Duration) {
+	ttl, refreshTTL := s.config.SessionTTL, s.config.SessionRefreshTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+	return ttl, refreshTTL
+}
+
+// handleAuthRefresh обрабатывает POST /api/auth/refresh {refresh_token}: новая пара
+// токенов сессии; прежний токен обновления больше не принимается
+func (s *Server) handleAuthRefresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+
+	ttl, refreshTTL := s.sessionTTLs()
+	sess, err := s.db.RefreshSession(r.Context(), req.RefreshToken, sessionClient(r), ttl, refreshTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid or expired refresh token"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"session":   sess,
+		"signaling": s.signalingSession(sess.UserID),
+	})
+}
+
+// handleAuthLogout обрабатывает POST /api/auth/logout с токеном доступа в заголовке
+// Authorization: сессия завершается
+func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	sess, err := s.db.ValidateSession(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), sessionClient(r))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+	if _, err := s.db.RevokeSession(r.Context(), sess.UserID, sess.ID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke session"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// sessionView - сессия в списке сессий пользователя
+type sessionView struct {
+	*storage.Session
+	Current bool `json:"current,omitempty"` // сессия, с которой пришел запрос
+}
+
+// handleSessions обрабатывает /api/sessions: GET - сессии пользователя (устройство,
+// адрес и User-Agent клиента, последняя активность), DELETE - завершение всех сессий,
+// кроме текущей; запрос должен прийти с токеном доступа сессии
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, current, err := s.bearerSession(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.db.ListSessions(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to list sessions"})
+			return
+		}
+		// С токеном сигнализации текущая сессия определяется по устройству (X-Device-ID)
+		device := r.Header.Get("X-Device-ID")
+		views := make([]sessionView, len(sessions))
+		for i, sess := range sessions {
+			isCurrent := current != nil && sess.ID == current.ID
+			if current == nil && device != "" {
+				isCurrent = sess.DeviceID == device
+			}
+			views[i] = sessionView{Session: sess, Current: isCurrent}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "sessions": views})
+
+	case http.MethodDelete:
+		if current == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Session token required"})
+			return
+		}
+		n, err := s.db.RevokeOtherSessions(r.Context(), userID, current.ID)
+		if err == nil {
+			err = s.revokeTokens(r.Context(), userID)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke sessions"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"revoked":   n,
+			"signaling": s.signalingSession(userID),
+		})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
+
+// handleSessionRevoke обрабатывает DELETE /api/sessions/{id}: завершение сессии
+// пользователя. Ответ содержит новый токен сигнализации: прежние отозваны.
+func (s *Server) handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	revoked, err := s.db.RevokeSession(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke session"})
+		return
+	}
+	if !revoked {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Session not found"})
+		return
+	}
+	if err := s.revokeTokens(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to revoke tokens"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "signaling": s.signalingSession(userID)})
+}
+
+// runSessionCleanup периодически удаляет сессии с истекшим токеном обновления и
+// неиспользованные приглашения привязать устройство
+func (s *Server) runSessionCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.db.DeleteExpiredSessions(context.Background(), time.Now()); err != nil {
+			log.Printf("Expired sessions cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d expired sessions", n)
+		}
+		if _, err := s.db.DeleteExpiredDeviceLinks(context.Background(), time.Now()); err != nil {
+			log.Printf("Expired device links cleanup failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
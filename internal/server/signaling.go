@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/rand"
+	"hydra/internal/config"
+	"hydra/pkg/signaling"
+	"log"
+	"time"
+)
+
+// signalingPath - путь встроенного ретранслятора сигнализации
+const signalingPath = "/api/signal/"
+
+// newSignaling возвращает секрет токенов сигнализации и встроенный ретранслятор.
+// Если задан SIGNALING_URL, сигнализацию обслуживает отдельный ретранслятор и встроенный не нужен.
+func newSignaling(cfg *config.Config) ([]byte, *signaling.Relay) {
+	secret := []byte(cfg.SignalingSecret)
+	if len(secret) == 0 {
+		if cfg.SignalingURL != "" {
+			log.Printf("Warning: SIGNALING_SECRET is empty, tokens will not be accepted by the signaling relay at %s", cfg.SignalingURL)
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("failed to generate signaling secret: %v", err)
+		}
+	}
+	if cfg.SignalingURL != "" {
+		return secret, nil
+	}
+	return secret, signaling.NewRelay(secret)
+}
+
+// signalingSession - адрес ретранслятора сигнализации и токен доступа пользователя,
+// передаются клиенту при входе
+func (s *Server) signalingSession(userID string) map[string]interface{} {
+	url := s.config.SignalingURL
+	if url == "" {
+		url = signalingPath
+	}
+	ttl := s.signalingTTL()
+	return map[string]interface{}{
+		"url":        url,
+		"token":      signaling.IssueToken(s.signalingSecret, userID, ttl),
+		"expires_in": int(ttl.Seconds()),
+	}
+}
+
+// signalingTTL возвращает срок действия выдаваемых токенов сигнализации
+func (s *Server) signalingTTL() time.Duration {
+	if s.config.SignalingTokenTTL <= 0 {
+		return 12 * time.Hour
+	}
+	return s.config.SignalingTokenTTL
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hydra/internal/config"
+	"hydra/pkg/sms"
+	"hydra/pkg/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const smsSendTimeout = 30 * time.Second
+
+// newSMSProvider создает отправителя SMS по SMS_PROVIDER; nil - провайдер неизвестен
+func newSMSProvider(cfg *config.Config) sms.Provider {
+	callback := smsCallbackURL(cfg)
+	switch cfg.SMSProvider {
+	case "", "console":
+		return sms.Console{}
+	case "http":
+		return &sms.HTTP{URL: cfg.SMSAPIURL, Key: cfg.SMSAPIKey}
+	case "twilio":
+		return &sms.Twilio{AccountSID: cfg.SMSAPIKey, AuthToken: cfg.SMSAPISecret, From: cfg.SMSFrom, CallbackURL: callback, Endpoint: cfg.SMSAPIURL}
+	case "vonage":
+		return &sms.Vonage{APIKey: cfg.SMSAPIKey, APISecret: cfg.SMSAPISecret, From: cfg.SMSFrom, CallbackURL: callback, Endpoint: cfg.SMSAPIURL}
+	case "smsc":
+		return &sms.SMSC{Login: cfg.SMSAPIKey, Password: cfg.SMSAPISecret, Sender: cfg.SMSFrom, Endpoint: cfg.SMSAPIURL}
+	case "gateway":
+		return &sms.Gateway{URL: cfg.SMSAPIURL, User: cfg.SMSAPIKey, Password: cfg.SMSAPISecret}
+	}
+	log.Printf("Warning: unknown SMS provider: %s", cfg.SMSProvider)
+	return nil
+}
+
+// smsCallbackURL возвращает адрес отчетов о доставке для провайдера; пусто - отчеты не принимаются
+func smsCallbackURL(cfg *config.Config) string {
+	if cfg.SMSCallbackToken == "" {
+		return ""
+	}
+	return strings.TrimRight(cfg.PublicURL, "/") + apiPrefixV1 + "/sms/status/" + cfg.SMSProvider +
+		"?token=" + url.QueryEscape(cfg.SMSCallbackToken)
+}
+
+// sendSMS отправляет SMS и запоминает его для отчетов о доставке
+func (s *Server) sendSMS(to, message string) error {
+	if s.smsProvider == nil {
+		return fmt.Errorf("unknown SMS provider: %s", s.config.SMSProvider)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), smsSendTimeout)
+	defer cancel()
+
+	id, err := s.smsProvider.Send(ctx, to, message)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		msg := &storage.SMSMessage{Provider: s.config.SMSProvider, ProviderID: id, Recipient: to, Status: sms.StatusQueued}
+		if err := s.db.RecordSMS(ctx, msg); err != nil {
+			log.Printf("Failed to record SMS %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// handleSMSStatus обрабатывает /api/sms/status/{provider}?token=...: отчеты службы
+// рассылки о доставке SMS
+func (s *Server) handleSMSStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	token := r.URL.Query().Get("token")
+	if s.config.SMSCallbackToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.SMSCallbackToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unauthorized"})
+		return
+	}
+
+	provider := r.PathValue("provider")
+	callbacks, ok := s.smsProvider.(sms.Callbacks)
+	if !ok || provider != s.config.SMSProvider {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Unknown SMS provider"})
+		return
+	}
+
+	reports, err := callbacks.ParseCallback(r)
+	if err != nil {
+		log.Printf("Invalid SMS delivery report: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid delivery report"})
+		return
+	}
+	updated := 0
+	for _, report := range reports {
+		ok, err := s.db.UpdateSMSStatus(r.Context(), provider, report.MessageID, report.Status, report.Error)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to update SMS status"})
+			return
+		}
+		if ok {
+			updated++
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "updated": updated})
+}
+
+// runSMSRetention удаляет сведения о SMS старше SMS_RETENTION
+func (s *Server) runSMSRetention() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := s.db.DeleteSMSBefore(context.Background(), time.Now().Add(-s.config.SMSRetention)); err != nil {
+			log.Printf("SMS records cleanup failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
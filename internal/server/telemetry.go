@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/telemetry"
+	"log"
+	"net/http"
+	"time"
+)
+
+// runTelemetry раз в TELEMETRY_INTERVAL отправляет искаженный отчет о транспортах узла
+// на TELEMETRY_URL. Отчет за период отправляется один раз; при ошибке он теряется, а не
+// повторяется, чтобы повторы не ослабляли искажение.
+func (s *Server) runTelemetry() {
+	if s.config.TelemetryURL == "" || s.config.TelemetryRegion == "" {
+		log.Printf("Warning: telemetry enabled but TELEMETRY_URL or TELEMETRY_REGION is not set, reports are not sent")
+		return
+	}
+	epsilon := min(max(s.config.TelemetryEpsilon, telemetry.MinEpsilon), telemetry.MaxEpsilon)
+
+	ticker := time.NewTicker(s.config.TelemetryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report := s.usage.Report(s.config.TelemetryRegion, epsilon)
+		if err := sendTelemetry(s.config.TelemetryURL, report); err != nil {
+			log.Printf("Telemetry report not sent: %v", err)
+		}
+	}
+}
+
+func sendTelemetry(url string, report *telemetry.Report) error {
+	data, _ := json.Marshal(report)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleTelemetry принимает искаженный отчет узла: POST /api/telemetry (telemetry.Report).
+// Адрес отправителя не сохраняется и используется только для ограничения частоты.
+func (s *Server) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	if !s.reportLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Too many reports"})
+		return
+	}
+
+	var report telemetry.Report
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&report); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if err := s.telemetry.Add(&report, time.Now()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleAdminTelemetry показывает оценки по отчетам узлов региона: GET /api/admin/telemetry?region=RU
+func (s *Server) handleAdminTelemetry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"region":      region,
+		"estimates":   s.telemetry.Estimates(region, time.Now()),
+		"min_reports": s.config.TelemetryMinReports,
+		"reporting":   s.usage != nil,
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Одноразовые билеты для подключения WebSocket (и EventSource). Браузер не может передать
+// заголовок Authorization при открытии WebSocket, а токен в URL оседает в логах прокси. Поэтому клиент
+// получает через HTTP API короткоживущий билет (POST /api/ws/ticket с токеном входа в заголовке)
+// и передает в URL только его; билет погашается при подключении. Пока соединение открыто,
+// сервер присылает по нему свежий билет для переподключения ({"type": "ticket", ...}).
+
+// defaultTicketTTL - срок жизни билета, если WS_TICKET_TTL не задан
+const defaultTicketTTL = 30 * time.Second
+
+type ticket struct {
+	userID  string
+	expires time.Time
+}
+
+// ticketStore хранит выданные и еще не погашенные билеты
+type ticketStore struct {
+	mu      sync.Mutex
+	tickets map[string]ticket
+}
+
+func newTicketStore() *ticketStore {
+	return &ticketStore{tickets: make(map[string]ticket)}
+}
+
+// mint выдает билет пользователя, действующий ttl
+func (ts *ticketStore) mint(userID string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := time.Now()
+	for key, t := range ts.tickets {
+		if now.After(t.expires) {
+			delete(ts.tickets, key)
+		}
+	}
+	ts.tickets[id] = ticket{userID: userID, expires: now.Add(ttl)}
+	return id, nil
+}
+
+// consume погашает билет и возвращает его владельца; повторное использование не проходит
+func (ts *ticketStore) consume(id string) (string, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, exists := ts.tickets[id]
+	if !exists {
+		return "", false
+	}
+	delete(ts.tickets, id)
+	if time.Now().After(t.expires) {
+		return "", false
+	}
+	return t.userID, true
+}
+
+// revoke отзывает неиспользованный билет
+func (ts *ticketStore) revoke(id string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.tickets, id)
+}
+
+func (s *Server) ticketTTL() time.Duration {
+	if s.config.WSTicketTTL > 0 {
+		return s.config.WSTicketTTL
+	}
+	return defaultTicketTTL
+}
+
+// handleWSTicket выдает билет подключения WebSocket.
+// POST с заголовком "Authorization: Bearer <токен>" (токен, выданный при входе)
+func (s *Server) handleWSTicket(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	userID, err := s.bearerUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	id, err := s.tickets.mint(userID, s.ticketTTL())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to issue ticket"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"ticket":     id,
+		"expires_in": int(s.ticketTTL().Seconds()),
+	})
+}
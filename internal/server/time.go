@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleTime возвращает подписанное серверное время.
+// Клиент передает nonce и (необязательно) свое время client_time в миллисекундах,
+// в ответ получает смещение своих часов относительно сервера.
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	signed := s.timeSigner.Sign(r.URL.Query().Get("nonce"))
+
+	response := map[string]interface{}{
+		"success":           true,
+		"time":              signed,
+		"skew_tolerance_ms": s.config.ClockSkewTolerance.Milliseconds(),
+	}
+
+	if clientTime, err := strconv.ParseInt(r.URL.Query().Get("client_time"), 10, 64); err == nil {
+		// Поправка, которую клиент должен прибавлять к своим часам
+		response["clock_offset_ms"] = signed.ServerTime - clientTime
+		response["within_tolerance"] = time.Duration(abs64(signed.ServerTime-clientTime))*time.Millisecond <= s.config.ClockSkewTolerance
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeSigningKey разбирает hex seed ключа подписи; пустая строка дает nil (случайный ключ)
+func decodeSigningKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	seed, err := hex.DecodeString(key)
+	if err != nil {
+		return []byte(key)
+	}
+	return seed
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve обслуживает handler на addr: по HTTPS, если настроен сертификат (TLS_CERT_FILE и
+// TLS_KEY_FILE или ACME_DOMAINS), иначе по HTTP
+func (s *Server) serve(addr string, handler http.Handler) error {
+	tlsConfig, challenges, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		log.Printf("Web Interface started at http://localhost%s", addr)
+		return http.ListenAndServe(addr, handler)
+	}
+
+	if s.config.HTTPRedirectAddr != "" {
+		redirect := httpsRedirect(addr)
+		if challenges != nil {
+			redirect = challenges(redirect)
+		}
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", s.config.HTTPRedirectAddr)
+			if err := http.ListenAndServe(s.config.HTTPRedirectAddr, redirect); err != nil {
+				log.Printf("HTTP redirect stopped: %v", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	log.Printf("Web Interface started at https://localhost%s", addr)
+	return srv.ListenAndServeTLS("", "")
+}
+
+// tlsConfig возвращает настройки TLS; nil - TLS выключен. challenges оборачивает
+// обработчик HTTP, чтобы он отвечал на проверки ACME http-01 (nil - сертификат из файлов).
+func (s *Server) tlsConfig() (*tls.Config, func(http.Handler) http.Handler, error) {
+	cfg := s.config
+	if len(cfg.ACMEDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler, nil
+	}
+
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil, nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, nil, errors.New("both TLS_CERT_FILE and TLS_KEY_FILE are required")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil, nil
+}
+
+// httpsRedirect перенаправляет запросы на тот же адрес по HTTPS (порт tlsAddr)
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		// 308 сохраняет метод и тело запроса, 301 понимают и старые клиенты
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"hydra/pkg/storage"
+	"hydra/pkg/transport/fronting"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// transportEventRetention - сколько хранится история сбоев фронтов
+const transportEventRetention = 30 * 24 * time.Hour
+
+// recordTransportEvent сохраняет сбой или восстановление фронта в историю
+func (s *Server) recordTransportEvent(event fronting.FrontEvent) {
+	err := s.db.CreateTransportEvent(context.Background(), &storage.TransportEvent{
+		Domain:    event.Domain,
+		Class:     event.Class,
+		Error:     event.Error,
+		CreatedAt: event.Time,
+	})
+	if err != nil {
+		log.Printf("Failed to record transport event for %s: %v", event.Domain, err)
+	}
+}
+
+// runFrontAvoidance периодически пересчитывает стабильно блокируемые фронты по истории
+// и передает их пулу, чтобы не тратить попытки на домены, заблокированные в регионе.
+func (s *Server) runFrontAvoidance() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		s.updateAvoidedFronts()
+		if err := s.db.DeleteTransportEventsBefore(context.Background(), time.Now().Add(-transportEventRetention)); err != nil {
+			log.Printf("Transport events cleanup failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (s *Server) updateAvoidedFronts() {
+	stats, err := s.db.GetDomainBlockStats(context.Background(), time.Now().Add(-s.config.FrontBlockWindow))
+	if err != nil {
+		log.Printf("Failed to load front block history: %v", err)
+		return
+	}
+
+	var avoided []string
+	for _, st := range stats {
+		if st.Blocks >= s.config.FrontBlockLimit {
+			avoided = append(avoided, st.Domain)
+		}
+	}
+	// Фронты, о блокировке которых сообщают клиенты из того же региона
+	avoided = append(avoided, s.regionBlockedFronts()...)
+	s.transportManager.FrontPool().SetAvoided(avoided)
+	if len(avoided) > 0 {
+		log.Printf("Fronts consistently blocked in this region: %v", avoided)
+	}
+}
+
+// handleTransportEvents возвращает историю сбоев фронтов (?domain=, ?limit=). Только
+// администратор: по ней цензор узнал бы, какие фронты еще работают.
+func (s *Server) handleTransportEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	events, err := s.db.ListTransportEvents(r.Context(), r.URL.Query().Get("domain"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load transport events"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "events": events})
+}
+
+// handleTransportBlocks возвращает сводку блокировок по доменам за окно истории
+// и список фронтов, которых пул избегает. Только администратор.
+func (s *Server) handleTransportBlocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	stats, err := s.db.GetDomainBlockStats(r.Context(), time.Now().Add(-s.config.FrontBlockWindow))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load block history"})
+		return
+	}
+
+	avoided := []string{}
+	for _, st := range s.transportManager.FrontPool().Status() {
+		if st.Avoided {
+			avoided = append(avoided, st.Domain)
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"window":  s.config.FrontBlockWindow.String(),
+		"limit":   s.config.FrontBlockLimit,
+		"domains": stats,
+		"avoided": avoided,
+	})
+}
+
+// handleMeshTopology возвращает топологию mesh сети, известную узлу (в т.ч. изученную через PEX)
+func (s *Server) handleMeshTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	meshTransport := s.transportManager.Mesh()
+	if meshTransport == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Mesh transport is not available"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "topology": meshTransport.Topology()})
+}
+
+// handleMeshConnect устанавливает прямое соединение с узлом mesh, изученным через PEX
+// (для узлов за NAT - пробиванием NAT). POST {"node_id": "..."}. Только администратор.
+func (s *Server) handleMeshConnect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		NodeID string `json:"node_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.NodeID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "node_id is required"})
+		return
+	}
+
+	meshTransport := s.transportManager.Mesh()
+	if meshTransport == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Mesh transport is not available"})
+		return
+	}
+	if err := meshTransport.ConnectPeer(req.NodeID); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// handleMeshResources обрабатывает /api/mesh/resources: GET - ограничения и использование
+// ресурсов на ретрансляцию, PUT {max_queue_bytes, max_relay_bandwidth, battery_saver} -
+// изменение ограничений (например, включение экономии батареи на телефоне), только
+// администратор
+func (s *Server) handleMeshResources(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPut && !s.requireAdmin(w, r) {
+		return
+	}
+
+	meshTransport := s.transportManager.Mesh()
+	if meshTransport == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Mesh transport is not available"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "resources": meshTransport.ResourceStatus()})
+
+	case http.MethodPut:
+		var req struct {
+			MaxQueueBytes     *int  `json:"max_queue_bytes"`
+			MaxRelayBandwidth *int  `json:"max_relay_bandwidth"`
+			BatterySaver      *bool `json:"battery_saver"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+			return
+		}
+
+		limits := meshTransport.ResourceStatus().ResourceLimits
+		if req.MaxQueueBytes != nil {
+			limits.MaxQueueBytes = *req.MaxQueueBytes
+		}
+		if req.MaxRelayBandwidth != nil {
+			limits.MaxRelayBandwidth = *req.MaxRelayBandwidth
+		}
+		if req.BatterySaver != nil {
+			limits.BatterySaver = *req.BatterySaver
+		}
+		meshTransport.SetResourceLimits(limits)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "resources": meshTransport.ResourceStatus()})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
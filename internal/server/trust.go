@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/internal/config"
+	"hydra/pkg/challenge"
+	"hydra/pkg/ratelimit"
+	"hydra/pkg/trust"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newTrustPolicy собирает правила доверия из конфигурации
+func newTrustPolicy(cfg *config.Config) *trust.Policy {
+	policy := &trust.Policy{
+		InviterMinLevel: trust.Verified,
+		InviteDecay:     cfg.TrustInviteDecay,
+		PromoteAfter:    cfg.TrustPromoteAfter,
+		ChallengeBelow:  trust.Invited,
+		SendPerMinute: map[trust.Level]int{
+			trust.Unknown:  cfg.TrustSendRateUnknown,
+			trust.Invited:  cfg.TrustSendRateInvited,
+			trust.Verified: cfg.TrustSendRateVerified,
+		},
+	}
+
+	if level, err := trust.ParseLevel(cfg.TrustInviterMinLevel); err == nil {
+		policy.InviterMinLevel = level
+	} else if cfg.TrustInviterMinLevel != "" {
+		log.Printf("Warning: invalid TRUST_INVITER_MIN_LEVEL (%v), using %s", err, policy.InviterMinLevel)
+	}
+	if level, err := trust.ParseLevel(cfg.TrustChallengeBelow); err == nil {
+		policy.ChallengeBelow = level
+	} else if cfg.TrustChallengeBelow != "" {
+		log.Printf("Warning: invalid TRUST_CHALLENGE_BELOW (%v), using %s", err, policy.ChallengeBelow)
+	}
+	return policy
+}
+
+// newSendLimiters создает ограничители отправки для уровней с ненулевым лимитом
+func newSendLimiters(policy *trust.Policy) map[trust.Level]*ratelimit.Limiter {
+	limiters := make(map[trust.Level]*ratelimit.Limiter)
+	for level, perMinute := range policy.SendPerMinute {
+		if perMinute > 0 {
+			limiters[level] = ratelimit.New(perMinute, perMinute)
+		}
+	}
+	return limiters
+}
+
+// userTrust возвращает действующий уровень доверия пользователя. Пользователи без
+// записи (неизвестный отправитель или аккаунт до появления уровней) считаются unknown.
+func (s *Server) userTrust(userID string) trust.Level {
+	if s.db == nil || userID == "" {
+		return trust.Unknown
+	}
+	record, err := s.db.GetUserTrust(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to load trust level of %s: %v", userID, err)
+		return trust.Unknown
+	}
+	if record == nil {
+		return trust.Unknown
+	}
+	return s.trust.Effective(trust.Level(record.Level), record.UpdatedAt, time.Now())
+}
+
+// inviteeTrust возвращает уровень, который получит пользователь по приглашению inviterID
+func (s *Server) inviteeTrust(inviterID string) trust.Level {
+	return s.trust.InviteeLevel(s.userTrust(inviterID), inviterID != "")
+}
+
+// saveTrust сохраняет уровень нового пользователя
+func (s *Server) saveTrust(userID string, level trust.Level, invitedBy string) {
+	if err := s.db.SetUserTrust(context.Background(), userID, int(level), invitedBy); err != nil {
+		log.Printf("Failed to save trust level of %s: %v", userID, err)
+	}
+}
+
+// allowSend проверяет лимит отправки сообщений для уровня доверия отправителя
+func (s *Server) allowSend(userID string) bool {
+	limiter := s.sendLimiters[s.userTrust(userID)]
+	if limiter == nil {
+		return true
+	}
+	key := userID
+	if key == "" {
+		key = "anonymous"
+	}
+	return limiter.Allow(key)
+}
+
+// newChallengeProvider выбирает испытание перед регистрацией и отправкой кодов по
+// CHALLENGE_PROVIDER; nil - испытания выключены. Подпись испытаний pow выводится из secret.
+func newChallengeProvider(cfg *config.Config, secret []byte) challenge.Provider {
+	provider := cfg.ChallengeProvider
+	if provider == "" {
+		provider = challenge.KindPoW
+		if cfg.CaptchaVerifyURL != "" {
+			provider = challenge.KindCaptcha
+		}
+	}
+
+	switch provider {
+	case "none":
+		return nil
+	case challenge.KindCaptcha:
+		if cfg.CaptchaVerifyURL == "" {
+			log.Printf("Warning: CHALLENGE_PROVIDER is captcha but CAPTCHA_VERIFY_URL is empty, challenges disabled")
+			return nil
+		}
+		return &challenge.SiteVerify{URL: cfg.CaptchaVerifyURL, Secret: cfg.CaptchaSecret, SiteKey: cfg.CaptchaSiteKey}
+	case challenge.KindPoW:
+	default:
+		log.Printf("Warning: unknown CHALLENGE_PROVIDER %q, using %s", provider, challenge.KindPoW)
+	}
+	ttl := cfg.ChallengeTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return challenge.NewProofOfWork(secret, cfg.ChallengePoWBits, ttl)
+}
+
+// passChallenge требует ответ на испытание (доказательство работы или CAPTCHA) от
+// регистраций и отправки кодов с уровнем ниже TRUST_CHALLENGE_BELOW. Если испытания
+// выключены, неизвестные пользователи ограничиваются только лимитами. При отказе ответ
+// с новым испытанием уже записан в w.
+func (s *Server) passChallenge(w http.ResponseWriter, r *http.Request, level trust.Level, response string) bool {
+	if !s.trust.NeedsChallenge(level) || s.challenge == nil {
+		return true
+	}
+
+	err := s.challenge.Verify(r.Context(), response, clientIP(r))
+	if err == nil {
+		return true
+	}
+	message := "Challenge required"
+	if !errors.Is(err, challenge.ErrMissing) {
+		log.Printf("Challenge verification failed: %v", err)
+		message = "Challenge verification failed"
+	}
+	issued, err := s.challenge.Issue()
+	if err != nil {
+		log.Printf("Failed to issue challenge: %v", err)
+	}
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": message, "challenge_required": true, "challenge": issued})
+	return false
+}
+
+// handleChallenge обрабатывает GET /api/challenge: испытание, ответ на которое нужен для
+// регистрации и отправки кода подтверждения (kind none - испытания выключены)
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.challenge == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "challenge": challenge.Challenge{Kind: "none"}})
+		return
+	}
+	issued, err := s.challenge.Issue()
+	if err != nil {
+		log.Printf("Failed to issue challenge: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to issue challenge"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "challenge": issued})
+}
+
+// handleAdminTrust обрабатывает /api/admin/trust/{user_id}: GET - уровень доверия,
+// PUT {level} - ручная установка уровня оператором (например, проверка участника)
+func (s *Server) handleAdminTrust(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	userID := strings.TrimPrefix(r.URL.Path, "/api/admin/trust/")
+	if userID == "" || strings.Contains(userID, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "User not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		record, err := s.db.GetUserTrust(r.Context(), userID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to load trust level"})
+			return
+		}
+		response := map[string]interface{}{"success": true, "level": s.userTrust(userID)}
+		if record != nil {
+			response["stored_level"] = trust.Level(record.Level)
+			response["invited_by"] = record.InvitedBy
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPut:
+		var req struct {
+			Level trust.Level `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid level"})
+			return
+		}
+
+		if err := s.db.SetUserTrust(r.Context(), userID, int(req.Level), ""); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Failed to save trust level"})
+			return
+		}
+		log.Printf("Trust level of %s set to %s", userID, req.Level)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "level": req.Level})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"hydra/pkg/i18n"
+	"hydra/pkg/validate"
+	"net/http"
+)
+
+// writeValidationErrors отвечает 400 с ошибками полей запроса err (validate.Errors).
+// Тексты ошибок полей переводятся на язык клиента.
+func (s *Server) writeValidationErrors(w http.ResponseWriter, r *http.Request, err error) {
+	var errs validate.Errors
+	errors.As(err, &errs)
+	locale := s.requestLocale(r)
+	fields := make([]validate.Error, len(errs))
+	for i, e := range errs {
+		fields[i] = *e
+		fields[i].Message = i18n.Translate(locale, e.Message)
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid input", "fields": fields})
+}
+
+// normalizeLogin приводит email или телефон для входа к виду, в котором он хранится;
+// значение, которое не удается разобрать, возвращается как есть
+func normalizeLogin(contact string) string {
+	if normalized, err := validate.Contact(contact); err == nil {
+		return normalized
+	}
+	return contact
+}
+
+// inviteContact проверяет email и телефон приглашенного и возвращает контакт
+// приглашения: email, если указан, иначе телефон; пусто - не указано ни то, ни другое
+func inviteContact(email, phone string) (string, error) {
+	var errs validate.Errors
+	var err error
+	if email != "" {
+		email, err = validate.Email(email)
+		errs.Check("email", err)
+	}
+	if phone != "" {
+		phone, err = validate.Phone(phone)
+		errs.Check("phone", err)
+	}
+	if err := errs.Err(); err != nil {
+		return "", err
+	}
+	if email != "" {
+		return email, nil
+	}
+	return phone, nil
+}
+
+// validateNewAccount проверяет имя и пароль нового аккаунта с контактом contact и
+// возвращает очищенное имя
+func validateNewAccount(name, password, contact string) (string, error) {
+	var errs validate.Errors
+	name, err := validate.Name(name)
+	errs.Check("name", err)
+	errs.Check("password", validate.Password(password, name, contact))
+	return name, errs.Err()
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/storage"
+	"io"
+	"sync"
+)
+
+// Холодное хранение истории бесед. Сообщения давно неактивной беседы упаковываются в
+// архив (gzip со строками JSON, по сообщению на строку) и отправляются в холодное
+// хранилище: локальный каталог или S3-совместимый бакет с дешевым классом хранения.
+// Когда пользователь листает историю назад дальше сообщений в базе, архив читается
+// обратно ("регидратация") и недавно прочитанные архивы держатся в памяти.
+
+// Backend - холодное хранилище архивов
+type Backend interface {
+	Put(key string, r io.Reader) (int64, error)
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Key возвращает ключ объекта архива в хранилище
+func Key(archiveID string) string {
+	return "archives/" + archiveID + ".jsonl.gz"
+}
+
+// Pack упаковывает сообщения в сжатый архив
+func Pack(messages []*storage.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, msg := range messages {
+		if err := enc.Encode(msg); err != nil {
+			return nil, fmt.Errorf("failed to encode archived message: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unpack читает сообщения архива в порядке упаковки
+func Unpack(r io.Reader) ([]*storage.Message, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	var messages []*storage.Message
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	for dec.More() {
+		msg := &storage.Message{}
+		if err := dec.Decode(msg); err != nil {
+			return nil, fmt.Errorf("failed to decode archived message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Local хранит архивы в локальном каталоге через blob-хранилище
+type Local struct {
+	blobs *blobstore.Store
+}
+
+func NewLocal(root string) (*Local, error) {
+	blobs, err := blobstore.New(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{blobs: blobs}, nil
+}
+
+func (l *Local) Put(key string, r io.Reader) (int64, error) {
+	return l.blobs.Put(key, r)
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	return l.blobs.Open(key)
+}
+
+func (l *Local) Delete(key string) error {
+	return l.blobs.Delete(key)
+}
+
+// Cache - недавно регидрированные архивы: листание истории назад читает один архив
+// много раз, а чтение из холодного хранилища медленное и может стоить денег
+type Cache struct {
+	backend Backend
+	size    int
+
+	mu    sync.Mutex
+	order []string // ключи от давно прочитанных к недавним
+	items map[string][]*storage.Message
+}
+
+// NewCache создает кеш на size архивов поверх хранилища
+func NewCache(backend Backend, size int) *Cache {
+	if size <= 0 {
+		size = 1
+	}
+	return &Cache{backend: backend, size: size, items: make(map[string][]*storage.Message)}
+}
+
+// Load возвращает сообщения архива, читая его из хранилища, если его нет в кеше
+func (c *Cache) Load(key string) ([]*storage.Message, error) {
+	c.mu.Lock()
+	if messages, ok := c.items[key]; ok {
+		c.touch(key)
+		c.mu.Unlock()
+		return messages, nil
+	}
+	c.mu.Unlock()
+
+	r, err := c.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	messages, err := Unpack(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok {
+		c.items[key] = messages
+		c.order = append(c.order, key)
+		for len(c.order) > c.size {
+			delete(c.items, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	return messages, nil
+}
+
+// touch переносит ключ в конец очереди вытеснения
+func (c *Cache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(append(c.order[:i:i], c.order[i+1:]...), key)
+			return
+		}
+	}
+}
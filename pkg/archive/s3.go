@@ -0,0 +1,211 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 хранит архивы в S3-совместимом бакете (AWS, MinIO, Ceph) с холодным классом хранения.
+// Запросы подписываются AWS Signature Version 4, адреса объектов - в стиле пути
+// (endpoint/bucket/key), который поддерживают все совместимые хранилища.
+//
+// Регидрация читает объект сразу, поэтому класс хранения должен отдавать данные без
+// предварительного восстановления: GLACIER_IR, STANDARD_IA или ONEZONE_IA. Объекты в
+// GLACIER и DEEP_ARCHIVE прочитать без RestoreObject нельзя.
+type S3 struct {
+	Endpoint     string // https://s3.eu-central-1.amazonaws.com
+	Bucket       string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	StorageClass string // пусто - класс бакета по умолчанию
+
+	Client *http.Client
+	now    func() time.Time
+}
+
+// DefaultStorageClass - самый дешевый класс хранения, читаемый без восстановления
+const DefaultStorageClass = "GLACIER_IR"
+
+func NewS3(endpoint, bucket, region, accessKey, secretKey, storageClass string) (*S3, error) {
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3 archive storage needs endpoint, bucket and credentials")
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:     strings.TrimSuffix(endpoint, "/"),
+		Bucket:       bucket,
+		Region:       region,
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		StorageClass: storageClass,
+		Client:       &http.Client{Timeout: 60 * time.Second},
+		now:          time.Now,
+	}, nil
+}
+
+func (s *S3) Put(key string, r io.Reader) (int64, error) {
+	// Подпись включает хеш тела, а архивы невелики: читаем их целиком
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive %s: %w", key, err)
+	}
+	req, err := s.request(http.MethodPut, key, body)
+	if err != nil {
+		return 0, err
+	}
+	if s.StorageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", s.StorageClass)
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, body)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload archive %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return 0, fmt.Errorf("failed to upload archive %s: %w", key, err)
+	}
+	return int64(len(body)), nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive %s: %w", key, err)
+	}
+	if err := s3Error(resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download archive %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+// Delete удаляет объект. S3 не считает отсутствие объекта ошибкой.
+func (s *S3) Delete(key string) error {
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete archive %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to delete archive %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3) request(method, key string, body []byte) (*http.Request, error) {
+	u := s.Endpoint + "/" + s.Bucket + "/" + escapeKey(key)
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 request: %w", err)
+	}
+	return req, nil
+}
+
+// sign добавляет заголовки авторизации AWS Signature Version 4
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Подписываются host и все заголовки x-amz-*
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(values[0])
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Error возвращает ошибку для неуспешного ответа с текстом ошибки S3
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+}
+
+// escapeKey кодирует ключ для пути, сохраняя разделители "/"
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
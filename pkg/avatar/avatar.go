@@ -0,0 +1,142 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	_ "image/gif"
+)
+
+// Аватар хранится квадратными вариантами стандартных размеров: исходное изображение
+// обрезается по центру до квадрата и уменьшается усреднением пикселей.
+
+// Sizes - стороны вариантов аватара в пикселях, по возрастанию
+var Sizes = []int{64, 128, 256, 512}
+
+// MaxPixels - наибольшее число пикселей исходного изображения: размеры проверяются по
+// заголовку до декодирования, чтобы небольшой файл не развернулся в гигабайты памяти
+const MaxPixels = 40_000_000
+
+// ErrUnsupported - содержимое не является изображением поддерживаемого типа
+var ErrUnsupported = errors.New("unsupported image type")
+
+// contentTypes - принимаемые типы исходного изображения (по содержимому, не по заголовку)
+var contentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Variant - вариант аватара одного размера
+type Variant struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Process проверяет изображение и создает варианты всех размеров Sizes. Изображения с
+// прозрачностью сохраняются в PNG, остальные - в JPEG.
+func Process(data []byte) ([]Variant, error) {
+	if !contentTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	square := crop(src)
+	opaque := square.Opaque()
+	// Меньшие варианты получаются из наибольшего: стороны кратны, и исходник
+	// обходится один раз
+	largest := resize(square, Sizes[len(Sizes)-1])
+	variants := make([]Variant, 0, len(Sizes))
+	for _, size := range Sizes {
+		img := largest
+		if size != largest.Bounds().Dx() {
+			img = resize(largest, size)
+		}
+		v, err := encode(img, size, opaque)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// crop вырезает из центра изображения наибольший квадрат
+func crop(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), src, origin, draw.Src)
+	return dst
+}
+
+// resize масштабирует квадрат до стороны size: каждый пиксель результата - среднее
+// покрываемых им пикселей исходника (при увеличении - ближайший пиксель)
+func resize(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, side, size)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, side, size)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// span возвращает полуинтервал пикселей исходника стороны side, покрываемый пикселем i
+// результата стороны size
+func span(i, side, size int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+func encode(img *image.RGBA, size int, opaque bool) (Variant, error) {
+	var buf bytes.Buffer
+	v := Variant{Size: size, ContentType: "image/jpeg"}
+	var err error
+	if opaque {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		v.ContentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return Variant{}, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	v.Data = buf.Bytes()
+	return v, nil
+}
@@ -0,0 +1,80 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	// Широкое изображение: слева и справа красные поля, в центре синий квадрат
+	src := image.NewNRGBA(image.Rect(0, 0, 900, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 900; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= 300 && x < 600 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	variants, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != len(Sizes) {
+		t.Fatalf("expected %d variants, got %d", len(Sizes), len(variants))
+	}
+	for i, v := range variants {
+		if v.Size != Sizes[i] || v.ContentType != "image/jpeg" {
+			t.Errorf("unexpected variant %d: %d %s", i, v.Size, v.ContentType)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != v.Size || b.Dy() != v.Size {
+			t.Errorf("variant %d is %v", v.Size, b)
+		}
+		// Обрезка по центру оставляет только синий квадрат
+		r, _, b, _ := img.At(0, 0).RGBA()
+		if r > 0x2000 || b < 0xe000 {
+			t.Errorf("variant %d not cropped to center: r=%x b=%x", v.Size, r, b)
+		}
+	}
+
+	// Прозрачность сохраняется в PNG
+	transparent := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	buf.Reset()
+	png.Encode(&buf, transparent)
+	variants, err = Process(buf.Bytes())
+	if err != nil || variants[0].ContentType != "image/png" {
+		t.Errorf("transparent avatar: %v", err)
+	}
+
+	if _, err := Process([]byte("<svg xmlns='http://www.w3.org/2000/svg'/>")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestProcessRejectsHugeImage(t *testing.T) {
+	// Заголовок PNG огромного изображения с неполными данными: отказ до декодирования
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	// IHDR: ширина и высота - первые поля после сигнатуры и заголовка блока
+	copy(data[16:24], []byte{0, 0, 0x40, 0, 0, 0, 0x40, 0})
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := Process(data); err == nil || errors.Is(err, ErrUnsupported) {
+		t.Errorf("huge image not rejected by size: %v", err)
+	}
+}
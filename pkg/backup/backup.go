@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/blobstore"
+	"hydra/pkg/ids"
+	"hydra/pkg/storage"
+	"io"
+	"strings"
+	"time"
+)
+
+// Архив резервной копии - зашифрованный tar.gz:
+//
+//	manifest.json    - описание копии (первая запись)
+//	db/<table>.json  - строки таблиц из согласованного снимка БД
+//	blobs/<key>      - объекты blob-хранилища (в инкрементальной копии - только измененные)
+const (
+	manifestName = "manifest.json"
+	dbPrefix     = "db/"
+	blobsPrefix  = "blobs/"
+
+	formatVersion = 1
+)
+
+// Manifest - описание резервной копии
+type Manifest struct {
+	Version   int          `json:"version"`
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	BaseID    string       `json:"base_id,omitempty"` // предыдущая копия цепочки для инкрементальной
+	Tables    []string     `json:"tables"`
+	Blobs     []*BlobEntry `json:"blobs"` // полный список объектов на момент копии
+}
+
+// BlobEntry - объект blob-хранилища в манифесте
+type BlobEntry struct {
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Included bool   `json:"included"` // содержимое есть в этом архиве (иначе - в одной из базовых копий)
+}
+
+// Incremental сообщает, требует ли копия базовых архивов для восстановления
+func (m *Manifest) Incremental() bool {
+	return m.BaseID != ""
+}
+
+// Create записывает зашифрованную резервную копию БД и blob-хранилища в out.
+// Если base не nil, копия инкрементальная: в архив попадают только объекты,
+// отсутствующие в base или изменившиеся с тех пор. Таблицы БД копируются всегда.
+func Create(ctx context.Context, out io.Writer, key []byte, db *storage.Storage, blobs *blobstore.Store, base *Manifest) (*Manifest, error) {
+	manifest := &Manifest{
+		Version:   formatVersion,
+		ID:        "backup-" + ids.New(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if base != nil {
+		manifest.BaseID = base.ID
+	}
+
+	// Хэши объектов нужны до записи манифеста, чтобы выбрать объекты инкрементальной копии
+	known := make(map[string]string)
+	if base != nil {
+		for _, blob := range base.Blobs {
+			known[blob.Key] = blob.SHA256
+		}
+	}
+	if blobs != nil {
+		err := blobs.Walk(func(info blobstore.BlobInfo) error {
+			sum, err := hashBlob(blobs, info.Key)
+			if err != nil {
+				return err
+			}
+			manifest.Blobs = append(manifest.Blobs, &BlobEntry{
+				Key:      info.Key,
+				Size:     info.Size,
+				SHA256:   sum,
+				Included: known[info.Key] != sum,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan blob storage: %w", err)
+		}
+	}
+
+	enc, err := newEncryptWriter(out, key)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+
+	// Снимок БД выгружается в память до записи манифеста, чтобы знать список таблиц
+	tables := make(map[string][]byte)
+	err = db.ExportTables(ctx, func(table string, rows []byte) error {
+		manifest.Tables = append(manifest.Tables, table)
+		tables[table] = rows
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestName, data); err != nil {
+		return nil, err
+	}
+	for _, table := range manifest.Tables {
+		if err := writeEntry(tw, dbPrefix+table+".json", tables[table]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, blob := range manifest.Blobs {
+		if !blob.Included {
+			continue
+		}
+		if err := writeBlob(tw, blobs, blob); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// ReadManifest читает манифест архива (например, базовой копии для инкрементальной)
+func ReadManifest(in io.Reader, key []byte) (*Manifest, error) {
+	tr, err := openArchive(in, key)
+	if err != nil {
+		return nil, err
+	}
+	return readManifest(tr)
+}
+
+// Verify полностью читает архив и проверяет целостность: подписи блоков шифрования,
+// наличие всех таблиц и размеры и хэши включенных объектов
+func Verify(in io.Reader, key []byte) (*Manifest, error) {
+	return walkArchive(in, key, nil, nil, nil)
+}
+
+// Restore восстанавливает цепочку копий: archives - полная копия и следующие за ней
+// инкрементальные в порядке создания. Объекты восстанавливаются из всех архивов,
+// таблицы БД - из последнего. После восстановления проверяются хэши всех объектов.
+func Restore(ctx context.Context, archives []io.Reader, key []byte, db *storage.Storage, blobs *blobstore.Store) (*Manifest, error) {
+	if len(archives) == 0 {
+		return nil, fmt.Errorf("no backup archives to restore")
+	}
+
+	var manifest *Manifest
+	var tables map[string][]byte
+	for i, in := range archives {
+		var collect map[string][]byte
+		if i == len(archives)-1 {
+			collect = make(map[string][]byte)
+		}
+
+		// Цепочка проверяется до записи объектов в хранилище
+		prev := manifest
+		checkChain := func(m *Manifest) error {
+			switch {
+			case prev == nil && m.Incremental():
+				return fmt.Errorf("backup %s is incremental; restore its base %s first", m.ID, m.BaseID)
+			case prev != nil && m.BaseID != prev.ID:
+				return fmt.Errorf("backup %s is based on %s, not on %s", m.ID, m.BaseID, prev.ID)
+			}
+			return nil
+		}
+
+		m, err := walkArchive(in, key, checkChain, collect, blobs)
+		if err != nil {
+			return nil, err
+		}
+		manifest, tables = m, collect
+	}
+
+	// Итоговый набор объектов должен совпадать с последним манифестом
+	for _, blob := range manifest.Blobs {
+		sum, err := hashBlob(blobs, blob.Key)
+		if err != nil {
+			return nil, fmt.Errorf("blob %s missing after restore: %w", blob.Key, err)
+		}
+		if sum != blob.SHA256 {
+			return nil, fmt.Errorf("blob %s checksum mismatch after restore", blob.Key)
+		}
+	}
+
+	if err := db.ImportTables(ctx, tables); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// walkArchive читает архив, проверяя содержимое по манифесту. check (если задан) проверяет
+// манифест до чтения данных. Если tables не nil, в него собираются данные таблиц;
+// если blobs не nil, включенные объекты записываются в хранилище.
+func walkArchive(in io.Reader, key []byte, check func(*Manifest) error, tables map[string][]byte, blobs *blobstore.Store) (*Manifest, error) {
+	tr, err := openArchive(in, key)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	expectedTables := make(map[string]bool, len(manifest.Tables))
+	for _, table := range manifest.Tables {
+		expectedTables[table] = true
+	}
+	expectedBlobs := make(map[string]*BlobEntry)
+	for _, blob := range manifest.Blobs {
+		if blob.Included {
+			expectedBlobs[blob.Key] = blob
+		}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		switch {
+		case strings.HasPrefix(hdr.Name, dbPrefix):
+			table := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, dbPrefix), ".json")
+			if !expectedTables[table] {
+				return nil, fmt.Errorf("unexpected table %s in archive", table)
+			}
+			rows, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read table %s: %w", table, err)
+			}
+			if !json.Valid(rows) {
+				return nil, fmt.Errorf("table %s is corrupted", table)
+			}
+			if tables != nil {
+				tables[table] = rows
+			}
+			delete(expectedTables, table)
+
+		case strings.HasPrefix(hdr.Name, blobsPrefix):
+			blobKey := strings.TrimPrefix(hdr.Name, blobsPrefix)
+			blob, ok := expectedBlobs[blobKey]
+			if !ok {
+				return nil, fmt.Errorf("unexpected blob %s in archive", blobKey)
+			}
+			if err := readBlob(tr, blob, blobs); err != nil {
+				return nil, err
+			}
+			delete(expectedBlobs, blobKey)
+
+		default:
+			return nil, fmt.Errorf("unexpected entry %s in archive", hdr.Name)
+		}
+	}
+
+	for table := range expectedTables {
+		return nil, fmt.Errorf("table %s missing from archive", table)
+	}
+	for blobKey := range expectedBlobs {
+		return nil, fmt.Errorf("blob %s missing from archive", blobKey)
+	}
+	return manifest, nil
+}
+
+func openArchive(in io.Reader, key []byte) (*tar.Reader, error) {
+	dec, err := newDecryptReader(in, key)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	return tar.NewReader(gz), nil
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, fmt.Errorf("archive has no manifest")
+	}
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// readBlob проверяет размер и хэш объекта и, если задано хранилище, записывает его туда
+func readBlob(tr io.Reader, blob *BlobEntry, blobs *blobstore.Store) error {
+	hash := sha256.New()
+	src := io.TeeReader(tr, hash)
+
+	var n int64
+	var err error
+	if blobs != nil {
+		n, err = blobs.Put(blob.Key, src)
+	} else {
+		n, err = io.Copy(io.Discard, src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", blob.Key, err)
+	}
+
+	if n != blob.Size || hex.EncodeToString(hash.Sum(nil)) != blob.SHA256 {
+		return fmt.Errorf("blob %s checksum mismatch", blob.Key)
+	}
+	return nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeBlob копирует объект в архив, проверяя, что он не изменился после подсчета хэша
+func writeBlob(tw *tar.Writer, blobs *blobstore.Store, blob *BlobEntry) error {
+	file, err := blobs.Open(blob.Key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hdr := &tar.Header{Name: blobsPrefix + blob.Key, Mode: 0600, Size: blob.Size, ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", blob.Key, err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(io.LimitReader(file, blob.Size), hash)); err != nil {
+		return fmt.Errorf("blob %s changed during backup: %w", blob.Key, err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != blob.SHA256 {
+		return fmt.Errorf("blob %s changed during backup", blob.Key)
+	}
+	return nil
+}
+
+func hashBlob(blobs *blobstore.Store, key string) (string, error) {
+	file, err := blobs.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
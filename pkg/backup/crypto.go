@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Формат зашифрованного архива: magic | префикс nonce (8 байт) | блоки.
+// Блок: длина шифртекста (4 байта) | AES-256-GCM(данные). Nonce блока - префикс и номер блока,
+// признак последнего блока входит в AAD, поэтому перестановка и обрезка архива обнаруживаются.
+var archiveMagic = []byte("HBK1")
+
+const (
+	chunkSize   = 64 << 10
+	noncePrefix = 8
+)
+
+var (
+	aadChunk = []byte("chunk")
+	aadLast  = []byte("last")
+)
+
+// ErrTruncated - архив обрезан или поврежден
+var ErrTruncated = errors.New("backup archive is truncated")
+
+// ParseKey разбирает ключ шифрования: 32 байта в hex (BACKUP_KEY)
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("backup key must be 32 bytes in hex")
+	}
+	return key, nil
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  [noncePrefix]byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// newEncryptWriter шифрует поток. Close обязателен: он записывает последний блок.
+func newEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}
+	if _, err := rand.Read(ew.prefix[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if _, err := w.Write(append(append([]byte{}, archiveMagic...), ew.prefix[:]...)); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+
+		// Полный блок пишется, только когда есть следующие данные: последний блок пишет Close
+		if len(ew.buf) == chunkSize && len(p) > 0 {
+			if err := ew.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.flush(true)
+}
+
+func (ew *encryptWriter) flush(last bool) error {
+	aad := aadChunk
+	if last {
+		aad = aadLast
+	}
+
+	sealed := ew.aead.Seal(nil, ew.nonce(), ew.buf, aad)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(sealed)))
+	if _, err := ew.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := ew.w.Write(sealed)
+	return err
+}
+
+func (ew *encryptWriter) nonce() []byte {
+	nonce := make([]byte, ew.aead.NonceSize())
+	copy(nonce, ew.prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefix:], ew.counter)
+	return nonce
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  [noncePrefix]byte
+	counter uint32
+	buf     []byte
+	done    bool
+}
+
+// newDecryptReader расшифровывает поток, проверяя целостность каждого блока
+func newDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(archiveMagic)+noncePrefix)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	if string(header[:len(archiveMagic)]) != string(archiveMagic) {
+		return nil, fmt.Errorf("not a hydra backup archive")
+	}
+
+	dr := &decryptReader{r: r, aead: aead}
+	copy(dr.prefix[:], header[len(archiveMagic):])
+	return dr, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) next() error {
+	var header [4]byte
+	if _, err := io.ReadFull(dr.r, header[:]); err != nil {
+		return ErrTruncated
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > chunkSize+uint32(dr.aead.Overhead()) {
+		return fmt.Errorf("invalid backup chunk size %d", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(dr.r, sealed); err != nil {
+		return ErrTruncated
+	}
+
+	nonce := make([]byte, dr.aead.NonceSize())
+	copy(nonce, dr.prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefix:], dr.counter)
+	dr.counter++
+
+	if plain, err := dr.aead.Open(nil, nonce, sealed, aadChunk); err == nil {
+		dr.buf = plain
+		return nil
+	}
+	plain, err := dr.aead.Open(nil, nonce, sealed, aadLast)
+	if err != nil {
+		return fmt.Errorf("backup decryption failed (wrong key or corrupted archive)")
+	}
+	dr.buf = plain
+	dr.done = true
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	// Несколько полных блоков и неполный последний
+	plain := make([]byte, 3*chunkSize+123)
+	rand.Read(plain)
+
+	var archive bytes.Buffer
+	w, err := newEncryptWriter(&archive, key)
+	if err != nil {
+		t.Fatalf("newEncryptWriter failed: %v", err)
+	}
+	w.Write(plain[:1000])
+	w.Write(plain[1000:])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := newDecryptReader(bytes.NewReader(archive.Bytes()), key)
+	if err != nil {
+		t.Fatalf("newDecryptReader failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("Decrypted data does not match")
+	}
+
+	// Обрезка по границе блока должна обнаруживаться
+	truncated := archive.Bytes()[:len(archive.Bytes())-(chunkSize/2)]
+	r, _ = newDecryptReader(bytes.NewReader(truncated), key)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+
+	// Чужой ключ
+	wrong := make([]byte, 32)
+	r, _ = newDecryptReader(bytes.NewReader(archive.Bytes()), wrong)
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("Expected decryption with wrong key to fail")
+	}
+}
@@ -0,0 +1,171 @@
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store хранит бинарные объекты (записи звонков, вложения) в файловой системе.
+// Ключи - относительные пути вида "recordings/<id>/<file>".
+type Store struct {
+	root string
+}
+
+// BlobInfo - метаданные объекта
+type BlobInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func New(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob storage directory: %w", err)
+	}
+	return &Store{root: root}, nil
+}
+
+// path преобразует ключ в путь, не позволяя выйти за пределы корня хранилища
+func (s *Store) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid blob key: %s", key)
+	}
+	return filepath.Join(s.root, clean), nil
+}
+
+// Create создает (или перезаписывает) объект и возвращает writer для записи данных
+func (s *Store) Create(key string) (io.WriteCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob %s: %w", key, err)
+	}
+	return file, nil
+}
+
+// Put сохраняет содержимое reader под ключом
+func (s *Store) Put(key string, r io.Reader) (int64, error) {
+	w, err := s.Create(key)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// Append дописывает содержимое reader в конец объекта, создавая его при необходимости
+// (загрузка по частям)
+func (s *Store) Append(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open blob %s: %w", key, err)
+	}
+	n, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to append to blob %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// Open открывает объект для чтения
+func (s *Store) Open(key string) (*os.File, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s not found: %w", key, err)
+	}
+	return file, nil
+}
+
+// Stat возвращает метаданные объекта
+func (s *Store) Stat(key string) (*BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s not found: %w", key, err)
+	}
+	return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete удаляет объект. Отсутствие объекта не считается ошибкой.
+func (s *Store) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeletePrefix удаляет все объекты с указанным префиксом (каталог)
+func (s *Store) DeletePrefix(prefix string) error {
+	path, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to delete blobs %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// Walk обходит все объекты хранилища в лексикографическом порядке ключей
+func (s *Store) Walk(fn func(info BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
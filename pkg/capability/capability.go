@@ -0,0 +1,145 @@
+package capability
+
+import (
+	"sort"
+	"strings"
+)
+
+// Виды медиа, для которых проверяется поддержка устройством
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+)
+
+var mediaCodecs = map[string][]string{
+	MediaAudio: {"opus", "pcmu", "pcma", "g722"},
+	MediaVideo: {"vp8", "vp9", "h264", "av1"},
+}
+
+// Capabilities - возможности клиентского устройства, которыми обмениваются клиенты
+// и устройства одного аккаунта. Сервер использует их, чтобы не рассылать устройствам
+// то, что они не умеют принимать (например, видеозвонки на устройства только с аудио).
+type Capabilities struct {
+	Codecs       []string `json:"codecs"`         // opus, vp8, h264, ...
+	MaxMediaSize int64    `json:"max_media_size"` // максимальный размер вложения в байтах, 0 - без ограничений
+	E2EVersions  []int    `json:"e2e_versions"`   // поддерживаемые версии E2E протокола
+	Transports   []string `json:"transports"`     // domain-fronting, mesh, direct, turn
+	Lite         bool     `json:"lite,omitempty"` // облегченный режим: только текст, медиа по запросу
+}
+
+// Normalize приводит списки к нижнему регистру, убирает дубликаты и сортирует
+// (версии E2E - по убыванию, чтобы первой шла предпочтительная)
+func (c *Capabilities) Normalize() {
+	c.Codecs = normalizeNames(c.Codecs)
+	c.Transports = normalizeNames(c.Transports)
+
+	seen := make(map[int]bool)
+	var versions []int
+	for _, v := range c.E2EVersions {
+		if v > 0 && !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	c.E2EVersions = versions
+
+	if c.MaxMediaSize < 0 {
+		c.MaxMediaSize = 0
+	}
+}
+
+// Supports сообщает, может ли устройство принять медиа данного вида
+func (c Capabilities) Supports(media string) bool {
+	for _, codec := range mediaCodecs[media] {
+		if c.HasCodec(codec) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCodec сообщает, поддерживает ли устройство кодек
+func (c Capabilities) HasCodec(codec string) bool {
+	codec = strings.ToLower(codec)
+	for _, have := range c.Codecs {
+		if have == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsSize сообщает, примет ли устройство вложение размера size.
+// Устройство в облегченном режиме вложения автоматически не принимает.
+func (c Capabilities) AcceptsSize(size int64) bool {
+	return !c.Lite && (c.MaxMediaSize == 0 || size <= c.MaxMediaSize)
+}
+
+// E2EVersion возвращает предпочтительную версию E2E протокола (0 - нет общей)
+func (c Capabilities) E2EVersion() int {
+	if len(c.E2EVersions) == 0 {
+		return 0
+	}
+	return c.E2EVersions[0]
+}
+
+// Negotiate возвращает общие возможности двух устройств: пересечение кодеков,
+// версий E2E и транспортов, меньший из лимитов размера медиа и облегченный режим,
+// если он включен хотя бы у одного.
+func Negotiate(a, b Capabilities) Capabilities {
+	a.Normalize()
+	b.Normalize()
+
+	result := Capabilities{
+		Codecs:     intersect(a.Codecs, b.Codecs),
+		Transports: intersect(a.Transports, b.Transports),
+		Lite:       a.Lite || b.Lite,
+	}
+
+	for _, v := range a.E2EVersions {
+		for _, w := range b.E2EVersions {
+			if v == w {
+				result.E2EVersions = append(result.E2EVersions, v)
+			}
+		}
+	}
+
+	switch {
+	case a.MaxMediaSize == 0:
+		result.MaxMediaSize = b.MaxMediaSize
+	case b.MaxMediaSize == 0 || a.MaxMediaSize < b.MaxMediaSize:
+		result.MaxMediaSize = a.MaxMediaSize
+	default:
+		result.MaxMediaSize = b.MaxMediaSize
+	}
+	return result
+}
+
+func normalizeNames(names []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func intersect(a, b []string) []string {
+	set := make(map[string]bool, len(b))
+	for _, item := range b {
+		set[item] = true
+	}
+	var result []string
+	for _, item := range a {
+		if set[item] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
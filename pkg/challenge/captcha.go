@@ -0,0 +1,68 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SiteVerify - CAPTCHA с проверкой ответа через siteverify API (общий формат hCaptcha,
+// Turnstile и reCAPTCHA: форма secret/response/remoteip, ответ {"success": bool})
+type SiteVerify struct {
+	URL     string // например https://hcaptcha.com/siteverify
+	Secret  string
+	SiteKey string // ключ виджета, который клиент показывает пользователю
+	Client  *http.Client
+}
+
+// Issue возвращает испытание с ключом виджета CAPTCHA
+func (c *SiteVerify) Issue() (*Challenge, error) {
+	return &Challenge{Kind: KindCaptcha, SiteKey: c.SiteKey}, nil
+}
+
+// Verify проверяет ответ виджета CAPTCHA
+func (c *SiteVerify) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return ErrMissing
+	}
+	form := url.Values{
+		"secret":   {c.Secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create CAPTCHA request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send CAPTCHA request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("CAPTCHA API returned status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode CAPTCHA response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("CAPTCHA rejected: %s", strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Испытания против автоматических регистраций и рассылки кодов: перед регистрацией и
+// отправкой SMS или письма с кодом клиент получает испытание (GET /api/challenge) и
+// присылает ответ на него. По умолчанию это доказательство работы в духе Hashcash,
+// которое клиент решает сам; вместо него можно подключить CAPTCHA (hCaptcha, Turnstile,
+// reCAPTCHA).
+
+// Виды испытаний
+const (
+	KindPoW     = "pow"
+	KindCaptcha = "captcha"
+)
+
+var (
+	ErrMissing = errors.New("challenge response required")
+	ErrInvalid = errors.New("invalid challenge response")
+	ErrExpired = errors.New("challenge expired")
+	ErrReused  = errors.New("challenge already used")
+)
+
+// Challenge - испытание, которое клиент должен пройти
+type Challenge struct {
+	Kind       string    `json:"kind"`
+	Challenge  string    `json:"challenge,omitempty"`  // строка испытания pow
+	Difficulty int       `json:"difficulty,omitempty"` // число нулевых старших бит SHA-256 для pow
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	SiteKey    string    `json:"site_key,omitempty"` // ключ виджета CAPTCHA
+}
+
+// Provider выдает испытания и проверяет ответы на них
+type Provider interface {
+	// Issue возвращает новое испытание для клиента
+	Issue() (*Challenge, error)
+	// Verify проверяет ответ клиента с адреса remoteIP
+	Verify(ctx context.Context, response, remoteIP string) error
+}
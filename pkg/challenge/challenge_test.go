@@ -0,0 +1,75 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProofOfWork(t *testing.T) {
+	now := time.Now()
+	p := NewProofOfWork([]byte("secret"), 8, time.Minute)
+	p.now = func() time.Time { return now }
+
+	c, err := p.Issue()
+	if err != nil || c.Kind != KindPoW || c.Difficulty != 8 {
+		t.Fatalf("Issue() = %+v, %v", c, err)
+	}
+	if err := p.Verify(context.Background(), "", ""); !errors.Is(err, ErrMissing) {
+		t.Errorf("empty response: %v", err)
+	}
+
+	response := Solve(c.Challenge, c.Difficulty)
+	if err := p.Verify(context.Background(), response, ""); err != nil {
+		t.Fatalf("Verify(solution) = %v", err)
+	}
+	if err := p.Verify(context.Background(), response, ""); !errors.Is(err, ErrReused) {
+		t.Errorf("reused solution: %v", err)
+	}
+
+	// Подпись защищает сложность и срок испытания
+	c, _ = p.Issue()
+	easier := "0" + c.Challenge[strings.IndexByte(c.Challenge, '.'):]
+	if err := p.Verify(context.Background(), Solve(easier, 0), ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("tampered difficulty: %v", err)
+	}
+	other := NewProofOfWork([]byte("other"), 8, time.Minute)
+	if err := other.Verify(context.Background(), Solve(c.Challenge, 8), ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("challenge of another server: %v", err)
+	}
+
+	response = Solve(c.Challenge, c.Difficulty)
+	now = now.Add(time.Minute)
+	if err := p.Verify(context.Background(), response, ""); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired challenge: %v", err)
+	}
+}
+
+func TestSiteVerify(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": r.Form.Get("secret") == "s3cret" && r.Form.Get("response") == "ok" && r.Form.Get("remoteip") == "192.0.2.1",
+		})
+	}))
+	defer api.Close()
+
+	c := &SiteVerify{URL: api.URL, Secret: "s3cret", SiteKey: "site"}
+	if issued, _ := c.Issue(); issued.Kind != KindCaptcha || issued.SiteKey != "site" {
+		t.Errorf("Issue() = %+v", issued)
+	}
+	if err := c.Verify(context.Background(), "ok", "192.0.2.1"); err != nil {
+		t.Errorf("valid response: %v", err)
+	}
+	if err := c.Verify(context.Background(), "bad", "192.0.2.1"); err == nil {
+		t.Error("invalid response accepted")
+	}
+	if err := c.Verify(context.Background(), "", "192.0.2.1"); !errors.Is(err, ErrMissing) {
+		t.Errorf("empty response: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Доказательство работы: сервер выдает подписанную строку испытания
+// "сложность.expires_unix.nonce.hex(HMAC-SHA256)", клиент подбирает счетчик, при котором
+// у SHA-256("испытание:счетчик") не меньше Difficulty нулевых старших бит, и присылает
+// "испытание:счетчик". Испытания не хранятся до ответа; решенные помнятся до истечения
+// срока, чтобы одно решение нельзя было предъявить дважды.
+
+// ProofOfWork - испытания доказательством работы
+type ProofOfWork struct {
+	Difficulty int
+	TTL        time.Duration
+
+	key  []byte
+	mu   sync.Mutex
+	used map[string]time.Time // решенные испытания -> срок их действия
+	now  func() time.Time
+}
+
+// NewProofOfWork создает испытания сложностью difficulty бит, действующие ttl; подпись
+// выводится из secret, поэтому испытание, выданное одним экземпляром сервера, принимает и другой
+func NewProofOfWork(secret []byte, difficulty int, ttl time.Duration) *ProofOfWork {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("hydra proof of work"))
+	return &ProofOfWork{
+		Difficulty: difficulty,
+		TTL:        ttl,
+		key:        mac.Sum(nil),
+		used:       make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Issue выдает новое испытание
+func (p *ProofOfWork) Issue() (*Challenge, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge nonce: %w", err)
+	}
+	expiresAt := p.now().Add(p.TTL)
+	payload := strconv.Itoa(p.Difficulty) + "." + strconv.FormatInt(expiresAt.Unix(), 10) + "." + hex.EncodeToString(buf)
+	return &Challenge{
+		Kind:       KindPoW,
+		Challenge:  payload + "." + p.sign(payload),
+		Difficulty: p.Difficulty,
+		ExpiresAt:  expiresAt.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Verify проверяет решение "испытание:счетчик"
+func (p *ProofOfWork) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return ErrMissing
+	}
+	i := strings.LastIndexByte(response, ':')
+	if i < 0 {
+		return ErrInvalid
+	}
+	challenge := response[:i]
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return ErrInvalid
+	}
+	payload := parts[0] + "." + parts[1] + "." + parts[2]
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		return ErrInvalid
+	}
+	// Сложность берется из подписанного испытания: ее смена не отменяет выданные испытания
+	difficulty, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ErrInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	now := p.now()
+	expiresAt := time.Unix(expires, 0)
+	if !now.Before(expiresAt) {
+		return ErrExpired
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+		return ErrInvalid
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for c, exp := range p.used {
+		if now.After(exp) {
+			delete(p.used, c)
+		}
+	}
+	if _, used := p.used[challenge]; used {
+		return ErrReused
+	}
+	p.used[challenge] = expiresAt
+	return nil
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Solve решает испытание перебором счетчика и возвращает ответ для Verify
+func Solve(challenge string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		response := challenge + ":" + strconv.Itoa(counter)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) >= difficulty {
+			return response
+		}
+	}
+}
+
+// leadingZeroBits возвращает число нулевых старших бит хеша
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,197 @@
+// Package compliance собирает выгрузку метаданных аккаунтов для организационных
+// развертываний (запросы регуляторов, внутренние расследования). В выгрузку попадают
+// только метаданные: тексты сообщений, журналы событий и секреты в нее не включаются
+// ни при каких условиях. Каждый раздел выгрузки хешируется, хеши разделов и параметры
+// запроса входят в манифест, манифест подписывается ключом сервера, а его хеш
+// записывается в журнал аудита, так что цепочку хранения можно проверить: файл не
+// изменен, получен с этого сервера и выдан конкретному сотруднику по конкретной причине.
+package compliance
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxAccounts - сколько аккаунтов можно выгрузить одним запросом
+const MaxAccounts = 100
+
+// forbiddenFields - поля, которые не могут попасть в выгрузку: содержимое сообщений
+// (в том числе зашифрованное), полезная нагрузка событий и секреты
+var forbiddenFields = map[string]bool{
+	"body":        true,
+	"payload":     true,
+	"password":    true,
+	"secret_hash": true,
+	"code":        true,
+	"signature":   true,
+	"mute_token":  true,
+}
+
+// Signer - ключ сервера, которым подписывается манифест (timesync.Signer)
+type Signer interface {
+	SignData(data []byte) string
+	PublicKey() ed25519.PublicKey
+}
+
+// Section - раздел выгрузки: JSON массив строк одной таблицы
+type Section struct {
+	Name string          `json:"name"`
+	Rows json.RawMessage `json:"rows"`
+}
+
+// SectionDigest - хеш раздела в манифесте
+type SectionDigest struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest - описание выгрузки, подписанное сервером
+type Manifest struct {
+	ID          string          `json:"id"`
+	RequestedBy string          `json:"requested_by"`
+	Reason      string          `json:"reason"`
+	UserIDs     []string        `json:"user_ids"`
+	SnapshotAt  int64           `json:"snapshot_at"` // время снимка БД, Unix мс
+	Sections    []SectionDigest `json:"sections"`
+	SHA256      string          `json:"sha256"`    // хеш канонического манифеста без подписи
+	Signature   string          `json:"signature"` // base64(ed25519(payload))
+	PublicKey   string          `json:"public_key"`
+}
+
+// Export - выгрузка целиком
+type Export struct {
+	Manifest Manifest  `json:"manifest"`
+	Sections []Section `json:"sections"`
+}
+
+// ValidateRequest проверяет список аккаунтов и причину выгрузки
+func ValidateRequest(userIDs []string, reason string) error {
+	if len(userIDs) == 0 || len(userIDs) > MaxAccounts {
+		return fmt.Errorf("from 1 to %d accounts are required", MaxAccounts)
+	}
+	seen := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		if id == "" || seen[id] {
+			return fmt.Errorf("invalid or duplicate account %q", id)
+		}
+		seen[id] = true
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("reason is required")
+	}
+	return nil
+}
+
+// CheckMetadataOnly проверяет, что в строках раздела нет запрещенных полей
+func CheckMetadataOnly(section Section) (rows int, err error) {
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(section.Rows, &records); err != nil {
+		return 0, fmt.Errorf("section %s is not an array of rows: %w", section.Name, err)
+	}
+	for _, record := range records {
+		for field := range record {
+			if forbiddenFields[field] {
+				return 0, fmt.Errorf("section %s contains forbidden field %q", section.Name, field)
+			}
+		}
+	}
+	return len(records), nil
+}
+
+// Build проверяет разделы, хеширует их и подписывает манифест
+func Build(signer Signer, id, requestedBy, reason string, userIDs []string, snapshotAt time.Time, sections []Section) (*Export, error) {
+	ids := append([]string(nil), userIDs...)
+	sort.Strings(ids)
+
+	export := &Export{
+		Manifest: Manifest{
+			ID:          id,
+			RequestedBy: requestedBy,
+			Reason:      reason,
+			UserIDs:     ids,
+			SnapshotAt:  snapshotAt.UnixMilli(),
+			PublicKey:   base64.StdEncoding.EncodeToString(signer.PublicKey()),
+		},
+	}
+	for _, section := range sections {
+		rows, err := CheckMetadataOnly(section)
+		if err != nil {
+			return nil, err
+		}
+		// Хешируется компактный JSON: так выгрузку можно переформатировать при передаче
+		// и проверить после разбора
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, section.Rows); err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.Name, err)
+		}
+		section.Rows = compact.Bytes()
+		export.Sections = append(export.Sections, section)
+		export.Manifest.Sections = append(export.Manifest.Sections, SectionDigest{
+			Name:   section.Name,
+			Rows:   rows,
+			SHA256: digest(section.Rows),
+		})
+	}
+
+	payload := export.Manifest.payload()
+	export.Manifest.SHA256 = digest(payload)
+	export.Manifest.Signature = signer.SignData(payload)
+	return export, nil
+}
+
+// Verify проверяет хеши разделов и подпись манифеста известным ключом сервера
+func Verify(publicKey ed25519.PublicKey, export *Export) error {
+	if len(export.Sections) != len(export.Manifest.Sections) {
+		return fmt.Errorf("manifest lists %d sections, export has %d", len(export.Manifest.Sections), len(export.Sections))
+	}
+	for i, section := range export.Sections {
+		d := export.Manifest.Sections[i]
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, section.Rows); err != nil {
+			return fmt.Errorf("section %s: %w", section.Name, err)
+		}
+		if section.Name != d.Name || digest(compact.Bytes()) != d.SHA256 {
+			return fmt.Errorf("section %s does not match the manifest", section.Name)
+		}
+	}
+
+	payload := export.Manifest.payload()
+	if digest(payload) != export.Manifest.SHA256 {
+		return fmt.Errorf("manifest hash mismatch")
+	}
+	sig, err := base64.StdEncoding.DecodeString(export.Manifest.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// payload - каноническое представление манифеста без хеша и подписи
+func (m *Manifest) payload() []byte {
+	data, _ := json.Marshal(struct {
+		ID          string          `json:"id"`
+		RequestedBy string          `json:"requested_by"`
+		Reason      string          `json:"reason"`
+		UserIDs     []string        `json:"user_ids"`
+		SnapshotAt  int64           `json:"snapshot_at"`
+		Sections    []SectionDigest `json:"sections"`
+	}{m.ID, m.RequestedBy, m.Reason, m.UserIDs, m.SnapshotAt, m.Sections})
+	return data
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package compliance
+
+import (
+	"encoding/json"
+	"hydra/pkg/timesync"
+	"testing"
+	"time"
+)
+
+func TestExportChainOfCustody(t *testing.T) {
+	signer, err := timesync.NewSigner(nil)
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+	sections := []Section{
+		{Name: "accounts", Rows: json.RawMessage(`[{"id": "alice", "name": "Alice"}]`)},
+		{Name: "messages", Rows: json.RawMessage(`[{"id": "msg-1", "sender_id": "alice"}, {"id": "msg-2", "sender_id": "alice"}]`)},
+	}
+	export, err := Build(signer, "export-1", "officer", "case 42", []string{"alice"}, time.Now(), sections)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if export.Manifest.Sections[1].Rows != 2 || export.Manifest.SHA256 == "" {
+		t.Errorf("unexpected manifest: %+v", export.Manifest)
+	}
+
+	// Выгрузка проверяется после сериализации, как ее получит проверяющий
+	data, _ := json.Marshal(export)
+	var received Export
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := Verify(signer.PublicKey(), &received); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// Изменение любого раздела или параметров запроса обнаруживается
+	received.Sections[1].Rows = json.RawMessage(`[{"id": "msg-1", "sender_id": "alice"}]`)
+	if err := Verify(signer.PublicKey(), &received); err == nil {
+		t.Error("tampered section verified")
+	}
+	json.Unmarshal(data, &received)
+	received.Manifest.Reason = "другая причина"
+	if err := Verify(signer.PublicKey(), &received); err == nil {
+		t.Error("tampered manifest verified")
+	}
+}
+
+func TestExportRejectsContent(t *testing.T) {
+	signer, _ := timesync.NewSigner(nil)
+	sections := []Section{{Name: "messages", Rows: json.RawMessage(`[{"id": "msg-1", "body": "секрет"}]`)}}
+	if _, err := Build(signer, "export-1", "officer", "case 42", []string{"alice"}, time.Now(), sections); err == nil {
+		t.Error("section with message bodies must be rejected")
+	}
+
+	if err := ValidateRequest([]string{"alice", "alice"}, "case 42"); err == nil {
+		t.Error("duplicate accounts accepted")
+	}
+	if err := ValidateRequest([]string{"alice"}, " "); err == nil {
+		t.Error("export without reason accepted")
+	}
+}
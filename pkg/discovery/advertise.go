@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hydra/pkg/transport/mesh"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+// AddressCheckInterval - как часто проверяется смена локального IP. Анонс живет все время
+// работы ServiceDiscovery; если IP не задан явно и сменился (переподключение к Wi-Fi,
+// новый адрес DHCP), сервер анонса пересоздается с новым адресом и новой подписью.
+const AddressCheckInterval = 30 * time.Second
+
+// announcement возвращает имя экземпляра и поля TXT анонса для адреса ip
+func (sd *ServiceDiscovery) announcement(ip string) (string, []string) {
+	// Имя экземпляра включает ключ узла, чтобы анонсы разных узлов не сливались в одну запись
+	instance := "Hydra Messenger"
+	txt := []string{txtVersion, "type=messenger"}
+	if sd.signer != nil {
+		nodeID := sd.signer.NodeID()
+		instance = "Hydra " + strings.NewReplacer("/", "_", "+", "-").Replace(nodeID[:12])
+		txt = append(txt, capabilityFields(sd.signer.Capabilities(), mesh.Fingerprint(nodeID))...)
+		txt = append(txt, "pk="+nodeID)
+		signature := sd.signer.Sign(announcementPayload(sd.serviceName, ip, sd.port, txt))
+		txt = append(txt, "sig="+base64.StdEncoding.EncodeToString(signature))
+	}
+	return instance, txt
+}
+
+// advertise запускает сервер анонса для адреса ip, заменяя предыдущий. Сервер отвечает
+// на запросы в фоне до следующей смены адреса или Stop.
+func (sd *ServiceDiscovery) advertise(ip string) error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	select {
+	case <-sd.stopChan:
+		return fmt.Errorf("discovery stopped")
+	default:
+	}
+
+	if sd.server != nil {
+		sd.server.Shutdown()
+		sd.server = nil
+	}
+
+	instance, txt := sd.announcement(ip)
+	service, err := mdns.NewMDNSService(instance, sd.serviceName, "", "", sd.port, []net.IP{net.ParseIP(ip)}, txt)
+	if err != nil {
+		return err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return err
+	}
+
+	sd.server = server
+	sd.advertisedIP = ip
+	return nil
+}
+
+// AdvertisedIP возвращает IP текущего анонса; пусто - узел не анонсируется
+func (sd *ServiceDiscovery) AdvertisedIP() string {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	if sd.server == nil {
+		return ""
+	}
+	return sd.advertisedIP
+}
+
+// watchAddress пересоздает анонс при смене локального IP и повторяет неудавшийся запуск
+func (sd *ServiceDiscovery) watchAddress() {
+	ticker := time.NewTicker(AddressCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sd.stopChan:
+			return
+		case <-ticker.C:
+			sd.checkAddressOnce()
+		}
+	}
+}
+
+// checkAddressOnce сравнивает локальный IP с анонсируемым и при расхождении анонсирует заново
+func (sd *ServiceDiscovery) checkAddressOnce() {
+	ip, err := sd.localIP()
+	if err != nil {
+		// Без адреса (сеть пропала) старый анонс оставляется: при возвращении того же
+		// адреса пересоздавать его не придется
+		return
+	}
+	if ip == sd.AdvertisedIP() {
+		return
+	}
+
+	if err := sd.advertise(ip); err != nil {
+		log.Printf("mDNS re-announce on %s failed: %v", ip, err)
+		return
+	}
+	log.Printf("mDNS announcement moved to %s", ip)
+}
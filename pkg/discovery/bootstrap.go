@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// bootstrapTimeout - время на загрузку удаленного списка bootstrap узлов
+const bootstrapTimeout = 15 * time.Second
+
+// SignedBootstrapList - удаленный список bootstrap узлов, подписанный ключом Ed25519
+// (формат как у списка фронтов). Payload - base64 JSON BootstrapList.
+type SignedBootstrapList struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// BootstrapList - содержимое подписанного списка bootstrap узлов
+type BootstrapList struct {
+	Peers    []string `json:"peers"` // адреса host:port узлов mesh
+	IssuedAt int64    `json:"issued_at"`
+}
+
+// VerifyBootstrapList проверяет подпись удаленного списка и возвращает его содержимое
+func VerifyBootstrapList(publicKey ed25519.PublicKey, signed *SignedBootstrapList) (*BootstrapList, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bootstrap list public key is not configured")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap list payload encoding: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap list signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, sig) {
+		return nil, fmt.Errorf("bootstrap list signature verification failed")
+	}
+
+	var list BootstrapList
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap list payload: %w", err)
+	}
+	return &list, nil
+}
+
+// FetchBootstrapList загружает и проверяет подписанный список bootstrap узлов
+func FetchBootstrapList(ctx context.Context, url string, publicKey ed25519.PublicKey) (*BootstrapList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bootstrap list request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bootstrap list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bootstrap list returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap list: %w", err)
+	}
+
+	var signed SignedBootstrapList
+	if err := json.Unmarshal(body, &signed); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap list: %w", err)
+	}
+	return VerifyBootstrapList(publicKey, &signed)
+}
+
+// BootstrapPeers возвращает bootstrap узлы из конфигурации и удаленного списка (если задан).
+// Ошибка загрузки удаленного списка не мешает использовать статические узлы.
+func BootstrapPeers(ctx context.Context, opts Options) []string {
+	peers := append([]string{}, opts.BootstrapPeers...)
+
+	if opts.BootstrapListURL != "" {
+		ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+		defer cancel()
+
+		list, err := FetchBootstrapList(ctx, opts.BootstrapListURL, opts.BootstrapListKey)
+		if err != nil {
+			log.Printf("Bootstrap list unavailable: %v", err)
+		} else {
+			peers = append(peers, list.Peers...)
+		}
+	}
+
+	seen := make(map[string]bool, len(peers))
+	unique := peers[:0]
+	for _, peer := range peers {
+		if peer != "" && !seen[peer] {
+			seen[peer] = true
+			unique = append(unique, peer)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,70 @@
+package discovery
+
+import (
+	"sync"
+	"time"
+)
+
+// Типы событий пиров
+const (
+	PeerJoined = "joined" // пир обнаружен или снова отвечает
+	PeerLeft   = "left"   // пир перестал отвечать (PeerStaleAfter) или удален из таблицы
+)
+
+// PeerEvent - появление или исчезновение пира. Несовместимые пиры событий не порождают.
+type PeerEvent struct {
+	Type string    `json:"type"`
+	Peer PeerInfo  `json:"peer"`
+	At   time.Time `json:"at"`
+}
+
+// subscribers - обработчики событий пиров
+type subscribers struct {
+	mu       sync.Mutex
+	next     int
+	handlers map[int]func(PeerEvent)
+}
+
+// subscribe добавляет обработчик и возвращает функцию отписки
+func (s *subscribers) subscribe(handler func(PeerEvent)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[int]func(PeerEvent))
+	}
+	id := s.next
+	s.next++
+	s.handlers[id] = handler
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+// emit передает события всем обработчикам по порядку. Вызывается без блокировок таблицы
+// пиров, поэтому обработчики могут обращаться к ней.
+func (s *subscribers) emit(events []PeerEvent) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	handlers := make([]func(PeerEvent), 0, len(s.handlers))
+	for _, h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.mu.Unlock()
+
+	for _, event := range events {
+		for _, h := range handlers {
+			h(event)
+		}
+	}
+}
+
+// Subscribe подписывает handler на появление и исчезновение пиров и возвращает функцию
+// отписки. Обработчик вызывается из горутины обнаружения и не должен надолго блокироваться.
+func (sd *ServiceDiscovery) Subscribe(handler func(PeerEvent)) (unsubscribe func()) {
+	return sd.events.subscribe(handler)
+}
@@ -2,9 +2,14 @@ package discovery
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"hydra/pkg/transport/mesh"
 	"log"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,28 +20,106 @@ type AutoPeerManager struct {
 	mesh         *mesh.MeshTransport
 	updateTicker *time.Ticker
 	stopChan     chan struct{}
+	opts         Options
+	seeding      bool // идет опрос bootstrap узлов
+	events       subscribers
 	mu           sync.Mutex
+
+	// Таблица пиров: статические (добавленные вручную, сохраняются в PeerStore),
+	// ответившие bootstrap узлы и текущий список, переданный mesh транспорту
+	static       map[string]bool
+	bootstrapped []string
+	current      []string
+}
+
+// PeerStore сохраняет статические пиры между перезапусками (storage.Storage)
+type PeerStore interface {
+	ListStaticPeers(ctx context.Context) ([]string, error)
+	AddStaticPeer(ctx context.Context, addr string) error
+	RemoveStaticPeer(ctx context.Context, addr string) error
 }
 
-func NewAutoPeerManager(meshPort int) (*AutoPeerManager, error) {
-	// Создаем discovery сервис
-	discovery := New("_hydra-messenger._tcp", meshPort)
+// Options - сетевые параметры узла mesh
+type Options struct {
+	ListenPort    int    // TCP порт mesh
+	BindInterface string // интерфейс (имя или IP) для слушателя и mDNS; пусто - все интерфейсы
+	AdvertiseAddr string // адрес (host:port), сообщаемый другим узлам; пусто - определяется автоматически
+
+	// Bootstrap узлы опрашиваются, пока mDNS никого не нашел
+	BootstrapPeers   []string          // адреса host:port из конфигурации
+	BootstrapListURL string            // URL подписанного списка (SignedBootstrapList); пусто - не используется
+	BootstrapListKey ed25519.PublicKey // ключ для проверки подписи списка
+
+	// Хранилище статических пиров; nil - статические пиры живут до перезапуска
+	PeerStore PeerStore
+
+	// Ключи узлов, чьи анонсы mDNS принимаются; пусто - любой анонс с верной подписью
+	TrustedKeys []ed25519.PublicKey
+	// Постоянный ключ узла; nil - случайный при каждом запуске
+	NodeKey ed25519.PrivateKey
+}
+
+func NewAutoPeerManager(opts Options) (*AutoPeerManager, error) {
+	listenAddr, err := mesh.BindAddr(opts.BindInterface, opts.ListenPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mesh bind interface: %v", err)
+	}
+
+	// Через mDNS анонсируется тот же адрес, что и в PEX
+	advertiseIP, advertisePort := "", opts.ListenPort
+	if opts.AdvertiseAddr != "" {
+		host, port, err := net.SplitHostPort(opts.AdvertiseAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mesh advertise address: %v", err)
+		}
+		if net.ParseIP(host) != nil {
+			advertiseIP = host
+		}
+		if advertisePort, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid mesh advertise port: %v", err)
+		}
+	} else if host, _, _ := net.SplitHostPort(listenAddr); host != "" {
+		advertiseIP = host
+	}
 
 	// Создаем Mesh транспорт с пустым списком пиров (будет обновляться автоматически)
 	meshTransport := mesh.New([]string{})
+	meshTransport.SetListenAddr(listenAddr)
+	meshTransport.SetAdvertiseAddr(opts.AdvertiseAddr)
+	if opts.NodeKey != nil {
+		meshTransport.SetIdentity(opts.NodeKey)
+	}
+
+	// Создаем discovery сервис; анонсы подписываются ключом узла mesh
+	discovery := New("_hydra-messenger._tcp", advertisePort)
+	discovery.SetAdvertiseIP(advertiseIP)
+	discovery.SetSigner(meshTransport)
+	discovery.SetTrustedKeys(opts.TrustedKeys)
 
 	manager := &AutoPeerManager{
 		discovery:    discovery,
 		mesh:         meshTransport,
 		updateTicker: time.NewTicker(15 * time.Second), // Обновляем пиры каждые 15 секунд
 		stopChan:     make(chan struct{}),
+		opts:         opts,
+		static:       make(map[string]bool),
 	}
 
-	// Запускаем discovery
-	if err := discovery.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start discovery: %v", err)
+	// Статические пиры, добавленные до перезапуска
+	if opts.PeerStore != nil {
+		peers, err := opts.PeerStore.ListStaticPeers(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load static peers: %v", err)
+		}
+		for _, peer := range peers {
+			manager.static[peer] = true
+		}
+		manager.applyPeersLocked()
 	}
 
+	// Появление и исчезновение пиров применяется сразу, не дожидаясь периодического обновления
+	discovery.Subscribe(manager.handlePeerEvent)
+
 	// Запускаем автоматическое обновление пиров
 	go manager.autoUpdatePeers()
 
@@ -50,6 +133,15 @@ func (m *AutoPeerManager) Start() error {
 		return fmt.Errorf("failed to connect mesh transport: %v", err)
 	}
 
+	// Анонс mDNS запускается после mesh транспорта: в нем передаются возможности узла
+	// (QUIC, ретрансляция), известные только после подключения
+	if err := m.discovery.Start(); err != nil {
+		return fmt.Errorf("failed to start discovery: %v", err)
+	}
+
+	// При запуске mDNS еще никого не нашел: таблица пиров заполняется через bootstrap узлы
+	go m.seedFromBootstrap()
+
 	log.Println("Auto peer manager started")
 	return nil
 }
@@ -83,40 +175,169 @@ func (m *AutoPeerManager) updatePeerList() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Получаем обнаруженные пиры
-	discoveredPeers := m.discovery.GetPeers()
+	if discovered := m.discovery.GetPeers(); len(discovered) > 0 {
+		log.Printf("Discovered %d peers: %v", len(discovered), discovered)
+	}
+	m.applyPeersLocked()
+
+	// mDNS никого не нашел и bootstrap узлы еще не ответили - пробуем снова
+	if len(m.discovery.GetPeers()) == 0 && len(m.bootstrapped) == 0 && !m.seeding {
+		go m.seedFromBootstrap()
+	}
+}
+
+// applyPeersLocked объединяет статические и обнаруженные пиры (а если mDNS никого не нашел -
+// bootstrap узлы) и передает список mesh транспорту, если он изменился. Вызывается под m.mu.
+func (m *AutoPeerManager) applyPeersLocked() {
+	discovered := m.discovery.GetPeers()
+
+	seen := make(map[string]bool)
+	var peers []string
+	add := func(list []string) {
+		for _, peer := range list {
+			if !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+	for peer := range m.static {
+		add([]string{peer})
+	}
+	add(discovered)
+	if len(discovered) == 0 {
+		add(m.bootstrapped)
+	}
+	sort.Strings(peers)
+
+	if slices.Equal(peers, m.current) {
+		return
+	}
+	m.current = peers
+	m.mesh.UpdatePeers(peers)
+}
+
+// handlePeerEvent обновляет список пиров mesh при появлении или исчезновении пира,
+// с новым пиром сразу обменивается анонсами PEX и передает событие подписчикам менеджера
+func (m *AutoPeerManager) handlePeerEvent(event PeerEvent) {
+	m.mu.Lock()
+	m.applyPeersLocked()
+	m.mu.Unlock()
 
-	if len(discoveredPeers) > 0 {
-		log.Printf("Discovered %d peers: %v", len(discoveredPeers), discoveredPeers)
+	if event.Type == PeerJoined {
+		go m.mesh.Bootstrap([]string{event.Peer.Addr})
+	}
+	m.events.emit([]PeerEvent{event})
+}
 
-		// Обновляем пиры в Mesh транспорте
-		m.mesh.UpdatePeers(discoveredPeers)
+// Subscribe подписывает handler на появление и исчезновение пиров, обнаруженных через mDNS,
+// и возвращает функцию отписки. К моменту вызова список пиров mesh уже обновлен.
+// Обработчик не должен надолго блокироваться.
+func (m *AutoPeerManager) Subscribe(handler func(PeerEvent)) (unsubscribe func()) {
+	return m.events.subscribe(handler)
+}
+
+// seedFromBootstrap опрашивает bootstrap узлы (обмен PEX) и использует ответившие как пиры.
+// Остальную сеть узел узнает из их анонсов.
+func (m *AutoPeerManager) seedFromBootstrap() {
+	m.mu.Lock()
+	if m.seeding || (len(m.opts.BootstrapPeers) == 0 && m.opts.BootstrapListURL == "") {
+		m.mu.Unlock()
+		return
 	}
+	m.seeding = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.seeding = false
+		m.mu.Unlock()
+	}()
+
+	peers := BootstrapPeers(context.Background(), m.opts)
+	reachable := m.mesh.Bootstrap(peers)
+	if len(reachable) == 0 {
+		log.Printf("No bootstrap peers reachable (%d tried)", len(peers))
+		return
+	}
+
+	// Bootstrap узлы используются, пока mDNS никого не нашел
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bootstrapped = reachable
+	m.applyPeersLocked()
+	log.Printf("Seeded mesh from %d bootstrap peers: %v", len(reachable), reachable)
 }
 
-// AddStaticPeer добавляет статический peer (ручное подключение)
-func (m *AutoPeerManager) AddStaticPeer(peerAddr string) {
+// AddStaticPeer добавляет статический пир (ручное подключение). Пир сохраняется
+// в PeerStore и используется вместе с обнаруженными через mDNS.
+func (m *AutoPeerManager) AddStaticPeer(peerAddr string) error {
+	if _, _, err := net.SplitHostPort(peerAddr); err != nil {
+		return fmt.Errorf("invalid peer address: %v", err)
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Здесь будет логика добавления статического пира
+	if m.static[peerAddr] {
+		return nil
+	}
+	if m.opts.PeerStore != nil {
+		if err := m.opts.PeerStore.AddStaticPeer(context.Background(), peerAddr); err != nil {
+			return err
+		}
+	}
+	m.static[peerAddr] = true
+	m.applyPeersLocked()
+
 	log.Printf("Added static peer: %s", peerAddr)
+	return nil
 }
 
-// RemovePeer удаляет peer из списка
-func (m *AutoPeerManager) RemovePeer(peerAddr string) {
+// RemovePeer удаляет статический пир. Пир, обнаруженный через mDNS, вернется
+// в список при следующем обнаружении.
+func (m *AutoPeerManager) RemovePeer(peerAddr string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Здесь будет логика удаления пира
+	if !m.static[peerAddr] {
+		return fmt.Errorf("peer %s is not a static peer", peerAddr)
+	}
+	if m.opts.PeerStore != nil {
+		if err := m.opts.PeerStore.RemoveStaticPeer(context.Background(), peerAddr); err != nil {
+			return err
+		}
+	}
+	delete(m.static, peerAddr)
+	m.applyPeersLocked()
+
 	log.Printf("Removed peer: %s", peerAddr)
+	return nil
 }
 
-// GetPeerList возвращает текущий список пиров
+// GetPeerList возвращает текущий список пиров mesh транспорта (без изученных через PEX)
 func (m *AutoPeerManager) GetPeerList() []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Здесь будет возвращаться актуальный список пиров
-	return []string{} // Заглушка
+	return append([]string{}, m.current...)
+}
+
+// GetPeerInfos возвращает обнаруженные через mDNS пиры со временем последней доступности
+// и возможностями; несовместимые пиры отмечены и не используются
+func (m *AutoPeerManager) GetPeerInfos() []PeerInfo {
+	return m.discovery.PeerInfos()
+}
+
+// GetStaticPeers возвращает статические пиры
+func (m *AutoPeerManager) GetStaticPeers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]string, 0, len(m.static))
+	for peer := range m.static {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
 }
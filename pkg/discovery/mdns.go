@@ -1,97 +1,341 @@
 package discovery
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
+	"hydra/pkg/transport/mesh"
 	"log"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/mdns"
 )
 
+// Проверка живости обнаруженных пиров: пир, не отвечающий на TCP подключение и не
+// анонсирующийся через mDNS дольше PeerStaleAfter, исключается из списка пиров,
+// а дольше PeerEvictAfter - удаляется из таблицы.
+const (
+	PeerCheckInterval = 30 * time.Second
+	PeerStaleAfter    = 90 * time.Second
+	PeerEvictAfter    = 10 * time.Minute
+
+	peerDialTimeout = 3 * time.Second
+)
+
+// PeerInfo - обнаруженный пир и время, когда он последний раз был доступен
+type PeerInfo struct {
+	ID       string    `json:"id"`
+	NodeID   string    `json:"node_id"` // ключ узла, подписавшего анонс
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"` // последний анонс mDNS или успешная проверка
+	Stale    bool      `json:"stale"`     // не отвечает дольше PeerStaleAfter
+
+	Caps        mesh.Capabilities `json:"caps"`        // возможности из анонса
+	Fingerprint string            `json:"fingerprint"` // отпечаток ключа узла
+	Compatible  bool              `json:"compatible"`  // версия протокола совместима с нашей
+}
+
+// Анонсы mDNS подписываются ключом узла mesh: в TXT записях передаются публичный ключ (pk)
+// и подпись (sig) над именем сервиса, IP, портом и остальными полями TXT. Неподписанные анонсы
+// и анонсы с неверной подписью не попадают в список пиров, поэтому узел в локальной сети не может
+// выдать себя за пир Hydra или перенаправить на себя чужой анонс. Если задан список доверенных
+// ключей, принимаются только анонсы узлов из него.
+//
+// Возможности узла передаются в тех же TXT записях: версия протокола (proto, minproto),
+// транспорты (tr), согласие ретранслировать (relay), режим ретранслятора с публичным адресом
+// (relaynode) и отпечаток ключа (fp). Возможности
+// фиксируются при запуске анонса; изменения (например, режим экономии батареи) узлы узнают из PEX.
+
+// txtVersion - версия формата TXT записей анонса
+const txtVersion = "txtv=2"
+
+// Signer подписывает анонсы ключом узла и сообщает его возможности (mesh.MeshTransport)
+type Signer interface {
+	NodeID() string // base64 публичного ключа ed25519
+	Sign(data []byte) []byte
+	Capabilities() mesh.Capabilities
+}
+
 // ServiceDiscovery управляет автоматическим обнаружением пиров через mDNS
 type ServiceDiscovery struct {
-	serviceName string
-	port        int
-	peers       map[string]string // peerID -> address
-	mu          sync.RWMutex
-	stopChan    chan struct{}
+	serviceName  string
+	port         int
+	advertiseIP  string               // IP для анонса; пусто - первый не-loopback IPv4
+	signer       Signer               // подпись собственного анонса
+	trusted      map[string]bool      // доверенные ключи (base64); пусто - любой подписанный анонс
+	peers        map[string]*PeerInfo // peerID -> пир
+	server       *mdns.Server         // текущий анонс; nil - узел не анонсируется
+	advertisedIP string
+	localIP      func() (string, error) // определение IP для анонса, если он не задан явно
+	events       subscribers
+	mu           sync.RWMutex
+	stopChan     chan struct{}
+	stopOnce     sync.Once
 }
 
 func New(serviceName string, port int) *ServiceDiscovery {
 	return &ServiceDiscovery{
 		serviceName: serviceName,
 		port:        port,
-		peers:       make(map[string]string),
+		peers:       make(map[string]*PeerInfo),
+		localIP:     getLocalIP,
 		stopChan:    make(chan struct{}),
 	}
 }
 
-// Start запускает mDNS сервер для анонса и обнаружения сервисов
-func (sd *ServiceDiscovery) Start() error {
-	// Получаем локальный IP для анонса
-	localIP, err := getLocalIP()
-	if err != nil {
-		return fmt.Errorf("failed to get local IP: %v", err)
+// SetAdvertiseIP задает IP, анонсируемый через mDNS (до Start)
+func (sd *ServiceDiscovery) SetAdvertiseIP(ip string) {
+	sd.advertiseIP = ip
+}
+
+// SetSigner задает ключ, которым подписывается собственный анонс (до Start)
+func (sd *ServiceDiscovery) SetSigner(signer Signer) {
+	sd.signer = signer
+}
+
+// SetTrustedKeys ограничивает пиров узлами с перечисленными ключами (до Start)
+func (sd *ServiceDiscovery) SetTrustedKeys(keys []ed25519.PublicKey) {
+	sd.trusted = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		sd.trusted[base64.StdEncoding.EncodeToString(key)] = true
+	}
+}
+
+// announcementPayload - подписываемые данные анонса: адрес и поля TXT кроме подписи
+func announcementPayload(service, ip string, port int, fields []string) []byte {
+	return []byte(fmt.Sprintf("hydra-mdns|%s|%s|%d|%s", service, ip, port, strings.Join(fields, "|")))
+}
+
+// capabilityFields возвращает поля TXT с возможностями узла
+func capabilityFields(caps mesh.Capabilities, fingerprint string) []string {
+	relay := "0"
+	if caps.Relay {
+		relay = "1"
 	}
+	relayNode := "0"
+	if caps.RelayNode {
+		relayNode = "1"
+	}
+	return []string{
+		"proto=" + strconv.Itoa(caps.Version),
+		"minproto=" + strconv.Itoa(caps.MinVersion),
+		"tr=" + strings.Join(caps.Transports, ","),
+		"relay=" + relay,
+		"relaynode=" + relayNode,
+		"fp=" + fingerprint,
+	}
+}
 
-	// Анонсируем наш сервис
-	if err := sd.advertiseService(localIP); err != nil {
-		return fmt.Errorf("failed to advertise service: %v", err)
+// parseCapabilities разбирает возможности узла из полей TXT
+func parseCapabilities(txt []string) (mesh.Capabilities, string) {
+	var caps mesh.Capabilities
+	var fingerprint string
+	for _, field := range txt {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "proto":
+			caps.Version, _ = strconv.Atoi(value)
+		case "minproto":
+			caps.MinVersion, _ = strconv.Atoi(value)
+		case "tr":
+			if value != "" {
+				caps.Transports = strings.Split(value, ",")
+			}
+		case "relay":
+			caps.Relay = value == "1"
+		case "relaynode":
+			caps.RelayNode = value == "1"
+		case "fp":
+			fingerprint = value
+		}
+	}
+	return caps, fingerprint
+}
+
+// verifyAnnouncement проверяет подпись анонса и возвращает ключ узла
+func (sd *ServiceDiscovery) verifyAnnouncement(ip string, port int, txt []string) (string, error) {
+	var pk, sig string
+	var fields []string
+	for _, field := range txt {
+		if value, ok := strings.CutPrefix(field, "sig="); ok {
+			sig = value
+			continue
+		}
+		if value, ok := strings.CutPrefix(field, "pk="); ok {
+			pk = value
+		}
+		fields = append(fields, field)
+	}
+	if pk == "" || sig == "" {
+		return "", fmt.Errorf("unsigned announcement")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(pk)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid node key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(key, announcementPayload(sd.serviceName, ip, port, fields), signature) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	if _, fingerprint := parseCapabilities(fields); fingerprint != mesh.Fingerprint(pk) {
+		return "", fmt.Errorf("fingerprint does not match node key")
+	}
+	if len(sd.trusted) > 0 && !sd.trusted[pk] {
+		return "", fmt.Errorf("untrusted node key")
+	}
+	return pk, nil
+}
+
+// Start запускает анонс и обнаружение сервисов. Анонс работает до Stop; если IP не задан
+// через SetAdvertiseIP, он определяется автоматически и отслеживается: пока адреса нет,
+// узел не анонсируется, а при смене адреса анонс пересоздается.
+func (sd *ServiceDiscovery) Start() error {
+	if sd.advertiseIP != "" {
+		if err := sd.advertise(sd.advertiseIP); err != nil {
+			return fmt.Errorf("failed to advertise service: %v", err)
+		}
+	} else {
+		localIP, err := sd.localIP()
+		if err != nil {
+			log.Printf("Warning: no local IP for mDNS announcement yet (%v), waiting for network", err)
+		} else if err := sd.advertise(localIP); err != nil {
+			return fmt.Errorf("failed to advertise service: %v", err)
+		}
+		go sd.watchAddress()
 	}
 
-	// Запускаем обнаружение других сервисов
+	// Запускаем обнаружение других сервисов и проверку живости найденных
 	go sd.discoverServices()
+	go sd.checkPeers()
 
 	log.Printf("mDNS discovery started. Service: %s, Port: %d", sd.serviceName, sd.port)
 	return nil
 }
 
-// Stop останавливает обнаружение
+// Stop останавливает обнаружение и анонс; повторный вызов ничего не делает
 func (sd *ServiceDiscovery) Stop() {
-	close(sd.stopChan)
+	sd.stopOnce.Do(func() {
+		sd.mu.Lock()
+		defer sd.mu.Unlock()
+
+		close(sd.stopChan)
+		if sd.server != nil {
+			sd.server.Shutdown()
+			sd.server = nil
+		}
+	})
 }
 
-// GetPeers возвращает список обнаруженных пиров
+// GetPeers возвращает адреса обнаруженных живых пиров с совместимой версией протокола
 func (sd *ServiceDiscovery) GetPeers() []string {
 	sd.mu.RLock()
 	defer sd.mu.RUnlock()
 
 	peers := make([]string, 0, len(sd.peers))
-	for _, addr := range sd.peers {
-		peers = append(peers, addr)
+	for _, peer := range sd.peers {
+		if !peer.Stale && peer.Compatible {
+			peers = append(peers, peer.Addr)
+		}
 	}
 	return peers
 }
 
-// advertiseService анонсирует наш сервис через mDNS
-func (sd *ServiceDiscovery) advertiseService(ip string) error {
-	// Создаем mDNS сервер для анонса
-	service, err := mdns.NewMDNSService(
-		"Hydra Messenger",
-		sd.serviceName,
-		"",
-		"",
-		sd.port,
-		[]net.IP{net.ParseIP(ip)},
-		[]string{"txtv=1", "type=messenger"},
-	)
-	if err != nil {
-		return err
+// PeerInfos возвращает все обнаруженные пиры, включая неотвечающие, по ID
+func (sd *ServiceDiscovery) PeerInfos() []PeerInfo {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	peers := make([]PeerInfo, 0, len(sd.peers))
+	for _, peer := range sd.peers {
+		peers = append(peers, *peer)
 	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
 
-	server, err := mdns.NewServer(&mdns.Config{
-		Zone: service,
-	})
-	if err != nil {
-		return err
+// checkPeers периодически проверяет обнаруженные пиры TCP подключением
+func (sd *ServiceDiscovery) checkPeers() {
+	ticker := time.NewTicker(PeerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sd.stopChan:
+			return
+		case <-ticker.C:
+			sd.checkPeersOnce()
+		}
 	}
+}
 
-	// Сервер будет работать в фоне
-	defer server.Shutdown()
+// checkPeersOnce проверяет все пиры параллельно, помечает неотвечающие и удаляет давно пропавшие
+func (sd *ServiceDiscovery) checkPeersOnce() {
+	sd.mu.RLock()
+	addrs := make(map[string]string, len(sd.peers))
+	for id, peer := range sd.peers {
+		addrs[id] = peer.Addr
+	}
+	sd.mu.RUnlock()
 
-	return nil
+	alive := make(map[string]bool, len(addrs))
+	var wg sync.WaitGroup
+	var aliveMu sync.Mutex
+	for id, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", addr, peerDialTimeout)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			aliveMu.Lock()
+			alive[id] = true
+			aliveMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var events []PeerEvent
+	sd.mu.Lock()
+	for id, peer := range sd.peers {
+		if alive[id] {
+			peer.LastSeen = now
+		}
+		wasLive := !peer.Stale && peer.Compatible
+		silent := now.Sub(peer.LastSeen)
+		switch {
+		case silent > PeerEvictAfter:
+			delete(sd.peers, id)
+			log.Printf("Evicted peer %s (%s): not seen since %s", id, peer.Addr, peer.LastSeen.Format(time.RFC3339))
+			if wasLive {
+				events = append(events, PeerEvent{Type: PeerLeft, Peer: *peer, At: now})
+			}
+		case silent > PeerStaleAfter:
+			if !peer.Stale {
+				log.Printf("Peer %s (%s) is stale", id, peer.Addr)
+			}
+			peer.Stale = true
+			if wasLive {
+				events = append(events, PeerEvent{Type: PeerLeft, Peer: *peer, At: now})
+			}
+		default:
+			peer.Stale = false
+			if !wasLive && peer.Compatible {
+				events = append(events, PeerEvent{Type: PeerJoined, Peer: *peer, At: now})
+			}
+		}
+	}
+	sd.mu.Unlock()
+
+	sd.events.emit(events)
 }
 
 // discoverServices ищет другие сервисы в сети
@@ -124,12 +368,42 @@ func (sd *ServiceDiscovery) discoverServices() {
 		case entry := <-entries:
 			if entry.AddrV4 != nil {
 				peerAddr := fmt.Sprintf("%s:%d", entry.AddrV4.String(), entry.Port)
+				nodeID, err := sd.verifyAnnouncement(entry.AddrV4.String(), entry.Port, entry.InfoFields)
+				if err != nil {
+					log.Printf("Ignoring mDNS announcement %s (%s): %v", entry.Name, peerAddr, err)
+					continue
+				}
+				if sd.signer != nil && nodeID == sd.signer.NodeID() {
+					continue
+				}
 
+				caps, fingerprint := parseCapabilities(entry.InfoFields)
+				peer := &PeerInfo{
+					ID:          entry.Name,
+					NodeID:      nodeID,
+					Addr:        peerAddr,
+					LastSeen:    time.Now(),
+					Caps:        caps,
+					Fingerprint: fingerprint,
+					Compatible:  caps.Compatible(),
+				}
 				sd.mu.Lock()
-				sd.peers[entry.Name] = peerAddr
+				prev := sd.peers[entry.Name]
+				sd.peers[entry.Name] = peer
 				sd.mu.Unlock()
 
-				log.Printf("Discovered peer: %s (%s)", entry.Name, peerAddr)
+				wasLive := prev != nil && !prev.Stale && prev.Compatible
+				if peer.Compatible && !wasLive {
+					sd.events.emit([]PeerEvent{{Type: PeerJoined, Peer: *peer, At: peer.LastSeen}})
+				} else if !peer.Compatible && wasLive {
+					sd.events.emit([]PeerEvent{{Type: PeerLeft, Peer: *peer, At: peer.LastSeen}})
+				}
+
+				if !peer.Compatible {
+					log.Printf("Discovered incompatible peer: %s (%s), protocol %d-%d", entry.Name, peerAddr, caps.MinVersion, caps.Version)
+					continue
+				}
+				log.Printf("Discovered peer: %s (%s) fp=%s transports=%v relay=%t", entry.Name, peerAddr, fingerprint, caps.Transports, caps.Relay)
 			}
 		}
 	}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"hydra/pkg/transport/mesh"
+	"net"
+	"testing"
+	"time"
+)
+
+// signedTXT возвращает поля TXT анонса узла node по адресу ip:port
+func signedTXT(sd *ServiceDiscovery, node *mesh.MeshTransport, caps mesh.Capabilities, ip string, port int) []string {
+	txt := append([]string{txtVersion, "type=messenger"}, capabilityFields(caps, mesh.Fingerprint(node.NodeID()))...)
+	txt = append(txt, "pk="+node.NodeID())
+	return append(txt, "sig="+base64.StdEncoding.EncodeToString(node.Sign(announcementPayload(sd.serviceName, ip, port, txt))))
+}
+
+// TestSignedAnnouncements проверяет, что принимаются только подписанные анонсы с верным адресом
+func TestSignedAnnouncements(t *testing.T) {
+	node := mesh.New(nil)
+	sd := New("_hydra-messenger._tcp", 8080)
+
+	txt := signedTXT(sd, node, node.Capabilities(), "192.168.1.10", 8080)
+
+	if nodeID, err := sd.verifyAnnouncement("192.168.1.10", 8080, txt); err != nil || nodeID != node.NodeID() {
+		t.Fatalf("valid announcement rejected: %v", err)
+	}
+	if _, err := sd.verifyAnnouncement("192.168.1.10", 8080, []string{txtVersion}); err == nil {
+		t.Error("unsigned announcement accepted")
+	}
+	// Чужой анонс, переадресованный на другой адрес, не проходит проверку
+	if _, err := sd.verifyAnnouncement("192.168.1.66", 8080, txt); err == nil {
+		t.Error("announcement with another address accepted")
+	}
+
+	// Со списком доверенных ключей принимаются только перечисленные узлы
+	other, _, _ := ed25519.GenerateKey(nil)
+	sd.SetTrustedKeys([]ed25519.PublicKey{other})
+	if _, err := sd.verifyAnnouncement("192.168.1.10", 8080, txt); err == nil {
+		t.Error("announcement of untrusted node accepted")
+	}
+	key, _ := base64.StdEncoding.DecodeString(node.NodeID())
+	sd.SetTrustedKeys([]ed25519.PublicKey{other, key})
+	if _, err := sd.verifyAnnouncement("192.168.1.10", 8080, txt); err != nil {
+		t.Errorf("trusted node rejected: %v", err)
+	}
+}
+
+// TestAnnouncedCapabilities проверяет разбор возможностей из анонса и защиту их подписью
+func TestAnnouncedCapabilities(t *testing.T) {
+	node := mesh.New(nil)
+	sd := New("_hydra-messenger._tcp", 8080)
+
+	sent := mesh.Capabilities{Version: 1, MinVersion: 1, Transports: []string{mesh.LinkTCP, mesh.LinkQUIC}, Relay: false, RelayNode: true}
+	txt := signedTXT(sd, node, sent, "192.168.1.10", 8080)
+	if _, err := sd.verifyAnnouncement("192.168.1.10", 8080, txt); err != nil {
+		t.Fatalf("valid announcement rejected: %v", err)
+	}
+
+	caps, fingerprint := parseCapabilities(txt)
+	if caps.Version != 1 || caps.Relay || !caps.RelayNode || !caps.Supports(mesh.LinkQUIC) || !caps.Compatible() {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+	if fingerprint != mesh.Fingerprint(node.NodeID()) {
+		t.Errorf("unexpected fingerprint %q", fingerprint)
+	}
+
+	// Подмена возможностей ломает подпись
+	tampered := append([]string{}, txt...)
+	for i, field := range tampered {
+		if field == "relay=0" {
+			tampered[i] = "relay=1"
+		}
+	}
+	if _, err := sd.verifyAnnouncement("192.168.1.10", 8080, tampered); err == nil {
+		t.Error("announcement with tampered capabilities accepted")
+	}
+
+	// Узел с более новым несовместимым протоколом не попадает в список пиров
+	future := mesh.Capabilities{Version: mesh.ProtocolVersion + 2, MinVersion: mesh.ProtocolVersion + 1, Transports: []string{mesh.LinkTCP}}
+	if future.Compatible() {
+		t.Fatal("future protocol reported as compatible")
+	}
+	sd.peers["a"] = &PeerInfo{ID: "a", Addr: "192.168.1.10:8080", Caps: sent, Compatible: true}
+	sd.peers["b"] = &PeerInfo{ID: "b", Addr: "192.168.1.11:8080", Caps: future}
+	if peers := sd.GetPeers(); len(peers) != 1 || peers[0] != "192.168.1.10:8080" {
+		t.Errorf("expected only the compatible peer, got %v", peers)
+	}
+}
+
+// TestPeerEvents проверяет события при исчезновении и возвращении пиров
+func TestPeerEvents(t *testing.T) {
+	sd := New("_hydra-messenger._tcp", 8080)
+
+	var events []PeerEvent
+	unsubscribe := sd.Subscribe(func(e PeerEvent) { events = append(events, e) })
+
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer live.Close()
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	old := time.Now().Add(-2 * PeerStaleAfter)
+	sd.peers["back"] = &PeerInfo{ID: "back", Addr: live.Addr().String(), LastSeen: old, Stale: true, Compatible: true}
+	sd.peers["gone"] = &PeerInfo{ID: "gone", Addr: deadAddr, LastSeen: old, Compatible: true}
+	sd.peers["old"] = &PeerInfo{ID: "old", Addr: deadAddr, LastSeen: old, Compatible: false}
+	sd.checkPeersOnce()
+
+	got := make(map[string]string)
+	for _, e := range events {
+		got[e.Peer.ID] = e.Type
+	}
+	if len(events) != 2 || got["back"] != PeerJoined || got["gone"] != PeerLeft {
+		t.Errorf("unexpected events: %+v", events)
+	}
+
+	// После отписки события не передаются
+	unsubscribe()
+	sd.peers["back"].Stale = true
+	sd.checkPeersOnce()
+	if len(events) != 2 {
+		t.Errorf("events delivered after unsubscribe: %+v", events)
+	}
+}
+
+// TestAnnouncementLifetime проверяет, что анонс живет до Stop и переезжает на новый IP
+func TestAnnouncementLifetime(t *testing.T) {
+	sd := New("_hydra-test._tcp", 8080)
+	sd.SetSigner(mesh.New(nil))
+	ip := "192.168.1.10"
+	sd.localIP = func() (string, error) { return ip, nil }
+
+	if err := sd.advertise(ip); err != nil {
+		t.Skipf("mDNS server unavailable: %v", err)
+	}
+	if got := sd.AdvertisedIP(); got != "192.168.1.10" {
+		t.Fatalf("expected announcement on 192.168.1.10, got %q", got)
+	}
+
+	// Адрес не сменился - сервер тот же
+	server := sd.server
+	sd.checkAddressOnce()
+	if sd.server != server {
+		t.Error("announcement recreated without address change")
+	}
+
+	ip = "192.168.1.20"
+	sd.checkAddressOnce()
+	if got := sd.AdvertisedIP(); got != "192.168.1.20" {
+		t.Errorf("expected announcement moved to 192.168.1.20, got %q", got)
+	}
+
+	sd.Stop()
+	sd.Stop()
+	if sd.AdvertisedIP() != "" {
+		t.Error("announcement alive after Stop")
+	}
+	if err := sd.advertise(ip); err == nil {
+		t.Error("announcement restarted after Stop")
+	}
+}
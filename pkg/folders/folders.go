@@ -0,0 +1,96 @@
+// Package folders реализует папки чатов с правилами: пользователь задает папки и условия
+// (отправитель, группа, ключевое слово), сервер раскладывает по ним беседы при получении
+// сообщений, и раскладка одинакова на всех устройствах пользователя. Нужно подписчикам
+// каналов с большим потоком сообщений, чтобы основной список чатов оставался читаемым.
+package folders
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxFolders - сколько папок может создать пользователь
+	MaxFolders = 20
+	// MaxRules - сколько правил может быть в одной папке
+	MaxRules = 50
+	// MaxNameLength - максимальная длина имени папки в символах
+	MaxNameLength = 64
+)
+
+// Rule - условие попадания беседы в папку. Заданные поля должны совпасть все;
+// пустое поле не проверяется.
+type Rule struct {
+	Sender  string `json:"sender,omitempty"`  // ID отправителя
+	Group   string `json:"group,omitempty"`   // ID беседы (группы, комнаты звонка)
+	Keyword string `json:"keyword,omitempty"` // подстрока текста без учета регистра
+}
+
+// Folder - папка чатов пользователя. Беседа попадает в папку, если сообщение в ней
+// подходит под любое из правил.
+type Folder struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Rules     []Rule    `json:"rules"`
+	Position  int       `json:"position"` // порядок папок; при нескольких совпадениях выигрывает меньший
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Message - то, что известно правилам о сообщении
+type Message struct {
+	ConversationID string
+	SenderID       string
+	Body           string
+}
+
+// Validate проверяет имя и правила папки
+func Validate(f *Folder) error {
+	name := strings.TrimSpace(f.Name)
+	if name == "" || len([]rune(name)) > MaxNameLength {
+		return fmt.Errorf("folder name must be from 1 to %d characters", MaxNameLength)
+	}
+	if len(f.Rules) > MaxRules {
+		return fmt.Errorf("at most %d rules per folder", MaxRules)
+	}
+	for i, rule := range f.Rules {
+		if rule.Sender == "" && rule.Group == "" && strings.TrimSpace(rule.Keyword) == "" {
+			return fmt.Errorf("rule %d has no conditions", i+1)
+		}
+	}
+	return nil
+}
+
+// Matches сообщает, подходит ли сообщение под правило
+func (r Rule) Matches(msg Message) bool {
+	if r.Sender != "" && r.Sender != msg.SenderID {
+		return false
+	}
+	if r.Group != "" && r.Group != msg.ConversationID {
+		return false
+	}
+	if keyword := strings.TrimSpace(r.Keyword); keyword != "" &&
+		!strings.Contains(strings.ToLower(msg.Body), strings.ToLower(keyword)) {
+		return false
+	}
+	return true
+}
+
+// Match возвращает папку для сообщения: первую по Position, одно из правил которой
+// подходит; nil - ни одна папка не подходит
+func Match(folders []*Folder, msg Message) *Folder {
+	var best *Folder
+	for _, f := range folders {
+		if best != nil && f.Position >= best.Position {
+			continue
+		}
+		for _, rule := range f.Rules {
+			if rule.Matches(msg) {
+				best = f
+				break
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,43 @@
+package folders
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	news := &Folder{ID: "news", Name: "Новости", Position: 2, Rules: []Rule{{Group: "channel-news"}, {Keyword: "Дайджест"}}}
+	work := &Folder{ID: "work", Name: "Работа", Position: 1, Rules: []Rule{{Sender: "boss"}, {Group: "team", Keyword: "срочно"}}}
+	all := []*Folder{news, work}
+
+	cases := []struct {
+		msg  Message
+		want *Folder
+	}{
+		{Message{ConversationID: "channel-news", SenderID: "bot", Body: "курс валют"}, news},
+		{Message{ConversationID: "alice", SenderID: "alice", Body: "еженедельный дайджест"}, news},
+		{Message{ConversationID: "boss", SenderID: "boss", Body: "привет"}, work},
+		{Message{ConversationID: "team", SenderID: "carol", Body: "Срочно нужен отчет"}, work},
+		{Message{ConversationID: "team", SenderID: "carol", Body: "обед"}, nil},
+		// Обе папки подходят - выигрывает папка с меньшей позицией
+		{Message{ConversationID: "channel-news", SenderID: "boss", Body: "новости"}, work},
+	}
+	for _, c := range cases {
+		if got := Match(all, c.msg); got != c.want {
+			t.Errorf("Match(%+v) = %v, want %v", c.msg, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(&Folder{Name: "Каналы", Rules: []Rule{{Keyword: "релиз"}}}); err != nil {
+		t.Errorf("valid folder rejected: %v", err)
+	}
+	invalid := []*Folder{
+		{Name: " ", Rules: []Rule{{Sender: "bob"}}},
+		{Name: "Пустое правило", Rules: []Rule{{Keyword: "  "}}},
+		{Name: "Много правил", Rules: make([]Rule, MaxRules+1)},
+	}
+	for _, f := range invalid {
+		if err := Validate(f); err == nil {
+			t.Errorf("invalid folder %q accepted", f.Name)
+		}
+	}
+}
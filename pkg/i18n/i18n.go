@@ -0,0 +1,170 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Тексты API хранятся на английском: английская строка сообщения служит ключом
+// каталога перевода. Сообщения без перевода отдаются как есть, поэтому новые ошибки
+// можно добавлять в код без правки каталогов. Тексты с подстановками (коды
+// подтверждения) задаются шаблонами по ключу, см. Format.
+
+// Default - язык по умолчанию и язык исходных строк
+const Default = "en"
+
+// Ключи шаблонов сообщений с кодами подтверждения (аргумент - код)
+const (
+	VerificationSubject   = "verification.subject"
+	VerificationEmail     = "verification.email"
+	VerificationSMS       = "verification.sms"
+	IdentifierChangeEmail = "identifier_change.email"
+	IdentifierChangeSMS   = "identifier_change.sms"
+)
+
+// Ключи шаблонов приглашений: аргументы - имя пригласившего, ссылка на регистрацию и
+// (в письме) дата окончания приглашения
+const (
+	InviteSubject = "invite.subject"
+	InviteEmail   = "invite.email"
+	InviteSMS     = "invite.sms"
+	InviteAdmin   = "invite.admin" // имя пригласившего для приглашений администратора
+)
+
+// Ключи шаблонов предупреждения о подборе пароля: аргументы - IP последней попытки и
+// срок блокировки входа в минутах
+const (
+	LoginAlertSubject = "login_alert.subject"
+	LoginAlertEmail   = "login_alert.email"
+	LoginAlertSMS     = "login_alert.sms"
+)
+
+// Ключи текстов HTML-версии писем; текстовая версия собирается по шаблонам выше
+const (
+	VerificationIntro     = "verification.intro"      // строка перед кодом
+	IdentifierChangeIntro = "identifier_change.intro" // строка перед кодом
+	CodeIgnoreNote        = "code.ignore_note"
+	InviteIntro           = "invite.intro" // аргумент - имя пригласившего
+	InviteButton          = "invite.button"
+	InviteValidUntil      = "invite.valid_until" // аргумент - дата окончания приглашения
+)
+
+// templates - шаблоны сообщений по языкам; у Default есть все ключи
+var templates = map[string]map[string]string{
+	"en": {
+		VerificationSubject:   "Hydra Verification Code",
+		VerificationEmail:     "Your verification code is: %s",
+		VerificationSMS:       "Your Hydra verification code is: %s",
+		IdentifierChangeEmail: "Your code to change the account email is: %s",
+		IdentifierChangeSMS:   "Your Hydra code to change the account phone is: %s",
+		InviteSubject:         "%s invites you to Hydra",
+		InviteEmail:           "%[1]s invites you to join Hydra.\r\n\r\nRegister by this link: %[2]s\r\n\r\nThe invite is valid until %[3]s.",
+		InviteSMS:             "%[1]s invites you to Hydra: %[2]s",
+		InviteAdmin:           "The Hydra administrator",
+		LoginAlertSubject:     "Failed sign-in attempts on your Hydra account",
+		LoginAlertEmail:       "Someone entered a wrong password for your Hydra account several times, most recently from %[1]s.\r\n\r\nSign-in is locked for %[2]d minutes. If this was not you, change your password.",
+		LoginAlertSMS:         "Hydra: failed sign-in attempts on your account from %[1]s, sign-in locked for %[2]d min. Not you? Change your password.",
+		VerificationIntro:     "Enter this code in Hydra to confirm your email address:",
+		IdentifierChangeIntro: "Enter this code in Hydra to change the email of your account:",
+		CodeIgnoreNote:        "If you did not request this code, just ignore this email.",
+		InviteIntro:           "%s invites you to join Hydra.",
+		InviteButton:          "Join Hydra",
+		InviteValidUntil:      "The invite is valid until %s.",
+	},
+	"ru": {
+		VerificationSubject:   "Код подтверждения Hydra",
+		VerificationEmail:     "Ваш код подтверждения: %s",
+		VerificationSMS:       "Ваш код подтверждения Hydra: %s",
+		IdentifierChangeEmail: "Код для смены email аккаунта: %s",
+		IdentifierChangeSMS:   "Код Hydra для смены телефона аккаунта: %s",
+		InviteSubject:         "%s приглашает вас в Hydra",
+		InviteEmail:           "%[1]s приглашает вас в Hydra.\r\n\r\nЗарегистрируйтесь по ссылке: %[2]s\r\n\r\nПриглашение действует до %[3]s.",
+		InviteSMS:             "%[1]s приглашает вас в Hydra: %[2]s",
+		InviteAdmin:           "Администратор Hydra",
+		LoginAlertSubject:     "Неудачные попытки входа в аккаунт Hydra",
+		LoginAlertEmail:       "Кто-то несколько раз ввел неверный пароль от вашего аккаунта Hydra, последний раз - с адреса %[1]s.\r\n\r\nВход заблокирован на %[2]d мин. Если это были не вы, смените пароль.",
+		LoginAlertSMS:         "Hydra: неудачные попытки входа в ваш аккаунт с %[1]s, вход заблокирован на %[2]d мин. Не вы? Смените пароль.",
+		VerificationIntro:     "Введите этот код в Hydra, чтобы подтвердить адрес почты:",
+		IdentifierChangeIntro: "Введите этот код в Hydra, чтобы сменить email аккаунта:",
+		CodeIgnoreNote:        "Если вы не запрашивали код, просто проигнорируйте это письмо.",
+		InviteIntro:           "%s приглашает вас в Hydra.",
+		InviteButton:          "Присоединиться к Hydra",
+		InviteValidUntil:      "Приглашение действует до %s.",
+	},
+}
+
+// Supported сообщает, есть ли переводы для языка
+func Supported(locale string) bool {
+	_, ok := templates[locale]
+	return ok
+}
+
+// Locales возвращает поддерживаемые языки по алфавиту
+func Locales() []string {
+	locales := make([]string, 0, len(templates))
+	for locale := range templates {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Normalize приводит тег языка (ru-RU, RU, ru_ru) к поддерживаемому языку;
+// пусто - язык не поддерживается
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	primary, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if Supported(primary) {
+		return primary
+	}
+	return ""
+}
+
+// Negotiate выбирает язык по заголовку Accept-Language с учетом весов q;
+// при равных весах побеждает указанный раньше. Без подходящего языка - Default.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		locale := Normalize(tag)
+		if locale == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// Translate переводит английское сообщение API на язык locale; без перевода
+// возвращает сообщение без изменений
+func Translate(locale, msg string) string {
+	if text, ok := messages[locale][msg]; ok {
+		return text
+	}
+	return msg
+}
+
+// Format подставляет args в шаблон key на языке locale (шаблоны Default - если
+// перевода нет)
+func Format(locale, key string, args ...interface{}) string {
+	tmpl, ok := templates[locale][key]
+	if !ok {
+		tmpl = templates[Default][key]
+	}
+	if len(args) == 0 {
+		return tmpl
+	}
+	return fmt.Sprintf(tmpl, args...)
+}
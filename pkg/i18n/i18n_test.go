@@ -0,0 +1,58 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                           "en",
+		"ru-RU,ru;q=0.9,en-US;q=0.8": "ru",
+		"en-US,en;q=0.9,ru;q=0.8":    "en",
+		"de-DE, ru;q=0.5, en;q=0.4":  "ru",
+		"fr, de":                     "en",
+		"en;q=0.2, RU_ru;q=0.7":      "ru",
+		"ru;q=bogus, en;q=0.1":       "en",
+		"*":                          "en",
+		"ru;q=0.5, en;q=0.5":         "ru",
+	} {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := Translate("ru", "User not found"); got != "Пользователь не найден" {
+		t.Errorf("unexpected translation %q", got)
+	}
+	// Без перевода сообщение отдается как есть
+	if got := Translate("ru", "Quota not found"); got != "Quota not found" {
+		t.Errorf("untranslated message changed: %q", got)
+	}
+	if got := Translate("en", "User not found"); got != "User not found" {
+		t.Errorf("english message changed: %q", got)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	// Каждый перевод шаблона есть и в языке по умолчанию и принимает тот же аргумент
+	for locale, catalog := range templates {
+		for key, tmpl := range catalog {
+			base, ok := templates[Default][key]
+			if !ok {
+				t.Errorf("%s template %s missing in %s", locale, key, Default)
+			}
+			if strings.Count(tmpl, "%s") != strings.Count(base, "%s") {
+				t.Errorf("%s template %s has different arguments", locale, key)
+			}
+		}
+	}
+	if got := Format("ru", VerificationSMS, "123456"); !strings.Contains(got, "123456") || !strings.Contains(got, "код") {
+		t.Errorf("unexpected SMS text %q", got)
+	}
+	if got := Format("de", VerificationEmail, "123456"); got != "Your verification code is: 123456" {
+		t.Errorf("unsupported locale not falling back: %q", got)
+	}
+}
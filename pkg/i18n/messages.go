@@ -0,0 +1,123 @@
+package i18n
+
+// messages - переводы сообщений API по языкам; ключ - английский текст из кода
+var messages = map[string]map[string]string{
+	"ru": {
+		// Общие
+		"Method not allowed":                      "Метод не поддерживается",
+		"Invalid JSON":                            "Некорректный JSON",
+		"Invalid request body":                    "Некорректное тело запроса",
+		"Invalid cursor":                          "Некорректный курсор",
+		"Unauthorized":                            "Требуется авторизация",
+		"Not found":                               "Не найдено",
+		"Admin access required":                   "Требуются права администратора",
+		"Failed to record audit entry":            "Не удалось записать действие в журнал аудита",
+		"Server is in read-only maintenance mode": "Сервер в режиме обслуживания: изменения временно недоступны",
+		"Database is not configured":              "База данных не настроена",
+		"Streaming is not supported":              "Потоковая передача не поддерживается",
+
+		// Вход, регистрация и подтверждение
+		"Invalid credentials":                "Неверный логин или пароль",
+		"Account disabled":                   "Аккаунт заблокирован",
+		"Invalid password":                   "Неверный пароль",
+		"Password is required":               "Укажите пароль",
+		"Email or phone required":            "Укажите email или телефон",
+		"Name required":                      "Укажите имя",
+		"name required":                      "Укажите имя",
+		"Failed to create user":              "Не удалось создать пользователя",
+		"Invalid or expired token":           "Токен недействителен или истек",
+		"Invalid or expired refresh token":   "Токен обновления недействителен или истек",
+		"Invalid verification code":          "Неверный код подтверждения",
+		"Failed to create verification code": "Не удалось создать код подтверждения",
+		"Verification code sent":             "Код подтверждения отправлен",
+		"Phone number is not verified":       "Номер телефона не подтвержден",
+		"Email is not verified":              "Email не подтвержден",
+		"Too many failed attempts":           "Слишком много неудачных попыток, попробуйте позже",
+		"Challenge required":                 "Требуется пройти проверку",
+		"Challenge verification failed":      "Проверка не пройдена",
+		"Failed to issue challenge":          "Не удалось выдать проверку",
+		"Unsupported locale":                 "Язык не поддерживается",
+
+		// Проверка полей (pkg/validate)
+		"Invalid input":            "Некорректные данные",
+		"Phone number is required": "Укажите номер телефона",
+		"Phone number must be in international format, e.g. +15551234567": "Укажите номер в международном формате, например +79161234567",
+		"Email is required":                                   "Укажите email",
+		"Email address is invalid":                            "Некорректный адрес email",
+		"Name is required":                                    "Укажите имя",
+		"Name must be at most 64 characters":                  "Имя должно быть не длиннее 64 символов",
+		"Name must contain a letter":                          "Имя должно содержать букву",
+		"Password must be at least 8 characters":              "Пароль должен быть не короче 8 символов",
+		"Password must be at most 128 characters":             "Пароль должен быть не длиннее 128 символов",
+		"Password must mix letters with digits or symbols":    "Пароль должен содержать буквы и цифры или другие символы",
+		"Password is too common":                              "Пароль слишком распространенный",
+		"Password must not contain your name, email or phone": "Пароль не должен содержать имя, email или телефон",
+
+		// Пользователи и устройства
+		"User not found":            "Пользователь не найден",
+		"User ID required":          "Укажите ID пользователя",
+		"user_id required":          "Укажите user_id",
+		"Failed to update user":     "Не удалось обновить пользователя",
+		"Failed to delete user":     "Не удалось удалить пользователя",
+		"Device ID required":        "Укажите ID устройства",
+		"Device not found":          "Устройство не найдено",
+		"Failed to list devices":    "Не удалось получить список устройств",
+		"Failed to register device": "Не удалось зарегистрировать устройство",
+		"Failed to delete device":   "Не удалось удалить устройство",
+		"Avatar not found":          "Аватар не найден",
+		"Failed to save avatar":     "Не удалось сохранить аватар",
+		"Too many lookups":          "Слишком много запросов поиска",
+		"Search failed":             "Не удалось выполнить поиск",
+		"Too many contact hashes":   "Слишком много контактов в одном запросе",
+		"Too many contact syncs":    "Слишком много сверок контактов",
+		"Contact salt expired":      "Соль хешей контактов устарела",
+		"Invalid signature":         "Неверная подпись",
+		"Invite not found":          "Приглашение не найдено",
+		"Failed to create invite":   "Не удалось создать приглашение",
+		"Failed to list invites":    "Не удалось получить список приглашений",
+
+		// Сообщения, группы и файлы
+		"Message not found":                "Сообщение не найдено",
+		"Message cannot be empty":          "Сообщение не может быть пустым",
+		"Recipient not found":              "Получатель не найден",
+		"Recipient is unavailable":         "Получатель недоступен",
+		"Failed to queue message":          "Не удалось поставить сообщение в очередь",
+		"Too many messages":                "Слишком много сообщений",
+		"Too many reports":                 "Слишком много жалоб",
+		"Group not found":                  "Группа не найдена",
+		"Group media quota exceeded":       "Превышена квота медиафайлов группы",
+		"Folder not found":                 "Папка не найдена",
+		"Too many folders":                 "Слишком много папок",
+		"File not found":                   "Файл не найден",
+		"File storage is not configured":   "Файловое хранилище не настроено",
+		"Failed to read file":              "Не удалось прочитать файл",
+		"Recording not found":              "Запись не найдена",
+		"Push subscription not found":      "Подписка на уведомления не найдена",
+		"Avatar storage is not configured": "Хранилище аватаров не настроено",
+
+		// Блокировки и жалобы
+		"Cannot block yourself":       "Нельзя заблокировать самого себя",
+		"Cannot report yourself":      "Нельзя пожаловаться на самого себя",
+		"User is not blocked":         "Пользователь не заблокирован",
+		"Failed to list blocks":       "Не удалось получить список заблокированных",
+		"Failed to block user":        "Не удалось заблокировать пользователя",
+		"Failed to unblock user":      "Не удалось разблокировать пользователя",
+		"Unknown report reason":       "Неизвестная причина жалобы",
+		"Report details are too long": "Слишком длинное описание жалобы",
+		"Failed to save report":       "Не удалось сохранить жалобу",
+		"Open report not found":       "Открытая жалоба не найдена",
+
+		// Восстановление аккаунта
+		"Recovery request not found":           "Запрос восстановления не найден",
+		"Recovery request is no longer active": "Запрос восстановления больше не активен",
+		"Recovery by guardians is not set up":  "Восстановление через поручителей не настроено",
+		"Invalid recovery secret":              "Неверный секрет восстановления",
+		"Too many recovery requests":           "Слишком много запросов восстановления",
+
+		// Звонки и транспорт
+		"call_id required":                "Укажите call_id",
+		"Mesh transport is not available": "Mesh транспорт недоступен",
+		"Peer discovery is disabled":      "Обнаружение узлов отключено",
+		"Invalid peer address":            "Некорректный адрес узла",
+	},
+}
@@ -0,0 +1,63 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Идентификаторы объектов - UUID версии 7 (RFC 9562): 48 бит времени в миллисекундах,
+// далее счетчик и случайные биты. Идентификаторы, выданные процессом, упорядочены по
+// времени выдачи и как строки: внутри одной миллисекунды растет 12-битный счетчик,
+// при его переполнении время сдвигается на миллисекунду вперед.
+
+var (
+	mu      sync.Mutex
+	lastMs  int64
+	counter uint16
+	now     = time.Now
+)
+
+// New возвращает новый UUIDv7 в каноническом виде xxxxxxxx-xxxx-7xxx-yxxx-xxxxxxxxxxxx
+func New() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("ids: crypto/rand failed: " + err.Error())
+	}
+
+	mu.Lock()
+	ms := now().UnixMilli()
+	if ms <= lastMs {
+		counter++
+		if counter > 0x0fff {
+			lastMs++
+			counter = 0
+		}
+		ms = lastMs
+	} else {
+		lastMs = ms
+		// Счетчик новой миллисекунды начинается со случайного значения в младшей половине
+		counter = binary.BigEndian.Uint16(b[6:8]) & 0x07ff
+	}
+	c := counter
+	mu.Unlock()
+
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	b[6] = 0x70 | byte(c>>8)
+	b[7] = byte(c)
+	b[8] = 0x80 | b[8]&0x3f
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
@@ -0,0 +1,62 @@
+package ids
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+var uuidV7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewFormatAndOrder(t *testing.T) {
+	prev := New()
+	for i := 0; i < 10000; i++ {
+		id := New()
+		if !uuidV7.MatchString(id) {
+			t.Fatalf("not a UUIDv7: %s", id)
+		}
+		if id <= prev {
+			t.Fatalf("IDs out of order: %s after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestNewFrozenClock(t *testing.T) {
+	// Время не идет, но идентификаторы все равно растут
+	fixed := time.Now()
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	prev := New()
+	for i := 0; i < 5000; i++ {
+		id := New()
+		if id <= prev {
+			t.Fatalf("IDs out of order with a frozen clock: %s after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestNewConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := New()
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("duplicate ID %s", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,63 @@
+package lookup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поиск знакомых по адресной книге: клиент отправляет не телефоны и email, а их хеши
+// с солью сервера, и получает только совпавшие. Соль выводится из секрета сервера и
+// меняется каждый период, поэтому хеши, сохраненные промежуточным узлом или журналом,
+// нельзя сопоставить с контактами после смены соли.
+
+// ContactSalt - соль хешей контактов одного периода
+type ContactSalt struct {
+	Epoch     int64     `json:"epoch"` // номер периода с начала эпохи Unix
+	Salt      string    `json:"salt"`  // base64 соли
+	ExpiresAt time.Time `json:"expires_at"`
+	key       []byte
+	secret    []byte
+	period    time.Duration
+}
+
+// NewContactSalt возвращает соль периода, в который попадает at; period - длительность периода
+func NewContactSalt(secret []byte, period time.Duration, at time.Time) *ContactSalt {
+	epoch := at.UnixNano() / int64(period)
+	return contactSalt(secret, period, epoch)
+}
+
+// PreviousSalt возвращает соль предыдущего периода: клиент мог получить соль до ее смены
+func (cs *ContactSalt) PreviousSalt() *ContactSalt {
+	return contactSalt(cs.secret, cs.period, cs.Epoch-1)
+}
+
+func contactSalt(secret []byte, period time.Duration, epoch int64) *ContactSalt {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("hydra contact salt " + strconv.FormatInt(epoch, 10)))
+	key := mac.Sum(nil)
+	return &ContactSalt{
+		Epoch:     epoch,
+		Salt:      base64.StdEncoding.EncodeToString(key),
+		ExpiresAt: time.Unix(0, (epoch+1)*int64(period)).UTC(),
+		key:       key,
+		secret:    secret,
+		period:    period,
+	}
+}
+
+// Hash возвращает хеш контакта: base64url(HMAC-SHA256(соль, контакт)). Телефон - в
+// формате E.164 (+15551234567), email - целиком в нижнем регистре.
+func (cs *ContactSalt) Hash(contact string) string {
+	return HashContact(cs.key, contact)
+}
+
+// HashContact хеширует контакт солью salt так же, как это делает клиент
+func HashContact(salt []byte, contact string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(contact))))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
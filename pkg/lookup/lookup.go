@@ -0,0 +1,81 @@
+package lookup
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// usernamePattern - допустимые имена пользователей. Имя начинается с буквы, поэтому
+// поиск не может использоваться для перебора телефонов, а '@' исключает email.
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,31}$`)
+
+// NormalizeUsername приводит имя к каноническому виду и проверяет его
+func NormalizeUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	if !usernamePattern.MatchString(username) {
+		return "", fmt.Errorf("username must be 3-32 characters: latin letters, digits and '_', starting with a letter")
+	}
+	return username, nil
+}
+
+// Signer - ключ сервера, которым подписываются ответы (timesync.Signer)
+type Signer interface {
+	SignData(data []byte) string
+	PublicKey() ed25519.PublicKey
+}
+
+// Result - подписанный ответ поиска. Подпись связывает имя пользователя с его ID,
+// так что промежуточный узел не может подменить собеседника.
+type Result struct {
+	Username  string `json:"username"`
+	UserID    string `json:"user_id"`
+	Name      string `json:"name"`
+	Nonce     string `json:"nonce,omitempty"`
+	IssuedAt  int64  `json:"issued_at"` // Unix время в миллисекундах
+	Signature string `json:"signature"` // base64(ed25519(payload))
+	PublicKey string `json:"public_key"`
+}
+
+// Sign подписывает результат поиска. Nonce клиента включается в подпись,
+// чтобы старый ответ нельзя было подставить повторно.
+func Sign(signer Signer, username, userID, name, nonce string) *Result {
+	res := &Result{
+		Username:  username,
+		UserID:    userID,
+		Name:      name,
+		Nonce:     nonce,
+		IssuedAt:  time.Now().UnixMilli(),
+		PublicKey: base64.StdEncoding.EncodeToString(signer.PublicKey()),
+	}
+	res.Signature = signer.SignData(res.payload())
+	return res
+}
+
+// Verify проверяет подпись ответа известным публичным ключом сервера
+func Verify(publicKey ed25519.PublicKey, res *Result) error {
+	sig, err := base64.StdEncoding.DecodeString(res.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, res.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// payload - каноническое представление подписываемых данных
+func (res *Result) payload() []byte {
+	data, _ := json.Marshal(struct {
+		Username string `json:"username"`
+		UserID   string `json:"user_id"`
+		Name     string `json:"name"`
+		Nonce    string `json:"nonce"`
+		IssuedAt int64  `json:"issued_at"`
+	}{res.Username, res.UserID, res.Name, res.Nonce, res.IssuedAt})
+	return data
+}
@@ -0,0 +1,82 @@
+package lookup
+
+import (
+	"encoding/base64"
+	"hydra/pkg/timesync"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := timesync.NewSigner(nil)
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	res := Sign(signer, "alice", "user-1", "Alice", "n1")
+	if err := Verify(signer.PublicKey(), res); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// Подмена собеседника должна обнаруживаться
+	res.UserID = "user-2"
+	if err := Verify(signer.PublicKey(), res); err == nil {
+		t.Error("Expected verification to fail for substituted user ID")
+	}
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	valid := map[string]string{"Alice": "alice", "@bob_1": "bob_1"}
+	for in, want := range valid {
+		got, err := NormalizeUsername(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeUsername(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	// Телефоны и email не являются именами пользователей
+	for _, in := range []string{"+79991234567", "79991234567", "alice@example.com", "ab", ""} {
+		if _, err := NormalizeUsername(in); err == nil {
+			t.Errorf("Expected %q to be rejected", in)
+		}
+	}
+}
+
+func TestContactSalt(t *testing.T) {
+	secret := []byte("secret")
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	salt := NewContactSalt(secret, 24*time.Hour, at)
+	if same := NewContactSalt(secret, 24*time.Hour, at.Add(time.Hour)); same.Salt != salt.Salt {
+		t.Error("salt changed within a period")
+	}
+	next := NewContactSalt(secret, 24*time.Hour, at.Add(24*time.Hour))
+	if next.Salt == salt.Salt || next.PreviousSalt().Salt != salt.Salt {
+		t.Error("salt must change every period")
+	}
+	if !salt.ExpiresAt.Equal(time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected expiry: %v", salt.ExpiresAt)
+	}
+
+	// Клиент хеширует контакт полученной солью
+	key, _ := base64.StdEncoding.DecodeString(salt.Salt)
+	if HashContact(key, "Alice@Example.com") != salt.Hash("alice@example.com") {
+		t.Error("client and server hashes differ")
+	}
+	if salt.Hash("+15551234567") == next.Hash("+15551234567") {
+		t.Error("hash does not depend on salt")
+	}
+}
+
+func TestMentions(t *testing.T) {
+	got := Mentions("@Alice, ask @bob_1 and @alice again; mail carol@example.com, not @ab or @" + strings.Repeat("x", 33))
+	if strings.Join(got, ",") != "alice,bob_1" {
+		t.Errorf("Mentions = %v", got)
+	}
+	if got := Mentions("(@dave) @erin."); strings.Join(got, ",") != "dave,erin" {
+		t.Errorf("Mentions = %v", got)
+	}
+	if got := Mentions("no mentions"); len(got) != 0 {
+		t.Errorf("Mentions = %v", got)
+	}
+}
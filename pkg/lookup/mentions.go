@@ -0,0 +1,32 @@
+package lookup
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mentionPattern - упоминание @имя. Перед '@' не должно быть буквы, цифры, '_' или точки,
+// иначе это часть email (alice@example.com).
+var mentionPattern = regexp.MustCompile(`(?:^|[^\pL\pN_.@])@([A-Za-z][A-Za-z0-9_]{2,31})\b`)
+
+// MaxMentions - сколько упоминаний одного сообщения разрешается
+const MaxMentions = 50
+
+// Mentions возвращает имена пользователей, упомянутые в тексте как @имя, в каноническом
+// виде, без повторов, в порядке появления (не больше MaxMentions)
+func Mentions(text string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(m[1])
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+		if len(usernames) == MaxMentions {
+			break
+		}
+	}
+	return usernames
+}
@@ -0,0 +1,155 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Почтовые службы сообщают о недоставленных письмах и жалобах на спам уведомлениями
+// (webhook) своего формата; ParseBounces сводит их к списку Bounce.
+
+// Службы, уведомления которых разбирает ParseBounces
+const (
+	ProviderSendGrid = "sendgrid"
+	ProviderMailgun  = "mailgun"
+	ProviderSES      = "ses" // через подписку Amazon SNS
+)
+
+// ErrUnknownProvider - уведомления службы не поддерживаются
+var ErrUnknownProvider = errors.New("unknown email provider")
+
+// Bounce - письмо не доставлено или получатель пожаловался на спам
+type Bounce struct {
+	Recipient string
+	Permanent bool // адрес не существует, отклоняет письма или получатель пожаловался: писать на него не стоит
+	Reason    string
+}
+
+// ParseBounces разбирает уведомление службы provider. Для SES вместо уведомления может
+// прийти подтверждение подписки SNS: тогда возвращается адрес, который нужно открыть.
+func ParseBounces(provider string, body []byte) (bounces []Bounce, subscribeURL string, err error) {
+	switch provider {
+	case ProviderSendGrid:
+		bounces, err = parseSendGrid(body)
+	case ProviderMailgun:
+		bounces, err = parseMailgun(body)
+	case ProviderSES:
+		return parseSES(body)
+	default:
+		err = ErrUnknownProvider
+	}
+	return bounces, "", err
+}
+
+// parseSendGrid разбирает пакет событий SendGrid Event Webhook
+func parseSendGrid(body []byte) ([]Bounce, error) {
+	var events []struct {
+		Email  string `json:"email"`
+		Event  string `json:"event"`
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("failed to parse SendGrid events: %w", err)
+	}
+	var bounces []Bounce
+	for _, e := range events {
+		switch e.Event {
+		case "bounce":
+			// type "blocked" - письмо временно отклонено сервером получателя
+			bounces = append(bounces, Bounce{Recipient: e.Email, Permanent: e.Type != "blocked", Reason: e.Reason})
+		case "dropped":
+			bounces = append(bounces, Bounce{Recipient: e.Email, Permanent: true, Reason: e.Reason})
+		case "spamreport":
+			bounces = append(bounces, Bounce{Recipient: e.Email, Permanent: true, Reason: "spam complaint"})
+		}
+	}
+	return bounces, nil
+}
+
+// parseMailgun разбирает уведомление Mailgun webhooks (событие failed или complained)
+func parseMailgun(body []byte) ([]Bounce, error) {
+	var payload struct {
+		EventData struct {
+			Event          string `json:"event"`
+			Severity       string `json:"severity"`
+			Recipient      string `json:"recipient"`
+			Reason         string `json:"reason"`
+			DeliveryStatus struct {
+				Description string `json:"description"`
+				Message     string `json:"message"`
+			} `json:"delivery-status"`
+		} `json:"event-data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse Mailgun event: %w", err)
+	}
+	e := payload.EventData
+	switch e.Event {
+	case "failed":
+		reason := e.DeliveryStatus.Description
+		if reason == "" {
+			reason = e.DeliveryStatus.Message
+		}
+		if reason == "" {
+			reason = e.Reason
+		}
+		return []Bounce{{Recipient: e.Recipient, Permanent: e.Severity == "permanent", Reason: reason}}, nil
+	case "complained":
+		return []Bounce{{Recipient: e.Recipient, Permanent: true, Reason: "spam complaint"}}, nil
+	}
+	return nil, nil
+}
+
+// parseSES разбирает сообщение SNS с уведомлением SES о недоставке (Bounce) или жалобе (Complaint)
+func parseSES(body []byte) ([]Bounce, string, error) {
+	var envelope struct {
+		Type         string `json:"Type"`
+		Message      string `json:"Message"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, "", fmt.Errorf("failed to parse SNS message: %w", err)
+	}
+	if envelope.Type == "SubscriptionConfirmation" {
+		return nil, envelope.SubscribeURL, nil
+	}
+
+	var n struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"` // уведомления через configuration set
+		Bounce           struct {
+			BounceType        string `json:"bounceType"`
+			BouncedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplainedRecipients []struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"complainedRecipients"`
+		} `json:"complaint"`
+	}
+	if err := json.Unmarshal([]byte(envelope.Message), &n); err != nil {
+		return nil, "", fmt.Errorf("failed to parse SES notification: %w", err)
+	}
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+
+	var bounces []Bounce
+	switch kind {
+	case "Bounce":
+		for _, r := range n.Bounce.BouncedRecipients {
+			bounces = append(bounces, Bounce{Recipient: r.EmailAddress, Permanent: n.Bounce.BounceType == "Permanent", Reason: r.DiagnosticCode})
+		}
+	case "Complaint":
+		for _, r := range n.Complaint.ComplainedRecipients {
+			bounces = append(bounces, Bounce{Recipient: r.EmailAddress, Permanent: true, Reason: "spam complaint"})
+		}
+	}
+	return bounces, "", nil
+}
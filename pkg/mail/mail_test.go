@@ -0,0 +1,258 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parts разбирает письмо и возвращает тела листовых частей по типу содержимого
+func parts(t *testing.T, raw []byte) (*mail.Message, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	found := make(map[string]string)
+	var walk func(contentType string, body io.Reader)
+	walk = func(contentType string, body io.Reader) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatalf("ParseMediaType(%q): %v", contentType, err)
+		}
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			data, _ := io.ReadAll(body)
+			found[mediaType] = string(data)
+			return
+		}
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatalf("NextPart: %v", err)
+			}
+			if id := p.Header.Get("Content-ID"); id != "" {
+				found["cid"] = id
+			}
+			walk(p.Header.Get("Content-Type"), p)
+		}
+	}
+	walk(msg.Header.Get("Content-Type"), msg.Body)
+	return msg, found
+}
+
+func TestPlainMessage(t *testing.T) {
+	m := &Message{From: "Гидра <noreply@example.com>", To: "user@example.com", Subject: "Код подтверждения", Text: "Ваш код: 123456"}
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, found := parts(t, raw)
+
+	dec := new(mime.WordDecoder)
+	if subject, _ := dec.DecodeHeader(msg.Header.Get("Subject")); subject != m.Subject {
+		t.Errorf("Subject = %q", subject)
+	}
+	if from, err := msg.Header.AddressList("From"); err != nil || from[0].Name != "Гидра" || from[0].Address != "noreply@example.com" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q", msg.Header.Get("Message-ID"))
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(strings.NewReader(found["text/plain"])))
+	if string(body) != m.Text {
+		t.Errorf("body = %q", body)
+	}
+	if Address(m.From) != "noreply@example.com" {
+		t.Errorf("Address() = %q", Address(m.From))
+	}
+}
+
+func TestComposeTemplates(t *testing.T) {
+	logo := &Attachment{ContentID: LogoCID, ContentType: "image/png", Filename: "logo.png", Data: bytes.Repeat([]byte{0x89}, 100)}
+
+	msg, err := Compose(Verification, "noreply@example.com", "user@example.com", &Content{
+		Lang:       "ru",
+		Subject:    "Код подтверждения",
+		Text:       "Ваш код: 123456",
+		Paragraphs: []string{"Введите <код>:"},
+		Code:       "123456",
+		Logo:       logo,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := msg.Bytes()
+	_, found := parts(t, raw)
+	if found["text/plain"] != "Ваш код: 123456" {
+		t.Errorf("text part = %q", found["text/plain"])
+	}
+	html := found["text/html"]
+	for _, want := range []string{`lang="ru"`, "123456", "Введите &lt;код&gt;:", `src="cid:` + LogoCID + `"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html part lacks %q", want)
+		}
+	}
+	if found["cid"] != "<"+LogoCID+">" {
+		t.Errorf("inline logo Content-ID = %q", found["cid"])
+	}
+
+	msg, err = Compose(Invite, "noreply@example.com", "user@example.com", &Content{
+		Subject:     "Invite",
+		Text:        "Join",
+		ActionURL:   "https://hydra.example.com/register.html?token=abc",
+		ActionLabel: "Join Hydra",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Inline) != 0 || !strings.Contains(msg.HTML, `href="https://hydra.example.com/register.html?token=abc"`) || strings.Contains(msg.HTML, "cid:") {
+		t.Errorf("invite html = %s", msg.HTML)
+	}
+
+	if _, err := Compose(SecurityAlert, "a@example.com", "b@example.com", &Content{Subject: "Alert"}); err != nil {
+		t.Errorf("Compose(SecurityAlert) = %v", err)
+	}
+	if _, err := Compose("unknown", "a@example.com", "b@example.com", &Content{}); err == nil {
+		t.Error("unknown template rendered")
+	}
+}
+
+func TestParagraphs(t *testing.T) {
+	got := Paragraphs("first\r\nline\r\n\r\n\nsecond\n\n")
+	if len(got) != 2 || got[0] != "first\nline" || got[1] != "second" {
+		t.Errorf("Paragraphs() = %q", got)
+	}
+}
+
+func TestAPIMailers(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	status := http.StatusAccepted
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer api.Close()
+
+	msg := &Message{From: "Hydra <noreply@example.com>", To: "user@example.com", Subject: "Hi", Text: "text", HTML: "<p>html</p>"}
+
+	sg := &SendGrid{APIKey: "sg-key", Endpoint: api.URL}
+	if err := sg.Send(context.Background(), msg); err != nil {
+		t.Fatalf("SendGrid: %v", err)
+	}
+	var payload struct {
+		From    sendGridAddress   `json:"from"`
+		Content []sendGridContent `json:"content"`
+	}
+	json.Unmarshal(body, &payload)
+	if got.URL.Path != "/v3/mail/send" || got.Header.Get("Authorization") != "Bearer sg-key" ||
+		payload.From.Email != "noreply@example.com" || payload.From.Name != "Hydra" || len(payload.Content) != 2 {
+		t.Errorf("SendGrid request: %s %v %s", got.URL.Path, got.Header, body)
+	}
+
+	mg := &Mailgun{Domain: "mg.example.com", APIKey: "mg-key", Endpoint: api.URL}
+	if err := mg.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Mailgun: %v", err)
+	}
+	if user, pass, _ := got.BasicAuth(); got.URL.Path != "/v3/mg.example.com/messages.mime" || user != "api" || pass != "mg-key" ||
+		!bytes.Contains(body, []byte("Subject: Hi")) {
+		t.Errorf("Mailgun request: %s %s", got.URL.Path, body)
+	}
+
+	ses := &SES{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: api.URL}
+	if err := ses.Send(context.Background(), msg); err != nil {
+		t.Fatalf("SES: %v", err)
+	}
+	if got.URL.Path != "/v2/email/outbound-emails" ||
+		!strings.HasPrefix(got.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(got.Header.Get("Authorization"), "/eu-west-1/ses/aws4_request") {
+		t.Errorf("SES request: %s %v", got.URL.Path, got.Header)
+	}
+
+	// 4xx - отказ службы, 5xx - временная ошибка
+	status = http.StatusBadRequest
+	if err := sg.Send(context.Background(), msg); !errors.Is(err, ErrRejected) {
+		t.Errorf("400 not a rejection: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := sg.Send(context.Background(), msg); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("503 treated as %v", err)
+	}
+}
+
+// mailerFunc - Mailer из функции
+type mailerFunc func(ctx context.Context, m *Message) error
+
+func (f mailerFunc) Send(ctx context.Context, m *Message) error { return f(ctx, m) }
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	flaky := mailerFunc(func(ctx context.Context, m *Message) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	})
+	if err := (&Retry{Mailer: flaky, Attempts: 3, Backoff: time.Millisecond}).Send(context.Background(), &Message{}); err != nil || calls != 3 {
+		t.Errorf("Retry = %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	rejecting := mailerFunc(func(ctx context.Context, m *Message) error {
+		calls++
+		return ErrRejected
+	})
+	if err := (&Retry{Mailer: rejecting, Attempts: 3, Backoff: time.Millisecond}).Send(context.Background(), &Message{}); !errors.Is(err, ErrRejected) || calls != 1 {
+		t.Errorf("rejection retried: %v after %d calls", err, calls)
+	}
+}
+
+func TestParseBounces(t *testing.T) {
+	bounces, _, err := ParseBounces(ProviderSendGrid, []byte(`[
+		{"email":"a@example.com","event":"bounce","type":"bounce","reason":"550 no such user"},
+		{"email":"b@example.com","event":"bounce","type":"blocked"},
+		{"email":"c@example.com","event":"delivered"},
+		{"email":"d@example.com","event":"spamreport"}]`))
+	if err != nil || len(bounces) != 3 || !bounces[0].Permanent || bounces[1].Permanent || bounces[2].Recipient != "d@example.com" {
+		t.Errorf("SendGrid bounces = %+v, %v", bounces, err)
+	}
+
+	bounces, _, err = ParseBounces(ProviderMailgun, []byte(`{"event-data":{"event":"failed","severity":"temporary","recipient":"a@example.com","delivery-status":{"message":"mailbox full"}}}`))
+	if err != nil || len(bounces) != 1 || bounces[0].Permanent || bounces[0].Reason != "mailbox full" {
+		t.Errorf("Mailgun bounces = %+v, %v", bounces, err)
+	}
+
+	notification, _ := json.Marshal(map[string]string{
+		"Type":    "Notification",
+		"Message": `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"a@example.com","diagnosticCode":"smtp; 550"}]}}`,
+	})
+	bounces, _, err = ParseBounces(ProviderSES, notification)
+	if err != nil || len(bounces) != 1 || !bounces[0].Permanent || bounces[0].Reason != "smtp; 550" {
+		t.Errorf("SES bounces = %+v, %v", bounces, err)
+	}
+	_, subscribeURL, err := ParseBounces(ProviderSES, []byte(`{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription"}`))
+	if err != nil || !strings.HasPrefix(subscribeURL, "https://sns.") {
+		t.Errorf("SNS confirmation = %q, %v", subscribeURL, err)
+	}
+
+	if _, _, err := ParseBounces("postmark", nil); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("unknown provider: %v", err)
+	}
+}
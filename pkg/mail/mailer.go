@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrRejected - служба отказалась принимать письмо (неверный адрес, отправитель не
+// подтвержден, неверный ключ API); повтор не поможет
+var ErrRejected = errors.New("email rejected")
+
+// Mailer отправляет письма: через SMTP или HTTP API почтовой службы
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Retry повторяет неудачную отправку до Attempts раз с удваивающейся задержкой от
+// Backoff. Отказ службы (ErrRejected) не повторяется.
+type Retry struct {
+	Mailer   Mailer
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Send отправляет письмо, повторяя временные ошибки
+func (r *Retry) Send(ctx context.Context, m *Message) error {
+	delay := r.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.Mailer.Send(ctx, m)
+		if err == nil || errors.Is(err, ErrRejected) || attempt >= r.Attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// apiError возвращает ошибку по неуспешному ответу HTTP API: 4xx кроме 408 и 429 -
+// отказ службы, остальное - временная ошибка
+func apiError(service string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s API returned status %d: %s", service, resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+	return c
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mailgun отправляет письма через Mailgun Messages API. Письмо передается собранным
+// целиком (messages.mime), поэтому доходит в том же виде, что и через SMTP.
+type Mailgun struct {
+	Domain   string
+	APIKey   string
+	Endpoint string // https://api.mailgun.net; для региона ЕС - https://api.eu.mailgun.net
+	Client   *http.Client
+}
+
+// Send отправляет письмо
+func (g *Mailgun) Send(ctx context.Context, m *Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("to", Address(m.To)); err != nil {
+		return fmt.Errorf("failed to encode Mailgun request: %w", err)
+	}
+	part, err := form.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return fmt.Errorf("failed to encode Mailgun request: %w", err)
+	}
+	if _, err := part.Write(msg); err != nil {
+		return fmt.Errorf("failed to encode Mailgun request: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("failed to encode Mailgun request: %w", err)
+	}
+
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.mailgun.net"
+	}
+	target := strings.TrimRight(endpoint, "/") + "/v3/" + url.PathEscape(g.Domain) + "/messages.mime"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+	req.SetBasicAuth("api", g.APIKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := httpClient(g.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Mailgun request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return apiError("Mailgun", resp)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message - письмо: текстовая версия и, если задана, HTML-версия с встроенными
+// изображениями (логотип), на которые HTML ссылается как cid:ContentID
+type Message struct {
+	From    string // "Имя <адрес>" или адрес
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Inline  []Attachment
+	Date    time.Time // пусто - время сборки письма
+}
+
+// Attachment - файл, встроенный в письмо
+type Attachment struct {
+	ContentID   string
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+// Address возвращает адрес без имени ("Hydra <noreply@example.com>" -> noreply@example.com)
+// для команд SMTP; нераспознанная строка возвращается как есть
+func Address(addr string) string {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return parsed.Address
+}
+
+// Bytes собирает письмо в формате RFC 5322 с MIME: тема и имена в заголовках кодируются
+// по RFC 2047, текст - quoted-printable в UTF-8. Письмо с HTML - multipart/alternative,
+// со встроенными изображениями HTML-версия вложена в multipart/related.
+func (m *Message) Bytes() ([]byte, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", formatAddress(m.From))
+	writeHeader(&buf, "To", formatAddress(m.To))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID(m.From))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	alt := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", "multipart/alternative; boundary="+alt.Boundary())
+	buf.WriteString("\r\n")
+	if err := writeTextPart(alt, "text/plain", m.Text); err != nil {
+		return nil, err
+	}
+
+	if len(m.Inline) == 0 {
+		if err := writeTextPart(alt, "text/html", m.HTML); err != nil {
+			return nil, err
+		}
+	} else {
+		var related bytes.Buffer
+		rel := multipart.NewWriter(&related)
+		if err := writeTextPart(rel, "text/html", m.HTML); err != nil {
+			return nil, err
+		}
+		for _, a := range m.Inline {
+			if err := writeInline(rel, a); err != nil {
+				return nil, err
+			}
+		}
+		if err := rel.Close(); err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		part, err := alt.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/related; boundary=" + rel.Boundary()}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		if _, err := part.Write(related.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+	}
+
+	if err := alt.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// formatAddress кодирует имя в адресе по RFC 2047; нераспознанный адрес остается как есть
+func formatAddress(addr string) string {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return parsed.String()
+}
+
+// messageID возвращает уникальный Message-ID в домене отправителя
+func messageID(from string) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	domain := "hydra.local"
+	if at := strings.LastIndexByte(Address(from), '@'); at != -1 {
+		domain = Address(from)[at+1:]
+	}
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
+
+func writeTextPart(w *multipart.Writer, contentType, text string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := writeQuotedPrintable(&buf, text); err != nil {
+		return err
+	}
+	_, err = part.Write(buf.Bytes())
+	return err
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, text string) error {
+	qp := quotedprintable.NewWriter(buf)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+	return nil
+}
+
+// writeInline добавляет изображение в base64 строками по 76 символов
+func writeInline(w *multipart.Writer, a Attachment) error {
+	header := textproto.MIMEHeader{
+		"Content-Type":              {a.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-ID":                {"<" + a.ContentID + ">"},
+		"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": a.Filename})},
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 76 {
+		if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+}
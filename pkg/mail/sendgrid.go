@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// SendGrid отправляет письма через SendGrid v3 Mail Send API
+type SendGrid struct {
+	APIKey   string
+	Endpoint string // https://api.sendgrid.com
+	Client   *http.Client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id"`
+}
+
+// Send отправляет письмо
+func (s *SendGrid) Send(ctx context.Context, m *Message) error {
+	payload := map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": []sendGridAddress{sendGridAddr(m.To)}}},
+		"from":             sendGridAddr(m.From),
+		"subject":          m.Subject,
+	}
+	content := []sendGridContent{{Type: "text/plain", Value: m.Text}}
+	if m.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: m.HTML})
+	}
+	payload["content"] = content
+	if len(m.Inline) > 0 {
+		attachments := make([]sendGridAttachment, 0, len(m.Inline))
+		for _, a := range m.Inline {
+			attachments = append(attachments, sendGridAttachment{
+				Content:     base64.StdEncoding.EncodeToString(a.Data),
+				Type:        a.ContentType,
+				Filename:    a.Filename,
+				Disposition: "inline",
+				ContentID:   a.ContentID,
+			})
+		}
+		payload["attachments"] = attachments
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode SendGrid request: %w", err)
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.sendgrid.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SendGrid request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient(s.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SendGrid request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return apiError("SendGrid", resp)
+	}
+	return nil
+}
+
+func sendGridAddr(addr string) sendGridAddress {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return sendGridAddress{Email: addr}
+	}
+	return sendGridAddress{Email: parsed.Address, Name: parsed.Name}
+}
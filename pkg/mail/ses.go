@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SES отправляет письма через Amazon SES API v2 (SendEmail с собранным письмом).
+// Запросы подписываются AWS Signature Version 4 ключом доступа IAM.
+type SES struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Endpoint        string // пусто - https://email.{Region}.amazonaws.com
+	Client          *http.Client
+}
+
+// Send отправляет письмо
+func (s *SES) Send(ctx context.Context, m *Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": m.From,
+		"Destination":      map[string][]string{"ToAddresses": {m.To}},
+		"Content":          map[string]interface{}{"Raw": map[string][]byte{"Data": msg}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode SES request: %w", err)
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + s.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SES request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := httpClient(s.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SES request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return apiError("SES", resp)
+	}
+	return nil
+}
+
+// sign добавляет к запросу подпись AWS Signature Version 4 по заголовкам content-type,
+// host и x-amz-date
+func (s *SES) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	const signedHeaders = "content-type;host;x-amz-date"
+	payloadHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type") + "\n" +
+			"host:" + req.URL.Host + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/ses/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// SMTP отправляет письма через SMTP сервер: на порт 465 - по неявному TLS, на остальные
+// (587, 25) - с STARTTLS
+type SMTP struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+}
+
+// Send отправляет письмо
+func (s *SMTP) Send(ctx context.Context, m *Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	// Для команды MAIL FROM нужен чистый email: From бывает в формате "Name <email>"
+	from := Address(m.From)
+	auth := smtp.PlainAuth("", s.User, s.Password, s.Host)
+
+	if s.Port != "465" {
+		if err := smtp.SendMail(s.addr(), auth, from, []string{m.To}, msg); err != nil {
+			return smtpError("smtp.SendMail failed", err)
+		}
+		return nil
+	}
+
+	client, err := s.dialTLS(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Quit()
+
+	if err := client.Auth(auth); err != nil {
+		return smtpError("failed to authenticate", err)
+	}
+	if err := client.Mail(from); err != nil {
+		return smtpError("failed to set sender (MAIL FROM)", err)
+	}
+	if err := client.Rcpt(m.To); err != nil {
+		return smtpError("failed to set recipient (RCPT TO)", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("failed to create data writer", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("failed to close writer", err)
+	}
+	return nil
+}
+
+// Check проверяет соединение с сервером и вход
+func (s *SMTP) Check(ctx context.Context) error {
+	auth := smtp.PlainAuth("", s.User, s.Password, s.Host)
+	if s.Port == "465" {
+		client, err := s.dialTLS(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Quit()
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		return nil
+	}
+
+	client, err := smtp.Dial(s.addr())
+	if err != nil {
+		return err
+	}
+	defer client.Quit()
+
+	if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+		return fmt.Errorf("StartTLS failed: %w", err)
+	}
+	if err := client.Auth(auth); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	return nil
+}
+
+func (s *SMTP) addr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+func (s *SMTP) dialTLS(ctx context.Context) (*smtp.Client, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config:    &tls.Config{ServerName: s.Host},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial TLS: %w", err)
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+	return client, nil
+}
+
+// smtpError отмечает постоянные отказы сервера (коды 5xx) как ErrRejected
+func smtpError(action string, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return fmt.Errorf("%s: %w: %v", action, ErrRejected, err)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
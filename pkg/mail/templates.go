@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Письма собираются по шаблонам своего вида (templates/*.html). Тексты приходят уже
+// переведенными на язык получателя (pkg/i18n): шаблон задает только оформление
+// HTML-версии, текстовая версия передается целиком.
+
+// Виды писем
+const (
+	Verification  = "verification"   // код подтверждения
+	Invite        = "invite"         // приглашение со ссылкой на регистрацию
+	SecurityAlert = "security_alert" // предупреждение о безопасности аккаунта
+)
+
+// LogoCID - Content-ID логотипа в шапке писем
+const LogoCID = "logo@hydra"
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var htmlTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	// Ссылки cid: html/template иначе считает небезопасными
+	"cid": func(id string) template.URL { return template.URL("cid:" + id) },
+}).ParseFS(templateFS, "templates/*.html"))
+
+// Content - содержимое письма
+type Content struct {
+	Lang        string // язык письма
+	Subject     string
+	Text        string      // текстовая версия письма
+	Paragraphs  []string    // абзацы HTML-версии
+	Code        string      // код подтверждения
+	ActionURL   string      // адрес кнопки
+	ActionLabel string      // текст кнопки
+	Note        string      // примечание мелким шрифтом
+	Logo        *Attachment // логотип в шапке; nil - название текстом
+}
+
+// Compose собирает письмо вида kind от from к to
+func Compose(kind, from, to string, c *Content) (*Message, error) {
+	var html bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, kind+".html", c); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", kind, err)
+	}
+	msg := &Message{From: from, To: to, Subject: c.Subject, Text: c.Text, HTML: html.String()}
+	if c.Logo != nil {
+		msg.Inline = append(msg.Inline, *c.Logo)
+	}
+	return msg, nil
+}
+
+// Paragraphs делит текст на абзацы по пустым строкам
+func Paragraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// LoadLogo читает изображение логотипа для шапки писем
+func LoadLogo(path string) (*Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email logo: %w", err)
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("email logo is not an image: %s", contentType)
+	}
+	return &Attachment{ContentID: LogoCID, ContentType: contentType, Filename: filepath.Base(path), Data: data}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package nat
+
+import (
+	"fmt"
+	"net"
+)
+
+// defaultGateway: определение шлюза реализовано только для Linux; на других системах
+// NAT-PMP не используется, остаются UPnP и STUN
+func defaultGateway() (net.IP, error) {
+	return nil, fmt.Errorf("default gateway lookup is not supported on this platform")
+}
@@ -0,0 +1,82 @@
+// Package nat обеспечивает доступность mesh узла за NAT: проброс порта через UPnP IGD
+// или NAT-PMP и определение внешнего адреса через STUN (для пробивания NAT).
+package nat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// Способы получения внешнего адреса
+const (
+	MethodUPnP   = "upnp"    // порт проброшен на роутере (узел доступен напрямую)
+	MethodNATPMP = "nat-pmp" // порт проброшен на роутере (узел доступен напрямую)
+	MethodSTUN   = "stun"    // известен только внешний адрес отображения - нужно пробивание NAT
+)
+
+// DefaultLease - срок аренды проброса порта; проброс продлевается заранее
+const DefaultLease = time.Hour
+
+// Mapping - внешний адрес, по которому узел виден из интернета
+type Mapping struct {
+	Method       string    `json:"method"`
+	InternalPort int       `json:"internal_port"`
+	ExternalAddr string    `json:"external_addr"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+
+	release func() error
+}
+
+// Reachable сообщает, принимает ли узел входящие соединения по ExternalAddr без пробивания NAT
+func (m *Mapping) Reachable() bool {
+	return m.Method == MethodUPnP || m.Method == MethodNATPMP
+}
+
+// Release удаляет проброс порта на роутере (для STUN ничего не делает)
+func (m *Mapping) Release() error {
+	if m.release == nil {
+		return nil
+	}
+	return m.release()
+}
+
+// Discover пытается пробросить TCP порт через UPnP, затем NAT-PMP. Если роутер не
+// поддерживает проброс, внешний адрес определяется через STUN серверы (host:port по TCP).
+// STUN запрос отправляется с локального порта port, поэтому сокет слушателя должен
+// допускать повторное использование адреса (см. ListenConfig).
+func Discover(ctx context.Context, port int, stunServers []string) (*Mapping, error) {
+	var errs []error
+
+	if mapping, err := mapUPnP(ctx, port, DefaultLease); err == nil {
+		return mapping, nil
+	} else {
+		errs = append(errs, fmt.Errorf("upnp: %w", err))
+	}
+
+	if gateway, err := defaultGateway(); err == nil {
+		if mapping, err := MapNATPMP(ctx, net.JoinHostPort(gateway.String(), "5351"), port, DefaultLease); err == nil {
+			return mapping, nil
+		} else {
+			errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+	}
+
+	for _, server := range stunServers {
+		addr, err := PublicAddr(ctx, server, port)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stun %s: %w", server, err))
+			continue
+		}
+		return &Mapping{Method: MethodSTUN, InternalPort: port, ExternalAddr: addr.String()}, nil
+	}
+
+	for _, err := range errs {
+		log.Printf("NAT: %v", err)
+	}
+	return nil, fmt.Errorf("no NAT traversal method available")
+}
@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// NAT-PMP (RFC 6886): запросы к шлюзу по UDP на порт 5351
+const (
+	natpmpOpExternalAddr = 0
+	natpmpOpMapTCP       = 2
+	natpmpResponseBit    = 128
+)
+
+// MapNATPMP пробрасывает TCP порт через NAT-PMP шлюз gateway (host:port)
+func MapNATPMP(ctx context.Context, gateway string, port int, lease time.Duration) (*Mapping, error) {
+	// Внешний IP
+	resp, err := natpmpCall(ctx, gateway, []byte{0, natpmpOpExternalAddr}, 12)
+	if err != nil {
+		return nil, err
+	}
+	externalIP := net.IP(resp[8:12])
+
+	// Проброс порта: внутренний порт, желаемый внешний порт, срок аренды
+	req := make([]byte, 12)
+	req[1] = natpmpOpMapTCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(port))
+	binary.BigEndian.PutUint16(req[6:8], uint16(port))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lease/time.Second))
+
+	resp, err = natpmpCall(ctx, gateway, req, 16)
+	if err != nil {
+		return nil, err
+	}
+	externalPort := binary.BigEndian.Uint16(resp[10:12])
+	granted := time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+
+	mapping := &Mapping{
+		Method:       MethodNATPMP,
+		InternalPort: port,
+		ExternalAddr: net.JoinHostPort(externalIP.String(), strconv.Itoa(int(externalPort))),
+		ExpiresAt:    time.Now().Add(granted),
+	}
+	mapping.release = func() error {
+		// Аренда с нулевым сроком удаляет проброс
+		binary.BigEndian.PutUint32(req[8:12], 0)
+		binary.BigEndian.PutUint16(req[6:8], 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_, err := natpmpCall(ctx, gateway, req, 16)
+		return err
+	}
+	return mapping, nil
+}
+
+// natpmpCall отправляет запрос с повторами (250 мс, удваивая) и проверяет код результата
+func natpmpCall(ctx context.Context, gateway string, req []byte, respSize int) ([]byte, error) {
+	conn, err := net.Dial("udp", gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			timeout *= 2
+			continue
+		}
+		if n < respSize || buf[1] != req[1]|natpmpResponseBit {
+			return nil, fmt.Errorf("invalid NAT-PMP response")
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP error code %d", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("no NAT-PMP response from %s", gateway)
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package nat
+
+import "syscall"
+
+// reuseControl: на этих платформах повторное использование порта не поддерживается,
+// пробивание NAT работает только при пробросе порта (UPnP/NAT-PMP)
+func reuseControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package nat
+
+import (
+	"syscall"
+)
+
+// reuseControl разрешает нескольким сокетам использовать один локальный порт: слушателю,
+// STUN запросам и исходящим соединениям пробивания NAT
+func reuseControl(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		if opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); opErr != nil {
+			return
+		}
+		opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
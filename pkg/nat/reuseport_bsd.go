@@ -0,0 +1,6 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package nat
+
+// SO_REUSEPORT на BSD системах (нет в пакете syscall)
+const soReusePort = 0x200
@@ -0,0 +1,4 @@
+package nat
+
+// SO_REUSEPORT (нет в пакете syscall)
+const soReusePort = 0xf
@@ -0,0 +1,21 @@
+package nat
+
+import (
+	"net"
+	"time"
+)
+
+// ListenConfig - конфигурация слушателя, совместимого с пробиванием NAT
+func ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: reuseControl}
+}
+
+// Dialer возвращает dialer, открывающий соединения с локального порта localPort
+// (того же, что слушает узел). Так NAT использует одно отображение для всех соединений.
+func Dialer(localPort int) *net.Dialer {
+	return &net.Dialer{
+		LocalAddr: &net.TCPAddr{Port: localPort},
+		Control:   reuseControl,
+		Timeout:   3 * time.Second,
+	}
+}
@@ -0,0 +1,54 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+// PublicAddr определяет внешний адрес TCP отображения локального порта localPort
+// через STUN сервер (host:port). Соединение открывается с того же порта, что слушает узел,
+// поэтому полученный адрес можно сообщать пирам для пробивания NAT.
+func PublicAddr(ctx context.Context, server string, localPort int) (*net.TCPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	dialer := Dialer(localPort)
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, fmt.Errorf("dial to %s failed: %w", server, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.Write(request.Raw); err != nil {
+		return nil, fmt.Errorf("failed to send binding request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("no binding response from %s: %w", server, err)
+	}
+
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		return nil, fmt.Errorf("invalid STUN response from %s: %w", server, err)
+	}
+	if response.TransactionID != request.TransactionID {
+		return nil, fmt.Errorf("unexpected STUN transaction from %s", server)
+	}
+
+	var mapped stun.XORMappedAddress
+	if err := mapped.GetFrom(response); err != nil {
+		return nil, fmt.Errorf("no mapped address in STUN response: %w", err)
+	}
+	return &net.TCPAddr{IP: mapped.IP, Port: mapped.Port}, nil
+}
@@ -0,0 +1,208 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// Сервисы IGD, через которые делается проброс порта
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// mapUPnP находит роутер через SSDP и пробрасывает TCP порт через AddPortMapping
+func mapUPnP(ctx context.Context, port int, lease time.Duration) (*Mapping, error) {
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, service, err := upnpControlURL(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	localIP, err := localIPFor(location.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	var ext struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := soapCall(ctx, controlURL, service, "GetExternalIPAddress", "", &ext); err != nil {
+		return nil, err
+	}
+	if net.ParseIP(ext.IP) == nil {
+		return nil, fmt.Errorf("router returned no external IP")
+	}
+
+	args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort>"+
+		"<NewProtocol>TCP</NewProtocol><NewInternalPort>%d</NewInternalPort>"+
+		"<NewInternalClient>%s</NewInternalClient><NewEnabled>1</NewEnabled>"+
+		"<NewPortMappingDescription>hydra mesh</NewPortMappingDescription>"+
+		"<NewLeaseDuration>%d</NewLeaseDuration>", port, port, localIP, int(lease/time.Second))
+	if err := soapCall(ctx, controlURL, service, "AddPortMapping", args, nil); err != nil {
+		return nil, err
+	}
+
+	mapping := &Mapping{
+		Method:       MethodUPnP,
+		InternalPort: port,
+		ExternalAddr: net.JoinHostPort(ext.IP, strconv.Itoa(port)),
+		ExpiresAt:    time.Now().Add(lease),
+	}
+	mapping.release = func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort><NewProtocol>TCP</NewProtocol>", port)
+		return soapCall(ctx, controlURL, service, "DeletePortMapping", args, nil)
+	}
+	return mapping, nil
+}
+
+// ssdpSearch ищет Internet Gateway Device в локальной сети и возвращает адрес его описания
+func ssdpSearch(ctx context.Context) (*url.URL, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	req := "M-SEARCH * HTTP/1.1\r\nHOST: " + ssdpAddr + "\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(req), dst); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no UPnP gateway found")
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			name, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "location") {
+				return url.Parse(strings.TrimSpace(value))
+			}
+		}
+	}
+}
+
+// upnpControlURL загружает описание устройства и находит сервис WAN соединения
+func upnpControlURL(ctx context.Context, location *url.URL) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return "", "", fmt.Errorf("invalid device description: %w", err)
+	}
+
+	var find func(d upnpDevice) (string, string)
+	find = func(d upnpDevice) (string, string) {
+		for _, s := range d.Services {
+			for _, wanted := range upnpServices {
+				if s.ServiceType == wanted {
+					return s.ControlURL, s.ServiceType
+				}
+			}
+		}
+		for _, child := range d.Devices {
+			if u, t := find(child); u != "" {
+				return u, t
+			}
+		}
+		return "", ""
+	}
+
+	control, service := find(root.Device)
+	if control == "" {
+		return "", "", fmt.Errorf("gateway has no WAN connection service")
+	}
+	ref, err := url.Parse(control)
+	if err != nil {
+		return "", "", err
+	}
+	return location.ResolveReference(ref).String(), service, nil
+}
+
+func soapCall(ctx context.Context, controlURL, service, action, args string, result interface{}) error {
+	body := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<u:` + action + ` xmlns:u="` + service + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+"#"+action+`"`)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed: HTTP %d", action, resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result)
+}
+
+// localIPFor возвращает локальный адрес, с которого идет трафик к хосту роутера
+func localIPFor(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	conn, err := net.Dial("udp4", net.JoinHostPort(host, "1900"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
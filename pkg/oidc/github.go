@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHub - вход через OAuth App или GitHub App. ID-токена у GitHub нет: учетная запись
+// запрашивается из API с полученным токеном доступа, подтвержденный адрес - из
+// /user/emails (основной подтвержденный).
+type GitHub struct {
+	ClientID     string
+	ClientSecret string
+	Endpoint     string // https://github.com; для GitHub Enterprise - адрес сервера
+	APIEndpoint  string // https://api.github.com; для GitHub Enterprise - адрес/api/v3
+	Client       *http.Client
+}
+
+// AuthURL возвращает адрес страницы входа
+func (g *GitHub) AuthURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	query := url.Values{
+		"client_id":             {g.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"read:user user:email"},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"allow_signup":          {"false"},
+	}
+	return g.endpoint() + "/login/oauth/authorize?" + query.Encode(), nil
+}
+
+// Exchange обменивает код на токен доступа и запрашивает учетную запись; nonce у
+// GitHub не используется
+func (g *GitHub) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*Identity, error) {
+	client := httpClient(g.Client)
+	token, err := exchangeCode(ctx, client, g.endpoint()+"/login/oauth/access_token", url.Values{
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {g.ClientID},
+		"client_secret": {g.ClientSecret},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, g.apiEndpoint()+"/user", token.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, ErrNoSubject
+	}
+	identity := &Identity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, g.apiEndpoint()+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			identity.Email, identity.EmailVerified = e.Email, true
+		}
+	}
+	return identity, nil
+}
+
+func (g *GitHub) endpoint() string {
+	if g.Endpoint == "" {
+		return "https://github.com"
+	}
+	return strings.TrimRight(g.Endpoint, "/")
+}
+
+func (g *GitHub) apiEndpoint() string {
+	if g.APIEndpoint == "" {
+		return "https://api.github.com"
+	}
+	return strings.TrimRight(g.APIEndpoint, "/")
+}
@@ -0,0 +1,131 @@
+// Package oidc реализует вход через внешних поставщиков учетных записей по схеме
+// authorization code с PKCE: OpenID Connect (Google, Keycloak и другие поставщики с
+// discovery) и OAuth 2.0 GitHub, у которого нет ID-токена.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider - поставщик учетных записей
+type Provider interface {
+	// AuthURL возвращает адрес страницы входа поставщика. state возвращается в
+	// redirectURI вместе с кодом, nonce попадает в ID-токен, challenge - PKCE (S256).
+	AuthURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error)
+	// Exchange обменивает код из redirectURI на учетную запись пользователя
+	Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*Identity, error)
+}
+
+// Identity - учетная запись пользователя у поставщика
+type Identity struct {
+	Subject       string // неизменный идентификатор у поставщика
+	Email         string
+	EmailVerified bool // поставщик подтвердил владение адресом
+	Name          string
+}
+
+// NewVerifier создает секрет PKCE (code_verifier) для одной попытки входа
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// Challenge возвращает code_challenge секрета PKCE (метод S256)
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewState создает случайное значение state или nonce
+func NewState() (string, error) {
+	return randomString(24)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenResponse - ответ конечной точки токенов (RFC 6749, OpenID Connect Core)
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode обменивает код авторизации на токены
+func exchangeCode(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	// GitHub сообщает об ошибке обмена с кодом 200
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request rejected: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	return &token, nil
+}
+
+// getJSON запрашивает JSON по адресу target, при непустом accessToken - с ним
+func getJSON(ctx context.Context, client *http.Client, target, accessToken string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned status %d: %s", target, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", target, err)
+	}
+	return nil
+}
+
+// ErrNoSubject - поставщик не сообщил идентификатор пользователя
+var ErrNoSubject = errors.New("identity has no subject")
+
+func httpClient(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return c
+}
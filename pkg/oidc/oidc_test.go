@@ -0,0 +1,185 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIssuer - поставщик OpenID Connect, выдающий ID-токен с утверждениями claims
+type fakeIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	form   url.Values // последний запрос токена
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/auth",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.form = r.PostForm
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": f.sign(t, f.claims)})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOpenID(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	p := &OpenID{Issuer: issuer.URL, ClientID: "hydra", ClientSecret: "s3cret"}
+
+	verifier, _ := NewVerifier()
+	authURL, err := p.AuthURL(t.Context(), "https://hydra.example/cb", "st", "n1", Challenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if !strings.HasPrefix(authURL, issuer.URL+"/auth?") || u.Query().Get("code_challenge") != Challenge(verifier) ||
+		u.Query().Get("scope") != "openid email profile" || u.Query().Get("nonce") != "n1" {
+		t.Errorf("auth url: %s", authURL)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer.URL, "sub": "248289761001", "aud": "hydra", "nonce": "n1",
+			"exp": time.Now().Add(time.Hour).Unix(), "email": "alice@example.com", "email_verified": true, "name": "Alice",
+		}
+	}
+	issuer.claims = valid()
+	identity, err := p.Exchange(t.Context(), "https://hydra.example/cb", "good-code", verifier, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *identity != (Identity{Subject: "248289761001", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}) {
+		t.Errorf("identity: %+v", identity)
+	}
+	if issuer.form.Get("code_verifier") != verifier || issuer.form.Get("client_secret") != "s3cret" {
+		t.Errorf("token request: %v", issuer.form)
+	}
+
+	if _, err := p.Exchange(t.Context(), "https://hydra.example/cb", "bad-code", verifier, "n1"); err == nil {
+		t.Error("rejected code accepted")
+	}
+	for name, mutate := range map[string]func(map[string]interface{}){
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "other" },
+		"audience": func(c map[string]interface{}) { c["aud"] = []string{"someone-else"} },
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+		"expired":  func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"subject":  func(c map[string]interface{}) { delete(c, "sub") },
+	} {
+		issuer.claims = valid()
+		mutate(issuer.claims)
+		if _, err := p.Exchange(t.Context(), "https://hydra.example/cb", "good-code", verifier, "n1"); err == nil {
+			t.Errorf("%s: invalid id_token accepted", name)
+		}
+	}
+
+	// Подпись чужим ключом
+	other := newFakeIssuer(t)
+	other.claims = valid()
+	token := other.sign(t, other.claims)
+	cfg, _ := p.discover(t.Context())
+	if _, err := p.verifyIDToken(t.Context(), cfg, token, "n1"); err == nil {
+		t.Error("id_token signed by another key accepted")
+	}
+
+	// Строковое email_verified и aud массивом
+	issuer.claims = valid()
+	issuer.claims["email_verified"] = "false"
+	issuer.claims["aud"] = []string{"hydra", "other"}
+	if identity, err := p.Exchange(t.Context(), "https://hydra.example/cb", "good-code", verifier, "n1"); err != nil || identity.EmailVerified {
+		t.Errorf("unverified email: %+v, %v", identity, err)
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" {
+			// GitHub сообщает об ошибке с кодом 200
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token"})
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"id": 583231, "login": "octocat", "name": ""}`)
+	})
+	mux.HandleFunc("/api/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true}]`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := &GitHub{ClientID: "gh", ClientSecret: "s", Endpoint: srv.URL, APIEndpoint: srv.URL + "/api"}
+	authURL, _ := g.AuthURL(t.Context(), "https://hydra.example/cb", "st", "", "ch")
+	if !strings.HasPrefix(authURL, srv.URL+"/login/oauth/authorize?") || !strings.Contains(authURL, "state=st") {
+		t.Errorf("auth url: %s", authURL)
+	}
+	identity, err := g.Exchange(t.Context(), "https://hydra.example/cb", "good-code", "v", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *identity != (Identity{Subject: "583231", Email: "octocat@example.com", EmailVerified: true, Name: "octocat"}) {
+		t.Errorf("identity: %+v", identity)
+	}
+	if _, err := g.Exchange(t.Context(), "https://hydra.example/cb", "bad-code", "v", ""); err == nil {
+		t.Error("rejected code accepted")
+	}
+}
@@ -0,0 +1,333 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google - издатель Google Accounts
+const Google = "https://accounts.google.com"
+
+// clockSkew - допустимое расхождение часов с поставщиком при проверке срока ID-токена
+const clockSkew = time.Minute
+
+// keysRefresh - как часто можно перезапрашивать ключи поставщика при неизвестном kid
+const keysRefresh = time.Minute
+
+// OpenID - поставщик OpenID Connect. Адреса входа, токенов и ключей берутся из документа
+// discovery издателя (Issuer + /.well-known/openid-configuration) при первом входе.
+// Учетная запись берется из ID-токена, подписанного ключом поставщика (RS256 или ES256).
+type OpenID struct {
+	Issuer       string // например https://accounts.google.com или https://sso.example.com/realms/hydra
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // пусто - openid email profile
+	Client       *http.Client
+
+	mu        sync.Mutex
+	config    *discovery
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+	keysValid bool
+}
+
+// discovery - нужная часть документа discovery
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// AuthURL возвращает адрес страницы входа
+func (p *OpenID) AuthURL(ctx context.Context, redirectURI, state, nonce, challenge string) (string, error) {
+	cfg, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	return withQuery(cfg.AuthorizationEndpoint, query), nil
+}
+
+// Exchange обменивает код на ID-токен и проверяет его
+func (p *OpenID) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*Identity, error) {
+	cfg, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := exchangeCode(ctx, httpClient(p.Client), cfg.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return p.verifyIDToken(ctx, cfg, token.IDToken, nonce)
+}
+
+// idClaims - утверждения ID-токена
+type idClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      audience        `json:"aud"`
+	Expires       int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+// audience - aud ID-токена: строка или массив строк
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// verifyIDToken проверяет подпись, издателя, получателя, срок и nonce ID-токена
+func (p *OpenID) verifyIDToken(ctx context.Context, cfg *discovery, token, nonce string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed id_token header: %w", err)
+	}
+	key, err := p.key(ctx, cfg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token signature: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims idClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token claims: %w", err)
+	}
+	// Google выдает и токены с издателем без схемы
+	if claims.Issuer != cfg.Issuer && !(cfg.Issuer == Google && claims.Issuer == "accounts.google.com") {
+		return nil, fmt.Errorf("id_token issued by %q, want %q", claims.Issuer, cfg.Issuer)
+	}
+	if !containsString(claims.Audience, p.ClientID) {
+		return nil, errors.New("id_token is issued for another client")
+	}
+	if time.Now().Add(-clockSkew).Unix() >= claims.Expires {
+		return nil, errors.New("id_token expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, ErrNoSubject
+	}
+	// email_verified бывает строкой у некоторых поставщиков
+	verified := strings.Trim(string(claims.EmailVerified), `"`) == "true"
+	return &Identity{Subject: claims.Subject, Email: claims.Email, EmailVerified: verified, Name: claims.Name}, nil
+}
+
+// verifySignature проверяет подпись JWS алгоритмом alg
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("id_token key is not RSA")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid id_token signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("id_token key is not P-256")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("invalid id_token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported id_token algorithm %q", alg)
+	}
+	return nil
+}
+
+// discover загружает документ discovery издателя; удачный результат запоминается
+func (p *OpenID) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+	var cfg discovery
+	target := strings.TrimRight(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, httpClient(p.Client), target, "", &cfg); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", p.Issuer, err)
+	}
+	if cfg.AuthorizationEndpoint == "" || cfg.TokenEndpoint == "" || cfg.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is incomplete", p.Issuer)
+	}
+	if strings.TrimRight(cfg.Issuer, "/") != strings.TrimRight(p.Issuer, "/") {
+		return nil, fmt.Errorf("discovery document of %s names issuer %q", p.Issuer, cfg.Issuer)
+	}
+	p.config = &cfg
+	return p.config, nil
+}
+
+// key возвращает открытый ключ поставщика kid. Ключи поставщик меняет, поэтому при
+// неизвестном kid набор перезапрашивается, но не чаще keysRefresh.
+func (p *OpenID) key(ctx context.Context, cfg *discovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if p.keysValid && time.Since(p.keysAt) < keysRefresh {
+		return nil, fmt.Errorf("unknown id_token key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, httpClient(p.Client), cfg.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch keys of %s: %w", p.Issuer, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// Ключи шифрования и неподдерживаемых типов пропускаются
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	p.keys, p.keysAt, p.keysValid = keys, time.Now(), true
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id_token key %q", kid)
+}
+
+// jwk - открытый ключ из набора JWKS (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid EC key")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid EC key")
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// withQuery добавляет параметры к адресу, который может уже содержать запрос
+func withQuery(target string, query url.Values) string {
+	if strings.Contains(target, "?") {
+		return target + "&" + query.Encode()
+	}
+	return target + "?" + query.Encode()
+}
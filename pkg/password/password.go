@@ -0,0 +1,106 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Хеширование паролей пользователей Argon2id. Хеш хранится в формате PHC:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<соль base64>$<ключ base64>
+//
+// Параметры записаны в самом хеше, поэтому их можно менять: старые хеши проверяются со
+// своими параметрами, а NeedsRehash подсказывает пересчитать их при следующем входе.
+// Строки без префикса - пароли, сохраненные до хеширования, в открытом виде.
+
+const (
+	prefix  = "$argon2id$"
+	saltLen = 16
+	keyLen  = 32
+)
+
+// Параметры Argon2id для новых хешей (переменные, чтобы тесты не тратили на них время)
+var (
+	Time    uint32 = 3
+	Memory  uint32 = 64 << 10 // КиБ
+	Threads uint8  = 4
+)
+
+var errMalformed = errors.New("malformed password hash")
+
+// Hash возвращает хеш пароля со случайной солью
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, Time, Memory, Threads, keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", prefix, argon2.Version, Memory, Time, Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify сравнивает пароль с хешем за время, не зависящее от совпадения. Старые записи
+// в открытом виде сравниваются как есть.
+func Verify(hash, password string) bool {
+	if !strings.HasPrefix(hash, prefix) {
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+	p, err := parse(hash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1
+}
+
+// NeedsRehash сообщает, что хеш нужно пересчитать: пароль хранится в открытом виде или
+// захеширован с параметрами, отличными от текущих
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, prefix) {
+		return true
+	}
+	p, err := parse(hash)
+	if err != nil {
+		return true
+	}
+	return p.time != Time || p.memory != Memory || p.threads != Threads || len(p.key) != keyLen
+}
+
+type params struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parse(hash string) (*params, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", соль, ключ
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errMalformed
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errMalformed
+	}
+	p := &params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, errMalformed
+	}
+	if p.time == 0 || p.threads == 0 {
+		return nil, errMalformed
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errMalformed
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, errMalformed
+	}
+	return p, nil
+}
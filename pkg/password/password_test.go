@@ -0,0 +1,60 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Минимальная стоимость, чтобы тесты не тратили секунды на вывод ключей
+	Time, Memory, Threads = 1, 64, 1
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if !Verify(hash, "correct horse") {
+		t.Error("correct password rejected")
+	}
+	if Verify(hash, "correct horsE") {
+		t.Error("wrong password accepted")
+	}
+	if NeedsRehash(hash) {
+		t.Error("fresh hash needs rehash")
+	}
+
+	again, _ := Hash("correct horse")
+	if again == hash {
+		t.Error("salt is not random")
+	}
+}
+
+func TestLegacyAndParams(t *testing.T) {
+	if !Verify("plain", "plain") || Verify("plain", "other") {
+		t.Error("legacy plaintext comparison broken")
+	}
+	if !NeedsRehash("plain") {
+		t.Error("legacy plaintext does not need rehash")
+	}
+
+	hash, _ := Hash("secret")
+	Time = 2
+	defer func() { Time = 1 }()
+	if !Verify(hash, "secret") {
+		t.Error("hash with old parameters rejected")
+	}
+	if !NeedsRehash(hash) {
+		t.Error("hash with old parameters does not need rehash")
+	}
+
+	for _, bad := range []string{"$argon2id$", "$argon2id$v=19$m=64,t=0,p=1$AA$AA", "$argon2id$v=18$m=64,t=1,p=1$AA$AA"} {
+		if Verify(bad, "") {
+			t.Errorf("malformed hash %q accepted", bad)
+		}
+	}
+}
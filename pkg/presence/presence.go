@@ -0,0 +1,166 @@
+package presence
+
+import (
+	"sync"
+	"time"
+)
+
+// Присутствие пользователей по соединениям реального времени. Клиент держит соединение
+// (WebSocket) и периодически присылает heartbeat с признаком активности пользователя.
+// Пользователь online, пока у него есть живое соединение и он недавно был активен;
+// away - соединение живо, но активности не было дольше awayAfter; offline - соединений
+// нет или heartbeat не приходил дольше timeout (соединение зависло).
+
+// Статусы присутствия
+const (
+	Online  = "online"
+	Away    = "away"
+	Offline = "offline"
+)
+
+type entry struct {
+	conns      int
+	lastBeat   time.Time // последний признак жизни соединения
+	lastActive time.Time // последняя активность пользователя
+	status     string
+}
+
+// Tracker хранит присутствие пользователей в памяти процесса и сообщает об изменениях
+// статуса обработчику, заданному OnChange
+type Tracker struct {
+	mu        sync.Mutex
+	awayAfter time.Duration
+	timeout   time.Duration
+	users     map[string]*entry
+	onChange  func(userID, status string)
+	now       func() time.Time
+}
+
+// New создает трекер: away после awayAfter без активности, offline после timeout без heartbeat
+func New(awayAfter, timeout time.Duration) *Tracker {
+	return &Tracker{
+		awayAfter: awayAfter,
+		timeout:   timeout,
+		users:     make(map[string]*entry),
+		now:       time.Now,
+	}
+}
+
+// OnChange задает обработчик изменения статуса. Вызывается без блокировки трекера.
+func (t *Tracker) OnChange(handler func(userID, status string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onChange = handler
+}
+
+// Connect отмечает новое соединение пользователя; подключение считается активностью
+func (t *Tracker) Connect(userID string) {
+	t.update(userID, func(e *entry, now time.Time) {
+		e.conns++
+		e.lastBeat = now
+		e.lastActive = now
+	})
+}
+
+// Disconnect отмечает закрытие соединения пользователя
+func (t *Tracker) Disconnect(userID string) {
+	t.update(userID, func(e *entry, now time.Time) {
+		if e.conns > 0 {
+			e.conns--
+		}
+	})
+}
+
+// Heartbeat отмечает признак жизни соединения; active - пользователь что-то делал в
+// клиенте после предыдущего heartbeat
+func (t *Tracker) Heartbeat(userID string, active bool) {
+	t.update(userID, func(e *entry, now time.Time) {
+		e.lastBeat = now
+		if active {
+			e.lastActive = now
+		}
+	})
+}
+
+// Touch отмечает активность пользователя вне heartbeat (например, набор текста)
+func (t *Tracker) Touch(userID string) {
+	t.update(userID, func(e *entry, now time.Time) {
+		e.lastActive = now
+	})
+}
+
+// Status возвращает текущий статус пользователя
+func (t *Tracker) Status(userID string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.users[userID]
+	if !ok {
+		return Offline
+	}
+	return t.statusLocked(e, t.now())
+}
+
+// LastSeen возвращает время последней активности пользователя; нулевое - не заходил
+// с запуска процесса
+func (t *Tracker) LastSeen(userID string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.users[userID]; ok {
+		return e.lastActive
+	}
+	return time.Time{}
+}
+
+// Sweep пересчитывает статусы, изменившиеся со временем (away по бездействию, offline
+// по отсутствию heartbeat), и сообщает об изменениях
+func (t *Tracker) Sweep() {
+	t.mu.Lock()
+	now := t.now()
+	type change struct{ userID, status string }
+	var changes []change
+	for userID, e := range t.users {
+		if status := t.statusLocked(e, now); status != e.status {
+			e.status = status
+			changes = append(changes, change{userID, status})
+		}
+	}
+	handler := t.onChange
+	t.mu.Unlock()
+
+	if handler != nil {
+		for _, c := range changes {
+			handler(c.userID, c.status)
+		}
+	}
+}
+
+func (t *Tracker) update(userID string, apply func(e *entry, now time.Time)) {
+	t.mu.Lock()
+	e, ok := t.users[userID]
+	if !ok {
+		e = &entry{status: Offline}
+		t.users[userID] = e
+	}
+	now := t.now()
+	apply(e, now)
+	status := t.statusLocked(e, now)
+	changed := status != e.status
+	e.status = status
+	handler := t.onChange
+	t.mu.Unlock()
+
+	if changed && handler != nil {
+		handler(userID, status)
+	}
+}
+
+func (t *Tracker) statusLocked(e *entry, now time.Time) string {
+	switch {
+	case e.conns == 0 || now.Sub(e.lastBeat) > t.timeout:
+		return Offline
+	case now.Sub(e.lastActive) >= t.awayAfter:
+		return Away
+	default:
+		return Online
+	}
+}
@@ -0,0 +1,63 @@
+package presence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerStatuses(t *testing.T) {
+	now := time.Now()
+	tr := New(5*time.Minute, 90*time.Second)
+	tr.now = func() time.Time { return now }
+
+	var changes []string
+	tr.OnChange(func(userID, status string) { changes = append(changes, userID+":"+status) })
+
+	if tr.Status("alice") != Offline {
+		t.Error("unknown user must be offline")
+	}
+	tr.Connect("alice")
+	tr.Connect("alice")
+	if tr.Status("alice") != Online {
+		t.Errorf("expected online after connect, got %s", tr.Status("alice"))
+	}
+
+	// Соединение живо, но пользователь давно неактивен
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Minute)
+		tr.Heartbeat("alice", false)
+	}
+	if tr.Status("alice") != Away {
+		t.Errorf("expected away without activity, got %s", tr.Status("alice"))
+	}
+	tr.Heartbeat("alice", true)
+	if tr.Status("alice") != Online {
+		t.Errorf("expected online after activity, got %s", tr.Status("alice"))
+	}
+
+	// Одно из двух соединений закрыто - пользователь еще в сети
+	tr.Disconnect("alice")
+	if tr.Status("alice") != Online {
+		t.Errorf("expected online with one connection left, got %s", tr.Status("alice"))
+	}
+
+	// Без heartbeat соединение считается зависшим
+	now = now.Add(2 * time.Minute)
+	tr.Sweep()
+	if tr.Status("alice") != Offline {
+		t.Errorf("expected offline without heartbeats, got %s", tr.Status("alice"))
+	}
+
+	want := []string{"alice:online", "alice:away", "alice:online", "alice:offline"}
+	if len(changes) != len(want) {
+		t.Fatalf("changes: %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d: %s, want %s", i, changes[i], want[i])
+		}
+	}
+	if !tr.LastSeen("alice").Equal(now.Add(-2 * time.Minute)) {
+		t.Errorf("LastSeen: %v", tr.LastSeen("alice"))
+	}
+}
@@ -0,0 +1,205 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fcmEndpoint = "https://fcm.googleapis.com"
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+	googleToken = "https://oauth2.googleapis.com/token"
+)
+
+// FCM отправляет уведомления в приложения через Firebase Cloud Messaging HTTP v1.
+// Доступ - по ключу сервисного аккаунта проекта Firebase: сервер обменивает подписанный
+// им JWT на токен доступа OAuth 2.0 и переиспользует токен до истечения.
+type FCM struct {
+	ProjectID string
+	Endpoint  string // https://fcm.googleapis.com
+
+	Client   *http.Client
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// serviceAccount - нужные поля файла ключа сервисного аккаунта Google
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// NewFCM создает отправителя по файлу ключа сервисного аккаунта (JSON из консоли Firebase)
+func NewFCM(credentialsFile string) (*FCM, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, fmt.Errorf("FCM credentials need project_id and client_email")
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("FCM credentials have no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("FCM private key is not RSA")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = googleToken
+	}
+	return &FCM{
+		ProjectID: account.ProjectID,
+		Endpoint:  fcmEndpoint,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		email:     account.ClientEmail,
+		key:       key,
+		tokenURI:  account.TokenURI,
+		now:       time.Now,
+	}, nil
+}
+
+func (f *FCM) Send(ctx context.Context, sub Subscription, n Notification) error {
+	priority, apnsPriority := "normal", "5"
+	if n.Urgent {
+		priority, apnsPriority = "high", "10"
+	}
+	message := map[string]interface{}{
+		"token":        sub.Endpoint,
+		"notification": map[string]string{"title": n.Title, "body": n.Body},
+		"android": map[string]interface{}{
+			"priority": priority,
+			"ttl":      strconv.Itoa(int(n.TTL.Seconds())) + "s",
+		},
+		"apns": map[string]interface{}{
+			"headers": map[string]string{
+				"apns-priority":   apnsPriority,
+				"apns-expiration": strconv.FormatInt(f.now().Add(n.TTL).Unix(), 10),
+			},
+		},
+	}
+	if len(n.Data) > 0 {
+		message["data"] = n.Data
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return fmt.Errorf("failed to encode FCM message: %w", err)
+	}
+
+	token, err := f.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	sendURL := strings.TrimSuffix(f.Endpoint, "/") + "/v1/projects/" + url.PathEscape(f.ProjectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create FCM request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send FCM message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// UNREGISTERED: приложение удалено или токен устарел
+		return ErrGone
+	case resp.StatusCode == http.StatusUnauthorized:
+		f.mu.Lock()
+		f.token = ""
+		f.mu.Unlock()
+		return fmt.Errorf("FCM rejected access token")
+	case resp.StatusCode >= 300:
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("FCM returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// accessToken возвращает действующий токен доступа, при необходимости получая новый
+func (f *FCM) accessToken(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if f.token != "" && now.Add(time.Minute).Before(f.expires) {
+		return f.token, nil
+	}
+
+	claims := map[string]interface{}{
+		"iss":   f.email,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	assertion, err := signJWT("RS256", claims, func(digest []byte) ([]byte, error) {
+		return rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest)
+	})
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create FCM token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint returned %s", resp.Status)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("invalid FCM token response")
+	}
+	f.token = result.AccessToken
+	f.expires = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return f.token, nil
+}
@@ -0,0 +1,61 @@
+// Package push отправляет push уведомления на устройства: в браузеры по Web Push
+// (RFC 8030, шифрование RFC 8291, VAPID RFC 8292) и в мобильные приложения через
+// Firebase Cloud Messaging (HTTP v1 API).
+package push
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrGone - служба push больше не принимает уведомления по адресу (подписка отозвана или
+// приложение удалено); подписку нужно удалить
+var ErrGone = errors.New("push subscription is no longer valid")
+
+// Subscription - адрес доставки. Для Web Push Endpoint - URL службы push браузера,
+// P256dh и Auth - ключи шифрования из PushSubscription (base64url); для FCM Endpoint -
+// токен регистрации приложения.
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// Notification - уведомление. Data передается приложению как есть; служба push хранит
+// недоставленное уведомление не дольше TTL.
+type Notification struct {
+	Title  string            `json:"title"`
+	Body   string            `json:"body"`
+	Data   map[string]string `json:"data,omitempty"`
+	TTL    time.Duration     `json:"-"`
+	Urgent bool              `json:"-"` // входящий звонок: доставить немедленно, разбудив устройство
+}
+
+// Sender доставляет уведомления одного вида подписок
+type Sender interface {
+	Send(ctx context.Context, sub Subscription, n Notification) error
+}
+
+// signJWT собирает JWT с заголовком alg и подписью sign над "header.claims"
+func signJWT(alg string, claims interface{}, sign func(digest []byte) ([]byte, error)) (string, error) {
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": alg})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := sign(digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
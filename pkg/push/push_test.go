@@ -0,0 +1,208 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decryptWebPush расшифровывает тело запроса ключами браузера (RFC 8291)
+func decryptWebPush(t *testing.T, ua *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	asPublic := body[21 : 21+idLen]
+	if rs != webPushRecordSize || idLen != 65 {
+		t.Fatalf("unexpected header: rs=%d idlen=%d", rs, idLen)
+	}
+	asKey, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, _ := ua.ECDH(asKey)
+	ikm, _ := hkdf.Key(sha256.New, shared, authSecret, "WebPush: info\x00"+string(ua.PublicKey().Bytes())+string(asPublic), 32)
+	cek, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt push: %v", err)
+	}
+	if plain[len(plain)-1] != 0x02 {
+		t.Fatalf("missing last record delimiter")
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestWebPush(t *testing.T) {
+	ua, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	var got *http.Request
+	var body []byte
+	status := http.StatusCreated
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	private, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWebPush(private, ""); err == nil {
+		t.Error("VAPID subject not required")
+	}
+	if _, err := NewWebPush("bad", "mailto:ops@example.com"); err == nil {
+		t.Error("invalid VAPID key accepted")
+	}
+	wp, err := NewWebPush(private, "mailto:ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp.Client = srv.Client()
+
+	sub := Subscription{
+		Endpoint: srv.URL + "/push/abc",
+		P256dh:   base64.RawURLEncoding.EncodeToString(ua.PublicKey().Bytes()),
+		Auth:     base64.URLEncoding.EncodeToString(authSecret),
+	}
+	n := Notification{Title: "Hydra", Body: "Новое сообщение", Data: map[string]string{"type": "message"}, TTL: time.Hour, Urgent: true}
+	if err := wp.Send(t.Context(), sub, n); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/push/abc" || got.Header.Get("Content-Encoding") != "aes128gcm" || got.Header.Get("TTL") != "3600" || got.Header.Get("Urgency") != "high" {
+		t.Errorf("unexpected request: %s %v", got.URL.Path, got.Header)
+	}
+	var decoded Notification
+	if err := json.Unmarshal(decryptWebPush(t, ua, authSecret, body), &decoded); err != nil || decoded.Body != n.Body || decoded.Data["type"] != "message" {
+		t.Errorf("unexpected payload: %+v, %v", decoded, err)
+	}
+
+	// Токен VAPID подписан ключом, открытая часть которого передана в k=
+	auth := got.Header.Get("Authorization")
+	token, key, ok := strings.Cut(strings.TrimPrefix(auth, "vapid t="), ", k=")
+	if !ok || key != wp.PublicKey() {
+		t.Fatalf("unexpected Authorization: %s", auth)
+	}
+	parts := strings.Split(token, ".")
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var vapid struct {
+		Aud string `json:"aud"`
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+	}
+	json.Unmarshal(claims, &vapid)
+	if vapid.Aud != srv.URL || vapid.Sub != "mailto:ops@example.com" || vapid.Exp <= time.Now().Unix() {
+		t.Errorf("unexpected VAPID claims: %+v", vapid)
+	}
+	rawPublic, _ := base64.RawURLEncoding.DecodeString(key)
+	x, y := elliptic.Unmarshal(elliptic.P256(), rawPublic)
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.Verify(public, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Error("VAPID signature does not verify")
+	}
+
+	status = http.StatusGone
+	if err := wp.Send(t.Context(), sub, n); !errors.Is(err, ErrGone) {
+		t.Errorf("expected ErrGone, got %v", err)
+	}
+	n.Body = strings.Repeat("x", maxWebPushPayload)
+	if err := wp.Send(t.Context(), sub, n); err == nil {
+		t.Error("oversized payload sent")
+	}
+}
+
+func TestFCM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	tokens, sends := 0, 0
+	var message map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at-1", "expires_in": 3600})
+	})
+	mux.HandleFunc("POST /v1/projects/hydra-app/messages:send", func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		if r.Header.Get("Authorization") != "Bearer at-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req map[string]map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		message = req["message"]
+		if message["token"] == "stale" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "hydra-app",
+		"client_email": "push@hydra-app.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL + "/token",
+	})
+	path := filepath.Join(t.TempDir(), "fcm.json")
+	os.WriteFile(path, credentials, 0o600)
+	fcm, err := NewFCM(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fcm.Endpoint = srv.URL
+	fcm.Client = srv.Client()
+
+	n := Notification{Title: "Hydra", Body: "Входящий звонок", Data: map[string]string{"type": "call"}, TTL: 30 * time.Second, Urgent: true}
+	for range 2 {
+		if err := fcm.Send(t.Context(), Subscription{Endpoint: "device-token"}, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens != 1 || sends != 2 {
+		t.Errorf("access token not reused: %d tokens, %d sends", tokens, sends)
+	}
+	android, _ := message["android"].(map[string]interface{})
+	if message["token"] != "device-token" || android["priority"] != "high" || android["ttl"] != "30s" {
+		t.Errorf("unexpected message: %v", message)
+	}
+	if err := fcm.Send(t.Context(), Subscription{Endpoint: "stale"}, n); !errors.Is(err, ErrGone) {
+		t.Errorf("expected ErrGone, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Размер записи aes128gcm и предельный размер уведомления: запись вмещает заголовок
+// (соль, размер записи, длина и открытый ключ сервера), тег GCM и разделитель
+const (
+	webPushRecordSize = 4096
+	webPushHeaderSize = 16 + 4 + 1 + 65
+	maxWebPushPayload = webPushRecordSize - webPushHeaderSize - 16 - 1
+)
+
+// vapidTokenTTL - срок токена VAPID (RFC 8292 допускает не больше суток)
+const vapidTokenTTL = 12 * time.Hour
+
+// WebPush отправляет уведомления в браузеры. Сервер подписывает запросы ключом VAPID;
+// браузер подписывается с его открытым ключом (applicationServerKey), поэтому служба
+// push принимает уведомления для подписки только от этого сервера.
+type WebPush struct {
+	Subject string // mailto: или https: адрес оператора сервера для службы push
+
+	Client    *http.Client
+	key       *ecdsa.PrivateKey
+	publicKey string
+	now       func() time.Time
+}
+
+// NewWebPush создает отправителя с закрытым ключом VAPID privateKey (P-256, base64url)
+func NewWebPush(privateKey, subject string) (*WebPush, error) {
+	raw, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	public, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("VAPID subject (mailto: or https: contact) is required")
+	}
+	return &WebPush{
+		Subject:   subject,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		now:       time.Now,
+	}, nil
+}
+
+// GenerateVAPIDKey создает закрытый ключ VAPID для NewWebPush
+func GenerateVAPIDKey() (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate VAPID key: %w", err)
+	}
+	raw, err := key.Bytes()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode VAPID key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// PublicKey возвращает открытый ключ VAPID (base64url) для подписки в браузере
+func (p *WebPush) PublicKey() string {
+	return p.publicKey
+}
+
+func (p *WebPush) Send(ctx context.Context, sub Subscription, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	body, err := encryptWebPush(sub, payload)
+	if err != nil {
+		return err
+	}
+	token, err := p.vapidToken(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Authorization", "vapid t="+token+", k="+p.publicKey)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(n.TTL.Seconds())))
+	if n.Urgent {
+		req.Header.Set("Urgency", "high")
+	} else {
+		req.Header.Set("Urgency", "normal")
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// vapidToken подписывает токен VAPID для источника (схема и хост) службы push
+func (p *WebPush) vapidToken(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint %q", endpoint)
+	}
+	claims := map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": p.now().Add(vapidTokenTTL).Unix(),
+		"sub": p.Subject,
+	}
+	return signJWT("ES256", claims, func(digest []byte) ([]byte, error) {
+		r, s, err := ecdsa.Sign(rand.Reader, p.key, digest)
+		if err != nil {
+			return nil, err
+		}
+		// JWS ES256: r и s по 32 байта подряд, а не DER
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	})
+}
+
+// encryptWebPush шифрует payload для подписки по RFC 8291 (aes128gcm, одна запись)
+func encryptWebPush(sub Subscription, payload []byte) ([]byte, error) {
+	if len(payload) > maxWebPushPayload {
+		return nil, fmt.Errorf("push payload of %d bytes exceeds %d", len(payload), maxWebPushPayload)
+	}
+	uaPublic, err := decodeKey(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := decodeKey(sub.Auth)
+	if err != nil || len(authSecret) == 0 {
+		return nil, fmt.Errorf("invalid auth secret")
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+
+	// Для каждого уведомления - новая пара ключей сервера и соль
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate push key: %w", err)
+	}
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive push secret: %w", err)
+	}
+	asPublic := asKey.PublicKey().Bytes()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate push salt: %w", err)
+	}
+
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, webPushHeaderSize)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	// 0x02 - разделитель последней записи
+	record := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// decodeKey декодирует ключ в base64url; браузеры и библиотеки иногда оставляют выравнивание
+func decodeKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
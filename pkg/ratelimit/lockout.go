@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout считает неудачные попытки по ключу (аккаунт, IP, телефон) и после MaxFailures
+// неудач в пределах Window блокирует ключ на LockFor. Успешная попытка сбрасывает счетчик.
+type Lockout struct {
+	MaxFailures int
+	Window      time.Duration
+	LockFor     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockEntry
+	now     func() time.Time
+}
+
+type lockEntry struct {
+	failures    int
+	first       time.Time // первая неудача текущего окна
+	lockedUntil time.Time
+}
+
+// NewLockout создает счетчик: maxFailures неудач за window блокируют ключ на lockFor;
+// maxFailures <= 0 - без блокировки
+func NewLockout(maxFailures int, window, lockFor time.Duration) *Lockout {
+	return &Lockout{
+		MaxFailures: maxFailures,
+		Window:      window,
+		LockFor:     lockFor,
+		entries:     make(map[string]*lockEntry),
+		now:         time.Now,
+	}
+}
+
+// Locked сообщает, заблокирован ли ключ, и сколько осталось до снятия блокировки
+func (l *Lockout) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, exists := l.entries[key]
+	if !exists {
+		return 0, false
+	}
+	left := e.lockedUntil.Sub(l.now())
+	if left <= 0 {
+		return 0, false
+	}
+	return left, true
+}
+
+// Fail записывает неудачную попытку; true - ключ только что заблокирован
+func (l *Lockout) Fail(key string) bool {
+	if l.MaxFailures <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanupLocked(now)
+	e, exists := l.entries[key]
+	if !exists || now.Sub(e.first) > l.Window {
+		e = &lockEntry{first: now}
+		l.entries[key] = e
+	}
+	if now.Before(e.lockedUntil) {
+		return false
+	}
+	e.failures++
+	if e.failures < l.MaxFailures {
+		return false
+	}
+	// После блокировки счет начинается заново
+	e.failures = 0
+	e.first = now
+	e.lockedUntil = now.Add(l.LockFor)
+	return true
+}
+
+// Reset сбрасывает счетчик ключа после успешной попытки
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, exists := l.entries[key]; exists && !l.now().Before(e.lockedUntil) {
+		delete(l.entries, key)
+	}
+}
+
+// cleanupLocked удаляет устаревшие счетчики, чтобы карта не росла бесконечно
+func (l *Lockout) cleanupLocked(now time.Time) {
+	if len(l.entries) < 1024 {
+		return
+	}
+	for key, e := range l.entries {
+		if now.Sub(e.first) > l.Window && !now.Before(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter ограничивает частоту запросов по ключу (например, IP клиента) алгоритмом
+// token bucket: Burst запросов сразу, далее Rate запросов в секунду.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New создает ограничитель: perMinute запросов в минуту, burst - допустимый всплеск
+func New(perMinute, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		Rate:    float64(perMinute) / 60,
+		Burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow сообщает, можно ли выполнить запрос с ключом key, и списывает токен
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+
+	l.cleanupLocked(now)
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanupLocked удаляет полностью восстановившиеся корзины, чтобы карта не росла бесконечно
+func (l *Limiter) cleanupLocked(now time.Time) {
+	if len(l.buckets) < 1024 || l.Rate <= 0 {
+		return
+	}
+	full := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
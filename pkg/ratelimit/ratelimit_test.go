@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(60, 2) // 1 запрос в секунду, всплеск 2
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("Burst requests should be allowed")
+	}
+	if l.Allow("a") {
+		t.Error("Request over burst should be rejected")
+	}
+	if !l.Allow("b") {
+		t.Error("Other keys must have their own limit")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("a") {
+		t.Error("Token should be restored after 1s")
+	}
+	if l.Allow("a") {
+		t.Error("Only one token should be restored after 1s")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	l := NewLockout(3, time.Minute, 10*time.Minute)
+	l.now = func() time.Time { return now }
+
+	if l.Fail("a") || l.Fail("a") {
+		t.Fatal("Key locked before MaxFailures")
+	}
+	if _, locked := l.Locked("a"); locked {
+		t.Fatal("Key locked before MaxFailures")
+	}
+	if !l.Fail("a") {
+		t.Fatal("Third failure should lock the key")
+	}
+	if left, locked := l.Locked("a"); !locked || left != 10*time.Minute {
+		t.Errorf("Locked = %v, %v", left, locked)
+	}
+	if _, locked := l.Locked("b"); locked {
+		t.Error("Other keys must have their own counter")
+	}
+	l.Reset("a")
+	if _, locked := l.Locked("a"); !locked {
+		t.Error("Success must not lift an active lock")
+	}
+
+	now = now.Add(10 * time.Minute)
+	if _, locked := l.Locked("a"); locked {
+		t.Error("Lock should expire after LockFor")
+	}
+
+	// Неудачи вне окна не складываются, успешная попытка сбрасывает счетчик
+	l.Fail("c")
+	l.Fail("c")
+	now = now.Add(2 * time.Minute)
+	if l.Fail("c") {
+		t.Error("Failures outside Window should not count")
+	}
+	l.Fail("c")
+	l.Reset("c")
+	if l.Fail("c") {
+		t.Error("Reset should clear failures")
+	}
+}
@@ -0,0 +1,207 @@
+package reachability
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Статусы пути в регионе
+const (
+	StatusWorking = "working" // большинство клиентов региона пользуются путем успешно
+	StatusBlocked = "blocked" // большинство клиентов региона сообщают о сбоях
+	StatusMixed   = "mixed"   // мнения расходятся (частичная блокировка, нестабильность)
+)
+
+// workingRatio - доля успешных голосов, начиная с которой путь считается рабочим
+// (и до 1-workingRatio - заблокированным)
+const workingRatio = 2.0 / 3
+
+// Status возвращает статус пути по доле клиентов, у которых он работает
+func Status(workingShare float64) string {
+	switch {
+	case workingShare >= workingRatio:
+		return StatusWorking
+	case workingShare <= 1-workingRatio:
+		return StatusBlocked
+	default:
+		return StatusMixed
+	}
+}
+
+// Hint - сводка наблюдений за путем в регионе
+type Hint struct {
+	Kind      string `json:"kind"`
+	Target    string `json:"target"`
+	Status    string `json:"status"`
+	Reporters int    `json:"reporters"` // число клиентов, сообщивших о пути
+	Working   int    `json:"working"`   // из них путь работает у
+}
+
+// HintList - региональные подсказки, распространяемые через /api/bridges
+type HintList struct {
+	Region    string `json:"region"`
+	IssuedAt  int64  `json:"issued_at"`  // Unix время в миллисекундах
+	ReportKey string `json:"report_key"` // base64 публичного ключа X25519 для шифрования отчетов
+	Hints     []Hint `json:"hints"`
+}
+
+// SignedHintList - подсказки, подписанные ключом сервера (формат как у SignedFrontList):
+// Payload - base64 JSON HintList
+type SignedHintList struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+	PublicKey string `json:"public_key"`
+}
+
+// Signer - ключ сервера, которым подписываются подсказки (timesync.Signer)
+type Signer interface {
+	SignData(data []byte) string
+	PublicKey() ed25519.PublicKey
+}
+
+// SignHints подписывает подсказки ключом сервера
+func SignHints(signer Signer, list *HintList) *SignedHintList {
+	data, _ := json.Marshal(list)
+	return &SignedHintList{
+		Payload:   base64.StdEncoding.EncodeToString(data),
+		Signature: signer.SignData(data),
+		PublicKey: base64.StdEncoding.EncodeToString(signer.PublicKey()),
+	}
+}
+
+// VerifyHints проверяет подпись подсказок известным публичным ключом сервера
+func VerifyHints(publicKey ed25519.PublicKey, signed *SignedHintList) (*HintList, error) {
+	data, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, data, sig) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var list HintList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid hint list: %w", err)
+	}
+	return &list, nil
+}
+
+// Aggregator сводит отчеты клиентов в региональные подсказки. Каждый клиент (ключ
+// отчета) имеет один голос за путь - последнее наблюдение в окне. Пути, о которых
+// сообщило меньше MinReporters клиентов, не публикуются: это затрудняет подмену
+// подсказок одиночным клиентом и не раскрывает пути отдельных пользователей.
+type Aggregator struct {
+	Window       time.Duration
+	MinReporters int
+
+	mu    sync.Mutex
+	votes map[string]map[pathKey]map[string]vote // регион -> путь -> клиент -> голос
+}
+
+type pathKey struct {
+	Kind   string
+	Target string
+}
+
+type vote struct {
+	working bool
+	at      time.Time
+}
+
+// NewAggregator создает агрегатор с окном window и порогом minReporters
+func NewAggregator(window time.Duration, minReporters int) *Aggregator {
+	if minReporters < 1 {
+		minReporters = 1
+	}
+	return &Aggregator{
+		Window:       window,
+		MinReporters: minReporters,
+		votes:        make(map[string]map[pathKey]map[string]vote),
+	}
+}
+
+// Add учитывает отчет клиента reporter
+func (a *Aggregator) Add(reporter string, report *Report, now time.Time) error {
+	if err := report.Validate(now); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	region := a.votes[report.Region]
+	if region == nil {
+		region = make(map[pathKey]map[string]vote)
+		a.votes[report.Region] = region
+	}
+	observedAt := time.UnixMilli(report.ObservedAt)
+	for _, o := range report.Observations {
+		key := pathKey{o.Kind, o.Target}
+		if region[key] == nil {
+			region[key] = make(map[string]vote)
+		}
+		// Более старое наблюдение не заменяет более новое
+		if prev, exists := region[key][reporter]; exists && prev.at.After(observedAt) {
+			continue
+		}
+		region[key][reporter] = vote{working: o.Working, at: observedAt}
+	}
+	return nil
+}
+
+// Hints возвращает подсказки для региона, отсортированные по типу и адресу
+func (a *Aggregator) Hints(region string, now time.Time) []Hint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireLocked(now)
+	hints := []Hint{}
+	for key, votes := range a.votes[region] {
+		if len(votes) < a.MinReporters {
+			continue
+		}
+		hint := Hint{Kind: key.Kind, Target: key.Target, Reporters: len(votes)}
+		for _, v := range votes {
+			if v.working {
+				hint.Working++
+			}
+		}
+		hint.Status = Status(float64(hint.Working) / float64(hint.Reporters))
+		hints = append(hints, hint)
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		if hints[i].Kind != hints[j].Kind {
+			return hints[i].Kind < hints[j].Kind
+		}
+		return hints[i].Target < hints[j].Target
+	})
+	return hints
+}
+
+// expireLocked удаляет голоса старше окна
+func (a *Aggregator) expireLocked(now time.Time) {
+	for region, paths := range a.votes {
+		for key, votes := range paths {
+			for reporter, v := range votes {
+				if now.Sub(v.at) > a.Window {
+					delete(votes, reporter)
+				}
+			}
+			if len(votes) == 0 {
+				delete(paths, key)
+			}
+		}
+		if len(paths) == 0 {
+			delete(a.votes, region)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package reachability собирает наблюдения клиентов о том, какие фронт-домены и ретрансляторы
+// работают в их регионе, и сводит их в региональные подсказки.
+//
+// Клиент подписывает отчет своим анонимным ключом Ed25519 и шифрует его для сервера
+// (X25519 + AES-256-GCM), поэтому промежуточные узлы (CDN фронта, mesh) не видят, какие
+// пути работают у клиента. Сервер учитывает один голос на ключ клиента и публикует только
+// сводки, подтвержденные несколькими клиентами, подписанные ключом сервера.
+package reachability
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Типы наблюдаемых путей
+const (
+	KindFront = "front" // фронт-домен domain fronting
+	KindRelay = "relay" // ретранслятор (TURN, mesh узел и т.п.)
+
+	// KindTransport - транспорт в целом (domain-fronting, mesh). Такие подсказки строятся
+	// по обезличенной телеметрии узлов (пакет telemetry), а не по отчетам клиентов.
+	KindTransport = "transport"
+)
+
+const (
+	maxObservations = 64               // максимум наблюдений в одном отчете
+	maxReportAge    = time.Hour        // отчеты старше отклоняются
+	maxClockSkew    = 5 * time.Minute  // допустимое опережение часов клиента
+	maxTargetLength = 253              // длина доменного имени
+	sealContext     = "hydra-reach-v1" // разделение ключей шифрования отчетов
+)
+
+// regionPattern - регион: код страны, ASN или имя, выбранное оператором
+var regionPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Observation - результат попытки клиента использовать путь
+type Observation struct {
+	Kind      string `json:"kind"`
+	Target    string `json:"target"`
+	Working   bool   `json:"working"`
+	LatencyMs int    `json:"latency_ms,omitempty"`
+}
+
+// Report - наблюдения клиента в его регионе
+type Report struct {
+	Region       string        `json:"region"`
+	ObservedAt   int64         `json:"observed_at"` // Unix время в миллисекундах
+	Observations []Observation `json:"observations"`
+}
+
+// Validate проверяет отчет относительно времени now
+func (r *Report) Validate(now time.Time) error {
+	if !regionPattern.MatchString(r.Region) {
+		return fmt.Errorf("invalid region")
+	}
+	observedAt := time.UnixMilli(r.ObservedAt)
+	if now.Sub(observedAt) > maxReportAge || observedAt.Sub(now) > maxClockSkew {
+		return fmt.Errorf("report is too old or from the future")
+	}
+	if len(r.Observations) == 0 || len(r.Observations) > maxObservations {
+		return fmt.Errorf("report must contain 1-%d observations", maxObservations)
+	}
+	for _, o := range r.Observations {
+		if o.Kind != KindFront && o.Kind != KindRelay {
+			return fmt.Errorf("unknown observation kind %q", o.Kind)
+		}
+		if o.Target == "" || len(o.Target) > maxTargetLength {
+			return fmt.Errorf("invalid observation target")
+		}
+	}
+	return nil
+}
+
+// SealedReport - зашифрованный для сервера отчет
+type SealedReport struct {
+	Ephemeral  string `json:"ephemeral"`  // base64 эфемерного публичного ключа X25519 клиента
+	Nonce      string `json:"nonce"`      // base64 nonce AES-GCM
+	Ciphertext string `json:"ciphertext"` // base64 AES-256-GCM(signedReport)
+}
+
+// signedReport - отчет, подписанный анонимным ключом клиента (внутри шифрования)
+type signedReport struct {
+	Report    json.RawMessage `json:"report"`
+	Reporter  string          `json:"reporter"`  // base64 публичного ключа Ed25519 клиента
+	Signature string          `json:"signature"` // base64(ed25519(report))
+}
+
+// Seal подписывает отчет ключом клиента и шифрует его публичным ключом сервера
+func Seal(report *Report, serverKey *ecdh.PublicKey, reporterKey ed25519.PrivateKey) (*SealedReport, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %w", err)
+	}
+	inner, _ := json.Marshal(signedReport{
+		Report:    data,
+		Reporter:  base64.StdEncoding.EncodeToString(reporterKey.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(reporterKey, data)),
+	})
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := reportAEAD(shared, ephemeral.PublicKey(), serverKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return &SealedReport{
+		Ephemeral:  base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, inner, nil)),
+	}, nil
+}
+
+// Open расшифровывает отчет ключом сервера и проверяет подпись клиента.
+// Возвращает отчет и ключ клиента (base64), по которому учитывается один голос.
+func Open(sealed *SealedReport, serverKey *ecdh.PrivateKey) (*Report, string, error) {
+	ephemeralBytes, err := base64.StdEncoding.DecodeString(sealed.Ephemeral)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ephemeral key encoding: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid nonce encoding: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ciphertext encoding: %w", err)
+	}
+
+	shared, err := serverKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, "", fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := reportAEAD(shared, ephemeral, serverKey.PublicKey())
+	if err != nil {
+		return nil, "", err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, "", fmt.Errorf("invalid nonce size")
+	}
+	inner, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt report: %w", err)
+	}
+
+	var signed signedReport
+	if err := json.Unmarshal(inner, &signed); err != nil {
+		return nil, "", fmt.Errorf("invalid report: %w", err)
+	}
+	reporter, err := base64.StdEncoding.DecodeString(signed.Reporter)
+	if err != nil || len(reporter) != ed25519.PublicKeySize {
+		return nil, "", fmt.Errorf("invalid reporter key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(reporter), signed.Report, sig) {
+		return nil, "", fmt.Errorf("invalid report signature")
+	}
+
+	var report Report
+	if err := json.Unmarshal(signed.Report, &report); err != nil {
+		return nil, "", fmt.Errorf("invalid report: %w", err)
+	}
+	return &report, signed.Reporter, nil
+}
+
+// reportAEAD выводит ключ AES-256-GCM из общего секрета ECDH и публичных ключей сторон
+func reportAEAD(shared []byte, ephemeral, server *ecdh.PublicKey) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte(sealContext))
+	h.Write(shared)
+	h.Write(ephemeral.Bytes())
+	h.Write(server.Bytes())
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewServerKey создает ключ сервера для расшифровки отчетов из 32-байтного seed.
+// Если seed пустой, генерируется случайный ключ (действителен до перезапуска сервера).
+func NewServerKey(seed []byte) (*ecdh.PrivateKey, error) {
+	if len(seed) == 0 {
+		return ecdh.X25519().GenerateKey(rand.Reader)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reachability key: %w", err)
+	}
+	return key, nil
+}
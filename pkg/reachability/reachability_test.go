@@ -0,0 +1,117 @@
+package reachability
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"hydra/pkg/timesync"
+	"testing"
+	"time"
+)
+
+func newReport(now time.Time, working bool) *Report {
+	return &Report{
+		Region:       "RU-MOW",
+		ObservedAt:   now.UnixMilli(),
+		Observations: []Observation{{Kind: KindFront, Target: "cdn.example.com", Working: working}},
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	serverKey, err := NewServerKey(nil)
+	if err != nil {
+		t.Fatalf("NewServerKey failed: %v", err)
+	}
+	pub, reporterKey, _ := ed25519.GenerateKey(nil)
+
+	sealed, err := Seal(newReport(time.Now(), true), serverKey.PublicKey(), reporterKey)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	report, reporter, err := Open(sealed, serverKey)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if report.Region != "RU-MOW" || len(report.Observations) != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if reporter != base64.StdEncoding.EncodeToString(pub) {
+		t.Errorf("Unexpected reporter key %s", reporter)
+	}
+
+	// Другой ключ сервера не расшифровывает отчет
+	otherKey, _ := NewServerKey(nil)
+	if _, _, err := Open(sealed, otherKey); err == nil {
+		t.Error("Expected error for foreign server key")
+	}
+
+	// Изменение шифртекста обнаруживается
+	sealed.Ciphertext = "A" + sealed.Ciphertext[1:]
+	if _, _, err := Open(sealed, serverKey); err == nil {
+		t.Error("Expected error for tampered report")
+	}
+}
+
+func TestAggregatorRequiresReporters(t *testing.T) {
+	now := time.Now()
+	agg := NewAggregator(time.Hour, 3)
+
+	// Один клиент, сколько бы отчетов ни прислал, имеет один голос
+	for i := 0; i < 5; i++ {
+		agg.Add("spammer", newReport(now, false), now)
+	}
+	if hints := agg.Hints("RU-MOW", now); len(hints) != 0 {
+		t.Fatalf("Expected no hints below MinReporters, got %+v", hints)
+	}
+
+	agg.Add("r2", newReport(now, false), now)
+	agg.Add("r3", newReport(now, true), now)
+	hints := agg.Hints("RU-MOW", now)
+	if len(hints) != 1 || hints[0].Reporters != 3 || hints[0].Working != 1 || hints[0].Status != StatusBlocked {
+		t.Fatalf("Unexpected hints: %+v", hints)
+	}
+
+	// Новое наблюдение клиента заменяет старое
+	agg.Add("spammer", newReport(now.Add(time.Second), true), now.Add(time.Second))
+	if hints := agg.Hints("RU-MOW", now.Add(time.Second)); hints[0].Status != StatusWorking {
+		t.Errorf("Expected working status, got %s", hints[0].Status)
+	}
+
+	// Голоса старше окна не учитываются, другие регионы не затрагиваются
+	if hints := agg.Hints("RU-MOW", now.Add(2*time.Hour)); len(hints) != 0 {
+		t.Errorf("Expected hints to expire, got %+v", hints)
+	}
+	if hints := agg.Hints("DE", now); len(hints) != 0 {
+		t.Errorf("Expected no hints for other region, got %+v", hints)
+	}
+}
+
+func TestReportValidation(t *testing.T) {
+	now := time.Now()
+	agg := NewAggregator(time.Hour, 1)
+
+	stale := newReport(now.Add(-2*time.Hour), true)
+	if err := agg.Add("r1", stale, now); err == nil {
+		t.Error("Expected stale report to be rejected")
+	}
+	bad := newReport(now, true)
+	bad.Region = "../etc"
+	if err := agg.Add("r1", bad, now); err == nil {
+		t.Error("Expected invalid region to be rejected")
+	}
+}
+
+func TestSignedHints(t *testing.T) {
+	signer, _ := timesync.NewSigner(nil)
+	signed := SignHints(signer, &HintList{Region: "DE", Hints: []Hint{{Kind: KindRelay, Target: "turn.example.com", Status: StatusWorking}}})
+
+	list, err := VerifyHints(signer.PublicKey(), signed)
+	if err != nil || list.Region != "DE" || len(list.Hints) != 1 {
+		t.Fatalf("VerifyHints failed: %v %+v", err, list)
+	}
+
+	other, _ := timesync.NewSigner(nil)
+	if _, err := VerifyHints(other.PublicKey(), signed); err == nil {
+		t.Error("Expected error for foreign signature")
+	}
+}
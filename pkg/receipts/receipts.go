@@ -0,0 +1,173 @@
+package receipts
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Агрегация квитанций в больших группах. Вместо квитанции от каждого участника автор
+// сообщения периодически получает один пакет со сводками по своим сообщениям: сколько
+// участников получили и прочитали сообщение и (в зависимости от уровня детализации)
+// кто именно. Сводки накопительные, поэтому потерянный или повторный пакет ничего не портит.
+
+// Уровни детализации сводки
+const (
+	DetailCount   = "count"   // только счетчики
+	DetailSampled = "sampled" // счетчики и первые прочитавшие (выборка)
+	DetailFull    = "full"    // счетчики и все прочитавшие
+)
+
+// Статусы квитанций
+const (
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+)
+
+// ValidDetail сообщает, известен ли уровень детализации
+func ValidDetail(detail string) bool {
+	return detail == DetailCount || detail == DetailSampled || detail == DetailFull
+}
+
+// Receipt - квитанция участника группы о сообщении автора SenderID
+type Receipt struct {
+	MessageID string
+	GroupID   string
+	SenderID  string
+	ReaderID  string
+	Status    string
+}
+
+// Summary - накопительная сводка квитанций сообщения. Прочитавшие учитываются и как получившие.
+type Summary struct {
+	MessageID string   `json:"message_id"`
+	GroupID   string   `json:"group_id"`
+	Delivered int      `json:"delivered"`
+	Read      int      `json:"read"`
+	Readers   []string `json:"readers,omitempty"`
+}
+
+// Batch - пакет сводок для автора сообщений
+type Batch struct {
+	SenderID  string     `json:"sender_id"`
+	Summaries []*Summary `json:"summaries"`
+}
+
+type message struct {
+	groupID, senderID string
+	delivered         map[string]bool
+	read              map[string]bool
+	readers           []string // в порядке прочтения
+	updatedAt         time.Time
+}
+
+// Aggregator копит квитанции в памяти и отдает пакеты изменившихся сводок
+type Aggregator struct {
+	mu         sync.Mutex
+	detail     string
+	sampleSize int
+	messages   map[string]*message
+	dirty      map[string]bool // сообщения с квитанциями после последнего Flush
+}
+
+// NewAggregator создает агрегатор с уровнем детализации detail; при DetailSampled
+// в сводку попадают не больше sampleSize прочитавших
+func NewAggregator(detail string, sampleSize int) (*Aggregator, error) {
+	if !ValidDetail(detail) {
+		return nil, fmt.Errorf("unknown receipt detail level %q", detail)
+	}
+	if sampleSize < 0 {
+		sampleSize = 0
+	}
+	return &Aggregator{
+		detail:     detail,
+		sampleSize: sampleSize,
+		messages:   make(map[string]*message),
+		dirty:      make(map[string]bool),
+	}, nil
+}
+
+// Add учитывает квитанцию. Повторная квитанция участника сводку не меняет.
+func (a *Aggregator) Add(r Receipt) error {
+	if r.MessageID == "" || r.SenderID == "" || r.ReaderID == "" {
+		return fmt.Errorf("message, sender and reader are required")
+	}
+	if r.Status != StatusDelivered && r.Status != StatusRead {
+		return fmt.Errorf("unknown receipt status %q", r.Status)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := a.messages[r.MessageID]
+	if m == nil {
+		m = &message{groupID: r.GroupID, senderID: r.SenderID, delivered: make(map[string]bool), read: make(map[string]bool)}
+		a.messages[r.MessageID] = m
+	}
+	m.updatedAt = time.Now()
+
+	changed := !m.delivered[r.ReaderID]
+	m.delivered[r.ReaderID] = true
+	if r.Status == StatusRead && !m.read[r.ReaderID] {
+		m.read[r.ReaderID] = true
+		m.readers = append(m.readers, r.ReaderID)
+		changed = true
+	}
+	if changed {
+		a.dirty[r.MessageID] = true
+	}
+	return nil
+}
+
+// Flush возвращает пакеты сводок сообщений, изменившихся после прошлого вызова,
+// по одному на автора
+func (a *Aggregator) Flush() []*Batch {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bySender := make(map[string]*Batch)
+	var batches []*Batch
+	for id := range a.dirty {
+		m := a.messages[id]
+		if m == nil {
+			continue
+		}
+		b := bySender[m.senderID]
+		if b == nil {
+			b = &Batch{SenderID: m.senderID}
+			bySender[m.senderID] = b
+			batches = append(batches, b)
+		}
+		b.Summaries = append(b.Summaries, a.summary(id, m))
+	}
+	a.dirty = make(map[string]bool)
+
+	sort.Slice(batches, func(i, j int) bool { return batches[i].SenderID < batches[j].SenderID })
+	for _, b := range batches {
+		sort.Slice(b.Summaries, func(i, j int) bool { return b.Summaries[i].MessageID < b.Summaries[j].MessageID })
+	}
+	return batches
+}
+
+func (a *Aggregator) summary(id string, m *message) *Summary {
+	s := &Summary{MessageID: id, GroupID: m.groupID, Delivered: len(m.delivered), Read: len(m.read)}
+	switch a.detail {
+	case DetailSampled:
+		s.Readers = append([]string(nil), m.readers[:min(len(m.readers), a.sampleSize)]...)
+	case DetailFull:
+		s.Readers = append([]string(nil), m.readers...)
+	}
+	return s
+}
+
+// Forget удаляет сообщения без квитанций с момента before: поздние квитанции к ним
+// начнут новую сводку
+func (a *Aggregator) Forget(before time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, m := range a.messages {
+		if m.updatedAt.Before(before) && !a.dirty[id] {
+			delete(a.messages, id)
+		}
+	}
+}
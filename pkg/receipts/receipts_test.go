@@ -0,0 +1,71 @@
+package receipts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAggregatorBatches(t *testing.T) {
+	a, err := NewAggregator(DetailSampled, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		reader := fmt.Sprintf("u%d", i)
+		a.Add(Receipt{MessageID: "m1", GroupID: "g", SenderID: "alice", ReaderID: reader, Status: StatusDelivered})
+		if i < 10 {
+			a.Add(Receipt{MessageID: "m1", GroupID: "g", SenderID: "alice", ReaderID: reader, Status: StatusRead})
+		}
+	}
+	a.Add(Receipt{MessageID: "m2", GroupID: "g", SenderID: "bob", ReaderID: "u1", Status: StatusRead})
+
+	batches := a.Flush()
+	if len(batches) != 2 || batches[0].SenderID != "alice" || batches[1].SenderID != "bob" {
+		t.Fatalf("expected one batch per sender, got %+v", batches)
+	}
+	s := batches[0].Summaries[0]
+	if s.Delivered != 100 || s.Read != 10 || len(s.Readers) != 2 || s.Readers[0] != "u0" {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+	// Прочтение без квитанции о получении все равно считается получением
+	if s := batches[1].Summaries[0]; s.Delivered != 1 || s.Read != 1 {
+		t.Errorf("read must imply delivered: %+v", s)
+	}
+
+	// Повтор ничего не меняет, новое прочтение дает накопительную сводку
+	a.Add(Receipt{MessageID: "m1", GroupID: "g", SenderID: "alice", ReaderID: "u0", Status: StatusRead})
+	if batches := a.Flush(); len(batches) != 0 {
+		t.Errorf("duplicate receipt produced a batch: %+v", batches)
+	}
+	a.Add(Receipt{MessageID: "m1", GroupID: "g", SenderID: "alice", ReaderID: "u50", Status: StatusRead})
+	if batches := a.Flush(); len(batches) != 1 || batches[0].Summaries[0].Read != 11 {
+		t.Errorf("expected cumulative summary, got %+v", batches)
+	}
+
+	a.Forget(time.Now().Add(time.Second))
+	a.Add(Receipt{MessageID: "m1", GroupID: "g", SenderID: "alice", ReaderID: "u0", Status: StatusRead})
+	if s := a.Flush()[0].Summaries[0]; s.Read != 1 {
+		t.Errorf("forgotten message kept its state: %+v", s)
+	}
+}
+
+func TestAggregatorDetailLevels(t *testing.T) {
+	if _, err := NewAggregator("verbose", 1); err == nil {
+		t.Error("unknown detail level accepted")
+	}
+	for detail, want := range map[string]int{DetailCount: 0, DetailSampled: 1, DetailFull: 3} {
+		a, _ := NewAggregator(detail, 1)
+		for _, reader := range []string{"u1", "u2", "u3"} {
+			a.Add(Receipt{MessageID: "m", SenderID: "alice", ReaderID: reader, Status: StatusRead})
+		}
+		if got := len(a.Flush()[0].Summaries[0].Readers); got != want {
+			t.Errorf("%s: %d readers, want %d", detail, got, want)
+		}
+	}
+	a, _ := NewAggregator(DetailFull, 0)
+	if err := a.Add(Receipt{MessageID: "m", SenderID: "alice", ReaderID: "u1", Status: "seen"}); err == nil {
+		t.Error("unknown status accepted")
+	}
+}
@@ -0,0 +1,119 @@
+// Package recovery реализует восстановление аккаунта поручителями: пользователь заранее
+// назначает M контактов, и если он потерял устройство и не может получить SMS, доступ
+// восстанавливается после одобрения N из них. Каждое одобрение подписывается ключом
+// сервера, так что журнал одобрений можно проверить независимо. Восстановление
+// завершается не раньше чем через задержку после запроса: за это время владелец,
+// если устройство у него, успевает отменить чужой запрос.
+package recovery
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaxGuardians - сколько поручителей можно назначить
+const MaxGuardians = 10
+
+// Signer - ключ сервера, которым подписываются одобрения (timesync.Signer)
+type Signer interface {
+	SignData(data []byte) string
+	PublicKey() ed25519.PublicKey
+}
+
+// ValidateGuardians проверяет список поручителей пользователя и порог одобрений
+func ValidateGuardians(userID string, guardians []string, threshold int) error {
+	if len(guardians) == 0 || len(guardians) > MaxGuardians {
+		return fmt.Errorf("from 1 to %d guardians are required", MaxGuardians)
+	}
+	seen := make(map[string]bool, len(guardians))
+	for _, g := range guardians {
+		if g == "" || g == userID {
+			return fmt.Errorf("invalid guardian %q", g)
+		}
+		if seen[g] {
+			return fmt.Errorf("duplicate guardian %q", g)
+		}
+		seen[g] = true
+	}
+	if threshold < 1 || threshold > len(guardians) {
+		return fmt.Errorf("threshold must be from 1 to %d", len(guardians))
+	}
+	return nil
+}
+
+// Approval - подписанное сервером одобрение восстановления поручителем
+type Approval struct {
+	RecoveryID string `json:"recovery_id"`
+	UserID     string `json:"user_id"`
+	GuardianID string `json:"guardian_id"`
+	ApprovedAt int64  `json:"approved_at"` // Unix время в миллисекундах
+	Signature  string `json:"signature"`   // base64(ed25519(payload))
+	PublicKey  string `json:"public_key"`
+}
+
+// SignApproval подписывает одобрение запроса recoveryID восстановления аккаунта userID
+func SignApproval(signer Signer, recoveryID, userID, guardianID string, at time.Time) *Approval {
+	a := &Approval{
+		RecoveryID: recoveryID,
+		UserID:     userID,
+		GuardianID: guardianID,
+		ApprovedAt: at.UnixMilli(),
+		PublicKey:  base64.StdEncoding.EncodeToString(signer.PublicKey()),
+	}
+	a.Signature = signer.SignData(a.payload())
+	return a
+}
+
+// VerifyApproval проверяет подпись одобрения известным публичным ключом сервера
+func VerifyApproval(publicKey ed25519.PublicKey, a *Approval) error {
+	sig, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, a.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// payload - каноническое представление подписываемых данных
+func (a *Approval) payload() []byte {
+	data, _ := json.Marshal(struct {
+		RecoveryID string `json:"recovery_id"`
+		UserID     string `json:"user_id"`
+		GuardianID string `json:"guardian_id"`
+		ApprovedAt int64  `json:"approved_at"`
+	}{a.RecoveryID, a.UserID, a.GuardianID, a.ApprovedAt})
+	return data
+}
+
+// Counted возвращает число одобрений от текущих поручителей: одобрения тех, кого
+// владелец исключил из списка после запроса, не учитываются
+func Counted(approvals []*Approval, guardians []string) int {
+	current := make(map[string]bool, len(guardians))
+	for _, g := range guardians {
+		current[g] = true
+	}
+	n := 0
+	for _, a := range approvals {
+		if current[a.GuardianID] {
+			current[a.GuardianID] = false
+			n++
+		}
+	}
+	return n
+}
+
+// Ready сообщает, можно ли завершить восстановление, а если нельзя - почему
+func Ready(approved, threshold int, requestedAt, now time.Time, delay time.Duration) error {
+	if approved < threshold {
+		return fmt.Errorf("%d of %d approvals", approved, threshold)
+	}
+	if wait := requestedAt.Add(delay).Sub(now); wait > 0 {
+		return fmt.Errorf("recovery is possible in %s", wait.Round(time.Minute))
+	}
+	return nil
+}
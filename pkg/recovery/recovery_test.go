@@ -0,0 +1,65 @@
+package recovery
+
+import (
+	"hydra/pkg/timesync"
+	"testing"
+	"time"
+)
+
+func TestApprovalSignature(t *testing.T) {
+	signer, err := timesync.NewSigner(nil)
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	a := SignApproval(signer, "rec-1", "alice", "bob", time.Now())
+	if err := VerifyApproval(signer.PublicKey(), a); err != nil {
+		t.Fatalf("VerifyApproval failed: %v", err)
+	}
+
+	// Одобрение нельзя перенести на другой запрос или приписать другому поручителю
+	a.RecoveryID = "rec-2"
+	if err := VerifyApproval(signer.PublicKey(), a); err == nil {
+		t.Error("approval moved to another request verified")
+	}
+}
+
+func TestValidateGuardians(t *testing.T) {
+	if err := ValidateGuardians("alice", []string{"bob", "carol", "dave"}, 2); err != nil {
+		t.Errorf("valid guardians rejected: %v", err)
+	}
+	invalid := []struct {
+		guardians []string
+		threshold int
+	}{
+		{nil, 1},
+		{[]string{"bob"}, 2},
+		{[]string{"bob", "bob"}, 1},
+		{[]string{"alice", "bob"}, 1},
+		{[]string{"bob", "carol"}, 0},
+	}
+	for _, c := range invalid {
+		if err := ValidateGuardians("alice", c.guardians, c.threshold); err == nil {
+			t.Errorf("guardians %v with threshold %d accepted", c.guardians, c.threshold)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	approvals := []*Approval{{GuardianID: "bob"}, {GuardianID: "carol"}, {GuardianID: "mallory"}, {GuardianID: "bob"}}
+	approved := Counted(approvals, []string{"bob", "carol", "dave"})
+	if approved != 2 {
+		t.Fatalf("expected 2 counted approvals, got %d", approved)
+	}
+
+	requested := time.Now().Add(-time.Hour)
+	if err := Ready(approved, 3, requested, time.Now(), 0); err == nil {
+		t.Error("recovery ready below threshold")
+	}
+	if err := Ready(approved, 2, requested, time.Now(), 24*time.Hour); err == nil {
+		t.Error("recovery ready before the delay")
+	}
+	if err := Ready(approved, 2, requested, time.Now(), time.Hour/2); err != nil {
+		t.Errorf("recovery not ready: %v", err)
+	}
+}
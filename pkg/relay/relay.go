@@ -0,0 +1,135 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Relay - скрытый сервис, до которого клиенты добираются через фронт CDN.
+// Принимает сообщения (POST с Host скрытого домена) в общую очередь и отдает их
+// длинным опросом (GET /poll?cursor=&wait=) всем клиентам: каждый читает очередь
+// со своего курсора, поэтому после переподключения прием продолжается без потерь.
+// Очередь хранится в памяти процесса.
+type Relay struct {
+	// HiddenDomain - Host, по которому CDN направляет запросы к сервису
+	HiddenDomain string
+
+	// MaxWait - наибольшее время удержания запроса опроса без новых сообщений
+	MaxWait time.Duration
+
+	mu       sync.Mutex
+	messages []Message
+	notify   chan struct{}
+}
+
+// Message - сообщение в очереди; Cursor - его позиция (используется и как ID доставки)
+type Message struct {
+	Cursor string `json:"cursor"`
+	Data   []byte `json:"data"`
+}
+
+// DefaultMaxWait - удержание опроса, если клиент не указал wait
+const DefaultMaxWait = 25 * time.Second
+
+func New(hiddenDomain string) *Relay {
+	return &Relay{
+		HiddenDomain: hiddenDomain,
+		MaxWait:      60 * time.Second,
+		notify:       make(chan struct{}),
+	}
+}
+
+// Push добавляет сообщение в очередь и возвращает его курсор
+func (r *Relay) Push(data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cursor := strconv.Itoa(len(r.messages) + 1)
+	r.messages = append(r.messages, Message{Cursor: cursor, Data: data})
+	close(r.notify)
+	r.notify = make(chan struct{})
+	return cursor
+}
+
+// After возвращает сообщения после курсора и канал, закрываемый при поступлении новых.
+// Неизвестный курсор означает чтение очереди с начала.
+func (r *Relay) After(cursor string) ([]Message, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pos, _ := strconv.Atoi(cursor)
+	if pos < 0 || pos > len(r.messages) {
+		pos = 0
+	}
+	return r.messages[pos:len(r.messages):len(r.messages)], r.notify
+}
+
+// ServeHTTP обрабатывает запросы, пришедшие через фронт. Запрос с чужим Host
+// отклоняется с 403, как это делает CDN для неизвестного домена.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.HiddenDomain != "" && req.Host != r.HiddenDomain {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case req.URL.Path == "/poll" && req.Method == http.MethodGet:
+		r.handlePoll(w, req)
+	case req.Method == http.MethodPost:
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, "Failed to read message", http.StatusBadRequest)
+			return
+		}
+		response := map[string]interface{}{"status": "success"}
+		if len(body) > 0 {
+			response["delivery_id"] = r.Push(body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case req.Method == http.MethodHead:
+		// Проверка доступности фронта
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePoll отдает сообщения после курсора клиента, удерживая запрос до появления новых
+func (r *Relay) handlePoll(w http.ResponseWriter, req *http.Request) {
+	cursor := req.URL.Query().Get("cursor")
+	wait := DefaultMaxWait
+	if seconds, err := strconv.Atoi(req.URL.Query().Get("wait")); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > r.MaxWait {
+		wait = r.MaxWait
+	}
+
+	messages, notify := r.After(cursor)
+	if len(messages) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-notify:
+			messages, _ = r.After(cursor)
+		case <-timer.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if len(messages) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"cursor":   messages[len(messages)-1].Cursor,
+	})
+}
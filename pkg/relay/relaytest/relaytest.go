@@ -0,0 +1,106 @@
+// Package relaytest запускает скрытый сервис-ретранслятор в процессе теста, чтобы
+// проверять настоящий протокол клиента fronting (отправку, длинный опрос, курсоры
+// очереди) без доступа к сети.
+//
+//	srv := relaytest.NewServer()
+//	defer srv.Close()
+//	tr := srv.Transport()
+//	tr.Exchange(ctx, []byte("hello"))
+//	messages, _ := tr.Poll(ctx)
+package relaytest
+
+import (
+	"crypto/x509"
+	"hydra/pkg/relay"
+	"hydra/pkg/transport/fronting"
+	"hydra/pkg/transport/manager"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Домены по умолчанию. Сертификат httptest выдан на example.com, поэтому клиент
+// проверяет его по фронт-домену, как проверял бы сертификат CDN.
+const (
+	FrontDomain  = "example.com"
+	HiddenDomain = "hidden.example.com"
+)
+
+// Server - ретранслятор на локальном TLS адресе, изображающий узел CDN
+type Server struct {
+	*relay.Relay
+
+	// Addr - адрес узла (127.0.0.1:порт), на который клиенты соединяются вместо DNS
+	Addr string
+
+	httpServer *httptest.Server
+
+	mu      sync.Mutex
+	blocked bool
+}
+
+// NewServer запускает ретранслятор. Опрос удерживается не дольше секунды, чтобы
+// тесты не ждали полный PollWait.
+func NewServer() *Server {
+	s := &Server{Relay: relay.New(HiddenDomain)}
+	s.MaxWait = time.Second
+	s.httpServer = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.httpServer.EnableHTTP2 = true
+	s.httpServer.StartTLS()
+	s.Addr = strings.TrimPrefix(s.httpServer.URL, "https://")
+	return s
+}
+
+// Close останавливает ретранслятор и закрывает соединения клиентов
+func (s *Server) Close() {
+	s.httpServer.CloseClientConnections()
+	s.httpServer.Close()
+}
+
+// SetBlocked имитирует блокировку фронта: CDN отвечает 403 на все запросы
+func (s *Server) SetBlocked(blocked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked = blocked
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	blocked := s.blocked
+	s.mu.Unlock()
+	if blocked {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	s.Relay.ServeHTTP(w, r)
+}
+
+// Options возвращает настройки клиента fronting для соединения с ретранслятором:
+// адрес узла, доверие его сертификату и отключенный ECH (без DNS запросов)
+func (s *Server) Options() []fronting.Option {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.httpServer.Certificate())
+	return []fronting.Option{
+		fronting.WithAddress(s.Addr),
+		fronting.WithRootCAs(roots),
+		fronting.WithoutECH(),
+	}
+}
+
+// Transport создает клиент fronting, подключенный к ретранслятору
+func (s *Server) Transport(opts ...fronting.Option) *fronting.Transport {
+	return fronting.New(FrontDomain, HiddenDomain, append(s.Options(), opts...)...)
+}
+
+// Pool создает пул из одного фронта, ведущего к ретранслятору
+func (s *Server) Pool(opts ...fronting.Option) *fronting.Pool {
+	return fronting.NewPool(HiddenDomain, []string{FrontDomain}, append(s.Options(), opts...)...)
+}
+
+// Manager создает менеджер транспортов, отправляющий через ретранслятор
+// (например, для server.New в сквозных тестах)
+func (s *Server) Manager(opts ...fronting.Option) *manager.TransportManager {
+	return manager.New(s.Pool(opts...))
+}
@@ -0,0 +1,102 @@
+package relaytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/transport/fronting"
+	"testing"
+	"time"
+)
+
+func TestExchangeAndPoll(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	sender := srv.Transport()
+	reply, err := sender.Exchange(context.Background(), []byte("hello"))
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	var delivery struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := json.Unmarshal(reply, &delivery); err != nil || delivery.DeliveryID != "1" {
+		t.Fatalf("unexpected reply: %s", reply)
+	}
+	if mode := sender.Mode(); mode != fronting.ModeClassic {
+		t.Errorf("expected classic fronting, got %s", mode)
+	}
+
+	receiver := srv.Transport()
+	messages, err := receiver.Poll(context.Background())
+	if err != nil || len(messages) != 1 || string(messages[0].Data) != "hello" {
+		t.Fatalf("Poll: %+v, %v", messages, err)
+	}
+
+	// Пустая очередь: опрос удерживается и возвращается без сообщений
+	start := time.Now()
+	if messages, err := receiver.Poll(context.Background()); err != nil || len(messages) != 0 {
+		t.Fatalf("expected empty poll, got %+v, %v", messages, err)
+	}
+	if time.Since(start) < srv.MaxWait/2 {
+		t.Error("poll returned without waiting")
+	}
+}
+
+func TestReceiveLoopResumesFromCursor(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	received := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	receiver := srv.Transport()
+	receiver.StartReceiving(ctx, func(data []byte) { received <- string(data) })
+
+	srv.Push([]byte("first"))
+	if got := waitMessage(t, received); got != "first" {
+		t.Fatalf("expected first, got %s", got)
+	}
+	cancel()
+
+	// Клиент перезапускается с сохраненным курсором и получает только новое
+	srv.Push([]byte("second"))
+	restarted := srv.Transport()
+	restarted.SetCursor(receiver.Cursor())
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	restarted.StartReceiving(ctx, func(data []byte) { received <- string(data) })
+	if got := waitMessage(t, received); got != "second" {
+		t.Fatalf("expected second, got %s", got)
+	}
+}
+
+func TestBlockedFront(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.SetBlocked(true)
+	tr := srv.Transport()
+	if _, err := tr.Exchange(context.Background(), []byte("x")); !errors.Is(err, fronting.ErrBlocked) {
+		t.Fatalf("expected ErrBlocked, got %v", err)
+	}
+
+	srv.SetBlocked(false)
+	if err := srv.Manager().Send(context.Background(), []byte("via manager")); err != nil {
+		t.Fatalf("Send via manager failed: %v", err)
+	}
+	if messages, _ := srv.After(""); len(messages) != 1 || string(messages[0].Data) != "via manager" {
+		t.Errorf("unexpected queue: %+v", messages)
+	}
+}
+
+func waitMessage(t *testing.T, received <-chan string) string {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+		return ""
+	}
+}
@@ -0,0 +1,258 @@
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Шифрование отдельных столбцов базы (тексты сообщений, контакты), чтобы изъятая база
+// не раскрывала содержимое. Значение хранится строкой
+//
+//	$enc$<ID ключа>$<base64(nonce | AES-256-GCM(данные))>
+//
+// Значения без префикса считаются открытыми: это строки, записанные до включения
+// шифрования, они читаются как есть и шифруются при ротации.
+//
+// Тексты шифруются со случайным nonce. Контакты (email, телефон) шифруются
+// детерминированно: nonce выводится из HMAC открытого значения, поэтому одинаковые
+// значения дают одинаковый шифротекст и поиск по равенству и ограничения уникальности
+// продолжают работать. Раскрывается только факт совпадения значений.
+//
+// Ротация: новый ключ добавляется первым в список и шифрует все новые записи, старые
+// ключи остаются для чтения, пока все строки не перешифрованы (hydra rotate-keys).
+
+const prefix = "$enc$"
+
+// Параметры Argon2id для ключей из парольной фразы
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 << 10 // КиБ
+	kdfThreads = 4
+)
+
+var (
+	// ErrUnknownKey - значение зашифровано ключом, которого нет в наборе
+	ErrUnknownKey = errors.New("value sealed with unknown key")
+	// ErrCorrupted - шифротекст поврежден или подделан
+	ErrCorrupted = errors.New("sealed value is corrupted")
+)
+
+type key struct {
+	aead cipher.AEAD
+	mac  []byte // ключ HMAC для детерминированного nonce
+}
+
+// Keyring - набор ключей шифрования с текущим ключом для новых записей.
+// Методы nil Keyring возвращают значения без изменений (шифрование выключено).
+type Keyring struct {
+	current uint32
+	keys    map[uint32]*key
+}
+
+// NewKeyring создает набор из мастер-секретов по ID ключа; current шифрует новые записи.
+// Ключи AES и HMAC выводятся из секрета через HKDF-SHA256.
+func NewKeyring(current uint32, secrets map[uint32][]byte) (*Keyring, error) {
+	if _, ok := secrets[current]; !ok {
+		return nil, fmt.Errorf("current key %d is not in the keyring", current)
+	}
+	k := &Keyring{current: current, keys: make(map[uint32]*key, len(secrets))}
+	for id, secret := range secrets {
+		if len(secret) < 16 {
+			return nil, fmt.Errorf("key %d: secret is too short", id)
+		}
+		encKey, err := hkdf.Key(sha256.New, secret, nil, "hydra/at-rest/aes", 32)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		macKey, err := hkdf.Key(sha256.New, secret, nil, "hydra/at-rest/siv", 32)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		k.keys[id] = &key{aead: aead, mac: macKey}
+	}
+	return k, nil
+}
+
+// ParseKeys разбирает список "ID:секрет" через запятую; первый ключ - текущий.
+// Секрет - base64 случайных байт (не меньше 32) или парольная фраза: из нее ключ
+// выводится Argon2id с солью salt. Пустой список - шифрование выключено (nil).
+func ParseKeys(spec string, salt []byte) (*Keyring, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var current uint32
+	secrets := make(map[uint32][]byte)
+	for i, entry := range strings.Split(spec, ",") {
+		idPart, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		id, err := strconv.ParseUint(idPart, 10, 32)
+		if !ok || err != nil || secret == "" {
+			return nil, fmt.Errorf("invalid key entry %d: want ID:secret", i+1)
+		}
+		if _, dup := secrets[uint32(id)]; dup {
+			return nil, fmt.Errorf("duplicate key ID %d", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil || len(raw) < 32 {
+			// Парольная фраза
+			if len(salt) == 0 {
+				return nil, fmt.Errorf("key %d is a passphrase and needs a salt", id)
+			}
+			raw = argon2.IDKey([]byte(secret), salt, kdfTime, kdfMemory, kdfThreads, 32)
+		}
+		secrets[uint32(id)] = raw
+		if i == 0 {
+			current = uint32(id)
+		}
+	}
+	return NewKeyring(current, secrets)
+}
+
+// Enabled сообщает, включено ли шифрование
+func (k *Keyring) Enabled() bool {
+	return k != nil
+}
+
+// Current возвращает ID ключа для новых записей
+func (k *Keyring) Current() uint32 {
+	if k == nil {
+		return 0
+	}
+	return k.current
+}
+
+// IDs возвращает ID всех ключей по возрастанию
+func (k *Keyring) IDs() []uint32 {
+	if k == nil {
+		return nil
+	}
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Seal шифрует значение текущим ключом со случайным nonce
+func (k *Keyring) Seal(plaintext string) string {
+	if k == nil || plaintext == "" {
+		return plaintext
+	}
+	key := k.keys[k.current]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("seal: failed to generate nonce: %v", err))
+	}
+	return k.format(k.current, key, nonce, plaintext)
+}
+
+// SealDeterministic шифрует значение текущим ключом так, что одинаковые значения дают
+// одинаковый шифротекст (для поиска по равенству)
+func (k *Keyring) SealDeterministic(plaintext string) string {
+	if k == nil || plaintext == "" {
+		return plaintext
+	}
+	return k.sealDeterministic(k.current, plaintext)
+}
+
+func (k *Keyring) sealDeterministic(id uint32, plaintext string) string {
+	key := k.keys[id]
+	mac := hmac.New(sha256.New, key.mac)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+	return k.format(id, key, nonce, plaintext)
+}
+
+func (k *Keyring) format(id uint32, key *key, nonce []byte, plaintext string) string {
+	data := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(strconv.FormatUint(uint64(id), 10)))
+	return prefix + strconv.FormatUint(uint64(id), 10) + "$" + base64.RawStdEncoding.EncodeToString(data)
+}
+
+// Candidates возвращает все значения, которыми может быть записано plaintext при
+// детерминированном шифровании: открытое (строки до включения шифрования) и шифротексты
+// каждым ключом набора (строки, еще не перешифрованные после ротации)
+func (k *Keyring) Candidates(plaintext string) []string {
+	candidates := []string{plaintext}
+	if plaintext == "" {
+		return candidates
+	}
+	for _, id := range k.IDs() {
+		candidates = append(candidates, k.sealDeterministic(id, plaintext))
+	}
+	return candidates
+}
+
+// Open расшифровывает значение; значение без префикса возвращается как есть
+func (k *Keyring) Open(value string) (string, error) {
+	id, sealed := keyID(value)
+	if !sealed {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	encoded := value[strings.LastIndex(value, "$")+1:]
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < key.aead.NonceSize() {
+		return "", ErrCorrupted
+	}
+	nonce, ciphertext := data[:key.aead.NonceSize()], data[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(strconv.FormatUint(uint64(id), 10)))
+	if err != nil {
+		return "", ErrCorrupted
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation сообщает, что значение нужно перешифровать текущим ключом: оно открытое
+// или зашифровано другим ключом
+func (k *Keyring) NeedsRotation(value string) bool {
+	if k == nil || value == "" {
+		return false
+	}
+	id, sealed := keyID(value)
+	return !sealed || id != k.current
+}
+
+// keyID возвращает ID ключа зашифрованного значения
+func keyID(value string) (uint32, bool) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return 0, false
+	}
+	idPart, _, ok := strings.Cut(rest, "$")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(idPart, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
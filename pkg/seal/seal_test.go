@@ -0,0 +1,123 @@
+package seal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	secret1 = "1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	secret2 = "2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	k, err := ParseKeys(secret1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := k.Seal("привет")
+	if !strings.HasPrefix(sealed, "$enc$1$") || strings.Contains(sealed, "привет") {
+		t.Fatalf("unexpected sealed value %q", sealed)
+	}
+	if sealed == k.Seal("привет") {
+		t.Error("Seal must use a random nonce")
+	}
+	if got, err := k.Open(sealed); err != nil || got != "привет" {
+		t.Fatalf("Open: %q, %v", got, err)
+	}
+
+	// Детерминированное шифрование дает одинаковый шифротекст
+	a, b := k.SealDeterministic("alice@example.com"), k.SealDeterministic("alice@example.com")
+	if a != b || a == k.SealDeterministic("bob@example.com") {
+		t.Error("SealDeterministic must depend only on the value")
+	}
+	if got, _ := k.Open(a); got != "alice@example.com" {
+		t.Errorf("Open deterministic: %q", got)
+	}
+
+	// Открытые и пустые значения не меняются
+	if got, err := k.Open("legacy"); err != nil || got != "legacy" {
+		t.Errorf("Open plaintext: %q, %v", got, err)
+	}
+	if k.Seal("") != "" {
+		t.Error("empty value must stay empty")
+	}
+
+	// Подмена шифротекста обнаруживается
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := k.Open(tampered); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Open tampered: %v", err)
+	}
+
+	// Без ключей шифрование выключено
+	var off *Keyring
+	if off.Seal("x") != "x" || off.Enabled() {
+		t.Error("nil keyring must pass values through")
+	}
+	if _, err := off.Open(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open without keys: %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, _ := ParseKeys(secret1, nil)
+	oldValue := old.Seal("тайна")
+	oldContact := old.SealDeterministic("+79990000000")
+
+	k, err := ParseKeys(secret2+","+secret1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Current() != 2 {
+		t.Fatalf("current key %d, want 2", k.Current())
+	}
+	if got, err := k.Open(oldValue); err != nil || got != "тайна" {
+		t.Fatalf("Open with previous key: %q, %v", got, err)
+	}
+	if !k.NeedsRotation(oldValue) || !k.NeedsRotation("plain") || k.NeedsRotation(k.Seal("x")) {
+		t.Error("NeedsRotation mismatch")
+	}
+
+	// Поиск контакта находит значения, записанные открыто и любым ключом
+	candidates := k.Candidates("+79990000000")
+	for _, want := range []string{"+79990000000", oldContact, k.SealDeterministic("+79990000000")} {
+		found := false
+		for _, c := range candidates {
+			found = found || c == want
+		}
+		if !found {
+			t.Errorf("Candidates miss %q", want)
+		}
+	}
+
+	// После удаления ключа его значения не читаются
+	next, _ := ParseKeys(secret2, nil)
+	if _, err := next.Open(oldValue); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with removed key: %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	if k, err := ParseKeys("", nil); err != nil || k.Enabled() {
+		t.Errorf("empty spec: %v, %v", k, err)
+	}
+	for _, spec := range []string{"secret", "x:secret", "1:", secret1 + "," + secret1} {
+		if _, err := ParseKeys(spec, []byte("salt")); err == nil {
+			t.Errorf("ParseKeys(%q) accepted", spec)
+		}
+	}
+	// Парольная фраза требует соли и дает одинаковый ключ при одинаковой соли
+	if _, err := ParseKeys("1:correct horse battery staple", nil); err == nil {
+		t.Error("passphrase without salt accepted")
+	}
+	a, err := ParseKeys("1:correct horse battery staple", []byte("hydra-salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ParseKeys("1:correct horse battery staple", []byte("hydra-salt"))
+	if got, err := b.Open(a.Seal("x")); err != nil || got != "x" {
+		t.Errorf("passphrase key is not stable: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,236 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Relay - ретранслятор сигнализации звонков: пересылает между клиентами SDP предложения,
+// ответы и ICE кандидатов. Состояние хранится только в памяти, база не нужна, поэтому
+// ретранслятор работает как внутри сервера сообщений, так и отдельным процессом
+// (hydra signaling), который можно масштабировать и размещать независимо.
+//
+//   - POST {prefix}send {"to": "...", "call_id": "...", "type": "offer", "payload": {...}}
+//   - GET  {prefix}poll?after=N&wait=25s - сообщения пользователя с номером больше N (long polling)
+//
+// Запросы авторизуются заголовком "Authorization: Bearer <токен>" (IssueToken).
+
+const (
+	// MessageTTL - сколько сообщение ждет получателя
+	MessageTTL = 2 * time.Minute
+	// MaxQueue - максимум ожидающих сообщений одного получателя; старые вытесняются
+	MaxQueue = 256
+	// maxPayload - максимальный размер тела запроса send
+	maxPayload = 64 << 10
+	// maxWait - максимальное ожидание при long polling
+	maxWait = 60 * time.Second
+)
+
+// Типы сообщений сигнализации
+var messageTypes = map[string]bool{
+	"offer":     true,
+	"answer":    true,
+	"candidate": true,
+	"hangup":    true,
+	"ring":      true,
+}
+
+// Message - сообщение сигнализации
+type Message struct {
+	ID        int64           `json:"id"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	CallID    string          `json:"call_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Relay - ретранслятор сигнализации с очередями сообщений получателей
+type Relay struct {
+	secret []byte
+
+	mu      sync.Mutex
+	nextID  int64
+	queues  map[string][]*Message
+	waiters map[string]chan struct{}
+}
+
+// NewRelay создает ретранслятор, проверяющий токены секретом secret
+func NewRelay(secret []byte) *Relay {
+	return &Relay{
+		secret:  secret,
+		queues:  make(map[string][]*Message),
+		waiters: make(map[string]chan struct{}),
+	}
+}
+
+// Handler возвращает HTTP обработчик ретранслятора для путей с префиксом prefix (например "/api/signal/")
+func (r *Relay) Handler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Отдельный ретранслятор обычно на другом домене, чем веб-клиент
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if req.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, err := VerifyToken(r.secret, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+
+		switch strings.TrimPrefix(req.URL.Path, prefix) {
+		case "send":
+			r.handleSend(w, req, userID)
+		case "poll":
+			r.handlePoll(w, req, userID)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Not found"})
+		}
+	})
+}
+
+func (r *Relay) handleSend(w http.ResponseWriter, req *http.Request, userID string) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	var msg Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxPayload)).Decode(&msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid JSON"})
+		return
+	}
+	if msg.To == "" || msg.CallID == "" || !messageTypes[msg.Type] {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "to, call_id and a known type are required"})
+		return
+	}
+
+	msg.From = userID
+	id := r.Send(&msg)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+}
+
+func (r *Relay) handlePoll(w http.ResponseWriter, req *http.Request, userID string) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Method not allowed"})
+		return
+	}
+
+	after, _ := strconv.ParseInt(req.URL.Query().Get("after"), 10, 64)
+	wait, _ := time.ParseDuration(req.URL.Query().Get("wait"))
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		// Канал берется до чтения очереди, чтобы не пропустить сообщение между чтением и ожиданием
+		wake := r.wait(userID)
+		messages := r.Receive(userID, after)
+		if len(messages) > 0 || wait <= 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "messages": messages})
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-deadline.C:
+			wait = 0
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// Send ставит сообщение в очередь получателя и возвращает его номер
+func (r *Relay) Send(msg *Message) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	msg.ID = r.nextID
+	msg.CreatedAt = time.Now()
+
+	queue := append(r.expireLocked(msg.To), msg)
+	if len(queue) > MaxQueue {
+		queue = queue[len(queue)-MaxQueue:]
+	}
+	r.queues[msg.To] = queue
+
+	// Очереди пользователей, которые больше не опрашивают ретранслятор, чистятся периодически
+	if msg.ID%MaxQueue == 0 {
+		for userID := range r.queues {
+			if queue := r.expireLocked(userID); len(queue) == 0 {
+				delete(r.queues, userID)
+			} else {
+				r.queues[userID] = queue
+			}
+		}
+	}
+
+	if ch, exists := r.waiters[msg.To]; exists {
+		close(ch)
+		delete(r.waiters, msg.To)
+	}
+	return msg.ID
+}
+
+// Receive возвращает сообщения пользователя с номером больше after. Сообщения с номером
+// не больше after считаются полученными и удаляются.
+func (r *Relay) Receive(userID string, after int64) []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pending []*Message
+	for _, msg := range r.expireLocked(userID) {
+		if msg.ID > after {
+			pending = append(pending, msg)
+		}
+	}
+	if len(pending) == 0 {
+		delete(r.queues, userID)
+	} else {
+		r.queues[userID] = pending
+	}
+	return append([]*Message{}, pending...)
+}
+
+// expireLocked возвращает очередь пользователя без сообщений старше MessageTTL
+func (r *Relay) expireLocked(userID string) []*Message {
+	queue := r.queues[userID]
+	cutoff := time.Now().Add(-MessageTTL)
+	for len(queue) > 0 && queue[0].CreatedAt.Before(cutoff) {
+		queue = queue[1:]
+	}
+	return queue
+}
+
+// wait возвращает канал, который закроется при следующем сообщении пользователю
+func (r *Relay) wait(userID string) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, exists := r.waiters[userID]
+	if !exists {
+		ch = make(chan struct{})
+		r.waiters[userID] = ch
+	}
+	return ch
+}
@@ -0,0 +1,97 @@
+package signaling
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	secret := []byte("shared-secret")
+	token := IssueToken(secret, "alice", time.Minute)
+
+	userID, err := VerifyToken(secret, token)
+	if err != nil || userID != "alice" {
+		t.Fatalf("VerifyToken = %q, %v", userID, err)
+	}
+	if _, err := VerifyToken([]byte("other-secret"), token); err != ErrInvalidToken {
+		t.Errorf("token with another secret: got %v", err)
+	}
+
+	// Подмена пользователя ломает подпись
+	parts := strings.SplitN(token, ".", 2)
+	forged := IssueToken(secret, "mallory", time.Minute)
+	if _, err := VerifyToken(secret, strings.SplitN(forged, ".", 2)[0]+"."+parts[1]); err != ErrInvalidToken {
+		t.Errorf("forged token: got %v", err)
+	}
+	if _, err := VerifyToken(secret, IssueToken(secret, "alice", -time.Second)); err != ErrExpiredToken {
+		t.Errorf("expired token: got %v", err)
+	}
+}
+
+// TestRelay проверяет пересылку предложения между пользователями через HTTP API
+func TestRelay(t *testing.T) {
+	secret := []byte("shared-secret")
+	server := httptest.NewServer(NewRelay(secret).Handler("/signal/"))
+	defer server.Close()
+
+	do := func(method, path, user string, body interface{}) (int, map[string]json.RawMessage) {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req, _ := http.NewRequest(method, server.URL+path, &buf)
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+IssueToken(secret, user, time.Minute))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := do(http.MethodGet, "/signal/poll", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("poll without token: status %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/signal/send", "alice", map[string]string{"to": "bob", "call_id": "c1", "type": "bogus"}); code != http.StatusBadRequest {
+		t.Errorf("unknown type: status %d", code)
+	}
+
+	// Боб ждет, Алиса отправляет предложение
+	done := make(chan []Message)
+	go func() {
+		_, out := do(http.MethodGet, "/signal/poll?wait=5s", "bob", nil)
+		var messages []Message
+		json.Unmarshal(out["messages"], &messages)
+		done <- messages
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	offer := map[string]interface{}{"to": "bob", "call_id": "c1", "type": "offer", "payload": map[string]string{"sdp": "v=0"}}
+	if code, _ := do(http.MethodPost, "/signal/send", "alice", offer); code != http.StatusOK {
+		t.Fatalf("send: status %d", code)
+	}
+
+	select {
+	case messages := <-done:
+		if len(messages) != 1 || messages[0].From != "alice" || messages[0].Type != "offer" {
+			t.Fatalf("unexpected messages: %+v", messages)
+		}
+		// Подтвержденные сообщения больше не возвращаются
+		_, out := do(http.MethodGet, "/signal/poll?after="+strconv.FormatInt(messages[0].ID, 10), "bob", nil)
+		var again []Message
+		json.Unmarshal(out["messages"], &again)
+		if len(again) != 0 {
+			t.Errorf("acknowledged messages returned again: %+v", again)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("long poll was not woken by send")
+	}
+}
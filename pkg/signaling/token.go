@@ -0,0 +1,67 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Токены доступа к сигнализации. Сервер сообщений выдает их пользователю при входе,
+// ретранслятор сигнализации проверяет общим секретом, не обращаясь к базе пользователей:
+// так ретранслятор можно запускать отдельно и в другом регионе.
+//
+// Формат: base64url(userID).expires_unix.hex(HMAC-SHA256(secret, "userID|expires"))
+
+var (
+	ErrInvalidToken = errors.New("invalid signaling token")
+	ErrExpiredToken = errors.New("signaling token expired")
+)
+
+// IssueToken выдает токен пользователя, действующий ttl
+func IssueToken(secret []byte, userID string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." + expires + "." + tokenMAC(secret, userID, expires)
+}
+
+// VerifyToken проверяет токен и возвращает ID пользователя
+func VerifyToken(secret []byte, token string) (string, error) {
+	userID, _, err := VerifyTokenExpiry(secret, token)
+	return userID, err
+}
+
+// VerifyTokenExpiry проверяет токен и возвращает ID пользователя и время истечения токена
+func VerifyTokenExpiry(secret []byte, token string) (string, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(user) == 0 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	userID := string(user)
+
+	if !hmac.Equal([]byte(parts[2]), []byte(tokenMAC(secret, userID, parts[1]))) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= expires {
+		return "", time.Time{}, ErrExpiredToken
+	}
+	return userID, time.Unix(expires, 0), nil
+}
+
+func tokenMAC(secret []byte, userID, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s|%s", userID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
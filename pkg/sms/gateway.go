@@ -0,0 +1,73 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Gateway отправляет SMS с Android-телефона через приложение-шлюз с API SMS Gateway for
+// Android (android-sms-gateway): локально по адресу телефона в сети или через облачный
+// сервер приложения. Отчеты о доставке телефон присылает webhook'ами sms:sent,
+// sms:delivered и sms:failed, зарегистрированными в приложении на адрес сервера.
+type Gateway struct {
+	URL      string // например http://192.168.1.10:8080 или https://api.sms-gate.app/3rdparty/v1
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+// Send отправляет сообщение
+func (g *Gateway) Send(ctx context.Context, to, text string) (string, error) {
+	if g.URL == "" {
+		return "", fmt.Errorf("SMS_API_URL is not configured")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message":            text,
+		"phoneNumbers":       []string{to},
+		"withDeliveryReport": true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SMS payload: %w", err)
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	auth := func(req *http.Request) { req.SetBasicAuth(g.User, g.Password) }
+	if err := postJSON(ctx, httpClient(g.Client), strings.TrimRight(g.URL, "/")+"/message", body, auth, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// ParseCallback разбирает webhook шлюза; события, не связанные с доставкой, пропускаются
+func (g *Gateway) ParseCallback(r *http.Request) ([]Report, error) {
+	var event struct {
+		Event   string `json:"event"`
+		Payload struct {
+			MessageID string `json:"messageId"`
+			Reason    string `json:"reason"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to parse gateway callback: %w", err)
+	}
+	var status string
+	switch event.Event {
+	case "sms:sent":
+		status = StatusSent
+	case "sms:delivered":
+		status = StatusDelivered
+	case "sms:failed":
+		status = StatusFailed
+	default:
+		return nil, nil
+	}
+	if event.Payload.MessageID == "" {
+		return nil, errors.New("gateway callback has no messageId")
+	}
+	return []Report{{MessageID: event.Payload.MessageID, Status: status, Error: event.Payload.Reason}}, nil
+}
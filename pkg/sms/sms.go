@@ -0,0 +1,120 @@
+// Package sms отправляет SMS через службы рассылки (Twilio, Vonage, smsc.ru), через
+// Android-телефон со шлюзом SMS или через произвольный HTTP API и разбирает отчеты
+// служб о доставке.
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Состояния доставки SMS
+const (
+	StatusQueued    = "queued"    // принято службой, ждет отправки
+	StatusSent      = "sent"      // передано оператору
+	StatusDelivered = "delivered" // доставлено на телефон
+	StatusFailed    = "failed"    // не доставлено
+)
+
+// Final сообщает, окончательно ли состояние: после него отчеты о доставке не меняют запись
+func Final(status string) bool {
+	return status == StatusDelivered || status == StatusFailed
+}
+
+// Provider отправляет SMS
+type Provider interface {
+	// Send отправляет text на номер to (E.164) и возвращает идентификатор сообщения у
+	// службы; пусто - служба не сообщает о доставке
+	Send(ctx context.Context, to, text string) (string, error)
+}
+
+// Callbacks - служба, присылающая отчеты о доставке на адрес сервера
+type Callbacks interface {
+	// ParseCallback проверяет и разбирает запрос службы с отчетами о доставке
+	ParseCallback(r *http.Request) ([]Report, error)
+}
+
+// Report - отчет службы о доставке сообщения
+type Report struct {
+	MessageID string
+	Status    string
+	Error     string // код или описание ошибки доставки
+}
+
+// Console выводит SMS в журнал вместо отправки (разработка)
+type Console struct{}
+
+// Send записывает сообщение в журнал
+func (Console) Send(ctx context.Context, to, text string) (string, error) {
+	log.Printf("[SMS-CONSOLE] To: %s | Message: %s", to, text)
+	return "", nil
+}
+
+// HTTP отправляет SMS запросом POST {"to", "message", "key"} на URL; ответ может
+// содержать {"id"} - идентификатор сообщения
+type HTTP struct {
+	URL    string
+	Key    string
+	Client *http.Client
+}
+
+// Send отправляет сообщение
+func (h *HTTP) Send(ctx context.Context, to, text string) (string, error) {
+	if h.URL == "" {
+		return "", fmt.Errorf("SMS_API_URL is not configured")
+	}
+	body, err := json.Marshal(map[string]string{"to": to, "message": text, "key": h.Key})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SMS payload: %w", err)
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := postJSON(ctx, httpClient(h.Client), h.URL, body, nil, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// postJSON отправляет JSON и разбирает JSON ответа в result; тело ответа, которое не
+// удалось разобрать, не считается ошибкой
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, auth func(*http.Request), result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SMS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != nil {
+		auth(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return apiError("SMS", resp)
+	}
+	json.NewDecoder(resp.Body).Decode(result)
+	return nil
+}
+
+// apiError возвращает ошибку по неуспешному ответу API службы
+func apiError(service string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s API returned status %d: %s", service, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return c
+}
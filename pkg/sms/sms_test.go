@@ -0,0 +1,189 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// capture запускает тестовый сервер, запоминающий последний запрос и отвечающий response
+func capture(t *testing.T, response string) (*httptest.Server, *http.Request, *string) {
+	t.Helper()
+	var req http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		req, body = *r, string(b)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &req, &body
+}
+
+func TestProviders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("twilio", func(t *testing.T) {
+		srv, req, body := capture(t, `{"sid":"SM123"}`)
+		p := &Twilio{AccountSID: "AC1", AuthToken: "secret", From: "MG99", CallbackURL: "https://hydra.example/cb", Endpoint: srv.URL}
+		id, err := p.Send(ctx, "+79990001122", "hello")
+		if err != nil || id != "SM123" {
+			t.Fatalf("Send = %q, %v", id, err)
+		}
+		if req.URL.Path != "/2010-04-01/Accounts/AC1/Messages.json" {
+			t.Errorf("path = %s", req.URL.Path)
+		}
+		if user, pass, _ := req.BasicAuth(); user != "AC1" || pass != "secret" {
+			t.Errorf("basic auth = %s:%s", user, pass)
+		}
+		form, _ := url.ParseQuery(*body)
+		if form.Get("MessagingServiceSid") != "MG99" || form.Get("From") != "" || form.Get("StatusCallback") != "https://hydra.example/cb" {
+			t.Errorf("form = %v", form)
+		}
+	})
+
+	t.Run("vonage", func(t *testing.T) {
+		srv, _, body := capture(t, `{"messages":[{"status":"0","message-id":"V1"}]}`)
+		p := &Vonage{APIKey: "k", APISecret: "s", From: "Hydra", Endpoint: srv.URL}
+		id, err := p.Send(ctx, "+79990001122", "hello")
+		if err != nil || id != "V1" {
+			t.Fatalf("Send = %q, %v", id, err)
+		}
+		if form, _ := url.ParseQuery(*body); form.Get("to") != "79990001122" {
+			t.Errorf("to = %q", form.Get("to"))
+		}
+
+		srv, _, _ = capture(t, `{"messages":[{"status":"2","error-text":"Missing to"}]}`)
+		p.Endpoint = srv.URL
+		if _, err := p.Send(ctx, "+79990001122", "hello"); err == nil {
+			t.Error("rejected message must fail")
+		}
+	})
+
+	t.Run("smsc", func(t *testing.T) {
+		srv, req, body := capture(t, `{"id":42,"cnt":1}`)
+		p := &SMSC{Login: "l", Password: "p", Endpoint: srv.URL}
+		id, err := p.Send(ctx, "+79990001122", "hello")
+		if err != nil || id != "42" {
+			t.Fatalf("Send = %q, %v", id, err)
+		}
+		if req.URL.Path != "/sys/send.php" {
+			t.Errorf("path = %s", req.URL.Path)
+		}
+		if form, _ := url.ParseQuery(*body); form.Get("fmt") != "3" || form.Get("phones") != "+79990001122" {
+			t.Errorf("form = %v", form)
+		}
+
+		srv, _, _ = capture(t, `{"error":"authorise error","error_code":2}`)
+		p.Endpoint = srv.URL
+		if _, err := p.Send(ctx, "+79990001122", "hello"); err == nil {
+			t.Error("SMSC error must fail")
+		}
+	})
+
+	t.Run("gateway", func(t *testing.T) {
+		srv, req, body := capture(t, `{"id":"G1","state":"Pending"}`)
+		p := &Gateway{URL: srv.URL + "/", User: "u", Password: "p"}
+		id, err := p.Send(ctx, "+79990001122", "hello")
+		if err != nil || id != "G1" {
+			t.Fatalf("Send = %q, %v", id, err)
+		}
+		if req.URL.Path != "/message" {
+			t.Errorf("path = %s", req.URL.Path)
+		}
+		var payload struct {
+			Message      string   `json:"message"`
+			PhoneNumbers []string `json:"phoneNumbers"`
+		}
+		json.Unmarshal([]byte(*body), &payload)
+		if payload.Message != "hello" || len(payload.PhoneNumbers) != 1 {
+			t.Errorf("payload = %s", *body)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, "upstream down")
+		}))
+		defer srv.Close()
+		_, err := (&HTTP{URL: srv.URL}).Send(ctx, "+79990001122", "hello")
+		if err == nil || !strings.Contains(err.Error(), "502") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestCallbacks(t *testing.T) {
+	form := func(values url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/cb", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	t.Run("twilio", func(t *testing.T) {
+		p := &Twilio{AuthToken: "secret", CallbackURL: "https://hydra.example/cb"}
+		values := url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}}
+
+		r := form(values)
+		r.Header.Set("X-Twilio-Signature", p.signature(p.CallbackURL, values))
+		reports, err := p.ParseCallback(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 || reports[0] != (Report{MessageID: "SM1", Status: StatusFailed, Error: "30003"}) {
+			t.Errorf("reports = %+v", reports)
+		}
+
+		r = form(values)
+		r.Header.Set("X-Twilio-Signature", "forged")
+		if _, err := p.ParseCallback(r); err == nil {
+			t.Error("forged signature must be rejected")
+		}
+	})
+
+	t.Run("vonage", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/cb?messageId=V1&status=delivered&err-code=0", nil)
+		reports, err := (&Vonage{}).ParseCallback(r)
+		if err != nil || len(reports) != 1 || reports[0] != (Report{MessageID: "V1", Status: StatusDelivered}) {
+			t.Errorf("reports = %+v, %v", reports, err)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/cb", strings.NewReader(`{"messageId":"V2","status":"rejected","err-code":"6"}`))
+		r.Header.Set("Content-Type", "application/json")
+		reports, err = (&Vonage{}).ParseCallback(r)
+		if err != nil || len(reports) != 1 || reports[0] != (Report{MessageID: "V2", Status: StatusFailed, Error: "6"}) {
+			t.Errorf("reports = %+v, %v", reports, err)
+		}
+	})
+
+	t.Run("smsc", func(t *testing.T) {
+		reports, err := (&SMSC{}).ParseCallback(form(url.Values{"id": {"42"}, "status": {"1"}}))
+		if err != nil || len(reports) != 1 || reports[0] != (Report{MessageID: "42", Status: StatusDelivered}) {
+			t.Errorf("reports = %+v, %v", reports, err)
+		}
+		reports, err = (&SMSC{}).ParseCallback(form(url.Values{"id": {"43"}, "status": {"20"}, "err": {"1"}}))
+		if err != nil || len(reports) != 1 || reports[0].Status != StatusFailed || reports[0].Error == "" {
+			t.Errorf("reports = %+v, %v", reports, err)
+		}
+	})
+
+	t.Run("gateway", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/cb", strings.NewReader(`{"event":"sms:failed","payload":{"messageId":"G1","reason":"no signal"}}`))
+		reports, err := (&Gateway{}).ParseCallback(r)
+		if err != nil || len(reports) != 1 || reports[0] != (Report{MessageID: "G1", Status: StatusFailed, Error: "no signal"}) {
+			t.Errorf("reports = %+v, %v", reports, err)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/cb", strings.NewReader(`{"event":"sms:received","payload":{}}`))
+		if reports, err := (&Gateway{}).ParseCallback(r); err != nil || len(reports) != 0 {
+			t.Errorf("unrelated event: %+v, %v", reports, err)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SMSC отправляет SMS через smsc.ru. Адрес отчетов о доставке задается в личном кабинете
+// smsc.ru (настройки - обработчик статусов), отчеты приходят формой POST.
+type SMSC struct {
+	Login    string
+	Password string
+	Sender   string // имя отправителя; пусто - имя по умолчанию из кабинета
+	Endpoint string // https://smsc.ru
+	Client   *http.Client
+}
+
+// Send отправляет сообщение
+func (c *SMSC) Send(ctx context.Context, to, text string) (string, error) {
+	form := url.Values{
+		"login":   {c.Login},
+		"psw":     {c.Password},
+		"phones":  {to},
+		"mes":     {text},
+		"charset": {"utf-8"},
+		"fmt":     {"3"}, // ответ в JSON
+	}
+	if c.Sender != "" {
+		form.Set("sender", c.Sender)
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://smsc.ru"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/sys/send.php", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create SMSC request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(c.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMSC request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", apiError("SMSC", resp)
+	}
+	var result struct {
+		ID        int64  `json:"id"`
+		Error     string `json:"error"`
+		ErrorCode int    `json:"error_code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode SMSC response: %w", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("SMSC rejected SMS: error %d: %s", result.ErrorCode, result.Error)
+	}
+	return strconv.FormatInt(result.ID, 10), nil
+}
+
+// ParseCallback разбирает отчет о доставке
+func (c *SMSC) ParseCallback(r *http.Request) ([]Report, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse SMSC callback: %w", err)
+	}
+	id := r.Form.Get("id")
+	if id == "" {
+		return nil, errors.New("SMSC callback has no id")
+	}
+	code, err := strconv.Atoi(r.Form.Get("status"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMSC status: %q", r.Form.Get("status"))
+	}
+	report := Report{MessageID: id, Status: smscStatus(code)}
+	if report.Status == StatusFailed {
+		report.Error = "status " + strconv.Itoa(code)
+		if e := r.Form.Get("err"); e != "" && e != "0" {
+			report.Error += ", error " + e
+		}
+	}
+	return []Report{report}, nil
+}
+
+// smscStatus переводит код состояния smsc.ru: -1 ожидает отправки, 0 передано оператору,
+// 1 доставлено, 2 прочитано, 4 переход по ссылке; 3 и 20-25 - просрочено или не доставлено
+func smscStatus(code int) string {
+	switch code {
+	case -1:
+		return StatusQueued
+	case 0:
+		return StatusSent
+	case 1, 2, 4:
+		return StatusDelivered
+	}
+	return StatusFailed
+}
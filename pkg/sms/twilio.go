@@ -0,0 +1,114 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Twilio отправляет SMS через Twilio Programmable Messaging. Отчеты о доставке Twilio
+// присылает на CallbackURL, подписывая их Auth Token (заголовок X-Twilio-Signature).
+type Twilio struct {
+	AccountSID  string
+	AuthToken   string
+	From        string // номер отправителя или Messaging Service SID (MG...)
+	CallbackURL string // адрес отчетов о доставке; пусто - отчеты не запрашиваются
+	Endpoint    string // https://api.twilio.com
+	Client      *http.Client
+}
+
+// Send отправляет сообщение
+func (t *Twilio) Send(ctx context.Context, to, text string) (string, error) {
+	form := url.Values{"To": {to}, "Body": {text}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+	if t.CallbackURL != "" {
+		form.Set("StatusCallback", t.CallbackURL)
+	}
+
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.twilio.com"
+	}
+	target := strings.TrimRight(endpoint, "/") + "/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Twilio request: %w", err)
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(t.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send Twilio request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", apiError("Twilio", resp)
+	}
+	var result struct {
+		SID string `json:"sid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Twilio response: %w", err)
+	}
+	return result.SID, nil
+}
+
+// ParseCallback проверяет подпись и разбирает отчет о доставке
+func (t *Twilio) ParseCallback(r *http.Request) ([]Report, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse Twilio callback: %w", err)
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Twilio-Signature")), []byte(t.signature(t.CallbackURL, r.PostForm))) {
+		return nil, errors.New("invalid Twilio signature")
+	}
+	id := r.PostForm.Get("MessageSid")
+	if id == "" {
+		return nil, errors.New("Twilio callback has no MessageSid")
+	}
+	return []Report{{MessageID: id, Status: twilioStatus(r.PostForm.Get("MessageStatus")), Error: r.PostForm.Get("ErrorCode")}}, nil
+}
+
+// signature - подпись запроса Twilio: base64(HMAC-SHA1(AuthToken, URL + параметры формы,
+// отсортированные по имени, в виде имязначение))
+func (t *Twilio) signature(callbackURL string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(callbackURL)
+	for _, k := range keys {
+		for _, v := range form[k] {
+			b.WriteString(k + v)
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(t.AuthToken))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func twilioStatus(status string) string {
+	switch status {
+	case "sent":
+		return StatusSent
+	case "delivered", "read":
+		return StatusDelivered
+	case "undelivered", "failed", "canceled":
+		return StatusFailed
+	}
+	return StatusQueued
+}
@@ -0,0 +1,112 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Vonage отправляет SMS через Vonage (Nexmo) SMS API. Отчеты о доставке (delivery
+// receipts) приходят на CallbackURL запросом GET или POST - формой или JSON.
+type Vonage struct {
+	APIKey      string
+	APISecret   string
+	From        string // номер или буквенное имя отправителя
+	CallbackURL string // адрес отчетов о доставке; пусто - адрес из настроек аккаунта
+	Endpoint    string // https://rest.nexmo.com
+	Client      *http.Client
+}
+
+// Send отправляет сообщение
+func (v *Vonage) Send(ctx context.Context, to, text string) (string, error) {
+	form := url.Values{
+		"api_key":    {v.APIKey},
+		"api_secret": {v.APISecret},
+		"from":       {v.From},
+		"to":         {strings.TrimPrefix(to, "+")},
+		"text":       {text},
+		"type":       {"unicode"},
+	}
+	if v.CallbackURL != "" {
+		form.Set("callback", v.CallbackURL)
+	}
+
+	endpoint := v.Endpoint
+	if endpoint == "" {
+		endpoint = "https://rest.nexmo.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Vonage request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(v.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send Vonage request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", apiError("Vonage", resp)
+	}
+	// Ошибка отправки приходит с кодом 200: status сообщения отличен от "0"
+	var result struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			MessageID string `json:"message-id"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Vonage response: %w", err)
+	}
+	if len(result.Messages) == 0 {
+		return "", errors.New("Vonage response has no messages")
+	}
+	if m := result.Messages[0]; m.Status != "0" {
+		return "", fmt.Errorf("Vonage rejected SMS: status %s: %s", m.Status, m.ErrorText)
+	}
+	return result.Messages[0].MessageID, nil
+}
+
+// ParseCallback разбирает отчет о доставке
+func (v *Vonage) ParseCallback(r *http.Request) ([]Report, error) {
+	var dlr struct {
+		MessageID string `json:"messageId"`
+		Status    string `json:"status"`
+		ErrCode   string `json:"err-code"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&dlr); err != nil {
+			return nil, fmt.Errorf("failed to parse Vonage callback: %w", err)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("failed to parse Vonage callback: %w", err)
+		}
+		dlr.MessageID, dlr.Status, dlr.ErrCode = r.Form.Get("messageId"), r.Form.Get("status"), r.Form.Get("err-code")
+	}
+	if dlr.MessageID == "" {
+		return nil, errors.New("Vonage callback has no messageId")
+	}
+	report := Report{MessageID: dlr.MessageID, Status: vonageStatus(dlr.Status)}
+	if dlr.ErrCode != "" && dlr.ErrCode != "0" {
+		report.Error = dlr.ErrCode
+	}
+	return []Report{report}, nil
+}
+
+func vonageStatus(status string) string {
+	switch status {
+	case "delivered":
+		return StatusDelivered
+	case "expired", "failed", "rejected":
+		return StatusFailed
+	}
+	// accepted, buffered, unknown
+	return StatusSent
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Режимы обработки входящих сообщений деактивированного аккаунта
+const (
+	InboundQueue  = "queue"  // сообщения копятся на сервере до реактивации
+	InboundBounce = "bounce" // отправитель получает отказ
+)
+
+// AccountState - состояние временно деактивированного аккаунта (режим отпуска).
+// Данные пользователя при этом не удаляются.
+type AccountState struct {
+	UserID        string    `json:"user_id"`
+	InboundMode   string    `json:"inbound_mode"`
+	DeactivatedAt time.Time `json:"deactivated_at"`
+}
+
+// QueuedMessage - входящее сообщение, ожидающее реактивации получателя
+type QueuedMessage struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	SenderID  string    `json:"sender_id,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeactivateUser временно деактивирует аккаунт. Повторный вызов меняет режим входящих.
+func (s *Storage) DeactivateUser(ctx context.Context, userID, inboundMode string) (*AccountState, error) {
+	if inboundMode != InboundQueue && inboundMode != InboundBounce {
+		return nil, fmt.Errorf("unknown inbound mode %q", inboundMode)
+	}
+
+	state := &AccountState{UserID: userID, InboundMode: inboundMode, DeactivatedAt: time.Now()}
+	query := `INSERT INTO account_states (user_id, inbound_mode, deactivated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET inbound_mode = EXCLUDED.inbound_mode
+		RETURNING deactivated_at`
+	if err := s.db.QueryRowContext(ctx, query, userID, inboundMode, state.DeactivatedAt).Scan(&state.DeactivatedAt); err != nil {
+		return nil, fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	return state, nil
+}
+
+// ReactivateUser снимает деактивацию и возвращает накопившиеся сообщения, удаляя их из очереди
+func (s *Storage) ReactivateUser(ctx context.Context, userID string) ([]*QueuedMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM account_states WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+
+	query := "DELETE FROM queued_messages WHERE user_id = $1 RETURNING id, user_id, sender_id, body, created_at"
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to drain message queue: %w", err)
+	}
+
+	var messages []*QueuedMessage
+	for rows.Next() {
+		msg := &QueuedMessage{}
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.SenderID, &msg.Body, &msg.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan queued message: %w", err)
+		}
+		if msg.Body, err = s.keys.Open(msg.Body); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to decrypt queued message %d: %w", msg.ID, err)
+		}
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to drain message queue: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+	// RETURNING не гарантирует порядок - возвращаем в порядке поступления
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// GetAccountState возвращает состояние деактивации; nil - аккаунт активен
+func (s *Storage) GetAccountState(ctx context.Context, userID string) (*AccountState, error) {
+	state := &AccountState{}
+	query := "SELECT user_id, inbound_mode, deactivated_at FROM account_states WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&state.UserID, &state.InboundMode, &state.DeactivatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account state: %w", err)
+	}
+	return state, nil
+}
+
+// QueueMessage сохраняет входящее сообщение деактивированного получателя
+func (s *Storage) QueueMessage(ctx context.Context, msg *QueuedMessage) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	query := "INSERT INTO queued_messages (user_id, sender_id, body, created_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	if err := s.db.QueryRowContext(ctx, query, msg.UserID, msg.SenderID, s.keys.Seal(msg.Body), msg.CreatedAt).Scan(&msg.ID); err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
+	}
+	return nil
+}
+
+// CountQueuedMessages возвращает число сообщений в очереди пользователя
+func (s *Storage) CountQueuedMessages(ctx context.Context, userID string) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM queued_messages WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count queued messages: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DisabledAccount - аккаунт, заблокированный администратором. Пока блокировка не снята,
+// вход и запросы с токенами пользователя отклоняются; данные аккаунта сохраняются.
+type DisabledAccount struct {
+	UserID     string    `json:"user_id"`
+	Reason     string    `json:"reason,omitempty"`
+	DisabledBy string    `json:"disabled_by"`
+	DisabledAt time.Time `json:"disabled_at"`
+}
+
+// UserSummary - пользователь в списке администратора
+type UserSummary struct {
+	User
+	IsAdmin     bool             `json:"is_admin"`
+	Deactivated bool             `json:"deactivated"` // режим отпуска, включенный самим пользователем
+	Disabled    *DisabledAccount `json:"disabled,omitempty"`
+}
+
+// StorageStats - число записей в основных таблицах и объем вложений
+type StorageStats struct {
+	Tables          map[string]int64 `json:"tables"`
+	AttachmentBytes int64            `json:"attachment_bytes"`
+}
+
+// statsTables - таблицы, размер которых показывается администратору
+var statsTables = []string{
+	"users", "sessions", "devices", "messages", "chat_groups", "attachments", "message_archives",
+	"invites", "inbox", "outbox", "user_events", "push_subscriptions", "avatars",
+	"transport_events", "recordings", "audit_log",
+}
+
+// DisableUser блокирует аккаунт. Повторный вызов меняет причину.
+func (s *Storage) DisableUser(ctx context.Context, userID, disabledBy, reason string) (*DisabledAccount, error) {
+	d := &DisabledAccount{UserID: userID, Reason: reason, DisabledBy: disabledBy, DisabledAt: time.Now()}
+	query := `INSERT INTO disabled_accounts (user_id, reason, disabled_by, disabled_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING disabled_by, disabled_at`
+	if err := s.db.QueryRowContext(ctx, query, userID, reason, disabledBy, d.DisabledAt).Scan(&d.DisabledBy, &d.DisabledAt); err != nil {
+		return nil, fmt.Errorf("failed to disable user: %w", err)
+	}
+	return d, nil
+}
+
+// EnableUser снимает блокировку; false - аккаунт не был заблокирован
+func (s *Storage) EnableUser(ctx context.Context, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM disabled_accounts WHERE user_id = $1", userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to enable user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to enable user: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetDisabledAccount возвращает блокировку аккаунта; nil - аккаунт не заблокирован
+func (s *Storage) GetDisabledAccount(ctx context.Context, userID string) (*DisabledAccount, error) {
+	d := &DisabledAccount{}
+	query := "SELECT user_id, reason, disabled_by, disabled_at FROM disabled_accounts WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&d.UserID, &d.Reason, &d.DisabledBy, &d.DisabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disabled account: %w", err)
+	}
+	return d, nil
+}
+
+// ListUserSummaries возвращает до limit пользователей с ID больше after по возрастанию ID
+func (s *Storage) ListUserSummaries(ctx context.Context, after string, limit int) ([]*UserSummary, error) {
+	query := `SELECT u.id, u.name, u.email, u.phone,
+			EXISTS (SELECT 1 FROM roles r WHERE r.user_id = u.id AND r.role = $1),
+			EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id),
+			d.reason, d.disabled_by, d.disabled_at
+		FROM users u LEFT JOIN disabled_accounts d ON d.user_id = u.id
+		WHERE u.id > $2 ORDER BY u.id LIMIT $3`
+	rows, err := s.db.QueryContext(ctx, query, RoleAdmin, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*UserSummary
+	for rows.Next() {
+		u := &UserSummary{}
+		var email, phone, reason, disabledBy sql.NullString
+		var disabledAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Name, &email, &phone, &u.IsAdmin, &u.Deactivated, &reason, &disabledBy, &disabledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		u.Email, u.Phone = email.String, phone.String
+		if err := s.openUser(&u.User); err != nil {
+			return nil, err
+		}
+		if disabledAt.Valid {
+			u.Disabled = &DisabledAccount{UserID: u.ID, Reason: reason.String, DisabledBy: disabledBy.String, DisabledAt: disabledAt.Time}
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetStorageStats считает записи в таблицах statsTables и объем вложений
+func (s *Storage) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	stats := &StorageStats{Tables: make(map[string]int64, len(statsTables))}
+	for _, table := range statsTables {
+		var n int64
+		if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", table, err)
+		}
+		stats.Tables[table] = n
+	}
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size_bytes), 0) FROM attachments").Scan(&stats.AttachmentBytes); err != nil {
+		return nil, fmt.Errorf("failed to sum attachment sizes: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Холодное хранение: сообщения давно неактивных бесед упаковываются в сжатые архивы во
+// внешнем хранилище (pkg/archive), а из таблицы messages удаляются. В базе остается только
+// запись об архиве, по которой архив находится, когда пользователь листает историю назад.
+
+// MessageArchive - архив части истории беседы в холодном хранилище
+type MessageArchive struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	Key            string    `json:"key"` // ключ объекта в холодном хранилище
+	Messages       int       `json:"messages"`
+	SizeBytes      int64     `json:"size_bytes"` // размер сжатого архива
+	FirstAt        time.Time `json:"first_at"`   // время первого сообщения архива
+	LastAt         time.Time `json:"last_at"`    // время последнего сообщения архива
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const archiveColumns = "id, conversation_id, blob_key, messages, size_bytes, first_at, last_at, created_at"
+
+// ListInactiveConversations возвращает беседы, в которых нет сообщений новее before,
+// начиная с давно неактивных
+func (s *Storage) ListInactiveConversations(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	query := `SELECT conversation_id FROM messages GROUP BY conversation_id
+		HAVING MAX(created_at) < $1 ORDER BY MAX(created_at) LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inactive conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListMessagesBefore возвращает до limit последних сообщений беседы старше before
+// в хронологическом порядке
+func (s *Storage) ListMessagesBefore(ctx context.Context, conversationID string, before time.Time, limit int) ([]*Message, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM messages
+		WHERE conversation_id = $1 AND created_at < $2 ORDER BY created_at DESC LIMIT $3`
+	rows, err := s.db.QueryContext(ctx, query, conversationID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.openMessages(messages); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// CreateMessageArchive сохраняет запись об архиве и удаляет заархивированные сообщения
+// в одной транзакции: сообщение всегда либо в messages, либо в архиве
+func (s *Storage) CreateMessageArchive(ctx context.Context, archive *MessageArchive, messageIDs []string) error {
+	if archive.CreatedAt.IsZero() {
+		archive.CreatedAt = time.Now()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create message archive: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO message_archives (" + archiveColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	_, err = tx.ExecContext(ctx, query, archive.ID, archive.ConversationID, archive.Key, archive.Messages, archive.SizeBytes,
+		archive.FirstAt, archive.LastAt, archive.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create message archive: %w", err)
+	}
+
+	in, ids := s.inList("id", 2, messageIDs)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE conversation_id = $1 AND "+in, archive.ConversationID, ids); err != nil {
+		return fmt.Errorf("failed to delete archived messages: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListMessageArchives возвращает архивы беседы, начиная с самых новых
+func (s *Storage) ListMessageArchives(ctx context.Context, conversationID string) ([]*MessageArchive, error) {
+	query := "SELECT " + archiveColumns + " FROM message_archives WHERE conversation_id = $1 ORDER BY last_at DESC"
+	rows, err := s.db.QueryContext(ctx, query, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message archives: %w", err)
+	}
+	defer rows.Close()
+
+	var archives []*MessageArchive
+	for rows.Next() {
+		a := &MessageArchive{}
+		if err := rows.Scan(&a.ID, &a.ConversationID, &a.Key, &a.Messages, &a.SizeBytes, &a.FirstAt, &a.LastAt, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message archive: %w", err)
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// Attachment - вложение (изображение, документ), загруженное для отправки в беседу.
+// Содержимое хранится в blob-хранилище под ключом Key; загрузка по частям завершена,
+// когда Complete.
+type Attachment struct {
+	ID             string    `json:"id"`
+	OwnerID        string    `json:"owner_id"`
+	ConversationID string    `json:"conversation_id"`
+	Name           string    `json:"name"`
+	MimeType       string    `json:"mime_type"`
+	Size           int64     `json:"size"`
+	SHA256         string    `json:"sha256,omitempty"` // hex; для незавершенной загрузки - заявленный клиентом
+	Key            string    `json:"-"`
+	Complete       bool      `json:"complete"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const attachmentColumns = "id, owner_id, conversation_id, name, mime_type, size_bytes, sha256, blob_key, complete, created_at"
+
+func (s *Storage) CreateAttachment(ctx context.Context, a *Attachment) error {
+	if a.ID == "" {
+		a.ID = "file-" + ids.New()
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+
+	query := "INSERT INTO attachments (" + attachmentColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+	_, err := s.db.ExecContext(ctx, query, a.ID, a.OwnerID, a.ConversationID, a.Name, a.MimeType, a.Size, a.SHA256, a.Key, a.Complete, a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) GetAttachment(ctx context.Context, id string) (*Attachment, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id = $1", id)
+	a, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("attachment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return a, nil
+}
+
+// CompleteAttachment отмечает загрузку завершенной и сохраняет хеш содержимого
+func (s *Storage) CompleteAttachment(ctx context.Context, id, sha256 string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE attachments SET complete = TRUE, sha256 = $1 WHERE id = $2", sha256, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete attachment: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteAttachment(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM attachments WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
+
+// ListStaleUploads возвращает незавершенные загрузки, начатые раньше before
+func (s *Storage) ListStaleUploads(ctx context.Context, before time.Time) ([]*Attachment, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE complete = FALSE AND created_at < $1", before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(&a.ID, &a.OwnerID, &a.ConversationID, &a.Name, &a.MimeType, &a.Size, &a.SHA256, &a.Key, &a.Complete, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
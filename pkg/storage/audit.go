@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Журнал аудита действий администраторов и сотрудников с ролями. Каждая запись содержит
+// хеш предыдущей, поэтому удаление или изменение записи задним числом обнаруживается
+// проверкой цепочки (VerifyAuditChain).
+
+// auditLockID - ключ pg_advisory_xact_lock: записи добавляются строго по одной,
+// чтобы у каждой был ровно один предшественник
+const auditLockID = 0x61756474 // "audt"
+
+// AuditEntry - запись журнала аудита
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"` // ID пользователя или "admin" для токена администратора
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt int64           `json:"created_at"` // Unix время в миллисекундах
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// auditHash - хеш записи вместе с хешем предыдущей
+func auditHash(e *AuditEntry) string {
+	h := sha256.New()
+	for _, part := range []string{e.PrevHash, strconv.FormatInt(e.ID, 10), e.Actor, e.Action, e.Target, string(e.Details), strconv.FormatInt(e.CreatedAt, 10)} {
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{':'})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AppendAudit добавляет запись в журнал аудита
+func (s *Storage) AppendAudit(ctx context.Context, actor, action, target string, details interface{}) (*AuditEntry, error) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Транзакция SQLite уже держит блокировку записи (_txlock=immediate)
+	nextID := "SELECT COALESCE(MAX(id), 0) + 1 FROM audit_log"
+	if s.dialect == dialectPostgres {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockID); err != nil {
+			return nil, fmt.Errorf("failed to lock audit log: %w", err)
+		}
+		nextID = "SELECT nextval(pg_get_serial_sequence('audit_log', 'id'))"
+	}
+
+	e := &AuditEntry{Actor: actor, Action: action, Target: target, Details: data, CreatedAt: time.Now().UnixMilli()}
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1), '')").Scan(&e.PrevHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, nextID).Scan(&e.ID); err != nil {
+		return nil, fmt.Errorf("failed to allocate audit entry: %w", err)
+	}
+	e.Hash = auditHash(e)
+
+	query := "INSERT INTO audit_log (id, actor, action, target, details, created_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	if _, err := tx.ExecContext(ctx, query, e.ID, e.Actor, e.Action, e.Target, string(e.Details), e.CreatedAt, e.PrevHash, e.Hash); err != nil {
+		return nil, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return e, nil
+}
+
+// ListAudit возвращает записи журнала с ID больше afterID по порядку
+func (s *Storage) ListAudit(ctx context.Context, afterID int64, limit int) ([]*AuditEntry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := "SELECT id, actor, action, target, details, created_at, prev_hash, hash FROM audit_log WHERE id > $1 ORDER BY id LIMIT $2"
+	rows, err := s.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		e := &AuditEntry{}
+		var details string
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &details, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.Details = json.RawMessage(details)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// VerifyAuditChain проверяет хеши записей и их связь друг с другом. Первая запись
+// проверяется только на собственный хеш: ее предшественник может быть за пределами выборки.
+func VerifyAuditChain(entries []*AuditEntry) error {
+	for i, e := range entries {
+		if auditHash(e) != e.Hash {
+			return fmt.Errorf("audit entry %d was modified", e.ID)
+		}
+		if i > 0 && e.PrevHash != entries[i-1].Hash {
+			return fmt.Errorf("audit chain is broken before entry %d", e.ID)
+		}
+	}
+	return nil
+}
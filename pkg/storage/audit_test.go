@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVerifyAuditChain(t *testing.T) {
+	var entries []*AuditEntry
+	prev := ""
+	for i, action := range []string{"role.grant", "compliance.export", "audit.read"} {
+		e := &AuditEntry{ID: int64(i + 1), Actor: "officer", Action: action, Details: json.RawMessage(`{}`), CreatedAt: int64(1000 + i), PrevHash: prev}
+		e.Hash = auditHash(e)
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	if err := VerifyAuditChain(entries); err != nil {
+		t.Fatalf("valid chain rejected: %v", err)
+	}
+
+	// Изменение записи задним числом
+	entries[1].Details = json.RawMessage(`{"user_ids": []}`)
+	if err := VerifyAuditChain(entries); err == nil {
+		t.Error("modified entry not detected")
+	}
+
+	// Удаление записи из середины
+	entries[1].Details = json.RawMessage(`{}`)
+	if err := VerifyAuditChain([]*AuditEntry{entries[0], entries[2]}); err == nil {
+		t.Error("removed entry not detected")
+	}
+}
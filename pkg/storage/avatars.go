@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Avatar - текущий аватар пользователя. Варианты размеров лежат в blob-хранилище под
+// версией Version; ContentType - тип всех вариантов (image/jpeg или image/png).
+type Avatar struct {
+	UserID      string    `json:"user_id"`
+	Version     string    `json:"version"`
+	ContentType string    `json:"content_type"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SaveAvatar сохраняет аватар пользователя вместо прежнего
+func (s *Storage) SaveAvatar(ctx context.Context, a *Avatar) error {
+	a.UpdatedAt = time.Now()
+	query := `INSERT INTO avatars (user_id, version, content_type, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET version = $2, content_type = $3, updated_at = $4`
+	if _, err := s.db.ExecContext(ctx, query, a.UserID, a.Version, a.ContentType, a.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save avatar: %w", err)
+	}
+	return nil
+}
+
+// GetAvatar возвращает аватар пользователя; nil - аватар не загружен
+func (s *Storage) GetAvatar(ctx context.Context, userID string) (*Avatar, error) {
+	a := &Avatar{}
+	err := s.db.QueryRowContext(ctx, "SELECT user_id, version, content_type, updated_at FROM avatars WHERE user_id = $1", userID).
+		Scan(&a.UserID, &a.Version, &a.ContentType, &a.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatar: %w", err)
+	}
+	return a, nil
+}
+
+// DeleteAvatar удаляет аватар пользователя; false - аватара не было
+func (s *Storage) DeleteAvatar(ctx context.Context, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM avatars WHERE user_id = $1", userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete avatar: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete avatar: %w", err)
+	}
+	return n > 0, nil
+}
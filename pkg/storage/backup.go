@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ExportTables выгружает все таблицы схемы public в JSON (массив строк таблицы) из одного
+// снимка БД, поэтому данные разных таблиц согласованы между собой.
+func (s *Storage) ExportTables(ctx context.Context, fn func(table string, rows []byte) error) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to start snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	tables, err := s.listTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		var rows []byte
+		if s.dialect == dialectSQLite {
+			result, err := tx.QueryContext(ctx, "SELECT * FROM "+pq.QuoteIdentifier(table))
+			if err == nil {
+				rows, err = rowsJSON(result, sqliteTimeFormat)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to export table %s: %w", table, err)
+			}
+		} else {
+			query := fmt.Sprintf("SELECT COALESCE(json_agg(t), '[]'::json) FROM %s t", pq.QuoteIdentifier(table))
+			if err := tx.QueryRowContext(ctx, query).Scan(&rows); err != nil {
+				return fmt.Errorf("failed to export table %s: %w", table, err)
+			}
+		}
+		if err := fn(table, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportTables заменяет содержимое таблиц данными из ExportTables в одной транзакции
+// и восстанавливает счетчики SERIAL колонок
+func (s *Storage) ImportTables(ctx context.Context, tables map[string][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start restore: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := s.listTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, table := range existing {
+		known[table] = true
+	}
+
+	for table, rows := range tables {
+		if !known[table] {
+			return fmt.Errorf("unknown table in backup: %s", table)
+		}
+		ident := pq.QuoteIdentifier(table)
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+ident); err != nil {
+			return fmt.Errorf("failed to clear table %s: %w", table, err)
+		}
+		if s.dialect == dialectSQLite {
+			if err := insertRowsJSON(ctx, tx, table, rows); err != nil {
+				return fmt.Errorf("failed to restore table %s: %w", table, err)
+			}
+			continue
+		}
+		query := fmt.Sprintf("INSERT INTO %s SELECT * FROM json_populate_recordset(NULL::%s, $1)", ident, ident)
+		if _, err := tx.ExecContext(ctx, query, string(rows)); err != nil {
+			return fmt.Errorf("failed to restore table %s: %w", table, err)
+		}
+	}
+
+	// Счетчики AUTOINCREMENT в SQLite сдвигаются вставкой явных ID сами
+	if s.dialect == dialectPostgres {
+		if err := resetSequences(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) listTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	query := "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_type = 'BASE TABLE' ORDER BY table_name"
+	if s.dialect == dialectSQLite {
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// resetSequences выставляет счетчики SERIAL колонок после максимального восстановленного значения
+func resetSequences(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = 'public' AND column_default LIKE 'nextval(%'`)
+	if err != nil {
+		return fmt.Errorf("failed to list sequences: %w", err)
+	}
+
+	type serial struct{ table, column string }
+	var serials []serial
+	for rows.Next() {
+		var sc serial
+		if err := rows.Scan(&sc.table, &sc.column); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan sequence: %w", err)
+		}
+		serials = append(serials, sc)
+	}
+	rows.Close()
+
+	for _, sc := range serials {
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			pq.QuoteIdentifier(sc.column), pq.QuoteIdentifier(sc.table))
+		if _, err := tx.ExecContext(ctx, query, sc.table, sc.column); err != nil {
+			return fmt.Errorf("failed to reset sequence of %s.%s: %w", sc.table, sc.column, err)
+		}
+	}
+	return nil
+}
+
+// rowsJSON читает строки результата в JSON массив объектов. Время форматируется по layout
+// в UTC, двоичные значения становятся строками.
+func rowsJSON(rows *sql.Rows, layout string) ([]byte, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			switch v := values[i].(type) {
+			case []byte:
+				record[column] = string(v)
+			case time.Time:
+				record[column] = v.UTC().Format(layout)
+			default:
+				record[column] = v
+			}
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(records)
+}
+
+// insertRowsJSON вставляет в таблицу строки из JSON массива объектов (для SQLite)
+func insertRowsJSON(ctx context.Context, tx *sql.Tx, table string, data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var records []map[string]interface{}
+	if err := decoder.Decode(&records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		columns := make([]string, 0, len(record))
+		for column := range record {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		idents := make([]string, len(columns))
+		params := make([]string, len(columns))
+		args := make([]interface{}, len(columns))
+		for i, column := range columns {
+			idents[i] = pq.QuoteIdentifier(column)
+			params[i] = fmt.Sprintf("$%d", i+1)
+			if n, ok := record[column].(json.Number); ok {
+				args[i] = n.String()
+			} else {
+				args[i] = record[column]
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", pq.QuoteIdentifier(table), strings.Join(idents, ", "), strings.Join(params, ", "))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
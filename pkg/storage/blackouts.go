@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hydra/pkg/ids"
+	"hydra/pkg/transport"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListTransportBlackouts возвращает окна отключения транспортов, заданные через API
+func (s *Storage) ListTransportBlackouts(ctx context.Context) ([]transport.Blackout, error) {
+	query := "SELECT id, transports, traffic, days, start_time, end_time, timezone, reason FROM transport_blackouts ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transport blackouts: %w", err)
+	}
+	defer rows.Close()
+
+	var blackouts []transport.Blackout
+	for rows.Next() {
+		var b transport.Blackout
+		var transports, days string
+		if err := rows.Scan(&b.ID, &transports, &b.Traffic, &days, &b.Start, &b.End, &b.Timezone, &b.Reason); err != nil {
+			return nil, fmt.Errorf("failed to scan transport blackout: %w", err)
+		}
+		b.Transports = strings.Split(transports, ",")
+		for _, day := range strings.Split(days, ",") {
+			if n, err := strconv.Atoi(day); err == nil {
+				b.Days = append(b.Days, time.Weekday(n))
+			}
+		}
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, rows.Err()
+}
+
+// CreateTransportBlackout сохраняет окно отключения; b.ID заполняется, если пуст
+func (s *Storage) CreateTransportBlackout(ctx context.Context, b *transport.Blackout) error {
+	if b.ID == "" {
+		b.ID = "blackout-" + ids.New()
+	}
+
+	days := make([]string, len(b.Days))
+	for i, day := range b.Days {
+		days[i] = strconv.Itoa(int(day))
+	}
+
+	query := "INSERT INTO transport_blackouts (id, transports, traffic, days, start_time, end_time, timezone, reason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	_, err := s.db.ExecContext(ctx, query, b.ID, strings.Join(b.Transports, ","), b.Traffic, strings.Join(days, ","), b.Start, b.End, b.Timezone, b.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to create transport blackout: %w", err)
+	}
+	return nil
+}
+
+// DeleteTransportBlackout удаляет окно отключения; false - окна с таким ID нет
+func (s *Storage) DeleteTransportBlackout(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM transport_blackouts WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete transport blackout: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete transport blackout: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// Что делать с сообщением от заблокированного отправителя (BLOCKED_SENDER_MODE)
+const (
+	BlockedDrop   = "drop"   // сообщение молча отбрасывается, отправитель видит обычный ответ
+	BlockedReject = "reject" // отправитель получает отказ
+)
+
+// Причины жалоб на пользователей
+const (
+	ReportSpam          = "spam"
+	ReportHarassment    = "harassment"
+	ReportImpersonation = "impersonation"
+	ReportIllegal       = "illegal"
+	ReportOther         = "other"
+)
+
+// KnownReportReason сообщает, существует ли причина жалобы
+func KnownReportReason(reason string) bool {
+	switch reason {
+	case ReportSpam, ReportHarassment, ReportImpersonation, ReportIllegal, ReportOther:
+		return true
+	}
+	return false
+}
+
+// Состояния жалобы
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Block - блокировка: UserID не получает сообщений от BlockedID
+type Block struct {
+	UserID    string    `json:"user_id"`
+	BlockedID string    `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AbuseReport - жалоба пользователя ReporterID на ReportedID
+type AbuseReport struct {
+	ID         string    `json:"id"`
+	ReporterID string    `json:"reporter_id"`
+	ReportedID string    `json:"reported_id"`
+	Reason     string    `json:"reason"`
+	MessageID  string    `json:"message_id,omitempty"` // сообщение, на которое жалуются
+	Details    string    `json:"details,omitempty"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty"`
+	Resolution string    `json:"resolution,omitempty"`
+}
+
+// BlockUser блокирует blockedID для userID; false - уже заблокирован
+func (s *Storage) BlockUser(ctx context.Context, userID, blockedID string) (bool, error) {
+	query := "INSERT INTO blocks (user_id, blocked_id, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	result, err := s.db.ExecContext(ctx, query, userID, blockedID, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to block user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to block user: %w", err)
+	}
+	return n > 0, nil
+}
+
+// UnblockUser снимает блокировку; false - блокировки не было
+func (s *Storage) UnblockUser(ctx context.Context, userID, blockedID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM blocks WHERE user_id = $1 AND blocked_id = $2", userID, blockedID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unblock user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to unblock user: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListBlocks возвращает блокировки пользователя, последние первыми
+func (s *Storage) ListBlocks(ctx context.Context, userID string) ([]*Block, error) {
+	query := "SELECT user_id, blocked_id, created_at FROM blocks WHERE user_id = $1 ORDER BY created_at DESC, blocked_id"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	defer rows.Close()
+
+	var blocks []*Block
+	for rows.Next() {
+		b := &Block{}
+		if err := rows.Scan(&b.UserID, &b.BlockedID, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan block: %w", err)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// IsBlocked сообщает, заблокировал ли userID отправителя senderID
+func (s *Storage) IsBlocked(ctx context.Context, userID, senderID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM blocks WHERE user_id = $1 AND blocked_id = $2)", userID, senderID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+	return exists, nil
+}
+
+// CreateAbuseReport сохраняет новую открытую жалобу
+func (s *Storage) CreateAbuseReport(ctx context.Context, report *AbuseReport) error {
+	report.ID = "report-" + ids.New()
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now()
+	query := `INSERT INTO abuse_reports (id, reporter_id, reported_id, reason, message_id, details, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := s.db.ExecContext(ctx, query, report.ID, report.ReporterID, report.ReportedID, report.Reason,
+		report.MessageID, report.Details, report.Status, report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create abuse report: %w", err)
+	}
+	return nil
+}
+
+// ListAbuseReports возвращает последние жалобы, при непустом status - только в этом состоянии
+func (s *Storage) ListAbuseReports(ctx context.Context, status string, limit int) ([]*AbuseReport, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, reporter_id, reported_id, reason, message_id, details, status, created_at,
+			resolved_at, resolved_by, resolution
+		FROM abuse_reports WHERE ($1 = '' OR status = $1) ORDER BY created_at DESC, id LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list abuse reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []*AbuseReport
+	for rows.Next() {
+		r := &AbuseReport{}
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.ReporterID, &r.ReportedID, &r.Reason, &r.MessageID, &r.Details, &r.Status,
+			&r.CreatedAt, &resolvedAt, &r.ResolvedBy, &r.Resolution); err != nil {
+			return nil, fmt.Errorf("failed to scan abuse report: %w", err)
+		}
+		r.ResolvedAt = resolvedAt.Time
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// ResolveAbuseReport закрывает открытую жалобу; false - открытой жалобы с таким id нет
+func (s *Storage) ResolveAbuseReport(ctx context.Context, id, resolvedBy, resolution string) (bool, error) {
+	query := `UPDATE abuse_reports SET status = $1, resolved_at = $2, resolved_by = $3, resolution = $4
+		WHERE id = $5 AND status = $6`
+	result, err := s.db.ExecContext(ctx, query, ReportResolved, time.Now(), resolvedBy, resolution, id, ReportOpen)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve abuse report: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve abuse report: %w", err)
+	}
+	return n > 0, nil
+}
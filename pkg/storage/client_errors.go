@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ClientError - отчет клиента о сбое или ошибке (уже очищенный от персональных данных)
+type ClientError struct {
+	ID            int64     `json:"id"`
+	Kind          string    `json:"kind"` // crash, error, transport
+	Message       string    `json:"message"`
+	Stack         string    `json:"stack,omitempty"`
+	Transport     string    `json:"transport,omitempty"` // транспорт, через который клиент работал
+	ClientVersion string    `json:"client_version,omitempty"`
+	Platform      string    `json:"platform,omitempty"`
+	Context       string    `json:"context,omitempty"` // дополнительные поля в JSON
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ClientErrorStats - число отчетов одного вида с одинаковым сообщением
+type ClientErrorStats struct {
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func (s *Storage) CreateClientError(ctx context.Context, report *ClientError) error {
+	query := `INSERT INTO client_errors (kind, message, stack, transport, client_version, platform, context)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, report.Kind, report.Message, report.Stack, report.Transport,
+		report.ClientVersion, report.Platform, report.Context).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create client error: %w", err)
+	}
+	return nil
+}
+
+// ListClientErrors возвращает последние отчеты, при непустом kind - только этого вида
+func (s *Storage) ListClientErrors(ctx context.Context, kind string, limit int) ([]*ClientError, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, kind, message, stack, transport, client_version, platform, context, created_at
+		FROM client_errors WHERE ($1 = '' OR kind = $1) ORDER BY created_at DESC LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, kind, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list client errors: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []*ClientError
+	for rows.Next() {
+		r := &ClientError{}
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Message, &r.Stack, &r.Transport, &r.ClientVersion, &r.Platform, &r.Context, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client error: %w", err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// GetClientErrorStats группирует отчеты после since по виду и сообщению, самые частые первыми
+func (s *Storage) GetClientErrorStats(ctx context.Context, since time.Time, limit int) ([]*ClientErrorStats, error) {
+	query := `SELECT kind, message, COUNT(*), MAX(created_at) FROM client_errors
+		WHERE created_at >= $1 GROUP BY kind, message ORDER BY COUNT(*) DESC LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client error stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*ClientErrorStats
+	for rows.Next() {
+		st := &ClientErrorStats{}
+		if err := rows.Scan(&st.Kind, &st.Message, &st.Count, &st.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan client error stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// DeleteClientErrorsBefore удаляет отчеты старше before
+func (s *Storage) DeleteClientErrorsBefore(ctx context.Context, before time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM client_errors WHERE created_at < $1", before); err != nil {
+		return fmt.Errorf("failed to delete client errors: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hydra/pkg/compliance"
+	"time"
+)
+
+// complianceSections - запросы разделов выгрузки метаданных. Колонки перечислены явно:
+// тексты сообщений, журналы событий и секреты не выбираются (compliance.Build
+// дополнительно отклоняет такие поля). Вместо %[1]s подставляется условие по списку
+// аккаунтов для колонки id раздела, вместо %[2]s - по списку участников записи звонка.
+var complianceSections = []struct {
+	name   string
+	column string
+	query  string
+}{
+	{"accounts", "id", "SELECT id, name, email, phone FROM users WHERE %[1]s ORDER BY id"},
+	{"account_states", "user_id", "SELECT user_id, inbound_mode, deactivated_at FROM account_states WHERE %[1]s ORDER BY user_id"},
+	{"lookup_profiles", "user_id", "SELECT user_id, username, discoverable FROM lookup_profiles WHERE %[1]s ORDER BY user_id"},
+	{"trust", "user_id", "SELECT user_id, level, invited_by, created_at, updated_at FROM user_trust WHERE %[1]s ORDER BY user_id"},
+	{"devices", "user_id", "SELECT id, user_id, name, updated_at FROM devices WHERE %[1]s ORDER BY user_id, id"},
+	{"recovery_guardians", "user_id", "SELECT user_id, guardians, threshold, updated_at FROM recovery_guardians WHERE %[1]s ORDER BY user_id"},
+	{"messages", "sender_id", "SELECT id, conversation_id, sender_id, type, reply_to, created_at FROM messages WHERE %[1]s OR %[3]s ORDER BY created_at, id"},
+	{"recordings", "requested_by", "SELECT id, room_id, requested_by, participants, size_bytes, started_at, stopped_at, expires_at FROM recordings WHERE %[1]s OR %[2]s ORDER BY started_at, id"},
+}
+
+// ComplianceSnapshot выгружает метаданные аккаунтов из одного снимка БД и возвращает
+// время снимка и разделы для compliance.Build
+func (s *Storage) ComplianceSnapshot(ctx context.Context, userIDs []string) (time.Time, []compliance.Section, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to start snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	// В PostgreSQL now() - время начала транзакции, то есть момент снимка; транзакция
+	// SQLite держит блокировку, и снимок соответствует моменту ее начала
+	snapshotAt := time.Now()
+	if s.dialect == dialectPostgres {
+		if err := tx.QueryRowContext(ctx, "SELECT now()").Scan(&snapshotAt); err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to start snapshot: %w", err)
+		}
+	}
+
+	sections := make([]compliance.Section, 0, len(complianceSections))
+	for _, section := range complianceSections {
+		cond, arg := s.inList(section.column, 1, userIDs)
+		conversationCond, _ := s.inList("conversation_id", 1, userIDs)
+		query := fmt.Sprintf(section.query, cond, s.listOverlaps("participants", 1), conversationCond)
+		rows, err := tx.QueryContext(ctx, query, arg)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+		}
+		data, err := rowsJSON(rows, time.RFC3339Nano)
+		if err == nil && section.name == "accounts" {
+			data, err = s.openJSONColumns(data, "email", "phone")
+		}
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+		}
+		sections = append(sections, compliance.Section{Name: section.name, Rows: data})
+	}
+	return snapshotAt, sections, nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeviceLink - приглашение привязать новое устройство к аккаунту, показанное QR-кодом
+// на устройстве, уже вошедшем в аккаунт
+type DeviceLink struct {
+	Token       string    `json:"token,omitempty"` // только при создании; в базе хранится хеш
+	UserID      string    `json:"user_id"`
+	KeyMaterial string    `json:"key_material,omitempty"` // зашифровано устройством-источником
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// CreateDeviceLink создает одноразовое приглашение, действующее ttl
+func (s *Storage) CreateDeviceLink(ctx context.Context, userID, keyMaterial string, ttl time.Duration) (*DeviceLink, error) {
+	link, err := newDeviceLink(userID, keyMaterial, ttl)
+	if err != nil {
+		return nil, err
+	}
+	query := "INSERT INTO device_links (token_hash, user_id, key_material, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := s.db.ExecContext(ctx, query, hashToken(link.Token), link.UserID, link.KeyMaterial, link.CreatedAt, link.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to create device link: %w", err)
+	}
+	return link, nil
+}
+
+// ConsumeDeviceLink использует приглашение: повторно оно не принимается. nil - приглашения
+// нет или его срок истек.
+func (s *Storage) ConsumeDeviceLink(ctx context.Context, token string) (*DeviceLink, error) {
+	link := &DeviceLink{}
+	query := "DELETE FROM device_links WHERE token_hash = $1 RETURNING user_id, key_material, created_at, expires_at"
+	err := s.db.QueryRowContext(ctx, query, hashToken(token)).Scan(&link.UserID, &link.KeyMaterial, &link.CreatedAt, &link.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume device link: %w", err)
+	}
+	if !time.Now().Before(link.ExpiresAt) {
+		return nil, nil
+	}
+	return link, nil
+}
+
+// DeleteExpiredDeviceLinks удаляет приглашения, срок которых истек до now
+func (s *Storage) DeleteExpiredDeviceLinks(ctx context.Context, now time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM device_links WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired device links: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func newDeviceLink(userID, keyMaterial string, ttl time.Duration) (*DeviceLink, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &DeviceLink{Token: token, UserID: userID, KeyMaterial: keyMaterial, CreatedAt: now, ExpiresAt: now.Add(ttl)}, nil
+}
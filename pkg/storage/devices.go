@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/capability"
+	"time"
+)
+
+// Device - устройство аккаунта и его объявленные возможности. EventSeq - номер последнего
+// события журнала пользователя, полученного устройством.
+type Device struct {
+	ID           string                  `json:"id"`
+	UserID       string                  `json:"user_id"`
+	Name         string                  `json:"name"`
+	Platform     string                  `json:"platform,omitempty"`
+	Capabilities capability.Capabilities `json:"capabilities"`
+	CreatedAt    time.Time               `json:"created_at"`
+	UpdatedAt    time.Time               `json:"updated_at"`
+	LastSeenAt   time.Time               `json:"last_seen_at"`
+	EventSeq     int64                   `json:"event_seq"`
+}
+
+const deviceColumns = "id, user_id, name, platform, capabilities, created_at, updated_at, last_seen_at, event_seq"
+
+// UpsertDevice создает устройство или обновляет его имя, платформу и возможности.
+// Время регистрации и курсор доставки существующего устройства не меняются.
+func (s *Storage) UpsertDevice(ctx context.Context, device *Device) error {
+	device.Capabilities.Normalize()
+	caps, err := json.Marshal(device.Capabilities)
+	if err != nil {
+		return fmt.Errorf("failed to encode capabilities: %w", err)
+	}
+	device.UpdatedAt = time.Now()
+
+	query := `INSERT INTO devices (id, user_id, name, platform, capabilities, created_at, updated_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $6)
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, platform = EXCLUDED.platform, capabilities = EXCLUDED.capabilities,
+			updated_at = EXCLUDED.updated_at, last_seen_at = EXCLUDED.last_seen_at
+		WHERE devices.user_id = EXCLUDED.user_id
+		RETURNING created_at, event_seq`
+	err = s.db.QueryRowContext(ctx, query, device.ID, device.UserID, device.Name, device.Platform, string(caps), device.UpdatedAt).
+		Scan(&device.CreatedAt, &device.EventSeq)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("device belongs to another user")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save device: %w", err)
+	}
+	device.LastSeenAt = device.UpdatedAt
+	return nil
+}
+
+func (s *Storage) GetDevice(ctx context.Context, id string) (*Device, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+deviceColumns+" FROM devices WHERE id = $1", id)
+	device, err := scanDevice(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("device not found")
+	}
+	return device, err
+}
+
+// ListDevices возвращает устройства пользователя
+func (s *Storage) ListDevices(ctx context.Context, userID string) ([]*Device, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+deviceColumns+" FROM devices WHERE user_id = $1 ORDER BY updated_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []*Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (s *Storage) DeleteDevice(ctx context.Context, userID, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM devices WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	return nil
+}
+
+// TouchDevice отмечает активность устройства пользователя и продвигает его курсор
+// доставки до seq (назад курсор не сдвигается); false - такого устройства у пользователя нет
+func (s *Storage) TouchDevice(ctx context.Context, userID, id string, seq int64) (bool, error) {
+	query := `UPDATE devices SET last_seen_at = $1, event_seq = CASE WHEN event_seq < $2 THEN $2 ELSE event_seq END
+		WHERE id = $3 AND user_id = $4`
+	result, err := s.db.ExecContext(ctx, query, time.Now(), seq, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to touch device: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanDevice(row rowScanner) (*Device, error) {
+	device := &Device{}
+	var caps string
+	err := row.Scan(&device.ID, &device.UserID, &device.Name, &device.Platform, &caps,
+		&device.CreatedAt, &device.UpdatedAt, &device.LastSeenAt, &device.EventSeq)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(caps), &device.Capabilities); err != nil {
+		return nil, fmt.Errorf("invalid capabilities of device %s: %w", device.ID, err)
+	}
+	return device, nil
+}
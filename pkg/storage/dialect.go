@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// Хранилище работает с PostgreSQL (серверные развертывания) и SQLite (один пользователь,
+// ноутбук без сети). Драйвер выбирается по DATABASE_URL: "sqlite:путь/к/файлу.db" или
+// "sqlite:///абсолютный/путь.db" - SQLite, остальное - строка подключения PostgreSQL.
+// Запросы пишутся для PostgreSQL; места, где синтаксис различается, ветвятся по s.dialect.
+
+// dialect - диалект SQL базы
+type dialect string
+
+const (
+	dialectPostgres dialect = "postgres"
+	dialectSQLite   dialect = "sqlite"
+)
+
+// sqliteTimeFormat - формат времени в SQLite: совпадает с форматом драйвера (_time_format=sqlite),
+// время записывается в UTC, поэтому строки сравниваются в SQL как время
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// sqlitePath возвращает путь к файлу SQLite из DATABASE_URL; ok=false - это не SQLite
+func sqlitePath(connStr string) (path string, ok bool) {
+	rest, found := strings.CutPrefix(connStr, "sqlite:")
+	if !found {
+		return "", false
+	}
+	return strings.TrimPrefix(rest, "//"), true
+}
+
+// openDB открывает базу по DATABASE_URL
+func openDB(connStr string) (*sql.DB, dialect, error) {
+	path, ok := sqlitePath(connStr)
+	if !ok {
+		db, err := sql.Open("postgres", connStr)
+		return db, dialectPostgres, err
+	}
+	if path == "" {
+		return nil, "", fmt.Errorf("empty SQLite database path")
+	}
+
+	// WAL и ожидание блокировки вместо SQLITE_BUSY: сервер пишет из нескольких горутин.
+	// Транзакции сразу берут блокировку записи, чтобы не получать ошибку при ее повышении.
+	dsn := "file:" + path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(0)&_txlock=immediate&_time_format=sqlite"
+	return sql.OpenDB(&sqliteConnector{dsn: dsn}), dialectSQLite, nil
+}
+
+// sqliteConnector открывает соединения SQLite, приводящие время в параметрах к UTC
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := (&sqlite.Driver{}).Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{Conn: conn}, nil
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return &sqlite.Driver{}
+}
+
+// sqliteConn передает вызовы соединению драйвера, кроме проверки параметров
+type sqliteConn struct {
+	driver.Conn
+}
+
+// CheckNamedValue приводит время к UTC: строки времени в разных поясах не сравнимы
+func (c *sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// inList возвращает условие "column входит в список из параметра $n" и значение параметра
+func (s *Storage) inList(column string, n int, values []string) (string, interface{}) {
+	if s.dialect == dialectSQLite {
+		data, _ := json.Marshal(values)
+		return fmt.Sprintf("%s IN (SELECT value FROM json_each($%d))", column, n), string(data)
+	}
+	return fmt.Sprintf("%s = ANY($%d)", column, n), pq.Array(values)
+}
+
+// listOverlaps возвращает условие "в списке через запятую column есть значение из параметра $n",
+// параметр - тот же, что у inList
+func (s *Storage) listOverlaps(column string, n int) string {
+	if s.dialect == dialectSQLite {
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each($%d) WHERE instr(',' || %s || ',', ',' || value || ',') > 0)", n, column)
+	}
+	return fmt.Sprintf("string_to_array(%s, ',') && $%d", column, n)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErasedAccount - итог удаления аккаунта: число удаленных сообщений и файлы, которые
+// сервер должен удалить из хранилищ (в базе записей о них уже нет)
+type ErasedAccount struct {
+	UserID         string   `json:"user_id"`
+	Messages       int64    `json:"messages"`
+	AttachmentKeys []string `json:"-"`
+	VoicePaths     []string `json:"-"`
+}
+
+// erasureDeletes - данные пользователя $1, удаляемые вместе с аккаунтом. Сообщения
+// удаляются после правок и квитанций, ссылающихся на них.
+var erasureDeletes = []struct{ table, cond string }{
+	{"message_edits", "message_id IN (SELECT id FROM messages WHERE sender_id = $1)"},
+	{"message_receipts", "user_id = $1 OR sender_id = $1"},
+	{"attachments", "owner_id = $1"},
+	{"voice_files", "sender_id = $1"},
+	{"inbox", "user_id = $1 OR sender_id = $1"},
+	{"queued_messages", "user_id = $1 OR sender_id = $1"},
+	{"outbox", "sender_id = $1 OR recipient_id = $1"},
+	{"user_events", "user_id = $1"},
+	{"user_event_seqs", "user_id = $1"},
+	{"notification_events", "user_id = $1"},
+	{"sessions", "user_id = $1"},
+	{"token_revocations", "user_id = $1"},
+	{"external_identities", "user_id = $1"},
+	{"device_links", "user_id = $1"},
+	{"devices", "user_id = $1"},
+	{"device_keys", "user_id = $1"},
+	{"one_time_prekeys", "user_id = $1"},
+	{"push_subscriptions", "user_id = $1"},
+	{"account_states", "user_id = $1"},
+	{"disabled_accounts", "user_id = $1"},
+	{"lookup_profiles", "user_id = $1"},
+	{"released_usernames", "user_id = $1"},
+	{"digest_settings", "user_id = $1"},
+	{"user_trust", "user_id = $1"},
+	{"identifier_changes", "user_id = $1"},
+	{"recovery_guardians", "user_id = $1"},
+	{"recovery_approvals", "guardian_id = $1 OR recovery_id IN (SELECT id FROM recovery_requests WHERE user_id = $1)"},
+	{"recovery_requests", "user_id = $1"},
+	{"chat_folder_assignments", "user_id = $1"},
+	{"chat_folders", "user_id = $1"},
+	{"group_members", "user_id = $1"},
+	{"roles", "user_id = $1"},
+	{"blocks", "user_id = $1 OR blocked_id = $1"},
+	{"abuse_reports", "reporter_id = $1"},
+	{"avatars", "user_id = $1"},
+	{"user_locales", "user_id = $1"},
+	{"invite_uses", "user_id = $1 OR token IN (SELECT token FROM invites WHERE created_by = $1)"},
+	{"invites", "created_by = $1"},
+}
+
+// erasureAnonymize - ссылки на пользователя $1 в чужих данных, которые заменяются пустой строкой
+var erasureAnonymize = []struct{ table, column string }{
+	{"user_trust", "invited_by"},
+	{"chat_groups", "created_by"},
+	{"roles", "granted_by"},
+}
+
+// PurgeUser удаляет аккаунт и все его данные: сообщения с правками и квитанциями,
+// вложения, голосовые сообщения, журнал событий, сессии, устройства и ключи,
+// приглашения, коды подтверждения и письма на его адрес. Ссылки на пользователя в
+// чужих данных (пригласивший, автор группы) обезличиваются. Журнал аудита и архивы
+// бесед, общие для нескольких участников, не меняются.
+func (s *Storage) PurgeUser(ctx context.Context, userID string) (*ErasedAccount, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase user: %w", err)
+	}
+	defer tx.Rollback()
+
+	erased := &ErasedAccount{UserID: userID}
+	for _, list := range []struct {
+		query string
+		dest  *[]string
+	}{
+		{"SELECT blob_key FROM attachments WHERE owner_id = $1", &erased.AttachmentKeys},
+		{"SELECT path FROM voice_files WHERE sender_id = $1", &erased.VoicePaths},
+	} {
+		rows, err := tx.QueryContext(ctx, list.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list user files: %w", err)
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan user file: %w", err)
+			}
+			*list.dest = append(*list.dest, value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list user files: %w", err)
+		}
+	}
+
+	for _, d := range erasureDeletes {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+d.table+" WHERE "+d.cond, userID); err != nil {
+			return nil, fmt.Errorf("failed to erase %s: %w", d.table, err)
+		}
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE sender_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase messages: %w", err)
+	}
+	erased.Messages, _ = result.RowsAffected()
+
+	for _, a := range erasureAnonymize {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+a.table+" SET "+a.column+" = '' WHERE "+a.column+" = $1", userID); err != nil {
+			return nil, fmt.Errorf("failed to anonymize %s: %w", a.table, err)
+		}
+	}
+
+	// Приглашения, коды и письма, адресованные email и телефону пользователя
+	if user.Email != "" {
+		for _, query := range []string{
+			"DELETE FROM invites WHERE contact_info = $1",
+			"DELETE FROM email_verifications WHERE email = $1",
+			"DELETE FROM mail_queue WHERE recipient = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, user.Email); err != nil {
+				return nil, fmt.Errorf("failed to erase email data: %w", err)
+			}
+		}
+	}
+	if user.Phone != "" {
+		for _, query := range []string{
+			"DELETE FROM invites WHERE contact_info = $1",
+			"DELETE FROM sms_verifications WHERE phone = $1",
+			"DELETE FROM sms_messages WHERE recipient = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, user.Phone); err != nil {
+				return nil, fmt.Errorf("failed to erase phone data: %w", err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to erase user: %w", err)
+	}
+	return erased, nil
+}
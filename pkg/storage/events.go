@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Типы событий журнала пользователя
+const (
+	EventMessageCreated    = "message.created"
+	EventMessageEdited     = "message.edited"
+	EventMessageDeleted    = "message.deleted"
+	EventReceipt           = "receipt"
+	EventReceiptBatch      = "receipt.batch"
+	EventMembership        = "membership.changed"
+	EventQuotaWarning      = "quota.warning"
+	EventIdentifierChanged = "identifier.changed"
+	EventRecovery          = "recovery"
+	EventFolders           = "folders.changed"
+	EventFolderAssigned    = "folder.assigned"
+	EventDevices           = "devices.changed"
+)
+
+// Event - событие журнала пользователя. Seq строго возрастает в пределах пользователя,
+// поэтому клиент после переподключения запрашивает все события после последнего полученного.
+type Event struct {
+	UserID    string          `json:"user_id"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AppendEvent добавляет событие в журнал пользователя, назначая следующий номер.
+// Номер выдается под блокировкой строки счетчика, поэтому параллельные записи не дают пропусков.
+func (s *Storage) AppendEvent(ctx context.Context, userID, eventType string, payload interface{}) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event payload: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to append event: %w", err)
+	}
+	defer tx.Rollback()
+
+	event := &Event{UserID: userID, Type: eventType, Payload: data}
+	query := `INSERT INTO user_event_seqs (user_id, last_seq) VALUES ($1, 1)
+		ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_seqs.last_seq + 1
+		RETURNING last_seq`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&event.Seq); err != nil {
+		return nil, fmt.Errorf("failed to allocate event sequence: %w", err)
+	}
+
+	query = "INSERT INTO user_events (user_id, seq, type, payload) VALUES ($1, $2, $3, $4) RETURNING created_at"
+	if err := tx.QueryRowContext(ctx, query, userID, event.Seq, eventType, string(data)).Scan(&event.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to append event: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to append event: %w", err)
+	}
+	return event, nil
+}
+
+// ListEvents возвращает события пользователя с номером больше afterSeq по возрастанию номера
+func (s *Storage) ListEvents(ctx context.Context, userID string, afterSeq int64, limit int) ([]*Event, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 500
+	}
+
+	query := `SELECT user_id, seq, type, payload, created_at FROM user_events
+		WHERE user_id = $1 AND seq > $2 ORDER BY seq LIMIT $3`
+	rows, err := s.db.QueryContext(ctx, query, userID, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		event := &Event{}
+		var payload string
+		if err := rows.Scan(&event.UserID, &event.Seq, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// LastEventSeq возвращает номер последнего события пользователя (0 - событий нет)
+func (s *Storage) LastEventSeq(ctx context.Context, userID string) (int64, error) {
+	var seq int64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(last_seq), 0) FROM user_event_seqs WHERE user_id = $1", userID).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last event sequence: %w", err)
+	}
+	return seq, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// ExternalIdentity - учетная запись внешнего поставщика входа (OpenID Connect, GitHub),
+// привязанная к пользователю
+type ExternalIdentity struct {
+	ID        string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	Provider  string    `json:"provider"` // имя поставщика в настройках сервера
+	Subject   string    `json:"subject"`  // идентификатор пользователя у поставщика
+	Email     string    `json:"email,omitempty"`
+	Signup    bool      `json:"signup"` // аккаунт создан входом через эту запись
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const externalIdentityColumns = "id, user_id, provider, subject, email, signup, created_at"
+
+// LinkExternalIdentity привязывает учетную запись поставщика к пользователю. false -
+// запись уже привязана (к нему или другому пользователю) или у пользователя уже есть
+// запись этого поставщика.
+func (s *Storage) LinkExternalIdentity(ctx context.Context, identity *ExternalIdentity) (bool, error) {
+	identity.ID = "identity-" + ids.New()
+	identity.CreatedAt = time.Now().UTC()
+	query := "INSERT INTO external_identities (" + externalIdentityColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`
+	result, err := s.db.ExecContext(ctx, query, identity.ID, identity.UserID, identity.Provider, identity.Subject,
+		s.keys.Seal(identity.Email), identity.Signup, identity.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to link external identity: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetExternalIdentity возвращает привязку учетной записи subject поставщика; nil - не привязана
+func (s *Storage) GetExternalIdentity(ctx context.Context, provider, subject string) (*ExternalIdentity, error) {
+	query := "SELECT " + externalIdentityColumns + " FROM external_identities WHERE provider = $1 AND subject = $2"
+	identity, err := s.scanExternalIdentity(s.db.QueryRowContext(ctx, query, provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external identity: %w", err)
+	}
+	return identity, nil
+}
+
+// ListExternalIdentities возвращает учетные записи поставщиков, привязанные к пользователю
+func (s *Storage) ListExternalIdentities(ctx context.Context, userID string) ([]*ExternalIdentity, error) {
+	query := "SELECT " + externalIdentityColumns + " FROM external_identities WHERE user_id = $1 ORDER BY created_at, provider"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list external identities: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*ExternalIdentity
+	for rows.Next() {
+		identity, err := s.scanExternalIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan external identity: %w", err)
+		}
+		list = append(list, identity)
+	}
+	return list, rows.Err()
+}
+
+// UnlinkExternalIdentity отвязывает от пользователя учетную запись поставщика
+func (s *Storage) UnlinkExternalIdentity(ctx context.Context, userID, provider string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM external_identities WHERE user_id = $1 AND provider = $2", userID, provider)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlink external identity: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Storage) scanExternalIdentity(row rowScanner) (*ExternalIdentity, error) {
+	identity := &ExternalIdentity{}
+	err := row.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.Signup, &identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Email, err = s.keys.Open(identity.Email); err != nil {
+		return nil, fmt.Errorf("failed to decrypt email of identity %s: %w", identity.ID, err)
+	}
+	return identity, nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/folders"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// FolderAssignment - беседа пользователя, разложенная правилами в папку
+type FolderAssignment struct {
+	ConversationID string    `json:"conversation_id"`
+	FolderID       string    `json:"folder_id"`
+	AssignedAt     time.Time `json:"assigned_at"`
+}
+
+// ListFolders возвращает папки пользователя в порядке Position
+func (s *Storage) ListFolders(ctx context.Context, userID string) ([]*folders.Folder, error) {
+	query := "SELECT id, name, rules, position, updated_at FROM chat_folders WHERE user_id = $1 ORDER BY position, id"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*folders.Folder
+	for rows.Next() {
+		f := &folders.Folder{UserID: userID}
+		var rules string
+		if err := rows.Scan(&f.ID, &f.Name, &rules, &f.Position, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		if err := json.Unmarshal([]byte(rules), &f.Rules); err != nil {
+			return nil, fmt.Errorf("failed to decode rules of folder %s: %w", f.ID, err)
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// SaveFolder создает папку (пустой ID) или заменяет имя, правила и позицию существующей;
+// false - папки с таким ID у пользователя нет
+func (s *Storage) SaveFolder(ctx context.Context, f *folders.Folder) (bool, error) {
+	rules, err := json.Marshal(f.Rules)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode folder rules: %w", err)
+	}
+	f.UpdatedAt = time.Now()
+
+	if f.ID == "" {
+		f.ID = "folder-" + ids.New()
+		query := "INSERT INTO chat_folders (id, user_id, name, rules, position, updated_at) VALUES ($1, $2, $3, $4, $5, $6)"
+		if _, err := s.db.ExecContext(ctx, query, f.ID, f.UserID, f.Name, string(rules), f.Position, f.UpdatedAt); err != nil {
+			return false, fmt.Errorf("failed to create folder: %w", err)
+		}
+		return true, nil
+	}
+
+	query := "UPDATE chat_folders SET name = $1, rules = $2, position = $3, updated_at = $4 WHERE id = $5 AND user_id = $6"
+	result, err := s.db.ExecContext(ctx, query, f.Name, string(rules), f.Position, f.UpdatedAt, f.ID, f.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update folder: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update folder: %w", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteFolder удаляет папку пользователя; ее беседы возвращаются в основной список.
+// false - папки нет.
+func (s *Storage) DeleteFolder(ctx context.Context, userID, folderID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete folder: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM chat_folders WHERE id = $1 AND user_id = $2", folderID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete folder: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM chat_folder_assignments WHERE user_id = $1 AND folder_id = $2", userID, folderID); err != nil {
+		return false, fmt.Errorf("failed to delete folder assignments: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to delete folder: %w", err)
+	}
+	return true, nil
+}
+
+// ListFolderAssignments возвращает раскладку бесед пользователя по папкам
+func (s *Storage) ListFolderAssignments(ctx context.Context, userID string) ([]*FolderAssignment, error) {
+	query := "SELECT conversation_id, folder_id, assigned_at FROM chat_folder_assignments WHERE user_id = $1 ORDER BY conversation_id"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder assignments: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*FolderAssignment
+	for rows.Next() {
+		a := &FolderAssignment{}
+		if err := rows.Scan(&a.ConversationID, &a.FolderID, &a.AssignedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan folder assignment: %w", err)
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// AssignFolder кладет беседу пользователя в папку; false - беседа уже в этой папке
+func (s *Storage) AssignFolder(ctx context.Context, userID, conversationID, folderID string) (bool, error) {
+	query := `INSERT INTO chat_folder_assignments (user_id, conversation_id, folder_id, assigned_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, conversation_id) DO UPDATE SET folder_id = $3, assigned_at = $4
+		WHERE chat_folder_assignments.folder_id <> $3`
+	result, err := s.db.ExecContext(ctx, query, userID, conversationID, folderID, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to assign folder: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to assign folder: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// Группы. ID группы - conversation_id ее сообщений: история, квота, срок хранения и
+// групповые звонки группы используют тот же ID.
+
+// Роли участников группы
+const (
+	GroupRoleAdmin  = "admin"  // добавляет и удаляет участников
+	GroupRoleMember = "member" // пишет и читает сообщения
+)
+
+// Group - групповая беседа
+type Group struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupMember - участник группы
+type GroupMember struct {
+	GroupID  string    `json:"group_id"`
+	UserID   string    `json:"user_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// CreateGroup создает группу; g.ID и g.CreatedAt заполняются. Автор становится ее
+// администратором, members - участниками.
+func (s *Storage) CreateGroup(ctx context.Context, g *Group, members []string) error {
+	g.ID = "group-" + ids.New()
+	g.CreatedAt = time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO chat_groups (id, name, created_by, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.ExecContext(ctx, query, g.ID, g.Name, g.CreatedBy, g.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+	query = "INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4) ON CONFLICT (group_id, user_id) DO NOTHING"
+	if _, err := tx.ExecContext(ctx, query, g.ID, g.CreatedBy, GroupRoleAdmin, g.CreatedAt); err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+	for _, userID := range members {
+		if _, err := tx.ExecContext(ctx, query, g.ID, userID, GroupRoleMember, g.CreatedAt); err != nil {
+			return fmt.Errorf("failed to add group member: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetGroup возвращает группу; nil - группы нет
+func (s *Storage) GetGroup(ctx context.Context, id string) (*Group, error) {
+	g := &Group{}
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, created_by, created_at FROM chat_groups WHERE id = $1", id).Scan(&g.ID, &g.Name, &g.CreatedBy, &g.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	return g, nil
+}
+
+// ListUserGroups возвращает группы, в которых состоит пользователь
+func (s *Storage) ListUserGroups(ctx context.Context, userID string) ([]*Group, error) {
+	query := `SELECT g.id, g.name, g.created_by, g.created_at FROM chat_groups g
+		JOIN group_members m ON m.group_id = g.id WHERE m.user_id = $1 ORDER BY g.created_at, g.id`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []*Group
+	for rows.Next() {
+		g := &Group{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedBy, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroupMember добавляет участника; false - пользователь уже в группе
+func (s *Storage) AddGroupMember(ctx context.Context, groupID, userID, role string) (bool, error) {
+	query := "INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES ($1, $2, $3, $4) ON CONFLICT (group_id, user_id) DO NOTHING"
+	result, err := s.db.ExecContext(ctx, query, groupID, userID, role, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to add group member: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to add group member: %w", err)
+	}
+	return n > 0, nil
+}
+
+// RemoveGroupMember удаляет участника; false - пользователь не состоял в группе
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupID, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove group member: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove group member: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetGroupMember возвращает участника группы; nil - пользователь не состоит в группе
+func (s *Storage) GetGroupMember(ctx context.Context, groupID, userID string) (*GroupMember, error) {
+	m := &GroupMember{}
+	query := "SELECT group_id, user_id, role, joined_at FROM group_members WHERE group_id = $1 AND user_id = $2"
+	err := s.db.QueryRowContext(ctx, query, groupID, userID).Scan(&m.GroupID, &m.UserID, &m.Role, &m.JoinedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group member: %w", err)
+	}
+	return m, nil
+}
+
+// ListGroupMembers возвращает участников группы в порядке вступления
+func (s *Storage) ListGroupMembers(ctx context.Context, groupID string) ([]*GroupMember, error) {
+	query := "SELECT group_id, user_id, role, joined_at FROM group_members WHERE group_id = $1 ORDER BY joined_at, user_id"
+	rows, err := s.db.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []*GroupMember
+	for rows.Next() {
+		m := &GroupMember{}
+		if err := rows.Scan(&m.GroupID, &m.UserID, &m.Role, &m.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// ICEServer - запись STUN/TURN сервера, раздаваемая клиентам для звонков
+type ICEServer struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"` // stun:host:port, turn:host:port?transport=udp, turns:host:port
+	Username   string    `json:"username,omitempty"`
+	Credential string    `json:"credential,omitempty"`
+	Secret     string    `json:"secret,omitempty"` // общий секрет TURN REST API для временных учетных данных
+	Priority   int       `json:"priority"`         // меньше - приоритетнее
+	Enabled    bool      `json:"enabled"`
+	Healthy    bool      `json:"healthy"`
+	LatencyMs  int64     `json:"latency_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+const iceServerColumns = "id, url, username, credential, secret, priority, enabled, healthy, latency_ms, checked_at, last_error"
+
+func (s *Storage) CreateICEServer(ctx context.Context, server *ICEServer) error {
+	if server.ID == "" {
+		server.ID = "ice-" + ids.New()
+	}
+
+	query := "INSERT INTO ice_servers (id, url, username, credential, secret, priority, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := s.db.ExecContext(ctx, query, server.ID, server.URL, server.Username, server.Credential, server.Secret, server.Priority, server.Enabled)
+	if err != nil {
+		return fmt.Errorf("failed to create ICE server: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) UpdateICEServer(ctx context.Context, server *ICEServer) error {
+	query := "UPDATE ice_servers SET url = $1, username = $2, credential = $3, secret = $4, priority = $5, enabled = $6 WHERE id = $7"
+	res, err := s.db.ExecContext(ctx, query, server.URL, server.Username, server.Credential, server.Secret, server.Priority, server.Enabled, server.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update ICE server: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("ICE server not found")
+	}
+	return nil
+}
+
+func (s *Storage) DeleteICEServer(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM ice_servers WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ICE server: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) GetICEServer(ctx context.Context, id string) (*ICEServer, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+iceServerColumns+" FROM ice_servers WHERE id = $1", id)
+	server, err := scanICEServer(row)
+	if err != nil {
+		return nil, fmt.Errorf("ICE server not found: %w", err)
+	}
+	return server, nil
+}
+
+// ListICEServers возвращает все записи, отсортированные по приоритету и задержке
+func (s *Storage) ListICEServers(ctx context.Context) ([]*ICEServer, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+iceServerColumns+" FROM ice_servers ORDER BY priority, latency_ms")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ICE servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []*ICEServer
+	for rows.Next() {
+		server, err := scanICEServer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ICE server: %w", err)
+		}
+		servers = append(servers, server)
+	}
+	return servers, rows.Err()
+}
+
+// UpdateICEServerHealth сохраняет результат проверки доступности сервера
+func (s *Storage) UpdateICEServerHealth(ctx context.Context, id string, healthy bool, latency time.Duration, lastError string) error {
+	query := "UPDATE ice_servers SET healthy = $1, latency_ms = $2, checked_at = $3, last_error = $4 WHERE id = $5"
+	_, err := s.db.ExecContext(ctx, query, healthy, latency.Milliseconds(), time.Now(), lastError, id)
+	if err != nil {
+		return fmt.Errorf("failed to update ICE server health: %w", err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanICEServer(row rowScanner) (*ICEServer, error) {
+	server := &ICEServer{}
+	var checkedAt sql.NullTime
+	err := row.Scan(&server.ID, &server.URL, &server.Username, &server.Credential, &server.Secret,
+		&server.Priority, &server.Enabled, &server.Healthy, &server.LatencyMs, &checkedAt, &server.LastError)
+	if err != nil {
+		return nil, err
+	}
+	server.CheckedAt = checkedAt.Time
+	return server, nil
+}
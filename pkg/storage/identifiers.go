@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Виды идентификаторов аккаунта
+const (
+	IdentifierPhone = "phone"
+	IdentifierEmail = "email"
+)
+
+// IdentifierChange - начатая смена телефона или email: новый идентификатор привязывается
+// только после подтверждения кодами, отправленными на старый и новый. Если идентификатора
+// этого вида у аккаунта еще нет, владение подтверждается другим (VerifyKind, VerifyValue).
+type IdentifierChange struct {
+	UserID      string    `json:"user_id"`
+	Kind        string    `json:"kind"`
+	OldValue    string    `json:"old_value,omitempty"`
+	NewValue    string    `json:"new_value"`
+	VerifyKind  string    `json:"verify_kind"`
+	VerifyValue string    `json:"verify_value"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// identifierColumn возвращает столбец users для вида идентификатора
+func identifierColumn(kind string) (string, error) {
+	switch kind {
+	case IdentifierPhone:
+		return "phone", nil
+	case IdentifierEmail:
+		return "email", nil
+	}
+	return "", fmt.Errorf("unknown identifier kind %q", kind)
+}
+
+// SaveIdentifierChange сохраняет начатую смену, заменяя предыдущую незавершенную
+func (s *Storage) SaveIdentifierChange(ctx context.Context, change *IdentifierChange) error {
+	query := `INSERT INTO identifier_changes (user_id, kind, old_value, new_value, verify_kind, verify_value, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE SET kind = $2, old_value = $3, new_value = $4, verify_kind = $5, verify_value = $6, expires_at = $7`
+	_, err := s.db.ExecContext(ctx, query, change.UserID, change.Kind, change.OldValue, change.NewValue, change.VerifyKind, change.VerifyValue, change.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save identifier change: %w", err)
+	}
+	return nil
+}
+
+// GetIdentifierChange возвращает незавершенную смену идентификатора; nil - смена не начата
+func (s *Storage) GetIdentifierChange(ctx context.Context, userID string) (*IdentifierChange, error) {
+	change := &IdentifierChange{UserID: userID}
+	query := "SELECT kind, old_value, new_value, verify_kind, verify_value, expires_at FROM identifier_changes WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&change.Kind, &change.OldValue, &change.NewValue, &change.VerifyKind, &change.VerifyValue, &change.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identifier change: %w", err)
+	}
+	return change, nil
+}
+
+// DeleteIdentifierChange отменяет незавершенную смену; false - смены не было
+func (s *Storage) DeleteIdentifierChange(ctx context.Context, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM identifier_changes WHERE user_id = $1", userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete identifier change: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete identifier change: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ApplyIdentifierChange в одной транзакции привязывает новый идентификатор к аккаунту
+// и переносит на него ссылки на старый: неиспользованные приглашения и неотправленные письма.
+// Коды подтверждения старого идентификатора удаляются. Поиск пользователей идет по имени
+// (lookup_profiles), а не по телефону или email, поэтому данных поиска смена не затрагивает.
+// Возвращает пользователей, которым нужно сообщить о смене: собеседников по беседам,
+// пригласившего и приглашенных.
+func (s *Storage) ApplyIdentifierChange(ctx context.Context, change *IdentifierChange) ([]string, error) {
+	column, err := identifierColumn(change.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change identifier: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Смена применяется, только если за время подтверждения идентификатор не изменился
+	cond, old := s.contactMatch("COALESCE("+column+", '')", 3, change.OldValue)
+	query := "UPDATE users SET " + column + " = $1 WHERE id = $2 AND " + cond
+	result, err := tx.ExecContext(ctx, query, s.keys.SealDeterministic(change.NewValue), change.UserID, old)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change identifier: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("identifier changed concurrently")
+	}
+
+	if change.OldValue != "" {
+		if _, err := tx.ExecContext(ctx, "UPDATE invites SET contact_info = $1 WHERE contact_info = $2", change.NewValue, change.OldValue); err != nil {
+			return nil, fmt.Errorf("failed to migrate invites: %w", err)
+		}
+		if change.Kind == IdentifierEmail {
+			query := "UPDATE mail_queue SET recipient = $1 WHERE recipient = $2 AND sent_at IS NULL"
+			if _, err := tx.ExecContext(ctx, query, change.NewValue, change.OldValue); err != nil {
+				return nil, fmt.Errorf("failed to migrate mail queue: %w", err)
+			}
+			_, err = tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE email = $1", change.OldValue)
+		} else {
+			_, err = tx.ExecContext(ctx, "DELETE FROM sms_verifications WHERE phone = $1", change.OldValue)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete old verification codes: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM identifier_changes WHERE user_id = $1", change.UserID); err != nil {
+		return nil, fmt.Errorf("failed to delete identifier change: %w", err)
+	}
+
+	query = `SELECT DISTINCT sender_id FROM messages
+			WHERE conversation_id IN (SELECT conversation_id FROM messages WHERE sender_id = $1) AND sender_id <> $1
+		UNION SELECT invited_by FROM user_trust WHERE user_id = $1 AND invited_by <> ''
+		UNION SELECT user_id FROM user_trust WHERE invited_by = $1`
+	rows, err := tx.QueryContext(ctx, query, change.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+	var contacts []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contacts = append(contacts, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to change identifier: %w", err)
+	}
+	return contacts, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// InboxMessage - сообщение, принятое транспортом для локального получателя. MessageID
+// назначает отправитель: по нему повторная доставка того же сообщения отбрасывается.
+type InboxMessage struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	MessageID  string    `json:"message_id"`
+	SenderID   string    `json:"sender_id"`
+	Body       string    `json:"body"`
+	Transport  string    `json:"transport,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// DeliverInbox сохраняет входящее сообщение; msg.ID и msg.ReceivedAt заполняются.
+// Возвращает false, если сообщение с тем же MessageID получатель уже получал.
+func (s *Storage) DeliverInbox(ctx context.Context, msg *InboxMessage) (bool, error) {
+	msg.ID = "inbox-" + ids.New()
+	msg.ReceivedAt = time.Now()
+	query := `INSERT INTO inbox (id, user_id, message_id, sender_id, body, transport, sent_at, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (user_id, message_id) DO NOTHING`
+	result, err := s.db.ExecContext(ctx, query, msg.ID, msg.UserID, msg.MessageID, msg.SenderID, s.keys.Seal(msg.Body), msg.Transport, msg.SentAt, msg.ReceivedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to deliver inbox message: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to deliver inbox message: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListInbox возвращает неподтвержденные входящие пользователя в порядке поступления
+func (s *Storage) ListInbox(ctx context.Context, userID string, limit int) ([]*InboxMessage, error) {
+	query := `SELECT id, user_id, message_id, sender_id, body, transport, sent_at, received_at FROM inbox
+		WHERE user_id = $1 AND acked_at IS NULL ORDER BY received_at, id LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*InboxMessage
+	for rows.Next() {
+		msg := &InboxMessage{}
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.MessageID, &msg.SenderID, &msg.Body, &msg.Transport, &msg.SentAt, &msg.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan inbox message: %w", err)
+		}
+		if msg.Body, err = s.keys.Open(msg.Body); err != nil {
+			return nil, fmt.Errorf("failed to decrypt inbox message %s: %w", msg.ID, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// AckInbox отмечает входящие пользователя полученными клиентом и возвращает число
+// отмеченных. Чужие и уже подтвержденные ID пропускаются.
+func (s *Storage) AckInbox(ctx context.Context, userID string, messageIDs []string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	acked := 0
+	for _, id := range messageIDs {
+		result, err := tx.ExecContext(ctx, "UPDATE inbox SET acked_at = $1 WHERE id = $2 AND user_id = $3 AND acked_at IS NULL", now, id, userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to ack inbox message: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to ack inbox message: %w", err)
+		}
+		acked += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to ack inbox messages: %w", err)
+	}
+	return acked, nil
+}
+
+// DeleteAckedInbox удаляет входящие, подтвержденные до before, и возвращает их число
+func (s *Storage) DeleteAckedInbox(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM inbox WHERE acked_at IS NOT NULL AND acked_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete acked inbox messages: %w", err)
+	}
+	return result.RowsAffected()
+}
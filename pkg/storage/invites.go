@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"hydra/pkg/timesync"
+	"time"
+)
+
+// Приглашения. Приглашение действует до ExpiresAt и MaxUses использований (0 - без
+// ограничения), автор или администратор может отозвать его раньше. Использованные
+// приглашения не удаляются: по ним видно, кто кого пригласил.
+
+// DefaultInviteTTL - срок действия приглашения, если он не задан
+const DefaultInviteTTL = 24 * time.Hour
+
+// Причины, по которым приглашением нельзя воспользоваться
+var (
+	ErrInviteUsed    = errors.New("invite already used")
+	ErrInviteRevoked = errors.New("invite revoked")
+	ErrInviteExpired = errors.New("token expired")
+)
+
+// Invite - приглашение к регистрации
+type Invite struct {
+	Token       string    `json:"token"`
+	ContactInfo string    `json:"contact_info"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	MaxUses     int       `json:"max_uses"`
+	Uses        int       `json:"uses"`
+	RevokedAt   time.Time `json:"revoked_at,omitempty"`
+}
+
+// InviteUse - регистрация пользователя по приглашению
+type InviteUse struct {
+	Token  string    `json:"token"`
+	UserID string    `json:"user_id"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+const inviteColumns = "token, contact_info, created_by, created_at, expires_at, max_uses, uses, revoked_at"
+
+// CreateInvite создает одноразовое приглашение без автора на DefaultInviteTTL
+func (s *Storage) CreateInvite(ctx context.Context, contactInfo string) (string, error) {
+	inv := &Invite{ContactInfo: contactInfo, MaxUses: 1}
+	if err := s.IssueInvite(ctx, inv); err != nil {
+		return "", err
+	}
+	return inv.Token, nil
+}
+
+// IssueInvite сохраняет приглашение inv и заполняет его токен. Незаданный срок
+// действия - DefaultInviteTTL.
+func (s *Storage) IssueInvite(ctx context.Context, inv *Invite) error {
+	prepareInvite(inv, "invite-"+ids.New())
+	query := "INSERT INTO invites (token, contact_info, created_by, created_at, expires_at, max_uses) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := s.db.ExecContext(ctx, query, inv.Token, inv.ContactInfo, inv.CreatedBy, inv.CreatedAt, inv.ExpiresAt, inv.MaxUses)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+	return nil
+}
+
+func prepareInvite(inv *Invite, token string) {
+	inv.Token = token
+	inv.CreatedAt = time.Now()
+	if inv.ExpiresAt.IsZero() {
+		inv.ExpiresAt = inv.CreatedAt.Add(DefaultInviteTTL)
+	}
+	inv.Uses = 0
+	inv.RevokedAt = time.Time{}
+}
+
+// ValidateInvite расходует одно использование приглашения и возвращает контакт,
+// на который оно выдано. Проверка и расход - один запрос, поэтому одновременные
+// регистрации не используют приглашение больше MaxUses раз: опоздавшие получают
+// ErrInviteUsed.
+func (s *Storage) ValidateInvite(ctx context.Context, token string) (string, error) {
+	var contactInfo string
+	query := `UPDATE invites SET uses = uses + 1
+		WHERE token = $1 AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses) AND expires_at >= $2
+		RETURNING contact_info`
+	err := s.db.QueryRowContext(ctx, query, token, time.Now().Add(-s.clockSkew)).Scan(&contactInfo)
+	if err == nil {
+		return contactInfo, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to use invite: %w", err)
+	}
+
+	// Приглашение не подошло: выясняем причину
+	inv, err := s.GetInvite(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if inv == nil {
+		return "", fmt.Errorf("invalid token: %w", sql.ErrNoRows)
+	}
+	if err := checkInvite(inv, s.clockSkew); err != nil {
+		return "", err
+	}
+	return "", ErrInviteUsed
+}
+
+// checkInvite возвращает причину, по которой приглашением нельзя воспользоваться
+func checkInvite(inv *Invite, clockSkew time.Duration) error {
+	switch {
+	case !inv.RevokedAt.IsZero():
+		return ErrInviteRevoked
+	case inv.MaxUses > 0 && inv.Uses >= inv.MaxUses:
+		return ErrInviteUsed
+	case timesync.Expired(inv.ExpiresAt, time.Now(), clockSkew):
+		return ErrInviteExpired
+	}
+	return nil
+}
+
+// RecordInviteUse запоминает, что пользователь userID зарегистрировался по приглашению
+func (s *Storage) RecordInviteUse(ctx context.Context, token, userID string) error {
+	query := "INSERT INTO invite_uses (token, user_id, used_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	if _, err := s.db.ExecContext(ctx, query, token, userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to record invite use: %w", err)
+	}
+	return nil
+}
+
+// GetInvite возвращает приглашение; nil - приглашения нет
+func (s *Storage) GetInvite(ctx context.Context, token string) (*Invite, error) {
+	inv, err := scanInvite(s.db.QueryRowContext(ctx, "SELECT "+inviteColumns+" FROM invites WHERE token = $1", token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+	return inv, nil
+}
+
+// ListInvites возвращает приглашения автора createdBy, новые первыми; пустой
+// createdBy - приглашения всех авторов
+func (s *Storage) ListInvites(ctx context.Context, createdBy string) ([]*Invite, error) {
+	query := "SELECT " + inviteColumns + " FROM invites"
+	var args []interface{}
+	if createdBy != "" {
+		query += " WHERE created_by = $1"
+		args = append(args, createdBy)
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY created_at DESC, token", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []*Invite
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// RevokeInvite отзывает приглашение; false - приглашения нет или оно уже отозвано
+func (s *Storage) RevokeInvite(ctx context.Context, token string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE invites SET revoked_at = $1 WHERE token = $2 AND revoked_at IS NULL", time.Now(), token)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invite: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invite: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListInviteUses возвращает пользователей, зарегистрированных по приглашению
+func (s *Storage) ListInviteUses(ctx context.Context, token string) ([]*InviteUse, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT token, user_id, used_at FROM invite_uses WHERE token = $1 ORDER BY used_at, user_id", token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invite uses: %w", err)
+	}
+	defer rows.Close()
+
+	var uses []*InviteUse
+	for rows.Next() {
+		u := &InviteUse{}
+		if err := rows.Scan(&u.Token, &u.UserID, &u.UsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invite use: %w", err)
+		}
+		uses = append(uses, u)
+	}
+	return uses, rows.Err()
+}
+
+func scanInvite(row rowScanner) (*Invite, error) {
+	inv := &Invite{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&inv.Token, &inv.ContactInfo, &inv.CreatedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.MaxUses, &inv.Uses, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.RevokedAt = revokedAt.Time
+	return inv, nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Открытые ключи сквозного шифрования. Каждое устройство публикует ключ идентичности,
+// подписанный им предварительный ключ и запас одноразовых предварительных ключей.
+// Собеседник перед первым сообщением забирает набор ключей устройства; одноразовый
+// ключ при этом удаляется. Сервер хранит ключи в base64 и не проверяет подписи:
+// их проверяет клиент по ключу идентичности.
+
+// PreKey - предварительный ключ устройства; Signature есть только у подписанного
+type PreKey struct {
+	KeyID     int64  `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// KeyBundle - ключи устройства для начала зашифрованной переписки. OneTimePreKey нет,
+// если запас одноразовых ключей устройства закончился.
+type KeyBundle struct {
+	UserID        string    `json:"user_id"`
+	DeviceID      string    `json:"device_id"`
+	IdentityKey   string    `json:"identity_key"`
+	SignedPreKey  *PreKey   `json:"signed_prekey"`
+	OneTimePreKey *PreKey   `json:"one_time_prekey,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PublishKeys сохраняет ключ идентичности и подписанный ключ устройства и добавляет
+// одноразовые ключи oneTime. Со сменой ключа идентичности прежние одноразовые ключи
+// удаляются: они принадлежат старой идентичности.
+func (s *Storage) PublishKeys(ctx context.Context, keys *KeyBundle, oneTime []*PreKey) error {
+	keys.UpdatedAt = time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var identityKey string
+	err = tx.QueryRowContext(ctx, "SELECT identity_key FROM device_keys WHERE user_id = $1 AND device_id = $2", keys.UserID, keys.DeviceID).Scan(&identityKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get device keys: %w", err)
+	}
+	if err == nil && identityKey != keys.IdentityKey {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2", keys.UserID, keys.DeviceID); err != nil {
+			return fmt.Errorf("failed to delete prekeys: %w", err)
+		}
+	}
+
+	query := `INSERT INTO device_keys (user_id, device_id, identity_key, signed_key_id, signed_public_key, signed_signature, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET identity_key = $3, signed_key_id = $4, signed_public_key = $5, signed_signature = $6, updated_at = $7`
+	signed := keys.SignedPreKey
+	_, err = tx.ExecContext(ctx, query, keys.UserID, keys.DeviceID, keys.IdentityKey, signed.KeyID, signed.PublicKey, signed.Signature, keys.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save device keys: %w", err)
+	}
+
+	for _, k := range oneTime {
+		query := `INSERT INTO one_time_prekeys (user_id, device_id, key_id, public_key, created_at) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, device_id, key_id) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, keys.UserID, keys.DeviceID, k.KeyID, k.PublicKey, keys.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to save prekey: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// CountPreKeys возвращает число оставшихся одноразовых ключей устройства
+func (s *Storage) CountPreKeys(ctx context.Context, userID, deviceID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2", userID, deviceID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count prekeys: %w", err)
+	}
+	return n, nil
+}
+
+// TakeKeyBundles возвращает наборы ключей устройств пользователя (deviceID пусто - всех
+// устройств) и расходует по одному одноразовому ключу каждого устройства
+func (s *Storage) TakeKeyBundles(ctx context.Context, userID, deviceID string) ([]*KeyBundle, error) {
+	query := "SELECT user_id, device_id, identity_key, signed_key_id, signed_public_key, signed_signature, updated_at FROM device_keys WHERE user_id = $1"
+	args := []interface{}{userID}
+	if deviceID != "" {
+		query += " AND device_id = $2"
+		args = append(args, deviceID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY device_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device keys: %w", err)
+	}
+	defer rows.Close()
+
+	var bundles []*KeyBundle
+	for rows.Next() {
+		b := &KeyBundle{SignedPreKey: &PreKey{}}
+		err := rows.Scan(&b.UserID, &b.DeviceID, &b.IdentityKey, &b.SignedPreKey.KeyID, &b.SignedPreKey.PublicKey, &b.SignedPreKey.Signature, &b.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device keys: %w", err)
+		}
+		bundles = append(bundles, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Один ключ одним запросом: параллельные запросы не получат один и тот же ключ
+	query = `DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2 AND key_id =
+		(SELECT MIN(key_id) FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2)
+		RETURNING key_id, public_key`
+	for _, b := range bundles {
+		k := &PreKey{}
+		err := s.db.QueryRowContext(ctx, query, b.UserID, b.DeviceID).Scan(&k.KeyID, &k.PublicKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to take prekey: %w", err)
+		}
+		b.OneTimePreKey = k
+	}
+	return bundles, nil
+}
+
+// DeleteKeys удаляет ключи устройства
+func (s *Storage) DeleteKeys(ctx context.Context, userID, deviceID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2", userID, deviceID); err != nil {
+		return fmt.Errorf("failed to delete prekeys: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM device_keys WHERE user_id = $1 AND device_id = $2", userID, deviceID); err != nil {
+		return fmt.Errorf("failed to delete device keys: %w", err)
+	}
+	return tx.Commit()
+}
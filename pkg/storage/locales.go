@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GetUserLocale возвращает выбранный пользователем язык; пусто - язык не выбран
+func (s *Storage) GetUserLocale(ctx context.Context, userID string) (string, error) {
+	var locale string
+	err := s.db.QueryRowContext(ctx, "SELECT locale FROM user_locales WHERE user_id = $1", userID).Scan(&locale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get user locale: %w", err)
+	}
+	return locale, nil
+}
+
+// SetUserLocale сохраняет язык пользователя; пустой locale сбрасывает выбор
+func (s *Storage) SetUserLocale(ctx context.Context, userID, locale string) error {
+	query := `INSERT INTO user_locales (user_id, locale) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET locale = EXCLUDED.locale`
+	args := []interface{}{userID, locale}
+	if locale == "" {
+		query, args = "DELETE FROM user_locales WHERE user_id = $1", args[:1]
+	}
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to set user locale: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LookupProfile - публичное имя пользователя и настройки его видимости в поиске
+type LookupProfile struct {
+	UserID              string `json:"user_id"`
+	Username            string `json:"username"`
+	Discoverable        bool   `json:"discoverable"`          // находится ли пользователь через /api/lookup и по имени в каталоге
+	DiscoverableByEmail bool   `json:"discoverable_by_email"` // находится ли в каталоге по точному email
+	DiscoverableByPhone bool   `json:"discoverable_by_phone"` // находится ли в каталоге по точному телефону
+
+	UsernameChangedAt *time.Time `json:"username_changed_at,omitempty"` // последняя смена имени (ChangeUsername)
+}
+
+// DirectoryEntry - пользователь в результатах поиска по каталогу
+type DirectoryEntry struct {
+	UserID   string `json:"user_id"`
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+}
+
+// DiscoverableContact - email или телефон пользователя, разрешившего поиск по нему
+type DiscoverableContact struct {
+	DirectoryEntry
+	Contact string `json:"-"`
+}
+
+// GetLookupProfile возвращает настройки поиска пользователя. Если они не заданы,
+// возвращается профиль без имени, скрытый из поиска.
+func (s *Storage) GetLookupProfile(ctx context.Context, userID string) (*LookupProfile, error) {
+	profile := &LookupProfile{UserID: userID}
+	var username sql.NullString
+	var changedAt sql.NullTime
+
+	query := "SELECT username, discoverable, discoverable_by_email, discoverable_by_phone, username_changed_at FROM lookup_profiles WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&username, &profile.Discoverable, &profile.DiscoverableByEmail, &profile.DiscoverableByPhone, &changedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get lookup profile: %w", err)
+	}
+	profile.Username = username.String
+	if changedAt.Valid {
+		profile.UsernameChangedAt = &changedAt.Time
+	}
+	return profile, nil
+}
+
+// SaveLookupProfile сохраняет имя пользователя и видимость. Имя должно быть уникальным;
+// смена имени с удержанием прежнего - ChangeUsername.
+func (s *Storage) SaveLookupProfile(ctx context.Context, profile *LookupProfile) error {
+	var username interface{}
+	if profile.Username != "" {
+		username = profile.Username
+	}
+
+	query := `INSERT INTO lookup_profiles (user_id, username, discoverable, discoverable_by_email, discoverable_by_phone) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET username = EXCLUDED.username, discoverable = EXCLUDED.discoverable,
+			discoverable_by_email = EXCLUDED.discoverable_by_email, discoverable_by_phone = EXCLUDED.discoverable_by_phone`
+	if _, err := s.db.ExecContext(ctx, query, profile.UserID, username, profile.Discoverable, profile.DiscoverableByEmail, profile.DiscoverableByPhone); err != nil {
+		return fmt.Errorf("failed to save lookup profile: %w", err)
+	}
+	return nil
+}
+
+// ChangeUsername занимает, меняет или (username пусто) освобождает имя пользователя.
+// Прежнее имя hold удерживается за владельцем: занять его снова может только он.
+// false - имя занято другим пользователем или удерживается за ним.
+func (s *Storage) ChangeUsername(ctx context.Context, userID, username string, hold time.Duration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to change username: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT username FROM lookup_profiles WHERE user_id = $1", userID).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to change username: %w", err)
+	}
+	if current.String == username {
+		return true, nil
+	}
+
+	now := time.Now()
+	var value interface{}
+	if username != "" {
+		owner, err := usernameOwner(ctx, tx, username, now.Add(-hold))
+		if err != nil {
+			return false, err
+		}
+		if owner != "" && owner != userID {
+			return false, nil
+		}
+		// Свое удерживаемое имя или имя с истекшим удержанием
+		if _, err := tx.ExecContext(ctx, "DELETE FROM released_usernames WHERE username = $1", username); err != nil {
+			return false, fmt.Errorf("failed to change username: %w", err)
+		}
+		value = username
+	}
+	if current.String != "" {
+		query := `INSERT INTO released_usernames (username, user_id, released_at) VALUES ($1, $2, $3)
+			ON CONFLICT (username) DO UPDATE SET user_id = EXCLUDED.user_id, released_at = EXCLUDED.released_at`
+		if _, err := tx.ExecContext(ctx, query, current.String, userID, now); err != nil {
+			return false, fmt.Errorf("failed to release username: %w", err)
+		}
+	}
+
+	query := `INSERT INTO lookup_profiles (user_id, username, username_changed_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET username = EXCLUDED.username, username_changed_at = EXCLUDED.username_changed_at`
+	if _, err := tx.ExecContext(ctx, query, userID, value, now); err != nil {
+		return false, fmt.Errorf("failed to change username: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to change username: %w", err)
+	}
+	return true, nil
+}
+
+// UsernameOwner возвращает пользователя, которому принадлежит имя или за которым оно
+// удерживается hold после смены; пусто - имя свободно
+func (s *Storage) UsernameOwner(ctx context.Context, username string, hold time.Duration) (string, error) {
+	return usernameOwner(ctx, s.db, username, time.Now().Add(-hold))
+}
+
+func usernameOwner(ctx context.Context, q execer, username string, releasedAfter time.Time) (string, error) {
+	var owner string
+	query := `SELECT user_id FROM lookup_profiles WHERE username = $1
+		UNION ALL SELECT user_id FROM released_usernames WHERE username = $1 AND released_at > $2
+		LIMIT 1`
+	err := q.QueryRowContext(ctx, query, username, releasedAfter).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check username: %w", err)
+	}
+	return owner, nil
+}
+
+// ResolveUsernames возвращает ID пользователей по именам (упоминания @имя) независимо от
+// видимости в поиске. Деактивированные пользователи и свободные имена не включаются.
+func (s *Storage) ResolveUsernames(ctx context.Context, usernames []string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	if len(usernames) == 0 {
+		return resolved, nil
+	}
+	cond, arg := s.inList("p.username", 1, usernames)
+	query := `SELECT p.username, p.user_id FROM lookup_profiles p JOIN users u ON u.id = p.user_id
+		WHERE ` + cond + ` AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id)`
+	rows, err := s.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve usernames: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, userID string
+		if err := rows.Scan(&username, &userID); err != nil {
+			return nil, fmt.Errorf("failed to scan username: %w", err)
+		}
+		resolved[username] = userID
+	}
+	return resolved, rows.Err()
+}
+
+// FindUserByUsername ищет пользователя по имени. Находятся только пользователи,
+// разрешившие поиск и не деактивировавшие аккаунт.
+func (s *Storage) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	query := `SELECT u.id, u.name FROM lookup_profiles p JOIN users u ON u.id = p.user_id
+		WHERE p.username = $1 AND p.discoverable
+			AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id)`
+	if err := s.db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Name); err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	return user, nil
+}
+
+// SearchDirectory ищет в каталоге пользователей, разрешивших поиск: по началу имени
+// пользователя handle и (если name не пусто) по части отображаемого имени без учета
+// регистра. Деактивированные пользователи не находятся.
+func (s *Storage) SearchDirectory(ctx context.Context, handle, name string, limit int) ([]*DirectoryEntry, error) {
+	cond := `p.username LIKE $1 ESCAPE '\'`
+	args := []interface{}{likeEscape(strings.ToLower(handle)) + "%"}
+	if name != "" {
+		cond = `(p.username LIKE $1 ESCAPE '\' OR LOWER(u.name) LIKE $2 ESCAPE '\')`
+		args = append(args, "%"+likeEscape(strings.ToLower(name))+"%")
+	}
+	args = append(args, limit)
+	query := fmt.Sprintf(`SELECT u.id, u.name, p.username FROM lookup_profiles p JOIN users u ON u.id = p.user_id
+		WHERE p.discoverable AND %s
+			AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id)
+		ORDER BY u.name, u.id LIMIT $%d`, cond, len(args))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search directory: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*DirectoryEntry
+	for rows.Next() {
+		entry := &DirectoryEntry{}
+		var username sql.NullString
+		if err := rows.Scan(&entry.UserID, &entry.Name, &username); err != nil {
+			return nil, fmt.Errorf("failed to scan directory entry: %w", err)
+		}
+		entry.Username = username.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// FindDirectoryContact ищет в каталоге пользователя по точному email или телефону (пустой
+// не проверяется). Находятся только разрешившие поиск по этому контакту; nil - не найден.
+func (s *Storage) FindDirectoryContact(ctx context.Context, email, phone string) (*DirectoryEntry, error) {
+	column, flag, value := "email", "discoverable_by_email", email
+	if email == "" {
+		column, flag, value = "phone", "discoverable_by_phone", phone
+	}
+	if value == "" {
+		return nil, nil
+	}
+	cond, arg := s.contactMatch("u."+column, 1, value)
+	query := `SELECT u.id, u.name, p.username FROM users u JOIN lookup_profiles p ON p.user_id = u.id
+		WHERE ` + cond + ` AND p.` + flag + `
+			AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id)`
+	entry := &DirectoryEntry{}
+	var username sql.NullString
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&entry.UserID, &entry.Name, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find directory contact: %w", err)
+	}
+	entry.Username = username.String
+	return entry, nil
+}
+
+// ListDiscoverableContacts возвращает контакты пользователей, разрешивших поиск по email
+// или телефону (по записи на контакт), для сверки с адресной книгой клиента.
+// Деактивированные пользователи не включаются.
+func (s *Storage) ListDiscoverableContacts(ctx context.Context) ([]*DiscoverableContact, error) {
+	query := `SELECT u.id, u.name, p.username, u.email, u.phone, p.discoverable_by_email, p.discoverable_by_phone
+		FROM users u JOIN lookup_profiles p ON p.user_id = u.id
+		WHERE (p.discoverable_by_email OR p.discoverable_by_phone)
+			AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = u.id)
+		ORDER BY u.id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list discoverable contacts: %w", err)
+	}
+	defer rows.Close()
+
+	var contacts []*DiscoverableContact
+	for rows.Next() {
+		var entry DirectoryEntry
+		var username, email, phone sql.NullString
+		var byEmail, byPhone bool
+		if err := rows.Scan(&entry.UserID, &entry.Name, &username, &email, &phone, &byEmail, &byPhone); err != nil {
+			return nil, fmt.Errorf("failed to scan discoverable contact: %w", err)
+		}
+		entry.Username = username.String
+		user := &User{ID: entry.UserID, Email: email.String, Phone: phone.String}
+		if err := s.openUser(user); err != nil {
+			return nil, err
+		}
+		if byEmail && user.Email != "" {
+			contacts = append(contacts, &DiscoverableContact{DirectoryEntry: entry, Contact: user.Email})
+		}
+		if byPhone && user.Phone != "" {
+			contacts = append(contacts, &DiscoverableContact{DirectoryEntry: entry, Contact: user.Phone})
+		}
+	}
+	return contacts, rows.Err()
+}
+
+// likeEscape экранирует спецсимволы шаблона LIKE
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
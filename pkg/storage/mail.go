@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxMailAttempts - после стольких неудачных попыток письмо больше не отправляется
+const maxMailAttempts = 5
+
+// QueuedMail - письмо в очереди исходящей почты
+type QueuedMail struct {
+	ID        int64  `json:"id"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	Attempts  int    `json:"attempts"`
+}
+
+// EnqueueMail ставит письмо в очередь на отправку
+func (s *Storage) EnqueueMail(ctx context.Context, recipient, subject, body string) error {
+	query := "INSERT INTO mail_queue (recipient, subject, body, next_attempt_at) VALUES ($1, $2, $3, $4)"
+	if _, err := s.db.ExecContext(ctx, query, recipient, subject, body, time.Now()); err != nil {
+		return fmt.Errorf("failed to enqueue mail: %w", err)
+	}
+	return nil
+}
+
+// ListPendingMail возвращает письма, которые пора отправить
+func (s *Storage) ListPendingMail(ctx context.Context, now time.Time, limit int) ([]*QueuedMail, error) {
+	query := `SELECT id, recipient, subject, body, attempts FROM mail_queue
+		WHERE sent_at IS NULL AND attempts < $1 AND next_attempt_at <= $2 ORDER BY id LIMIT $3`
+	rows, err := s.db.QueryContext(ctx, query, maxMailAttempts, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending mail: %w", err)
+	}
+	defer rows.Close()
+
+	var mails []*QueuedMail
+	for rows.Next() {
+		mail := &QueuedMail{}
+		if err := rows.Scan(&mail.ID, &mail.Recipient, &mail.Subject, &mail.Body, &mail.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan mail: %w", err)
+		}
+		mails = append(mails, mail)
+	}
+	return mails, rows.Err()
+}
+
+// MarkMailSent отмечает письмо отправленным
+func (s *Storage) MarkMailSent(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE mail_queue SET sent_at = $1, last_error = '' WHERE id = $2", time.Now(), id); err != nil {
+		return fmt.Errorf("failed to mark mail sent: %w", err)
+	}
+	return nil
+}
+
+// MarkMailFailed увеличивает счетчик попыток и откладывает следующую попытку
+func (s *Storage) MarkMailFailed(ctx context.Context, id int64, sendErr error, retryAt time.Time) error {
+	query := "UPDATE mail_queue SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3"
+	if _, err := s.db.ExecContext(ctx, query, sendErr.Error(), retryAt, id); err != nil {
+		return fmt.Errorf("failed to mark mail failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteSentMailBefore удаляет давно отправленные и окончательно не доставленные письма
+func (s *Storage) DeleteSentMailBefore(ctx context.Context, before time.Time) error {
+	query := "DELETE FROM mail_queue WHERE (sent_at IS NOT NULL AND sent_at < $1) OR (attempts >= $2 AND next_attempt_at < $1)"
+	if _, err := s.db.ExecContext(ctx, query, before, maxMailAttempts); err != nil {
+		return fmt.Errorf("failed to delete old mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,3541 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/compliance"
+	"hydra/pkg/folders"
+	"hydra/pkg/ids"
+	"hydra/pkg/password"
+	"hydra/pkg/recovery"
+	"hydra/pkg/sms"
+	"hydra/pkg/timesync"
+	"hydra/pkg/transport"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory - хранилище в памяти процесса с поведением Storage: те же значения по умолчанию,
+// ограничения уникальности, порядок выдачи и ошибки. Нужно для тестов сервера без базы.
+// Возвращаемые значения - копии, их изменение не меняет хранилище. Операции не
+// блокируются, поэтому контекст запроса не проверяется.
+type Memory struct {
+	mu        sync.Mutex
+	clockSkew time.Duration
+	serials   map[string]int64
+
+	users         map[string]*User
+	invites       map[string]*Invite
+	inviteUses    []*InviteUse
+	smsCodes      map[string]*memCode
+	emailCodes    map[string]*memCode
+	sessions      map[string]*memSession
+	revocations   map[string]time.Time   // время отзыва токенов по пользователю
+	deviceLinks   map[string]*DeviceLink // по хешу токена
+	identities    []*ExternalIdentity
+	trust         map[string]*UserTrust
+	accountStates map[string]*AccountState
+	queued        []*QueuedMessage
+	idChanges     map[string]*IdentifierChange
+	lookup        map[string]*LookupProfile
+	released      map[string]*memReleased
+
+	guardians  map[string]*RecoveryGuardians
+	recoveries map[string]*RecoveryRequest
+	approvals  map[string][]*recovery.Approval
+
+	devices     map[string]*Device
+	deviceKeys  map[memDeviceKey]*KeyBundle
+	prekeys     map[memDeviceKey][]*PreKey
+	push        map[memDeviceKey]*PushSubscription
+	avatars     map[string]*Avatar
+	locales     map[string]string
+	groups      map[string]*Group
+	members     []*GroupMember
+	messages    []*Message
+	edits       []*MessageEdit
+	receipts    map[string]map[string]*MessageReceipt // ID сообщения -> получатель
+	archives    []*MessageArchive
+	attachments map[string]*Attachment
+	retention   map[string]*RetentionPolicy
+	voiceFiles  map[string]*VoiceFile
+	events      map[string][]*Event
+	folders     map[string]*folders.Folder
+	assignments map[string]map[string]*FolderAssignment
+	inbox       []*memInbox
+	outbox      []*OutboxMessage
+	quotas      map[string]*Quota
+	recordings  map[string]*Recording
+
+	digests       map[string]*DigestSettings
+	notifications []*memNotification
+	mail          []*memMail
+	sms           []*SMSMessage
+
+	ice             map[string]*ICEServer
+	blackouts       []transport.Blackout
+	transportEvents []*TransportEvent
+	staticPeers     []string
+	clientErrors    []*ClientError
+
+	roles    []*RoleGrant
+	audit    []*AuditEntry
+	disabled map[string]*DisabledAccount
+
+	blocks  []*Block
+	reports []*AbuseReport
+}
+
+type memCode struct {
+	code      string
+	expiresAt time.Time
+	verified  bool
+}
+
+// memReleased - имя, удерживаемое за прежним владельцем после смены
+type memReleased struct {
+	userID     string
+	releasedAt time.Time
+}
+
+type memSession struct {
+	Session
+	tokenHash, refreshHash string
+}
+
+type memDeviceKey struct {
+	userID, deviceID string
+}
+
+type memInbox struct {
+	InboxMessage
+	ackedAt time.Time
+}
+
+type memNotification struct {
+	userID, kind, conversationID string
+	createdAt                    time.Time
+}
+
+type memMail struct {
+	QueuedMail
+	nextAttemptAt time.Time
+	sentAt        time.Time
+	lastError     string
+}
+
+// NewMemory создает пустое хранилище в памяти
+func NewMemory() *Memory {
+	return &Memory{
+		serials:       make(map[string]int64),
+		users:         make(map[string]*User),
+		invites:       make(map[string]*Invite),
+		smsCodes:      make(map[string]*memCode),
+		emailCodes:    make(map[string]*memCode),
+		sessions:      make(map[string]*memSession),
+		revocations:   make(map[string]time.Time),
+		deviceLinks:   make(map[string]*DeviceLink),
+		trust:         make(map[string]*UserTrust),
+		accountStates: make(map[string]*AccountState),
+		idChanges:     make(map[string]*IdentifierChange),
+		lookup:        make(map[string]*LookupProfile),
+		released:      make(map[string]*memReleased),
+		guardians:     make(map[string]*RecoveryGuardians),
+		recoveries:    make(map[string]*RecoveryRequest),
+		approvals:     make(map[string][]*recovery.Approval),
+		devices:       make(map[string]*Device),
+		groups:        make(map[string]*Group),
+		deviceKeys:    make(map[memDeviceKey]*KeyBundle),
+		prekeys:       make(map[memDeviceKey][]*PreKey),
+		push:          make(map[memDeviceKey]*PushSubscription),
+		avatars:       make(map[string]*Avatar),
+		locales:       make(map[string]string),
+		events:        make(map[string][]*Event),
+		folders:       make(map[string]*folders.Folder),
+		assignments:   make(map[string]map[string]*FolderAssignment),
+		quotas:        make(map[string]*Quota),
+		recordings:    make(map[string]*Recording),
+		disabled:      make(map[string]*DisabledAccount),
+		attachments:   make(map[string]*Attachment),
+		receipts:      make(map[string]map[string]*MessageReceipt),
+		retention:     make(map[string]*RetentionPolicy),
+		voiceFiles:    make(map[string]*VoiceFile),
+		digests:       make(map[string]*DigestSettings),
+		ice:           make(map[string]*ICEServer),
+	}
+}
+
+// nextSerial - следующее значение SERIAL колонки таблицы
+func (m *Memory) nextSerial(table string) int64 {
+	m.serials[table]++
+	return m.serials[table]
+}
+
+// clone копирует значение через JSON, как при записи в базу и чтении из нее
+func clone(dst, src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string(nil), list...)
+}
+
+func (m *Memory) SetClockSkewTolerance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clockSkew = d
+}
+
+// Пользователи, приглашения и коды подтверждения
+
+func (m *Memory) CreateUser(ctx context.Context, name, plain, contactInfo string) (*User, error) {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := &User{ID: "user-" + ids.New(), Name: name, Password: hash}
+	if strings.Contains(contactInfo, "@") {
+		user.Email = contactInfo
+	} else {
+		user.Phone = contactInfo
+	}
+	if err := m.checkUserUnique(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	m.users[user.ID] = user
+	c := *user
+	return &c, nil
+}
+
+// checkUserUnique проверяет уникальность email и телефона (пустые значения не сравниваются)
+func (m *Memory) checkUserUnique(user *User) error {
+	for _, other := range m.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if user.Email != "" && other.Email == user.Email {
+			return fmt.Errorf("email %s is already taken", user.Email)
+		}
+		if user.Phone != "" && other.Phone == user.Phone {
+			return fmt.Errorf("phone %s is already taken", user.Phone)
+		}
+	}
+	return nil
+}
+
+// findUser возвращает первого по ID пользователя, для которого match истинно
+func (m *Memory) findUser(match func(*User) bool) *User {
+	var found *User
+	for _, user := range m.users {
+		if match(user) && (found == nil || user.ID < found.ID) {
+			found = user
+		}
+	}
+	return found
+}
+
+func (m *Memory) GetUser(ctx context.Context, id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("failed to get user: %w", sql.ErrNoRows)
+	}
+	c := *user
+	c.Password = ""
+	return &c, nil
+}
+
+func (m *Memory) GetUserByPhone(ctx context.Context, phone string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user := m.findUser(func(u *User) bool { return u.Phone == phone })
+	if user == nil {
+		return nil, fmt.Errorf("user not found: %w", sql.ErrNoRows)
+	}
+	c := *user
+	return &c, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user := m.findUser(func(u *User) bool { return u.Email == email })
+	if user == nil {
+		return nil, fmt.Errorf("user not found: %w", sql.ErrNoRows)
+	}
+	c := *user
+	return &c, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if err := m.checkUserUnique(user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	stored.Name, stored.Email, stored.Phone = user.Name, user.Email, user.Phone
+	return nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	return nil
+}
+
+func (m *Memory) ValidateUser(ctx context.Context, contactInfo, plain string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user := m.findUser(func(u *User) bool { return u.Email == contactInfo || u.Phone == contactInfo })
+	if user == nil {
+		return nil, fmt.Errorf("invalid credentials: %w", sql.ErrNoRows)
+	}
+	if !password.Verify(user.Password, plain) {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if password.NeedsRehash(user.Password) {
+		if hash, err := password.Hash(plain); err == nil {
+			user.Password = hash
+		}
+	}
+	c := *user
+	c.Password = ""
+	return &c, nil
+}
+
+func (m *Memory) CreateSMSVerification(ctx context.Context, phone, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.smsCodes[phone] = &memCode{code: code, expiresAt: time.Now().Add(10 * time.Minute)}
+	return nil
+}
+
+func (m *Memory) ValidateSMSVerification(ctx context.Context, phone, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.validateCode(m.smsCodes[phone], code)
+}
+
+func (m *Memory) CreateEmailVerification(ctx context.Context, email, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emailCodes[email] = &memCode{code: code, expiresAt: time.Now().Add(10 * time.Minute)}
+	return nil
+}
+
+func (m *Memory) ValidateEmailVerification(ctx context.Context, email, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.validateCode(m.emailCodes[email], code)
+}
+
+func (m *Memory) validateCode(stored *memCode, code string) (bool, error) {
+	if stored == nil || stored.verified {
+		return false, fmt.Errorf("invalid or expired code: %w", sql.ErrNoRows)
+	}
+	if timesync.Expired(stored.expiresAt, time.Now(), m.clockSkew) {
+		return false, fmt.Errorf("code expired")
+	}
+	if stored.code != code {
+		return false, fmt.Errorf("invalid code")
+	}
+	stored.verified = true
+	return true, nil
+}
+
+// Сессии входа
+
+func (m *Memory) CreateSession(ctx context.Context, userID, deviceID string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error) {
+	sess, err := newSession(userID, deviceID, ttl, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess.ID = "session-" + ids.New()
+	sess.SessionClient = client
+	stored := &memSession{Session: *sess, tokenHash: hashToken(sess.Token), refreshHash: hashToken(sess.RefreshToken)}
+	stored.Token, stored.RefreshToken = "", ""
+	m.sessions[sess.ID] = stored
+	return sess, nil
+}
+
+func (m *Memory) ValidateSession(ctx context.Context, token string, client SessionClient) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := hashToken(token)
+	for _, sess := range m.sessions {
+		if sess.tokenHash != hash {
+			continue
+		}
+		now := time.Now()
+		if !now.Before(sess.ExpiresAt) {
+			return nil, fmt.Errorf("session expired")
+		}
+		sess.LastUsedAt, sess.SessionClient = now, client
+		c := sess.Session
+		return &c, nil
+	}
+	return nil, fmt.Errorf("session not found")
+}
+
+func (m *Memory) RefreshSession(ctx context.Context, refreshToken string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error) {
+	next, err := newSession("", "", ttl, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	next.SessionClient = client
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := hashToken(refreshToken)
+	for _, sess := range m.sessions {
+		if sess.refreshHash != hash || !sess.RefreshExpiresAt.After(next.LastUsedAt) {
+			continue
+		}
+		next.ID, next.UserID, next.DeviceID, next.CreatedAt = sess.ID, sess.UserID, sess.DeviceID, sess.CreatedAt
+		sess.tokenHash, sess.refreshHash = hashToken(next.Token), hashToken(next.RefreshToken)
+		sess.ExpiresAt, sess.RefreshExpiresAt, sess.LastUsedAt = next.ExpiresAt, next.RefreshExpiresAt, next.LastUsedAt
+		sess.SessionClient = client
+		return next, nil
+	}
+	return nil, fmt.Errorf("session not found or expired")
+}
+
+func (m *Memory) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var sessions []*Session
+	for _, sess := range m.sessions {
+		if sess.UserID == userID && sess.RefreshExpiresAt.After(now) {
+			c := sess.Session
+			sessions = append(sessions, &c)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (m *Memory) RevokeSession(ctx context.Context, userID, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[id]
+	if !ok || sess.UserID != userID {
+		return false, nil
+	}
+	delete(m.sessions, id)
+	return true, nil
+}
+
+func (m *Memory) RevokeUserSessions(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, sess := range m.sessions {
+		if sess.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RevokeOtherSessions(ctx context.Context, userID, keepID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, sess := range m.sessions {
+		if sess.UserID == userID && id != keepID {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) RevokeTokens(ctx context.Context, userID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revocations[userID] = at
+	return nil
+}
+
+func (m *Memory) TokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revocations[userID], nil
+}
+
+func (m *Memory) RevokeDeviceSessions(ctx context.Context, userID, deviceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, sess := range m.sessions {
+		if sess.UserID == userID && sess.DeviceID == deviceID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, sess := range m.sessions {
+		if !sess.RefreshExpiresAt.After(now) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Вход через внешних поставщиков
+
+func (m *Memory) LinkExternalIdentity(ctx context.Context, identity *ExternalIdentity) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, linked := range m.identities {
+		if linked.Provider == identity.Provider && (linked.Subject == identity.Subject || linked.UserID == identity.UserID) {
+			return false, nil
+		}
+	}
+	identity.ID = "identity-" + ids.New()
+	identity.CreatedAt = time.Now().UTC()
+	c := *identity
+	m.identities = append(m.identities, &c)
+	return true, nil
+}
+
+func (m *Memory) GetExternalIdentity(ctx context.Context, provider, subject string) (*ExternalIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			c := *identity
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) ListExternalIdentities(ctx context.Context, userID string) ([]*ExternalIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*ExternalIdentity
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			c := *identity
+			list = append(list, &c)
+		}
+	}
+	return list, nil
+}
+
+func (m *Memory) UnlinkExternalIdentity(ctx context.Context, userID, provider string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.identities)
+	m.identities = slices.DeleteFunc(m.identities, func(identity *ExternalIdentity) bool {
+		return identity.UserID == userID && identity.Provider == provider
+	})
+	return len(m.identities) < n, nil
+}
+
+// Привязка устройств по QR-коду
+
+func (m *Memory) CreateDeviceLink(ctx context.Context, userID, keyMaterial string, ttl time.Duration) (*DeviceLink, error) {
+	link, err := newDeviceLink(userID, keyMaterial, ttl)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *link
+	stored.Token = ""
+	m.deviceLinks[hashToken(link.Token)] = &stored
+	return link, nil
+}
+
+func (m *Memory) ConsumeDeviceLink(ctx context.Context, token string) (*DeviceLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := hashToken(token)
+	link, ok := m.deviceLinks[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.deviceLinks, hash)
+	if !time.Now().Before(link.ExpiresAt) {
+		return nil, nil
+	}
+	c := *link
+	return &c, nil
+}
+
+func (m *Memory) DeleteExpiredDeviceLinks(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for hash, link := range m.deviceLinks {
+		if !link.ExpiresAt.After(now) {
+			delete(m.deviceLinks, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Приглашения
+
+func (m *Memory) CreateInvite(ctx context.Context, contactInfo string) (string, error) {
+	inv := &Invite{ContactInfo: contactInfo, MaxUses: 1}
+	if err := m.IssueInvite(ctx, inv); err != nil {
+		return "", err
+	}
+	return inv.Token, nil
+}
+
+func (m *Memory) IssueInvite(ctx context.Context, inv *Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prepareInvite(inv, "invite-"+ids.New())
+	c := *inv
+	m.invites[inv.Token] = &c
+	return nil
+}
+
+func (m *Memory) ValidateInvite(ctx context.Context, token string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invites[token]
+	if !ok {
+		return "", fmt.Errorf("invalid token: %w", sql.ErrNoRows)
+	}
+	if err := checkInvite(inv, m.clockSkew); err != nil {
+		return "", err
+	}
+	inv.Uses++
+	return inv.ContactInfo, nil
+}
+
+func (m *Memory) RecordInviteUse(ctx context.Context, token, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.inviteUses {
+		if u.Token == token && u.UserID == userID {
+			return nil
+		}
+	}
+	m.inviteUses = append(m.inviteUses, &InviteUse{Token: token, UserID: userID, UsedAt: time.Now()})
+	return nil
+}
+
+func (m *Memory) GetInvite(ctx context.Context, token string) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invites[token]
+	if !ok {
+		return nil, nil
+	}
+	c := *inv
+	return &c, nil
+}
+
+func (m *Memory) ListInvites(ctx context.Context, createdBy string) ([]*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var invites []*Invite
+	for _, inv := range m.invites {
+		if createdBy == "" || inv.CreatedBy == createdBy {
+			c := *inv
+			invites = append(invites, &c)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		if !invites[i].CreatedAt.Equal(invites[j].CreatedAt) {
+			return invites[i].CreatedAt.After(invites[j].CreatedAt)
+		}
+		return invites[i].Token < invites[j].Token
+	})
+	return invites, nil
+}
+
+func (m *Memory) RevokeInvite(ctx context.Context, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invites[token]
+	if !ok || !inv.RevokedAt.IsZero() {
+		return false, nil
+	}
+	inv.RevokedAt = time.Now()
+	return true, nil
+}
+
+func (m *Memory) ListInviteUses(ctx context.Context, token string) ([]*InviteUse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var uses []*InviteUse
+	for _, u := range m.inviteUses {
+		if u.Token == token {
+			c := *u
+			uses = append(uses, &c)
+		}
+	}
+	return uses, nil
+}
+
+// Доверие
+
+func (m *Memory) GetUserTrust(ctx context.Context, userID string) (*UserTrust, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.trust[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *t
+	return &c, nil
+}
+
+func (m *Memory) SetUserTrust(ctx context.Context, userID string, level int, invitedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if t, ok := m.trust[userID]; ok {
+		t.Level, t.UpdatedAt = level, now
+		return nil
+	}
+	m.trust[userID] = &UserTrust{UserID: userID, Level: level, InvitedBy: invitedBy, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+// Деактивация аккаунта
+
+func (m *Memory) DeactivateUser(ctx context.Context, userID, inboundMode string) (*AccountState, error) {
+	if inboundMode != InboundQueue && inboundMode != InboundBounce {
+		return nil, fmt.Errorf("unknown inbound mode %q", inboundMode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.accountStates[userID]
+	if !ok {
+		state = &AccountState{UserID: userID, DeactivatedAt: time.Now()}
+		m.accountStates[userID] = state
+	}
+	state.InboundMode = inboundMode
+	c := *state
+	return &c, nil
+}
+
+func (m *Memory) ReactivateUser(ctx context.Context, userID string) ([]*QueuedMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.accountStates, userID)
+
+	var messages []*QueuedMessage
+	kept := m.queued[:0]
+	for _, msg := range m.queued {
+		if msg.UserID == userID {
+			messages = append(messages, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	m.queued = kept
+	return messages, nil
+}
+
+func (m *Memory) GetAccountState(ctx context.Context, userID string) (*AccountState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.accountStates[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *state
+	return &c, nil
+}
+
+func (m *Memory) QueueMessage(ctx context.Context, msg *QueuedMessage) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.ID = m.nextSerial("queued_messages")
+	c := *msg
+	m.queued = append(m.queued, &c)
+	return nil
+}
+
+func (m *Memory) CountQueuedMessages(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, msg := range m.queued {
+		if msg.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Смена идентификаторов
+
+func (m *Memory) SaveIdentifierChange(ctx context.Context, change *IdentifierChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *change
+	m.idChanges[change.UserID] = &c
+	return nil
+}
+
+func (m *Memory) GetIdentifierChange(ctx context.Context, userID string) (*IdentifierChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change, ok := m.idChanges[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *change
+	return &c, nil
+}
+
+func (m *Memory) DeleteIdentifierChange(ctx context.Context, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.idChanges[userID]
+	delete(m.idChanges, userID)
+	return ok, nil
+}
+
+func (m *Memory) ApplyIdentifierChange(ctx context.Context, change *IdentifierChange) ([]string, error) {
+	if _, err := identifierColumn(change.Kind); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[change.UserID]
+	if !ok {
+		return nil, fmt.Errorf("identifier changed concurrently")
+	}
+	field := &user.Phone
+	if change.Kind == IdentifierEmail {
+		field = &user.Email
+	}
+	if *field != change.OldValue {
+		return nil, fmt.Errorf("identifier changed concurrently")
+	}
+	updated := *user
+	if change.Kind == IdentifierEmail {
+		updated.Email = change.NewValue
+	} else {
+		updated.Phone = change.NewValue
+	}
+	if err := m.checkUserUnique(&updated); err != nil {
+		return nil, fmt.Errorf("failed to change identifier: %w", err)
+	}
+	*field = change.NewValue
+
+	if change.OldValue != "" {
+		for _, invite := range m.invites {
+			if invite.ContactInfo == change.OldValue {
+				invite.ContactInfo = change.NewValue
+			}
+		}
+		if change.Kind == IdentifierEmail {
+			for _, mail := range m.mail {
+				if mail.Recipient == change.OldValue && mail.sentAt.IsZero() {
+					mail.Recipient = change.NewValue
+				}
+			}
+			delete(m.emailCodes, change.OldValue)
+		} else {
+			delete(m.smsCodes, change.OldValue)
+		}
+	}
+	delete(m.idChanges, change.UserID)
+
+	contacts := make(map[string]bool)
+	conversations := make(map[string]bool)
+	for _, msg := range m.messages {
+		if msg.SenderID == change.UserID {
+			conversations[msg.ConversationID] = true
+		}
+	}
+	for _, msg := range m.messages {
+		if conversations[msg.ConversationID] && msg.SenderID != change.UserID {
+			contacts[msg.SenderID] = true
+		}
+	}
+	for _, t := range m.trust {
+		if t.UserID == change.UserID && t.InvitedBy != "" {
+			contacts[t.InvitedBy] = true
+		}
+		if t.InvitedBy == change.UserID {
+			contacts[t.UserID] = true
+		}
+	}
+	list := make([]string, 0, len(contacts))
+	for id := range contacts {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Поиск по имени
+
+func (m *Memory) GetLookupProfile(ctx context.Context, userID string) (*LookupProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.lookup[userID]
+	if !ok {
+		return &LookupProfile{UserID: userID}, nil
+	}
+	c := *profile
+	return &c, nil
+}
+
+func (m *Memory) SaveLookupProfile(ctx context.Context, profile *LookupProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if profile.Username != "" {
+		for _, other := range m.lookup {
+			if other.UserID != profile.UserID && other.Username == profile.Username {
+				return fmt.Errorf("failed to save lookup profile: username %s is already taken", profile.Username)
+			}
+		}
+	}
+	c := *profile
+	m.lookup[profile.UserID] = &c
+	return nil
+}
+
+func (m *Memory) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, profile := range m.lookup {
+		if username == "" || profile.Username != username || !profile.Discoverable {
+			continue
+		}
+		user, ok := m.users[profile.UserID]
+		if _, deactivated := m.accountStates[profile.UserID]; !ok || deactivated {
+			break
+		}
+		return &User{ID: user.ID, Name: user.Name}, nil
+	}
+	return nil, fmt.Errorf("user not found: %w", sql.ErrNoRows)
+}
+
+func (m *Memory) ChangeUsername(ctx context.Context, userID, username string, hold time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.lookup[userID]
+	if !ok {
+		profile = &LookupProfile{UserID: userID}
+	}
+	if profile.Username == username {
+		return true, nil
+	}
+
+	now := time.Now()
+	if username != "" {
+		if owner := m.usernameOwner(username, now.Add(-hold)); owner != "" && owner != userID {
+			return false, nil
+		}
+		delete(m.released, username)
+	}
+	if profile.Username != "" {
+		m.released[profile.Username] = &memReleased{userID: userID, releasedAt: now}
+	}
+	c := *profile
+	c.Username, c.UsernameChangedAt = username, &now
+	m.lookup[userID] = &c
+	return true, nil
+}
+
+func (m *Memory) UsernameOwner(ctx context.Context, username string, hold time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usernameOwner(username, time.Now().Add(-hold)), nil
+}
+
+func (m *Memory) usernameOwner(username string, releasedAfter time.Time) string {
+	for _, profile := range m.lookup {
+		if profile.Username == username {
+			return profile.UserID
+		}
+	}
+	if r, ok := m.released[username]; ok && r.releasedAt.After(releasedAfter) {
+		return r.userID
+	}
+	return ""
+}
+
+func (m *Memory) ResolveUsernames(ctx context.Context, usernames []string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wanted := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		wanted[username] = true
+	}
+	resolved := make(map[string]string)
+	for _, profile := range m.lookup {
+		_, exists := m.users[profile.UserID]
+		_, deactivated := m.accountStates[profile.UserID]
+		if profile.Username != "" && wanted[profile.Username] && exists && !deactivated {
+			resolved[profile.Username] = profile.UserID
+		}
+	}
+	return resolved, nil
+}
+
+func (m *Memory) SearchDirectory(ctx context.Context, handle, name string, limit int) ([]*DirectoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	handle, name = strings.ToLower(handle), strings.ToLower(name)
+	var users []*User
+	for _, profile := range m.lookup {
+		user, ok := m.users[profile.UserID]
+		if _, deactivated := m.accountStates[profile.UserID]; !ok || deactivated || !profile.Discoverable {
+			continue
+		}
+		if (profile.Username != "" && strings.HasPrefix(profile.Username, handle)) ||
+			(name != "" && strings.Contains(strings.ToLower(user.Name), name)) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].ID < users[j].ID
+	})
+	var entries []*DirectoryEntry
+	for _, user := range users[:min(limit, len(users))] {
+		entries = append(entries, &DirectoryEntry{UserID: user.ID, Name: user.Name, Username: m.lookup[user.ID].Username})
+	}
+	return entries, nil
+}
+
+func (m *Memory) FindDirectoryContact(ctx context.Context, email, phone string) (*DirectoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if email == "" && phone == "" {
+		return nil, nil
+	}
+	for _, user := range m.users {
+		profile, ok := m.lookup[user.ID]
+		if _, deactivated := m.accountStates[user.ID]; !ok || deactivated {
+			continue
+		}
+		if (email != "" && user.Email == email && profile.DiscoverableByEmail) ||
+			(email == "" && user.Phone == phone && profile.DiscoverableByPhone) {
+			return &DirectoryEntry{UserID: user.ID, Name: user.Name, Username: profile.Username}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) ListDiscoverableContacts(ctx context.Context) ([]*DiscoverableContact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var users []*User
+	for _, user := range m.users {
+		profile, ok := m.lookup[user.ID]
+		if _, deactivated := m.accountStates[user.ID]; ok && !deactivated && (profile.DiscoverableByEmail || profile.DiscoverableByPhone) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	var contacts []*DiscoverableContact
+	for _, user := range users {
+		profile := m.lookup[user.ID]
+		entry := DirectoryEntry{UserID: user.ID, Name: user.Name, Username: profile.Username}
+		if profile.DiscoverableByEmail && user.Email != "" {
+			contacts = append(contacts, &DiscoverableContact{DirectoryEntry: entry, Contact: user.Email})
+		}
+		if profile.DiscoverableByPhone && user.Phone != "" {
+			contacts = append(contacts, &DiscoverableContact{DirectoryEntry: entry, Contact: user.Phone})
+		}
+	}
+	return contacts, nil
+}
+
+// Восстановление аккаунта поручителями
+
+func (m *Memory) GetRecoveryGuardians(ctx context.Context, userID string) (*RecoveryGuardians, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.guardians[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *g
+	c.Guardians = copyStrings(g.Guardians)
+	return &c, nil
+}
+
+func (m *Memory) SetRecoveryGuardians(ctx context.Context, g *RecoveryGuardians) error {
+	g.UpdatedAt = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *g
+	c.Guardians = copyStrings(g.Guardians)
+	m.guardians[g.UserID] = &c
+	return nil
+}
+
+func (m *Memory) DeleteRecoveryGuardians(ctx context.Context, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.guardians[userID]
+	delete(m.guardians, userID)
+	return ok, nil
+}
+
+func (m *Memory) CreateRecoveryRequest(ctx context.Context, req *RecoveryRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req.ID = "recovery-" + ids.New()
+	c := *req
+	c.CompletedAt, c.Cancelled = nil, false
+	m.recoveries[req.ID] = &c
+	return nil
+}
+
+func (m *Memory) GetRecoveryRequest(ctx context.Context, id string) (*RecoveryRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req, ok := m.recoveries[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *req
+	if req.CompletedAt != nil {
+		completedAt := *req.CompletedAt
+		c.CompletedAt = &completedAt
+	}
+	return &c, nil
+}
+
+func (m *Memory) CancelRecoveryRequests(ctx context.Context, userID, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, req := range m.recoveries {
+		if req.UserID == userID && req.CompletedAt == nil && !req.Cancelled && (id == "" || req.ID == id) {
+			req.Cancelled = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) AddRecoveryApproval(ctx context.Context, a *recovery.Approval) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.approvals[a.RecoveryID] {
+		if existing.GuardianID == a.GuardianID {
+			return false, nil
+		}
+	}
+	c := *a
+	m.approvals[a.RecoveryID] = append(m.approvals[a.RecoveryID], &c)
+	return true, nil
+}
+
+func (m *Memory) ListRecoveryApprovals(ctx context.Context, req *RecoveryRequest) ([]*recovery.Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var approvals []*recovery.Approval
+	for _, a := range m.approvals[req.ID] {
+		c := *a
+		c.RecoveryID, c.UserID = req.ID, req.UserID
+		approvals = append(approvals, &c)
+	}
+	sort.SliceStable(approvals, func(i, j int) bool { return approvals[i].ApprovedAt < approvals[j].ApprovedAt })
+	return approvals, nil
+}
+
+func (m *Memory) CompleteRecovery(ctx context.Context, req *RecoveryRequest, plain string) error {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("failed to complete recovery: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.recoveries[req.ID]
+	if !ok || stored.CompletedAt != nil || stored.Cancelled {
+		return fmt.Errorf("recovery request is no longer active")
+	}
+	now := time.Now()
+	stored.CompletedAt = &now
+	if user, ok := m.users[req.UserID]; ok {
+		user.Password = hash
+	}
+	for _, other := range m.recoveries {
+		if other.UserID == req.UserID && other.ID != req.ID && other.CompletedAt == nil {
+			other.Cancelled = true
+		}
+	}
+	return nil
+}
+
+// Устройства
+
+func (m *Memory) UpsertDevice(ctx context.Context, device *Device) error {
+	device.Capabilities.Normalize()
+	device.UpdatedAt = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	device.CreatedAt, device.LastSeenAt, device.EventSeq = device.UpdatedAt, device.UpdatedAt, 0
+	if existing, ok := m.devices[device.ID]; ok {
+		if existing.UserID != device.UserID {
+			return fmt.Errorf("device belongs to another user")
+		}
+		device.CreatedAt, device.EventSeq = existing.CreatedAt, existing.EventSeq
+	}
+	c := &Device{}
+	if err := clone(c, device); err != nil {
+		return fmt.Errorf("failed to encode capabilities: %w", err)
+	}
+	m.devices[device.ID] = c
+	return nil
+}
+
+func (m *Memory) GetDevice(ctx context.Context, id string) (*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	device, ok := m.devices[id]
+	if !ok {
+		return nil, fmt.Errorf("device not found")
+	}
+	c := &Device{}
+	return c, clone(c, device)
+}
+
+func (m *Memory) ListDevices(ctx context.Context, userID string) ([]*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var devices []*Device
+	for _, device := range m.devices {
+		if device.UserID != userID {
+			continue
+		}
+		c := &Device{}
+		if err := clone(c, device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, c)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UpdatedAt.After(devices[j].UpdatedAt) })
+	return devices, nil
+}
+
+func (m *Memory) DeleteDevice(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if device, ok := m.devices[id]; ok && device.UserID == userID {
+		delete(m.devices, id)
+	}
+	return nil
+}
+
+func (m *Memory) TouchDevice(ctx context.Context, userID, id string, seq int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	device, ok := m.devices[id]
+	if !ok || device.UserID != userID {
+		return false, nil
+	}
+	device.LastSeenAt = time.Now()
+	if seq > device.EventSeq {
+		device.EventSeq = seq
+	}
+	return true, nil
+}
+
+// Ключи сквозного шифрования
+
+func (m *Memory) PublishKeys(ctx context.Context, keys *KeyBundle, oneTime []*PreKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys.UpdatedAt = time.Now()
+	key := memDeviceKey{keys.UserID, keys.DeviceID}
+	if old, ok := m.deviceKeys[key]; ok && old.IdentityKey != keys.IdentityKey {
+		delete(m.prekeys, key)
+	}
+	signed := *keys.SignedPreKey
+	m.deviceKeys[key] = &KeyBundle{UserID: keys.UserID, DeviceID: keys.DeviceID, IdentityKey: keys.IdentityKey, SignedPreKey: &signed, UpdatedAt: keys.UpdatedAt}
+
+next:
+	for _, k := range oneTime {
+		for _, existing := range m.prekeys[key] {
+			if existing.KeyID == k.KeyID {
+				continue next
+			}
+		}
+		m.prekeys[key] = append(m.prekeys[key], &PreKey{KeyID: k.KeyID, PublicKey: k.PublicKey})
+	}
+	sort.Slice(m.prekeys[key], func(i, j int) bool { return m.prekeys[key][i].KeyID < m.prekeys[key][j].KeyID })
+	return nil
+}
+
+func (m *Memory) CountPreKeys(ctx context.Context, userID, deviceID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.prekeys[memDeviceKey{userID, deviceID}]), nil
+}
+
+func (m *Memory) TakeKeyBundles(ctx context.Context, userID, deviceID string) ([]*KeyBundle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var bundles []*KeyBundle
+	for key, keys := range m.deviceKeys {
+		if key.userID != userID || (deviceID != "" && key.deviceID != deviceID) {
+			continue
+		}
+		b := *keys
+		signed := *keys.SignedPreKey
+		b.SignedPreKey = &signed
+		if prekeys := m.prekeys[key]; len(prekeys) > 0 {
+			b.OneTimePreKey = prekeys[0]
+			m.prekeys[key] = prekeys[1:]
+		}
+		bundles = append(bundles, &b)
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].DeviceID < bundles[j].DeviceID })
+	return bundles, nil
+}
+
+func (m *Memory) DeleteKeys(ctx context.Context, userID, deviceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memDeviceKey{userID, deviceID}
+	delete(m.deviceKeys, key)
+	delete(m.prekeys, key)
+	return nil
+}
+
+// Push уведомления
+
+func (m *Memory) SavePushSubscription(ctx context.Context, sub *PushSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub.CreatedAt = time.Now()
+	for key, existing := range m.push {
+		if existing.Endpoint == sub.Endpoint {
+			delete(m.push, key)
+		}
+	}
+	saved := *sub
+	m.push[memDeviceKey{sub.UserID, sub.DeviceID}] = &saved
+	return nil
+}
+
+func (m *Memory) ListPushSubscriptions(ctx context.Context, userID string) ([]*PushSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subs []*PushSubscription
+	for key, sub := range m.push {
+		if key.userID == userID {
+			s := *sub
+			subs = append(subs, &s)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].DeviceID < subs[j].DeviceID })
+	return subs, nil
+}
+
+func (m *Memory) DeletePushSubscription(ctx context.Context, userID, deviceID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memDeviceKey{userID, deviceID}
+	_, ok := m.push[key]
+	delete(m.push, key)
+	return ok, nil
+}
+
+// Аватары
+
+func (m *Memory) SaveAvatar(ctx context.Context, a *Avatar) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.UpdatedAt = time.Now()
+	saved := *a
+	m.avatars[a.UserID] = &saved
+	return nil
+}
+
+func (m *Memory) GetAvatar(ctx context.Context, userID string) (*Avatar, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.avatars[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *a
+	return &c, nil
+}
+
+func (m *Memory) DeleteAvatar(ctx context.Context, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.avatars[userID]
+	delete(m.avatars, userID)
+	return ok, nil
+}
+
+// Язык пользователя
+
+func (m *Memory) GetUserLocale(ctx context.Context, userID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locales[userID], nil
+}
+
+func (m *Memory) SetUserLocale(ctx context.Context, userID, locale string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if locale == "" {
+		delete(m.locales, userID)
+	} else {
+		m.locales[userID] = locale
+	}
+	return nil
+}
+
+// Группы
+
+func (m *Memory) CreateGroup(ctx context.Context, g *Group, members []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g.ID = "group-" + ids.New()
+	g.CreatedAt = time.Now()
+	c := *g
+	m.groups[g.ID] = &c
+	m.addMemberLocked(g.ID, g.CreatedBy, GroupRoleAdmin, g.CreatedAt)
+	for _, userID := range members {
+		m.addMemberLocked(g.ID, userID, GroupRoleMember, g.CreatedAt)
+	}
+	return nil
+}
+
+func (m *Memory) addMemberLocked(groupID, userID, role string, at time.Time) bool {
+	for _, member := range m.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			return false
+		}
+	}
+	m.members = append(m.members, &GroupMember{GroupID: groupID, UserID: userID, Role: role, JoinedAt: at})
+	return true
+}
+
+func (m *Memory) GetGroup(ctx context.Context, id string) (*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *g
+	return &c, nil
+}
+
+func (m *Memory) ListUserGroups(ctx context.Context, userID string) ([]*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var groups []*Group
+	for _, member := range m.members {
+		if member.UserID == userID {
+			c := *m.groups[member.GroupID]
+			groups = append(groups, &c)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if !groups[i].CreatedAt.Equal(groups[j].CreatedAt) {
+			return groups[i].CreatedAt.Before(groups[j].CreatedAt)
+		}
+		return groups[i].ID < groups[j].ID
+	})
+	return groups, nil
+}
+
+func (m *Memory) AddGroupMember(ctx context.Context, groupID, userID, role string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addMemberLocked(groupID, userID, role, time.Now()), nil
+}
+
+func (m *Memory) RemoveGroupMember(ctx context.Context, groupID, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, member := range m.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) GetGroupMember(ctx context.Context, groupID, userID string) (*GroupMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, member := range m.members {
+		if member.GroupID == groupID && member.UserID == userID {
+			c := *member
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) ListGroupMembers(ctx context.Context, groupID string) ([]*GroupMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var members []*GroupMember
+	for _, member := range m.members {
+		if member.GroupID == groupID {
+			c := *member
+			members = append(members, &c)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// Сообщения, журнал событий и папки
+
+func (m *Memory) CreateMessage(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg.ID == "" {
+		msg.ID = "msg-" + ids.New()
+	}
+	if msg.Type == "" {
+		msg.Type = "text"
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	for _, existing := range m.messages {
+		if existing.ID == msg.ID {
+			return fmt.Errorf("failed to create message: duplicate id %s", msg.ID)
+		}
+	}
+	c := *msg
+	m.messages = append(m.messages, &c)
+	return nil
+}
+
+// conversationMessages возвращает сообщения беседы по возрастанию времени
+func (m *Memory) conversationMessages(conversationID string) []*Message {
+	var messages []*Message
+	for _, msg := range m.messages {
+		if msg.ConversationID == conversationID {
+			messages = append(messages, msg)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+	return messages
+}
+
+func (m *Memory) ListMessages(ctx context.Context, conversationID string, limit int) ([]*Message, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*Message
+	for _, msg := range m.conversationMessages(conversationID) {
+		if len(messages) == limit {
+			break
+		}
+		c := *msg
+		messages = append(messages, &c)
+	}
+	return messages, nil
+}
+
+func (m *Memory) IsConversationParticipant(ctx context.Context, conversationID, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.messages {
+		if msg.ConversationID != conversationID {
+			continue
+		}
+		if _, ok := m.receipts[msg.ID][userID]; ok || msg.SenderID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) ListMessagesBefore(ctx context.Context, conversationID string, before time.Time, limit int) ([]*Message, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*Message
+	for _, msg := range m.conversationMessages(conversationID) {
+		if !msg.CreatedAt.Before(before) {
+			break
+		}
+		c := *msg
+		messages = append(messages, &c)
+	}
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+func (m *Memory) GetMessage(ctx context.Context, id string) (*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			c := *msg
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) EditMessage(ctx context.Context, id, body string, keepHistory bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.messages {
+		if msg.ID != id || msg.DeletedAt != nil {
+			continue
+		}
+		now := time.Now()
+		if keepHistory {
+			m.edits = append(m.edits, &MessageEdit{ID: "edit-" + ids.New(), MessageID: id, Body: msg.Body, EditedAt: now})
+		}
+		msg.Body = body
+		msg.EditedAt = &now
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *Memory) DeleteMessage(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.messages {
+		if msg.ID != id || msg.DeletedAt != nil {
+			continue
+		}
+		now := time.Now()
+		msg.Body = ""
+		msg.DeletedAt = &now
+		m.dropEditsLocked(func(e *MessageEdit) bool { return e.MessageID == id })
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *Memory) ListMessageEdits(ctx context.Context, messageID string) ([]*MessageEdit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var edits []*MessageEdit
+	for _, e := range m.edits {
+		if e.MessageID == messageID {
+			c := *e
+			edits = append(edits, &c)
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].EditedAt.Before(edits[j].EditedAt) })
+	return edits, nil
+}
+
+func (m *Memory) DeleteMessageEditsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropEditsLocked(func(e *MessageEdit) bool { return e.EditedAt.Before(before) }), nil
+}
+
+// dropEditsLocked удаляет подходящие прежние тексты и возвращает их число
+func (m *Memory) dropEditsLocked(match func(*MessageEdit) bool) int64 {
+	var n int64
+	kept := m.edits[:0]
+	for _, e := range m.edits {
+		if match(e) {
+			n++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	m.edits = kept
+	return n
+}
+
+func (m *Memory) ListInactiveConversations(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := make(map[string]time.Time)
+	for _, msg := range m.messages {
+		if msg.CreatedAt.After(last[msg.ConversationID]) {
+			last[msg.ConversationID] = msg.CreatedAt
+		}
+	}
+	var ids []string
+	for id, at := range last {
+		if at.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return last[ids[i]].Before(last[ids[j]]) })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (m *Memory) CreateMessageArchive(ctx context.Context, archive *MessageArchive, messageIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if archive.CreatedAt.IsZero() {
+		archive.CreatedAt = time.Now()
+	}
+	for _, existing := range m.archives {
+		if existing.ID == archive.ID {
+			return fmt.Errorf("failed to create message archive: duplicate id %s", archive.ID)
+		}
+	}
+	archived := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		archived[id] = true
+	}
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if msg.ConversationID != archive.ConversationID || !archived[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	m.messages = kept
+	c := *archive
+	m.archives = append(m.archives, &c)
+	return nil
+}
+
+func (m *Memory) ListMessageArchives(ctx context.Context, conversationID string) ([]*MessageArchive, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var archives []*MessageArchive
+	for _, a := range m.archives {
+		if a.ConversationID == conversationID {
+			c := *a
+			archives = append(archives, &c)
+		}
+	}
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].LastAt.After(archives[j].LastAt) })
+	return archives, nil
+}
+
+func (m *Memory) AppendEvent(ctx context.Context, userID, eventType string, payload interface{}) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event payload: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.events[userID]
+	event := &Event{UserID: userID, Seq: int64(len(events)) + 1, Type: eventType, Payload: data, CreatedAt: time.Now()}
+	m.events[userID] = append(events, event)
+	c := *event
+	return &c, nil
+}
+
+func (m *Memory) ListEvents(ctx context.Context, userID string, afterSeq int64, limit int) ([]*Event, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 500
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []*Event
+	for _, event := range m.events[userID] {
+		if event.Seq <= afterSeq {
+			continue
+		}
+		if len(events) == limit {
+			break
+		}
+		c := *event
+		events = append(events, &c)
+	}
+	return events, nil
+}
+
+func (m *Memory) LastEventSeq(ctx context.Context, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.events[userID])), nil
+}
+
+func (m *Memory) ListFolders(ctx context.Context, userID string) ([]*folders.Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*folders.Folder
+	for _, f := range m.folders {
+		if f.UserID != userID {
+			continue
+		}
+		c := &folders.Folder{}
+		if err := clone(c, f); err != nil {
+			return nil, fmt.Errorf("failed to decode rules of folder %s: %w", f.ID, err)
+		}
+		c.UserID = userID
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Position != list[j].Position {
+			return list[i].Position < list[j].Position
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *Memory) SaveFolder(ctx context.Context, f *folders.Folder) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f.UpdatedAt = time.Now()
+	if f.ID == "" {
+		f.ID = "folder-" + ids.New()
+	} else if existing, ok := m.folders[f.ID]; !ok || existing.UserID != f.UserID {
+		return false, nil
+	}
+
+	c := &folders.Folder{}
+	if err := clone(c, f); err != nil {
+		return false, fmt.Errorf("failed to encode folder rules: %w", err)
+	}
+	c.UserID = f.UserID
+	m.folders[f.ID] = c
+	return true, nil
+}
+
+func (m *Memory) DeleteFolder(ctx context.Context, userID, folderID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[folderID]
+	if !ok || f.UserID != userID {
+		return false, nil
+	}
+	delete(m.folders, folderID)
+	for conversationID, a := range m.assignments[userID] {
+		if a.FolderID == folderID {
+			delete(m.assignments[userID], conversationID)
+		}
+	}
+	return true, nil
+}
+
+func (m *Memory) ListFolderAssignments(ctx context.Context, userID string) ([]*FolderAssignment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*FolderAssignment
+	for _, a := range m.assignments[userID] {
+		c := *a
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConversationID < list[j].ConversationID })
+	return list, nil
+}
+
+func (m *Memory) AssignFolder(ctx context.Context, userID, conversationID, folderID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byConversation := m.assignments[userID]
+	if byConversation == nil {
+		byConversation = make(map[string]*FolderAssignment)
+		m.assignments[userID] = byConversation
+	}
+	if a, ok := byConversation[conversationID]; ok && a.FolderID == folderID {
+		return false, nil
+	}
+	byConversation[conversationID] = &FolderAssignment{ConversationID: conversationID, FolderID: folderID, AssignedAt: time.Now()}
+	return true, nil
+}
+
+// Квитанции доставки
+
+func (m *Memory) CreateReceipts(ctx context.Context, messageID, senderID string, recipients []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byUser := m.receipts[messageID]
+	if byUser == nil {
+		byUser = make(map[string]*MessageReceipt)
+		m.receipts[messageID] = byUser
+	}
+	now := time.Now()
+	for _, userID := range recipients {
+		if _, ok := byUser[userID]; !ok {
+			byUser[userID] = &MessageReceipt{MessageID: messageID, UserID: userID, SenderID: senderID, Status: ReceiptSent, SentAt: now}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpdateReceipt(ctx context.Context, messageID, userID, status string, at time.Time) (*MessageReceipt, bool, error) {
+	if status != ReceiptDelivered && status != ReceiptRead {
+		return nil, false, fmt.Errorf("invalid receipt status %q", status)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	receipt, ok := m.receipts[messageID][userID]
+	if !ok {
+		return nil, false, fmt.Errorf("receipt not found")
+	}
+	changed := false
+	switch {
+	case status == ReceiptDelivered && receipt.Status == ReceiptSent:
+		receipt.Status, receipt.DeliveredAt = ReceiptDelivered, at
+		changed = true
+	case status == ReceiptRead && receipt.Status != ReceiptRead:
+		receipt.Status, receipt.ReadAt = ReceiptRead, at
+		if receipt.DeliveredAt.IsZero() {
+			receipt.DeliveredAt = at
+		}
+		changed = true
+	}
+	c := *receipt
+	return &c, changed, nil
+}
+
+func (m *Memory) ListReceipts(ctx context.Context, messageID string) ([]*MessageReceipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var receipts []*MessageReceipt
+	for _, receipt := range m.receipts[messageID] {
+		c := *receipt
+		receipts = append(receipts, &c)
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].UserID < receipts[j].UserID })
+	return receipts, nil
+}
+
+// Сроки хранения истории
+
+func (m *Memory) GetRetention(ctx context.Context, conversationID string) (*RetentionPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.retention[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	c := *p
+	return &c, nil
+}
+
+func (m *Memory) ListRetention(ctx context.Context) ([]*RetentionPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var policies []*RetentionPolicy
+	for _, p := range m.retention {
+		c := *p
+		policies = append(policies, &c)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].ConversationID < policies[j].ConversationID })
+	return policies, nil
+}
+
+func (m *Memory) SetRetention(ctx context.Context, p *RetentionPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+	if p.TTL <= 0 {
+		delete(m.retention, p.ConversationID)
+		return nil
+	}
+	c := *p
+	c.TTL = c.TTL.Truncate(time.Second)
+	m.retention[p.ConversationID] = &c
+	return nil
+}
+
+func (m *Memory) DeleteMessagesBefore(ctx context.Context, conversationID string, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if msg.ConversationID == conversationID && msg.CreatedAt.Before(before) {
+			delete(m.receipts, msg.ID)
+			id := msg.ID
+			m.dropEditsLocked(func(e *MessageEdit) bool { return e.MessageID == id })
+			n++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	m.messages = kept
+	return n, nil
+}
+
+func (m *Memory) ListAttachmentsBefore(ctx context.Context, conversationID string, before time.Time) ([]*Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var attachments []*Attachment
+	for _, a := range m.attachments {
+		if a.ConversationID == conversationID && a.CreatedAt.Before(before) {
+			c := *a
+			attachments = append(attachments, &c)
+		}
+	}
+	return attachments, nil
+}
+
+func (m *Memory) DeleteMessageArchive(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.archives[:0]
+	for _, a := range m.archives {
+		if a.ID != id {
+			kept = append(kept, a)
+		}
+	}
+	m.archives = kept
+	return nil
+}
+
+func (m *Memory) CreateVoiceFile(ctx context.Context, v *VoiceFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	if _, ok := m.voiceFiles[v.ID]; ok {
+		return fmt.Errorf("failed to create voice file: duplicate id %s", v.ID)
+	}
+	c := *v
+	m.voiceFiles[v.ID] = &c
+	return nil
+}
+
+func (m *Memory) ListVoiceFilesBefore(ctx context.Context, conversationID string, before time.Time) ([]*VoiceFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var files []*VoiceFile
+	for _, v := range m.voiceFiles {
+		if v.ConversationID == conversationID && v.CreatedAt.Before(before) {
+			c := *v
+			files = append(files, &c)
+		}
+	}
+	return files, nil
+}
+
+func (m *Memory) DeleteVoiceFile(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.voiceFiles, id)
+	return nil
+}
+
+// Вложения
+
+func (m *Memory) CreateAttachment(ctx context.Context, a *Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a.ID == "" {
+		a.ID = "file-" + ids.New()
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	if _, ok := m.attachments[a.ID]; ok {
+		return fmt.Errorf("failed to create attachment: duplicate id %s", a.ID)
+	}
+	c := *a
+	m.attachments[a.ID] = &c
+	return nil
+}
+
+func (m *Memory) GetAttachment(ctx context.Context, id string) (*Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attachments[id]
+	if !ok {
+		return nil, fmt.Errorf("attachment not found")
+	}
+	c := *a
+	return &c, nil
+}
+
+func (m *Memory) CompleteAttachment(ctx context.Context, id, sha256 string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.attachments[id]; ok {
+		a.Complete = true
+		a.SHA256 = sha256
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAttachment(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attachments, id)
+	return nil
+}
+
+func (m *Memory) ListStaleUploads(ctx context.Context, before time.Time) ([]*Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var attachments []*Attachment
+	for _, a := range m.attachments {
+		if !a.Complete && a.CreatedAt.Before(before) {
+			c := *a
+			attachments = append(attachments, &c)
+		}
+	}
+	return attachments, nil
+}
+
+// Входящие, принятые транспортами
+
+func (m *Memory) DeliverInbox(ctx context.Context, msg *InboxMessage) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.inbox {
+		if existing.UserID == msg.UserID && existing.MessageID == msg.MessageID {
+			return false, nil
+		}
+	}
+	msg.ID = "inbox-" + ids.New()
+	msg.ReceivedAt = time.Now()
+	m.inbox = append(m.inbox, &memInbox{InboxMessage: *msg})
+	return true, nil
+}
+
+func (m *Memory) ListInbox(ctx context.Context, userID string, limit int) ([]*InboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*InboxMessage
+	for _, msg := range m.inbox {
+		if len(messages) == limit {
+			break
+		}
+		if msg.UserID == userID && msg.ackedAt.IsZero() {
+			c := msg.InboxMessage
+			messages = append(messages, &c)
+		}
+	}
+	return messages, nil
+}
+
+func (m *Memory) AckInbox(ctx context.Context, userID string, messageIDs []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	acked := 0
+	for _, id := range messageIDs {
+		for _, msg := range m.inbox {
+			if msg.ID == id && msg.UserID == userID && msg.ackedAt.IsZero() {
+				msg.ackedAt = now
+				acked++
+			}
+		}
+	}
+	return acked, nil
+}
+
+func (m *Memory) DeleteAckedInbox(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	kept := m.inbox[:0]
+	for _, msg := range m.inbox {
+		if !msg.ackedAt.IsZero() && msg.ackedAt.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	m.inbox = kept
+	return n, nil
+}
+
+// Исходящие режима обслуживания
+
+func (m *Memory) EnqueueOutbox(ctx context.Context, msg *OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.ID = m.nextSerial("outbox")
+	msg.CreatedAt = time.Now()
+	c := *msg
+	m.outbox = append(m.outbox, &c)
+	return nil
+}
+
+func (m *Memory) ListOutbox(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*OutboxMessage
+	for _, msg := range m.outbox {
+		if len(messages) == limit {
+			break
+		}
+		c := *msg
+		messages = append(messages, &c)
+	}
+	return messages, nil
+}
+
+func (m *Memory) DeleteOutbox(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, msg := range m.outbox {
+		if msg.ID == id {
+			m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) CountOutbox(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.outbox), nil
+}
+
+// Квоты групп и записи звонков
+
+func (m *Memory) GetQuota(ctx context.Context, groupID string) (*Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.quotas[groupID]
+	if !ok {
+		return nil, nil
+	}
+	c := *q
+	c.Admins = copyStrings(q.Admins)
+	return &c, nil
+}
+
+func (m *Memory) ListQuotas(ctx context.Context) ([]*Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var quotas []*Quota
+	for _, q := range m.quotas {
+		c := *q
+		c.Admins = copyStrings(q.Admins)
+		quotas = append(quotas, &c)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].GroupID < quotas[j].GroupID })
+	return quotas, nil
+}
+
+func (m *Memory) SetQuota(ctx context.Context, q *Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *q
+	// Администраторы хранятся списком через запятую: пустой список читается как nil
+	c.Admins = splitList(strings.Join(q.Admins, ","))
+	m.quotas[q.GroupID] = &c
+	return nil
+}
+
+func (m *Memory) DeleteQuota(ctx context.Context, groupID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.quotas[groupID]
+	delete(m.quotas, groupID)
+	return ok, nil
+}
+
+func (m *Memory) GetQuotaUsage(ctx context.Context, groupID string) (*QuotaUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := &QuotaUsage{Messages: int64(len(m.conversationMessages(groupID)))}
+	for _, rec := range m.recordings {
+		if rec.RoomID == groupID {
+			usage.MediaBytes += rec.SizeBytes
+		}
+	}
+	for _, a := range m.attachments {
+		if a.ConversationID == groupID {
+			usage.MediaBytes += a.Size
+		}
+	}
+	return usage, nil
+}
+
+func (m *Memory) DeleteOldestMessages(ctx context.Context, conversationID string, keep int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	messages := m.conversationMessages(conversationID)
+	if int64(len(messages)) <= keep {
+		return 0, nil
+	}
+	evict := make(map[*Message]bool)
+	for _, msg := range messages[:int64(len(messages))-keep] {
+		evict[msg] = true
+	}
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if !evict[msg] {
+			kept = append(kept, msg)
+		}
+	}
+	m.messages = kept
+	return int64(len(evict)), nil
+}
+
+func (m *Memory) CreateRecording(ctx context.Context, rec *Recording) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.recordings[rec.ID]; ok {
+		return fmt.Errorf("failed to create recording: duplicate id %s", rec.ID)
+	}
+	c := *rec
+	c.Participants = splitList(strings.Join(rec.Participants, ","))
+	c.Users = splitList(strings.Join(rec.Users, ","))
+	m.recordings[rec.ID] = &c
+	return nil
+}
+
+func (m *Memory) GetRecording(ctx context.Context, id string) (*Recording, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.recordings[id]
+	if !ok {
+		return nil, fmt.Errorf("recording not found")
+	}
+	c := *rec
+	c.Participants = copyStrings(rec.Participants)
+	c.Users = copyStrings(rec.Users)
+	return &c, nil
+}
+
+// listRecordings возвращает записи, для которых match истинно, упорядоченные less
+func (m *Memory) listRecordings(match func(*Recording) bool, less func(a, b *Recording) bool) []*Recording {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var recordings []*Recording
+	for _, rec := range m.recordings {
+		if match(rec) {
+			c := *rec
+			c.Participants = copyStrings(rec.Participants)
+			c.Users = copyStrings(rec.Users)
+			recordings = append(recordings, &c)
+		}
+	}
+	if less != nil {
+		sort.Slice(recordings, func(i, j int) bool { return less(recordings[i], recordings[j]) })
+	}
+	return recordings
+}
+
+func (m *Memory) ListRecordings(ctx context.Context) ([]*Recording, error) {
+	return m.listRecordings(func(*Recording) bool { return true },
+		func(a, b *Recording) bool { return a.StartedAt.After(b.StartedAt) }), nil
+}
+
+func (m *Memory) ListRoomRecordings(ctx context.Context, roomID string) ([]*Recording, error) {
+	return m.listRecordings(func(rec *Recording) bool { return rec.RoomID == roomID },
+		func(a, b *Recording) bool { return a.StartedAt.Before(b.StartedAt) }), nil
+}
+
+func (m *Memory) ListExpiredRecordings(ctx context.Context, now time.Time) ([]*Recording, error) {
+	return m.listRecordings(func(rec *Recording) bool { return !rec.ExpiresAt.After(now) }, nil), nil
+}
+
+func (m *Memory) DeleteRecording(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.recordings, id)
+	return nil
+}
+
+// Дайджесты и почта
+
+func (m *Memory) RecordNotification(ctx context.Context, userID, kind, conversationID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications = append(m.notifications, &memNotification{userID: userID, kind: kind, conversationID: conversationID, createdAt: time.Now()})
+	return nil
+}
+
+// ensureDigest создает настройки дайджеста по умолчанию, как ensureDigestSettings
+func (m *Memory) ensureDigest(userID string) (*DigestSettings, error) {
+	if settings, ok := m.digests[userID]; ok {
+		return settings, nil
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate mute token: %w", err)
+	}
+	settings := &DigestSettings{UserID: userID, Enabled: true, Interval: 24 * time.Hour, Privacy: true,
+		MuteToken: hex.EncodeToString(token), LastSeenAt: time.Now()}
+	m.digests[userID] = settings
+	return settings, nil
+}
+
+func (m *Memory) TouchUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings, err := m.ensureDigest(userID)
+	if err != nil {
+		return err
+	}
+	settings.LastSeenAt = time.Now()
+	return nil
+}
+
+func (m *Memory) GetDigestSettings(ctx context.Context, userID string) (*DigestSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings, err := m.ensureDigest(userID)
+	if err != nil {
+		return nil, err
+	}
+	c := *settings
+	return &c, nil
+}
+
+func (m *Memory) UpdateDigestSettings(ctx context.Context, settings *DigestSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, err := m.ensureDigest(settings.UserID)
+	if err != nil {
+		return err
+	}
+	stored.Enabled = settings.Enabled
+	stored.Interval = settings.Interval / time.Second * time.Second
+	stored.Privacy = settings.Privacy
+	return nil
+}
+
+func (m *Memory) MuteDigests(ctx context.Context, token string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, settings := range m.digests {
+		if settings.MuteToken == token {
+			settings.Enabled = false
+			return settings.UserID, nil
+		}
+	}
+	return "", fmt.Errorf("invalid mute token: %w", sql.ErrNoRows)
+}
+
+func (m *Memory) ListDigestSummaries(ctx context.Context, offlineSince, now time.Time) ([]*DigestSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*DigestSummary
+	for _, settings := range m.digests {
+		user, ok := m.users[settings.UserID]
+		if !ok || !settings.Enabled || user.Email == "" || !settings.LastSeenAt.Before(offlineSince) {
+			continue
+		}
+		if !settings.LastDigestAt.IsZero() && settings.LastDigestAt.Add(settings.Interval).After(now) {
+			continue
+		}
+		if _, deactivated := m.accountStates[settings.UserID]; deactivated {
+			continue
+		}
+
+		c := *settings
+		summary := &DigestSummary{Settings: &c, Email: user.Email}
+		m.fillDigestSummary(summary)
+		if summary.Messages > 0 || summary.MissedCalls > 0 {
+			result = append(result, summary)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Settings.UserID < result[j].Settings.UserID })
+	return result, nil
+}
+
+func (m *Memory) fillDigestSummary(summary *DigestSummary) {
+	since := summary.Settings.LastSeenAt
+	if summary.Settings.LastDigestAt.After(since) {
+		since = summary.Settings.LastDigestAt
+	}
+
+	type group struct{ kind, conversationID string }
+	counts := make(map[group]int)
+	for _, n := range m.notifications {
+		if n.userID == summary.Settings.UserID && n.createdAt.After(since) {
+			counts[group{n.kind, n.conversationID}]++
+		}
+	}
+	groups := make([]group, 0, len(counts))
+	for g := range counts {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].kind != groups[j].kind {
+			return groups[i].kind < groups[j].kind
+		}
+		return groups[i].conversationID < groups[j].conversationID
+	})
+
+	for _, g := range groups {
+		switch g.kind {
+		case NotificationMessage:
+			summary.Conversations++
+			summary.Messages += counts[g]
+			if g.conversationID != "" {
+				summary.Senders = append(summary.Senders, g.conversationID)
+			}
+		case NotificationCall:
+			summary.MissedCalls += counts[g]
+		}
+	}
+}
+
+func (m *Memory) MarkDigestSent(ctx context.Context, userID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if settings, ok := m.digests[userID]; ok {
+		settings.LastDigestAt = at
+	}
+	return nil
+}
+
+func (m *Memory) DeleteNotificationsBefore(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.notifications[:0]
+	for _, n := range m.notifications {
+		if !n.createdAt.Before(before) {
+			kept = append(kept, n)
+		}
+	}
+	m.notifications = kept
+	return nil
+}
+
+func (m *Memory) EnqueueMail(ctx context.Context, recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mail := &memMail{QueuedMail: QueuedMail{ID: m.nextSerial("mail_queue"), Recipient: recipient, Subject: subject, Body: body}, nextAttemptAt: time.Now()}
+	m.mail = append(m.mail, mail)
+	return nil
+}
+
+func (m *Memory) ListPendingMail(ctx context.Context, now time.Time, limit int) ([]*QueuedMail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var mails []*QueuedMail
+	for _, mail := range m.mail {
+		if len(mails) == limit {
+			break
+		}
+		if mail.sentAt.IsZero() && mail.Attempts < maxMailAttempts && !mail.nextAttemptAt.After(now) {
+			c := mail.QueuedMail
+			mails = append(mails, &c)
+		}
+	}
+	return mails, nil
+}
+
+// findMail возвращает письмо очереди; nil - письма нет
+func (m *Memory) findMail(id int64) *memMail {
+	for _, mail := range m.mail {
+		if mail.ID == id {
+			return mail
+		}
+	}
+	return nil
+}
+
+func (m *Memory) MarkMailSent(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mail := m.findMail(id); mail != nil {
+		mail.sentAt, mail.lastError = time.Now(), ""
+	}
+	return nil
+}
+
+func (m *Memory) MarkMailFailed(ctx context.Context, id int64, sendErr error, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mail := m.findMail(id); mail != nil {
+		mail.Attempts++
+		mail.lastError, mail.nextAttemptAt = sendErr.Error(), retryAt
+	}
+	return nil
+}
+
+func (m *Memory) DeleteSentMailBefore(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.mail[:0]
+	for _, mail := range m.mail {
+		sent := !mail.sentAt.IsZero() && mail.sentAt.Before(before)
+		failed := mail.Attempts >= maxMailAttempts && mail.nextAttemptAt.Before(before)
+		if !sent && !failed {
+			kept = append(kept, mail)
+		}
+	}
+	m.mail = kept
+	return nil
+}
+
+// Доставка SMS
+
+func (m *Memory) RecordSMS(ctx context.Context, msg *SMSMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.CreatedAt = time.Now()
+	msg.UpdatedAt = msg.CreatedAt
+	c := *msg
+	m.sms = append(m.sms, &c)
+	return nil
+}
+
+func (m *Memory) UpdateSMSStatus(ctx context.Context, provider, providerID, status, sendErr string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.sms {
+		if msg.Provider == provider && msg.ProviderID == providerID {
+			if sms.Final(msg.Status) {
+				return false, nil
+			}
+			msg.Status, msg.Error, msg.UpdatedAt = status, sendErr, time.Now()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) GetSMS(ctx context.Context, provider, providerID string) (*SMSMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.sms {
+		if msg.Provider == provider && msg.ProviderID == providerID {
+			c := *msg
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("SMS not found")
+}
+
+func (m *Memory) DeleteSMSBefore(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sms = slices.DeleteFunc(m.sms, func(msg *SMSMessage) bool { return msg.CreatedAt.Before(before) })
+	return nil
+}
+
+// ICE серверы, транспорты и mesh
+
+func (m *Memory) CreateICEServer(ctx context.Context, server *ICEServer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if server.ID == "" {
+		server.ID = "ice-" + ids.New()
+	}
+	if _, ok := m.ice[server.ID]; ok {
+		return fmt.Errorf("failed to create ICE server: duplicate id %s", server.ID)
+	}
+	m.ice[server.ID] = &ICEServer{ID: server.ID, URL: server.URL, Username: server.Username, Credential: server.Credential,
+		Secret: server.Secret, Priority: server.Priority, Enabled: server.Enabled, Healthy: true}
+	return nil
+}
+
+func (m *Memory) UpdateICEServer(ctx context.Context, server *ICEServer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.ice[server.ID]
+	if !ok {
+		return fmt.Errorf("ICE server not found")
+	}
+	stored.URL, stored.Username, stored.Credential, stored.Secret = server.URL, server.Username, server.Credential, server.Secret
+	stored.Priority, stored.Enabled = server.Priority, server.Enabled
+	return nil
+}
+
+func (m *Memory) DeleteICEServer(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ice, id)
+	return nil
+}
+
+func (m *Memory) GetICEServer(ctx context.Context, id string) (*ICEServer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	server, ok := m.ice[id]
+	if !ok {
+		return nil, fmt.Errorf("ICE server not found: %w", sql.ErrNoRows)
+	}
+	c := *server
+	return &c, nil
+}
+
+func (m *Memory) ListICEServers(ctx context.Context) ([]*ICEServer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var servers []*ICEServer
+	for _, server := range m.ice {
+		c := *server
+		servers = append(servers, &c)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Priority != servers[j].Priority {
+			return servers[i].Priority < servers[j].Priority
+		}
+		return servers[i].LatencyMs < servers[j].LatencyMs
+	})
+	return servers, nil
+}
+
+func (m *Memory) UpdateICEServerHealth(ctx context.Context, id string, healthy bool, latency time.Duration, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if server, ok := m.ice[id]; ok {
+		server.Healthy, server.LatencyMs, server.CheckedAt, server.LastError = healthy, latency.Milliseconds(), time.Now(), lastError
+	}
+	return nil
+}
+
+func (m *Memory) ListTransportBlackouts(ctx context.Context) ([]transport.Blackout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var blackouts []transport.Blackout
+	for _, b := range m.blackouts {
+		b.Transports = copyStrings(b.Transports)
+		b.Days = append([]time.Weekday(nil), b.Days...)
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, nil
+}
+
+func (m *Memory) CreateTransportBlackout(ctx context.Context, b *transport.Blackout) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b.ID == "" {
+		b.ID = "blackout-" + ids.New()
+	}
+	for _, existing := range m.blackouts {
+		if existing.ID == b.ID {
+			return fmt.Errorf("failed to create transport blackout: duplicate id %s", b.ID)
+		}
+	}
+	c := *b
+	// Транспорты хранятся списком через запятую: пустой список читается как [""]
+	c.Transports = strings.Split(strings.Join(b.Transports, ","), ",")
+	c.Days = append([]time.Weekday(nil), b.Days...)
+	m.blackouts = append(m.blackouts, c)
+	return nil
+}
+
+func (m *Memory) DeleteTransportBlackout(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, b := range m.blackouts {
+		if b.ID == id {
+			m.blackouts = append(m.blackouts[:i], m.blackouts[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) CreateTransportEvent(ctx context.Context, event *TransportEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event.ID = m.nextSerial("transport_events")
+	c := *event
+	m.transportEvents = append(m.transportEvents, &c)
+	return nil
+}
+
+func (m *Memory) ListTransportEvents(ctx context.Context, domain string, limit int) ([]*TransportEvent, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []*TransportEvent
+	for _, event := range m.transportEvents {
+		if domain == "" || event.Domain == domain {
+			c := *event
+			events = append(events, &c)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.After(events[j].CreatedAt) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *Memory) GetDomainBlockStats(ctx context.Context, since time.Time) ([]*DomainBlockStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Блокировки считаются после последнего восстановления домена, но не раньше since
+	after := make(map[string]time.Time)
+	for _, event := range m.transportEvents {
+		if event.Class == "recovered" && event.CreatedAt.After(after[event.Domain]) {
+			after[event.Domain] = event.CreatedAt
+		}
+	}
+
+	byDomain := make(map[string]*DomainBlockStats)
+	var stats []*DomainBlockStats
+	for _, event := range m.transportEvents {
+		threshold, recovered := after[event.Domain]
+		if !recovered {
+			threshold = since
+		}
+		if event.Class != "blocked" || event.CreatedAt.Before(since) || !event.CreatedAt.After(threshold) {
+			continue
+		}
+		st := byDomain[event.Domain]
+		if st == nil {
+			st = &DomainBlockStats{Domain: event.Domain}
+			byDomain[event.Domain] = st
+			stats = append(stats, st)
+		}
+		st.Blocks++
+		if event.CreatedAt.After(st.LastBlocked) {
+			st.LastBlocked = event.CreatedAt
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Blocks > stats[j].Blocks })
+	return stats, nil
+}
+
+func (m *Memory) DeleteTransportEventsBefore(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.transportEvents[:0]
+	for _, event := range m.transportEvents {
+		if !event.CreatedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	m.transportEvents = kept
+	return nil
+}
+
+func (m *Memory) ListStaticPeers(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyStrings(m.staticPeers), nil
+}
+
+func (m *Memory) AddStaticPeer(ctx context.Context, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, peer := range m.staticPeers {
+		if peer == addr {
+			return nil
+		}
+	}
+	m.staticPeers = append(m.staticPeers, addr)
+	return nil
+}
+
+func (m *Memory) RemoveStaticPeer(ctx context.Context, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, peer := range m.staticPeers {
+		if peer == addr {
+			m.staticPeers = append(m.staticPeers[:i], m.staticPeers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Отчеты клиентов об ошибках
+
+func (m *Memory) CreateClientError(ctx context.Context, report *ClientError) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report.ID = m.nextSerial("client_errors")
+	report.CreatedAt = time.Now()
+	c := *report
+	m.clientErrors = append(m.clientErrors, &c)
+	return nil
+}
+
+func (m *Memory) ListClientErrors(ctx context.Context, kind string, limit int) ([]*ClientError, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reports []*ClientError
+	for i := len(m.clientErrors) - 1; i >= 0 && len(reports) < limit; i-- {
+		if r := m.clientErrors[i]; kind == "" || r.Kind == kind {
+			c := *r
+			reports = append(reports, &c)
+		}
+	}
+	return reports, nil
+}
+
+func (m *Memory) GetClientErrorStats(ctx context.Context, since time.Time, limit int) ([]*ClientErrorStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type group struct{ kind, message string }
+	byGroup := make(map[group]*ClientErrorStats)
+	var stats []*ClientErrorStats
+	for _, r := range m.clientErrors {
+		if r.CreatedAt.Before(since) {
+			continue
+		}
+		st := byGroup[group{r.Kind, r.Message}]
+		if st == nil {
+			st = &ClientErrorStats{Kind: r.Kind, Message: r.Message}
+			byGroup[group{r.Kind, r.Message}] = st
+			stats = append(stats, st)
+		}
+		st.Count++
+		if r.CreatedAt.After(st.LastSeen) {
+			st.LastSeen = r.CreatedAt
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Count > stats[j].Count })
+	if limit >= 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats, nil
+}
+
+func (m *Memory) DeleteClientErrorsBefore(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.clientErrors[:0]
+	for _, r := range m.clientErrors {
+		if !r.CreatedAt.Before(before) {
+			kept = append(kept, r)
+		}
+	}
+	m.clientErrors = kept
+	return nil
+}
+
+// Роли, аудит и выгрузка метаданных
+
+func (m *Memory) GrantRole(ctx context.Context, userID, role, grantedBy string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hasRole(userID, role) {
+		return false, nil
+	}
+	m.roles = append(m.roles, &RoleGrant{UserID: userID, Role: role, GrantedBy: grantedBy, GrantedAt: time.Now()})
+	return true, nil
+}
+
+func (m *Memory) RevokeRole(ctx context.Context, userID, role string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, g := range m.roles {
+		if g.UserID == userID && g.Role == role {
+			m.roles = append(m.roles[:i], m.roles[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) hasRole(userID, role string) bool {
+	for _, g := range m.roles {
+		if g.UserID == userID && g.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) HasRole(ctx context.Context, userID, role string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hasRole(userID, role), nil
+}
+
+func (m *Memory) ListRoles(ctx context.Context) ([]*RoleGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var grants []*RoleGrant
+	for _, g := range m.roles {
+		c := *g
+		grants = append(grants, &c)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].UserID != grants[j].UserID {
+			return grants[i].UserID < grants[j].UserID
+		}
+		return grants[i].Role < grants[j].Role
+	})
+	return grants, nil
+}
+
+// Администрирование
+
+func (m *Memory) DisableUser(ctx context.Context, userID, disabledBy, reason string) (*DisabledAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.disabled[userID]
+	if !ok {
+		d = &DisabledAccount{UserID: userID, DisabledBy: disabledBy, DisabledAt: time.Now()}
+		m.disabled[userID] = d
+	}
+	d.Reason = reason
+	c := *d
+	return &c, nil
+}
+
+func (m *Memory) EnableUser(ctx context.Context, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.disabled[userID]
+	delete(m.disabled, userID)
+	return ok, nil
+}
+
+func (m *Memory) GetDisabledAccount(ctx context.Context, userID string) (*DisabledAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.disabled[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *d
+	return &c, nil
+}
+
+func (m *Memory) ListUserSummaries(ctx context.Context, after string, limit int) ([]*UserSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var users []*UserSummary
+	for _, u := range m.users {
+		if u.ID <= after {
+			continue
+		}
+		summary := &UserSummary{User: *u, IsAdmin: m.hasRole(u.ID, RoleAdmin), Deactivated: m.accountStates[u.ID] != nil}
+		summary.Password = ""
+		if d, ok := m.disabled[u.ID]; ok {
+			c := *d
+			summary.Disabled = &c
+		}
+		users = append(users, summary)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *Memory) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := 0
+	for _, list := range m.events {
+		events += len(list)
+	}
+	stats := &StorageStats{Tables: map[string]int64{
+		"users":              int64(len(m.users)),
+		"sessions":           int64(len(m.sessions)),
+		"devices":            int64(len(m.devices)),
+		"messages":           int64(len(m.messages)),
+		"chat_groups":        int64(len(m.groups)),
+		"attachments":        int64(len(m.attachments)),
+		"message_archives":   int64(len(m.archives)),
+		"invites":            int64(len(m.invites)),
+		"inbox":              int64(len(m.inbox)),
+		"outbox":             int64(len(m.outbox)),
+		"user_events":        int64(events),
+		"push_subscriptions": int64(len(m.push)),
+		"avatars":            int64(len(m.avatars)),
+		"transport_events":   int64(len(m.transportEvents)),
+		"recordings":         int64(len(m.recordings)),
+		"audit_log":          int64(len(m.audit)),
+	}}
+	for _, a := range m.attachments {
+		stats.AttachmentBytes += a.Size
+	}
+	return stats, nil
+}
+
+// Блокировки и жалобы
+
+func (m *Memory) BlockUser(ctx context.Context, userID, blockedID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isBlocked(userID, blockedID) {
+		return false, nil
+	}
+	m.blocks = append(m.blocks, &Block{UserID: userID, BlockedID: blockedID, CreatedAt: time.Now()})
+	return true, nil
+}
+
+func (m *Memory) UnblockUser(ctx context.Context, userID, blockedID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, b := range m.blocks {
+		if b.UserID == userID && b.BlockedID == blockedID {
+			m.blocks = append(m.blocks[:i], m.blocks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) ListBlocks(ctx context.Context, userID string) ([]*Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var blocks []*Block
+	for i := len(m.blocks) - 1; i >= 0; i-- {
+		if b := m.blocks[i]; b.UserID == userID {
+			c := *b
+			blocks = append(blocks, &c)
+		}
+	}
+	return blocks, nil
+}
+
+func (m *Memory) isBlocked(userID, senderID string) bool {
+	for _, b := range m.blocks {
+		if b.UserID == userID && b.BlockedID == senderID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) IsBlocked(ctx context.Context, userID, senderID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isBlocked(userID, senderID), nil
+}
+
+func (m *Memory) CreateAbuseReport(ctx context.Context, report *AbuseReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report.ID = "report-" + ids.New()
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now()
+	c := *report
+	m.reports = append(m.reports, &c)
+	return nil
+}
+
+func (m *Memory) ListAbuseReports(ctx context.Context, status string, limit int) ([]*AbuseReport, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var reports []*AbuseReport
+	for i := len(m.reports) - 1; i >= 0 && len(reports) < limit; i-- {
+		if r := m.reports[i]; status == "" || r.Status == status {
+			c := *r
+			reports = append(reports, &c)
+		}
+	}
+	return reports, nil
+}
+
+func (m *Memory) ResolveAbuseReport(ctx context.Context, id, resolvedBy, resolution string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.reports {
+		if r.ID == id && r.Status == ReportOpen {
+			r.Status = ReportResolved
+			r.ResolvedAt = time.Now()
+			r.ResolvedBy = resolvedBy
+			r.Resolution = resolution
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Удаление аккаунта
+
+func (m *Memory) PurgeUser(ctx context.Context, userID string) (*ErasedAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get user: %w", sql.ErrNoRows)
+	}
+	erased := &ErasedAccount{UserID: userID}
+
+	// Сообщения с правками и квитанциями
+	sent := make(map[string]bool)
+	m.messages = slices.DeleteFunc(m.messages, func(msg *Message) bool {
+		if msg.SenderID == userID {
+			sent[msg.ID] = true
+			erased.Messages++
+		}
+		return sent[msg.ID]
+	})
+	m.edits = slices.DeleteFunc(m.edits, func(e *MessageEdit) bool { return sent[e.MessageID] })
+	for messageID, receipts := range m.receipts {
+		for recipient, receipt := range receipts {
+			if recipient == userID || receipt.SenderID == userID {
+				delete(receipts, recipient)
+			}
+		}
+		if len(receipts) == 0 {
+			delete(m.receipts, messageID)
+		}
+	}
+
+	// Файлы
+	for id, a := range m.attachments {
+		if a.OwnerID == userID {
+			erased.AttachmentKeys = append(erased.AttachmentKeys, a.Key)
+			delete(m.attachments, id)
+		}
+	}
+	for id, v := range m.voiceFiles {
+		if v.SenderID == userID {
+			erased.VoicePaths = append(erased.VoicePaths, v.Path)
+			delete(m.voiceFiles, id)
+		}
+	}
+
+	// Доставка и журнал событий
+	m.inbox = slices.DeleteFunc(m.inbox, func(msg *memInbox) bool { return msg.UserID == userID || msg.SenderID == userID })
+	m.queued = slices.DeleteFunc(m.queued, func(msg *QueuedMessage) bool { return msg.UserID == userID || msg.SenderID == userID })
+	m.outbox = slices.DeleteFunc(m.outbox, func(msg *OutboxMessage) bool { return msg.SenderID == userID || msg.RecipientID == userID })
+	m.notifications = slices.DeleteFunc(m.notifications, func(n *memNotification) bool { return n.userID == userID })
+	delete(m.events, userID)
+
+	// Сессии, устройства и ключи
+	for id, sess := range m.sessions {
+		if sess.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	delete(m.revocations, userID)
+	m.identities = slices.DeleteFunc(m.identities, func(identity *ExternalIdentity) bool { return identity.UserID == userID })
+	for hash, link := range m.deviceLinks {
+		if link.UserID == userID {
+			delete(m.deviceLinks, hash)
+		}
+	}
+	for id, device := range m.devices {
+		if device.UserID == userID {
+			delete(m.devices, id)
+		}
+	}
+	for key := range m.deviceKeys {
+		if key.userID == userID {
+			delete(m.deviceKeys, key)
+		}
+	}
+	for key := range m.prekeys {
+		if key.userID == userID {
+			delete(m.prekeys, key)
+		}
+	}
+	for key := range m.push {
+		if key.userID == userID {
+			delete(m.push, key)
+		}
+	}
+
+	// Настройки и состояние аккаунта
+	delete(m.accountStates, userID)
+	delete(m.disabled, userID)
+	delete(m.lookup, userID)
+	for username, r := range m.released {
+		if r.userID == userID {
+			delete(m.released, username)
+		}
+	}
+	delete(m.digests, userID)
+	delete(m.trust, userID)
+	delete(m.idChanges, userID)
+	delete(m.guardians, userID)
+	delete(m.avatars, userID)
+	delete(m.locales, userID)
+	delete(m.assignments, userID)
+	for id, req := range m.recoveries {
+		if req.UserID == userID {
+			delete(m.approvals, id)
+			delete(m.recoveries, id)
+		}
+	}
+	for id, approvals := range m.approvals {
+		m.approvals[id] = slices.DeleteFunc(approvals, func(a *recovery.Approval) bool { return a.GuardianID == userID })
+	}
+	for id, f := range m.folders {
+		if f.UserID == userID {
+			delete(m.folders, id)
+		}
+	}
+	m.members = slices.DeleteFunc(m.members, func(member *GroupMember) bool { return member.UserID == userID })
+	m.roles = slices.DeleteFunc(m.roles, func(g *RoleGrant) bool { return g.UserID == userID })
+	m.blocks = slices.DeleteFunc(m.blocks, func(b *Block) bool { return b.UserID == userID || b.BlockedID == userID })
+	m.reports = slices.DeleteFunc(m.reports, func(r *AbuseReport) bool { return r.ReporterID == userID })
+
+	// Приглашения пользователя и на его адреса
+	for token, inv := range m.invites {
+		if inv.CreatedBy == userID || (inv.ContactInfo != "" && (inv.ContactInfo == user.Email || inv.ContactInfo == user.Phone)) {
+			delete(m.invites, token)
+		}
+	}
+	m.inviteUses = slices.DeleteFunc(m.inviteUses, func(u *InviteUse) bool {
+		_, ok := m.invites[u.Token]
+		return u.UserID == userID || !ok
+	})
+	if user.Email != "" {
+		delete(m.emailCodes, user.Email)
+		m.mail = slices.DeleteFunc(m.mail, func(mail *memMail) bool { return mail.Recipient == user.Email })
+	}
+	if user.Phone != "" {
+		delete(m.smsCodes, user.Phone)
+		m.sms = slices.DeleteFunc(m.sms, func(msg *SMSMessage) bool { return msg.Recipient == user.Phone })
+	}
+
+	// Ссылки в чужих данных обезличиваются
+	for _, t := range m.trust {
+		if t.InvitedBy == userID {
+			t.InvitedBy = ""
+		}
+	}
+	for _, g := range m.groups {
+		if g.CreatedBy == userID {
+			g.CreatedBy = ""
+		}
+	}
+	for _, g := range m.roles {
+		if g.GrantedBy == userID {
+			g.GrantedBy = ""
+		}
+	}
+
+	delete(m.users, userID)
+	return erased, nil
+}
+
+func (m *Memory) AppendAudit(ctx context.Context, actor, action, target string, details interface{}) (*AuditEntry, error) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &AuditEntry{ID: int64(len(m.audit)) + 1, Actor: actor, Action: action, Target: target, Details: data, CreatedAt: time.Now().UnixMilli()}
+	if len(m.audit) > 0 {
+		e.PrevHash = m.audit[len(m.audit)-1].Hash
+	}
+	e.Hash = auditHash(e)
+	m.audit = append(m.audit, e)
+	c := *e
+	return &c, nil
+}
+
+func (m *Memory) ListAudit(ctx context.Context, afterID int64, limit int) ([]*AuditEntry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []*AuditEntry
+	for _, e := range m.audit {
+		if e.ID > afterID && len(entries) < limit {
+			c := *e
+			entries = append(entries, &c)
+		}
+	}
+	return entries, nil
+}
+
+// ComplianceSnapshot выгружает те же разделы и колонки, что Storage.ComplianceSnapshot
+func (m *Memory) ComplianceSnapshot(ctx context.Context, userIDs []string) (time.Time, []compliance.Section, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshotAt := time.Now()
+
+	accounts := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		accounts[id] = true
+	}
+	ts := func(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+	rows := map[string][]map[string]interface{}{}
+	add := func(section string, row map[string]interface{}) {
+		rows[section] = append(rows[section], row)
+	}
+
+	for _, id := range sortedKeys(m.users) {
+		if u := m.users[id]; accounts[id] {
+			add("accounts", map[string]interface{}{"id": u.ID, "name": u.Name, "email": u.Email, "phone": u.Phone})
+		}
+	}
+	for _, id := range sortedKeys(m.accountStates) {
+		if st := m.accountStates[id]; accounts[id] {
+			add("account_states", map[string]interface{}{"user_id": id, "inbound_mode": st.InboundMode, "deactivated_at": ts(st.DeactivatedAt)})
+		}
+	}
+	for _, id := range sortedKeys(m.lookup) {
+		if p := m.lookup[id]; accounts[id] {
+			var username interface{}
+			if p.Username != "" {
+				username = p.Username
+			}
+			add("lookup_profiles", map[string]interface{}{"user_id": id, "username": username, "discoverable": p.Discoverable})
+		}
+	}
+	for _, id := range sortedKeys(m.trust) {
+		if t := m.trust[id]; accounts[id] {
+			add("trust", map[string]interface{}{"user_id": id, "level": t.Level, "invited_by": t.InvitedBy,
+				"created_at": ts(t.CreatedAt), "updated_at": ts(t.UpdatedAt)})
+		}
+	}
+	devices := make([]*Device, 0)
+	for _, d := range m.devices {
+		if accounts[d.UserID] {
+			devices = append(devices, d)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].UserID != devices[j].UserID {
+			return devices[i].UserID < devices[j].UserID
+		}
+		return devices[i].ID < devices[j].ID
+	})
+	for _, d := range devices {
+		add("devices", map[string]interface{}{"id": d.ID, "user_id": d.UserID, "name": d.Name, "updated_at": ts(d.UpdatedAt)})
+	}
+	for _, id := range sortedKeys(m.guardians) {
+		if g := m.guardians[id]; accounts[id] {
+			add("recovery_guardians", map[string]interface{}{"user_id": id, "guardians": strings.Join(g.Guardians, ","),
+				"threshold": g.Threshold, "updated_at": ts(g.UpdatedAt)})
+		}
+	}
+
+	messages := make([]*Message, 0)
+	for _, msg := range m.messages {
+		if accounts[msg.SenderID] || accounts[msg.ConversationID] {
+			messages = append(messages, msg)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	for _, msg := range messages {
+		add("messages", map[string]interface{}{"id": msg.ID, "conversation_id": msg.ConversationID, "sender_id": msg.SenderID,
+			"type": msg.Type, "reply_to": msg.ReplyTo, "created_at": ts(msg.CreatedAt)})
+	}
+
+	recordings := m.complianceRecordings(accounts)
+	for _, rec := range recordings {
+		add("recordings", map[string]interface{}{"id": rec.ID, "room_id": rec.RoomID, "requested_by": rec.RequestedBy,
+			"participants": strings.Join(rec.Participants, ","), "size_bytes": rec.SizeBytes,
+			"started_at": ts(rec.StartedAt), "stopped_at": ts(rec.StoppedAt), "expires_at": ts(rec.ExpiresAt)})
+	}
+
+	sections := make([]compliance.Section, 0, len(complianceSections))
+	for _, section := range complianceSections {
+		list := rows[section.name]
+		if list == nil {
+			list = []map[string]interface{}{}
+		}
+		data, err := json.Marshal(list)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+		}
+		sections = append(sections, compliance.Section{Name: section.name, Rows: data})
+	}
+	return snapshotAt, sections, nil
+}
+
+// complianceRecordings - записи, заказанные аккаунтами выгрузки или с их участием
+func (m *Memory) complianceRecordings(accounts map[string]bool) []*Recording {
+	var recordings []*Recording
+	for _, rec := range m.recordings {
+		match := accounts[rec.RequestedBy]
+		for _, p := range rec.Participants {
+			match = match || accounts[p]
+		}
+		if match {
+			recordings = append(recordings, rec)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		if !recordings[i].StartedAt.Equal(recordings[j].StartedAt) {
+			return recordings[i].StartedAt.Before(recordings[j].StartedAt)
+		}
+		return recordings[i].ID < recordings[j].ID
+	})
+	return recordings
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
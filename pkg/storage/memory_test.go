@@ -0,0 +1,773 @@
+package storage
+
+import (
+	"hydra/pkg/password"
+	"hydra/pkg/sms"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMemoryMatchesSQL прогоняет одинаковый сценарий на Memory и на SQLite: поведение,
+// на которое опираются тесты сервера, должно совпадать
+func TestMemoryMatchesSQL(t *testing.T) {
+	sqlite, err := New("sqlite:" + filepath.Join(t.TempDir(), "hydra.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	for name, s := range map[string]Store{"memory": NewMemory(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) { testStoreContract(t, s) })
+	}
+}
+
+func testStoreContract(t *testing.T, s Store) {
+	alice, err := s.CreateUser(t.Context(), "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(t.Context(), "Alice 2", "secret", "alice@example.com"); err == nil {
+		t.Error("duplicate email accepted")
+	}
+	if got, err := s.GetUser(t.Context(), alice.ID); err != nil || got.Password != "" {
+		t.Errorf("GetUser: %+v, %v", got, err)
+	}
+	if got, err := s.GetUserByEmail(t.Context(), "alice@example.com"); err != nil || !password.Verify(got.Password, "secret") || got.Password == "secret" {
+		t.Errorf("GetUserByEmail: %+v, %v", got, err)
+	}
+	if _, err := s.ValidateUser(t.Context(), "alice@example.com", "wrong"); err == nil {
+		t.Error("wrong password accepted")
+	}
+	if _, err := s.ValidateUser(t.Context(), "alice@example.com", "secret"); err != nil {
+		t.Errorf("ValidateUser: %v", err)
+	}
+
+	// Код подтверждения одноразовый
+	if err := s.CreateSMSVerification(t.Context(), "+70000000001", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateSMSVerification(t.Context(), "+70000000001", "0000"); err == nil {
+		t.Error("wrong code accepted")
+	}
+	if ok, err := s.ValidateSMSVerification(t.Context(), "+70000000001", "1234"); !ok || err != nil {
+		t.Errorf("valid code rejected: %v", err)
+	}
+	if _, err := s.ValidateSMSVerification(t.Context(), "+70000000001", "1234"); err == nil {
+		t.Error("code accepted twice")
+	}
+
+	token, err := s.CreateInvite(t.Context(), "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contact, err := s.ValidateInvite(t.Context(), token); err != nil || contact != "bob@example.com" {
+		t.Errorf("ValidateInvite: %q, %v", contact, err)
+	}
+	if _, err := s.ValidateInvite(t.Context(), token); err == nil {
+		t.Error("invite accepted twice")
+	}
+
+	// Многоразовое приглашение считает использования, помнит автора и приглашенных
+	multi := &Invite{ContactInfo: "team@example.com", CreatedBy: alice.ID, MaxUses: 2}
+	if err := s.IssueInvite(t.Context(), multi); err != nil || multi.Token == "" {
+		t.Fatalf("IssueInvite: %v", err)
+	}
+	for _, userID := range []string{"user-1", "user-2"} {
+		if _, err := s.ValidateInvite(t.Context(), multi.Token); err != nil {
+			t.Fatalf("use by %s: %v", userID, err)
+		}
+		s.RecordInviteUse(t.Context(), multi.Token, userID)
+	}
+	if _, err := s.ValidateInvite(t.Context(), multi.Token); err == nil {
+		t.Error("invite used beyond max_uses")
+	}
+	if inv, _ := s.GetInvite(t.Context(), multi.Token); inv == nil || inv.Uses != 2 || inv.CreatedBy != alice.ID {
+		t.Errorf("GetInvite: %+v", inv)
+	}
+	if uses, _ := s.ListInviteUses(t.Context(), multi.Token); len(uses) != 2 || uses[0].UserID != "user-1" {
+		t.Errorf("invite uses: %+v", uses)
+	}
+	if all, _ := s.ListInvites(t.Context(), ""); len(all) != 2 {
+		t.Errorf("expected 2 invites, got %d", len(all))
+	}
+	if own, _ := s.ListInvites(t.Context(), alice.ID); len(own) != 1 || own[0].Token != multi.Token {
+		t.Errorf("alice's invites: %+v", own)
+	}
+
+	revoked, _ := s.CreateInvite(t.Context(), "carol@example.com")
+	if ok, err := s.RevokeInvite(t.Context(), revoked); !ok || err != nil {
+		t.Errorf("RevokeInvite: %v, %v", ok, err)
+	}
+	if ok, _ := s.RevokeInvite(t.Context(), revoked); ok {
+		t.Error("invite revoked twice")
+	}
+	if _, err := s.ValidateInvite(t.Context(), revoked); err == nil {
+		t.Error("revoked invite accepted")
+	}
+	if inv, err := s.GetInvite(t.Context(), "missing"); inv != nil || err != nil {
+		t.Errorf("missing invite: %+v, %v", inv, err)
+	}
+
+	// Сессия: токен обновления одноразовый, после отзыва токен доступа не действует
+	sess, err := s.CreateSession(t.Context(), alice.ID, "laptop", SessionClient{}, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ValidateSession(t.Context(), sess.Token, SessionClient{}); err != nil || got.UserID != alice.ID || got.DeviceID != "laptop" || got.Token != "" {
+		t.Errorf("ValidateSession: %+v, %v", got, err)
+	}
+	refreshed, err := s.RefreshSession(t.Context(), sess.RefreshToken, SessionClient{}, time.Hour, 24*time.Hour)
+	if err != nil || refreshed.ID != sess.ID || refreshed.Token == sess.Token {
+		t.Fatalf("RefreshSession: %+v, %v", refreshed, err)
+	}
+	if _, err := s.RefreshSession(t.Context(), sess.RefreshToken, SessionClient{}, time.Hour, 24*time.Hour); err == nil {
+		t.Error("refresh token accepted twice")
+	}
+	if _, err := s.ValidateSession(t.Context(), sess.Token, SessionClient{}); err == nil {
+		t.Error("token valid after refresh")
+	}
+	if ok, err := s.RevokeSession(t.Context(), "mallory", sess.ID); ok || err != nil {
+		t.Errorf("RevokeSession by another user: %v, %v", ok, err)
+	}
+	if ok, err := s.RevokeSession(t.Context(), alice.ID, sess.ID); !ok || err != nil {
+		t.Errorf("RevokeSession: %v, %v", ok, err)
+	}
+	if _, err := s.ValidateSession(t.Context(), refreshed.Token, SessionClient{}); err == nil {
+		t.Error("token valid after revoke")
+	}
+	expired, err := s.CreateSession(t.Context(), alice.ID, "", SessionClient{}, -time.Minute, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateSession(t.Context(), expired.Token, SessionClient{}); err == nil {
+		t.Error("expired token accepted")
+	}
+	if n, err := s.DeleteExpiredSessions(t.Context(), time.Now()); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSessions: %d, %v", n, err)
+	}
+
+	// Список сессий с последним клиентом; завершение всех, кроме текущей
+	phoneClient := SessionClient{IP: "203.0.113.7", UserAgent: "Hydra/1.0 (Android)"}
+	current, _ := s.CreateSession(t.Context(), alice.ID, "tablet", SessionClient{IP: "198.51.100.1"}, time.Hour, time.Hour)
+	other, _ := s.CreateSession(t.Context(), alice.ID, "phone", SessionClient{}, time.Hour, time.Hour)
+	if _, err := s.ValidateSession(t.Context(), other.Token, phoneClient); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := s.ListSessions(t.Context(), alice.ID)
+	if err != nil || len(listed) != 2 || listed[0].ID != other.ID || listed[0].SessionClient != phoneClient || listed[1].IP != "198.51.100.1" {
+		t.Errorf("ListSessions: %+v, %v", listed, err)
+	}
+	if n, err := s.RevokeOtherSessions(t.Context(), alice.ID, current.ID); err != nil || n != 1 {
+		t.Errorf("RevokeOtherSessions: %d, %v", n, err)
+	}
+	if listed, _ := s.ListSessions(t.Context(), alice.ID); len(listed) != 1 || listed[0].ID != current.ID {
+		t.Errorf("sessions after RevokeOtherSessions: %+v", listed)
+	}
+	if at, err := s.TokensRevokedAt(t.Context(), alice.ID); err != nil || !at.IsZero() {
+		t.Errorf("TokensRevokedAt before revoke: %v, %v", at, err)
+	}
+	revokedAt := time.Now().Truncate(time.Second).UTC()
+	s.RevokeTokens(t.Context(), alice.ID, revokedAt.Add(-time.Minute))
+	if err := s.RevokeTokens(t.Context(), alice.ID, revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := s.TokensRevokedAt(t.Context(), alice.ID); err != nil || !at.Equal(revokedAt) {
+		t.Errorf("TokensRevokedAt: %v, %v", at, err)
+	}
+	s.RevokeSession(t.Context(), alice.ID, current.ID)
+
+	// Приглашение привязать устройство используется один раз
+	link, err := s.CreateDeviceLink(t.Context(), alice.ID, "sealed-keys", time.Minute)
+	if err != nil || link.Token == "" {
+		t.Fatalf("CreateDeviceLink: %+v, %v", link, err)
+	}
+	if got, err := s.ConsumeDeviceLink(t.Context(), link.Token); err != nil || got == nil || got.UserID != alice.ID || got.KeyMaterial != "sealed-keys" {
+		t.Errorf("ConsumeDeviceLink: %+v, %v", got, err)
+	}
+	if got, err := s.ConsumeDeviceLink(t.Context(), link.Token); got != nil || err != nil {
+		t.Errorf("device link consumed twice: %+v, %v", got, err)
+	}
+	stale, _ := s.CreateDeviceLink(t.Context(), alice.ID, "", -time.Minute)
+	if got, _ := s.ConsumeDeviceLink(t.Context(), stale.Token); got != nil {
+		t.Errorf("expired device link accepted: %+v", got)
+	}
+	s.CreateDeviceLink(t.Context(), alice.ID, "", -time.Minute)
+	if n, err := s.DeleteExpiredDeviceLinks(t.Context(), time.Now()); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredDeviceLinks: %d, %v", n, err)
+	}
+
+	// Учетная запись поставщика привязывается к одному пользователю, у пользователя - одна
+	// запись каждого поставщика
+	google := &ExternalIdentity{UserID: alice.ID, Provider: "google", Subject: "g-1", Email: "alice@gmail.example", Signup: true}
+	if ok, err := s.LinkExternalIdentity(t.Context(), google); !ok || err != nil || google.ID == "" {
+		t.Fatalf("LinkExternalIdentity: %v, %v", ok, err)
+	}
+	if ok, _ := s.LinkExternalIdentity(t.Context(), &ExternalIdentity{UserID: "mallory", Provider: "google", Subject: "g-1"}); ok {
+		t.Error("identity linked to two users")
+	}
+	if ok, _ := s.LinkExternalIdentity(t.Context(), &ExternalIdentity{UserID: alice.ID, Provider: "google", Subject: "g-2"}); ok {
+		t.Error("second identity of the same provider linked")
+	}
+	s.LinkExternalIdentity(t.Context(), &ExternalIdentity{UserID: alice.ID, Provider: "github", Subject: "g-1"})
+	if got, err := s.GetExternalIdentity(t.Context(), "google", "g-1"); err != nil || got == nil || got.UserID != alice.ID || got.Email != "alice@gmail.example" || !got.Signup {
+		t.Errorf("GetExternalIdentity: %+v, %v", got, err)
+	}
+	if got, err := s.GetExternalIdentity(t.Context(), "keycloak", "g-1"); got != nil || err != nil {
+		t.Errorf("identity of another provider: %+v, %v", got, err)
+	}
+	if list, err := s.ListExternalIdentities(t.Context(), alice.ID); err != nil || len(list) != 2 {
+		t.Errorf("ListExternalIdentities: %+v, %v", list, err)
+	}
+	if ok, err := s.UnlinkExternalIdentity(t.Context(), alice.ID, "github"); !ok || err != nil {
+		t.Errorf("UnlinkExternalIdentity: %v, %v", ok, err)
+	}
+	if ok, _ := s.UnlinkExternalIdentity(t.Context(), alice.ID, "github"); ok {
+		t.Error("identity unlinked twice")
+	}
+
+	// Состояние доставки только продвигается вперед
+	if err := s.CreateReceipts(t.Context(), "m1", alice.ID, []string{"bob", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, changed, err := s.UpdateReceipt(t.Context(), "m1", "bob", ReceiptRead, time.Now()); err != nil || !changed || r.DeliveredAt.IsZero() {
+		t.Errorf("UpdateReceipt read: %+v, %v, %v", r, changed, err)
+	}
+	if r, changed, err := s.UpdateReceipt(t.Context(), "m1", "bob", ReceiptDelivered, time.Now()); err != nil || changed || r.Status != ReceiptRead {
+		t.Errorf("UpdateReceipt after read: %+v, %v, %v", r, changed, err)
+	}
+	if _, _, err := s.UpdateReceipt(t.Context(), "m1", "mallory", ReceiptRead, time.Now()); err == nil {
+		t.Error("receipt of a non-recipient accepted")
+	}
+	if list, err := s.ListReceipts(t.Context(), "m1"); err != nil || len(list) != 2 || list[1].UserID != "carol" || list[1].Status != ReceiptSent {
+		t.Errorf("ListReceipts: %+v, %v", list, err)
+	}
+
+	// Журнал событий нумеруется с единицы для каждого пользователя
+	for i := 0; i < 3; i++ {
+		if _, err := s.AppendEvent(t.Context(), alice.ID, EventFolders, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events, err := s.ListEvents(t.Context(), alice.ID, 1, 10); err != nil || len(events) != 2 || events[0].Seq != 2 {
+		t.Errorf("ListEvents: %+v, %v", events, err)
+	}
+	if seq, err := s.LastEventSeq(t.Context(), alice.ID); err != nil || seq != 3 {
+		t.Errorf("LastEventSeq: %d, %v", seq, err)
+	}
+
+	// Необязательные объекты без строки возвращаются как nil без ошибки
+	if state, err := s.GetAccountState(t.Context(), alice.ID); state != nil || err != nil {
+		t.Errorf("GetAccountState: %+v, %v", state, err)
+	}
+	if q, err := s.GetQuota(t.Context(), "group"); q != nil || err != nil {
+		t.Errorf("GetQuota: %+v, %v", q, err)
+	}
+
+	// Вытеснение оставляет самые новые сообщения
+	base := time.Now().Add(-time.Hour)
+	for i, body := range []string{"first", "second", "third"} {
+		msg := &Message{ConversationID: "group", SenderID: alice.ID, Body: body, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.CreateMessage(t.Context(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.DeleteOldestMessages(t.Context(), "group", 2); err != nil || n != 1 {
+		t.Errorf("DeleteOldestMessages: %d, %v", n, err)
+	}
+	if msgs, err := s.ListMessages(t.Context(), "group", 10); err != nil || len(msgs) != 2 || msgs[0].Body != "second" {
+		t.Errorf("ListMessages: %+v, %v", msgs, err)
+	}
+
+	// Политика хранения: TTL 0 - бессрочно; очистка удаляет только старые сообщения
+	if err := s.SetRetention(t.Context(), &RetentionPolicy{ConversationID: "group", TTL: 7 * 24 * time.Hour, UpdatedBy: alice.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.GetRetention(t.Context(), "group"); err != nil || p == nil || p.TTL != 7*24*time.Hour {
+		t.Errorf("GetRetention: %+v, %v", p, err)
+	}
+	if n, err := s.DeleteMessagesBefore(t.Context(), "group", base.Add(90*time.Second)); err != nil || n != 1 {
+		t.Errorf("DeleteMessagesBefore: %d, %v", n, err)
+	}
+	if err := s.SetRetention(t.Context(), &RetentionPolicy{ConversationID: "group"}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := s.ListRetention(t.Context()); err != nil || len(list) != 0 {
+		t.Errorf("ListRetention after reset: %+v, %v", list, err)
+	}
+
+	// Правка сохраняет прежний текст в истории; удаление оставляет надгробие без текста и истории
+	msgs, _ := s.ListMessages(t.Context(), "group", 10)
+	edited := msgs[0].ID
+	if ok, err := s.EditMessage(t.Context(), edited, "third, edited", true); !ok || err != nil {
+		t.Fatalf("EditMessage: %v, %v", ok, err)
+	}
+	s.EditMessage(t.Context(), edited, "third, final", false)
+	if msg, err := s.GetMessage(t.Context(), edited); err != nil || msg == nil || msg.Body != "third, final" || msg.EditedAt == nil {
+		t.Errorf("GetMessage after edit: %+v, %v", msg, err)
+	}
+	if edits, err := s.ListMessageEdits(t.Context(), edited); err != nil || len(edits) != 1 || edits[0].Body != "third" {
+		t.Errorf("ListMessageEdits: %+v, %v", edits, err)
+	}
+	if ok, err := s.DeleteMessage(t.Context(), edited); !ok || err != nil {
+		t.Fatalf("DeleteMessage: %v, %v", ok, err)
+	}
+	if msg, _ := s.GetMessage(t.Context(), edited); msg == nil || msg.DeletedAt == nil || msg.Body != "" {
+		t.Errorf("expected tombstone, got %+v", msg)
+	}
+	if edits, _ := s.ListMessageEdits(t.Context(), edited); len(edits) != 0 {
+		t.Errorf("edits survived deletion: %+v", edits)
+	}
+	if ok, _ := s.EditMessage(t.Context(), edited, "again", true); ok {
+		t.Error("edited a deleted message")
+	}
+	if ok, _ := s.DeleteMessage(t.Context(), edited); ok {
+		t.Error("deleted a message twice")
+	}
+	if msg, err := s.GetMessage(t.Context(), "missing"); msg != nil || err != nil {
+		t.Errorf("GetMessage(missing): %+v, %v", msg, err)
+	}
+
+	// Одноразовый ключ выдается один раз; смена ключа идентичности удаляет прежние
+	keys := &KeyBundle{UserID: alice.ID, DeviceID: "phone", IdentityKey: "id1", SignedPreKey: &PreKey{KeyID: 1, PublicKey: "spk", Signature: "sig"}}
+	if err := s.PublishKeys(t.Context(), keys, []*PreKey{{KeyID: 11, PublicKey: "a"}, {KeyID: 10, PublicKey: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	bundles, err := s.TakeKeyBundles(t.Context(), alice.ID, "")
+	if err != nil || len(bundles) != 1 || bundles[0].OneTimePreKey == nil || bundles[0].OneTimePreKey.KeyID != 10 || bundles[0].SignedPreKey.Signature != "sig" {
+		t.Fatalf("TakeKeyBundles: %+v, %v", bundles, err)
+	}
+	if n, _ := s.CountPreKeys(t.Context(), alice.ID, "phone"); n != 1 {
+		t.Errorf("expected 1 prekey left, got %d", n)
+	}
+	keys.IdentityKey = "id2"
+	s.PublishKeys(t.Context(), keys, nil)
+	if bundles, _ := s.TakeKeyBundles(t.Context(), alice.ID, "phone"); len(bundles) != 1 || bundles[0].OneTimePreKey != nil || bundles[0].IdentityKey != "id2" {
+		t.Errorf("prekeys survived identity change: %+v", bundles)
+	}
+	s.DeleteKeys(t.Context(), alice.ID, "phone")
+	if bundles, _ := s.TakeKeyBundles(t.Context(), alice.ID, ""); len(bundles) != 0 {
+		t.Error("keys not deleted")
+	}
+
+	// У устройства одна подписка push; адрес переходит к устройству, подписавшемуся последним
+	web := &PushSubscription{UserID: alice.ID, DeviceID: "laptop", Kind: PushWebPush, Endpoint: "https://push.example/1", P256dh: "pk", Auth: "secret"}
+	if err := s.SavePushSubscription(t.Context(), web); err != nil {
+		t.Fatal(err)
+	}
+	s.SavePushSubscription(t.Context(), &PushSubscription{UserID: alice.ID, DeviceID: "laptop", Kind: PushWebPush, Endpoint: "https://push.example/2", P256dh: "pk", Auth: "secret"})
+	s.SavePushSubscription(t.Context(), &PushSubscription{UserID: "bob", DeviceID: "phone", Kind: PushFCM, Endpoint: "https://push.example/2"})
+	s.SavePushSubscription(t.Context(), &PushSubscription{UserID: alice.ID, DeviceID: "phone", Kind: PushFCM, Endpoint: "fcm-token"})
+	subs, err := s.ListPushSubscriptions(t.Context(), alice.ID)
+	if err != nil || len(subs) != 1 || subs[0].DeviceID != "phone" || subs[0].Kind != PushFCM {
+		t.Fatalf("ListPushSubscriptions: %+v, %v", subs, err)
+	}
+	if subs, _ := s.ListPushSubscriptions(t.Context(), "bob"); len(subs) != 1 || subs[0].Endpoint != "https://push.example/2" {
+		t.Errorf("endpoint not moved: %+v", subs)
+	}
+	s.SavePushSubscription(t.Context(), web)
+	if subs, _ := s.ListPushSubscriptions(t.Context(), alice.ID); len(subs) != 2 || subs[0].Auth != "secret" || subs[0].P256dh != "pk" {
+		t.Errorf("web push keys lost: %+v", subs)
+	}
+	if ok, err := s.DeletePushSubscription(t.Context(), alice.ID, "laptop"); !ok || err != nil {
+		t.Errorf("DeletePushSubscription: %v, %v", ok, err)
+	}
+	if ok, _ := s.DeletePushSubscription(t.Context(), alice.ID, "laptop"); ok {
+		t.Error("deleted a push subscription twice")
+	}
+
+	// Каталог: по имени находятся разрешившие поиск, по контакту - разрешившие поиск по нему
+	boris, _ := s.CreateUser(t.Context(), "Boris Ivanov", "secret", "+70000000042")
+	s.SaveLookupProfile(t.Context(), &LookupProfile{UserID: boris.ID, Username: "borya_100%", Discoverable: true, DiscoverableByPhone: true})
+	s.SaveLookupProfile(t.Context(), &LookupProfile{UserID: alice.ID, Username: "alice", DiscoverableByEmail: true})
+	if found, err := s.SearchDirectory(t.Context(), "ivan", "ivan", 10); err != nil || len(found) != 1 || found[0].UserID != boris.ID || found[0].Username != "borya_100%" {
+		t.Errorf("SearchDirectory(name): %+v, %v", found, err)
+	}
+	if found, _ := s.SearchDirectory(t.Context(), "bor", "", 10); len(found) != 1 {
+		t.Errorf("SearchDirectory(handle): %+v", found)
+	}
+	if found, _ := s.SearchDirectory(t.Context(), "borya_1000", "", 10); len(found) != 0 {
+		t.Errorf("LIKE wildcards not escaped: %+v", found)
+	}
+	if found, _ := s.SearchDirectory(t.Context(), "ali", "ali", 10); len(found) != 0 {
+		t.Errorf("hidden user found by name: %+v", found)
+	}
+	if entry, err := s.FindDirectoryContact(t.Context(), "alice@example.com", ""); err != nil || entry == nil || entry.UserID != alice.ID {
+		t.Errorf("FindDirectoryContact(email): %+v, %v", entry, err)
+	}
+	if entry, _ := s.FindDirectoryContact(t.Context(), "", "+70000000042"); entry == nil || entry.UserID != boris.ID {
+		t.Errorf("FindDirectoryContact(phone): %+v", entry)
+	}
+	contacts, err := s.ListDiscoverableContacts(t.Context())
+	if err != nil || len(contacts) != 2 {
+		t.Fatalf("ListDiscoverableContacts: %+v, %v", contacts, err)
+	}
+	for _, c := range contacts {
+		if (c.UserID == alice.ID && c.Contact != "alice@example.com") || (c.UserID == boris.ID && c.Contact != "+70000000042") {
+			t.Errorf("discoverable contact: %+v", c)
+		}
+	}
+	s.SaveLookupProfile(t.Context(), &LookupProfile{UserID: boris.ID, Discoverable: true})
+	if entry, err := s.FindDirectoryContact(t.Context(), "", "+70000000042"); entry != nil || err != nil {
+		t.Errorf("phone search not disabled: %+v, %v", entry, err)
+	}
+	if _, err := s.DeactivateUser(t.Context(), boris.ID, InboundBounce); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.SearchDirectory(t.Context(), "boris", "boris", 10); len(found) != 0 {
+		t.Errorf("deactivated user found: %+v", found)
+	}
+	if contacts, _ := s.ListDiscoverableContacts(t.Context()); len(contacts) != 1 || contacts[0].UserID != alice.ID {
+		t.Errorf("discoverable contacts after deactivation: %+v", contacts)
+	}
+
+	// Смена имени: прежнее удерживается за владельцем, упоминания не находят деактивированных
+	if ok, err := s.ChangeUsername(t.Context(), boris.ID, "alice", time.Hour); ok || err != nil {
+		t.Errorf("taken username claimed: %v, %v", ok, err)
+	}
+	if ok, err := s.ChangeUsername(t.Context(), alice.ID, "alice_w", time.Hour); !ok || err != nil {
+		t.Fatalf("ChangeUsername: %v, %v", ok, err)
+	}
+	if p, _ := s.GetLookupProfile(t.Context(), alice.ID); p.Username != "alice_w" || p.UsernameChangedAt == nil || !p.DiscoverableByEmail {
+		t.Errorf("profile after change: %+v", p)
+	}
+	if owner, err := s.UsernameOwner(t.Context(), "alice", time.Hour); owner != alice.ID || err != nil {
+		t.Errorf("released username owner: %q, %v", owner, err)
+	}
+	if ok, _ := s.ChangeUsername(t.Context(), boris.ID, "alice", time.Hour); ok {
+		t.Error("held username claimed by another user")
+	}
+	if owner, _ := s.UsernameOwner(t.Context(), "alice", 0); owner != "" {
+		t.Errorf("username held after the hold period: %q", owner)
+	}
+	if ok, err := s.ChangeUsername(t.Context(), boris.ID, "boris", time.Hour); !ok || err != nil {
+		t.Fatalf("ChangeUsername(boris): %v, %v", ok, err)
+	}
+	resolved, err := s.ResolveUsernames(t.Context(), []string{"alice_w", "boris", "nobody"})
+	if err != nil || len(resolved) != 1 || resolved["alice_w"] != alice.ID {
+		t.Errorf("ResolveUsernames: %+v, %v", resolved, err)
+	}
+	if ok, _ := s.ChangeUsername(t.Context(), alice.ID, "alice", time.Hour); !ok {
+		t.Error("owner could not reclaim a held username")
+	}
+	if ok, _ := s.ChangeUsername(t.Context(), boris.ID, "", time.Hour); !ok {
+		t.Error("username not released")
+	}
+	if owner, _ := s.UsernameOwner(t.Context(), "boris", time.Hour); owner != boris.ID {
+		t.Errorf("cleared username not held: %q", owner)
+	}
+
+	// Новая загрузка аватара заменяет версию
+	if a, err := s.GetAvatar(t.Context(), alice.ID); a != nil || err != nil {
+		t.Errorf("avatar before upload: %+v, %v", a, err)
+	}
+	s.SaveAvatar(t.Context(), &Avatar{UserID: alice.ID, Version: "v1", ContentType: "image/png"})
+	if err := s.SaveAvatar(t.Context(), &Avatar{UserID: alice.ID, Version: "v2", ContentType: "image/jpeg"}); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := s.GetAvatar(t.Context(), alice.ID); err != nil || a.Version != "v2" || a.ContentType != "image/jpeg" || a.UpdatedAt.IsZero() {
+		t.Errorf("GetAvatar: %+v, %v", a, err)
+	}
+	if ok, err := s.DeleteAvatar(t.Context(), alice.ID); !ok || err != nil {
+		t.Errorf("DeleteAvatar: %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteAvatar(t.Context(), alice.ID); ok {
+		t.Error("deleted an avatar twice")
+	}
+
+	// Язык пользователя: выбор, замена и сброс
+	if locale, err := s.GetUserLocale(t.Context(), alice.ID); locale != "" || err != nil {
+		t.Errorf("locale before selection: %q, %v", locale, err)
+	}
+	s.SetUserLocale(t.Context(), alice.ID, "en")
+	if err := s.SetUserLocale(t.Context(), alice.ID, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if locale, _ := s.GetUserLocale(t.Context(), alice.ID); locale != "ru" {
+		t.Errorf("expected ru, got %q", locale)
+	}
+	s.SetUserLocale(t.Context(), alice.ID, "")
+	if locale, _ := s.GetUserLocale(t.Context(), alice.ID); locale != "" {
+		t.Errorf("locale not reset: %q", locale)
+	}
+
+	// Устройство: повторная регистрация сохраняет курсор доставки, курсор не идет назад,
+	// отзыв сессий устройства не затрагивает другие устройства
+	phone := &Device{ID: "dev-phone", UserID: alice.ID, Name: "Phone", Platform: "android"}
+	if err := s.UpsertDevice(t.Context(), phone); err != nil || phone.CreatedAt.IsZero() {
+		t.Fatalf("UpsertDevice: %+v, %v", phone, err)
+	}
+	if ok, err := s.TouchDevice(t.Context(), alice.ID, phone.ID, 7); !ok || err != nil {
+		t.Errorf("TouchDevice: %v, %v", ok, err)
+	}
+	s.TouchDevice(t.Context(), alice.ID, phone.ID, 3)
+	if ok, _ := s.TouchDevice(t.Context(), "mallory", phone.ID, 9); ok {
+		t.Error("foreign device touched")
+	}
+	renamed := &Device{ID: phone.ID, UserID: alice.ID, Name: "Pixel", Platform: "android"}
+	if err := s.UpsertDevice(t.Context(), renamed); err != nil || renamed.EventSeq != 7 {
+		t.Errorf("re-register: %+v, %v", renamed, err)
+	}
+	if err := s.UpsertDevice(t.Context(), &Device{ID: phone.ID, UserID: "mallory"}); err == nil {
+		t.Error("device taken over by another user")
+	}
+	if got, err := s.GetDevice(t.Context(), phone.ID); err != nil || got.Name != "Pixel" || got.Platform != "android" || got.EventSeq != 7 || got.LastSeenAt.IsZero() {
+		t.Errorf("GetDevice: %+v, %v", got, err)
+	}
+	onPhone, _ := s.CreateSession(t.Context(), alice.ID, phone.ID, SessionClient{}, time.Hour, time.Hour)
+	onLaptop, _ := s.CreateSession(t.Context(), alice.ID, "dev-laptop", SessionClient{}, time.Hour, time.Hour)
+	if err := s.RevokeDeviceSessions(t.Context(), alice.ID, phone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateSession(t.Context(), onPhone.Token, SessionClient{}); err == nil {
+		t.Error("session of revoked device still valid")
+	}
+	if _, err := s.ValidateSession(t.Context(), onLaptop.Token, SessionClient{}); err != nil {
+		t.Errorf("session of another device revoked: %v", err)
+	}
+
+	// Блокировка аккаунта администратором и список пользователей для него
+	before, err := s.GetStorageStats(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range statsTables {
+		if _, ok := before.Tables[table]; !ok {
+			t.Errorf("no stats for %s", table)
+		}
+	}
+	s.SaveAvatar(t.Context(), &Avatar{UserID: alice.ID, Version: "v3", ContentType: "image/png"})
+	if after, _ := s.GetStorageStats(t.Context()); after.Tables["avatars"] != before.Tables["avatars"]+1 {
+		t.Errorf("avatars counted %d, then %d", before.Tables["avatars"], after.Tables["avatars"])
+	}
+	if d, err := s.GetDisabledAccount(t.Context(), boris.ID); d != nil || err != nil {
+		t.Errorf("active account disabled: %+v, %v", d, err)
+	}
+	s.DisableUser(t.Context(), boris.ID, "admin", "spam")
+	if d, err := s.DisableUser(t.Context(), boris.ID, "other", "abuse"); err != nil || d.DisabledBy != "admin" || d.Reason != "abuse" {
+		t.Errorf("DisableUser: %+v, %v", d, err)
+	}
+	s.GrantRole(t.Context(), alice.ID, RoleAdmin, "admin")
+	summaries, err := s.ListUserSummaries(t.Context(), "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, u := range summaries {
+		if i > 0 && u.ID <= summaries[i-1].ID {
+			t.Errorf("users not ordered by ID: %s after %s", u.ID, summaries[i-1].ID)
+		}
+		switch u.ID {
+		case alice.ID:
+			if !u.IsAdmin || u.Disabled != nil || u.Email != "alice@example.com" {
+				t.Errorf("unexpected summary of alice: %+v", u)
+			}
+		case boris.ID:
+			if u.IsAdmin || !u.Deactivated || u.Disabled == nil || u.Disabled.Reason != "abuse" || u.Phone != "+70000000042" {
+				t.Errorf("unexpected summary of boris: %+v", u)
+			}
+		}
+	}
+	if page, _ := s.ListUserSummaries(t.Context(), summaries[0].ID, 1); len(page) != 1 || page[0].ID != summaries[1].ID {
+		t.Errorf("ListUserSummaries after cursor: %+v", page)
+	}
+	if ok, err := s.EnableUser(t.Context(), boris.ID); !ok || err != nil {
+		t.Errorf("EnableUser: %v, %v", ok, err)
+	}
+	if ok, _ := s.EnableUser(t.Context(), boris.ID); ok {
+		t.Error("enabled an account twice")
+	}
+
+	// Повторная доставка отбрасывается и после подтверждения
+	in := &InboxMessage{UserID: alice.ID, MessageID: "m1", SenderID: "bob", Body: "привет", SentAt: time.Now()}
+	if ok, err := s.DeliverInbox(t.Context(), in); !ok || err != nil || in.ID == "" {
+		t.Fatalf("DeliverInbox: %v, %v", ok, err)
+	}
+	if ok, _ := s.DeliverInbox(t.Context(), &InboxMessage{UserID: alice.ID, MessageID: "m1", SenderID: "bob", Body: "x", SentAt: time.Now()}); ok {
+		t.Error("duplicate message delivered")
+	}
+	if pending, err := s.ListInbox(t.Context(), alice.ID, 10); err != nil || len(pending) != 1 || pending[0].Body != "привет" {
+		t.Fatalf("ListInbox: %+v, %v", pending, err)
+	}
+	if n, _ := s.AckInbox(t.Context(), "bob", []string{in.ID}); n != 0 {
+		t.Error("foreign inbox message acked")
+	}
+	if n, err := s.AckInbox(t.Context(), alice.ID, []string{in.ID, in.ID}); n != 1 || err != nil {
+		t.Errorf("AckInbox: %d, %v", n, err)
+	}
+	if pending, _ := s.ListInbox(t.Context(), alice.ID, 10); len(pending) != 0 {
+		t.Error("acked message still pending")
+	}
+	if ok, _ := s.DeliverInbox(t.Context(), &InboxMessage{UserID: alice.ID, MessageID: "m1", SenderID: "bob", Body: "x", SentAt: time.Now()}); ok {
+		t.Error("acked message delivered again")
+	}
+	if n, err := s.DeleteAckedInbox(t.Context(), time.Now().Add(time.Minute)); n != 1 || err != nil {
+		t.Errorf("DeleteAckedInbox: %d, %v", n, err)
+	}
+
+	// Автор группы - ее администратор; повторное добавление участника не меняет группу
+	group := &Group{Name: "Семья", CreatedBy: "zoe"}
+	if err := s.CreateGroup(t.Context(), group, []string{"bob", "zoe"}); err != nil || group.ID == "" {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	members, err := s.ListGroupMembers(t.Context(), group.ID)
+	if err != nil || len(members) != 2 || members[0].UserID != "bob" || members[1].Role != GroupRoleAdmin {
+		t.Fatalf("ListGroupMembers: %+v, %v", members, err)
+	}
+	if added, _ := s.AddGroupMember(t.Context(), group.ID, "bob", GroupRoleMember); added {
+		t.Error("member added twice")
+	}
+	if removed, _ := s.RemoveGroupMember(t.Context(), group.ID, "bob"); !removed {
+		t.Error("member not removed")
+	}
+	if m, err := s.GetGroupMember(t.Context(), group.ID, "bob"); m != nil || err != nil {
+		t.Errorf("GetGroupMember after removal: %+v, %v", m, err)
+	}
+	if groups, _ := s.ListUserGroups(t.Context(), "zoe"); len(groups) != 1 || groups[0].Name != "Семья" {
+		t.Errorf("ListUserGroups: %+v", groups)
+	}
+	if g, err := s.GetGroup(t.Context(), "missing"); g != nil || err != nil {
+		t.Errorf("GetGroup of a missing group: %+v, %v", g, err)
+	}
+
+	if added, err := s.GrantRole(t.Context(), alice.ID, "auditor", "root"); !added || err != nil {
+		t.Errorf("GrantRole: %v, %v", added, err)
+	}
+	if added, _ := s.GrantRole(t.Context(), alice.ID, "auditor", "root"); added {
+		t.Error("role granted twice")
+	}
+	for _, action := range []string{"grant", "revoke"} {
+		if _, err := s.AppendAudit(t.Context(), "root", action, alice.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := s.ListAudit(t.Context(), 0, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ListAudit: %d entries, %v", len(entries), err)
+	}
+	if err := VerifyAuditChain(entries); err != nil {
+		t.Error(err)
+	}
+
+	// Блокировки и жалобы
+	if added, err := s.BlockUser(t.Context(), alice.ID, "bob"); !added || err != nil {
+		t.Errorf("BlockUser: %v, %v", added, err)
+	}
+	if added, _ := s.BlockUser(t.Context(), alice.ID, "bob"); added {
+		t.Error("user blocked twice")
+	}
+	s.BlockUser(t.Context(), "bob", alice.ID)
+	if blocked, err := s.IsBlocked(t.Context(), alice.ID, "bob"); !blocked || err != nil {
+		t.Errorf("IsBlocked: %v, %v", blocked, err)
+	}
+	if blocked, _ := s.IsBlocked(t.Context(), alice.ID, "zoe"); blocked {
+		t.Error("unexpected block")
+	}
+	s.BlockUser(t.Context(), alice.ID, "zoe")
+	if ok, _ := s.UnblockUser(t.Context(), alice.ID, "zoe"); !ok {
+		t.Error("block not removed")
+	}
+	if ok, _ := s.UnblockUser(t.Context(), alice.ID, "zoe"); ok {
+		t.Error("block removed twice")
+	}
+	if blocks, err := s.ListBlocks(t.Context(), alice.ID); err != nil || len(blocks) != 1 || blocks[0].BlockedID != "bob" {
+		t.Errorf("ListBlocks: %+v, %v", blocks, err)
+	}
+	report := &AbuseReport{ReporterID: alice.ID, ReportedID: "bob", Reason: ReportSpam, MessageID: "msg-1"}
+	if err := s.CreateAbuseReport(t.Context(), report); err != nil || report.ID == "" || report.Status != ReportOpen {
+		t.Fatalf("CreateAbuseReport: %+v, %v", report, err)
+	}
+	s.CreateAbuseReport(t.Context(), &AbuseReport{ReporterID: "bob", ReportedID: alice.ID, Reason: ReportOther})
+	if ok, err := s.ResolveAbuseReport(t.Context(), report.ID, "admin", "warned"); !ok || err != nil {
+		t.Errorf("ResolveAbuseReport: %v, %v", ok, err)
+	}
+	if ok, _ := s.ResolveAbuseReport(t.Context(), report.ID, "admin", "again"); ok {
+		t.Error("report resolved twice")
+	}
+	if reports, err := s.ListAbuseReports(t.Context(), ReportResolved, 10); err != nil || len(reports) != 1 ||
+		reports[0].ResolvedBy != "admin" || reports[0].Resolution != "warned" || reports[0].ResolvedAt.IsZero() {
+		t.Errorf("ListAbuseReports(resolved): %+v, %v", reports, err)
+	}
+	if reports, _ := s.ListAbuseReports(t.Context(), "", 10); len(reports) != 2 {
+		t.Errorf("ListAbuseReports: %d reports", len(reports))
+	}
+
+	// Доставка SMS: окончательное состояние не меняется запоздалым отчетом
+	if err := s.RecordSMS(t.Context(), &SMSMessage{Provider: "twilio", ProviderID: "SM1", Recipient: "+15551230000", Status: sms.StatusQueued}); err != nil {
+		t.Fatalf("RecordSMS: %v", err)
+	}
+	if ok, err := s.UpdateSMSStatus(t.Context(), "twilio", "SM1", sms.StatusDelivered, ""); !ok || err != nil {
+		t.Errorf("UpdateSMSStatus: %v, %v", ok, err)
+	}
+	if ok, _ := s.UpdateSMSStatus(t.Context(), "twilio", "SM1", sms.StatusSent, ""); ok {
+		t.Error("final SMS status changed")
+	}
+	if ok, _ := s.UpdateSMSStatus(t.Context(), "vonage", "SM1", sms.StatusSent, ""); ok {
+		t.Error("status of another provider's message updated")
+	}
+	if msg, err := s.GetSMS(t.Context(), "twilio", "SM1"); err != nil || msg.Status != sms.StatusDelivered || msg.Recipient != "+15551230000" {
+		t.Errorf("GetSMS: %+v, %v", msg, err)
+	}
+	s.DeleteSMSBefore(t.Context(), time.Now().Add(time.Minute))
+	if _, err := s.GetSMS(t.Context(), "twilio", "SM1"); err == nil {
+		t.Error("old SMS kept")
+	}
+
+	// Удаление аккаунта: данные пользователя удаляются, ссылки в чужих данных обезличиваются
+	s.CreateMessage(t.Context(), &Message{ConversationID: "erasure", SenderID: alice.ID, Body: "mine"})
+	s.CreateMessage(t.Context(), &Message{ConversationID: "erasure", SenderID: "bob", Body: "theirs"})
+	s.CreateAttachment(t.Context(), &Attachment{ID: "att-erasure", OwnerID: alice.ID, MimeType: "image/png", Key: "attachments/erasure"})
+	s.CreateVoiceFile(t.Context(), &VoiceFile{ID: "voice-alice", ConversationID: "erasure", SenderID: alice.ID, Path: "voice/alice.mp3"})
+	s.CreateVoiceFile(t.Context(), &VoiceFile{ID: "voice-bob", ConversationID: "erasure", SenderID: "bob", Path: "voice/bob.mp3"})
+	s.SetUserTrust(t.Context(), "bob", 1, alice.ID)
+	aliceGroup := &Group{Name: "Alice's", CreatedBy: alice.ID}
+	s.CreateGroup(t.Context(), aliceGroup, []string{"bob"})
+	s.AppendEvent(t.Context(), alice.ID, EventFolders, nil)
+	erased, err := s.PurgeUser(t.Context(), alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased.Messages == 0 || len(erased.AttachmentKeys) != 1 || erased.AttachmentKeys[0] != "attachments/erasure" ||
+		len(erased.VoicePaths) != 1 || erased.VoicePaths[0] != "voice/alice.mp3" {
+		t.Errorf("PurgeUser: %+v", erased)
+	}
+	if _, err := s.GetUser(t.Context(), alice.ID); err == nil {
+		t.Error("user still exists")
+	}
+	if _, err := s.PurgeUser(t.Context(), alice.ID); err == nil {
+		t.Error("missing user purged")
+	}
+	if msgs, _ := s.ListMessages(t.Context(), "erasure", 10); len(msgs) != 1 || msgs[0].SenderID != "bob" {
+		t.Errorf("messages after erasure: %+v", msgs)
+	}
+	if files, _ := s.ListVoiceFilesBefore(t.Context(), "erasure", time.Now().Add(time.Minute)); len(files) != 1 || files[0].ID != "voice-bob" {
+		t.Errorf("voice files after erasure: %+v", files)
+	}
+	if a, _ := s.GetAttachment(t.Context(), "att-erasure"); a != nil {
+		t.Errorf("attachment kept: %+v", a)
+	}
+	if devices, _ := s.ListDevices(t.Context(), alice.ID); len(devices) != 0 {
+		t.Errorf("devices kept: %+v", devices)
+	}
+	if events, _ := s.ListEvents(t.Context(), alice.ID, 0, 10); len(events) != 0 {
+		t.Errorf("events kept: %+v", events)
+	}
+	if _, err := s.ValidateSession(t.Context(), onLaptop.Token, SessionClient{}); err == nil {
+		t.Error("session kept")
+	}
+	if trust, _ := s.GetUserTrust(t.Context(), "bob"); trust == nil || trust.InvitedBy != "" {
+		t.Errorf("inviter not anonymized: %+v", trust)
+	}
+	if g, _ := s.GetGroup(t.Context(), aliceGroup.ID); g == nil || g.CreatedBy != "" {
+		t.Errorf("group author not anonymized: %+v", g)
+	}
+	if m, _ := s.GetGroupMember(t.Context(), aliceGroup.ID, alice.ID); m != nil {
+		t.Errorf("membership kept: %+v", m)
+	}
+	if blocked, _ := s.IsBlocked(t.Context(), "bob", alice.ID); blocked {
+		t.Error("block of the erased user kept")
+	}
+	if reports, _ := s.ListAbuseReports(t.Context(), "", 10); len(reports) != 1 || reports[0].ReporterID != "bob" {
+		t.Errorf("reports after erasure: %+v", reports)
+	}
+	if entries, _ := s.ListAudit(t.Context(), 0, 10); len(entries) != 2 {
+		t.Errorf("audit log changed: %d entries", len(entries))
+	}
+}
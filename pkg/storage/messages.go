@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// Message - сообщение беседы
+type Message struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Type           string    `json:"type"` // text, reaction, quick_reply, ...
+	Body           string    `json:"body"`
+	ReplyTo        string    `json:"reply_to,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // надгробие: текст удален
+}
+
+// MessageEdit - прежний текст сообщения, замененный правкой в EditedAt
+type MessageEdit struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"message_id"`
+	Body      string    `json:"body"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+const messageColumns = "id, conversation_id, sender_id, type, body, reply_to, created_at, edited_at, deleted_at"
+
+func scanMessage(row rowScanner) (*Message, error) {
+	msg := &Message{}
+	var editedAt, deletedAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Type, &msg.Body, &msg.ReplyTo, &msg.CreatedAt, &editedAt, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if editedAt.Valid {
+		msg.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
+	}
+	return msg, nil
+}
+
+func (s *Storage) CreateMessage(ctx context.Context, msg *Message) error {
+	if msg.ID == "" {
+		msg.ID = "msg-" + ids.New()
+	}
+	if msg.Type == "" {
+		msg.Type = "text"
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	query := "INSERT INTO messages (id, conversation_id, sender_id, type, body, reply_to, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := s.db.ExecContext(ctx, query, msg.ID, msg.ConversationID, msg.SenderID, msg.Type, s.keys.Seal(msg.Body), msg.ReplyTo, msg.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create message: %w", err)
+	}
+	return nil
+}
+
+// ListMessages возвращает сообщения беседы в хронологическом порядке
+func (s *Storage) ListMessages(ctx context.Context, conversationID string, limit int) ([]*Message, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := "SELECT " + messageColumns + " FROM messages WHERE conversation_id = $1 ORDER BY created_at LIMIT $2"
+	rows, err := s.db.QueryContext(ctx, query, conversationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, s.openMessages(messages)
+}
+
+// IsConversationParticipant сообщает, писал ли пользователь в беседу или получал ее
+// сообщения (по отметкам доставки). Учитываются только сообщения, еще не ушедшие в архив.
+func (s *Storage) IsConversationParticipant(ctx context.Context, conversationID, userID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = $1 AND (m.sender_id = $2
+		OR EXISTS (SELECT 1 FROM message_receipts r WHERE r.message_id = m.id AND r.user_id = $2)))`
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, conversationID, userID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check conversation participant: %w", err)
+	}
+	return exists, nil
+}
+
+// GetMessage возвращает сообщение (в том числе надгробие удаленного); nil - сообщения нет
+func (s *Storage) GetMessage(ctx context.Context, id string) (*Message, error) {
+	msg, err := scanMessage(s.db.QueryRowContext(ctx, "SELECT "+messageColumns+" FROM messages WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	return msg, s.openMessages([]*Message{msg})
+}
+
+// EditMessage заменяет текст сообщения; при keepHistory прежний текст сохраняется в
+// истории правок. false - сообщения нет или оно удалено.
+func (s *Storage) EditMessage(ctx context.Context, id, body string, keepHistory bool) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, "SELECT body FROM messages WHERE id = $1 AND deleted_at IS NULL", id).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	now := time.Now()
+	if keepHistory {
+		// Прежний текст переносится как есть, уже зашифрованным
+		query := "INSERT INTO message_edits (id, message_id, body, edited_at) VALUES ($1, $2, $3, $4)"
+		if _, err := tx.ExecContext(ctx, query, "edit-"+ids.New(), id, previous, now); err != nil {
+			return false, fmt.Errorf("failed to save message edit: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE messages SET body = $1, edited_at = $2 WHERE id = $3", s.keys.Seal(body), now, id); err != nil {
+		return false, fmt.Errorf("failed to edit message: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// DeleteMessage превращает сообщение в надгробие: текст и история правок удаляются,
+// остаются автор, беседа и время. false - сообщения нет или оно уже удалено.
+func (s *Storage) DeleteMessage(ctx context.Context, id string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE messages SET body = '', deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL", time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete message: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete message: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM message_edits WHERE message_id = $1", id); err != nil {
+		return false, fmt.Errorf("failed to delete message edits: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// ListMessageEdits возвращает прежние тексты сообщения, начиная с самого старого
+func (s *Storage) ListMessageEdits(ctx context.Context, messageID string) ([]*MessageEdit, error) {
+	query := "SELECT id, message_id, body, edited_at FROM message_edits WHERE message_id = $1 ORDER BY edited_at, id"
+	rows, err := s.db.QueryContext(ctx, query, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message edits: %w", err)
+	}
+	defer rows.Close()
+
+	var edits []*MessageEdit
+	for rows.Next() {
+		e := &MessageEdit{}
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Body, &e.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message edit: %w", err)
+		}
+		if e.Body, err = s.keys.Open(e.Body); err != nil {
+			return nil, fmt.Errorf("failed to decrypt message edit %s: %w", e.ID, err)
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// DeleteMessageEditsBefore удаляет прежние тексты, замененные раньше before
+func (s *Storage) DeleteMessageEditsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM message_edits WHERE edited_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete message edits: %w", err)
+	}
+	return result.RowsAffected()
+}
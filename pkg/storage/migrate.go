@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Схема базы меняется версионными миграциями из каталога migrations/<диалект>: файлы
+// NNNN_имя.up.sql применяют изменение, NNNN_имя.down.sql откатывают его. Наборы миграций
+// PostgreSQL и SQLite совпадают по номерам и именам и различаются только синтаксисом. Номер последней
+// примененной миграции хранится в schema_version. Каждая миграция выполняется в своей
+// транзакции вместе с записью версии, поэтому при ошибке база остается на предыдущей
+// версии. Новое изменение схемы - новый файл со следующим номером; примененные файлы
+// не редактируются.
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - ключ pg_advisory_lock, чтобы несколько экземпляров сервера,
+// запущенных одновременно, не применяли миграции параллельно
+const migrationLockID = 0x68796472 // "hydr"
+
+// Migration - одна версия схемы
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // пусто - миграцию нельзя откатить
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// loadMigrations читает миграции из fsys и проверяет, что номера идут подряд с 1
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrations возвращает встроенные в сервер миграции для базы
+func (s *Storage) Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, path.Join("migrations", string(s.dialect)))
+}
+
+// SchemaVersion возвращает номер последней примененной миграции; 0 - схема не создана
+func (s *Storage) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ensureSchemaVersion(ctx, s.db); err != nil {
+		return 0, err
+	}
+	return currentVersion(ctx, s.db)
+}
+
+// Migrate переводит схему на версию target: применяет недостающие миграции или откатывает
+// лишние. target < 0 - последняя известная версия.
+func (s *Storage) Migrate(ctx context.Context, target int) error {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return err
+	}
+	if target < 0 {
+		target = len(migrations)
+	}
+	if target > len(migrations) {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, len(migrations))
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migrations: %w", err)
+	}
+	defer conn.Close()
+
+	// Базу SQLite открывает один процесс, а транзакции миграций берут блокировку записи
+	if s.dialect == dialectPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to lock migrations: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+
+	if err := ensureSchemaVersion(ctx, conn); err != nil {
+		return err
+	}
+	current, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		// База обновлена более новой версией сервера: старый сервер не должен с ней работать
+		return fmt.Errorf("schema version %d is newer than this server supports (%d)", current, len(migrations))
+	}
+
+	for current < target {
+		migration := migrations[current]
+		if err := applyMigration(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Schema migrated to version %d (%s)", migration.Version, migration.Name)
+		current++
+	}
+
+	for current > target {
+		migration := migrations[current-1]
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s cannot be rolled back", migration.Version, migration.Name)
+		}
+		if err := applyMigration(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_version WHERE version = $1", migration.Version)
+			return err
+		}); err != nil {
+			return fmt.Errorf("rollback of migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Schema rolled back to version %d", migration.Version-1)
+		current--
+	}
+	return nil
+}
+
+// execer - *sql.DB или *sql.Conn
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func ensureSchemaVersion(ctx context.Context, db execer) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+	return nil
+}
+
+func currentVersion(ctx context.Context, db execer) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// applyMigration выполняет SQL миграции и запись версии в одной транзакции
+func applyMigration(ctx context.Context, conn *sql.Conn, script string, record func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestEmbeddedMigrations проверяет, что встроенные миграции читаются, идут подряд,
+// откатываемы и совпадают по номерам и именам в обоих диалектах
+func TestEmbeddedMigrations(t *testing.T) {
+	postgres, err := (&Storage{dialect: dialectPostgres}).Migrations()
+	if err != nil {
+		t.Fatalf("postgres migrations failed: %v", err)
+	}
+	sqlite, err := (&Storage{dialect: dialectSQLite}).Migrations()
+	if err != nil {
+		t.Fatalf("sqlite migrations failed: %v", err)
+	}
+	if len(postgres) == 0 || postgres[0].Name != "initial" {
+		t.Fatalf("unexpected migrations: %+v", postgres)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres has %d", len(sqlite), len(postgres))
+	}
+	for i, m := range postgres {
+		if sqlite[i].Name != m.Name {
+			t.Errorf("migration %d: sqlite %s, postgres %s", m.Version, sqlite[i].Name, m.Name)
+		}
+		if m.Down == "" || sqlite[i].Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+	for _, migrations := range [][]Migration{postgres, sqlite} {
+		if !strings.Contains(migrations[0].Up, "CREATE TABLE IF NOT EXISTS users") {
+			t.Error("initial migration must create users")
+		}
+	}
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	valid := fstest.MapFS{
+		"m/0001_initial.up.sql":   file("CREATE TABLE a (id TEXT);"),
+		"m/0001_initial.down.sql": file("DROP TABLE a;"),
+		"m/0002_add_b.up.sql":     file("CREATE TABLE b (id TEXT);"),
+	}
+	migrations, err := loadMigrations(valid, "m")
+	if err != nil {
+		t.Fatalf("valid migrations rejected: %v", err)
+	}
+	if len(migrations) != 2 || migrations[1].Name != "add_b" || migrations[1].Down != "" {
+		t.Errorf("unexpected migrations: %+v", migrations)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"gap":       {"m/0001_a.up.sql": file("x"), "m/0003_c.up.sql": file("x")},
+		"no up":     {"m/0001_a.up.sql": file("x"), "m/0002_b.down.sql": file("x")},
+		"bad name":  {"m/0001_a.up.sql": file("x"), "m/2_B.sql": file("x")},
+		"two names": {"m/0001_a.up.sql": file("x"), "m/0001_b.down.sql": file("x")},
+	}
+	for name, fsys := range invalid {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: invalid migrations accepted", name)
+		}
+	}
+}
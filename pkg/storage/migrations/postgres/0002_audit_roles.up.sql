@@ -0,0 +1,20 @@
+-- Роли сотрудников организации и журнал аудита с цепочкой хешей
+
+CREATE TABLE roles (
+	user_id TEXT NOT NULL,
+	role TEXT NOT NULL,
+	granted_by TEXT NOT NULL,
+	granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, role)
+);
+
+CREATE TABLE audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	details TEXT NOT NULL DEFAULT '{}',
+	created_at BIGINT NOT NULL,
+	prev_hash TEXT NOT NULL,
+	hash TEXT NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Вложения сообщений: метаданные файлов в blob-хранилище
+
+CREATE TABLE attachments (
+	id TEXT PRIMARY KEY,
+	owner_id TEXT NOT NULL,
+	conversation_id TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL DEFAULT '',
+	mime_type TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	sha256 TEXT NOT NULL DEFAULT '',
+	blob_key TEXT NOT NULL,
+	complete BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_conversation ON attachments (conversation_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS voice_files;
+DROP TABLE IF EXISTS retention_policies;
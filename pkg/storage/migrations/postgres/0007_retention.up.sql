@@ -0,0 +1,17 @@
+-- Сроки хранения истории бесед и голосовые сообщения, привязанные к беседам
+
+CREATE TABLE retention_policies (
+	conversation_id TEXT PRIMARY KEY,
+	ttl_seconds BIGINT NOT NULL,
+	updated_by TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE voice_files (
+	id TEXT PRIMARY KEY,
+	conversation_id TEXT NOT NULL,
+	path TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_voice_files_conversation ON voice_files (conversation_id, created_at);
@@ -0,0 +1,19 @@
+-- Использованные и отозванные приглашения удаляются: до этой версии приглашение
+-- существовало, только пока им можно воспользоваться
+
+DROP TABLE IF EXISTS invite_uses;
+
+CREATE TABLE invite_inviters (
+	token TEXT PRIMARY KEY,
+	inviter_id TEXT NOT NULL
+);
+
+DELETE FROM invites WHERE revoked_at IS NOT NULL OR (max_uses > 0 AND uses >= max_uses);
+INSERT INTO invite_inviters (token, inviter_id) SELECT token, created_by FROM invites WHERE created_by <> '';
+
+DROP INDEX IF EXISTS idx_invites_created_by;
+ALTER TABLE invites DROP COLUMN revoked_at;
+ALTER TABLE invites DROP COLUMN uses;
+ALTER TABLE invites DROP COLUMN max_uses;
+ALTER TABLE invites DROP COLUMN created_at;
+ALTER TABLE invites DROP COLUMN created_by;
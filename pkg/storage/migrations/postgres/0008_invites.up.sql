@@ -0,0 +1,23 @@
+-- Управление приглашениями: приглашение не удаляется после использования, а считает
+-- использования до max_uses (0 - без ограничения), может быть отозвано, помнит автора и
+-- приглашенных им пользователей. Автор переносится из invite_inviters в created_by.
+
+ALTER TABLE invites ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE invites ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE invites ADD COLUMN max_uses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE invites ADD COLUMN uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE invites ADD COLUMN revoked_at TIMESTAMP;
+
+UPDATE invites SET created_by = i.inviter_id FROM invite_inviters i WHERE i.token = invites.token;
+DROP TABLE invite_inviters;
+
+CREATE INDEX idx_invites_created_by ON invites (created_by);
+
+CREATE TABLE invite_uses (
+	token TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token, user_id)
+);
+
+CREATE INDEX idx_invite_uses_user ON invite_uses (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS one_time_prekeys;
+DROP TABLE IF EXISTS device_keys;
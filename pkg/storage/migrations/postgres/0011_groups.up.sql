@@ -0,0 +1,19 @@
+-- Группы: ID группы - conversation_id ее сообщений в messages. Квота и групповые
+-- звонки группы используют тот же ID.
+
+CREATE TABLE chat_groups (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE group_members (
+	group_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'member',
+	joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user ON group_members(user_id);
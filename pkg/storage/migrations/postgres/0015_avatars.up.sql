@@ -0,0 +1,10 @@
+-- Аватары пользователей: варианты стандартных размеров лежат в blob-хранилище
+-- (avatars/<user_id>/<version>/<size>), здесь - текущая версия и тип содержимого.
+-- Новая загрузка меняет версию, и кеши клиентов по старой версии не мешают.
+
+CREATE TABLE avatars (
+	user_id TEXT PRIMARY KEY,
+	version TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+-- Аккаунты, заблокированные администратором. В отличие от деактивации (account_states),
+-- которую включает сам пользователь, блокировка запрещает вход и запросы с его токенами.
+
+CREATE TABLE disabled_accounts (
+	user_id TEXT PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	disabled_by TEXT NOT NULL,
+	disabled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
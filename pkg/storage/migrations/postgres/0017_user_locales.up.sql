@@ -0,0 +1,7 @@
+-- Язык пользователя для текстов ошибок API, писем и SMS. Без записи язык выбирается
+-- по заголовку Accept-Language запроса.
+
+CREATE TABLE user_locales (
+	user_id TEXT PRIMARY KEY,
+	locale TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE devices DROP COLUMN event_seq;
+ALTER TABLE devices DROP COLUMN last_seen_at;
+ALTER TABLE devices DROP COLUMN created_at;
+ALTER TABLE devices DROP COLUMN platform;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_voice_files_sender;
+ALTER TABLE voice_files DROP COLUMN sender_id;
@@ -0,0 +1,5 @@
+-- Автор голосового сообщения: при удалении аккаунта удаляются и его голосовые сообщения
+
+ALTER TABLE voice_files ADD COLUMN sender_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_voice_files_sender ON voice_files (sender_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS abuse_reports;
+DROP TABLE IF EXISTS blocks;
@@ -0,0 +1,15 @@
+-- Отправленные SMS и состояние их доставки по отчетам службы рассылки
+
+CREATE TABLE sms_messages (
+	provider TEXT NOT NULL,
+	provider_id TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (provider, provider_id)
+);
+
+CREATE INDEX idx_sms_messages_recipient ON sms_messages (recipient);
+CREATE INDEX idx_sms_messages_created ON sms_messages (created_at);
@@ -0,0 +1,14 @@
+-- Привязка нового устройства по QR-коду: одноразовые приглашения устройства, вошедшего
+-- в аккаунт. Токен хранится хешем; key_material зашифрован устройством-источником ключом
+-- из QR-кода и серверу не читаем.
+
+CREATE TABLE device_links (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	key_material TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_device_links_user ON device_links (user_id);
+CREATE INDEX idx_device_links_expires ON device_links (expires_at);
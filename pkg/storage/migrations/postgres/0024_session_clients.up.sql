@@ -0,0 +1,10 @@
+-- Список активных сессий: адрес и User-Agent клиента при последнем использовании сессии,
+-- и время отзыва токенов сигнализации пользователя (они не привязаны к сессии)
+
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE token_revocations (
+	user_id TEXT PRIMARY KEY,
+	revoked_at TIMESTAMP NOT NULL
+);
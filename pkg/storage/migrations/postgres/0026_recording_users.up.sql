@@ -0,0 +1,3 @@
+-- Пользователи записанных звонков: к сохраненной записи имеют доступ ее участники
+
+ALTER TABLE recordings ADD COLUMN users TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,33 @@
+-- Откат исходной схемы удаляет все таблицы вместе с данными
+
+DROP TABLE IF EXISTS email_verifications;
+DROP TABLE IF EXISTS recordings;
+DROP TABLE IF EXISTS transport_events;
+DROP TABLE IF EXISTS devices;
+DROP TABLE IF EXISTS chat_folder_assignments;
+DROP TABLE IF EXISTS chat_folders;
+DROP TABLE IF EXISTS recovery_approvals;
+DROP TABLE IF EXISTS recovery_requests;
+DROP TABLE IF EXISTS recovery_guardians;
+DROP TABLE IF EXISTS identifier_changes;
+DROP TABLE IF EXISTS group_quotas;
+DROP TABLE IF EXISTS client_errors;
+DROP TABLE IF EXISTS transport_blackouts;
+DROP TABLE IF EXISTS static_peers;
+DROP TABLE IF EXISTS invite_inviters;
+DROP TABLE IF EXISTS user_trust;
+DROP TABLE IF EXISTS user_events;
+DROP TABLE IF EXISTS user_event_seqs;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS mail_queue;
+DROP TABLE IF EXISTS digest_settings;
+DROP TABLE IF EXISTS notification_events;
+DROP TABLE IF EXISTS lookup_profiles;
+DROP TABLE IF EXISTS queued_messages;
+DROP TABLE IF EXISTS account_states;
+DROP TABLE IF EXISTS ice_servers;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS sms_verifications;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,281 @@
+-- Исходная схема для SQLite: та же, что для PostgreSQL, с автоинкрементными ключами
+-- вместо SERIAL. Время хранится строками "YYYY-MM-DD HH:MM:SS.fff+00:00" в UTC, в том числе
+-- значения по умолчанию: CURRENT_TIMESTAMP отбрасывает доли секунды и сравнивался бы неверно.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT UNIQUE,
+	phone TEXT UNIQUE,
+	password TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	avatar TEXT,
+	status TEXT
+);
+
+CREATE TABLE IF NOT EXISTS invites (
+	token TEXT PRIMARY KEY,
+	contact_info TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sms_verifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	phone TEXT NOT NULL,
+	code TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	verified BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id TEXT PRIMARY KEY,
+	conversation_id TEXT NOT NULL,
+	sender_id TEXT NOT NULL,
+	type TEXT NOT NULL DEFAULT 'text',
+	body TEXT NOT NULL,
+	reply_to TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (conversation_id, created_at);
+
+CREATE TABLE IF NOT EXISTS ice_servers (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	username TEXT NOT NULL DEFAULT '',
+	credential TEXT NOT NULL DEFAULT '',
+	secret TEXT NOT NULL DEFAULT '',
+	priority INTEGER NOT NULL DEFAULT 0,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	healthy BOOLEAN NOT NULL DEFAULT TRUE,
+	latency_ms BIGINT NOT NULL DEFAULT 0,
+	checked_at TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS account_states (
+	user_id TEXT PRIMARY KEY,
+	inbound_mode TEXT NOT NULL DEFAULT 'queue',
+	deactivated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS queued_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	sender_id TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_queued_messages_user ON queued_messages (user_id, id);
+
+CREATE TABLE IF NOT EXISTS lookup_profiles (
+	user_id TEXT PRIMARY KEY,
+	username TEXT UNIQUE,
+	discoverable BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS notification_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	conversation_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_events_user ON notification_events (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS digest_settings (
+	user_id TEXT PRIMARY KEY,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	interval_seconds BIGINT NOT NULL DEFAULT 86400,
+	privacy BOOLEAN NOT NULL DEFAULT TRUE,
+	mute_token TEXT UNIQUE NOT NULL,
+	last_digest_at TIMESTAMP,
+	last_seen_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS mail_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	sent_at TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id TEXT NOT NULL DEFAULT '',
+	recipient_id TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS user_event_seqs (
+	user_id TEXT PRIMARY KEY,
+	last_seq BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_events (
+	user_id TEXT NOT NULL,
+	seq BIGINT NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (user_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS user_trust (
+	user_id TEXT PRIMARY KEY,
+	level INTEGER NOT NULL DEFAULT 0,
+	invited_by TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS invite_inviters (
+	token TEXT PRIMARY KEY,
+	inviter_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS static_peers (
+	addr TEXT PRIMARY KEY,
+	added_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS transport_blackouts (
+	id TEXT PRIMARY KEY,
+	transports TEXT NOT NULL,
+	traffic TEXT NOT NULL DEFAULT '',
+	days TEXT NOT NULL DEFAULT '',
+	start_time TEXT NOT NULL,
+	end_time TEXT NOT NULL,
+	timezone TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS client_errors (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	stack TEXT NOT NULL DEFAULT '',
+	transport TEXT NOT NULL DEFAULT '',
+	client_version TEXT NOT NULL DEFAULT '',
+	platform TEXT NOT NULL DEFAULT '',
+	context TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_client_errors_created ON client_errors (created_at);
+
+CREATE TABLE IF NOT EXISTS group_quotas (
+	group_id TEXT PRIMARY KEY,
+	max_messages BIGINT NOT NULL DEFAULT 0,
+	max_media_bytes BIGINT NOT NULL DEFAULT 0,
+	eviction TEXT NOT NULL DEFAULT 'evict_oldest',
+	admins TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS identifier_changes (
+	user_id TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	verify_kind TEXT NOT NULL,
+	verify_value TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recovery_guardians (
+	user_id TEXT PRIMARY KEY,
+	guardians TEXT NOT NULL,
+	threshold INTEGER NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS recovery_requests (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	secret_hash TEXT NOT NULL,
+	requested_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	cancelled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_approvals (
+	recovery_id TEXT NOT NULL,
+	guardian_id TEXT NOT NULL,
+	approved_at BIGINT NOT NULL,
+	signature TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	PRIMARY KEY (recovery_id, guardian_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_folders (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	rules TEXT NOT NULL DEFAULT '[]',
+	position INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_folders_user ON chat_folders (user_id, position);
+
+CREATE TABLE IF NOT EXISTS chat_folder_assignments (
+	user_id TEXT NOT NULL,
+	conversation_id TEXT NOT NULL,
+	folder_id TEXT NOT NULL,
+	assigned_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, conversation_id)
+);
+
+CREATE TABLE IF NOT EXISTS devices (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	capabilities TEXT NOT NULL DEFAULT '{}',
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_user ON devices (user_id);
+
+CREATE TABLE IF NOT EXISTS transport_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	domain TEXT NOT NULL,
+	class TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_transport_events_domain ON transport_events (domain, created_at);
+
+CREATE TABLE IF NOT EXISTS recordings (
+	id TEXT PRIMARY KEY,
+	room_id TEXT NOT NULL,
+	requested_by TEXT NOT NULL,
+	participants TEXT NOT NULL DEFAULT '',
+	size_bytes BIGINT NOT NULL DEFAULT 0,
+	started_at TIMESTAMP NOT NULL,
+	stopped_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL,
+	code TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	verified BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
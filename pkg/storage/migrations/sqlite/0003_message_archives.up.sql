@@ -0,0 +1,14 @@
+-- Архивы истории неактивных бесед в холодном хранилище
+
+CREATE TABLE message_archives (
+	id TEXT PRIMARY KEY,
+	conversation_id TEXT NOT NULL,
+	blob_key TEXT NOT NULL,
+	messages INTEGER NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	first_at TIMESTAMP NOT NULL,
+	last_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX idx_message_archives_conversation ON message_archives (conversation_id, last_at);
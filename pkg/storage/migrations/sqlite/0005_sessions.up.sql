@@ -0,0 +1,16 @@
+-- Сессии входа: в базе хранятся только хеши токена доступа и токена обновления
+
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL DEFAULT '',
+	token_hash TEXT NOT NULL UNIQUE,
+	refresh_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	expires_at TIMESTAMP NOT NULL,
+	refresh_expires_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+CREATE INDEX idx_sessions_refresh_expires ON sessions (refresh_expires_at);
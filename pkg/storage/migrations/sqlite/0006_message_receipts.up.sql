@@ -0,0 +1,14 @@
+-- Состояние доставки сообщения каждому получателю: sent -> delivered -> read
+
+CREATE TABLE message_receipts (
+	message_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	sender_id TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'sent',
+	sent_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	delivered_at TIMESTAMP,
+	read_at TIMESTAMP,
+	PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_receipts_sent ON message_receipts (sent_at);
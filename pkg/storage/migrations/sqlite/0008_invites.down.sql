@@ -0,0 +1,22 @@
+-- Использованные и отозванные приглашения удаляются: до этой версии приглашение
+-- существовало, только пока им можно воспользоваться
+
+DROP TABLE IF EXISTS invite_uses;
+
+CREATE TABLE invite_inviters (
+	token TEXT PRIMARY KEY,
+	inviter_id TEXT NOT NULL
+);
+
+DELETE FROM invites WHERE revoked_at IS NOT NULL OR (max_uses > 0 AND uses >= max_uses);
+INSERT INTO invite_inviters (token, inviter_id) SELECT token, created_by FROM invites WHERE created_by <> '';
+
+CREATE TABLE invites_old (
+	token TEXT PRIMARY KEY,
+	contact_info TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO invites_old (token, contact_info, expires_at) SELECT token, contact_info, expires_at FROM invites;
+DROP TABLE invites;
+ALTER TABLE invites_old RENAME TO invites;
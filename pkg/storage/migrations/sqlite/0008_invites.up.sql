@@ -0,0 +1,34 @@
+-- Управление приглашениями: приглашение не удаляется после использования, а считает
+-- использования до max_uses (0 - без ограничения), может быть отозвано, помнит автора и
+-- приглашенных им пользователей. Автор переносится из invite_inviters в created_by.
+-- SQLite не добавляет столбец с вычисляемым значением по умолчанию, поэтому таблица
+-- пересоздается.
+
+CREATE TABLE invites_new (
+	token TEXT PRIMARY KEY,
+	contact_info TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	revoked_at TIMESTAMP
+);
+
+INSERT INTO invites_new (token, contact_info, expires_at, created_by)
+	SELECT i.token, i.contact_info, i.expires_at, COALESCE(ii.inviter_id, '')
+	FROM invites i LEFT JOIN invite_inviters ii ON ii.token = i.token;
+DROP TABLE invites;
+ALTER TABLE invites_new RENAME TO invites;
+DROP TABLE invite_inviters;
+
+CREATE INDEX idx_invites_created_by ON invites (created_by);
+
+CREATE TABLE invite_uses (
+	token TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	used_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (token, user_id)
+);
+
+CREATE INDEX idx_invite_uses_user ON invite_uses (user_id);
@@ -0,0 +1,23 @@
+-- Открытые ключи сквозного шифрования (по схеме Signal): ключ идентичности и
+-- подписанный предварительный ключ устройства, а также одноразовые предварительные
+-- ключи, каждый из которых выдается собеседнику один раз
+
+CREATE TABLE device_keys (
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	identity_key TEXT NOT NULL,
+	signed_key_id BIGINT NOT NULL,
+	signed_public_key TEXT NOT NULL,
+	signed_signature TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (user_id, device_id)
+);
+
+CREATE TABLE one_time_prekeys (
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	key_id BIGINT NOT NULL,
+	public_key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (user_id, device_id, key_id)
+);
@@ -0,0 +1,18 @@
+-- Входящие: сообщения, принятые транспортами для локальных получателей. Подтвержденные
+-- клиентом строки остаются на время окна дедупликации, чтобы повторная доставка того же
+-- сообщения (ретрансляция mesh, повтор транспорта) не попала во входящие снова
+
+CREATE TABLE inbox (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	message_id TEXT NOT NULL,
+	sender_id TEXT NOT NULL,
+	body TEXT NOT NULL,
+	transport TEXT NOT NULL DEFAULT '',
+	sent_at TIMESTAMP NOT NULL,
+	received_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	acked_at TIMESTAMP,
+	UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX idx_inbox_user ON inbox(user_id, received_at);
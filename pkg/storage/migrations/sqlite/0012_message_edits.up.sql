@@ -0,0 +1,15 @@
+-- Правка и удаление сообщений: удаленное сообщение остается надгробием без текста
+-- (deleted_at), прежние тексты правленых сообщений хранятся в message_edits
+
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE message_edits (
+	id TEXT PRIMARY KEY,
+	message_id TEXT NOT NULL,
+	body TEXT NOT NULL,
+	edited_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits (message_id, edited_at);
+CREATE INDEX idx_message_edits_edited ON message_edits (edited_at);
@@ -0,0 +1,16 @@
+-- Адреса push уведомлений устройств: подписки Web Push (endpoint службы push браузера и
+-- ключи шифрования p256dh и auth) и токены регистрации FCM (в endpoint). У устройства
+-- одна подписка; один адрес принадлежит одному устройству.
+
+CREATE TABLE push_subscriptions (
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	endpoint TEXT NOT NULL,
+	p256dh TEXT NOT NULL DEFAULT '',
+	auth TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (user_id, device_id)
+);
+
+CREATE UNIQUE INDEX idx_push_subscriptions_endpoint ON push_subscriptions (endpoint);
@@ -0,0 +1,2 @@
+ALTER TABLE lookup_profiles DROP COLUMN discoverable_by_phone;
+ALTER TABLE lookup_profiles DROP COLUMN discoverable_by_email;
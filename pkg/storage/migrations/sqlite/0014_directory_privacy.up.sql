@@ -0,0 +1,5 @@
+-- Поиск по каталогу пользователей: по точному email и телефону находятся только
+-- разрешившие это пользователи (по умолчанию - никто)
+
+ALTER TABLE lookup_profiles ADD COLUMN discoverable_by_email BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE lookup_profiles ADD COLUMN discoverable_by_phone BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,9 @@
+-- Регистрация устройств: платформа, время регистрации и последней активности, номер
+-- последнего события журнала, полученного устройством (курсор доставки)
+
+ALTER TABLE devices ADD COLUMN platform TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE devices ADD COLUMN last_seen_at TIMESTAMP;
+ALTER TABLE devices ADD COLUMN event_seq BIGINT NOT NULL DEFAULT 0;
+
+UPDATE devices SET created_at = updated_at, last_seen_at = updated_at;
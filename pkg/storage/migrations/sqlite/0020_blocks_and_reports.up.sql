@@ -0,0 +1,27 @@
+-- Блокировки пользователей (user_id не получает сообщений от blocked_id) и жалобы
+-- на пользователей, которые разбирает администратор
+
+CREATE TABLE blocks (
+	user_id TEXT NOT NULL,
+	blocked_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, blocked_id)
+);
+
+CREATE INDEX idx_blocks_blocked ON blocks (blocked_id);
+
+CREATE TABLE abuse_reports (
+	id TEXT PRIMARY KEY,
+	reporter_id TEXT NOT NULL,
+	reported_id TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	message_id TEXT NOT NULL DEFAULT '',
+	details TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'open',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at TIMESTAMP,
+	resolved_by TEXT NOT NULL DEFAULT '',
+	resolution TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_abuse_reports_status ON abuse_reports (status, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS released_usernames;
+ALTER TABLE lookup_profiles DROP COLUMN username_changed_at;
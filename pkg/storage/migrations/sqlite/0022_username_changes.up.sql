@@ -0,0 +1,12 @@
+-- Смена имени пользователя: время последней смены и освобожденные имена, которые
+-- удерживаются за прежним владельцем, чтобы их не заняли для подмены
+
+ALTER TABLE lookup_profiles ADD COLUMN username_changed_at TIMESTAMP;
+
+CREATE TABLE released_usernames (
+	username TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	released_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_released_usernames_user ON released_usernames (user_id);
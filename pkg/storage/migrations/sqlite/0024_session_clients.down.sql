@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS token_revocations;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN ip;
@@ -0,0 +1,15 @@
+-- Учетные записи внешних поставщиков входа (OpenID Connect, GitHub), привязанные к
+-- пользователям. Пользователь привязывает не больше одной записи каждого поставщика.
+-- signup - аккаунт создан входом через эту запись.
+
+CREATE TABLE external_identities (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	signup BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (provider, subject),
+	UNIQUE (user_id, provider)
+);
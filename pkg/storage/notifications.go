@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Виды событий, попадающих в дайджест
+const (
+	NotificationMessage = "message"
+	NotificationCall    = "call"
+)
+
+// DigestSettings - настройки email-дайджеста пользователя
+type DigestSettings struct {
+	UserID       string        `json:"user_id"`
+	Enabled      bool          `json:"enabled"`
+	Interval     time.Duration `json:"interval"` // не чаще одного дайджеста за интервал
+	Privacy      bool          `json:"privacy"`  // только счетчики, без отправителей
+	MuteToken    string        `json:"-"`
+	LastDigestAt time.Time     `json:"last_digest_at,omitempty"`
+	LastSeenAt   time.Time     `json:"last_seen_at,omitempty"`
+}
+
+// DigestSummary - сводка событий пользователя с момента последнего визита или дайджеста
+type DigestSummary struct {
+	Settings      *DigestSettings
+	Email         string
+	Conversations int
+	Messages      int
+	MissedCalls   int
+	Senders       []string
+}
+
+// RecordNotification сохраняет событие для дайджеста получателя
+func (s *Storage) RecordNotification(ctx context.Context, userID, kind, conversationID string) error {
+	query := "INSERT INTO notification_events (user_id, kind, conversation_id) VALUES ($1, $2, $3)"
+	if _, err := s.db.ExecContext(ctx, query, userID, kind, conversationID); err != nil {
+		return fmt.Errorf("failed to record notification: %w", err)
+	}
+	return nil
+}
+
+// TouchUser отмечает активность пользователя: события до этого момента в дайджест не попадают
+func (s *Storage) TouchUser(ctx context.Context, userID string) error {
+	if _, err := s.ensureDigestSettings(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "UPDATE digest_settings SET last_seen_at = $1 WHERE user_id = $2", time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+	return nil
+}
+
+// GetDigestSettings возвращает настройки дайджеста, создавая их со значениями по умолчанию
+func (s *Storage) GetDigestSettings(ctx context.Context, userID string) (*DigestSettings, error) {
+	return s.ensureDigestSettings(ctx, userID)
+}
+
+// UpdateDigestSettings сохраняет расписание, режим приватности и включение дайджеста
+func (s *Storage) UpdateDigestSettings(ctx context.Context, settings *DigestSettings) error {
+	if _, err := s.ensureDigestSettings(ctx, settings.UserID); err != nil {
+		return err
+	}
+
+	query := "UPDATE digest_settings SET enabled = $1, interval_seconds = $2, privacy = $3 WHERE user_id = $4"
+	_, err := s.db.ExecContext(ctx, query, settings.Enabled, int64(settings.Interval/time.Second), settings.Privacy, settings.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update digest settings: %w", err)
+	}
+	return nil
+}
+
+// MuteDigests отключает дайджест по токену из ссылки в письме
+func (s *Storage) MuteDigests(ctx context.Context, token string) (string, error) {
+	var userID string
+	query := "UPDATE digest_settings SET enabled = FALSE WHERE mute_token = $1 RETURNING user_id"
+	if err := s.db.QueryRowContext(ctx, query, token).Scan(&userID); err != nil {
+		return "", fmt.Errorf("invalid mute token: %w", err)
+	}
+	return userID, nil
+}
+
+// ListDigestSummaries возвращает сводки пользователей, которые не заходили с offlineSince,
+// у которых подошел срок очередного дайджеста и есть новые события
+func (s *Storage) ListDigestSummaries(ctx context.Context, offlineSince, now time.Time) ([]*DigestSummary, error) {
+	due := "d.last_digest_at + d.interval_seconds * INTERVAL '1 second'"
+	if s.dialect == dialectSQLite {
+		due = "strftime('%Y-%m-%d %H:%M:%f+00:00', d.last_digest_at, '+' || d.interval_seconds || ' seconds')"
+	}
+	query := `SELECT d.user_id, d.enabled, d.interval_seconds, d.privacy, d.mute_token, d.last_digest_at, d.last_seen_at, u.email
+		FROM digest_settings d JOIN users u ON u.id = d.user_id
+		WHERE d.enabled AND u.email IS NOT NULL AND u.email <> ''
+			AND d.last_seen_at < $1
+			AND (d.last_digest_at IS NULL OR ` + due + ` <= $2)
+			AND NOT EXISTS (SELECT 1 FROM account_states a WHERE a.user_id = d.user_id)`
+	rows, err := s.db.QueryContext(ctx, query, offlineSince, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list digest candidates: %w", err)
+	}
+
+	var summaries []*DigestSummary
+	for rows.Next() {
+		settings, email, err := scanDigestSettings(rows, true)
+		if err == nil {
+			email, err = s.keys.Open(email)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		summaries = append(summaries, &DigestSummary{Settings: settings, Email: email})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list digest candidates: %w", err)
+	}
+
+	var result []*DigestSummary
+	for _, summary := range summaries {
+		if err := s.fillDigestSummary(ctx, summary); err != nil {
+			return nil, err
+		}
+		if summary.Messages > 0 || summary.MissedCalls > 0 {
+			result = append(result, summary)
+		}
+	}
+	return result, nil
+}
+
+// MarkDigestSent фиксирует отправку дайджеста: следующий включит только более новые события
+func (s *Storage) MarkDigestSent(ctx context.Context, userID string, at time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE digest_settings SET last_digest_at = $1 WHERE user_id = $2", at, userID); err != nil {
+		return fmt.Errorf("failed to mark digest sent: %w", err)
+	}
+	return nil
+}
+
+// DeleteNotificationsBefore удаляет старые события дайджестов
+func (s *Storage) DeleteNotificationsBefore(ctx context.Context, before time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM notification_events WHERE created_at < $1", before); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	return nil
+}
+
+// fillDigestSummary считает события после последнего визита и последнего дайджеста
+func (s *Storage) fillDigestSummary(ctx context.Context, summary *DigestSummary) error {
+	since := summary.Settings.LastSeenAt
+	if summary.Settings.LastDigestAt.After(since) {
+		since = summary.Settings.LastDigestAt
+	}
+
+	query := `SELECT kind, conversation_id, COUNT(*) FROM notification_events
+		WHERE user_id = $1 AND created_at > $2 GROUP BY kind, conversation_id`
+	rows, err := s.db.QueryContext(ctx, query, summary.Settings.UserID, since)
+	if err != nil {
+		return fmt.Errorf("failed to count notifications: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, conversationID string
+		var count int
+		if err := rows.Scan(&kind, &conversationID, &count); err != nil {
+			return fmt.Errorf("failed to scan notification count: %w", err)
+		}
+		switch kind {
+		case NotificationMessage:
+			summary.Conversations++
+			summary.Messages += count
+			if conversationID != "" {
+				summary.Senders = append(summary.Senders, conversationID)
+			}
+		case NotificationCall:
+			summary.MissedCalls += count
+		}
+	}
+	return rows.Err()
+}
+
+// ensureDigestSettings создает настройки по умолчанию (включено, раз в сутки, приватный режим)
+func (s *Storage) ensureDigestSettings(ctx context.Context, userID string) (*DigestSettings, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate mute token: %w", err)
+	}
+
+	insert := `INSERT INTO digest_settings (user_id, mute_token, last_seen_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, insert, userID, hex.EncodeToString(token), time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to create digest settings: %w", err)
+	}
+
+	query := `SELECT user_id, enabled, interval_seconds, privacy, mute_token, last_digest_at, last_seen_at
+		FROM digest_settings WHERE user_id = $1`
+	settings, _, err := scanDigestSettings(s.db.QueryRowContext(ctx, query, userID), false)
+	return settings, err
+}
+
+func scanDigestSettings(row rowScanner, withEmail bool) (*DigestSettings, string, error) {
+	settings := &DigestSettings{}
+	var intervalSeconds int64
+	var lastDigestAt sql.NullTime
+	var email string
+
+	dest := []interface{}{&settings.UserID, &settings.Enabled, &intervalSeconds, &settings.Privacy,
+		&settings.MuteToken, &lastDigestAt, &settings.LastSeenAt}
+	if withEmail {
+		dest = append(dest, &email)
+	}
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", fmt.Errorf("digest settings not found: %w", err)
+		}
+		return nil, "", fmt.Errorf("failed to scan digest settings: %w", err)
+	}
+
+	settings.Interval = time.Duration(intervalSeconds) * time.Second
+	settings.LastDigestAt = lastDigestAt.Time
+	return settings, email, nil
+}
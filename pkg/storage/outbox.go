@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// OutboxMessage - сообщение, принятое в режиме обслуживания и ожидающее доставки
+type OutboxMessage struct {
+	ID          int64     `json:"id"`
+	SenderID    string    `json:"sender_id,omitempty"`
+	RecipientID string    `json:"recipient_id,omitempty"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// EnqueueOutbox сохраняет сообщение в исходящие; msg.ID и msg.CreatedAt заполняются
+func (s *Storage) EnqueueOutbox(ctx context.Context, msg *OutboxMessage) error {
+	query := "INSERT INTO outbox (sender_id, recipient_id, body) VALUES ($1, $2, $3) RETURNING id, created_at"
+	if err := s.db.QueryRowContext(ctx, query, msg.SenderID, msg.RecipientID, s.keys.Seal(msg.Body)).Scan(&msg.ID, &msg.CreatedAt); err != nil {
+		return fmt.Errorf("failed to enqueue outbox message: %w", err)
+	}
+	return nil
+}
+
+// ListOutbox возвращает исходящие в порядке поступления
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	query := "SELECT id, sender_id, recipient_id, body, created_at FROM outbox ORDER BY id LIMIT $1"
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*OutboxMessage
+	for rows.Next() {
+		msg := &OutboxMessage{}
+		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.RecipientID, &msg.Body, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		if msg.Body, err = s.keys.Open(msg.Body); err != nil {
+			return nil, fmt.Errorf("failed to decrypt outbox message %d: %w", msg.ID, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteOutbox удаляет доставленное сообщение из исходящих
+func (s *Storage) DeleteOutbox(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM outbox WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete outbox message: %w", err)
+	}
+	return nil
+}
+
+// CountOutbox возвращает число недоставленных исходящих
+func (s *Storage) CountOutbox(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM outbox").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count outbox: %w", err)
+	}
+	return count, nil
+}
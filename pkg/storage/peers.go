@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// ListStaticPeers возвращает статические пиры mesh в порядке добавления
+func (s *Storage) ListStaticPeers(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT addr FROM static_peers ORDER BY added_at, addr")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list static peers: %w", err)
+	}
+	defer rows.Close()
+
+	var peers []string
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return nil, fmt.Errorf("failed to scan static peer: %w", err)
+		}
+		peers = append(peers, addr)
+	}
+	return peers, rows.Err()
+}
+
+// AddStaticPeer сохраняет статический пир; повторное добавление не является ошибкой
+func (s *Storage) AddStaticPeer(ctx context.Context, addr string) error {
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO static_peers (addr) VALUES ($1) ON CONFLICT (addr) DO NOTHING", addr); err != nil {
+		return fmt.Errorf("failed to add static peer: %w", err)
+	}
+	return nil
+}
+
+// RemoveStaticPeer удаляет статический пир
+func (s *Storage) RemoveStaticPeer(ctx context.Context, addr string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM static_peers WHERE addr = $1", addr); err != nil {
+		return fmt.Errorf("failed to remove static peer: %w", err)
+	}
+	return nil
+}
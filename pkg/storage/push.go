@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Виды подписок push уведомлений
+const (
+	PushWebPush = "webpush"
+	PushFCM     = "fcm"
+)
+
+// PushSubscription - адрес push уведомлений устройства. Для Web Push Endpoint - URL службы
+// push браузера, P256dh и Auth - ключи шифрования содержимого; для FCM Endpoint - токен
+// регистрации приложения.
+type PushSubscription struct {
+	UserID    string    `json:"user_id"`
+	DeviceID  string    `json:"device_id"`
+	Kind      string    `json:"kind"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"-"`
+	Auth      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavePushSubscription сохраняет подписку устройства вместо прежней. Адрес, который
+// раньше принадлежал другому устройству или пользователю, переходит к этому устройству.
+func (s *Storage) SavePushSubscription(ctx context.Context, sub *PushSubscription) error {
+	sub.CreatedAt = time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE endpoint = $1", sub.Endpoint); err != nil {
+		return fmt.Errorf("failed to release push endpoint: %w", err)
+	}
+	query := `INSERT INTO push_subscriptions (user_id, device_id, kind, endpoint, p256dh, auth, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET kind = $3, endpoint = $4, p256dh = $5, auth = $6, created_at = $7`
+	_, err = tx.ExecContext(ctx, query, sub.UserID, sub.DeviceID, sub.Kind, sub.Endpoint, sub.P256dh, s.keys.Seal(sub.Auth), sub.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListPushSubscriptions возвращает подписки устройств пользователя
+func (s *Storage) ListPushSubscriptions(ctx context.Context, userID string) ([]*PushSubscription, error) {
+	query := `SELECT user_id, device_id, kind, endpoint, p256dh, auth, created_at FROM push_subscriptions
+		WHERE user_id = $1 ORDER BY device_id`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list push subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*PushSubscription
+	for rows.Next() {
+		sub := &PushSubscription{}
+		if err := rows.Scan(&sub.UserID, &sub.DeviceID, &sub.Kind, &sub.Endpoint, &sub.P256dh, &sub.Auth, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan push subscription: %w", err)
+		}
+		if sub.Auth, err = s.keys.Open(sub.Auth); err != nil {
+			return nil, fmt.Errorf("failed to decrypt push subscription of %s: %w", sub.DeviceID, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// DeletePushSubscription удаляет подписку устройства; false - подписки не было
+func (s *Storage) DeletePushSubscription(ctx context.Context, userID, deviceID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE user_id = $1 AND device_id = $2", userID, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete push subscription: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete push subscription: %w", err)
+	}
+	return n > 0, nil
+}
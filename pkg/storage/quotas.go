@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Квоты групп и каналов. Группа (канал) - беседа с conversation_id, ее групповые звонки
+// идут в комнате с тем же ID: объем медиа - суммарный размер записей звонков комнаты и
+// вложений беседы, число сообщений - сообщения беседы.
+
+// Политики при превышении квоты
+const (
+	QuotaEvictOldest = "evict_oldest" // удалять самые старые медиа и сообщения
+	QuotaReject      = "reject"       // не принимать новые медиа и сообщения
+)
+
+// Quota - ограничения группы; 0 - без ограничения
+type Quota struct {
+	GroupID       string   `json:"group_id"`
+	MaxMessages   int64    `json:"max_messages"`
+	MaxMediaBytes int64    `json:"max_media_bytes"`
+	Eviction      string   `json:"eviction"`
+	Admins        []string `json:"admins"` // администраторы группы, получающие предупреждения
+}
+
+// QuotaUsage - занятое группой место
+type QuotaUsage struct {
+	Messages   int64 `json:"messages"`
+	MediaBytes int64 `json:"media_bytes"`
+}
+
+// GetQuota возвращает квоту группы; nil - квота не задана
+func (s *Storage) GetQuota(ctx context.Context, groupID string) (*Quota, error) {
+	q := &Quota{GroupID: groupID}
+	var admins string
+	query := "SELECT max_messages, max_media_bytes, eviction, admins FROM group_quotas WHERE group_id = $1"
+	err := s.db.QueryRowContext(ctx, query, groupID).Scan(&q.MaxMessages, &q.MaxMediaBytes, &q.Eviction, &admins)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+	q.Admins = splitList(admins)
+	return q, nil
+}
+
+// ListQuotas возвращает квоты всех групп
+func (s *Storage) ListQuotas(ctx context.Context) ([]*Quota, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT group_id, max_messages, max_media_bytes, eviction, admins FROM group_quotas ORDER BY group_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quotas: %w", err)
+	}
+	defer rows.Close()
+
+	var quotas []*Quota
+	for rows.Next() {
+		q := &Quota{}
+		var admins string
+		if err := rows.Scan(&q.GroupID, &q.MaxMessages, &q.MaxMediaBytes, &q.Eviction, &admins); err != nil {
+			return nil, fmt.Errorf("failed to scan quota: %w", err)
+		}
+		q.Admins = splitList(admins)
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetQuota создает или заменяет квоту группы
+func (s *Storage) SetQuota(ctx context.Context, q *Quota) error {
+	query := `INSERT INTO group_quotas (group_id, max_messages, max_media_bytes, eviction, admins) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (group_id) DO UPDATE SET max_messages = $2, max_media_bytes = $3, eviction = $4, admins = $5`
+	_, err := s.db.ExecContext(ctx, query, q.GroupID, q.MaxMessages, q.MaxMediaBytes, q.Eviction, strings.Join(q.Admins, ","))
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %w", err)
+	}
+	return nil
+}
+
+// DeleteQuota удаляет квоту группы; false - квоты не было
+func (s *Storage) DeleteQuota(ctx context.Context, groupID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM group_quotas WHERE group_id = $1", groupID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete quota: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete quota: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetQuotaUsage считает сообщения беседы и объем записей комнаты и вложений группы
+func (s *Storage) GetQuotaUsage(ctx context.Context, groupID string) (*QuotaUsage, error) {
+	usage := &QuotaUsage{}
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages WHERE conversation_id = $1", groupID).Scan(&usage.Messages); err != nil {
+		return nil, fmt.Errorf("failed to count group messages: %w", err)
+	}
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size_bytes), 0) FROM recordings WHERE room_id = $1", groupID).Scan(&usage.MediaBytes); err != nil {
+		return nil, fmt.Errorf("failed to count group media: %w", err)
+	}
+	var files int64
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size_bytes), 0) FROM attachments WHERE conversation_id = $1", groupID).Scan(&files); err != nil {
+		return nil, fmt.Errorf("failed to count group attachments: %w", err)
+	}
+	usage.MediaBytes += files
+	return usage, nil
+}
+
+// ListRoomRecordings возвращает записи комнаты, начиная с самых старых
+func (s *Storage) ListRoomRecordings(ctx context.Context, roomID string) ([]*Recording, error) {
+	return s.queryRecordings(ctx, "SELECT "+recordingColumns+" FROM recordings WHERE room_id = $1 ORDER BY started_at", roomID)
+}
+
+// DeleteOldestMessages удаляет самые старые сообщения беседы, оставляя keep последних,
+// и возвращает число удаленных
+func (s *Storage) DeleteOldestMessages(ctx context.Context, conversationID string, keep int64) (int64, error) {
+	// SQLite не принимает OFFSET без LIMIT, отрицательный LIMIT у него - без ограничения
+	all := "ALL"
+	if s.dialect == dialectSQLite {
+		all = "-1"
+	}
+	query := `DELETE FROM messages WHERE id IN (
+		SELECT id FROM messages WHERE conversation_id = $1 ORDER BY created_at DESC LIMIT ` + all + ` OFFSET $2)`
+	result, err := s.db.ExecContext(ctx, query, conversationID, keep)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old messages: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old messages: %w", err)
+	}
+	return n, nil
+}
+
+// splitList разбирает список через запятую; пустая строка - пустой список
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
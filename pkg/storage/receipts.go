@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Состояния доставки сообщения получателю. Состояние только продвигается вперед:
+// квитанция delivered после read ничего не меняет.
+const (
+	ReceiptSent      = "sent"
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// MessageReceipt - состояние доставки сообщения SenderID получателю UserID
+type MessageReceipt struct {
+	MessageID   string    `json:"message_id"`
+	UserID      string    `json:"user_id"`
+	SenderID    string    `json:"sender_id"`
+	Status      string    `json:"status"`
+	SentAt      time.Time `json:"sent_at"`
+	DeliveredAt time.Time `json:"delivered_at,omitempty"`
+	ReadAt      time.Time `json:"read_at,omitempty"`
+}
+
+const receiptColumns = "message_id, user_id, sender_id, status, sent_at, delivered_at, read_at"
+
+// CreateReceipts отмечает сообщение отправленным получателям recipients
+func (s *Storage) CreateReceipts(ctx context.Context, messageID, senderID string, recipients []string) error {
+	now := time.Now()
+	query := `INSERT INTO message_receipts (message_id, user_id, sender_id, status, sent_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, user_id) DO NOTHING`
+	for _, userID := range recipients {
+		if _, err := s.db.ExecContext(ctx, query, messageID, userID, senderID, ReceiptSent, now); err != nil {
+			return fmt.Errorf("failed to create receipt: %w", err)
+		}
+	}
+	return nil
+}
+
+// UpdateReceipt продвигает состояние доставки получателю до status (delivered или read)
+// и возвращает квитанцию; changed - состояние изменилось
+func (s *Storage) UpdateReceipt(ctx context.Context, messageID, userID, status string, at time.Time) (*MessageReceipt, bool, error) {
+	var query string
+	switch status {
+	case ReceiptDelivered:
+		query = "UPDATE message_receipts SET status = 'delivered', delivered_at = $1 WHERE message_id = $2 AND user_id = $3 AND status = 'sent'"
+	case ReceiptRead:
+		query = `UPDATE message_receipts SET status = 'read', read_at = $1, delivered_at = COALESCE(delivered_at, $1)
+			WHERE message_id = $2 AND user_id = $3 AND status <> 'read'`
+	default:
+		return nil, false, fmt.Errorf("invalid receipt status %q", status)
+	}
+	result, err := s.db.ExecContext(ctx, query, at, messageID, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update receipt: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update receipt: %w", err)
+	}
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+receiptColumns+" FROM message_receipts WHERE message_id = $1 AND user_id = $2", messageID, userID)
+	receipt, err := scanReceipt(row)
+	if err == sql.ErrNoRows {
+		return nil, false, fmt.Errorf("receipt not found")
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get receipt: %w", err)
+	}
+	return receipt, n > 0, nil
+}
+
+// ListReceipts возвращает состояние доставки сообщения всем получателям
+func (s *Storage) ListReceipts(ctx context.Context, messageID string) ([]*MessageReceipt, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+receiptColumns+" FROM message_receipts WHERE message_id = $1 ORDER BY user_id", messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+	defer rows.Close()
+
+	var receipts []*MessageReceipt
+	for rows.Next() {
+		receipt, err := scanReceipt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %w", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+func scanReceipt(row rowScanner) (*MessageReceipt, error) {
+	receipt := &MessageReceipt{}
+	var deliveredAt, readAt sql.NullTime
+	err := row.Scan(&receipt.MessageID, &receipt.UserID, &receipt.SenderID, &receipt.Status, &receipt.SentAt, &deliveredAt, &readAt)
+	if err != nil {
+		return nil, err
+	}
+	receipt.DeliveredAt = deliveredAt.Time
+	receipt.ReadAt = readAt.Time
+	return receipt, nil
+}
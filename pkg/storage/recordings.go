@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recording - метаданные серверной записи звонка. Сами файлы хранятся в blob-хранилище
+// под ключами recordings/<id>/<call_id>.ogg.
+type Recording struct {
+	ID           string    `json:"id"`
+	RoomID       string    `json:"room_id"`
+	RequestedBy  string    `json:"requested_by"`
+	Participants []string  `json:"participants"` // звонки, давшие согласие и попавшие в запись
+	Users        []string  `json:"users"`        // пользователи этих звонков
+	SizeBytes    int64     `json:"size_bytes"`
+	StartedAt    time.Time `json:"started_at"`
+	StoppedAt    time.Time `json:"stopped_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+const recordingColumns = "id, room_id, requested_by, participants, users, size_bytes, started_at, stopped_at, expires_at"
+
+func (s *Storage) CreateRecording(ctx context.Context, rec *Recording) error {
+	query := "INSERT INTO recordings (" + recordingColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	_, err := s.db.ExecContext(ctx, query, rec.ID, rec.RoomID, rec.RequestedBy, strings.Join(rec.Participants, ","),
+		strings.Join(rec.Users, ","), rec.SizeBytes, rec.StartedAt, rec.StoppedAt, rec.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create recording: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) GetRecording(ctx context.Context, id string) (*Recording, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+recordingColumns+" FROM recordings WHERE id = $1", id)
+	rec, err := scanRecording(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("recording not found")
+	}
+	return rec, err
+}
+
+// ListRecordings возвращает записи, начиная с самых новых
+func (s *Storage) ListRecordings(ctx context.Context) ([]*Recording, error) {
+	return s.queryRecordings(ctx, "SELECT "+recordingColumns+" FROM recordings ORDER BY started_at DESC")
+}
+
+// ListExpiredRecordings возвращает записи с истекшим сроком хранения
+func (s *Storage) ListExpiredRecordings(ctx context.Context, now time.Time) ([]*Recording, error) {
+	return s.queryRecordings(ctx, "SELECT "+recordingColumns+" FROM recordings WHERE expires_at <= $1", now)
+}
+
+func (s *Storage) DeleteRecording(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM recordings WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete recording: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) queryRecordings(ctx context.Context, query string, args ...interface{}) ([]*Recording, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recordings: %w", err)
+	}
+	defer rows.Close()
+
+	var recordings []*Recording
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, rec)
+	}
+	return recordings, rows.Err()
+}
+
+func scanRecording(row rowScanner) (*Recording, error) {
+	rec := &Recording{}
+	var participants, users string
+	err := row.Scan(&rec.ID, &rec.RoomID, &rec.RequestedBy, &participants, &users, &rec.SizeBytes, &rec.StartedAt, &rec.StoppedAt, &rec.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if participants != "" {
+		rec.Participants = strings.Split(participants, ",")
+	}
+	if users != "" {
+		rec.Users = strings.Split(users, ",")
+	}
+	return rec, nil
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"hydra/pkg/password"
+	"hydra/pkg/recovery"
+	"strings"
+	"time"
+)
+
+// RecoveryGuardians - поручители пользователя и сколько одобрений нужно для восстановления
+type RecoveryGuardians struct {
+	UserID    string    `json:"user_id"`
+	Guardians []string  `json:"guardians"`
+	Threshold int       `json:"threshold"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RecoveryRequest - запрос восстановления аккаунта. Завершить его может только
+// тот, кто знает секрет, выданный при создании (хранится только хеш).
+type RecoveryRequest struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	SecretHash  string     `json:"-"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Cancelled   bool       `json:"cancelled"`
+}
+
+// Active сообщает, что запрос еще можно одобрять и завершать
+func (r *RecoveryRequest) Active(now time.Time) bool {
+	return r.CompletedAt == nil && !r.Cancelled && now.Before(r.ExpiresAt)
+}
+
+// GetRecoveryGuardians возвращает поручителей пользователя; nil - не назначены
+func (s *Storage) GetRecoveryGuardians(ctx context.Context, userID string) (*RecoveryGuardians, error) {
+	g := &RecoveryGuardians{UserID: userID}
+	var guardians string
+	query := "SELECT guardians, threshold, updated_at FROM recovery_guardians WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&guardians, &g.Threshold, &g.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recovery guardians: %w", err)
+	}
+	g.Guardians = splitList(guardians)
+	return g, nil
+}
+
+// SetRecoveryGuardians назначает поручителей, заменяя прежних
+func (s *Storage) SetRecoveryGuardians(ctx context.Context, g *RecoveryGuardians) error {
+	g.UpdatedAt = time.Now()
+	query := `INSERT INTO recovery_guardians (user_id, guardians, threshold, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET guardians = $2, threshold = $3, updated_at = $4`
+	if _, err := s.db.ExecContext(ctx, query, g.UserID, strings.Join(g.Guardians, ","), g.Threshold, g.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to set recovery guardians: %w", err)
+	}
+	return nil
+}
+
+// DeleteRecoveryGuardians отключает восстановление поручителями; false - они не были назначены
+func (s *Storage) DeleteRecoveryGuardians(ctx context.Context, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM recovery_guardians WHERE user_id = $1", userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete recovery guardians: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete recovery guardians: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CreateRecoveryRequest сохраняет новый запрос восстановления
+func (s *Storage) CreateRecoveryRequest(ctx context.Context, req *RecoveryRequest) error {
+	req.ID = "recovery-" + ids.New()
+	query := "INSERT INTO recovery_requests (id, user_id, secret_hash, requested_at, expires_at) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := s.db.ExecContext(ctx, query, req.ID, req.UserID, req.SecretHash, req.RequestedAt, req.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to create recovery request: %w", err)
+	}
+	return nil
+}
+
+// GetRecoveryRequest возвращает запрос восстановления; nil - запроса нет
+func (s *Storage) GetRecoveryRequest(ctx context.Context, id string) (*RecoveryRequest, error) {
+	req := &RecoveryRequest{ID: id}
+	var completedAt sql.NullTime
+	query := "SELECT user_id, secret_hash, requested_at, expires_at, completed_at, cancelled FROM recovery_requests WHERE id = $1"
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&req.UserID, &req.SecretHash, &req.RequestedAt, &req.ExpiresAt, &completedAt, &req.Cancelled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recovery request: %w", err)
+	}
+	if completedAt.Valid {
+		req.CompletedAt = &completedAt.Time
+	}
+	return req, nil
+}
+
+// CancelRecoveryRequests отменяет незавершенные запросы восстановления пользователя
+// (все или только id) и возвращает число отмененных
+func (s *Storage) CancelRecoveryRequests(ctx context.Context, userID, id string) (int64, error) {
+	query := "UPDATE recovery_requests SET cancelled = TRUE WHERE user_id = $1 AND completed_at IS NULL AND NOT cancelled AND ($2 = '' OR id = $2)"
+	result, err := s.db.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel recovery requests: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel recovery requests: %w", err)
+	}
+	return n, nil
+}
+
+// AddRecoveryApproval записывает подписанное одобрение; false - поручитель уже одобрил запрос
+func (s *Storage) AddRecoveryApproval(ctx context.Context, a *recovery.Approval) (bool, error) {
+	query := `INSERT INTO recovery_approvals (recovery_id, guardian_id, approved_at, signature, public_key) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (recovery_id, guardian_id) DO NOTHING`
+	result, err := s.db.ExecContext(ctx, query, a.RecoveryID, a.GuardianID, a.ApprovedAt, a.Signature, a.PublicKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to save recovery approval: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to save recovery approval: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListRecoveryApprovals возвращает одобрения запроса в порядке поступления
+func (s *Storage) ListRecoveryApprovals(ctx context.Context, req *RecoveryRequest) ([]*recovery.Approval, error) {
+	query := "SELECT guardian_id, approved_at, signature, public_key FROM recovery_approvals WHERE recovery_id = $1 ORDER BY approved_at"
+	rows, err := s.db.QueryContext(ctx, query, req.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recovery approvals: %w", err)
+	}
+	defer rows.Close()
+
+	var approvals []*recovery.Approval
+	for rows.Next() {
+		a := &recovery.Approval{RecoveryID: req.ID, UserID: req.UserID}
+		if err := rows.Scan(&a.GuardianID, &a.ApprovedAt, &a.Signature, &a.PublicKey); err != nil {
+			return nil, fmt.Errorf("failed to scan recovery approval: %w", err)
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
+
+// CompleteRecovery в одной транзакции задает новый пароль, завершает запрос и отменяет
+// остальные запросы восстановления пользователя
+func (s *Storage) CompleteRecovery(ctx context.Context, req *RecoveryRequest, plain string) error {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("failed to complete recovery: %w", err)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to complete recovery: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE recovery_requests SET completed_at = $1 WHERE id = $2 AND completed_at IS NULL AND NOT cancelled"
+	result, err := tx.ExecContext(ctx, query, time.Now(), req.ID)
+	if err != nil {
+		return fmt.Errorf("failed to complete recovery: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("recovery request is no longer active")
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", hash, req.UserID); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	query = "UPDATE recovery_requests SET cancelled = TRUE WHERE user_id = $1 AND id <> $2 AND completed_at IS NULL"
+	if _, err := tx.ExecContext(ctx, query, req.UserID, req.ID); err != nil {
+		return fmt.Errorf("failed to cancel other recovery requests: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to complete recovery: %w", err)
+	}
+	return nil
+}
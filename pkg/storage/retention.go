@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Сроки хранения истории бесед. Для беседы с политикой фоновая очистка удаляет
+// сообщения, вложения, голосовые сообщения и архивы холодного хранения старше TTL.
+// Беседы без политики хранятся бессрочно.
+
+// RetentionPolicy - срок хранения истории беседы
+type RetentionPolicy struct {
+	ConversationID string        `json:"conversation_id"`
+	TTL            time.Duration `json:"ttl"`
+	UpdatedBy      string        `json:"updated_by"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// VoiceFile - файл голосового сообщения беседы в VOICE_STORAGE_PATH
+type VoiceFile struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id,omitempty"`
+	Path           string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// GetRetention возвращает политику беседы; nil - история хранится бессрочно
+func (s *Storage) GetRetention(ctx context.Context, conversationID string) (*RetentionPolicy, error) {
+	p := &RetentionPolicy{ConversationID: conversationID}
+	var ttlSeconds int64
+	query := "SELECT ttl_seconds, updated_by, updated_at FROM retention_policies WHERE conversation_id = $1"
+	err := s.db.QueryRowContext(ctx, query, conversationID).Scan(&ttlSeconds, &p.UpdatedBy, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention policy: %w", err)
+	}
+	p.TTL = time.Duration(ttlSeconds) * time.Second
+	return p, nil
+}
+
+// ListRetention возвращает политики всех бесед
+func (s *Storage) ListRetention(ctx context.Context) ([]*RetentionPolicy, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT conversation_id, ttl_seconds, updated_by, updated_at FROM retention_policies ORDER BY conversation_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []*RetentionPolicy
+	for rows.Next() {
+		p := &RetentionPolicy{}
+		var ttlSeconds int64
+		if err := rows.Scan(&p.ConversationID, &ttlSeconds, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		p.TTL = time.Duration(ttlSeconds) * time.Second
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// SetRetention создает или заменяет политику беседы; TTL 0 - хранить бессрочно
+func (s *Storage) SetRetention(ctx context.Context, p *RetentionPolicy) error {
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+	if p.TTL <= 0 {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM retention_policies WHERE conversation_id = $1", p.ConversationID); err != nil {
+			return fmt.Errorf("failed to delete retention policy: %w", err)
+		}
+		return nil
+	}
+
+	query := `INSERT INTO retention_policies (conversation_id, ttl_seconds, updated_by, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (conversation_id) DO UPDATE SET ttl_seconds = $2, updated_by = $3, updated_at = $4`
+	_, err := s.db.ExecContext(ctx, query, p.ConversationID, int64(p.TTL/time.Second), p.UpdatedBy, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set retention policy: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessagesBefore удаляет сообщения беседы старше before вместе с их квитанциями
+// и историей правок и возвращает число удаленных сообщений
+func (s *Storage) DeleteMessagesBefore(ctx context.Context, conversationID string, before time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM message_receipts WHERE message_id IN
+		(SELECT id FROM messages WHERE conversation_id = $1 AND created_at < $2)`, conversationID, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete receipts: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM message_edits WHERE message_id IN
+		(SELECT id FROM messages WHERE conversation_id = $1 AND created_at < $2)`, conversationID, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete message edits: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE conversation_id = $1 AND created_at < $2", conversationID, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete messages: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// ListAttachmentsBefore возвращает вложения беседы, загруженные раньше before
+func (s *Storage) ListAttachmentsBefore(ctx context.Context, conversationID string, before time.Time) ([]*Attachment, error) {
+	query := "SELECT " + attachmentColumns + " FROM attachments WHERE conversation_id = $1 AND created_at < $2"
+	rows, err := s.db.QueryContext(ctx, query, conversationID, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// DeleteMessageArchive удаляет запись об архиве холодного хранения
+func (s *Storage) DeleteMessageArchive(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM message_archives WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete message archive: %w", err)
+	}
+	return nil
+}
+
+// CreateVoiceFile сохраняет запись о голосовом сообщении беседы
+func (s *Storage) CreateVoiceFile(ctx context.Context, v *VoiceFile) error {
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	query := "INSERT INTO voice_files (id, conversation_id, sender_id, path, created_at) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := s.db.ExecContext(ctx, query, v.ID, v.ConversationID, v.SenderID, v.Path, v.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create voice file: %w", err)
+	}
+	return nil
+}
+
+// ListVoiceFilesBefore возвращает голосовые сообщения беседы, записанные раньше before
+func (s *Storage) ListVoiceFilesBefore(ctx context.Context, conversationID string, before time.Time) ([]*VoiceFile, error) {
+	query := "SELECT id, conversation_id, sender_id, path, created_at FROM voice_files WHERE conversation_id = $1 AND created_at < $2"
+	rows, err := s.db.QueryContext(ctx, query, conversationID, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list voice files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []*VoiceFile
+	for rows.Next() {
+		v := &VoiceFile{}
+		if err := rows.Scan(&v.ID, &v.ConversationID, &v.SenderID, &v.Path, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan voice file: %w", err)
+		}
+		files = append(files, v)
+	}
+	return files, rows.Err()
+}
+
+// DeleteVoiceFile удаляет запись о голосовом сообщении
+func (s *Storage) DeleteVoiceFile(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM voice_files WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete voice file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Роли сотрудников организации. Роли выдает администратор; действия с ролями выполняются
+// с личным токеном входа сотрудника, чтобы в журнале аудита было видно, кто их совершил.
+const (
+	RoleAdmin             = "admin"              // /api/admin/* наравне с ADMIN_TOKEN
+	RoleComplianceOfficer = "compliance_officer" // выгрузка метаданных аккаунтов
+	RoleAuditor           = "auditor"            // чтение журнала аудита
+)
+
+// KnownRole сообщает, существует ли роль
+func KnownRole(role string) bool {
+	return role == RoleAdmin || role == RoleComplianceOfficer || role == RoleAuditor
+}
+
+// RoleGrant - выданная пользователю роль
+type RoleGrant struct {
+	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// GrantRole выдает роль; false - роль уже была выдана
+func (s *Storage) GrantRole(ctx context.Context, userID, role, grantedBy string) (bool, error) {
+	query := "INSERT INTO roles (user_id, role, granted_by, granted_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+	result, err := s.db.ExecContext(ctx, query, userID, role, grantedBy, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to grant role: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to grant role: %w", err)
+	}
+	return n > 0, nil
+}
+
+// RevokeRole отзывает роль; false - роли не было
+func (s *Storage) RevokeRole(ctx context.Context, userID, role string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM roles WHERE user_id = $1 AND role = $2", userID, role)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return n > 0, nil
+}
+
+// HasRole сообщает, есть ли у пользователя роль
+func (s *Storage) HasRole(ctx context.Context, userID, role string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE user_id = $1 AND role = $2)", userID, role).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check role: %w", err)
+	}
+	return exists, nil
+}
+
+// ListRoles возвращает все выданные роли
+func (s *Storage) ListRoles(ctx context.Context) ([]*RoleGrant, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, role, granted_by, granted_at FROM roles ORDER BY user_id, role")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []*RoleGrant
+	for rows.Next() {
+		g := &RoleGrant{}
+		if err := rows.Scan(&g.UserID, &g.Role, &g.GrantedBy, &g.GrantedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
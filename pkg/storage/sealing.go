@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/seal"
+)
+
+// sealedColumns - столбцы, шифруемые при включенном шифровании (см. pkg/seal).
+// Контакты шифруются детерминированно: по ним ищут пользователей.
+var sealedColumns = []struct {
+	table, key, column string
+	deterministic      bool
+}{
+	{"users", "id", "email", true},
+	{"users", "id", "phone", true},
+	{"messages", "id", "body", false},
+	{"queued_messages", "id", "body", false},
+	{"outbox", "id", "body", false},
+	{"inbox", "id", "body", false},
+	{"message_edits", "id", "body", false},
+	{"push_subscriptions", "endpoint", "auth", false},
+	{"external_identities", "id", "email", false},
+}
+
+// SetKeyring включает шифрование текстов сообщений и контактов; nil - выключает.
+// Строки, записанные без шифрования, читаются как есть.
+func (s *Storage) SetKeyring(keys *seal.Keyring) {
+	s.keys = keys
+}
+
+// openUser расшифровывает контакты пользователя
+func (s *Storage) openUser(user *User) error {
+	var err error
+	if user.Email, err = s.keys.Open(user.Email); err != nil {
+		return fmt.Errorf("failed to decrypt email of %s: %w", user.ID, err)
+	}
+	if user.Phone, err = s.keys.Open(user.Phone); err != nil {
+		return fmt.Errorf("failed to decrypt phone of %s: %w", user.ID, err)
+	}
+	return nil
+}
+
+// openMessages расшифровывает тексты сообщений
+func (s *Storage) openMessages(messages []*Message) error {
+	for _, msg := range messages {
+		body, err := s.keys.Open(msg.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt message %s: %w", msg.ID, err)
+		}
+		msg.Body = body
+	}
+	return nil
+}
+
+// contactMatch возвращает условие "column равен value" для контакта, записанного
+// открыто или детерминированным шифротекстом любого ключа, и параметр для $n
+func (s *Storage) contactMatch(column string, n int, value string) (string, interface{}) {
+	return s.inList(column, n, s.keys.Candidates(value))
+}
+
+// openJSONColumns расшифровывает столбцы в строках, выгруженных rowsJSON
+func (s *Storage) openJSONColumns(data []byte, columns ...string) ([]byte, error) {
+	if !s.keys.Enabled() {
+		return data, nil
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		for _, column := range columns {
+			value, ok := row[column].(string)
+			if !ok {
+				continue
+			}
+			opened, err := s.keys.Open(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", column, err)
+			}
+			row[column] = opened
+		}
+	}
+	return json.Marshal(rows)
+}
+
+// RotateKeys перешифровывает текущим ключом все значения, записанные открыто или
+// другими ключами, и возвращает число измененных значений. После нее старые ключи
+// можно убрать из набора.
+func (s *Storage) RotateKeys(ctx context.Context, batch int) (int, error) {
+	if !s.keys.Enabled() {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+	if batch <= 0 {
+		batch = 500
+	}
+
+	total := 0
+	for _, c := range sealedColumns {
+		after := ""
+		for {
+			query := fmt.Sprintf("SELECT %[1]s, %[2]s FROM %[3]s WHERE CAST(%[1]s AS TEXT) > $1 ORDER BY CAST(%[1]s AS TEXT) LIMIT $2", c.key, c.column, c.table)
+			rows, err := s.db.QueryContext(ctx, query, after, batch)
+			if err != nil {
+				return total, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+			}
+			type row struct{ key, value string }
+			var pending []row
+			n := 0
+			for rows.Next() {
+				var r row
+				var value *string
+				if err := rows.Scan(&r.key, &value); err != nil {
+					rows.Close()
+					return total, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+				}
+				n++
+				after = r.key
+				if value != nil && s.keys.NeedsRotation(*value) {
+					r.value = *value
+					pending = append(pending, r)
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return total, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+			}
+
+			for _, r := range pending {
+				plaintext, err := s.keys.Open(r.value)
+				if err != nil {
+					return total, fmt.Errorf("%s.%s of %s: %w", c.table, c.column, r.key, err)
+				}
+				sealed := s.keys.Seal(plaintext)
+				if c.deterministic {
+					sealed = s.keys.SealDeterministic(plaintext)
+				}
+				// Условие на старое значение: строку могли изменить после чтения
+				update := fmt.Sprintf("UPDATE %[1]s SET %[2]s = $1 WHERE CAST(%[3]s AS TEXT) = $2 AND %[2]s = $3", c.table, c.column, c.key)
+				if _, err := s.db.ExecContext(ctx, update, sealed, r.key, r.value); err != nil {
+					return total, fmt.Errorf("failed to update %s.%s of %s: %w", c.table, c.column, r.key, err)
+				}
+				total++
+			}
+			if n < batch {
+				break
+			}
+		}
+	}
+	return total, nil
+}
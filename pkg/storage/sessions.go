@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hydra/pkg/ids"
+	"time"
+)
+
+// Session - сессия входа. Клиент предъявляет короткоживущий токен доступа, а по его
+// истечении получает новую пару токенов по токену обновления. В базе хранятся только
+// хеши токенов; сами токены заполнены лишь в ответах CreateSession и RefreshSession.
+type Session struct {
+	ID               string    `json:"id"`
+	UserID           string    `json:"user_id"`
+	DeviceID         string    `json:"device_id,omitempty"`
+	Token            string    `json:"token,omitempty"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	LastUsedAt       time.Time `json:"last_used_at"`
+	SessionClient
+}
+
+// SessionClient - клиент, последним использовавший сессию
+type SessionClient struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+const sessionColumns = "id, user_id, device_id, created_at, expires_at, refresh_expires_at, last_used_at, ip, user_agent"
+
+// CreateSession открывает сессию: токен доступа действует ttl, токен обновления - refreshTTL
+func (s *Storage) CreateSession(ctx context.Context, userID, deviceID string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error) {
+	sess, err := newSession(userID, deviceID, ttl, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	sess.ID = "session-" + ids.New()
+	sess.SessionClient = client
+
+	query := `INSERT INTO sessions (id, user_id, device_id, token_hash, refresh_hash, created_at, expires_at, refresh_expires_at, last_used_at, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err = s.db.ExecContext(ctx, query, sess.ID, sess.UserID, sess.DeviceID, hashToken(sess.Token), hashToken(sess.RefreshToken),
+		sess.CreatedAt, sess.ExpiresAt, sess.RefreshExpiresAt, sess.LastUsedAt, client.IP, client.UserAgent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return sess, nil
+}
+
+// ValidateSession возвращает сессию по действующему токену доступа и отмечает ее
+// использование клиентом client
+func (s *Storage) ValidateSession(ctx context.Context, token string, client SessionClient) (*Session, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE token_hash = $1", hashToken(token))
+	sess, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	now := time.Now()
+	if !now.Before(sess.ExpiresAt) {
+		return nil, fmt.Errorf("session expired")
+	}
+
+	query := "UPDATE sessions SET last_used_at = $1, ip = $2, user_agent = $3 WHERE id = $4"
+	if _, err := s.db.ExecContext(ctx, query, now, client.IP, client.UserAgent, sess.ID); err != nil {
+		return nil, fmt.Errorf("failed to touch session: %w", err)
+	}
+	sess.LastUsedAt, sess.SessionClient = now, client
+	return sess, nil
+}
+
+// RefreshSession выдает новую пару токенов по действующему токену обновления. Прежние
+// токены перестают действовать: повторно предъявленный токен обновления отклоняется.
+func (s *Storage) RefreshSession(ctx context.Context, refreshToken string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error) {
+	next, err := newSession("", "", ttl, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	next.SessionClient = client
+
+	query := `UPDATE sessions SET token_hash = $1, refresh_hash = $2, expires_at = $3, refresh_expires_at = $4, last_used_at = $5, ip = $6, user_agent = $7
+		WHERE refresh_hash = $8 AND refresh_expires_at > $5 RETURNING id, user_id, device_id, created_at`
+	err = s.db.QueryRowContext(ctx, query, hashToken(next.Token), hashToken(next.RefreshToken), next.ExpiresAt,
+		next.RefreshExpiresAt, next.LastUsedAt, client.IP, client.UserAgent, hashToken(refreshToken)).Scan(&next.ID, &next.UserID, &next.DeviceID, &next.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found or expired")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	return next, nil
+}
+
+// RevokeSession завершает сессию пользователя; false - такой сессии у него нет
+func (s *Storage) RevokeSession(ctx context.Context, userID, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListSessions возвращает сессии пользователя, которые еще можно продлить, начиная с
+// последней использованной
+func (s *Storage) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = $1 AND refresh_expires_at > $2 ORDER BY last_used_at DESC, id"
+	rows, err := s.db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме keepID, и возвращает их число
+func (s *Storage) RevokeOtherSessions(ctx context.Context, userID, keepID string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RevokeTokens отзывает токены пользователя, не привязанные к сессии (токены сигнализации),
+// выданные до at
+func (s *Storage) RevokeTokens(ctx context.Context, userID string, at time.Time) error {
+	query := `INSERT INTO token_revocations (user_id, revoked_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at`
+	if _, err := s.db.ExecContext(ctx, query, userID, at); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	return nil
+}
+
+// TokensRevokedAt возвращает время последнего отзыва токенов пользователя; нулевое - не отзывались
+func (s *Storage) TokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	var at time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT revoked_at FROM token_revocations WHERE user_id = $1", userID).Scan(&at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("failed to get token revocation: %w", err)
+	}
+	return at, nil
+}
+
+// RevokeUserSessions завершает все сессии пользователя
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// RevokeDeviceSessions завершает сессии пользователя, открытые на устройстве deviceID
+func (s *Storage) RevokeDeviceSessions(ctx context.Context, userID, deviceID string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1 AND device_id = $2", userID, deviceID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessions удаляет сессии, токен обновления которых истек до now
+func (s *Storage) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE refresh_expires_at <= $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// newSession создает сессию со случайными токенами (без ID)
+func newSession(userID, deviceID string, ttl, refreshTTL time.Duration) (*Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Session{
+		UserID:           userID,
+		DeviceID:         deviceID,
+		Token:            token,
+		RefreshToken:     refresh,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(ttl),
+		RefreshExpiresAt: now.Add(refreshTTL),
+		LastUsedAt:       now,
+	}, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken - хеш токена сессии для хранения в базе. Токены случайные, поэтому
+// достаточно SHA-256 без соли.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	sess := &Session{}
+	err := row.Scan(&sess.ID, &sess.UserID, &sess.DeviceID, &sess.CreatedAt, &sess.ExpiresAt, &sess.RefreshExpiresAt, &sess.LastUsedAt, &sess.IP, &sess.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
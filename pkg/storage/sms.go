@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hydra/pkg/sms"
+	"time"
+)
+
+// SMSMessage - отправленное SMS и состояние его доставки (sms.Status*) по отчетам службы
+type SMSMessage struct {
+	Provider   string    `json:"provider"`
+	ProviderID string    `json:"provider_id"` // идентификатор сообщения у службы
+	Recipient  string    `json:"recipient"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// RecordSMS сохраняет отправленное SMS
+func (s *Storage) RecordSMS(ctx context.Context, msg *SMSMessage) error {
+	msg.CreatedAt = time.Now()
+	msg.UpdatedAt = msg.CreatedAt
+	query := `INSERT INTO sms_messages (provider, provider_id, recipient, status, error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := s.db.ExecContext(ctx, query, msg.Provider, msg.ProviderID, msg.Recipient, msg.Status, msg.Error, msg.CreatedAt, msg.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record SMS: %w", err)
+	}
+	return nil
+}
+
+// UpdateSMSStatus записывает состояние доставки по отчету службы. Окончательное
+// состояние (доставлено или не доставлено) не меняется: отчеты приходят не по порядку.
+// false - сообщения нет или его состояние уже окончательное.
+func (s *Storage) UpdateSMSStatus(ctx context.Context, provider, providerID, status, sendErr string) (bool, error) {
+	query := `UPDATE sms_messages SET status = $1, error = $2, updated_at = $3
+		WHERE provider = $4 AND provider_id = $5 AND status NOT IN ($6, $7)`
+	result, err := s.db.ExecContext(ctx, query, status, sendErr, time.Now(), provider, providerID, sms.StatusDelivered, sms.StatusFailed)
+	if err != nil {
+		return false, fmt.Errorf("failed to update SMS status: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update SMS status: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetSMS возвращает отправленное SMS по идентификатору службы
+func (s *Storage) GetSMS(ctx context.Context, provider, providerID string) (*SMSMessage, error) {
+	query := `SELECT provider, provider_id, recipient, status, error, created_at, updated_at
+		FROM sms_messages WHERE provider = $1 AND provider_id = $2`
+	msg := &SMSMessage{}
+	err := s.db.QueryRowContext(ctx, query, provider, providerID).Scan(&msg.Provider, &msg.ProviderID, &msg.Recipient,
+		&msg.Status, &msg.Error, &msg.CreatedAt, &msg.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("SMS not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SMS: %w", err)
+	}
+	return msg, nil
+}
+
+// DeleteSMSBefore удаляет сведения о SMS, отправленных раньше before
+func (s *Storage) DeleteSMSBefore(ctx context.Context, before time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sms_messages WHERE created_at < $1", before); err != nil {
+		return fmt.Errorf("failed to delete old SMS: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"hydra/pkg/folders"
+	"hydra/pkg/password"
+	"hydra/pkg/seal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSQLiteBackend проверяет хранилище на SQLite: миграции, запросы с ветвлением по
+// диалекту и восстановление из резервной копии
+func TestSQLiteBackend(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("sqlite:" + filepath.Join(t.TempDir(), "hydra.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	migrations, err := s.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := s.SchemaVersion(ctx); err != nil || version != len(migrations) {
+		t.Fatalf("schema version %d (%v), want %d", version, err, len(migrations))
+	}
+
+	user, err := s.CreateUser(ctx, "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetUser(ctx, user.ID); err != nil || got.Email != "alice@example.com" {
+		t.Fatalf("GetUser: %+v, %v", got, err)
+	}
+	// Отмененный запрос не доходит до базы
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.GetUser(cancelled, user.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetUser with cancelled context: %v", err)
+	}
+
+	// Пароль, сохраненный до хеширования, принимается и перехешируется при входе
+	if _, err := s.db.Exec("UPDATE users SET password = $1 WHERE id = $2", "legacy", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateUser(ctx, "alice@example.com", "legacy"); err != nil {
+		t.Fatalf("legacy password rejected: %v", err)
+	}
+	if got, err := s.GetUserByEmail(ctx, "alice@example.com"); err != nil || password.NeedsRehash(got.Password) || !password.Verify(got.Password, "legacy") {
+		t.Fatalf("legacy password not rehashed: %+v, %v", got, err)
+	}
+
+	if _, err := s.AppendEvent(ctx, user.ID, EventFolders, map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := s.ListEvents(ctx, user.ID, 0, 10); err != nil || len(events) != 1 {
+		t.Fatalf("ListEvents: %d events, %v", len(events), err)
+	}
+
+	for _, action := range []string{"grant", "revoke"} {
+		if _, err := s.AppendAudit(ctx, "admin", action, user.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := s.ListAudit(ctx, 0, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ListAudit: %d entries, %v", len(entries), err)
+	}
+	if err := VerifyAuditChain(entries); err != nil {
+		t.Error(err)
+	}
+
+	folder := &folders.Folder{UserID: user.ID, Name: "Работа", Rules: []folders.Rule{{Keyword: "отчет"}}}
+	if _, err := s.SaveFolder(ctx, folder); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := s.ListFolders(ctx, user.ID); err != nil || len(list) != 1 || list[0].Rules[0].Keyword != "отчет" {
+		t.Fatalf("ListFolders: %+v, %v", list, err)
+	}
+
+	msg := &Message{ConversationID: user.ID, SenderID: user.ID, Body: "привет"}
+	if err := s.CreateMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	// Участники беседы - автор и получатели сообщений
+	if err := s.CreateReceipts(ctx, msg.ID, user.ID, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	for userID, want := range map[string]bool{user.ID: true, "bob": true, "mallory": false} {
+		if ok, err := s.IsConversationParticipant(ctx, user.ID, userID); err != nil || ok != want {
+			t.Errorf("IsConversationParticipant(%s) = %v, %v", userID, ok, err)
+		}
+	}
+	// Беседа без сообщений новее часа архивируется, сообщения уходят из таблицы
+	old := &Message{ConversationID: "old", SenderID: user.ID, Body: "давно", CreatedAt: time.Now().Add(-2 * time.Hour)}
+	if err := s.CreateMessage(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	inactive, err := s.ListInactiveConversations(ctx, time.Now().Add(-time.Hour), 10)
+	if err != nil || len(inactive) != 1 || inactive[0] != "old" {
+		t.Fatalf("ListInactiveConversations: %v, %v", inactive, err)
+	}
+	archive := &MessageArchive{ID: "a1", ConversationID: "old", Key: "archives/a1.jsonl.gz", Messages: 1, FirstAt: old.CreatedAt, LastAt: old.CreatedAt}
+	if err := s.CreateMessageArchive(ctx, archive, []string{old.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := s.ListMessagesBefore(ctx, "old", time.Now(), 10); err != nil || len(left) != 0 {
+		t.Fatalf("archived messages left: %d, %v", len(left), err)
+	}
+	if archives, err := s.ListMessageArchives(ctx, "old"); err != nil || len(archives) != 1 || !archives[0].FirstAt.Equal(old.CreatedAt) {
+		t.Fatalf("ListMessageArchives: %+v, %v", archives, err)
+	}
+
+	// Незавершенная загрузка попадает в очистку, завершенная учитывается в квоте медиа
+	upload := &Attachment{OwnerID: user.ID, ConversationID: "g1", Name: "doc.pdf", MimeType: "application/pdf", Size: 1024, CreatedAt: time.Now().Add(-2 * time.Hour)}
+	if err := s.CreateAttachment(ctx, upload); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := s.ListStaleUploads(ctx, time.Now().Add(-time.Hour)); err != nil || len(stale) != 1 || stale[0].ID != upload.ID {
+		t.Fatalf("ListStaleUploads: %+v, %v", stale, err)
+	}
+	if err := s.CompleteAttachment(ctx, upload.ID, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetAttachment(ctx, upload.ID); err != nil || !got.Complete || got.SHA256 != "abc" {
+		t.Fatalf("GetAttachment: %+v, %v", got, err)
+	}
+	if usage, err := s.GetQuotaUsage(ctx, "g1"); err != nil || usage.MediaBytes != 1024 {
+		t.Fatalf("GetQuotaUsage: %+v, %v", usage, err)
+	}
+
+	if _, sections, err := s.ComplianceSnapshot(ctx, []string{user.ID}); err != nil {
+		t.Fatal(err)
+	} else if string(sections[0].Rows) == "[]" {
+		t.Error("compliance snapshot has no accounts")
+	}
+
+	// Дайджест: пользователь не заходил, событие есть, дайджестов еще не было
+	if err := s.TouchUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond) // время по умолчанию в SQLite - с точностью до миллисекунды
+	if err := s.RecordNotification(ctx, user.ID, NotificationMessage, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Add(time.Minute)
+	summaries, err := s.ListDigestSummaries(ctx, now, now)
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("ListDigestSummaries: %d summaries, %v", len(summaries), err)
+	}
+	if err := s.MarkDigestSent(ctx, user.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, err := s.ListDigestSummaries(ctx, now, now); err != nil || len(summaries) != 0 {
+		t.Fatalf("digest repeated before interval: %d summaries, %v", len(summaries), err)
+	}
+
+	backup := make(map[string][]byte)
+	if err := s.ExportTables(ctx, func(table string, rows []byte) error {
+		backup[table] = rows
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(ctx, "Bob", "secret", "+79990000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportTables(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByPhone(ctx, "+79990000000"); err == nil {
+		t.Error("restore kept a user created after the backup")
+	}
+	if got, err := s.GetUser(ctx, user.ID); err != nil || got.Name != "Alice" {
+		t.Fatalf("restored user: %+v, %v", got, err)
+	}
+	entries, err = s.ListAudit(ctx, 0, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("restored audit: %d entries, %v", len(entries), err)
+	}
+	if err := VerifyAuditChain(entries); err != nil {
+		t.Errorf("restored audit chain: %v", err)
+	}
+	if _, err := s.AppendAudit(ctx, "admin", "grant", user.ID, nil); err != nil {
+		t.Errorf("append after restore: %v", err)
+	}
+}
+
+// TestSQLiteAtRestEncryption проверяет шифрование текстов и контактов в базе и ротацию ключей
+func TestSQLiteAtRestEncryption(t *testing.T) {
+	ctx := t.Context()
+	s, err := New("sqlite:" + filepath.Join(t.TempDir(), "hydra.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Пользователь, созданный до включения шифрования
+	legacy, err := s.CreateUser(ctx, "Bob", "secret", "+79990000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := seal.ParseKeys("1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetKeyring(first)
+	user, err := s.CreateUser(ctx, "Alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{ConversationID: user.ID, SenderID: user.ID, Body: "привет"}
+	if err := s.CreateMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != "привет" {
+		t.Errorf("CreateMessage changed the body: %q", msg.Body)
+	}
+
+	var rawEmail, rawBody string
+	s.db.QueryRow("SELECT email FROM users WHERE id = $1", user.ID).Scan(&rawEmail)
+	s.db.QueryRow("SELECT body FROM messages WHERE id = $1", msg.ID).Scan(&rawBody)
+	if !strings.HasPrefix(rawEmail, "$enc$1$") || !strings.HasPrefix(rawBody, "$enc$1$") {
+		t.Fatalf("values stored in clear: %q, %q", rawEmail, rawBody)
+	}
+
+	if got, err := s.ValidateUser(ctx, "alice@example.com", "secret"); err != nil || got.Email != "alice@example.com" {
+		t.Fatalf("ValidateUser: %+v, %v", got, err)
+	}
+	if got, err := s.GetUserByPhone(ctx, "+79990000000"); err != nil || got.ID != legacy.ID {
+		t.Fatalf("GetUserByPhone for legacy user: %+v, %v", got, err)
+	}
+	if list, err := s.ListMessages(ctx, user.ID, 10); err != nil || len(list) != 1 || list[0].Body != "привет" {
+		t.Fatalf("ListMessages: %+v, %v", list, err)
+	}
+
+	// Ротация: новый ключ первым, старые значения перешифровываются
+	second, err := seal.ParseKeys("2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=,1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetKeyring(second)
+	if got, err := s.GetUserByEmail(ctx, "alice@example.com"); err != nil || got.ID != user.ID {
+		t.Fatalf("GetUserByEmail before rotation: %+v, %v", got, err)
+	}
+	n, err := s.RotateKeys(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 { // email Alice, телефон Bob, сообщение
+		t.Errorf("RotateKeys: %d values, want 3", n)
+	}
+	if n, err := s.RotateKeys(ctx, 1); err != nil || n != 0 {
+		t.Errorf("repeated RotateKeys: %d, %v", n, err)
+	}
+
+	only, _ := seal.ParseKeys("2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=", nil)
+	s.SetKeyring(only)
+	if got, err := s.GetUserByPhone(ctx, "+79990000000"); err != nil || got.Phone != "+79990000000" {
+		t.Fatalf("GetUserByPhone after rotation: %+v, %v", got, err)
+	}
+	if list, err := s.ListMessages(ctx, user.ID, 10); err != nil || len(list) != 1 || list[0].Body != "привет" {
+		t.Fatalf("ListMessages after rotation: %+v, %v", list, err)
+	}
+}
+
+// TestSQLiteInviteRace проверяет, что одновременные регистрации расходуют
+// одноразовое приглашение ровно один раз
+func TestSQLiteInviteRace(t *testing.T) {
+	s, err := New("sqlite:" + filepath.Join(t.TempDir(), "hydra.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	token, err := s.CreateInvite(t.Context(), "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := s.ValidateInvite(t.Context(), token)
+			errs <- err
+		}()
+	}
+	var used, rejected int
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; {
+		case err == nil:
+			used++
+		case errors.Is(err, ErrInviteUsed):
+			rejected++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if used != 1 || rejected != cap(errs)-1 {
+		t.Errorf("invite used %d times, %d rejected", used, rejected)
+	}
+}
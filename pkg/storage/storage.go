@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"hydra/pkg/ids"
+	"hydra/pkg/password"
+	"hydra/pkg/seal"
+	"hydra/pkg/timesync"
 	"log"
 	"strings"
 	"time"
@@ -11,7 +16,14 @@ import (
 )
 
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+
+	// clockSkew - допуск расхождения часов при проверке сроков действия
+	clockSkew time.Duration
+
+	// keys шифруют тексты сообщений и контакты; nil - шифрование выключено
+	keys *seal.Keyring
 }
 
 type User struct {
@@ -22,19 +34,14 @@ type User struct {
 	Password string `json:"-"`
 }
 
+// New подключается к базе и переводит схему на последнюю версию
 func New(connStr string) (*Storage, error) {
 	// Example connStr: "user=postgres password=postgres dbname=hydra sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
+	storage, err := Open(connStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
-
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	storage := &Storage{db: db}
-	if err := storage.initDB(); err != nil {
+	if err := storage.Migrate(context.Background(), -1); err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
@@ -42,92 +49,59 @@ func New(connStr string) (*Storage, error) {
 	return storage, nil
 }
 
-func (s *Storage) initDB() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		email TEXT UNIQUE,
-		phone TEXT UNIQUE,
-		password TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS contacts (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		avatar TEXT,
-		status TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS invites (
-		token TEXT PRIMARY KEY,
-		contact_info TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS sms_verifications (
-		id SERIAL PRIMARY KEY,
-		phone TEXT NOT NULL,
-		code TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		verified BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS email_verifications (
-		id SERIAL PRIMARY KEY,
-		email TEXT NOT NULL,
-		code TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		verified BOOLEAN DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	_, err := s.db.Exec(query)
-	return err
-}
-
-func (s *Storage) CreateInvite(contactInfo string) (string, error) {
-	token := fmt.Sprintf("invite-%d", time.Now().UnixNano())
-	expiresAt := time.Now().Add(24 * time.Hour)
-
-	query := "INSERT INTO invites (token, contact_info, expires_at) VALUES ($1, $2, $3)"
-	_, err := s.db.Exec(query, token, contactInfo, expiresAt)
+// Open подключается к базе без миграций схемы (для команды "hydra migrate").
+// Драйвер выбирается по connStr (см. openDB).
+func Open(connStr string) (*Storage, error) {
+	db, dialect, err := openDB(connStr)
 	if err != nil {
-		return "", fmt.Errorf("failed to create invite: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-	return token, nil
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &Storage{db: db, dialect: dialect}, nil
 }
 
-func (s *Storage) ValidateInvite(token string) (string, error) {
-	var contactInfo string
-	var expiresAt time.Time
+// PoolConfig - настройки пула соединений с базой. Нулевые поля оставляют значения
+// database/sql по умолчанию.
+type PoolConfig struct {
+	MaxOpenConns    int           // Наибольшее число открытых соединений
+	MaxIdleConns    int           // Сколько простаивающих соединений держать открытыми
+	ConnMaxLifetime time.Duration // Через сколько соединение пересоздается
+	ConnMaxIdleTime time.Duration // Через сколько простоя соединение закрывается
+}
 
-	query := "SELECT contact_info, expires_at FROM invites WHERE token = $1"
-	err := s.db.QueryRow(query, token).Scan(&contactInfo, &expiresAt)
-	if err != nil {
-		return "", fmt.Errorf("invalid token: %w", err)
+// SetPool настраивает пул соединений
+func (s *Storage) SetPool(p PoolConfig) {
+	if p.MaxOpenConns > 0 {
+		s.db.SetMaxOpenConns(p.MaxOpenConns)
 	}
-
-	if time.Now().After(expiresAt) {
-		return "", fmt.Errorf("token expired")
+	if p.MaxIdleConns > 0 {
+		s.db.SetMaxIdleConns(p.MaxIdleConns)
 	}
-
-	// Удаляем токен после использования
-	deleteQuery := "DELETE FROM invites WHERE token = $1"
-	_, err = s.db.Exec(deleteQuery, token)
-	if err != nil {
-		log.Printf("Failed to delete invite token: %v", err)
+	if p.ConnMaxLifetime > 0 {
+		s.db.SetConnMaxLifetime(p.ConnMaxLifetime)
 	}
+	if p.ConnMaxIdleTime > 0 {
+		s.db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	}
+}
 
-	return contactInfo, nil
+// SetClockSkewTolerance задает допуск, добавляемый к срокам действия кодов и приглашений
+func (s *Storage) SetClockSkewTolerance(d time.Duration) {
+	s.clockSkew = d
 }
 
-func (s *Storage) CreateUser(name, password, contactInfo string) (*User, error) {
+// CreateUser создает пользователя; пароль сохраняется хешем (pkg/password)
+func (s *Storage) CreateUser(ctx context.Context, name, plain, contactInfo string) (*User, error) {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
 	user := &User{
-		ID:       fmt.Sprintf("user-%d", time.Now().UnixNano()),
+		ID:       "user-" + ids.New(),
 		Name:     name,
-		Password: password, // В реальном приложении пароль нужно хешировать
+		Password: hash,
 	}
 
 	if strings.Contains(contactInfo, "@") {
@@ -137,7 +111,7 @@ func (s *Storage) CreateUser(name, password, contactInfo string) (*User, error)
 	}
 
 	query := "INSERT INTO users (id, name, email, phone, password) VALUES ($1, $2, $3, $4, $5)"
-	_, err := s.db.Exec(query, user.ID, user.Name, user.Email, user.Phone, user.Password)
+	_, err = s.db.ExecContext(ctx, query, user.ID, user.Name, s.keys.SealDeterministic(user.Email), s.keys.SealDeterministic(user.Phone), user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
@@ -146,18 +120,18 @@ func (s *Storage) CreateUser(name, password, contactInfo string) (*User, error)
 }
 
 // SMS Verification Methods
-func (s *Storage) CreateSMSVerification(phone, code string) error {
+func (s *Storage) CreateSMSVerification(ctx context.Context, phone, code string) error {
 	expiresAt := time.Now().Add(10 * time.Minute) // Код действителен 10 минут
 
 	// Удаляем старые коды для этого номера
-	_, err := s.db.Exec("DELETE FROM sms_verifications WHERE phone = $1", phone)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sms_verifications WHERE phone = $1", phone)
 	if err != nil {
 		return fmt.Errorf("failed to clean old codes: %w", err)
 	}
 
 	// Вставляем новый код
 	query := "INSERT INTO sms_verifications (phone, code, expires_at) VALUES ($1, $2, $3)"
-	_, err = s.db.Exec(query, phone, code, expiresAt)
+	_, err = s.db.ExecContext(ctx, query, phone, code, expiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to create SMS verification: %w", err)
 	}
@@ -165,18 +139,18 @@ func (s *Storage) CreateSMSVerification(phone, code string) error {
 	return nil
 }
 
-func (s *Storage) ValidateSMSVerification(phone, code string) (bool, error) {
+func (s *Storage) ValidateSMSVerification(ctx context.Context, phone, code string) (bool, error) {
 	var storedCode string
 	var expiresAt time.Time
 
 	query := "SELECT code, expires_at FROM sms_verifications WHERE phone = $1 AND verified = FALSE ORDER BY created_at DESC LIMIT 1"
-	err := s.db.QueryRow(query, phone).Scan(&storedCode, &expiresAt)
+	err := s.db.QueryRowContext(ctx, query, phone).Scan(&storedCode, &expiresAt)
 	if err != nil {
 		return false, fmt.Errorf("invalid or expired code: %w", err)
 	}
 
 	// Проверяем срок действия
-	if time.Now().After(expiresAt) {
+	if timesync.Expired(expiresAt, time.Now(), s.clockSkew) {
 		return false, fmt.Errorf("code expired")
 	}
 
@@ -186,7 +160,7 @@ func (s *Storage) ValidateSMSVerification(phone, code string) (bool, error) {
 	}
 
 	// Помечаем код как использованный
-	_, err = s.db.Exec("UPDATE sms_verifications SET verified = TRUE WHERE phone = $1 AND code = $2", phone, code)
+	_, err = s.db.ExecContext(ctx, "UPDATE sms_verifications SET verified = TRUE WHERE phone = $1 AND code = $2", phone, code)
 	if err != nil {
 		return false, fmt.Errorf("failed to mark code as verified: %w", err)
 	}
@@ -194,16 +168,16 @@ func (s *Storage) ValidateSMSVerification(phone, code string) (bool, error) {
 	return true, nil
 }
 
-func (s *Storage) CreateEmailVerification(email, code string) error {
+func (s *Storage) CreateEmailVerification(ctx context.Context, email, code string) error {
 	expiresAt := time.Now().Add(10 * time.Minute)
 
-	_, err := s.db.Exec("DELETE FROM email_verifications WHERE email = $1", email)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM email_verifications WHERE email = $1", email)
 	if err != nil {
 		return fmt.Errorf("failed to clean old codes: %w", err)
 	}
 
 	query := "INSERT INTO email_verifications (email, code, expires_at) VALUES ($1, $2, $3)"
-	_, err = s.db.Exec(query, email, code, expiresAt)
+	_, err = s.db.ExecContext(ctx, query, email, code, expiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to create email verification: %w", err)
 	}
@@ -211,17 +185,17 @@ func (s *Storage) CreateEmailVerification(email, code string) error {
 	return nil
 }
 
-func (s *Storage) ValidateEmailVerification(email, code string) (bool, error) {
+func (s *Storage) ValidateEmailVerification(ctx context.Context, email, code string) (bool, error) {
 	var storedCode string
 	var expiresAt time.Time
 
 	query := "SELECT code, expires_at FROM email_verifications WHERE email = $1 AND verified = FALSE ORDER BY created_at DESC LIMIT 1"
-	err := s.db.QueryRow(query, email).Scan(&storedCode, &expiresAt)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&storedCode, &expiresAt)
 	if err != nil {
 		return false, fmt.Errorf("invalid or expired code: %w", err)
 	}
 
-	if time.Now().After(expiresAt) {
+	if timesync.Expired(expiresAt, time.Now(), s.clockSkew) {
 		return false, fmt.Errorf("code expired")
 	}
 
@@ -229,7 +203,7 @@ func (s *Storage) ValidateEmailVerification(email, code string) (bool, error) {
 		return false, fmt.Errorf("invalid code")
 	}
 
-	_, err = s.db.Exec("UPDATE email_verifications SET verified = TRUE WHERE email = $1 AND code = $2", email, code)
+	_, err = s.db.ExecContext(ctx, "UPDATE email_verifications SET verified = TRUE WHERE email = $1 AND code = $2", email, code)
 	if err != nil {
 		return false, fmt.Errorf("failed to mark code as verified: %w", err)
 	}
@@ -237,69 +211,101 @@ func (s *Storage) ValidateEmailVerification(email, code string) (bool, error) {
 	return true, nil
 }
 
-func (s *Storage) GetUserByPhone(phone string) (*User, error) {
+func (s *Storage) GetUserByPhone(ctx context.Context, phone string) (*User, error) {
 	user := &User{}
-	query := "SELECT id, name, email, phone, password FROM users WHERE phone = $1"
-	err := s.db.QueryRow(query, phone).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Password)
+	cond, arg := s.contactMatch("phone", 1, phone)
+	query := "SELECT id, name, email, phone, password FROM users WHERE " + cond
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
+	if err := s.openUser(user); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-func (s *Storage) GetUserByEmail(email string) (*User, error) {
+func (s *Storage) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	user := &User{}
-	query := "SELECT id, name, email, phone, password FROM users WHERE email = $1"
-	err := s.db.QueryRow(query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Password)
+	cond, arg := s.contactMatch("email", 1, email)
+	query := "SELECT id, name, email, phone, password FROM users WHERE " + cond
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
+	if err := s.openUser(user); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-func (s *Storage) GetUser(id string) (*User, error) {
+func (s *Storage) GetUser(ctx context.Context, id string) (*User, error) {
 	user := &User{}
 	query := "SELECT id, name, email, phone FROM users WHERE id = $1"
-	err := s.db.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Phone)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Phone)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if err := s.openUser(user); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-func (s *Storage) UpdateUser(user *User) error {
+func (s *Storage) UpdateUser(ctx context.Context, user *User) error {
 	query := "UPDATE users SET name = $1, email = $2, phone = $3 WHERE id = $4"
-	_, err := s.db.Exec(query, user.Name, user.Email, user.Phone, user.ID)
+	_, err := s.db.ExecContext(ctx, query, user.Name, s.keys.SealDeterministic(user.Email), s.keys.SealDeterministic(user.Phone), user.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 	return nil
 }
 
-func (s *Storage) DeleteUser(id string) error {
+func (s *Storage) DeleteUser(ctx context.Context, id string) error {
 	query := "DELETE FROM users WHERE id = $1"
-	_, err := s.db.Exec(query, id)
+	_, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 	return nil
 }
 
-func (s *Storage) ValidateUser(contactInfo, password string) (*User, error) {
+// ValidateUser проверяет пароль. Пароль, сохраненный в открытом виде или хешем с
+// устаревшими параметрами, после успешной проверки перехешируется.
+func (s *Storage) ValidateUser(ctx context.Context, contactInfo, plain string) (*User, error) {
 	user := &User{}
 	var storedPassword string
 
 	// Пытаемся найти пользователя по email или телефону
-	query := "SELECT id, name, email, phone, password FROM users WHERE email = $1 OR phone = $2"
-	err := s.db.QueryRow(query, contactInfo, contactInfo).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &storedPassword)
+	emailCond, arg := s.contactMatch("email", 1, contactInfo)
+	phoneCond, _ := s.contactMatch("phone", 1, contactInfo)
+	query := "SELECT id, name, email, phone, password FROM users WHERE " + emailCond + " OR " + phoneCond
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &storedPassword)
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials: %w", err)
 	}
+	if err := s.openUser(user); err != nil {
+		return nil, err
+	}
 
-	// В реальном приложении здесь должна быть проверка хеша
-	if storedPassword != password {
+	if !password.Verify(storedPassword, plain) {
 		return nil, fmt.Errorf("invalid credentials")
 	}
+	if password.NeedsRehash(storedPassword) {
+		s.rehashPassword(ctx, user.ID, storedPassword, plain)
+	}
 
 	return user, nil
 }
+
+// rehashPassword заменяет сохраненный пароль новым хешем. Ошибка только логируется:
+// вход уже проверен, а пересчитать хеш можно при следующем входе.
+func (s *Storage) rehashPassword(ctx context.Context, userID, stored, plain string) {
+	hash, err := password.Hash(plain)
+	if err == nil {
+		_, err = s.db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2 AND password = $3", hash, userID, stored)
+	}
+	if err != nil {
+		log.Printf("Failed to rehash password of %s: %v", userID, err)
+	}
+}
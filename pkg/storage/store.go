@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"context"
+	"hydra/pkg/compliance"
+	"hydra/pkg/folders"
+	"hydra/pkg/recovery"
+	"hydra/pkg/transport"
+	"time"
+)
+
+// Store - данные сервера. Реализации: Storage (PostgreSQL или SQLite) и Memory (в памяти,
+// для тестов). Миграции схемы и табличное резервное копирование (Migrate, ExportTables,
+// ImportTables) относятся только к SQL базе и в интерфейс не входят.
+// Методы принимают контекст запроса: его отмена прерывает обращение к базе.
+type Store interface {
+	SetClockSkewTolerance(d time.Duration)
+
+	// Пользователи, приглашения и коды подтверждения
+	CreateUser(ctx context.Context, name, password, contactInfo string) (*User, error)
+	GetUser(ctx context.Context, id string) (*User, error)
+	GetUserByPhone(ctx context.Context, phone string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, user *User) error
+	DeleteUser(ctx context.Context, id string) error
+	ValidateUser(ctx context.Context, contactInfo, password string) (*User, error)
+	CreateSMSVerification(ctx context.Context, phone, code string) error
+	ValidateSMSVerification(ctx context.Context, phone, code string) (bool, error)
+	CreateEmailVerification(ctx context.Context, email, code string) error
+	ValidateEmailVerification(ctx context.Context, email, code string) (bool, error)
+
+	// Сессии входа
+	CreateSession(ctx context.Context, userID, deviceID string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error)
+	ValidateSession(ctx context.Context, token string, client SessionClient) (*Session, error)
+	RefreshSession(ctx context.Context, refreshToken string, client SessionClient, ttl, refreshTTL time.Duration) (*Session, error)
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	RevokeSession(ctx context.Context, userID, id string) (bool, error)
+	RevokeOtherSessions(ctx context.Context, userID, keepID string) (int64, error)
+	RevokeTokens(ctx context.Context, userID string, at time.Time) error
+	TokensRevokedAt(ctx context.Context, userID string) (time.Time, error)
+	RevokeUserSessions(ctx context.Context, userID string) error
+	RevokeDeviceSessions(ctx context.Context, userID, deviceID string) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+
+	// Вход через внешних поставщиков (OpenID Connect, GitHub)
+	LinkExternalIdentity(ctx context.Context, identity *ExternalIdentity) (bool, error)
+	GetExternalIdentity(ctx context.Context, provider, subject string) (*ExternalIdentity, error)
+	ListExternalIdentities(ctx context.Context, userID string) ([]*ExternalIdentity, error)
+	UnlinkExternalIdentity(ctx context.Context, userID, provider string) (bool, error)
+
+	// Привязка устройств по QR-коду
+	CreateDeviceLink(ctx context.Context, userID, keyMaterial string, ttl time.Duration) (*DeviceLink, error)
+	ConsumeDeviceLink(ctx context.Context, token string) (*DeviceLink, error)
+	DeleteExpiredDeviceLinks(ctx context.Context, now time.Time) (int64, error)
+
+	// Приглашения
+	CreateInvite(ctx context.Context, contactInfo string) (string, error)
+	IssueInvite(ctx context.Context, inv *Invite) error
+	ValidateInvite(ctx context.Context, token string) (string, error)
+	RecordInviteUse(ctx context.Context, token, userID string) error
+	GetInvite(ctx context.Context, token string) (*Invite, error)
+	ListInvites(ctx context.Context, createdBy string) ([]*Invite, error)
+	RevokeInvite(ctx context.Context, token string) (bool, error)
+	ListInviteUses(ctx context.Context, token string) ([]*InviteUse, error)
+
+	// Доверие
+	GetUserTrust(ctx context.Context, userID string) (*UserTrust, error)
+	SetUserTrust(ctx context.Context, userID string, level int, invitedBy string) error
+
+	// Деактивация аккаунта
+	DeactivateUser(ctx context.Context, userID, inboundMode string) (*AccountState, error)
+	ReactivateUser(ctx context.Context, userID string) ([]*QueuedMessage, error)
+	GetAccountState(ctx context.Context, userID string) (*AccountState, error)
+	QueueMessage(ctx context.Context, msg *QueuedMessage) error
+	CountQueuedMessages(ctx context.Context, userID string) (int, error)
+
+	// Смена идентификаторов
+	SaveIdentifierChange(ctx context.Context, change *IdentifierChange) error
+	GetIdentifierChange(ctx context.Context, userID string) (*IdentifierChange, error)
+	DeleteIdentifierChange(ctx context.Context, userID string) (bool, error)
+	ApplyIdentifierChange(ctx context.Context, change *IdentifierChange) ([]string, error)
+
+	// Поиск по имени
+	GetLookupProfile(ctx context.Context, userID string) (*LookupProfile, error)
+	SaveLookupProfile(ctx context.Context, profile *LookupProfile) error
+	ChangeUsername(ctx context.Context, userID, username string, hold time.Duration) (bool, error)
+	UsernameOwner(ctx context.Context, username string, hold time.Duration) (string, error)
+	ResolveUsernames(ctx context.Context, usernames []string) (map[string]string, error)
+	FindUserByUsername(ctx context.Context, username string) (*User, error)
+	SearchDirectory(ctx context.Context, handle, name string, limit int) ([]*DirectoryEntry, error)
+	FindDirectoryContact(ctx context.Context, email, phone string) (*DirectoryEntry, error)
+	ListDiscoverableContacts(ctx context.Context) ([]*DiscoverableContact, error)
+
+	// Восстановление аккаунта поручителями
+	GetRecoveryGuardians(ctx context.Context, userID string) (*RecoveryGuardians, error)
+	SetRecoveryGuardians(ctx context.Context, g *RecoveryGuardians) error
+	DeleteRecoveryGuardians(ctx context.Context, userID string) (bool, error)
+	CreateRecoveryRequest(ctx context.Context, req *RecoveryRequest) error
+	GetRecoveryRequest(ctx context.Context, id string) (*RecoveryRequest, error)
+	CancelRecoveryRequests(ctx context.Context, userID, id string) (int64, error)
+	AddRecoveryApproval(ctx context.Context, a *recovery.Approval) (bool, error)
+	ListRecoveryApprovals(ctx context.Context, req *RecoveryRequest) ([]*recovery.Approval, error)
+	CompleteRecovery(ctx context.Context, req *RecoveryRequest, password string) error
+
+	// Устройства
+	UpsertDevice(ctx context.Context, device *Device) error
+	GetDevice(ctx context.Context, id string) (*Device, error)
+	ListDevices(ctx context.Context, userID string) ([]*Device, error)
+	DeleteDevice(ctx context.Context, userID, id string) error
+	TouchDevice(ctx context.Context, userID, id string, seq int64) (bool, error)
+
+	// Ключи сквозного шифрования
+	PublishKeys(ctx context.Context, keys *KeyBundle, oneTime []*PreKey) error
+	CountPreKeys(ctx context.Context, userID, deviceID string) (int, error)
+	TakeKeyBundles(ctx context.Context, userID, deviceID string) ([]*KeyBundle, error)
+	DeleteKeys(ctx context.Context, userID, deviceID string) error
+
+	// Push уведомления
+	SavePushSubscription(ctx context.Context, sub *PushSubscription) error
+	ListPushSubscriptions(ctx context.Context, userID string) ([]*PushSubscription, error)
+	DeletePushSubscription(ctx context.Context, userID, deviceID string) (bool, error)
+
+	// Аватары
+	SaveAvatar(ctx context.Context, a *Avatar) error
+	GetAvatar(ctx context.Context, userID string) (*Avatar, error)
+	DeleteAvatar(ctx context.Context, userID string) (bool, error)
+
+	// Язык пользователя
+	GetUserLocale(ctx context.Context, userID string) (string, error)
+	SetUserLocale(ctx context.Context, userID, locale string) error
+
+	// Группы
+	CreateGroup(ctx context.Context, g *Group, members []string) error
+	GetGroup(ctx context.Context, id string) (*Group, error)
+	ListUserGroups(ctx context.Context, userID string) ([]*Group, error)
+	AddGroupMember(ctx context.Context, groupID, userID, role string) (bool, error)
+	RemoveGroupMember(ctx context.Context, groupID, userID string) (bool, error)
+	GetGroupMember(ctx context.Context, groupID, userID string) (*GroupMember, error)
+	ListGroupMembers(ctx context.Context, groupID string) ([]*GroupMember, error)
+
+	// Сообщения, журнал событий и папки
+	CreateMessage(ctx context.Context, msg *Message) error
+	ListMessages(ctx context.Context, conversationID string, limit int) ([]*Message, error)
+	ListMessagesBefore(ctx context.Context, conversationID string, before time.Time, limit int) ([]*Message, error)
+	IsConversationParticipant(ctx context.Context, conversationID, userID string) (bool, error)
+	GetMessage(ctx context.Context, id string) (*Message, error)
+	EditMessage(ctx context.Context, id, body string, keepHistory bool) (bool, error)
+	DeleteMessage(ctx context.Context, id string) (bool, error)
+	ListMessageEdits(ctx context.Context, messageID string) ([]*MessageEdit, error)
+	DeleteMessageEditsBefore(ctx context.Context, before time.Time) (int64, error)
+	AppendEvent(ctx context.Context, userID, eventType string, payload interface{}) (*Event, error)
+	ListEvents(ctx context.Context, userID string, afterSeq int64, limit int) ([]*Event, error)
+	LastEventSeq(ctx context.Context, userID string) (int64, error)
+	ListFolders(ctx context.Context, userID string) ([]*folders.Folder, error)
+	SaveFolder(ctx context.Context, f *folders.Folder) (bool, error)
+	DeleteFolder(ctx context.Context, userID, folderID string) (bool, error)
+	ListFolderAssignments(ctx context.Context, userID string) ([]*FolderAssignment, error)
+	AssignFolder(ctx context.Context, userID, conversationID, folderID string) (bool, error)
+
+	// Квитанции доставки
+	CreateReceipts(ctx context.Context, messageID, senderID string, recipients []string) error
+	UpdateReceipt(ctx context.Context, messageID, userID, status string, at time.Time) (*MessageReceipt, bool, error)
+	ListReceipts(ctx context.Context, messageID string) ([]*MessageReceipt, error)
+
+	// Холодное хранение истории
+	ListInactiveConversations(ctx context.Context, before time.Time, limit int) ([]string, error)
+	CreateMessageArchive(ctx context.Context, archive *MessageArchive, messageIDs []string) error
+	ListMessageArchives(ctx context.Context, conversationID string) ([]*MessageArchive, error)
+
+	// Сроки хранения истории
+	GetRetention(ctx context.Context, conversationID string) (*RetentionPolicy, error)
+	ListRetention(ctx context.Context) ([]*RetentionPolicy, error)
+	SetRetention(ctx context.Context, p *RetentionPolicy) error
+	DeleteMessagesBefore(ctx context.Context, conversationID string, before time.Time) (int64, error)
+	ListAttachmentsBefore(ctx context.Context, conversationID string, before time.Time) ([]*Attachment, error)
+	DeleteMessageArchive(ctx context.Context, id string) error
+	CreateVoiceFile(ctx context.Context, v *VoiceFile) error
+	ListVoiceFilesBefore(ctx context.Context, conversationID string, before time.Time) ([]*VoiceFile, error)
+	DeleteVoiceFile(ctx context.Context, id string) error
+
+	// Вложения
+	CreateAttachment(ctx context.Context, a *Attachment) error
+	GetAttachment(ctx context.Context, id string) (*Attachment, error)
+	CompleteAttachment(ctx context.Context, id, sha256 string) error
+	DeleteAttachment(ctx context.Context, id string) error
+	ListStaleUploads(ctx context.Context, before time.Time) ([]*Attachment, error)
+
+	// Входящие, принятые транспортами
+	DeliverInbox(ctx context.Context, msg *InboxMessage) (bool, error)
+	ListInbox(ctx context.Context, userID string, limit int) ([]*InboxMessage, error)
+	AckInbox(ctx context.Context, userID string, messageIDs []string) (int, error)
+	DeleteAckedInbox(ctx context.Context, before time.Time) (int64, error)
+
+	// Исходящие режима обслуживания
+	EnqueueOutbox(ctx context.Context, msg *OutboxMessage) error
+	ListOutbox(ctx context.Context, limit int) ([]*OutboxMessage, error)
+	DeleteOutbox(ctx context.Context, id int64) error
+	CountOutbox(ctx context.Context) (int, error)
+
+	// Квоты групп и записи звонков
+	GetQuota(ctx context.Context, groupID string) (*Quota, error)
+	ListQuotas(ctx context.Context) ([]*Quota, error)
+	SetQuota(ctx context.Context, q *Quota) error
+	DeleteQuota(ctx context.Context, groupID string) (bool, error)
+	GetQuotaUsage(ctx context.Context, groupID string) (*QuotaUsage, error)
+	DeleteOldestMessages(ctx context.Context, conversationID string, keep int64) (int64, error)
+	CreateRecording(ctx context.Context, rec *Recording) error
+	GetRecording(ctx context.Context, id string) (*Recording, error)
+	ListRecordings(ctx context.Context) ([]*Recording, error)
+	ListRoomRecordings(ctx context.Context, roomID string) ([]*Recording, error)
+	ListExpiredRecordings(ctx context.Context, now time.Time) ([]*Recording, error)
+	DeleteRecording(ctx context.Context, id string) error
+
+	// Дайджесты и почта
+	RecordNotification(ctx context.Context, userID, kind, conversationID string) error
+	TouchUser(ctx context.Context, userID string) error
+	GetDigestSettings(ctx context.Context, userID string) (*DigestSettings, error)
+	UpdateDigestSettings(ctx context.Context, settings *DigestSettings) error
+	MuteDigests(ctx context.Context, token string) (string, error)
+	ListDigestSummaries(ctx context.Context, offlineSince, now time.Time) ([]*DigestSummary, error)
+	MarkDigestSent(ctx context.Context, userID string, at time.Time) error
+	DeleteNotificationsBefore(ctx context.Context, before time.Time) error
+	EnqueueMail(ctx context.Context, recipient, subject, body string) error
+	ListPendingMail(ctx context.Context, now time.Time, limit int) ([]*QueuedMail, error)
+	MarkMailSent(ctx context.Context, id int64) error
+	MarkMailFailed(ctx context.Context, id int64, sendErr error, retryAt time.Time) error
+	DeleteSentMailBefore(ctx context.Context, before time.Time) error
+
+	// Доставка SMS
+	RecordSMS(ctx context.Context, msg *SMSMessage) error
+	UpdateSMSStatus(ctx context.Context, provider, providerID, status, sendErr string) (bool, error)
+	GetSMS(ctx context.Context, provider, providerID string) (*SMSMessage, error)
+	DeleteSMSBefore(ctx context.Context, before time.Time) error
+
+	// ICE серверы, транспорты и mesh
+	CreateICEServer(ctx context.Context, server *ICEServer) error
+	UpdateICEServer(ctx context.Context, server *ICEServer) error
+	DeleteICEServer(ctx context.Context, id string) error
+	GetICEServer(ctx context.Context, id string) (*ICEServer, error)
+	ListICEServers(ctx context.Context) ([]*ICEServer, error)
+	UpdateICEServerHealth(ctx context.Context, id string, healthy bool, latency time.Duration, lastError string) error
+	ListTransportBlackouts(ctx context.Context) ([]transport.Blackout, error)
+	CreateTransportBlackout(ctx context.Context, b *transport.Blackout) error
+	DeleteTransportBlackout(ctx context.Context, id string) (bool, error)
+	CreateTransportEvent(ctx context.Context, event *TransportEvent) error
+	ListTransportEvents(ctx context.Context, domain string, limit int) ([]*TransportEvent, error)
+	GetDomainBlockStats(ctx context.Context, since time.Time) ([]*DomainBlockStats, error)
+	DeleteTransportEventsBefore(ctx context.Context, before time.Time) error
+	ListStaticPeers(ctx context.Context) ([]string, error)
+	AddStaticPeer(ctx context.Context, addr string) error
+	RemoveStaticPeer(ctx context.Context, addr string) error
+
+	// Отчеты клиентов об ошибках
+	CreateClientError(ctx context.Context, report *ClientError) error
+	ListClientErrors(ctx context.Context, kind string, limit int) ([]*ClientError, error)
+	GetClientErrorStats(ctx context.Context, since time.Time, limit int) ([]*ClientErrorStats, error)
+	DeleteClientErrorsBefore(ctx context.Context, before time.Time) error
+
+	// Роли, аудит и выгрузка метаданных
+	GrantRole(ctx context.Context, userID, role, grantedBy string) (bool, error)
+	RevokeRole(ctx context.Context, userID, role string) (bool, error)
+	HasRole(ctx context.Context, userID, role string) (bool, error)
+	ListRoles(ctx context.Context) ([]*RoleGrant, error)
+	AppendAudit(ctx context.Context, actor, action, target string, details interface{}) (*AuditEntry, error)
+	ListAudit(ctx context.Context, afterID int64, limit int) ([]*AuditEntry, error)
+	ComplianceSnapshot(ctx context.Context, userIDs []string) (time.Time, []compliance.Section, error)
+
+	// Администрирование
+	DisableUser(ctx context.Context, userID, disabledBy, reason string) (*DisabledAccount, error)
+	EnableUser(ctx context.Context, userID string) (bool, error)
+	GetDisabledAccount(ctx context.Context, userID string) (*DisabledAccount, error)
+	ListUserSummaries(ctx context.Context, after string, limit int) ([]*UserSummary, error)
+	GetStorageStats(ctx context.Context) (*StorageStats, error)
+
+	// Блокировки и жалобы
+	BlockUser(ctx context.Context, userID, blockedID string) (bool, error)
+	UnblockUser(ctx context.Context, userID, blockedID string) (bool, error)
+	ListBlocks(ctx context.Context, userID string) ([]*Block, error)
+	IsBlocked(ctx context.Context, userID, senderID string) (bool, error)
+	CreateAbuseReport(ctx context.Context, report *AbuseReport) error
+	ListAbuseReports(ctx context.Context, status string, limit int) ([]*AbuseReport, error)
+	ResolveAbuseReport(ctx context.Context, id, resolvedBy, resolution string) (bool, error)
+
+	// Удаление аккаунта
+	PurgeUser(ctx context.Context, userID string) (*ErasedAccount, error)
+}
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*Memory)(nil)
+)
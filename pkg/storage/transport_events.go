@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TransportEvent - сбой или восстановление фронт-домена (история блокировок CDN)
+type TransportEvent struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain"`
+	Class     string    `json:"class"` // blocked, timeout, server_error, network, error, recovered
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DomainBlockStats - сводка блокировок домена
+type DomainBlockStats struct {
+	Domain      string    `json:"domain"`
+	Blocks      int       `json:"blocks"`
+	LastBlocked time.Time `json:"last_blocked"`
+}
+
+func (s *Storage) CreateTransportEvent(ctx context.Context, event *TransportEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	query := "INSERT INTO transport_events (domain, class, error, created_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	err := s.db.QueryRowContext(ctx, query, event.Domain, event.Class, event.Error, event.CreatedAt).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create transport event: %w", err)
+	}
+	return nil
+}
+
+// ListTransportEvents возвращает последние события, при непустом domain - только для него
+func (s *Storage) ListTransportEvents(ctx context.Context, domain string, limit int) ([]*TransportEvent, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := "SELECT id, domain, class, error, created_at FROM transport_events WHERE ($1 = '' OR domain = $1) ORDER BY created_at DESC LIMIT $2"
+	rows, err := s.db.QueryContext(ctx, query, domain, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transport events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*TransportEvent
+	for rows.Next() {
+		event := &TransportEvent{}
+		if err := rows.Scan(&event.ID, &event.Domain, &event.Class, &event.Error, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan transport event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetDomainBlockStats считает блокировки каждого домена с момента since.
+// Блокировки до последнего восстановления домена не учитываются.
+func (s *Storage) GetDomainBlockStats(ctx context.Context, since time.Time) ([]*DomainBlockStats, error) {
+	query := `
+	SELECT e.domain, COUNT(*), MAX(e.created_at)
+	FROM transport_events e
+	WHERE e.class = 'blocked' AND e.created_at >= $1
+		AND e.created_at > COALESCE(
+			(SELECT MAX(r.created_at) FROM transport_events r WHERE r.domain = e.domain AND r.class = 'recovered'),
+			$1)
+	GROUP BY e.domain
+	ORDER BY COUNT(*) DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*DomainBlockStats
+	for rows.Next() {
+		st := &DomainBlockStats{}
+		if err := rows.Scan(&st.Domain, &st.Blocks, &st.LastBlocked); err != nil {
+			return nil, fmt.Errorf("failed to scan block stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// DeleteTransportEventsBefore удаляет старые события истории
+func (s *Storage) DeleteTransportEventsBefore(ctx context.Context, before time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM transport_events WHERE created_at < $1", before); err != nil {
+		return fmt.Errorf("failed to delete transport events: %w", err)
+	}
+	return nil
+}
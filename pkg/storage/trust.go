@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// UserTrust - сохраненный уровень доверия пользователя
+type UserTrust struct {
+	UserID    string    `json:"user_id"`
+	Level     int       `json:"level"`
+	InvitedBy string    `json:"invited_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetUserTrust возвращает уровень доверия пользователя; для пользователей без
+// записи (зарегистрированных до появления уровней) возвращается nil
+func (s *Storage) GetUserTrust(ctx context.Context, userID string) (*UserTrust, error) {
+	t := &UserTrust{UserID: userID}
+	query := "SELECT level, invited_by, created_at, updated_at FROM user_trust WHERE user_id = $1"
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&t.Level, &t.InvitedBy, &t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user trust: %w", err)
+	}
+	return t, nil
+}
+
+// SetUserTrust сохраняет уровень доверия пользователя. invitedBy сохраняется
+// только при создании записи, чтобы ручное изменение уровня не теряло автора приглашения.
+func (s *Storage) SetUserTrust(ctx context.Context, userID string, level int, invitedBy string) error {
+	query := `
+	INSERT INTO user_trust (user_id, level, invited_by) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE SET level = EXCLUDED.level, updated_at = CURRENT_TIMESTAMP`
+	if _, err := s.db.ExecContext(ctx, query, userID, level, invitedBy); err != nil {
+		return fmt.Errorf("failed to set user trust: %w", err)
+	}
+	return nil
+}
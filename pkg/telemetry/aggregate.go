@@ -0,0 +1,149 @@
+package telemetry
+
+import (
+	"hydra/pkg/reachability"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Estimate - оценка доли узлов региона, пользовавшихся транспортом и успешно отправивших через него
+type Estimate struct {
+	Transport string  `json:"transport"`
+	Reports   int     `json:"reports"`   // отчетов за окно
+	Used      float64 `json:"used"`      // оценка доли узлов, пробовавших транспорт
+	Succeeded float64 `json:"succeeded"` // оценка доли узлов, у которых транспорт хоть раз сработал
+}
+
+// Aggregator сводит искаженные отчеты в оценки по регионам. Отчеты не хранятся: для каждого
+// дня приема копятся только суммы несмещенных оценок битов. Регионы, приславшие меньше
+// MinReports отчетов за окно, не публикуются - при малом числе отчетов оценки слишком шумные.
+type Aggregator struct {
+	Window     time.Duration
+	MinReports int
+
+	mu      sync.Mutex
+	regions map[string]map[int64]*dayTotals // регион -> день приема (Unix дни) -> суммы
+}
+
+type dayTotals struct {
+	reports int
+	sums    map[string]float64
+}
+
+// NewAggregator создает агрегатор с окном window и порогом minReports
+func NewAggregator(window time.Duration, minReports int) *Aggregator {
+	if minReports < 1 {
+		minReports = 1
+	}
+	return &Aggregator{
+		Window:     window,
+		MinReports: minReports,
+		regions:    make(map[string]map[int64]*dayTotals),
+	}
+}
+
+// Add учитывает отчет, принятый в момент now
+func (a *Aggregator) Add(report *Report, now time.Time) error {
+	if err := report.Validate(); err != nil {
+		return err
+	}
+
+	// Несмещенная оценка бита рандомизированного ответа: (b - (1-p)) / (2p - 1)
+	p := truthProbability(report.Epsilon)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	days := a.regions[report.Region]
+	if days == nil {
+		days = make(map[int64]*dayTotals)
+		a.regions[report.Region] = days
+	}
+	day := now.Unix() / 86400
+	totals := days[day]
+	if totals == nil {
+		totals = &dayTotals{sums: make(map[string]float64)}
+		days[day] = totals
+	}
+	totals.reports++
+	for key, bit := range report.Bits {
+		b := 0.0
+		if bit {
+			b = 1
+		}
+		totals.sums[key] += (b - (1 - p)) / (2*p - 1)
+	}
+	return nil
+}
+
+// Estimates возвращает оценки по транспортам региона; nil - отчетов за окно недостаточно
+func (a *Aggregator) Estimates(region string, now time.Time) []Estimate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireLocked(now)
+	reports := 0
+	sums := make(map[string]float64)
+	for _, totals := range a.regions[region] {
+		reports += totals.reports
+		for key, sum := range totals.sums {
+			sums[key] += sum
+		}
+	}
+	if reports < a.MinReports {
+		return nil
+	}
+
+	estimates := make([]Estimate, 0, len(Transports))
+	for _, t := range Transports {
+		estimates = append(estimates, Estimate{
+			Transport: t,
+			Reports:   reports,
+			Used:      clamp(sums[metricKey(t, MetricUsed)] / float64(reports)),
+			Succeeded: clamp(sums[metricKey(t, MetricOK)] / float64(reports)),
+		})
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Transport < estimates[j].Transport })
+	return estimates
+}
+
+// Hints возвращает оценки региона как подсказки о доступности транспортов (reachability.KindTransport).
+// Reporters и Working - оценки числа узлов, пробовавших транспорт и успешно отправивших через него.
+func (a *Aggregator) Hints(region string, now time.Time) []reachability.Hint {
+	var hints []reachability.Hint
+	for _, e := range a.Estimates(region, now) {
+		reporters := int(math.Round(e.Used * float64(e.Reports)))
+		if reporters == 0 {
+			continue
+		}
+		working := min(int(math.Round(e.Succeeded*float64(e.Reports))), reporters)
+		hints = append(hints, reachability.Hint{
+			Kind:      reachability.KindTransport,
+			Target:    e.Transport,
+			Status:    reachability.Status(min(e.Succeeded/e.Used, 1)),
+			Reporters: reporters,
+			Working:   working,
+		})
+	}
+	return hints
+}
+
+// expireLocked удаляет суммы дней, вышедших из окна
+func (a *Aggregator) expireLocked(now time.Time) {
+	oldest := now.Add(-a.Window).Unix() / 86400
+	for region, days := range a.regions {
+		for day := range days {
+			if day < oldest {
+				delete(days, day)
+			}
+		}
+		if len(days) == 0 {
+			delete(a.regions, region)
+		}
+	}
+}
+
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
@@ -0,0 +1,143 @@
+// Package telemetry - необязательная обезличенная статистика использования транспортов
+// с локальной дифференциальной приватностью.
+//
+// Узел (только если пользователь включил телеметрию) сам копит за период грубые признаки:
+// пользовался ли он транспортом и удалось ли хоть раз через него отправить сообщение.
+// В отчет попадает по одному биту на признак, каждый из которых искажен рандомизированным
+// ответом: с вероятностью e^ε/(1+e^ε) передается истинное значение, иначе - противоположное.
+// Отдельный отчет поэтому ничего достоверно не сообщает об узле, а сервер по множеству
+// отчетов оценивает долю узлов региона, у которых транспорт работает. Отчет не содержит
+// идентификаторов, времени событий и адресов; сервер не связывает отчеты между собой.
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"sync"
+)
+
+// Transports - транспорты, о которых сообщает телеметрия (имена transport.Transport)
+var Transports = []string{"domain-fronting", "mesh"}
+
+// Признаки транспорта за период
+const (
+	MetricUsed = "used" // узел пробовал отправить через транспорт
+	MetricOK   = "ok"   // хотя бы одна отправка удалась
+)
+
+const (
+	// DefaultEpsilon - параметр приватности ε по умолчанию
+	DefaultEpsilon = 1.0
+	// MinEpsilon и MaxEpsilon ограничивают ε в отчетах: меньше - оценки бесполезны,
+	// больше - искажение слишком слабое для защиты узла
+	MinEpsilon = 0.1
+	MaxEpsilon = 4.0
+)
+
+// regionPattern - регион: код страны, ASN или имя, выбранное оператором (как в reachability)
+var regionPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Report - искаженный отчет узла за период
+type Report struct {
+	Region  string          `json:"region"`
+	Epsilon float64         `json:"epsilon"`
+	Bits    map[string]bool `json:"bits"` // "transport.metric" -> искаженный признак
+}
+
+// Validate проверяет, что отчет содержит ровно известные признаки и допустимый ε
+func (r *Report) Validate() error {
+	if !regionPattern.MatchString(r.Region) {
+		return fmt.Errorf("invalid region")
+	}
+	if r.Epsilon < MinEpsilon || r.Epsilon > MaxEpsilon {
+		return fmt.Errorf("epsilon must be between %.1f and %.1f", MinEpsilon, MaxEpsilon)
+	}
+	keys := metricKeys()
+	if len(r.Bits) != len(keys) {
+		return fmt.Errorf("report must contain exactly %d metrics", len(keys))
+	}
+	for _, key := range keys {
+		if _, exists := r.Bits[key]; !exists {
+			return fmt.Errorf("missing metric %q", key)
+		}
+	}
+	return nil
+}
+
+// metricKeys возвращает все признаки отчета
+func metricKeys() []string {
+	keys := make([]string, 0, 2*len(Transports))
+	for _, t := range Transports {
+		keys = append(keys, metricKey(t, MetricUsed), metricKey(t, MetricOK))
+	}
+	return keys
+}
+
+func metricKey(transport, metric string) string {
+	return transport + "." + metric
+}
+
+// truthProbability - вероятность передать истинное значение бита при параметре ε
+func truthProbability(epsilon float64) float64 {
+	e := math.Exp(epsilon)
+	return e / (1 + e)
+}
+
+// Collector копит признаки на узле до отправки отчета. Признаки хранятся только в памяти.
+type Collector struct {
+	mu   sync.Mutex
+	used map[string]bool
+	ok   map[string]bool
+}
+
+// NewCollector создает пустой счетчик
+func NewCollector() *Collector {
+	return &Collector{used: make(map[string]bool), ok: make(map[string]bool)}
+}
+
+// Record учитывает попытку отправки через транспорт; неизвестные транспорты игнорируются
+func (c *Collector) Record(transport string, ok bool) {
+	known := false
+	for _, t := range Transports {
+		if t == transport {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[transport] = true
+	if ok {
+		c.ok[transport] = true
+	}
+}
+
+// Report возвращает искаженный отчет за прошедший период и начинает новый период
+func (c *Collector) Report(region string, epsilon float64) *Report {
+	c.mu.Lock()
+	used, ok := c.used, c.ok
+	c.used, c.ok = make(map[string]bool), make(map[string]bool)
+	c.mu.Unlock()
+
+	p := truthProbability(epsilon)
+	report := &Report{Region: region, Epsilon: epsilon, Bits: make(map[string]bool)}
+	for _, t := range Transports {
+		report.Bits[metricKey(t, MetricUsed)] = randomize(used[t], p)
+		report.Bits[metricKey(t, MetricOK)] = randomize(ok[t], p)
+	}
+	return report
+}
+
+// randomize возвращает bit с вероятностью p и противоположное значение иначе
+func randomize(bit bool, p float64) bool {
+	if rand.Float64() < p {
+		return bit
+	}
+	return !bit
+}
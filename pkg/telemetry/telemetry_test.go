@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"hydra/pkg/reachability"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestReportIsRandomized проверяет, что отчет содержит все признаки и искажается
+func TestReportIsRandomized(t *testing.T) {
+	c := NewCollector()
+	c.Record("mesh", true)
+	c.Record("unknown", true)
+
+	flipped := 0
+	for i := 0; i < 200; i++ {
+		c.Record("mesh", true)
+		report := c.Report("RU", DefaultEpsilon)
+		if err := report.Validate(); err != nil {
+			t.Fatalf("invalid report: %v", err)
+		}
+		if !report.Bits["mesh.ok"] {
+			flipped++
+		}
+	}
+	if flipped == 0 || flipped == 200 {
+		t.Errorf("expected some but not all bits to be flipped, got %d of 200", flipped)
+	}
+
+	if err := (&Report{Region: "RU", Epsilon: 10, Bits: map[string]bool{}}).Validate(); err == nil {
+		t.Error("report with too large epsilon accepted")
+	}
+}
+
+// TestAggregatorEstimates проверяет, что по искаженным отчетам восстанавливаются доли узлов
+func TestAggregatorEstimates(t *testing.T) {
+	a := NewAggregator(24*time.Hour, 100)
+	now := time.Now()
+
+	const nodes = 20000
+	for i := 0; i < nodes; i++ {
+		c := NewCollector()
+		// Все узлы пробуют фронтинг, работает он у 20%; mesh пробуют 60% и у всех работает
+		c.Record("domain-fronting", i%5 == 0)
+		if i%5 < 3 {
+			c.Record("mesh", true)
+		}
+		if err := a.Add(c.Report("RU", DefaultEpsilon), now); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	estimates := a.Estimates("RU", now)
+	want := map[string][2]float64{"domain-fronting": {1, 0.2}, "mesh": {0.6, 0.6}}
+	for _, e := range estimates {
+		w := want[e.Transport]
+		if math.Abs(e.Used-w[0]) > 0.05 || math.Abs(e.Succeeded-w[1]) > 0.05 {
+			t.Errorf("%s: estimated used %.3f ok %.3f, want %.1f %.1f", e.Transport, e.Used, e.Succeeded, w[0], w[1])
+		}
+	}
+
+	hints := a.Hints("RU", now)
+	if len(hints) != 2 || hints[0].Target != "domain-fronting" || hints[0].Status != reachability.StatusBlocked ||
+		hints[1].Status != reachability.StatusWorking {
+		t.Errorf("unexpected hints: %+v", hints)
+	}
+
+	// Малое число отчетов и устаревшие отчеты не публикуются
+	if a.Estimates("KZ", now) != nil {
+		t.Error("estimates published for a region without reports")
+	}
+	if a.Estimates("RU", now.Add(72*time.Hour)) != nil {
+		t.Error("expired reports still counted")
+	}
+}
@@ -0,0 +1,160 @@
+package timesync
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SignedTime - подписанная отметка серверного времени.
+// Клиент сравнивает ServerTime со своими часами и вычисляет смещение.
+type SignedTime struct {
+	ServerTime int64  `json:"server_time"` // Unix время в миллисекундах
+	Nonce      string `json:"nonce,omitempty"`
+	Signature  string `json:"signature"`  // base64(ed25519(payload))
+	PublicKey  string `json:"public_key"` // base64 публичного ключа сервера
+}
+
+// Signer подписывает отметки серверного времени ключом Ed25519.
+type Signer struct {
+	privateKey ed25519.PrivateKey
+	now        func() time.Time
+}
+
+// NewSigner создает подписчика из 32-байтного seed. Если seed пустой,
+// генерируется случайный ключ (действителен до перезапуска сервера).
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) == 0 {
+		seed = make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+
+	return &Signer{
+		privateKey: ed25519.NewKeyFromSeed(seed),
+		now:        time.Now,
+	}, nil
+}
+
+// PublicKey возвращает публичный ключ для проверки подписи на клиенте
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// Sign возвращает подписанное текущее время. Nonce клиента включается в подпись,
+// чтобы ответ нельзя было переиграть.
+func (s *Signer) Sign(nonce string) *SignedTime {
+	st := &SignedTime{
+		ServerTime: s.now().UnixMilli(),
+		Nonce:      nonce,
+		PublicKey:  base64.StdEncoding.EncodeToString(s.PublicKey()),
+	}
+	st.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, st.payload()))
+	return st
+}
+
+// SignData подписывает произвольные данные ключом сервера (например, ответы поиска
+// пользователей), чтобы клиент проверял их тем же публичным ключом. Возвращает base64 подписи.
+func (s *Signer) SignData(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, data))
+}
+
+// Verify проверяет подпись отметки времени указанным публичным ключом
+func Verify(publicKey ed25519.PublicKey, st *SignedTime) error {
+	sig, err := base64.StdEncoding.DecodeString(st.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, st.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// payload - каноническое представление подписываемых данных
+func (st *SignedTime) payload() []byte {
+	data, _ := json.Marshal(struct {
+		ServerTime int64  `json:"server_time"`
+		Nonce      string `json:"nonce"`
+	}{st.ServerTime, st.Nonce})
+	return data
+}
+
+// Time возвращает серверное время как time.Time
+func (st *SignedTime) Time() time.Time {
+	return time.UnixMilli(st.ServerTime)
+}
+
+// ReplayGuard отклоняет запросы с устаревшими отметками времени и повторными nonce.
+// Допустимое окно равно Tolerance в обе стороны от серверного времени,
+// что компенсирует расхождение часов на клиентах.
+type ReplayGuard struct {
+	Tolerance time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> момент, после которого запись можно удалить
+	now  func() time.Time
+}
+
+// NewReplayGuard создает защиту от повторов с указанным допуском расхождения часов
+func NewReplayGuard(tolerance time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		Tolerance: tolerance,
+		seen:      make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// Check проверяет отметку времени клиента (с учетом известного смещения его часов) и nonce.
+// offset - поправка, которую нужно прибавить к часам клиента, чтобы получить серверное время.
+func (g *ReplayGuard) Check(nonce string, clientTime time.Time, offset time.Duration) error {
+	now := g.now()
+	corrected := clientTime.Add(offset)
+
+	if !WithinTolerance(corrected, now, g.Tolerance) {
+		return fmt.Errorf("timestamp outside allowed window (skew %s, tolerance %s)", now.Sub(corrected).Round(time.Second), g.Tolerance)
+	}
+
+	if nonce == "" {
+		return fmt.Errorf("nonce required")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Удаляем записи, которые уже не могут пройти проверку окна
+	for n, until := range g.seen {
+		if now.After(until) {
+			delete(g.seen, n)
+		}
+	}
+
+	if _, exists := g.seen[nonce]; exists {
+		return fmt.Errorf("replayed request")
+	}
+	g.seen[nonce] = corrected.Add(2 * g.Tolerance)
+
+	return nil
+}
+
+// WithinTolerance сообщает, отличаются ли моменты a и b не более чем на tolerance
+func WithinTolerance(a, b time.Time, tolerance time.Duration) bool {
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// Expired сообщает, истек ли срок expiresAt с учетом допуска расхождения часов
+func Expired(expiresAt, now time.Time, tolerance time.Duration) bool {
+	return now.After(expiresAt.Add(tolerance))
+}
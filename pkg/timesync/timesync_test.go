@@ -0,0 +1,60 @@
+package timesync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner(nil)
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	st := signer.Sign("client-nonce")
+	if err := Verify(signer.PublicKey(), st); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// Подмена времени должна ломать подпись
+	st.ServerTime += 1000
+	if err := Verify(signer.PublicKey(), st); err == nil {
+		t.Error("Expected verification error for tampered time")
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Now()
+	guard := NewReplayGuard(time.Minute)
+	guard.now = func() time.Time { return now }
+
+	// Часы клиента отстают на 10 минут, но клиент знает свое смещение
+	skewed := now.Add(-10 * time.Minute)
+	if err := guard.Check("n1", skewed, 10*time.Minute); err != nil {
+		t.Errorf("Expected corrected timestamp to pass: %v", err)
+	}
+
+	if err := guard.Check("n1", skewed, 10*time.Minute); err == nil {
+		t.Error("Expected replayed nonce to be rejected")
+	}
+
+	if err := guard.Check("n2", skewed, 0); err == nil {
+		t.Error("Expected uncorrected skewed timestamp to be rejected")
+	}
+
+	if err := guard.Check("n3", now.Add(30*time.Second), 0); err != nil {
+		t.Errorf("Expected timestamp within tolerance to pass: %v", err)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(-time.Minute)
+
+	if !Expired(expiresAt, now, 0) {
+		t.Error("Expected expired without tolerance")
+	}
+	if Expired(expiresAt, now, 5*time.Minute) {
+		t.Error("Expected not expired within tolerance")
+	}
+}
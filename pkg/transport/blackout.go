@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blackout - расписание, в которое указанные транспорты запрещены (например, никакого
+// трафика, заметного в сотовой сети, в часы комендантского часа). Окно задается временем
+// суток и может переходить через полночь: 22:00-06:00 длится до 06:00 следующего дня.
+type Blackout struct {
+	ID         string         `json:"id,omitempty"`
+	Transports []string       `json:"transports"`         // имена транспортов или путей медиа
+	Traffic    string         `json:"traffic,omitempty"`  // calls или messages; пусто - весь трафик
+	Days       []time.Weekday `json:"days,omitempty"`     // дни начала окна (0 - воскресенье); пусто - каждый день
+	Start      string         `json:"start"`              // HH:MM
+	End        string         `json:"end"`                // HH:MM; равно Start - круглые сутки
+	Timezone   string         `json:"timezone,omitempty"` // IANA, например Europe/Moscow; пусто - локальное время
+	Reason     string         `json:"reason,omitempty"`
+}
+
+// ActiveBlackout - действующее окно и момент его окончания
+type ActiveBlackout struct {
+	Blackout
+	Until time.Time `json:"until"`
+}
+
+// Validate проверяет формат окна
+func (b *Blackout) Validate() error {
+	if len(b.Transports) == 0 {
+		return fmt.Errorf("no transports")
+	}
+	if b.Traffic != "" && b.Traffic != TrafficCalls && b.Traffic != TrafficMessages {
+		return fmt.Errorf("unknown traffic %q", b.Traffic)
+	}
+	if _, err := parseClock(b.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(b.End); err != nil {
+		return err
+	}
+	for _, day := range b.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("invalid day %d", day)
+		}
+	}
+	if _, err := b.location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", b.Timezone, err)
+	}
+	return nil
+}
+
+// covers сообщает, относится ли окно к транспорту name и виду трафика
+func (b *Blackout) covers(traffic, name string) bool {
+	if b.Traffic != "" && b.Traffic != traffic {
+		return false
+	}
+	for _, t := range b.Transports {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt сообщает, действует ли окно в момент now, и когда оно закончится
+func (b *Blackout) ActiveAt(now time.Time) (bool, time.Time) {
+	start, err := parseClock(b.Start)
+	if err != nil {
+		return false, time.Time{}
+	}
+	end, err := parseClock(b.End)
+	if err != nil {
+		return false, time.Time{}
+	}
+	loc, err := b.location()
+	if err != nil {
+		return false, time.Time{}
+	}
+
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	length := end - start
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+
+	// Окно могло начаться сегодня или, если переходит через полночь, вчера
+	for _, begin := range []time.Time{midnight.Add(start), midnight.AddDate(0, 0, -1).Add(start)} {
+		finish := begin.Add(length)
+		if !b.onDay(begin.Weekday()) || local.Before(begin) || !local.Before(finish) {
+			continue
+		}
+		return true, finish
+	}
+	return false, time.Time{}
+}
+
+func (b *Blackout) location() (*time.Location, error) {
+	if b.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(b.Timezone)
+}
+
+func (b *Blackout) onDay(day time.Weekday) bool {
+	if len(b.Days) == 0 {
+		return true
+	}
+	for _, d := range b.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock разбирает время суток HH:MM в смещение от полуночи
+func parseClock(value string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// ParseBlackouts разбирает окна из конфигурации, разделенные ";":
+// [calls:|messages:]транспорт,транспорт@HH:MM-HH:MM, например "mesh@22:00-06:00".
+// timezone применяется ко всем окнам.
+func ParseBlackouts(spec, timezone string) ([]Blackout, error) {
+	var blackouts []Blackout
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		names, window, ok := strings.Cut(item, "@")
+		start, end, ok2 := strings.Cut(window, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid blackout %q", item)
+		}
+
+		b := Blackout{Start: strings.TrimSpace(start), End: strings.TrimSpace(end), Timezone: timezone}
+		if traffic, rest, found := strings.Cut(names, ":"); found {
+			b.Traffic, names = strings.TrimSpace(traffic), rest
+		}
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				b.Transports = append(b.Transports, name)
+			}
+		}
+		if err := b.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid blackout %q: %w", item, err)
+		}
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, nil
+}
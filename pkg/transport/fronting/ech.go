@@ -0,0 +1,51 @@
+package fronting
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// echResolvers - DNS серверы, у которых запрашиваются HTTPS записи с ECH конфигурацией.
+var echResolvers = []string{"8.8.8.8:53", "1.1.1.1:53", "9.9.9.9:53"}
+
+// LookupECHConfig получает ECHConfigList домена из его DNS HTTPS (SVCB) записи.
+// Возвращает ошибку, если домен не публикует ECH конфигурацию.
+func LookupECHConfig(ctx context.Context, domain string) ([]byte, error) {
+	if net.ParseIP(domain) != nil {
+		return nil, fmt.Errorf("ECH is not supported for IP address %s", domain)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(domain), dns.TypeHTTPS)
+	msg.RecursionDesired = true
+
+	client := &dns.Client{Timeout: 3 * time.Second}
+
+	var lastErr error
+	for _, server := range echResolvers {
+		resp, _, err := client.ExchangeContext(ctx, msg, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			https, ok := rr.(*dns.HTTPS)
+			if !ok {
+				continue
+			}
+			for _, kv := range https.Value {
+				if ech, ok := kv.(*dns.SVCBECHConfig); ok && len(ech.ECH) > 0 {
+					return ech.ECH, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("no ECH config published for %s", domain)
+	}
+
+	return nil, fmt.Errorf("failed to query HTTPS record for %s: %w", domain, lastErr)
+}
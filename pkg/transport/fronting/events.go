@@ -0,0 +1,87 @@
+package fronting
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Классы событий фронта
+const (
+	EventBlocked     = "blocked"      // CDN отклоняет запросы к скрытому домену (403, подмена сертификата)
+	EventTimeout     = "timeout"      // поток не уложился в таймаут
+	EventServerError = "server_error" // 5xx от CDN или скрытого сервиса
+	EventNetwork     = "network"      // сбой TCP/DNS до фронта
+	EventError       = "error"        // прочие ошибки
+	EventRecovered   = "recovered"    // фронт снова работает после сбоев
+)
+
+// FrontEvent - сбой или восстановление фронт-домена. Сохраняется в истории блокировок.
+type FrontEvent struct {
+	Domain string    `json:"domain"`
+	Class  string    `json:"class"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// ClassifyError определяет класс ошибки фронта
+func ClassifyError(err error) string {
+	var netErr *net.OpError
+	switch {
+	case isBlocked(err):
+		return EventBlocked
+	case errors.Is(err, ErrTimeout):
+		return EventTimeout
+	case errors.Is(err, ErrServerError):
+		return EventServerError
+	case errors.As(err, &netErr):
+		return EventNetwork
+	default:
+		return EventError
+	}
+}
+
+// OnEvent задает обработчик событий фронтов (для сохранения истории блокировок)
+func (p *Pool) OnEvent(handler func(FrontEvent)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onEvent = handler
+}
+
+// RecordFailure фиксирует сбой отправки через фронт. Состояние фронта не меняет -
+// для этого используется MarkBlocked.
+func (p *Pool) RecordFailure(domain string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emitLocked(domain, ClassifyError(err), err)
+}
+
+// SetAvoided задает фронты, которые по истории стабильно блокируются в регионе пользователя.
+// Такие фронты используются только после остальных и реже проверяются в фоне.
+func (p *Pool) SetAvoided(domains []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	avoided := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		avoided[d] = true
+	}
+	for _, f := range p.fronts {
+		f.status.Avoided = avoided[f.status.Domain]
+	}
+	if len(p.fronts) > 0 && p.fronts[p.active].status.Avoided {
+		p.rotateLocked()
+	}
+}
+
+// emitLocked передает событие обработчику. Вызывается под p.mu.
+func (p *Pool) emitLocked(domain, class string, err error) {
+	if p.onEvent == nil {
+		return
+	}
+	event := FrontEvent{Domain: domain, Class: class, Time: time.Now()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	go p.onEvent(event)
+}
@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"hydra/pkg/transport"
@@ -28,17 +34,67 @@ type Transport struct {
 	// EndpointUrl - полный URL для подключения (обычно https://FrontDomain/path).
 	EndpointUrl string
 
-	client *http.Client
+	// PollUrl - URL для получения входящих сообщений длинным опросом (long-polling).
+	PollUrl string
+
+	// SessionID идентифицирует клиента на скрытом сервисе (заголовок X-Session-ID).
+	SessionID string
+
+	// ECHConfigList - сериализованный ECHConfigList фронт-домена.
+	// Если пусто, конфигурация запрашивается из DNS HTTPS записи при первом подключении.
+	ECHConfigList []byte
+
+	// DisableECH отключает попытки Encrypted Client Hello (только классический fronting).
+	DisableECH bool
+
+	// Protocol - протокол HTTP поверх фронта: ProtocolHTTP2 (по умолчанию), ProtocolHTTP1 или ProtocolHTTP3.
+	// Меняется через SetProtocol.
+	Protocol string
+
+	// StreamTimeout - таймаут одного запроса (потока HTTP/2) к скрытому сервису.
+	StreamTimeout time.Duration
+
+	// Retry - повторы временных ошибок через этот фронт до переключения на другой.
+	Retry RetryPolicy
+
+	client        *http.Client
+	pollClient    *http.Client
+	httpTransport *http.Transport
+	metrics       ConnMetrics
+	proxyURL      *url.URL
+
+	mu          sync.Mutex
+	cursor      string
+	mode        string
+	echResolved bool
 }
 
+// ErrBlocked возвращается, когда CDN отклоняет запрос к скрытому домену (признак блокировки фронта)
+var ErrBlocked = errors.New("front blocked")
+
+// maxResponseSize - максимальный размер тела ответа, возвращаемого Exchange
+const maxResponseSize = 4 << 20
+
+// Режимы установки TLS соединения
+const (
+	// ModeClassic - классический domain fronting: SNI=FrontDomain, Host=HiddenDomain.
+	ModeClassic = "classic"
+	// ModeECH - Encrypted Client Hello: реальный SNI (HiddenDomain) зашифрован.
+	ModeECH = "ech"
+)
+
 // New создает новый экземпляр транспорта.
-func New(frontDomain, hiddenDomain string) *Transport {
+func New(frontDomain, hiddenDomain string, opts ...Option) *Transport {
 	// Создаем кастомный HTTP транспорт с оптимизированными настройками
 	httpTransport := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			// Ключевой момент 1: SNI (Server Name Indication) указывает на "белый" домен.
 			ServerName: frontDomain,
+			// Явно согласуем HTTP/2, чтобы все сообщения шли потоками внутри одного TLS соединения
+			NextProtos: []string{"h2", "http/1.1"},
 		},
+		// Кастомный DialTLSContext по умолчанию отключает HTTP/2 - включаем явно
+		ForceAttemptHTTP2: true,
 		// Оптимизированные таймауты
 		DialContext: (&net.Dialer{
 			Timeout:   5 * time.Second,
@@ -51,66 +107,211 @@ func New(frontDomain, hiddenDomain string) *Transport {
 		MaxIdleConns:          10,
 		MaxIdleConnsPerHost:   5,
 	}
-	httpTransport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		dnsServers := []string{"", "8.8.8.8:53", "1.1.1.1:53", "9.9.9.9:53"}
-
-		for _, dnsServer := range dnsServers {
-			var dialer net.Dialer
-			if dnsServer != "" {
-				dialer = net.Dialer{
-					Timeout:   5 * time.Second,
-					KeepAlive: 30 * time.Second,
-					Resolver: &net.Resolver{
-						PreferGo: true,
-						Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-							return net.Dial("udp", dnsServer)
-						},
-					},
-				}
-			} else {
-				dialer = net.Dialer{
-					Timeout:   5 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}
-			}
+	t := &Transport{
+		FrontDomain:  frontDomain,
+		HiddenDomain: hiddenDomain,
+		// По умолчанию стучимся на frontDomain.
+		// Реальный роутинг произойдет на уровне CDN благодаря Host заголовку.
+		EndpointUrl: fmt.Sprintf("https://%s/message", frontDomain),
+		PollUrl:     fmt.Sprintf("https://%s/poll", frontDomain),
+		SessionID:   newSessionID(),
+		Protocol:    ProtocolHTTP2,
+		// Таймаут задается на каждый поток отдельно (StreamTimeout), общий таймаут клиента не нужен
+		StreamTimeout: 8 * time.Second,
+		Retry:         DefaultRetryPolicy(),
+		client: &http.Client{
+			Transport: httpTransport,
+		},
+		// Для long-polling нужен таймаут больше, чем время удержания запроса сервером
+		pollClient: &http.Client{
+			Transport: httpTransport,
+			Timeout:   PollWait + 10*time.Second,
+		},
+		httpTransport: httpTransport,
+		mode:          ModeClassic,
+	}
+	httpTransport.DialTLSContext = t.dialTLS
 
-			tcpConn, err := dialer.DialContext(ctx, "tcp", addr)
-			if err != nil {
-				continue
-			}
+	for _, opt := range opts {
+		opt(t)
+	}
 
-			tlsConfig := &tls.Config{}
-			if httpTransport.TLSClientConfig != nil {
-				tlsConfig = httpTransport.TLSClientConfig.Clone()
+	return t
+}
+
+// WithAddress направляет запросы на конкретный адрес узла CDN (host:port) вместо
+// разрешения фронт-домена через DNS. SNI и проверка сертификата остаются по фронт-домену.
+func WithAddress(addr string) Option {
+	return func(t *Transport) {
+		t.EndpointUrl = fmt.Sprintf("https://%s/message", addr)
+		t.PollUrl = fmt.Sprintf("https://%s/poll", addr)
+	}
+}
+
+// WithRootCAs задает корневые сертификаты для проверки сертификата фронта
+// (вместо системных), например для собственного CDN или ретранслятора в тестах
+func WithRootCAs(roots *x509.CertPool) Option {
+	return func(t *Transport) {
+		t.httpTransport.TLSClientConfig.RootCAs = roots
+	}
+}
+
+// WithoutECH отключает Encrypted Client Hello и поиск его конфигурации в DNS
+func WithoutECH() Option {
+	return func(t *Transport) {
+		t.DisableECH = true
+	}
+}
+
+// dialTLS устанавливает TLS соединение с фронтом.
+// Сначала пробует ECH (если фронт его поддерживает), при отказе - классический fronting.
+func (t *Transport) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	tlsConfig := &tls.Config{}
+	if t.httpTransport.TLSClientConfig != nil {
+		tlsConfig = t.httpTransport.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = t.FrontDomain
+	}
+
+	if echConfig := t.echConfig(ctx); len(echConfig) > 0 {
+		echTLSConfig := tlsConfig.Clone()
+		// При ECH внешний SNI берется из public_name конфигурации,
+		// а во внутреннем ClientHello указываем реальный скрытый домен.
+		echTLSConfig.ServerName = t.HiddenDomain
+		echTLSConfig.EncryptedClientHelloConfigList = echConfig
+		echTLSConfig.MinVersion = tls.VersionTLS13
+
+		conn, err := t.handshake(ctx, addr, echTLSConfig)
+		if err == nil {
+			t.setMode(ModeECH)
+			return conn, nil
+		}
+
+		var rejection *tls.ECHRejectionError
+		if errors.As(err, &rejection) && len(rejection.RetryConfigList) > 0 {
+			// Фронт прислал актуальную конфигурацию - используем ее в следующий раз
+			t.mu.Lock()
+			t.ECHConfigList = rejection.RetryConfigList
+			t.mu.Unlock()
+		} else {
+			// Фронт не поддерживает ECH - больше не пытаемся
+			t.mu.Lock()
+			t.ECHConfigList = nil
+			t.mu.Unlock()
+		}
+		log.Printf("ECH handshake with %s failed, falling back to classic fronting: %v", t.FrontDomain, err)
+	}
+
+	conn, err := t.handshake(ctx, addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	t.setMode(ModeClassic)
+	return conn, nil
+}
+
+// handshake перебирает DNS серверы и выполняет TLS рукопожатие с указанной конфигурацией.
+// Если задан прокси, соединение устанавливается через него (TLS внутри туннеля прокси).
+func (t *Transport) handshake(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if t.proxyURL != nil {
+		conn, err := t.dialViaProxy(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	dnsServers := []string{"", "8.8.8.8:53", "1.1.1.1:53", "9.9.9.9:53"}
+
+	var lastErr error
+	for _, dnsServer := range dnsServers {
+		var dialer net.Dialer
+		if dnsServer != "" {
+			dialer = net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Resolver: &net.Resolver{
+					PreferGo: true,
+					Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+						return net.Dial("udp", dnsServer)
+					},
+				},
 			}
-			if tlsConfig.ServerName == "" {
-				tlsConfig.ServerName = frontDomain
+		} else {
+			dialer = net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
 			}
+		}
 
-			tlsConn := tls.Client(tcpConn, tlsConfig)
+		tcpConn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
 
-			if err := tlsConn.HandshakeContext(ctx); err != nil {
-				tcpConn.Close()
-				continue
-			}
+		tlsConn := tls.Client(tcpConn, tlsConfig)
 
-			return tlsConn, nil
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			tcpConn.Close()
+			var rejection *tls.ECHRejectionError
+			if errors.As(err, &rejection) {
+				// Отказ в ECH не зависит от DNS сервера
+				return nil, err
+			}
+			lastErr = err
+			continue
 		}
 
-		return nil, fmt.Errorf("all DNS servers failed for %s", addr)
+		return tlsConn, nil
 	}
 
-	return &Transport{
-		FrontDomain:  frontDomain,
-		HiddenDomain: hiddenDomain,
-		// По умолчанию стучимся на frontDomain.
-		// Реальный роутинг произойдет на уровне CDN благодаря Host заголовку.
-		EndpointUrl: fmt.Sprintf("https://%s/message", frontDomain),
-		client: &http.Client{
-			Transport: httpTransport,
-			Timeout:   8 * time.Second, // Уменьшенный общий таймаут
-		},
+	if lastErr != nil {
+		return nil, fmt.Errorf("all DNS servers failed for %s: %w", addr, lastErr)
+	}
+	return nil, fmt.Errorf("all DNS servers failed for %s", addr)
+}
+
+// echConfig возвращает ECH конфигурацию фронта, при необходимости запрашивая ее из DNS.
+func (t *Transport) echConfig(ctx context.Context) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.DisableECH {
+		return nil
 	}
+
+	// Через прокси DNS запросы к резолверам ушли бы напрямую - автоматический поиск ECH отключаем
+	if len(t.ECHConfigList) == 0 && !t.echResolved && t.proxyURL == nil {
+		t.echResolved = true
+		config, err := LookupECHConfig(ctx, t.FrontDomain)
+		if err != nil {
+			log.Printf("ECH config for %s not available: %v", t.FrontDomain, err)
+		} else {
+			t.ECHConfigList = config
+		}
+	}
+
+	return t.ECHConfigList
+}
+
+func (t *Transport) setMode(mode string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mode = mode
+}
+
+// Mode возвращает режим, использованный при последнем TLS соединении (ModeECH или ModeClassic).
+func (t *Transport) Mode() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mode
 }
 
 func (t *Transport) Name() string {
@@ -127,10 +328,26 @@ func (t *Transport) IsAvailable() bool {
 	return true
 }
 
+// Send отправляет данные скрытому сервису, не дожидаясь содержимого ответа
 func (t *Transport) Send(ctx context.Context, data []byte) error {
+	_, err := t.Exchange(ctx, data)
+	return err
+}
+
+// Exchange отправляет данные скрытому сервису и возвращает тело ответа
+// (ID доставки, входящие сообщения в очереди и т.п.).
+// Временные ошибки повторяются по политике Retry.
+func (t *Transport) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	return t.withRetry(ctx, func() ([]byte, error) {
+		return t.exchangeOnce(ctx, data)
+	})
+}
+
+// exchangeOnce выполняет одну попытку отправки
+func (t *Transport) exchangeOnce(ctx context.Context, data []byte) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", t.EndpointUrl, bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to create request for %s: %w", t.EndpointUrl, err)
+		return nil, fmt.Errorf("failed to create request for %s: %w", t.EndpointUrl, err)
 	}
 
 	// Ключевой момент 2: Host заголовок указывает на скрытый сервис.
@@ -143,22 +360,24 @@ func (t *Transport) Send(ctx context.Context, data []byte) error {
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
 	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("X-Session-ID", t.SessionID)
 
-	// Добавляем таймаут для конкретного запроса
-	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	// Добавляем таймаут для конкретного запроса (потока)
+	ctx, cancel := context.WithTimeout(ctx, t.StreamTimeout)
 	defer cancel()
 	req = req.WithContext(ctx)
 
-	resp, err := t.client.Do(req)
+	resp, err := t.do(t.client, req)
 	if err != nil {
 		// Анализируем тип ошибки для лучшего сообщения
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return fmt.Errorf("request to %s timed out after 8 seconds", t.FrontDomain)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+			t.recordStreamTimeout()
+			return nil, fmt.Errorf("request to %s timed out after %s: %w", t.FrontDomain, t.StreamTimeout, ErrTimeout)
 		}
 		if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
-			return fmt.Errorf("network connection failed to %s: %w", t.FrontDomain, err)
+			return nil, fmt.Errorf("network connection failed to %s: %w", t.FrontDomain, err)
 		}
-		return fmt.Errorf("request to %s failed: %w", t.FrontDomain, err)
+		return nil, fmt.Errorf("request to %s failed: %w", t.FrontDomain, err)
 	}
 	defer resp.Body.Close()
 
@@ -169,15 +388,56 @@ func (t *Transport) Send(ctx context.Context, data []byte) error {
 		// Специфичные коды ошибок CDN
 		switch resp.StatusCode {
 		case 403:
-			return fmt.Errorf("CDN blocked request to %s (403 Forbidden)", t.FrontDomain)
+			return nil, fmt.Errorf("CDN blocked request to %s (403 Forbidden): %w", t.FrontDomain, ErrBlocked)
 		case 404:
-			return fmt.Errorf("endpoint not found on %s (404 Not Found)", t.FrontDomain)
+			return nil, fmt.Errorf("endpoint not found on %s (404 Not Found)", t.FrontDomain)
 		case 502, 503, 504:
-			return fmt.Errorf("CDN gateway error %d for %s", resp.StatusCode, t.FrontDomain)
+			return nil, fmt.Errorf("CDN gateway error %d for %s: %w", resp.StatusCode, t.FrontDomain, ErrServerError)
 		default:
-			return fmt.Errorf("server %s returned status %d: %s", t.FrontDomain, resp.StatusCode, string(body))
+			if resp.StatusCode >= 500 {
+				return nil, fmt.Errorf("server %s returned status %d: %s: %w", t.FrontDomain, resp.StatusCode, string(body), ErrServerError)
+			}
+			return nil, fmt.Errorf("server %s returned status %d: %s", t.FrontDomain, resp.StatusCode, string(body))
 		}
 	}
 
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", t.FrontDomain, err)
+	}
+	return body, nil
+}
+
+// Probe проверяет доступность фронта: выполняет легкий запрос к скрытому сервису через CDN.
+// Любой ответ, кроме 403, считается признаком рабочего фронта.
+func (t *Transport) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, t.EndpointUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create probe request for %s: %w", t.EndpointUrl, err)
+	}
+	req.Host = t.HiddenDomain
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	ctx, cancel := context.WithTimeout(ctx, t.StreamTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := t.do(t.client, req)
+	if err != nil {
+		return fmt.Errorf("probe of %s failed: %w", t.FrontDomain, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("CDN blocked probe to %s (403 Forbidden): %w", t.FrontDomain, ErrBlocked)
+	}
 	return nil
 }
+
+// isBlocked сообщает, указывает ли ошибка на блокировку фронта (а не на временный сбой)
+func isBlocked(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrBlocked) || strings.Contains(err.Error(), "certificate")
+}
@@ -2,10 +2,14 @@ package fronting
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestDomainFrontingLogic проверяет, что клиент действительно отправляет разные Host header и SNI/URL.
@@ -52,7 +56,7 @@ func TestDomainFrontingLogic(t *testing.T) {
 	tr.EndpointUrl = server.URL // https://127.0.0.1:xxxxx
 
 	// Хак для теста: разрешаем самоподписанные сертификаты
-	tr.client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
 
 	// Выполняем отправку
 	err := tr.Send(context.Background(), []byte("test-payload"))
@@ -60,3 +64,209 @@ func TestDomainFrontingLogic(t *testing.T) {
 		t.Fatalf("Send failed: %v", err)
 	}
 }
+
+// TestECHFallbackToClassic проверяет, что при неработающем ECH транспорт
+// откатывается на классический domain fronting и сообщает об этом через Mode().
+func TestECHFallbackToClassic(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tr := New("127.0.0.1", "hidden-service.com")
+	tr.EndpointUrl = server.URL
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	// Заведомо некорректная ECH конфигурация - рукопожатие с ECH должно провалиться
+	tr.ECHConfigList = []byte{0x00, 0x01, 0x02}
+
+	if err := tr.Send(context.Background(), []byte("test-payload")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if mode := tr.Mode(); mode != ModeClassic {
+		t.Errorf("Expected mode %s, got %s", ModeClassic, mode)
+	}
+}
+
+// TestExchangeReturnsResponse проверяет, что Exchange возвращает тело ответа скрытого сервиса.
+func TestExchangeReturnsResponse(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"delivery_id":"42"}`)
+	}))
+	defer server.Close()
+
+	tr := New("127.0.0.1", "hidden-service.com")
+	tr.EndpointUrl = server.URL
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	reply, err := tr.Exchange(context.Background(), []byte("test-payload"))
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if string(reply) != `{"delivery_id":"42"}` {
+		t.Errorf("Unexpected reply: %s", reply)
+	}
+}
+
+// TestPollAdvancesCursor проверяет прием сообщений длинным опросом и продвижение курсора.
+func TestPollAdvancesCursor(t *testing.T) {
+	var sessionID string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID = r.Header.Get("X-Session-ID")
+		if r.URL.Query().Get("cursor") == "2" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"messages":[{"cursor":"1","data":"aGVsbG8="},{"cursor":"2","data":"d29ybGQ="}]}`)
+	}))
+	defer server.Close()
+
+	tr := New("127.0.0.1", "hidden-service.com")
+	tr.PollUrl = server.URL + "/poll"
+	tr.DisableECH = true
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	messages, err := tr.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+	if len(messages) != 2 || string(messages[0].Data) != "hello" || string(messages[1].Data) != "world" {
+		t.Fatalf("Unexpected messages: %+v", messages)
+	}
+	if tr.Cursor() != "2" {
+		t.Errorf("Expected cursor 2, got %q", tr.Cursor())
+	}
+	if sessionID != tr.SessionID {
+		t.Errorf("Expected session ID %s, got %s", tr.SessionID, sessionID)
+	}
+
+	messages, err = tr.Poll(context.Background())
+	if err != nil || len(messages) != 0 {
+		t.Errorf("Expected empty poll after cursor, got %v, %v", messages, err)
+	}
+}
+
+// TestHTTP2ConnectionReuse проверяет, что несколько сообщений идут потоками HTTP/2 в одном TLS соединении.
+func TestHTTP2ConnectionReuse(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("Expected HTTP/2 request, got %s", r.Proto)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tr := New("127.0.0.1", "hidden-service.com")
+	tr.EndpointUrl = server.URL
+	tr.DisableECH = true
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	for i := 0; i < 3; i++ {
+		if err := tr.Send(context.Background(), []byte("test-payload")); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+
+	metrics := tr.Metrics()
+	if metrics.Protocol != ProtocolHTTP2 {
+		t.Errorf("Expected protocol %s, got %s", ProtocolHTTP2, metrics.Protocol)
+	}
+	if metrics.NewConns != 1 || metrics.ReusedConns != 2 {
+		t.Errorf("Expected 1 new and 2 reused connections, got %d new, %d reused", metrics.NewConns, metrics.ReusedConns)
+	}
+}
+
+// TestSendViaHTTPProxy проверяет, что TLS соединение с фронтом устанавливается через CONNECT прокси с авторизацией.
+func TestSendViaHTTPProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var connected string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		connected = r.Host
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		client, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(upstream, client)
+		io.Copy(client, upstream)
+		client.Close()
+		upstream.Close()
+	}))
+	defer proxyServer.Close()
+
+	proxyURL, err := ParseProxyURL(strings.Replace(proxyServer.URL, "http://", "http://user:secret@", 1))
+	if err != nil {
+		t.Fatalf("ParseProxyURL failed: %v", err)
+	}
+
+	tr := New("127.0.0.1", "hidden-service.com", WithProxy(proxyURL))
+	tr.EndpointUrl = server.URL
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	if err := tr.Send(context.Background(), []byte("test-payload")); err != nil {
+		t.Fatalf("Send via proxy failed: %v", err)
+	}
+	if connected != strings.TrimPrefix(server.URL, "https://") {
+		t.Errorf("Expected CONNECT to %s, got %q", server.URL, connected)
+	}
+}
+
+// TestRetryTransientErrors проверяет повтор после 5xx и отсутствие повторов при блокировке (403).
+func TestRetryTransientErrors(t *testing.T) {
+	var calls int
+	status := http.StatusServiceUnavailable
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 || status == http.StatusForbidden {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tr := New("127.0.0.1", "hidden-service.com")
+	tr.EndpointUrl = server.URL
+	tr.httpTransport.TLSClientConfig.InsecureSkipVerify = true
+	tr.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	if err := tr.Send(context.Background(), []byte("test-payload")); err != nil {
+		t.Fatalf("Send should succeed after retry: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls)
+	}
+	if retries := tr.Metrics().Retries; retries != 1 {
+		t.Errorf("Expected 1 retry in metrics, got %d", retries)
+	}
+
+	calls = 0
+	status = http.StatusForbidden
+	err := tr.Send(context.Background(), []byte("test-payload"))
+	if !errors.Is(err, ErrBlocked) {
+		t.Fatalf("Expected ErrBlocked, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Blocked request must not be retried, got %d attempts", calls)
+	}
+}
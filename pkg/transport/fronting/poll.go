@@ -0,0 +1,138 @@
+package fronting
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PollWait - сколько скрытый сервис удерживает запрос опроса, если новых сообщений нет
+const PollWait = 25 * time.Second
+
+// PolledMessage - входящее сообщение, полученное через опрос
+type PolledMessage struct {
+	Cursor string `json:"cursor"`
+	Data   []byte `json:"data"`
+}
+
+// pollResponse - ответ скрытого сервиса на запрос опроса
+type pollResponse struct {
+	Messages []PolledMessage `json:"messages"`
+	Cursor   string          `json:"cursor"`
+}
+
+// Cursor возвращает позицию последнего полученного сообщения.
+// Ее можно сохранить и восстановить через SetCursor, чтобы продолжить прием после перезапуска.
+func (t *Transport) Cursor() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cursor
+}
+
+// SetCursor задает позицию, с которой продолжается прием сообщений
+func (t *Transport) SetCursor(cursor string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cursor = cursor
+}
+
+// Poll выполняет один запрос длинного опроса и возвращает новые сообщения после текущего курсора.
+// Курсор продвигается только после успешного разбора ответа.
+func (t *Transport) Poll(ctx context.Context) ([]PolledMessage, error) {
+	query := url.Values{}
+	query.Set("cursor", t.Cursor())
+	query.Set("wait", strconv.Itoa(int(PollWait.Seconds())))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.PollUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create poll request for %s: %w", t.PollUrl, err)
+	}
+
+	req.Host = t.HiddenDomain
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Session-ID", t.SessionID)
+
+	resp, err := t.do(t.pollClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("poll via %s failed: %w", t.FrontDomain, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("CDN blocked poll to %s (403 Forbidden): %w", t.FrontDomain, ErrBlocked)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("poll via %s returned status %d: %s", t.FrontDomain, resp.StatusCode, string(body))
+	}
+
+	var result pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid poll response from %s: %w", t.FrontDomain, err)
+	}
+
+	cursor := result.Cursor
+	if cursor == "" && len(result.Messages) > 0 {
+		cursor = result.Messages[len(result.Messages)-1].Cursor
+	}
+	if cursor != "" {
+		t.SetCursor(cursor)
+	}
+
+	return result.Messages, nil
+}
+
+// StartReceiving запускает цикл приема сообщений, пока не отменен ctx.
+// handler вызывается для каждого сообщения по порядку. При ошибках цикл
+// делает паузу с нарастающей задержкой (до минуты).
+func (t *Transport) StartReceiving(ctx context.Context, handler func(data []byte)) {
+	go func() {
+		backoff := time.Second
+
+		for {
+			messages, err := t.Poll(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				log.Printf("Fronting receive error via %s: %v (retry in %s)", t.FrontDomain, err, backoff)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff < time.Minute {
+					backoff *= 2
+				}
+				continue
+			}
+
+			backoff = time.Second
+			for _, msg := range messages {
+				handler(msg.Data)
+			}
+		}
+	}()
+}
+
+// newSessionID генерирует случайный идентификатор сессии клиента
+func newSessionID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("session-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,437 @@
+package fronting
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultFrontDomains - встроенный список фронтов, используемый если конфигурация не задана
+var DefaultFrontDomains = []string{
+	"ajax.googleapis.com",       // Google CDN
+	"cdn.cloudflare.com",        // Cloudflare CDN
+	"d3a2p9q8.stackpathcdn.com", // StackPath CDN
+	"assets.buymeacoffee.com",   // BuyMeACoffee CDN
+}
+
+// blockedRetryAfter - через сколько заблокированный фронт снова проверяется
+const blockedRetryAfter = 30 * time.Minute
+
+// avoidedRetryAfter - как часто проверяются фронты, стабильно блокируемые по истории
+const avoidedRetryAfter = 6 * time.Hour
+
+// FrontStatus - состояние фронт-домена в пуле
+type FrontStatus struct {
+	Domain    string      `json:"domain"`
+	Active    bool        `json:"active"`
+	Healthy   bool        `json:"healthy"`
+	Blocked   bool        `json:"blocked"`
+	Avoided   bool        `json:"avoided"` // стабильно блокируется по истории (см. SetAvoided)
+	BlockedAt time.Time   `json:"blocked_at,omitempty"`
+	CheckedAt time.Time   `json:"checked_at,omitempty"`
+	LatencyMs int64       `json:"latency_ms"`
+	Failures  int         `json:"failures"`
+	Mode      string      `json:"mode"`
+	Metrics   ConnMetrics `json:"metrics"`
+}
+
+type front struct {
+	transport *Transport
+	status    FrontStatus
+}
+
+// SignedFrontList - удаленный список фронтов, подписанный ключом Ed25519.
+// Payload - base64 JSON вида {"fronts": [...], "issued_at": <unix>}.
+type SignedFrontList struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// FrontList - содержимое подписанного списка фронтов
+type FrontList struct {
+	Fronts   []string `json:"fronts"`
+	IssuedAt int64    `json:"issued_at"`
+}
+
+// Pool поддерживает набор кандидатов во фронт-домены, проверяет их в фоне
+// и переключает активный фронт при блокировке.
+type Pool struct {
+	hiddenDomain string
+
+	// Удаленный подписанный список (необязательно)
+	listURL      string
+	listKey      ed25519.PublicKey
+	listIssuedAt int64
+
+	// opts передаются в New для каждого фронта
+	opts []Option
+
+	// configure применяется к каждому транспорту пула, включая добавленные позже
+	configure func(*Transport)
+
+	// onEvent получает сбои и восстановления фронтов (история блокировок)
+	onEvent func(FrontEvent)
+
+	fronts   []*front
+	active   int
+	mu       sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewPool создает пул фронтов для скрытого домена. Если seeds пуст, используется DefaultFrontDomains.
+// opts применяются ко всем транспортам пула (например, WithProxy).
+func NewPool(hiddenDomain string, seeds []string, opts ...Option) *Pool {
+	if len(seeds) == 0 {
+		seeds = DefaultFrontDomains
+	}
+
+	p := &Pool{
+		hiddenDomain: hiddenDomain,
+		opts:         opts,
+		stopChan:     make(chan struct{}),
+	}
+	for _, domain := range seeds {
+		p.addLocked(domain)
+	}
+	return p
+}
+
+// SetRemoteList задает URL подписанного списка фронтов и ключ для проверки подписи
+func (p *Pool) SetRemoteList(url string, publicKey ed25519.PublicKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listURL = url
+	p.listKey = publicKey
+}
+
+// Configure задает настройку транспортов (протокол, таймауты и т.п.)
+// и применяет ее к уже созданным и ко всем будущим фронтам пула
+func (p *Pool) Configure(configure func(*Transport)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.configure = configure
+	for _, f := range p.fronts {
+		configure(f.transport)
+	}
+}
+
+// addLocked добавляет фронт, если его еще нет. Вызывается под p.mu.
+func (p *Pool) addLocked(domain string) {
+	if domain == "" {
+		return
+	}
+	for _, f := range p.fronts {
+		if f.status.Domain == domain {
+			return
+		}
+	}
+
+	t := New(domain, p.hiddenDomain, p.opts...)
+	if p.configure != nil {
+		p.configure(t)
+	}
+	p.fronts = append(p.fronts, &front{
+		transport: t,
+		status:    FrontStatus{Domain: domain, Healthy: true},
+	})
+}
+
+// Active возвращает текущий активный фронт
+func (p *Pool) Active() *Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.fronts) == 0 {
+		return nil
+	}
+	return p.fronts[p.active].transport
+}
+
+// Transports возвращает транспорты в порядке попыток: активный, затем здоровые,
+// затем стабильно блокируемые по истории и заблокированные (на случай если блокировку уже сняли).
+func (p *Pool) Transports() []*Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.fronts) == 0 {
+		return nil
+	}
+
+	result := []*Transport{p.fronts[p.active].transport}
+	var avoided, blocked []*Transport
+	for i, f := range p.fronts {
+		if i == p.active {
+			continue
+		}
+		switch {
+		case f.status.Blocked:
+			blocked = append(blocked, f.transport)
+		case f.status.Avoided:
+			avoided = append(avoided, f.transport)
+		default:
+			result = append(result, f.transport)
+		}
+	}
+	result = append(result, avoided...)
+	return append(result, blocked...)
+}
+
+// Usable сообщает, есть ли в пуле хотя бы один незаблокированный фронт
+func (p *Pool) Usable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, f := range p.fronts {
+		if !f.status.Blocked {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkBlocked помечает фронт заблокированным и, если он был активным, переключается на следующий
+func (p *Pool) MarkBlocked(domain string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx := p.indexLocked(domain)
+	if idx < 0 {
+		return
+	}
+
+	f := p.fronts[idx]
+	f.status.Blocked = true
+	f.status.Healthy = false
+	f.status.BlockedAt = time.Now()
+	f.status.Failures++
+	p.emitLocked(domain, EventBlocked, nil)
+
+	if idx == p.active {
+		p.rotateLocked()
+	}
+}
+
+// MarkSuccess фиксирует успешную отправку через фронт и делает его активным
+func (p *Pool) MarkSuccess(domain string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx := p.indexLocked(domain)
+	if idx < 0 {
+		return
+	}
+
+	f := p.fronts[idx]
+	if f.status.Blocked || f.status.Failures > 0 {
+		p.emitLocked(domain, EventRecovered, nil)
+	}
+	f.status.Blocked = false
+	f.status.Healthy = true
+	f.status.Failures = 0
+	p.active = idx
+}
+
+// rotateLocked выбирает следующий здоровый фронт, предпочитая не блокируемые по истории.
+// Вызывается под p.mu.
+func (p *Pool) rotateLocked() {
+	for _, allowAvoided := range []bool{false, true} {
+		for i := 1; i <= len(p.fronts); i++ {
+			next := (p.active + i) % len(p.fronts)
+			st := p.fronts[next].status
+			if st.Blocked || (st.Avoided && !allowAvoided) {
+				continue
+			}
+			log.Printf("Front %s unusable, rotating to %s", p.fronts[p.active].status.Domain, st.Domain)
+			p.active = next
+			return
+		}
+	}
+}
+
+func (p *Pool) indexLocked(domain string) int {
+	for i, f := range p.fronts {
+		if f.status.Domain == domain {
+			return i
+		}
+	}
+	return -1
+}
+
+// Status возвращает состояние всех фронтов
+func (p *Pool) Status() []FrontStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]FrontStatus, 0, len(p.fronts))
+	for i, f := range p.fronts {
+		st := f.status
+		st.Active = i == p.active
+		st.Mode = f.transport.Mode()
+		st.Metrics = f.transport.Metrics()
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// Start запускает фоновую проверку фронтов и обновление удаленного списка
+func (p *Pool) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := p.Refresh(ctx); err != nil {
+				log.Printf("Front list refresh failed: %v", err)
+			}
+			p.CheckAll(ctx)
+			cancel()
+
+			select {
+			case <-p.stopChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop останавливает фоновые проверки
+func (p *Pool) Stop() {
+	close(p.stopChan)
+}
+
+// CheckAll проверяет доступность всех фронтов
+func (p *Pool) CheckAll(ctx context.Context) {
+	p.mu.Lock()
+	fronts := make([]*front, len(p.fronts))
+	copy(fronts, p.fronts)
+	p.mu.Unlock()
+
+	for _, f := range fronts {
+		p.mu.Lock()
+		skip := f.status.Blocked && time.Since(f.status.BlockedAt) < blockedRetryAfter ||
+			f.status.Avoided && time.Since(f.status.CheckedAt) < avoidedRetryAfter
+		p.mu.Unlock()
+		if skip {
+			continue
+		}
+
+		start := time.Now()
+		err := f.transport.Probe(ctx)
+		latency := time.Since(start)
+
+		p.mu.Lock()
+		f.status.CheckedAt = time.Now()
+		f.status.LatencyMs = latency.Milliseconds()
+		if err == nil {
+			if f.status.Blocked || f.status.Failures > 0 {
+				p.emitLocked(f.status.Domain, EventRecovered, nil)
+			}
+			f.status.Healthy = true
+			f.status.Blocked = false
+			f.status.Failures = 0
+		} else {
+			f.status.Healthy = false
+			f.status.Failures++
+			if isBlocked(err) {
+				f.status.Blocked = true
+				f.status.BlockedAt = time.Now()
+			}
+			p.emitLocked(f.status.Domain, ClassifyError(err), err)
+		}
+		if idx := p.indexLocked(f.status.Domain); idx == p.active && f.status.Blocked {
+			p.rotateLocked()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Refresh загружает подписанный удаленный список фронтов и добавляет новые домены в пул
+func (p *Pool) Refresh(ctx context.Context) error {
+	p.mu.Lock()
+	listURL, listKey := p.listURL, p.listKey
+	p.mu.Unlock()
+
+	if listURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create front list request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch front list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("front list returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read front list: %w", err)
+	}
+
+	var signed SignedFrontList
+	if err := json.Unmarshal(body, &signed); err != nil {
+		return fmt.Errorf("invalid front list: %w", err)
+	}
+
+	payload, err := VerifyFrontList(listKey, &signed)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if payload.IssuedAt <= p.listIssuedAt {
+		return nil // Не откатываемся на более старый список
+	}
+	p.listIssuedAt = payload.IssuedAt
+
+	for _, domain := range payload.Fronts {
+		p.addLocked(domain)
+	}
+	log.Printf("Front list refreshed: %d fronts in pool", len(p.fronts))
+	return nil
+}
+
+// VerifyFrontList проверяет подпись удаленного списка и возвращает его содержимое
+func VerifyFrontList(publicKey ed25519.PublicKey, signed *SignedFrontList) (*FrontList, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("front list public key is not configured")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid front list payload encoding: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid front list signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, sig) {
+		return nil, fmt.Errorf("front list signature verification failed")
+	}
+
+	var list FrontList
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, fmt.Errorf("invalid front list payload: %w", err)
+	}
+	return &list, nil
+}
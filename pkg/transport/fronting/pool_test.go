@@ -0,0 +1,87 @@
+package fronting
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestPoolRotatesOnBlock(t *testing.T) {
+	pool := NewPool("hidden-service.com", []string{"front-a.example", "front-b.example", "front-c.example"})
+
+	if got := pool.Active().FrontDomain; got != "front-a.example" {
+		t.Fatalf("Expected front-a.example to be active, got %s", got)
+	}
+
+	pool.MarkBlocked("front-a.example")
+	if got := pool.Active().FrontDomain; got != "front-b.example" {
+		t.Errorf("Expected rotation to front-b.example, got %s", got)
+	}
+
+	// Заблокированный фронт уходит в конец очереди попыток
+	transports := pool.Transports()
+	if last := transports[len(transports)-1].FrontDomain; last != "front-a.example" {
+		t.Errorf("Expected blocked front last, got %s", last)
+	}
+
+	pool.MarkSuccess("front-c.example")
+	if got := pool.Active().FrontDomain; got != "front-c.example" {
+		t.Errorf("Expected front-c.example to become active, got %s", got)
+	}
+}
+
+func TestPoolAvoidsHistoricallyBlocked(t *testing.T) {
+	pool := NewPool("hidden-service.com", []string{"front-a.example", "front-b.example", "front-c.example"})
+
+	events := make(chan FrontEvent, 1)
+	pool.OnEvent(func(e FrontEvent) { events <- e })
+
+	pool.SetAvoided([]string{"front-a.example", "front-b.example"})
+	if got := pool.Active().FrontDomain; got != "front-c.example" {
+		t.Fatalf("Expected front-c.example to be active, got %s", got)
+	}
+
+	// Избегаемые фронты остаются в конце очереди на случай, если остальные недоступны
+	transports := pool.Transports()
+	if len(transports) != 3 || transports[1].FrontDomain != "front-a.example" {
+		t.Errorf("Unexpected order: %s, %s", transports[0].FrontDomain, transports[1].FrontDomain)
+	}
+
+	pool.MarkBlocked("front-c.example")
+	if got := pool.Active().FrontDomain; got != "front-a.example" {
+		t.Errorf("Expected fallback to avoided front-a.example, got %s", got)
+	}
+
+	select {
+	case e := <-events:
+		if e.Domain != "front-c.example" || e.Class != EventBlocked {
+			t.Errorf("Unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected blocked event")
+	}
+}
+
+func TestVerifyFrontList(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+
+	payload := []byte(`{"fronts":["new-front.example"],"issued_at":100}`)
+	signed := &SignedFrontList{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload)),
+	}
+
+	list, err := VerifyFrontList(pub, signed)
+	if err != nil {
+		t.Fatalf("VerifyFrontList failed: %v", err)
+	}
+	if len(list.Fronts) != 1 || list.Fronts[0] != "new-front.example" {
+		t.Errorf("Unexpected fronts: %v", list.Fronts)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyFrontList(otherPub, signed); err == nil {
+		t.Error("Expected verification failure with wrong key")
+	}
+}
@@ -0,0 +1,146 @@
+package fronting
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// Протоколы HTTP поверх фронта
+const (
+	ProtocolHTTP1 = "http/1.1"
+	ProtocolHTTP2 = "h2"
+	ProtocolHTTP3 = "h3"
+)
+
+// HTTP3Factory создает RoundTripper для HTTP/3 (QUIC) к фронту с указанной TLS конфигурацией.
+// Реализация HTTP/3 не входит в стандартную библиотеку, поэтому подключается извне через RegisterHTTP3.
+type HTTP3Factory func(tlsConfig *tls.Config) http.RoundTripper
+
+var (
+	http3Mu      sync.Mutex
+	http3Factory HTTP3Factory
+)
+
+// RegisterHTTP3 регистрирует реализацию HTTP/3. Без нее ProtocolHTTP3 откатывается на HTTP/2.
+func RegisterHTTP3(factory HTTP3Factory) {
+	http3Mu.Lock()
+	defer http3Mu.Unlock()
+	http3Factory = factory
+}
+
+// ConnMetrics - метрики повторного использования соединений с фронтом
+type ConnMetrics struct {
+	Requests       int64  `json:"requests"`
+	NewConns       int64  `json:"new_conns"`
+	ReusedConns    int64  `json:"reused_conns"`
+	StreamTimeouts int64  `json:"stream_timeouts"`
+	Retries        int64  `json:"retries"`
+	Protocol       string `json:"protocol"` // протокол последнего ответа (h2, http/1.1, h3)
+}
+
+// SetProtocol переключает протокол HTTP поверх фронта
+func (t *Transport) SetProtocol(protocol string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch protocol {
+	case ProtocolHTTP2, "":
+		t.httpTransport.ForceAttemptHTTP2 = true
+		t.httpTransport.TLSClientConfig.NextProtos = []string{"h2", "http/1.1"}
+		t.setRoundTripperLocked(t.httpTransport)
+		t.Protocol = ProtocolHTTP2
+	case ProtocolHTTP1:
+		t.httpTransport.ForceAttemptHTTP2 = false
+		t.httpTransport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		t.setRoundTripperLocked(t.httpTransport)
+		t.Protocol = ProtocolHTTP1
+	case ProtocolHTTP3:
+		http3Mu.Lock()
+		factory := http3Factory
+		http3Mu.Unlock()
+
+		if factory == nil {
+			log.Printf("HTTP/3 is not available for %s, using HTTP/2", t.FrontDomain)
+			t.Protocol = ProtocolHTTP2
+			return nil
+		}
+
+		tlsConfig := t.httpTransport.TLSClientConfig.Clone()
+		tlsConfig.NextProtos = []string{"h3"}
+		t.setRoundTripperLocked(factory(tlsConfig))
+		t.Protocol = ProtocolHTTP3
+	default:
+		return fmt.Errorf("unknown fronting protocol: %s", protocol)
+	}
+
+	// Соединения, открытые со старыми настройками, больше не используем
+	t.httpTransport.CloseIdleConnections()
+	return nil
+}
+
+func (t *Transport) setRoundTripperLocked(rt http.RoundTripper) {
+	t.client.Transport = rt
+	t.pollClient.Transport = rt
+}
+
+// Metrics возвращает метрики соединений
+func (t *Transport) Metrics() ConnMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.metrics
+}
+
+// do выполняет запрос, собирая метрики повторного использования соединения
+func (t *Transport) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if info.Reused {
+				t.metrics.ReusedConns++
+			} else {
+				t.metrics.NewConns++
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	t.mu.Lock()
+	t.metrics.Requests++
+	t.mu.Unlock()
+
+	resp, err := client.Do(req)
+	if err == nil {
+		t.mu.Lock()
+		t.metrics.Protocol = negotiatedProtocol(resp)
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (t *Transport) recordStreamTimeout() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metrics.StreamTimeouts++
+}
+
+func (t *Transport) recordRetry() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metrics.Retries++
+}
+
+func negotiatedProtocol(resp *http.Response) string {
+	switch resp.ProtoMajor {
+	case 3:
+		return ProtocolHTTP3
+	case 2:
+		return ProtocolHTTP2
+	default:
+		return ProtocolHTTP1
+	}
+}
@@ -0,0 +1,129 @@
+package fronting
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Option настраивает транспорт при создании
+type Option func(*Transport)
+
+// WithProxy направляет соединения с фронтом через вышестоящий прокси.
+// Поддерживаются схемы http, https (TLS до прокси) и socks5/socks5h;
+// учетные данные берутся из URL (user:password@host).
+func WithProxy(proxyURL *url.URL) Option {
+	return func(t *Transport) {
+		t.proxyURL = proxyURL
+	}
+}
+
+// ParseProxyURL разбирает и проверяет URL прокси
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// dialViaProxy открывает TCP туннель к addr через прокси.
+// Имя хоста передается прокси как есть, чтобы DNS запросы не уходили мимо него.
+func (t *Transport) dialViaProxy(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	switch t.proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if t.proxyURL.User != nil {
+			password, _ := t.proxyURL.User.Password()
+			auth = &proxy.Auth{User: t.proxyURL.User.Username(), Password: password}
+		}
+
+		socks, err := proxy.SOCKS5("tcp", t.proxyURL.Host, auth, dialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+		}
+		conn, err := socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("SOCKS5 proxy %s failed to connect to %s: %w", t.proxyURL.Host, addr, err)
+		}
+		return conn, nil
+
+	default:
+		conn, err := dialer.DialContext(ctx, "tcp", t.proxyURL.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to proxy %s: %w", t.proxyURL.Host, err)
+		}
+
+		if t.proxyURL.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: t.proxyURL.Hostname()})
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", t.proxyURL.Host, err)
+			}
+			conn = tlsConn
+		}
+
+		if err := httpConnect(ctx, conn, addr, t.proxyURL.User); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// httpConnect устанавливает туннель методом CONNECT через HTTP прокси
+func httpConnect(ctx context.Context, conn net.Conn, addr string, user *url.Userinfo) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("failed to send CONNECT to proxy: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+	return nil
+}
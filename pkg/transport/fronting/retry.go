@@ -0,0 +1,83 @@
+package fronting
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+var (
+	// ErrTimeout - поток к скрытому сервису не уложился в StreamTimeout (повторяемая ошибка)
+	ErrTimeout = errors.New("stream timeout")
+
+	// ErrServerError - CDN или скрытый сервис вернул 5xx (повторяемая ошибка)
+	ErrServerError = errors.New("server error")
+)
+
+// RetryPolicy задает повторы запроса через тот же фронт перед переключением на следующий.
+// Повторяются только временные ошибки (таймауты, 5xx); блокировка (403) - сразу.
+type RetryPolicy struct {
+	MaxAttempts int           // всего попыток через фронт (1 - без повторов)
+	BaseDelay   time.Duration // задержка перед первым повтором
+	MaxDelay    time.Duration // верхняя граница задержки
+}
+
+// DefaultRetryPolicy - 3 попытки с задержкой 200мс, 400мс (± джиттер)
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+	}
+}
+
+// WithRetry задает политику повторов
+func WithRetry(policy RetryPolicy) Option {
+	return func(t *Transport) {
+		t.Retry = policy
+	}
+}
+
+// backoff возвращает задержку перед повтором номер attempt (с 1): экспоненциальный рост
+// с равномерным джиттером в диапазоне [d/2, d], чтобы клиенты не повторяли синхронно.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// isRetryable сообщает, имеет ли смысл повторить запрос через тот же фронт
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrBlocked) {
+		return false
+	}
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrServerError)
+}
+
+// withRetry выполняет op с повторами по политике t.Retry
+func (t *Transport) withRetry(ctx context.Context, op func() ([]byte, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		reply, err := op()
+		if err == nil || attempt >= t.Retry.MaxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return reply, err
+		}
+
+		delay := t.Retry.backoff(attempt)
+		log.Printf("Fronting via %s failed: %v (attempt %d/%d, retry in %s)", t.FrontDomain, err, attempt, t.Retry.MaxAttempts, delay)
+		t.recordRetry()
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -13,32 +13,19 @@ import (
 
 // TransportManager управляет переключением между разными транспортами
 type TransportManager struct {
-	transports   []transport.Transport
-	currentIndex int
-	mu           sync.Mutex
-}
-
-func New() *TransportManager {
-	// Создаем транспорты в порядке приоритета с разными CDN доменами для retry:
-
-	// Domain Fronting транспорты с разными CDN для retry
-	frontingTransports := []*fronting.Transport{
-		fronting.New(
-			"ajax.googleapis.com",     // Google CDN
-			"secret-chat.appspot.com", // Скрытый сервис
-		),
-		fronting.New(
-			"cdn.cloudflare.com",      // Cloudflare CDN
-			"secret-chat.appspot.com", // Скрытый сервис
-		),
-		fronting.New(
-			"d3a2p9q8.stackpathcdn.com", // StackPath CDN
-			"secret-chat.appspot.com",   // Скрытый сервис
-		),
-		fronting.New(
-			"assets.buymeacoffee.com", // BuyMeACoffee CDN
-			"secret-chat.appspot.com", // Скрытый сервис
-		),
+	fronts  *fronting.Pool
+	mesh    transport.Transport
+	current transport.Transport
+	policy  *transport.Policy
+	onSend  func(name string, ok bool)
+	mu      sync.Mutex
+}
+
+// New создает менеджер, использующий пул фронт-доменов и Mesh как последний резерв.
+// Если pool равен nil, используется пул со встроенным списком фронтов.
+func New(pool *fronting.Pool) *TransportManager {
+	if pool == nil {
+		pool = fronting.NewPool("secret-chat.appspot.com", nil)
 	}
 
 	// Mesh транспорт как последний резерв
@@ -48,16 +35,75 @@ func New() *TransportManager {
 		"192.168.1.102:8080",
 	})
 
-	// Конвертируем в интерфейс Transport
-	transports := make([]transport.Transport, len(frontingTransports)+1)
-	for i, ft := range frontingTransports {
-		transports[i] = ft
+	return &TransportManager{
+		fronts: pool,
+		mesh:   meshTransport,
 	}
-	transports[len(frontingTransports)] = meshTransport
+}
 
-	return &TransportManager{
-		transports: transports,
+// transportsLocked возвращает транспорты в порядке приоритета: фронты из пула, затем Mesh
+func (m *TransportManager) transportsLocked() []transport.Transport {
+	fronts := m.fronts.Transports()
+	transports := make([]transport.Transport, 0, len(fronts)+1)
+	for _, ft := range fronts {
+		transports = append(transports, ft)
+	}
+	return append(transports, m.mesh)
+}
+
+// SetPolicy задает, какие транспорты разрешены для звонков и сообщений
+func (m *TransportManager) SetPolicy(policy *transport.Policy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+// OnSend задает обработчик результата каждой попытки отправки через транспорт (для телеметрии).
+// Вызывается под блокировкой менеджера и не должен ждать.
+func (m *TransportManager) OnSend(handler func(name string, ok bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSend = handler
+}
+
+// AvailableFor проверяет, есть ли доступный транспорт, разрешенный политикой для вида трафика
+// (transport.TrafficCalls или transport.TrafficMessages)
+func (m *TransportManager) AvailableFor(traffic string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.transportsLocked() {
+		if !m.policy.Allows(traffic, t.Name()) || !t.IsAvailable() {
+			continue
+		}
+		if _, ok := t.(*fronting.Transport); ok && !m.fronts.Usable() {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Mesh возвращает Mesh транспорт (для просмотра топологии, изученной через PEX)
+func (m *TransportManager) Mesh() *mesh.MeshTransport {
+	meshTransport, _ := m.mesh.(*mesh.MeshTransport)
+	return meshTransport
+}
+
+// SetMesh заменяет Mesh транспорт (например, транспортом с пирами из discovery)
+func (m *TransportManager) SetMesh(meshTransport *mesh.MeshTransport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current == m.mesh {
+		m.current = nil
 	}
+	m.mesh = meshTransport
+}
+
+// FrontPool возвращает пул фронт-доменов
+func (m *TransportManager) FrontPool() *fronting.Pool {
+	return m.fronts
 }
 
 // Name возвращает имя менеджера
@@ -71,7 +117,7 @@ func (m *TransportManager) Connect(ctx context.Context) error {
 	defer m.mu.Unlock()
 
 	// Пробуем подключиться к текущему или всем
-	for _, t := range m.transports {
+	for _, t := range m.transportsLocked() {
 		if err := t.Connect(ctx); err != nil {
 			log.Printf("Предупреждение: не удалось подключиться к %s: %v", t.Name(), err)
 		}
@@ -84,7 +130,7 @@ func (m *TransportManager) IsAvailable() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, t := range m.transports {
+	for _, t := range m.transportsLocked() {
 		if t.IsAvailable() {
 			return true
 		}
@@ -95,15 +141,25 @@ func (m *TransportManager) IsAvailable() bool {
 // Send пытается отправить сообщение через доступные транспорты
 // Автоматически переключается при ошибках
 func (m *TransportManager) Send(ctx context.Context, data []byte) error {
+	_, err := m.Exchange(ctx, data)
+	return err
+}
+
+// Exchange отправляет сообщение через первый сработавший транспорт и возвращает
+// ответ скрытого сервиса. Переключение между транспортами такое же, как в Send.
+func (m *TransportManager) Exchange(ctx context.Context, data []byte) ([]byte, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	// Пробуем все транспорты по порядку приоритета
-	for i, t := range m.transports {
+	for _, t := range m.transportsLocked() {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
+			if !m.policy.Allows(transport.TrafficMessages, t.Name()) {
+				continue
+			}
 			if !t.IsAvailable() {
 				log.Printf("Транспорт %s недоступен, пропускаем", t.Name())
 				continue
@@ -111,26 +167,38 @@ func (m *TransportManager) Send(ctx context.Context, data []byte) error {
 
 			log.Printf("Попытка отправки через %s...", t.Name())
 
-			err := t.Send(ctx, data)
+			reply, err := t.Exchange(ctx, data)
+			if m.onSend != nil {
+				m.onSend(t.Name(), err == nil)
+			}
 			if err == nil {
 				// Успех! Запоминаем этот транспорт для следующих отправок
-				m.currentIndex = i
+				m.current = t
+				if ft, ok := t.(*fronting.Transport); ok {
+					m.fronts.MarkSuccess(ft.FrontDomain)
+				}
 				log.Printf("✓ Сообщение отправлено через %s", t.Name())
-				return nil
+				return reply, nil
 			}
 
 			log.Printf("✗ Ошибка в транспорте %s: %v", t.Name(), err)
 
-			// Если это Domain Fronting и ошибка 502 (блокировка CDN),
-			// сразу переключаемся на следующий транспорт
-			if t.Name() == "fronting" && isBlockingError(err) {
-				log.Printf("Обнаружена блокировка CDN, переключаемся на Mesh...")
+			// Каждый сбой фронта попадает в историю блокировок
+			if ft, ok := t.(*fronting.Transport); ok {
+				m.fronts.RecordFailure(ft.FrontDomain, err)
+			}
+
+			// Если это Domain Fronting и ошибка похожа на блокировку CDN,
+			// помечаем фронт заблокированным - пул переключит активный фронт
+			if ft, ok := t.(*fronting.Transport); ok && isBlockingError(err) {
+				log.Printf("Обнаружена блокировка CDN %s, переключаем фронт...", ft.FrontDomain)
+				m.fronts.MarkBlocked(ft.FrontDomain)
 				continue
 			}
 		}
 	}
 
-	return fmt.Errorf("все транспорты недоступны")
+	return nil, fmt.Errorf("все транспорты недоступны")
 }
 
 // isBlockingError проверяет, является ли ошибка блокировкой CDN
@@ -152,11 +220,13 @@ func (m *TransportManager) GetCurrentTransport() transport.Transport {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if len(m.transports) == 0 {
-		return nil
+	if m.current != nil {
+		return m.current
 	}
-
-	return m.transports[m.currentIndex]
+	if active := m.fronts.Active(); active != nil {
+		return active
+	}
+	return m.mesh
 }
 
 // SwitchTo принудительно переключает на указанный транспорт
@@ -164,9 +234,9 @@ func (m *TransportManager) SwitchTo(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for i, t := range m.transports {
+	for _, t := range m.transportsLocked() {
 		if t.Name() == name {
-			m.currentIndex = i
+			m.current = t
 			log.Printf("Принудительно переключились на %s", name)
 			return nil
 		}
@@ -181,7 +251,7 @@ func (m *TransportManager) GetStatus() map[string]string {
 	defer m.mu.Unlock()
 
 	status := make(map[string]string)
-	for _, t := range m.transports {
+	for _, t := range m.transportsLocked() {
 		status[t.Name()] = "available"
 		if !t.IsAvailable() {
 			status[t.Name()] = "unavailable"
@@ -190,3 +260,16 @@ func (m *TransportManager) GetStatus() map[string]string {
 
 	return status
 }
+
+// GetFrontingModes возвращает режим TLS (ech/classic) для каждого фронт-домена
+func (m *TransportManager) GetFrontingModes() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	modes := make(map[string]string)
+	for _, ft := range m.fronts.Transports() {
+		modes[ft.FrontDomain] = ft.Mode()
+	}
+
+	return modes
+}
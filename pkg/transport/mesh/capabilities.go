@@ -0,0 +1,116 @@
+package mesh
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+)
+
+// Возможности узла передаются в анонсах PEX и mDNS, чтобы не подключаться вслепую ко всем
+// найденным узлам: узлы с несовместимой версией протокола не используются, QUIC пробуется
+// только с узлами, которые его поддерживают, а при отправке сначала выбираются узлы,
+// согласные ретранслировать чужие сообщения.
+const (
+	// ProtocolVersion - версия протокола mesh этого узла
+	ProtocolVersion = 1
+	// MinProtocolVersion - минимальная версия протокола, с которой узел совместим
+	MinProtocolVersion = 1
+)
+
+// Транспорты соединений между узлами
+const (
+	LinkTCP  = "tcp"
+	LinkQUIC = "quic"
+)
+
+// Capabilities - возможности узла mesh сети
+type Capabilities struct {
+	Version    int      `json:"version"`
+	MinVersion int      `json:"min_version"`
+	Transports []string `json:"transports"`
+	Relay      bool     `json:"relay"`                // узел ретранслирует чужие сообщения
+	RelayNode  bool     `json:"relay_node,omitempty"` // узел с публичным адресом пересылает запросы к пирам (см. relaynode.go)
+}
+
+// legacyCapabilities - возможности узлов, не сообщающих о них (до появления обмена возможностями)
+// или еще не приславших анонс
+var legacyCapabilities = Capabilities{Version: 1, MinVersion: 1, Transports: []string{LinkTCP}, Relay: true}
+
+// Compatible сообщает, может ли этот узел работать с узлом c
+func (c Capabilities) Compatible() bool {
+	return c.Version >= MinProtocolVersion && c.MinVersion <= ProtocolVersion
+}
+
+// Supports сообщает, поддерживает ли узел транспорт link
+func (c Capabilities) Supports(link string) bool {
+	return slices.Contains(c.Transports, link)
+}
+
+// Fingerprint возвращает отпечаток ключа узла (первые 8 байт SHA-256 ключа в hex)
+// для сверки узлов пользователями; пустая строка - некорректный NodeID
+func Fingerprint(nodeID string) string {
+	key, err := base64.StdEncoding.DecodeString(nodeID)
+	if err != nil || len(key) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Capabilities возвращает текущие возможности узла
+func (m *MeshTransport) Capabilities() Capabilities {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.capabilitiesLocked()
+}
+
+func (m *MeshTransport) capabilitiesLocked() Capabilities {
+	caps := Capabilities{
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		Transports: []string{LinkTCP},
+		Relay:      !m.resources.BatterySaver,
+		RelayNode:  m.relayNodeLocked(),
+	}
+	if m.quic != nil {
+		caps.Transports = append(caps.Transports, LinkQUIC)
+	}
+	return caps
+}
+
+// peerCapsLocked возвращает возможности узла с адресом addr, известные из анонсов PEX
+func (m *MeshTransport) peerCapsLocked(addr string) (Capabilities, bool) {
+	for _, info := range m.learned {
+		if info.Caps != nil && (info.Addr == addr || info.PublicAddr == addr) {
+			return *info.Caps, true
+		}
+	}
+	return legacyCapabilities, false
+}
+
+// peerSupports сообщает, стоит ли пробовать с узлом addr транспорт link. С узлами, чьи
+// возможности еще неизвестны, транспорт пробуется (при ошибке используется TCP).
+func (m *MeshTransport) peerSupports(addr, link string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	caps, known := m.peerCapsLocked(addr)
+	return !known || caps.Supports(link)
+}
+
+// relayFirst упорядочивает пиры для отправки: сначала согласные ретранслировать
+func (m *MeshTransport) relayFirst(peers []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ordered := make([]string, 0, len(peers))
+	var reluctant []string
+	for _, peer := range peers {
+		if caps, _ := m.peerCapsLocked(peer); caps.Relay {
+			ordered = append(ordered, peer)
+		} else {
+			reluctant = append(reluctant, peer)
+		}
+	}
+	return append(ordered, reluctant...)
+}
@@ -0,0 +1,468 @@
+package mesh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Режим DTN (delay-tolerant networking): при полном отсутствии связности сообщения
+// переносятся устройствами. Каждое исходящее сообщение дополнительно сохраняется как
+// бандл, и при любом контакте с пиром узлы обмениваются недостающими бандлами
+// (эпидемическая маршрутизация). Бандлы зашифрованы ключом сети: узлы без ключа
+// переносят их, не имея доступа к содержимому, узлы с ключом доставляют.
+//
+// Контакт: отправитель передает в кадре frameDTNSummary список ID своих бандлов, пир
+// отвечает ID, которых у него нет, после чего отправитель передает их пачками в кадрах
+// frameBundle. Каждый узел проводит такие обмены сам, поэтому бандлы расходятся в обе стороны.
+const (
+	// DefaultBundleLifetime - срок жизни бандла по умолчанию
+	DefaultBundleLifetime = 72 * time.Hour
+	// DefaultDTNMaxBundles и DefaultDTNMaxBytes - квота хранилища бандлов по умолчанию
+	DefaultDTNMaxBundles = 1000
+	DefaultDTNMaxBytes   = 64 << 20
+
+	dtnInterval          = 15 * time.Second // период обмена бандлами с пирами в зоне досягаемости
+	maxBundlesPerContact = 64               // сколько бандлов передается пиру за один контакт
+	maxBundleHops        = 32               // бандлы, прошедшие больше узлов, отбрасываются
+	dtnIDSize            = 2 * messageIDSize
+)
+
+// DTNOptions - настройки режима DTN
+type DTNOptions struct {
+	Key        []byte        // ключ сети (32 байта); без ключа узел только переносит бандлы
+	Lifetime   time.Duration // срок жизни исходящих бандлов
+	MaxBundles int           // максимум хранимых бандлов
+	MaxBytes   int64         // максимальный суммарный размер бандлов
+	StorePath  string        // файл для хранения бандлов между перезапусками (пусто - в памяти)
+}
+
+// Bundle - зашифрованное сообщение, переносимое узлами в режиме DTN
+type Bundle struct {
+	ID      string `json:"id"`      // ID конверта сообщения (hex)
+	Expires int64  `json:"expires"` // Unix время в миллисекундах
+	Hops    uint8  `json:"hops"`    // число узлов, через которые прошел бандл
+	Sealed  []byte `json:"sealed"`  // nonce | AES-256-GCM(данные), ID - дополнительные данные
+}
+
+type dtnSummary struct {
+	IDs  []string `json:"ids,omitempty"`
+	Want []string `json:"want,omitempty"`
+}
+
+// dtnStore хранит переносимые бандлы с квотой и сроком жизни
+type dtnStore struct {
+	opts DTNOptions
+	aead cipher.AEAD // nil - ключ сети не задан
+
+	mu        sync.Mutex
+	bundles   map[string]*Bundle
+	size      int64
+	known     map[string]int64 // ID принятых бандлов до истечения срока (в т.ч. вытесненных)
+	delivered map[string]int64 // ID доставленных сообщений до истечения срока
+	dirty     bool
+}
+
+type dtnState struct {
+	Bundles   []*Bundle        `json:"bundles"`
+	Known     map[string]int64 `json:"known"`
+	Delivered map[string]int64 `json:"delivered"`
+}
+
+// ParseDTNKey разбирает ключ сети DTN: 32 байта в hex
+func ParseDTNKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("DTN key must be 32 bytes in hex")
+	}
+	return key, nil
+}
+
+func newDTNStore(opts DTNOptions) (*dtnStore, error) {
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = DefaultBundleLifetime
+	}
+	if opts.MaxBundles <= 0 {
+		opts.MaxBundles = DefaultDTNMaxBundles
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultDTNMaxBytes
+	}
+
+	s := &dtnStore{
+		opts:      opts,
+		bundles:   make(map[string]*Bundle),
+		known:     make(map[string]int64),
+		delivered: make(map[string]int64),
+	}
+	if opts.Key != nil {
+		block, err := aes.NewCipher(opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTN key: %w", err)
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// seal шифрует данные конверта в бандл
+func (s *dtnStore) seal(env *envelope, now time.Time) (*Bundle, error) {
+	if s.aead == nil {
+		return nil, fmt.Errorf("DTN key is not configured")
+	}
+	b := &Bundle{
+		ID:      hex.EncodeToString(env.ID[:]),
+		Expires: now.Add(s.opts.Lifetime).UnixMilli(),
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	b.Sealed = s.aead.Seal(nonce, nonce, env.Data, []byte(b.ID))
+	return b, nil
+}
+
+// open расшифровывает бандл; ok=false - бандл зашифрован другим ключом или ключа нет
+func (s *dtnStore) open(b *Bundle) (data []byte, ok bool) {
+	if s.aead == nil || len(b.Sealed) < s.aead.NonceSize() {
+		return nil, false
+	}
+	nonce, sealed := b.Sealed[:s.aead.NonceSize()], b.Sealed[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, sealed, []byte(b.ID))
+	return data, err == nil
+}
+
+// add сохраняет бандл, вытесняя бандлы с ближайшим сроком истечения при превышении квоты.
+// Возвращает false, если бандл уже встречался, истек или не помещается.
+func (s *dtnStore) add(b *Bundle, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(now)
+	if len(b.ID) != dtnIDSize || b.Expires <= now.UnixMilli() || b.Hops > maxBundleHops {
+		return false
+	}
+	if _, exists := s.known[b.ID]; exists {
+		return false
+	}
+	size := int64(len(b.Sealed))
+	if size > s.opts.MaxBytes {
+		return false
+	}
+
+	for len(s.bundles) >= s.opts.MaxBundles || s.size+size > s.opts.MaxBytes {
+		victim := s.soonestLocked()
+		if victim == nil || victim.Expires >= b.Expires {
+			return false
+		}
+		delete(s.bundles, victim.ID)
+		s.size -= int64(len(victim.Sealed))
+	}
+
+	s.bundles[b.ID] = b
+	s.size += size
+	s.known[b.ID] = b.Expires
+	s.dirty = true
+	return true
+}
+
+// soonestLocked возвращает бандл с ближайшим сроком истечения
+func (s *dtnStore) soonestLocked() *Bundle {
+	var soonest *Bundle
+	for _, b := range s.bundles {
+		if soonest == nil || b.Expires < soonest.Expires {
+			soonest = b
+		}
+	}
+	return soonest
+}
+
+func (s *dtnStore) expireLocked(now time.Time) {
+	ms := now.UnixMilli()
+	for id, b := range s.bundles {
+		if b.Expires <= ms {
+			delete(s.bundles, id)
+			s.size -= int64(len(b.Sealed))
+			s.dirty = true
+		}
+	}
+	for _, ids := range []map[string]int64{s.known, s.delivered} {
+		for id, expires := range ids {
+			if expires <= ms {
+				delete(ids, id)
+				s.dirty = true
+			}
+		}
+	}
+}
+
+// markDelivered отмечает сообщение доставленным; возвращает false при повторной доставке
+func (s *dtnStore) markDelivered(id string, expires int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.delivered[id]; exists {
+		return false
+	}
+	s.delivered[id] = expires
+	s.dirty = true
+	return true
+}
+
+// ids возвращает ID хранимых бандлов
+func (s *dtnStore) ids(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(now)
+	ids := make([]string, 0, len(s.bundles))
+	for id := range s.bundles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// missing возвращает ID из списка пира, которые узел еще не встречал
+func (s *dtnStore) missing(ids []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var want []string
+	for _, id := range ids {
+		if _, exists := s.known[id]; !exists && len(want) < maxBundlesPerContact {
+			want = append(want, id)
+		}
+	}
+	return want
+}
+
+// get возвращает бандлы по ID с увеличенным числом переходов
+func (s *dtnStore) get(ids []string) []*Bundle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bundles []*Bundle
+	for _, id := range ids {
+		if b, exists := s.bundles[id]; exists && b.Hops < maxBundleHops {
+			cp := *b
+			cp.Hops++
+			bundles = append(bundles, &cp)
+		}
+	}
+	return bundles
+}
+
+func (s *dtnStore) load() error {
+	if s.opts.StorePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.opts.StorePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read DTN store: %w", err)
+	}
+
+	var state dtnState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse DTN store: %w", err)
+	}
+	for id, expires := range state.Delivered {
+		s.delivered[id] = expires
+	}
+	for id, expires := range state.Known {
+		s.known[id] = expires
+	}
+	for _, b := range state.Bundles {
+		delete(s.known, b.ID)
+		s.add(b, time.Now())
+	}
+	s.dirty = false
+	return nil
+}
+
+// save записывает бандлы в файл, если они изменились
+func (s *dtnStore) save() error {
+	s.mu.Lock()
+	if s.opts.StorePath == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	state := dtnState{Known: s.known, Delivered: s.delivered}
+	for _, b := range s.bundles {
+		state.Bundles = append(state.Bundles, b)
+	}
+	data, err := json.Marshal(state)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode DTN store: %w", err)
+	}
+
+	tmp := s.opts.StorePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write DTN store: %w", err)
+	}
+	return os.Rename(tmp, s.opts.StorePath)
+}
+
+// EnableDTN включает режим DTN; вызывается до Connect
+func (m *MeshTransport) EnableDTN(opts DTNOptions) error {
+	store, err := newDTNStore(opts)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dtn = store
+	return nil
+}
+
+func (m *MeshTransport) dtnStore() *dtnStore {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dtn
+}
+
+// storeBundle сохраняет исходящее сообщение как бандл для переноса
+func (m *MeshTransport) storeBundle(env *envelope) error {
+	store := m.dtnStore()
+	if store == nil {
+		return fmt.Errorf("DTN mode is disabled")
+	}
+	b, err := store.seal(env, time.Now())
+	if err != nil {
+		return err
+	}
+	store.markDelivered(b.ID, b.Expires)
+	if !store.add(b, time.Now()) {
+		return fmt.Errorf("bundle not stored: duplicate or DTN quota exceeded")
+	}
+	return nil
+}
+
+// runDTN периодически обменивается бандлами с пирами в зоне досягаемости
+func (m *MeshTransport) runDTN() {
+	ticker := time.NewTicker(dtnInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.dtnRound()
+	}
+}
+
+func (m *MeshTransport) dtnRound() {
+	store := m.dtnStore()
+	if store == nil {
+		return
+	}
+	for _, peer := range m.GetPeers() {
+		if err := m.exchangeBundles(store, peer); err != nil {
+			log.Printf("Mesh DTN: обмен бандлами с %s не удался: %v", peer, err)
+		}
+	}
+	if err := store.save(); err != nil {
+		log.Printf("Mesh DTN: %v", err)
+	}
+}
+
+// exchangeBundles передает пиру бандлы, которых у него нет
+func (m *MeshTransport) exchangeBundles(store *dtnStore, peer string) error {
+	ids := store.ids(time.Now())
+	if len(ids) == 0 {
+		return nil
+	}
+
+	summary, _ := json.Marshal(dtnSummary{IDs: ids})
+	reply, err := m.roundTripPeer(peer, frameDTNSummary, summary)
+	if err != nil {
+		return err
+	}
+	var answer dtnSummary
+	if reply.Type != frameDTNSummary || json.Unmarshal(reply.Payload, &answer) != nil {
+		return fmt.Errorf("peer does not support DTN")
+	}
+
+	// Бандлы передаются пачками, не превышающими размер кадра
+	var batch []*Bundle
+	var batchSize int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		data, _ := json.Marshal(batch)
+		batch, batchSize = nil, 0
+		_, err := m.roundTripPeer(peer, frameBundle, data)
+		return err
+	}
+	for _, b := range store.get(answer.Want) {
+		size := len(b.Sealed)*4/3 + 128
+		if batchSize+size > maxFrameSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, b)
+		batchSize += size
+	}
+	return flush()
+}
+
+// handleDTNSummary отвечает на список бандлов пира списком недостающих
+func (m *MeshTransport) handleDTNSummary(data []byte) ([]byte, error) {
+	store := m.dtnStore()
+	if store == nil {
+		return nil, fmt.Errorf("DTN mode is disabled")
+	}
+	var summary dtnSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("invalid DTN summary: %w", err)
+	}
+	reply, _ := json.Marshal(dtnSummary{Want: store.missing(summary.IDs)})
+	return reply, nil
+}
+
+// handleBundles сохраняет полученные бандлы и доставляет те, что удается расшифровать
+func (m *MeshTransport) handleBundles(data []byte) error {
+	store := m.dtnStore()
+	if store == nil {
+		return fmt.Errorf("DTN mode is disabled")
+	}
+	var bundles []*Bundle
+	if err := json.Unmarshal(data, &bundles); err != nil {
+		return fmt.Errorf("invalid DTN bundles: %w", err)
+	}
+
+	now := time.Now()
+	for _, b := range bundles {
+		if !store.add(b, now) {
+			continue
+		}
+		payload, ok := store.open(b)
+		if !ok || !store.markDelivered(b.ID, b.Expires) {
+			continue
+		}
+		var id [messageIDSize]byte
+		hex.Decode(id[:], []byte(b.ID))
+		if m.seen.add(id) {
+			m.deliver(payload)
+		}
+	}
+	return nil
+}
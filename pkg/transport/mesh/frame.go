@@ -0,0 +1,119 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Кадр mesh протокола: длина данных (4 байта, big-endian) | тип (1 байт) | CRC32 данных (4 байта) | данные.
+// На каждый кадр запроса пир отвечает одним кадром: frameAck после приема сообщения,
+// frameNack с причиной отказа или своим анонсом на framePEX.
+const (
+	frameMessage byte = 1 // конверт сообщения (см. envelope)
+	framePEX     byte = 2 // анонс PEX (JSON)
+	frameAck     byte = 3 // сообщение принято целиком
+	frameNack    byte = 4 // сообщение отклонено, данные - причина
+
+	frameDTNSummary byte = 5 // ID бандлов DTN отправителя; ответ - ID, которых нет у пира
+	frameBundle     byte = 6 // бандлы DTN (JSON)
+	frameRelay      byte = 7 // запрос к другому пиру через ретранслятор (см. encodeRelay); ответ - ответ пира
+
+	frameHeader  = 4 + 1 + 4
+	maxFrameSize = maxReplySize + envelopeHeader
+	frameTimeout = 10 * time.Second // время на запрос и ответ
+)
+
+// ErrChecksum - данные кадра повреждены
+var ErrChecksum = errors.New("mesh frame checksum mismatch")
+
+type frame struct {
+	Type    byte
+	Payload []byte
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("mesh frame too large: %d bytes", len(payload))
+	}
+	buf := make([]byte, frameHeader, frameHeader+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	buf[4] = typ
+	binary.BigEndian.PutUint32(buf[5:9], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(append(buf, payload...)); err != nil {
+		return err
+	}
+	// Потоки QUIC буферизуют запись до явного сброса
+	if f, ok := w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (*frame, error) {
+	var header [frameHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("mesh frame too large: %d bytes", size)
+	}
+
+	f := &frame{Type: header[4], Payload: make([]byte, size)}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, fmt.Errorf("truncated mesh frame: %w", err)
+	}
+	if crc32.ChecksumIEEE(f.Payload) != binary.BigEndian.Uint32(header[5:9]) {
+		return nil, ErrChecksum
+	}
+	return f, nil
+}
+
+// isLegacyFrame распознает соединения узлов без кадров: данные начинаются с magic
+// конверта или PEX, либо префикс длины заведомо некорректен
+func isLegacyFrame(head []byte) bool {
+	return bytes.Equal(head, envelopeMagic) || bytes.Equal(head, pexMagic) ||
+		binary.BigEndian.Uint32(head) > maxFrameSize
+}
+
+// roundTrip отправляет кадр и ждет ответный. Отказ пира (frameNack) возвращается как ошибка.
+func roundTrip(rw io.ReadWriter, typ byte, payload []byte) (*frame, error) {
+	if err := writeFrame(rw, typ, payload); err != nil {
+		return nil, err
+	}
+
+	reply, err := readFrame(rw)
+	if err != nil {
+		return nil, fmt.Errorf("no acknowledgement from peer: %w", err)
+	}
+	if reply.Type == frameNack {
+		return nil, fmt.Errorf("peer rejected message: %s", reply.Payload)
+	}
+	return reply, nil
+}
+
+// roundTripPeer отправляет кадр пиру и ждет ответный: по QUIC, если он включен и пир его
+// поддерживает, иначе по TCP
+func (m *MeshTransport) roundTripPeer(peer string, typ byte, payload []byte) (*frame, error) {
+	if links := m.quicLinks(); links != nil && links.usable(peer) && m.peerSupports(peer, LinkQUIC) {
+		reply, err := links.roundTrip(peer, typ, payload)
+		if err == nil {
+			return reply, nil
+		}
+		links.markFailed(peer, err)
+	}
+
+	conn, err := m.dial(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(frameTimeout))
+	return roundTrip(conn, typ, payload)
+}
@@ -0,0 +1,72 @@
+package mesh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// SetListenAddr задает адрес слушателя mesh (до Connect): ":8080" - все интерфейсы,
+// "192.168.1.10:8080" - один интерфейс. По умолчанию ":0" (случайный порт).
+func (m *MeshTransport) SetListenAddr(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listenAddr = addr
+}
+
+// SetAdvertiseAddr задает адрес (host:port), который узел сообщает о себе в анонсах PEX,
+// например внешний адрес при ручном пробросе порта. Пусто - адрес определяется автоматически.
+func (m *MeshTransport) SetAdvertiseAddr(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advertiseAddr = addr
+}
+
+// BindAddr возвращает адрес слушателя для порта port на интерфейсе iface: имя интерфейса
+// (eth0) или его IP. Пустой iface означает все интерфейсы.
+func BindAddr(iface string, port int) (string, error) {
+	if iface == "" {
+		return net.JoinHostPort("", strconv.Itoa(port)), nil
+	}
+	if ip := net.ParseIP(iface); ip != nil {
+		return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", fmt.Errorf("unknown interface %s: %w", iface, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses of %s: %w", iface, err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return net.JoinHostPort(ipnet.IP.String(), strconv.Itoa(port)), nil
+		}
+	}
+	return "", fmt.Errorf("interface %s has no IPv4 address", iface)
+}
+
+// localIP возвращает IP, по которому узел доступен в локальной сети: адрес интерфейса
+// слушателя, если он задан, иначе первый не-loopback IPv4
+func localIP(listenAddr string) (string, error) {
+	if host, _, err := net.SplitHostPort(listenAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			return ip.String(), nil
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get interface addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP.String(), nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -2,7 +2,10 @@ package mesh
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
+	"hydra/pkg/nat"
 	"hydra/pkg/transport"
 	"log"
 	"net"
@@ -10,20 +13,72 @@ import (
 	"time"
 )
 
+// maxReplySize - максимальный размер данных сообщения mesh
+const maxReplySize = 1 << 20
+
 // MeshTransport реализует P2P mesh сеть через TCP
 // В реальном приложении здесь был бы Bluetooth/Wi-Fi Direct
 // Для демонстрации используем простой TCP
 
 type MeshTransport struct {
-	peers     []string // Список пиров в сети
-	listener  net.Listener
-	currentIP string
-	mu        sync.Mutex
+	peers         []string // Список пиров в сети
+	listener      net.Listener
+	listenAddr    string // адрес слушателя (по умолчанию ":0" - случайный порт)
+	advertiseAddr string // адрес для анонсов, заданный вручную
+	currentIP     string
+	ttl           uint8
+	seen          *seenCache
+	onMessage     func(data []byte)
+
+	// PEX: ключ подписи анонсов и узлы, изученные через обмен списками пиров
+	identity       ed25519.PrivateKey
+	learned        map[string]*PeerInfo
+	gossipInterval time.Duration
+
+	// Обход NAT: проброс порта или внешний адрес STUN и запросы на пробивание NAT
+	natEnabled   bool
+	stunServers  []string
+	natMapping   *nat.Mapping
+	pendingPunch map[string]*PunchRequest
+	handledPunch map[string]time.Time
+
+	// QUIC соединения с пирами (для сетей с потерями)
+	quicEnabled bool
+	quic        *quicLinkSet
+
+	// DTN: бандлы, переносимые до контакта с пирами
+	dtn *dtnStore
+
+	// Ресурсы на ретрансляцию: очередь, полоса, режим экономии батареи
+	resources      ResourceLimits
+	relayQueue     *relayQueue
+	relayBandwidth *bandwidth
+	skippedRelays  int64
+
+	// Режим ретранслятора и результаты запросов через чужие ретрансляторы
+	relayNode  bool
+	relayStats map[string]*relayStat
+
+	mu sync.Mutex
 }
 
 func New(peers []string) *MeshTransport {
 	return &MeshTransport{
-		peers: peers,
+		peers:      peers,
+		listenAddr: ":0",
+		ttl:        DefaultTTL,
+		seen:       newSeenCache(),
+
+		identity:       newIdentity(),
+		learned:        make(map[string]*PeerInfo),
+		gossipInterval: DefaultGossipInterval,
+
+		pendingPunch: make(map[string]*PunchRequest),
+		handledPunch: make(map[string]time.Time),
+
+		resources:      ResourceLimits{MaxQueueBytes: DefaultMaxQueueBytes},
+		relayQueue:     newRelayQueue(DefaultMaxQueueBytes),
+		relayBandwidth: &bandwidth{},
 	}
 }
 
@@ -32,61 +87,116 @@ func (m *MeshTransport) Name() string {
 }
 
 func (m *MeshTransport) Connect(ctx context.Context) error {
-	// Получаем наш локальный IP для демонстрации
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return fmt.Errorf("failed to get interface addresses: %v", err)
-	}
+	m.mu.Lock()
+	listenAddr := m.listenAddr
+	m.mu.Unlock()
 
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				m.currentIP = ipnet.IP.String()
-				break
-			}
-		}
+	// Получаем локальный IP для анонсов
+	currentIP, err := localIP(listenAddr)
+	if err != nil {
+		return err
 	}
 
 	// Запускаем TCP сервер для приема сообщений
-	m.listener, err = net.Listen("tcp", ":0") // Случайный порт
+	listener, err := m.listen(listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to start mesh listener: %v", err)
 	}
 
+	m.mu.Lock()
+	m.listener = listener
+	m.currentIP = currentIP
+	interval := m.gossipInterval
+	natEnabled, stunServers := m.natEnabled, m.stunServers
+	quicEnabled := m.quicEnabled
+	dtnEnabled := m.dtn != nil
+	m.mu.Unlock()
+
+	go m.serve(listener)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if quicEnabled {
+		if err := m.startQUIC(listenAddr, port); err != nil {
+			log.Printf("Mesh: QUIC недоступен, используется только TCP: %v", err)
+		}
+	}
+
+	if interval > 0 {
+		go m.runGossip(interval)
+	}
+	if natEnabled {
+		go m.runNAT(port, stunServers)
+	}
+	if dtnEnabled {
+		go m.runDTN()
+	}
+
 	log.Printf("Mesh транспорт запущен на %s", m.listener.Addr().String())
 	return nil
 }
 
+// Send отправляет данные пиру; успех означает, что пир подтвердил прием всего сообщения
 func (m *MeshTransport) Send(ctx context.Context, data []byte) error {
-	if len(m.peers) == 0 {
-		return fmt.Errorf("no peers available in mesh network")
+	_, err := m.Exchange(ctx, data)
+	return err
+}
+
+// Exchange отправляет данные пиру и возвращает данные его подтверждения.
+// В режиме DTN сообщение также сохраняется как бандл и считается принятым,
+// даже если сейчас ни один пир не доступен.
+func (m *MeshTransport) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	m.mu.Lock()
+	ttl := m.ttl
+	dtnEnabled := m.dtn != nil
+	m.mu.Unlock()
+
+	// Сообщение отправляется в конверте с ID и TTL, чтобы пиры могли ретранслировать его дальше
+	env, err := newEnvelope(data, ttl)
+	if err != nil {
+		return nil, err
+	}
+	m.seen.add(env.ID)
+	payload := env.marshal()
+
+	if dtnEnabled {
+		if err := m.storeBundle(env); err != nil {
+			return nil, fmt.Errorf("failed to store DTN bundle: %w", err)
+		}
+	}
+
+	peers := m.relayFirst(m.GetPeers())
+	if len(peers) == 0 {
+		if dtnEnabled {
+			log.Printf("Mesh: пиров нет, сообщение %s ожидает контакта (DTN)", hex.EncodeToString(env.ID[:4]))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no peers available in mesh network")
 	}
 
 	// Пытаемся отправить всем доступным пирам
 	var lastError error
-	for _, peer := range m.peers {
+	for _, peer := range peers {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
-			conn, err := net.DialTimeout("tcp", peer, 3*time.Second)
-			if err != nil {
-				lastError = err
-				continue
+			ack, err := m.roundTripRoute(peer, frameMessage, payload)
+			if err == nil && ack.Type == frameAck {
+				log.Printf("Сообщение успешно отправлено через Mesh к %s", peer)
+				return ack.Payload, nil
 			}
-
-			_, err = conn.Write(data)
-			conn.Close()
-
 			if err == nil {
-				log.Printf("Сообщение успешно отправлено через Mesh к %s", peer)
-				return nil
+				err = fmt.Errorf("unexpected reply frame type %d", ack.Type)
 			}
 			lastError = err
 		}
 	}
 
-	return fmt.Errorf("failed to send to any peer: %v", lastError)
+	if dtnEnabled {
+		log.Printf("Mesh: сообщение %s не доставлено напрямую (%v), ожидает контакта (DTN)", hex.EncodeToString(env.ID[:4]), lastError)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("failed to send to any peer: %v", lastError)
 }
 
 func (m *MeshTransport) IsAvailable() bool {
@@ -103,12 +213,12 @@ func (m *MeshTransport) UpdatePeers(newPeers []string) {
 	log.Printf("Mesh peers updated: %v", newPeers)
 }
 
-// GetPeers возвращает текущий список пиров
+// GetPeers возвращает текущий список пиров, включая изученные через PEX
 func (m *MeshTransport) GetPeers() []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	return m.peers
+	return m.peersLocked()
 }
 
 // Ensure interface compliance
@@ -0,0 +1,652 @@
+package mesh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hydra/pkg/nat"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMultiHopRelay проверяет доставку через промежуточный узел и отсутствие повторов в петле.
+func TestMultiHopRelay(t *testing.T) {
+	a, b, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b, c} {
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+
+	var mu sync.Mutex
+	received := make(map[*MeshTransport]int)
+	done := make(chan struct{}, 3)
+	for _, node := range []*MeshTransport{a, b, c} {
+		node := node
+		node.OnMessage(func(data []byte) {
+			mu.Lock()
+			received[node]++
+			mu.Unlock()
+			if string(data) != "hello" {
+				t.Errorf("Unexpected data: %s", data)
+			}
+			done <- struct{}{}
+		})
+	}
+
+	// Цепочка с петлей: a -> b -> c -> a
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+	b.UpdatePeers([]string{a.listener.Addr().String(), c.listener.Addr().String()})
+	c.UpdatePeers([]string{a.listener.Addr().String(), b.listener.Addr().String()})
+
+	if err := a.Send(context.Background(), []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("Message was not relayed")
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received[a] != 0 || received[b] != 1 || received[c] != 1 {
+		t.Errorf("Expected one delivery to b and c and none to sender, got a=%d b=%d c=%d", received[a], received[b], received[c])
+	}
+}
+
+// TestTTLLimitsHops проверяет, что сообщение с TTL=1 не ретранслируется.
+func TestTTLLimitsHops(t *testing.T) {
+	a, b, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b, c} {
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+
+	got := make(chan string, 2)
+	b.OnMessage(func(data []byte) { got <- "b" })
+	c.OnMessage(func(data []byte) { got <- "c" })
+
+	a.SetTTL(1)
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+	b.UpdatePeers([]string{c.listener.Addr().String()})
+
+	if err := a.Send(context.Background(), []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case node := <-got:
+		if node != "b" {
+			t.Fatalf("Expected delivery to b, got %s", node)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Message was not delivered")
+	}
+	select {
+	case node := <-got:
+		t.Errorf("Message with TTL=1 must not be relayed, delivered to %s", node)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// TestPeerExchange проверяет, что узел узнает о пирах за пределами своей видимости через PEX.
+func TestPeerExchange(t *testing.T) {
+	a, b, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b, c} {
+		node.SetGossipInterval(0)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+
+	// a и c видят только b
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+	c.UpdatePeers([]string{b.listener.Addr().String()})
+
+	c.gossipRound()
+	a.gossipRound()
+
+	cAddr := c.Topology().Addr
+	found := false
+	for _, peer := range a.GetPeers() {
+		if peer == cAddr {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a to learn about c (%s), got peers %v", cAddr, a.GetPeers())
+	}
+
+	topo := a.Topology()
+	if len(topo.Learned) != 1 || topo.Learned[0].NodeID != b.NodeID() {
+		t.Errorf("Expected b in learned topology, got %+v", topo.Learned)
+	}
+}
+
+// TestBootstrap проверяет, что узел без пиров узнает сеть через bootstrap узел.
+func TestBootstrap(t *testing.T) {
+	a, boot, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, boot, c} {
+		node.SetGossipInterval(0)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+	c.UpdatePeers([]string{boot.listener.Addr().String()})
+	c.gossipRound()
+
+	// Закрытый порт: узел недоступен и не попадает в результат
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	reachable := a.Bootstrap([]string{deadAddr, boot.listener.Addr().String()})
+	if len(reachable) != 1 || reachable[0] != boot.listener.Addr().String() {
+		t.Fatalf("Expected only the live bootstrap peer, got %v", reachable)
+	}
+
+	cAddr := c.Topology().Addr
+	for _, peer := range a.GetPeers() {
+		if peer == cAddr {
+			return
+		}
+	}
+	t.Errorf("Expected a to learn about c (%s) from bootstrap peer, got %v", cAddr, a.GetPeers())
+}
+
+// TestForgedAnnouncementRejected проверяет отклонение анонса с чужой подписью.
+func TestForgedAnnouncementRejected(t *testing.T) {
+	a, b := New(nil), New(nil)
+
+	ann := &Announcement{
+		NodeID:   b.NodeID(),
+		Addr:     "10.0.0.1:9000",
+		Peers:    []string{"10.0.0.2:9000"},
+		IssuedAt: time.Now().UnixMilli(),
+	}
+	ann.sign(a.identity) // подписано не ключом узла b
+
+	data, _ := json.Marshal(ann)
+	if err := a.learn(data, "10.0.0.1:9000"); err == nil {
+		t.Fatal("Expected forged announcement to be rejected")
+	}
+	if len(a.Topology().Learned) != 0 {
+		t.Error("Forged announcement must not change topology")
+	}
+}
+
+func TestPunchRequestRelayed(t *testing.T) {
+	a, b, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b, c} {
+		// Без Connect, чтобы не обращаться к настоящему роутеру через UPnP/NAT-PMP
+		listener, err := nat.ListenConfig().Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		defer listener.Close()
+		node.listener = listener
+		node.natEnabled = true
+		go node.serve(listener)
+	}
+
+	// a и c "за NAT": внешний адрес известен через STUN, входящие возможны только после пробивания
+	for _, node := range []*MeshTransport{a, c} {
+		node.natMapping = &nat.Mapping{Method: nat.MethodSTUN, ExternalAddr: node.listener.Addr().String()}
+	}
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+	c.UpdatePeers([]string{b.listener.Addr().String()})
+
+	c.gossipRound()
+	a.gossipRound()
+
+	if err := a.ConnectPeer(c.NodeID()); err != nil {
+		t.Fatalf("ConnectPeer failed: %v", err)
+	}
+
+	// Запрос доходит до c через b, когда c обменивается анонсами с b
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.gossipRound()
+		c.mu.Lock()
+		handled := len(c.handledPunch)
+		c.mu.Unlock()
+		a.mu.Lock()
+		punched := a.learned[c.NodeID()] != nil && a.learned[c.NodeID()].Punched
+		a.mu.Unlock()
+		if handled == 1 && punched {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected punch request relayed to c (handled %d, punched %v)", handled, punched)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Запрос с чужой подписью отбрасывается
+	forged := &PunchRequest{From: a.NodeID(), FromAddr: "127.0.0.1:1", Target: b.NodeID(), IssuedAt: time.Now().UnixMilli()}
+	forged.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.identity, forged.payload()))
+	b.handlePunchRequests([]*PunchRequest{forged})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.handledPunch) != 0 {
+		t.Errorf("Expected forged punch request to be ignored")
+	}
+}
+
+func TestListenAndAdvertiseAddr(t *testing.T) {
+	node := New(nil)
+	node.SetGossipInterval(0)
+	node.SetListenAddr("127.0.0.1:0")
+	if err := node.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer node.listener.Close()
+
+	// Узел, привязанный к интерфейсу, анонсирует его адрес
+	if addr := node.Topology().Addr; addr != node.listener.Addr().String() {
+		t.Errorf("Expected advertised addr %s, got %s", node.listener.Addr(), addr)
+	}
+
+	node.SetAdvertiseAddr("203.0.113.7:8080")
+	if addr := node.Topology().Addr; addr != "203.0.113.7:8080" {
+		t.Errorf("Expected configured advertised addr, got %s", addr)
+	}
+}
+
+func TestFrameChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, frameMessage, []byte("payload")); err != nil {
+		t.Fatalf("writeFrame failed: %v", err)
+	}
+	raw := buf.Bytes()
+
+	f, err := readFrame(bytes.NewReader(raw))
+	if err != nil || f.Type != frameMessage || string(f.Payload) != "payload" {
+		t.Fatalf("Expected frame to round-trip, got %+v, %v", f, err)
+	}
+
+	corrupted := append([]byte(nil), raw...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if _, err := readFrame(bytes.NewReader(corrupted)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected ErrChecksum for corrupted frame, got %v", err)
+	}
+	if _, err := readFrame(bytes.NewReader(raw[:len(raw)-2])); err == nil {
+		t.Error("Expected error for truncated frame")
+	}
+}
+
+// TestSendRequiresAck проверяет, что Send не считает успехом запись без подтверждения пира
+func TestSendRequiresAck(t *testing.T) {
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, io.LimitReader(conn, frameHeader))
+			conn.Close()
+		}
+	}()
+
+	node := New([]string{silent.Addr().String()})
+	if err := node.Send(context.Background(), []byte("hello")); err == nil {
+		t.Fatal("Expected Send to fail without acknowledgement")
+	}
+
+	receiver := New(nil)
+	receiver.SetGossipInterval(0)
+	if err := receiver.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer receiver.listener.Close()
+
+	node.UpdatePeers([]string{receiver.listener.Addr().String()})
+	if err := node.Send(context.Background(), []byte("hello")); err != nil {
+		t.Errorf("Expected acknowledged Send, got %v", err)
+	}
+}
+
+func TestQUICLink(t *testing.T) {
+	a, b := New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b} {
+		node.SetGossipInterval(0)
+		node.SetListenAddr("127.0.0.1:0")
+		node.SetQUIC(true)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+		if node.quicLinks() == nil {
+			t.Skip("QUIC endpoint is not available")
+		}
+		defer node.quicLinks().endpoint.Close(context.Background())
+	}
+
+	received := make(chan string, 4)
+	b.OnMessage(func(data []byte) { received <- string(data) })
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+
+	// Несколько сообщений идут параллельными потоками одного соединения
+	for _, msg := range []string{"one", "two"} {
+		if err := a.Send(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatal("Message was not delivered over QUIC")
+		}
+	}
+
+	links := a.quicLinks()
+	links.mu.Lock()
+	_, viaQUIC := links.conns[b.listener.Addr().String()]
+	links.mu.Unlock()
+	if !viaQUIC {
+		t.Error("Expected messages to use a QUIC connection")
+	}
+
+	// После смены адреса соединения открываются заново
+	links.reset()
+	if err := a.Send(context.Background(), []byte("three")); err != nil {
+		t.Fatalf("Send after reset failed: %v", err)
+	}
+}
+
+// TestDTNCarriesBundles проверяет перенос бандла узлом без ключа сети между
+// узлами, которые никогда не были на связи одновременно.
+func TestDTNCarriesBundles(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	a, carrier, c := New(nil), New(nil), New(nil)
+	for node, opts := range map[*MeshTransport]DTNOptions{
+		a:       {Key: key},
+		carrier: {},
+		c:       {Key: key},
+	} {
+		if err := node.EnableDTN(opts); err != nil {
+			t.Fatalf("EnableDTN failed: %v", err)
+		}
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+
+	got := make(chan string, 2)
+	c.OnMessage(func(data []byte) { got <- string(data) })
+	carrier.OnMessage(func(data []byte) { t.Errorf("Carrier without key must not read bundles: %s", data) })
+
+	// Пиров нет: сообщение принимается как бандл
+	if err := a.Send(context.Background(), []byte("blackout")); err != nil {
+		t.Fatalf("Send in DTN mode failed: %v", err)
+	}
+
+	// Контакт a -> переносчик, затем переносчик -> c
+	a.UpdatePeers([]string{carrier.listener.Addr().String()})
+	a.dtnRound()
+	a.UpdatePeers(nil)
+	if ids := carrier.dtn.ids(time.Now()); len(ids) != 1 {
+		t.Fatalf("Expected carrier to hold one bundle, got %d", len(ids))
+	}
+
+	carrier.UpdatePeers([]string{c.listener.Addr().String()})
+	carrier.dtnRound()
+	carrier.dtnRound() // повторный контакт не доставляет сообщение дважды
+
+	select {
+	case msg := <-got:
+		if msg != "blackout" {
+			t.Errorf("Unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Bundle was not delivered")
+	}
+	select {
+	case <-got:
+		t.Error("Bundle delivered twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestDTNQuotaAndExpiry проверяет вытеснение бандлов с ближайшим сроком и отказ от истекших.
+func TestDTNQuotaAndExpiry(t *testing.T) {
+	store, err := newDTNStore(DTNOptions{MaxBundles: 2})
+	if err != nil {
+		t.Fatalf("newDTNStore failed: %v", err)
+	}
+	now := time.Now()
+	bundle := func(b byte, expires time.Duration) *Bundle {
+		return &Bundle{ID: hex.EncodeToString(bytes.Repeat([]byte{b}, messageIDSize)), Expires: now.Add(expires).UnixMilli()}
+	}
+
+	if store.add(bundle(1, -time.Minute), now) {
+		t.Error("Expired bundle must be rejected")
+	}
+	store.add(bundle(2, time.Hour), now)
+	store.add(bundle(3, 2*time.Hour), now)
+	if !store.add(bundle(4, 3*time.Hour), now) {
+		t.Fatal("Expected bundle to evict the soonest expiring one")
+	}
+	if store.add(bundle(5, time.Minute), now) {
+		t.Error("Bundle expiring sooner than all stored ones must not evict them")
+	}
+
+	ids := store.ids(now)
+	if len(ids) != 2 || ids[0] != bundle(3, 0).ID || ids[1] != bundle(4, 0).ID {
+		t.Errorf("Unexpected bundles after eviction: %v", ids)
+	}
+	if want := store.missing([]string{bundle(2, 0).ID, bundle(6, 0).ID}); len(want) != 1 || want[0] != bundle(6, 0).ID {
+		t.Errorf("Evicted bundles must not be requested again, want=%v", want)
+	}
+}
+
+// TestRelayQueuePriority проверяет вытеснение сообщений с меньшим TTL при переполнении очереди.
+func TestRelayQueuePriority(t *testing.T) {
+	msg := func(ttl uint8) *envelope { return &envelope{TTL: ttl, Data: make([]byte, 100-envelopeHeader)} }
+	q := newRelayQueue(300)
+
+	if !q.push(msg(2)) {
+		t.Error("Expected first push to start the relay worker")
+	}
+	q.push(msg(1))
+	q.push(msg(3))
+	q.push(msg(4)) // вытесняет сообщение с TTL=1
+	q.push(msg(1)) // не помещается и ничего не вытесняет
+
+	if q.dropped != 2 || q.bytes != 300 {
+		t.Errorf("Expected 2 dropped and 300 bytes queued, got %d and %d", q.dropped, q.bytes)
+	}
+	for _, want := range []uint8{4, 3, 2} {
+		if got := q.pop().TTL; got != want {
+			t.Errorf("Expected TTL %d, got %d", want, got)
+		}
+	}
+}
+
+// TestBatterySaverDisablesRelay проверяет, что в режиме экономии батареи узел
+// получает сообщения, но не ретранслирует их.
+func TestBatterySaverDisablesRelay(t *testing.T) {
+	a, b, c := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b, c} {
+		node.SetGossipInterval(0)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+	b.SetResourceLimits(ResourceLimits{BatterySaver: true})
+
+	got := make(chan string, 2)
+	b.OnMessage(func(data []byte) { got <- "b" })
+	c.OnMessage(func(data []byte) { got <- "c" })
+
+	a.UpdatePeers([]string{b.listener.Addr().String()})
+	b.UpdatePeers([]string{c.listener.Addr().String()})
+
+	if err := a.Send(context.Background(), []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if node := <-got; node != "b" {
+		t.Fatalf("Expected delivery to b, got %s", node)
+	}
+	select {
+	case node := <-got:
+		t.Errorf("Message must not be relayed in battery saver mode, got delivery to %s", node)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if status := b.ResourceStatus(); status.SkippedRelays != 1 || !status.BatterySaver {
+		t.Errorf("Unexpected resource status: %+v", status)
+	}
+}
+
+// TestCapabilitiesExchange проверяет передачу возможностей в PEX, пропуск несовместимых узлов
+// и выбор узлов, согласных ретранслировать
+func TestCapabilitiesExchange(t *testing.T) {
+	a, b := New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, b} {
+		node.SetGossipInterval(0)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+	b.SetResourceLimits(ResourceLimits{BatterySaver: true})
+
+	bAddr := b.listener.Addr().String()
+	a.UpdatePeers([]string{bAddr})
+	a.gossipRound()
+
+	topo := a.Topology()
+	if len(topo.Learned) != 1 || topo.Learned[0].Caps == nil {
+		t.Fatalf("Expected b with capabilities in learned topology, got %+v", topo.Learned)
+	}
+	info := topo.Learned[0]
+	if info.Caps.Relay || info.Caps.Version != ProtocolVersion || !info.Caps.Supports(LinkTCP) {
+		t.Errorf("Unexpected capabilities of b: %+v", info.Caps)
+	}
+	if info.Fingerprint != Fingerprint(b.NodeID()) || len(info.Fingerprint) != 16 {
+		t.Errorf("Unexpected fingerprint %q", info.Fingerprint)
+	}
+
+	// Узел, не согласный ретранслировать, выбирается последним
+	other := "10.0.0.9:9000"
+	if order := a.relayFirst([]string{info.Addr, other}); order[0] != other {
+		t.Errorf("Expected relaying peer first, got %v", order)
+	}
+
+	// Узел с несовместимой версией протокола не используется
+	pub, key, _ := ed25519.GenerateKey(nil)
+	ann := &Announcement{
+		NodeID:   base64.StdEncoding.EncodeToString(pub),
+		Addr:     "10.0.0.7:9000",
+		IssuedAt: time.Now().UnixMilli(),
+		Caps:     &Capabilities{Version: ProtocolVersion + 2, MinVersion: ProtocolVersion + 1, Transports: []string{LinkTCP}},
+	}
+	ann.sign(key)
+	data, _ := json.Marshal(ann)
+	if err := a.learn(data, ann.Addr); err != nil {
+		t.Fatalf("learn failed: %v", err)
+	}
+	for _, peer := range a.GetPeers() {
+		if peer == ann.Addr {
+			t.Errorf("Incompatible peer must not be used, got peers %v", a.GetPeers())
+		}
+	}
+}
+
+// TestRelayNode проверяет пересылку через ретранслятор с публичным адресом, отказ
+// ретранслятора пересылать неизвестным пирам и порядок выбора ретрансляторов
+func TestRelayNode(t *testing.T) {
+	a, r, b := New(nil), New(nil), New(nil)
+	for _, node := range []*MeshTransport{a, r, b} {
+		node.SetGossipInterval(0)
+		if err := node.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer node.listener.Close()
+	}
+	rAddr, bAddr := r.listener.Addr().String(), b.listener.Addr().String()
+
+	// Узел с локальным адресом ретранслятором не объявляется
+	r.EnableRelayNode()
+	r.SetAdvertiseAddr("192.168.1.5:9000")
+	if r.Capabilities().RelayNode {
+		t.Fatal("Relay node with private address must not be announced")
+	}
+	r.SetAdvertiseAddr("203.0.113.7:9000")
+	if !r.Capabilities().RelayNode {
+		t.Fatal("Relay node with public address must be announced")
+	}
+	r.UpdatePeers([]string{bAddr})
+
+	a.mu.Lock()
+	a.learned[r.NodeID()] = &PeerInfo{NodeID: r.NodeID(), Addr: rAddr, Caps: &Capabilities{
+		Version: ProtocolVersion, MinVersion: ProtocolVersion, Transports: []string{LinkTCP}, RelayNode: true,
+	}}
+	a.mu.Unlock()
+
+	got := make(chan []byte, 1)
+	b.OnMessage(func(data []byte) { got <- data })
+
+	env, err := newEnvelope([]byte("via relay"), 1)
+	if err != nil {
+		t.Fatalf("newEnvelope failed: %v", err)
+	}
+	if _, err := a.roundTripRelayed(bAddr, frameMessage, env.marshal()); err != nil {
+		t.Fatalf("roundTripRelayed failed: %v", err)
+	}
+	select {
+	case data := <-got:
+		if string(data) != "via relay" {
+			t.Errorf("Unexpected data: %s", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Message was not delivered through relay node")
+	}
+
+	// Ретранслятор не пересылает пирам, которых не знает, и не становится открытым прокси
+	if _, err := a.roundTripRelayed("198.51.100.1:9000", frameMessage, env.marshal()); err == nil {
+		t.Error("Relay to unknown peer must be refused")
+	}
+
+	// Недоступный ретранслятор выбирается последним, быстрый - раньше медленного
+	a.recordRelay("10.0.0.1:9000", 50*time.Millisecond, nil)
+	a.recordRelay("10.0.0.2:9000", 0, errors.New("timeout"))
+	a.mu.Lock()
+	for _, addr := range []string{"10.0.0.1:9000", "10.0.0.2:9000"} {
+		pub, _, _ := ed25519.GenerateKey(nil)
+		id := base64.StdEncoding.EncodeToString(pub)
+		a.learned[id] = &PeerInfo{NodeID: id, Addr: addr, Caps: &Capabilities{
+			Version: ProtocolVersion, MinVersion: ProtocolVersion, Transports: []string{LinkTCP}, RelayNode: true,
+		}}
+	}
+	a.mu.Unlock()
+	if relays := a.selectRelays(bAddr); len(relays) != maxRelayAttempts || relays[0] != "10.0.0.1:9000" || relays[1] != rAddr {
+		t.Errorf("Unexpected relay order: %v", relays)
+	}
+}
@@ -0,0 +1,296 @@
+package mesh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/nat"
+	"log"
+	"net"
+	"time"
+)
+
+// Пробивание NAT: узел за NAT без проброса порта знает свой внешний адрес (STUN), но
+// принять входящее соединение не может. Инициатор рассылает подписанный запрос через
+// PEX; пиры хранят его и передают цели, когда она сама обменивается с ними анонсами.
+// Получив запрос, обе стороны одновременно открывают TCP соединения друг к другу
+// со своих слушающих портов (simultaneous open).
+const (
+	punchWindow      = 30 * time.Second       // сколько инициатор пытается соединиться
+	punchAttemptGap  = 500 * time.Millisecond // пауза между попытками
+	punchRequestTTL  = 2 * time.Minute        // срок жизни запроса у посредников
+	maxPendingPunch  = 64                     // максимум хранимых запросов
+	natRenewFraction = 2                      // проброс продлевается через lease/2
+)
+
+// PunchRequest - подписанный инициатором запрос на пробивание NAT
+type PunchRequest struct {
+	From      string `json:"from"`      // NodeID инициатора
+	FromAddr  string `json:"from_addr"` // внешний адрес инициатора
+	Target    string `json:"target"`    // NodeID цели
+	IssuedAt  int64  `json:"issued_at"`
+	Signature string `json:"signature"`
+}
+
+func (r *PunchRequest) payload() []byte {
+	data, _ := json.Marshal(struct {
+		From     string `json:"from"`
+		FromAddr string `json:"from_addr"`
+		Target   string `json:"target"`
+		IssuedAt int64  `json:"issued_at"`
+	}{r.From, r.FromAddr, r.Target, r.IssuedAt})
+	return data
+}
+
+func (r *PunchRequest) verify(now time.Time) error {
+	pub, err := base64.StdEncoding.DecodeString(r.From)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid node id")
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), r.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	if now.Sub(time.UnixMilli(r.IssuedAt)) > punchRequestTTL {
+		return fmt.Errorf("stale punch request")
+	}
+	if _, _, err := net.SplitHostPort(r.FromAddr); err != nil {
+		return fmt.Errorf("invalid addr: %w", err)
+	}
+	return nil
+}
+
+func (r *PunchRequest) key() string {
+	return fmt.Sprintf("%s>%s@%d", r.From, r.Target, r.IssuedAt)
+}
+
+// EnableNAT включает обход NAT (до Connect): проброс порта через UPnP/NAT-PMP, а если
+// роутер его не поддерживает - определение внешнего адреса через STUN серверы (host:port, TCP)
+// для пробивания NAT. Пробрасывается порт слушателя (см. SetListenAddr).
+func (m *MeshTransport) EnableNAT(stunServers []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.natEnabled = true
+	m.stunServers = stunServers
+}
+
+// NATMapping возвращает текущий внешний адрес узла; nil - не определен
+func (m *MeshTransport) NATMapping() *nat.Mapping {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.natMapping == nil {
+		return nil
+	}
+	cp := *m.natMapping
+	return &cp
+}
+
+// ConnectPeer устанавливает прямое соединение с узлом nodeID, изученным через PEX.
+// Если узел доступен напрямую, ничего не делает; иначе запускает пробивание NAT.
+func (m *MeshTransport) ConnectPeer(nodeID string) error {
+	m.mu.Lock()
+	info, exists := m.learned[nodeID]
+	mapping := m.natMapping
+	m.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("unknown node %s", nodeID)
+	}
+	if info.PublicAddr == "" {
+		return nil
+	}
+	if mapping == nil {
+		return fmt.Errorf("public address is unknown (NAT traversal disabled or failed)")
+	}
+
+	req := &PunchRequest{
+		From:     m.NodeID(),
+		FromAddr: mapping.ExternalAddr,
+		Target:   nodeID,
+		IssuedAt: time.Now().UnixMilli(),
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(m.identity, req.payload()))
+
+	// Запрос уходит всем пирам сразу, не дожидаясь раунда PEX
+	m.addPunchRequest(req)
+	go m.gossipAll()
+	go m.punch(info.PublicAddr, nodeID)
+	return nil
+}
+
+// gossipAll обменивается анонсами со всеми пирами
+func (m *MeshTransport) gossipAll() {
+	for _, peer := range m.GetPeers() {
+		if err := m.exchangePeers(peer); err != nil {
+			log.Printf("Mesh PEX с %s не удался: %v", peer, err)
+		}
+	}
+}
+
+// handlePunchRequests обрабатывает запросы из полученного анонса: адресованные этому
+// узлу запускают пробивание, остальные сохраняются для передачи дальше
+func (m *MeshTransport) handlePunchRequests(requests []*PunchRequest) {
+	self := m.NodeID()
+	for _, req := range requests {
+		if req.From == self || req.verify(time.Now()) != nil {
+			continue
+		}
+		if req.Target == self {
+			if m.markPunchHandled(req) {
+				go m.punch(req.FromAddr, req.From)
+			}
+			continue
+		}
+		m.addPunchRequest(req)
+	}
+}
+
+func (m *MeshTransport) addPunchRequest(req *PunchRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expirePunchLocked()
+	if _, exists := m.pendingPunch[req.key()]; exists || len(m.pendingPunch) >= maxPendingPunch {
+		return
+	}
+	m.pendingPunch[req.key()] = req
+}
+
+// markPunchHandled отмечает запрос к этому узлу; false - запрос уже обрабатывался
+func (m *MeshTransport) markPunchHandled(req *PunchRequest) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expirePunchLocked()
+	if _, handled := m.handledPunch[req.key()]; handled {
+		return false
+	}
+	m.handledPunch[req.key()] = time.UnixMilli(req.IssuedAt)
+	return true
+}
+
+// pendingPunchLocked возвращает запросы для передачи в анонсе
+func (m *MeshTransport) pendingPunchLocked() []*PunchRequest {
+	m.expirePunchLocked()
+	requests := make([]*PunchRequest, 0, len(m.pendingPunch))
+	for _, req := range m.pendingPunch {
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+func (m *MeshTransport) expirePunchLocked() {
+	for key, req := range m.pendingPunch {
+		if time.Since(time.UnixMilli(req.IssuedAt)) > punchRequestTTL {
+			delete(m.pendingPunch, key)
+		}
+	}
+	for key, issued := range m.handledPunch {
+		if time.Since(issued) > punchRequestTTL {
+			delete(m.handledPunch, key)
+		}
+	}
+}
+
+// punch пытается открыть соединение к addr со слушающего порта в течение punchWindow.
+// Встречные SYN обеих сторон открывают отображения в обоих NAT.
+func (m *MeshTransport) punch(addr, nodeID string) {
+	deadline := time.Now().Add(punchWindow)
+	for time.Now().Before(deadline) {
+		conn, err := m.dial(addr)
+		if err == nil {
+			// Обмен анонсами подтверждает, что на той стороне нужный узел
+			conn.SetDeadline(time.Now().Add(frameTimeout))
+			reply, err := roundTrip(conn, framePEX, m.announcement())
+			conn.Close()
+			if err == nil && reply.Type == framePEX && m.confirmPunch(reply.Payload, nodeID, addr) {
+				log.Printf("Mesh: NAT пробит, прямое соединение с %s (%s)", shortID(nodeID), addr)
+				return
+			}
+		}
+		time.Sleep(punchAttemptGap)
+	}
+	log.Printf("Mesh: не удалось пробить NAT до %s (%s)", shortID(nodeID), addr)
+}
+
+// confirmPunch проверяет, что по адресу addr ответил узел nodeID, и отмечает его
+// доступным напрямую по этому адресу
+func (m *MeshTransport) confirmPunch(reply []byte, nodeID, addr string) bool {
+	var ann Announcement
+	if err := json.Unmarshal(reply, &ann); err != nil || ann.NodeID != nodeID {
+		return false
+	}
+	if err := m.learn(reply, addr); err != nil {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, exists := m.learned[nodeID]
+	if !exists {
+		return false
+	}
+	info.Addr = addr
+	info.Punched = true
+	return true
+}
+
+// dial открывает соединение с пиром. При включенном обходе NAT соединение идет со
+// слушающего порта, чтобы использовать уже открытые отображения NAT.
+func (m *MeshTransport) dial(addr string) (net.Conn, error) {
+	m.mu.Lock()
+	natEnabled, listener := m.natEnabled, m.listener
+	m.mu.Unlock()
+
+	if natEnabled && listener != nil {
+		if tcp, ok := listener.Addr().(*net.TCPAddr); ok {
+			return nat.Dialer(tcp.Port).Dial("tcp", addr)
+		}
+	}
+	return net.DialTimeout("tcp", addr, 3*time.Second)
+}
+
+// listen запускает слушатель; при обходе NAT порт допускает повторное использование
+func (m *MeshTransport) listen(addr string) (net.Listener, error) {
+	m.mu.Lock()
+	natEnabled := m.natEnabled
+	m.mu.Unlock()
+
+	if natEnabled {
+		return nat.ListenConfig().Listen(context.Background(), "tcp", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// runNAT определяет внешний адрес и продлевает проброс порта до его истечения
+func (m *MeshTransport) runNAT(port int, stunServers []string) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		mapping, err := nat.Discover(ctx, port, stunServers)
+		cancel()
+
+		retry := 10 * time.Minute
+		if err != nil {
+			log.Printf("Mesh: обход NAT недоступен: %v", err)
+		} else {
+			m.mu.Lock()
+			m.natMapping = mapping
+			m.mu.Unlock()
+			log.Printf("Mesh: внешний адрес %s (%s)", mapping.ExternalAddr, mapping.Method)
+
+			if !mapping.ExpiresAt.IsZero() {
+				retry = time.Until(mapping.ExpiresAt) / natRenewFraction
+			}
+		}
+		time.Sleep(retry)
+	}
+}
+
+func shortID(nodeID string) string {
+	if len(nodeID) > 8 {
+		return nodeID[:8]
+	}
+	return nodeID
+}
@@ -0,0 +1,428 @@
+package mesh
+
+import (
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/nat"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Обмен списками пиров (PEX): узлы периодически рассылают подписанные анонсы со своим
+// адресом и известными пирами, что позволяет сети расти за пределы видимости mDNS.
+// Анонс передается в кадре framePEX, получатель отвечает своим анонсом в том же соединении.
+// Узлы без кадров передают pexMagic | JSON анонса.
+var pexMagic = []byte("HYP1")
+
+const (
+	// DefaultGossipInterval - период обмена списками пиров
+	DefaultGossipInterval = 30 * time.Second
+
+	gossipFanout      = 3                // скольким пирам отправляется анонс за раунд
+	maxAnnouncedPeers = 32               // максимум пиров в одном анонсе
+	maxKnownPeers     = 256              // максимум узлов, изученных через PEX
+	maxAnnounceSize   = 16 << 10         // максимальный размер анонса
+	announceMaxAge    = 10 * time.Minute // анонсы старше (или из будущего) отклоняются
+	learnedPeerTTL    = 30 * time.Minute // узел забывается, если о нем давно не было анонсов
+)
+
+// Announcement - подписанный анонс узла mesh сети
+type Announcement struct {
+	NodeID     string   `json:"node_id"`               // base64 публичного ключа Ed25519 узла
+	Addr       string   `json:"addr"`                  // адрес, на котором узел принимает соединения
+	PublicAddr string   `json:"public_addr,omitempty"` // внешний адрес за NAT (нужно пробивание)
+	Peers      []string `json:"peers"`
+	IssuedAt   int64    `json:"issued_at"` // Unix время в миллисекундах
+	Signature  string   `json:"signature"`
+
+	// Возможности узла; нет у узлов до появления обмена возможностями
+	Caps *Capabilities `json:"caps,omitempty"`
+
+	// Узлы за NAT, известные отправителю: к ним можно подключиться только пробиванием NAT
+	NATPeers []NATPeer `json:"nat_peers,omitempty"`
+	// Запросы на пробивание NAT для передачи дальше; каждый подписан своим инициатором
+	Punch []*PunchRequest `json:"punch,omitempty"`
+}
+
+// NATPeer - узел за NAT и его внешний адрес
+type NATPeer struct {
+	NodeID     string `json:"node_id"`
+	PublicAddr string `json:"public_addr"`
+}
+
+// PeerInfo - узел, изученный через PEX
+type PeerInfo struct {
+	NodeID     string    `json:"node_id"`
+	Addr       string    `json:"addr"`
+	PublicAddr string    `json:"public_addr,omitempty"` // внешний адрес за NAT
+	Punched    bool      `json:"punched,omitempty"`     // NAT пробит, Addr - внешний адрес
+	Peers      []string  `json:"peers"`                 // пиры, о которых узел сообщил
+	Via        string    `json:"via"`                   // адрес, от которого получен последний анонс
+	LastSeen   time.Time `json:"last_seen"`             // время последнего анонса
+
+	Caps        *Capabilities `json:"caps,omitempty"` // возможности из анонса узла
+	Fingerprint string        `json:"fingerprint"`    // отпечаток ключа узла
+}
+
+// Topology - известная узлу часть mesh сети
+type Topology struct {
+	NodeID  string       `json:"node_id"`
+	Addr    string       `json:"addr"`
+	NAT     *nat.Mapping `json:"nat,omitempty"` // внешний адрес узла, если используется обход NAT
+	Peers   []string     `json:"peers"`         // пиры, используемые для отправки
+	Learned []*PeerInfo  `json:"learned"`       // узлы, изученные через PEX
+}
+
+func (a *Announcement) payload() []byte {
+	data, _ := json.Marshal(struct {
+		NodeID     string        `json:"node_id"`
+		Addr       string        `json:"addr"`
+		PublicAddr string        `json:"public_addr,omitempty"`
+		Peers      []string      `json:"peers"`
+		NATPeers   []NATPeer     `json:"nat_peers,omitempty"`
+		IssuedAt   int64         `json:"issued_at"`
+		Caps       *Capabilities `json:"caps,omitempty"`
+	}{a.NodeID, a.Addr, a.PublicAddr, a.Peers, a.NATPeers, a.IssuedAt, a.Caps})
+	return data
+}
+
+func (a *Announcement) sign(key ed25519.PrivateKey) {
+	a.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, a.payload()))
+}
+
+// verify проверяет подпись ключом, совпадающим с NodeID, свежесть и ограничения анонса
+func (a *Announcement) verify(now time.Time) error {
+	pub, err := base64.StdEncoding.DecodeString(a.NodeID)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid node id")
+	}
+	sig, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), a.payload(), sig) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	issued := time.UnixMilli(a.IssuedAt)
+	if now.Sub(issued) > announceMaxAge || issued.Sub(now) > announceMaxAge {
+		return fmt.Errorf("stale announcement")
+	}
+	if len(a.Peers) > maxAnnouncedPeers || len(a.NATPeers) > maxAnnouncedPeers {
+		return fmt.Errorf("too many peers in announcement")
+	}
+	if len(a.Punch) > maxPendingPunch {
+		return fmt.Errorf("too many punch requests in announcement")
+	}
+	if _, _, err := net.SplitHostPort(a.Addr); err != nil {
+		return fmt.Errorf("invalid addr: %w", err)
+	}
+	if a.PublicAddr != "" {
+		if _, _, err := net.SplitHostPort(a.PublicAddr); err != nil {
+			return fmt.Errorf("invalid public addr: %w", err)
+		}
+	}
+	return nil
+}
+
+// NodeID возвращает идентификатор узла (base64 публичного ключа подписи анонсов)
+func (m *MeshTransport) NodeID() string {
+	return base64.StdEncoding.EncodeToString(m.identity.Public().(ed25519.PublicKey))
+}
+
+// ParseNodeKey разбирает ключ узла: 32-байтовый seed Ed25519 в hex
+func ParseNodeKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("node key must be a 32-byte seed in hex")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// SetIdentity задает постоянный ключ узла вместо случайного (до Connect), чтобы NodeID
+// не менялся между перезапусками
+func (m *MeshTransport) SetIdentity(key ed25519.PrivateKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identity = key
+}
+
+// Sign подписывает данные ключом узла (например, анонс mDNS); подпись проверяется ключом из NodeID
+func (m *MeshTransport) Sign(data []byte) []byte {
+	return ed25519.Sign(m.identity, data)
+}
+
+// SetGossipInterval задает период обмена списками пиров (до Connect); 0 отключает PEX
+func (m *MeshTransport) SetGossipInterval(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gossipInterval = interval
+}
+
+// Topology возвращает известную узлу топологию сети
+func (m *MeshTransport) Topology() *Topology {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topo := &Topology{NodeID: m.NodeID(), Addr: m.advertiseAddrLocked(), Peers: m.peersLocked()}
+	if m.natMapping != nil {
+		mapping := *m.natMapping
+		topo.NAT = &mapping
+	}
+	for _, info := range m.learned {
+		cp := *info
+		cp.Peers = append([]string(nil), info.Peers...)
+		topo.Learned = append(topo.Learned, &cp)
+	}
+	sort.Slice(topo.Learned, func(i, j int) bool { return topo.Learned[i].Addr < topo.Learned[j].Addr })
+	return topo
+}
+
+// runGossip периодически обменивается анонсами со случайными пирами
+func (m *MeshTransport) runGossip(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.expireLearned()
+		m.gossipRound()
+	}
+}
+
+func (m *MeshTransport) gossipRound() {
+	peers := m.GetPeers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > gossipFanout {
+		peers = peers[:gossipFanout]
+	}
+
+	for _, peer := range peers {
+		if err := m.exchangePeers(peer); err != nil {
+			log.Printf("Mesh PEX с %s не удался: %v", peer, err)
+		}
+	}
+}
+
+// Bootstrap обменивается анонсами с узлами peers и возвращает ответившие.
+// Узлы, о которых они сообщили, запоминаются как изученные через PEX.
+func (m *MeshTransport) Bootstrap(peers []string) []string {
+	var reachable []string
+	for _, peer := range peers {
+		if err := m.exchangePeers(peer); err != nil {
+			log.Printf("Mesh: bootstrap узел %s недоступен: %v", peer, err)
+			continue
+		}
+		reachable = append(reachable, peer)
+	}
+	return reachable
+}
+
+// exchangePeers отправляет пиру свой анонс и обрабатывает ответный
+func (m *MeshTransport) exchangePeers(peer string) error {
+	reply, err := m.roundTripPeer(peer, framePEX, m.announcement())
+	if err != nil {
+		return err
+	}
+	if reply.Type != framePEX {
+		return fmt.Errorf("peer does not support PEX")
+	}
+	return m.learn(reply.Payload, peer)
+}
+
+// handlePEX обрабатывает входящий анонс и возвращает свой для ответа
+func (m *MeshTransport) handlePEX(data []byte, from string) ([]byte, error) {
+	if err := m.learn(data, from); err != nil {
+		log.Printf("Mesh PEX: отклонен анонс от %s: %v", from, err)
+		return nil, err
+	}
+	return m.announcement(), nil
+}
+
+// announcement возвращает подписанный анонс узла (JSON)
+func (m *MeshTransport) announcement() []byte {
+	m.mu.Lock()
+	ann := &Announcement{
+		NodeID:   m.NodeID(),
+		Addr:     m.advertiseAddrLocked(),
+		Peers:    m.peersLocked(),
+		IssuedAt: time.Now().UnixMilli(),
+		NATPeers: m.natPeersLocked(),
+		Punch:    m.pendingPunchLocked(),
+	}
+	caps := m.capabilitiesLocked()
+	ann.Caps = &caps
+	if m.natMapping != nil && !m.natMapping.Reachable() {
+		ann.PublicAddr = m.natMapping.ExternalAddr
+	}
+	m.mu.Unlock()
+
+	if len(ann.Peers) > maxAnnouncedPeers {
+		ann.Peers = ann.Peers[:maxAnnouncedPeers]
+	}
+	ann.sign(m.identity)
+
+	data, _ := json.Marshal(ann)
+	return data
+}
+
+// learn проверяет анонс и добавляет узел и его пиров в изученную топологию
+func (m *MeshTransport) learn(data []byte, via string) error {
+	if len(data) > maxAnnounceSize {
+		return fmt.Errorf("announcement too large")
+	}
+	var ann Announcement
+	if err := json.Unmarshal(data, &ann); err != nil {
+		return fmt.Errorf("invalid announcement: %w", err)
+	}
+	if err := ann.verify(time.Now()); err != nil {
+		return err
+	}
+
+	if ann.NodeID == m.NodeID() {
+		return nil
+	}
+	if err := m.learnPeer(&ann, via); err != nil {
+		return err
+	}
+	m.handlePunchRequests(ann.Punch)
+	return nil
+}
+
+func (m *MeshTransport) learnPeer(ann *Announcement, via string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, exists := m.learned[ann.NodeID]
+	if !exists {
+		if len(m.learned) >= maxKnownPeers {
+			return fmt.Errorf("known peer limit reached")
+		}
+		info = &PeerInfo{NodeID: ann.NodeID, Fingerprint: Fingerprint(ann.NodeID)}
+		m.learned[ann.NodeID] = info
+	}
+	// Адрес, по которому NAT уже пробит, не заменяется внутренним адресом из анонса
+	if !info.Punched || info.PublicAddr != ann.PublicAddr {
+		info.Addr = ann.Addr
+		info.Punched = false
+	}
+	info.PublicAddr = ann.PublicAddr
+	info.Peers = ann.Peers
+	info.Via = via
+	info.LastSeen = time.Now()
+	info.Caps = ann.Caps
+
+	// Узлы за NAT запоминаются только по подсказке, без адреса для прямых соединений;
+	// сведения, полученные от самих узлов, не перезаписываются
+	for _, peer := range ann.NATPeers {
+		if peer.NodeID == m.NodeID() || len(m.learned) >= maxKnownPeers {
+			continue
+		}
+		if _, exists := m.learned[peer.NodeID]; exists {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(peer.PublicAddr); err != nil {
+			continue
+		}
+		m.learned[peer.NodeID] = &PeerInfo{NodeID: peer.NodeID, Fingerprint: Fingerprint(peer.NodeID), PublicAddr: peer.PublicAddr, Via: via, LastSeen: time.Now()}
+	}
+	return nil
+}
+
+// natPeersLocked возвращает известные узлы за NAT для анонса
+func (m *MeshTransport) natPeersLocked() []NATPeer {
+	var peers []NATPeer
+	for _, info := range m.learned {
+		if info.PublicAddr == "" || len(peers) >= maxAnnouncedPeers {
+			continue
+		}
+		peers = append(peers, NATPeer{NodeID: info.NodeID, PublicAddr: info.PublicAddr})
+	}
+	return peers
+}
+
+// expireLearned забывает узлы, о которых давно не было анонсов
+func (m *MeshTransport) expireLearned() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, info := range m.learned {
+		if time.Since(info.LastSeen) > learnedPeerTTL {
+			delete(m.learned, id)
+		}
+	}
+}
+
+// peersLocked возвращает пиры для отправки: заданные (mDNS, статические), узлы, приславшие
+// анонс, и пиры из их анонсов. Узлы с несовместимой версией протокола пропускаются.
+// Общее число ограничено maxKnownPeers.
+func (m *MeshTransport) peersLocked() []string {
+	own := m.advertiseAddrLocked()
+	seen := make(map[string]bool, len(m.peers)+len(m.learned))
+	peers := make([]string, 0, len(m.peers)+len(m.learned))
+
+	// Адреса несовместимых узлов не используются, откуда бы они ни были известны
+	for _, info := range m.learned {
+		if info.Caps != nil && !info.Caps.Compatible() {
+			seen[info.Addr] = true
+		}
+	}
+
+	add := func(addr string) {
+		if addr == "" || addr == own || seen[addr] {
+			return
+		}
+		seen[addr] = true
+		peers = append(peers, addr)
+	}
+	for _, p := range m.peers {
+		add(p)
+	}
+	for _, info := range m.learned {
+		add(info.Addr)
+	}
+	for _, info := range m.learned {
+		for _, p := range info.Peers {
+			if len(peers) >= maxKnownPeers {
+				return peers
+			}
+			add(p)
+		}
+	}
+	return peers
+}
+
+// advertiseAddrLocked - адрес, который узел сообщает о себе в анонсах: заданный вручную,
+// внешний адрес проброшенного порта или локальный IP и порт слушателя
+func (m *MeshTransport) advertiseAddrLocked() string {
+	if m.advertiseAddr != "" {
+		return m.advertiseAddr
+	}
+	if m.listener == nil {
+		return ""
+	}
+	if m.natMapping != nil && m.natMapping.Reachable() {
+		return m.natMapping.ExternalAddr
+	}
+	addr, ok := m.listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return m.listener.Addr().String()
+	}
+	host := m.currentIP
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+func newIdentity() ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("mesh: failed to generate node key: %v", err))
+	}
+	return key
+}
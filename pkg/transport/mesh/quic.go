@@ -0,0 +1,267 @@
+package mesh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// Режим QUIC для неустойчивых Wi-Fi сетей: UDP на том же порту, что и TCP, одно соединение
+// с пиром на все сообщения (каждое - отдельный поток, потери одного пакета не задерживают
+// остальные). Пиры без QUIC обслуживаются по TCP.
+//
+// x/net/quic не поддерживает миграцию адреса, поэтому при смене локального IP (переход между
+// точкой доступа и LAN) соединения открываются заново с нового адреса, а пиры узнают новый
+// адрес из внеочередного анонса PEX. Неподтвержденные сообщения повторяются отправителем.
+const (
+	quicALPN           = "hydra-mesh"
+	quicHandshake      = 3 * time.Second
+	quicIdleTimeout    = 30 * time.Second
+	quicKeepAlive      = 10 * time.Second
+	quicFallbackTTL    = 5 * time.Minute // сколько пир без QUIC обслуживается только по TCP
+	addressWatchPeriod = 5 * time.Second
+)
+
+// quicLinkSet - QUIC endpoint узла и открытые соединения с пирами
+type quicLinkSet struct {
+	endpoint *quic.Endpoint
+	config   *quic.Config
+
+	mu      sync.Mutex
+	conns   map[string]*quic.Conn
+	tcpOnly map[string]time.Time
+}
+
+// SetQUIC включает QUIC для соединений с пирами (до Connect)
+func (m *MeshTransport) SetQUIC(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quicEnabled = enabled
+}
+
+func (m *MeshTransport) quicLinks() *quicLinkSet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.quic
+}
+
+// startQUIC открывает UDP endpoint на порту TCP слушателя и принимает входящие соединения
+func (m *MeshTransport) startQUIC(listenAddr string, port int) error {
+	cert, err := quicCertificate(m.identity)
+	if err != nil {
+		return err
+	}
+	config := &quic.Config{
+		// Пиры аутентифицируются подписанными анонсами, а не сертификатами
+		TLSConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS13,
+			Certificates:       []tls.Certificate{cert},
+			NextProtos:         []string{quicALPN},
+			InsecureSkipVerify: true,
+		},
+		HandshakeTimeout: quicHandshake,
+		MaxIdleTimeout:   quicIdleTimeout,
+		KeepAlivePeriod:  quicKeepAlive,
+	}
+
+	host, _, _ := net.SplitHostPort(listenAddr)
+	endpoint, err := quic.Listen("udp", net.JoinHostPort(host, strconv.Itoa(port)), config)
+	if err != nil {
+		return fmt.Errorf("failed to start mesh QUIC endpoint: %w", err)
+	}
+
+	links := &quicLinkSet{
+		endpoint: endpoint,
+		config:   config,
+		conns:    make(map[string]*quic.Conn),
+		tcpOnly:  make(map[string]time.Time),
+	}
+	m.mu.Lock()
+	m.quic = links
+	m.mu.Unlock()
+
+	go m.serveQUIC(endpoint)
+	go m.watchAddress(listenAddr)
+	return nil
+}
+
+func (m *MeshTransport) serveQUIC(endpoint *quic.Endpoint) {
+	for {
+		conn, err := endpoint.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go m.serveQUICConn(conn)
+	}
+}
+
+// serveQUICConn обрабатывает потоки соединения: каждый поток - один запрос пира
+func (m *MeshTransport) serveQUICConn(conn *quic.Conn) {
+	remote := conn.RemoteAddr().String()
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+			defer cancel()
+			stream.SetReadContext(ctx)
+			stream.SetWriteContext(ctx)
+
+			m.handleStream(stream, remote)
+			stream.Close()
+		}()
+	}
+}
+
+// watchAddress следит за локальным IP и при его смене переоткрывает соединения с пирами
+func (m *MeshTransport) watchAddress(listenAddr string) {
+	ticker := time.NewTicker(addressWatchPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ip, err := localIP(listenAddr)
+		if err != nil || ip == "" {
+			continue
+		}
+
+		m.mu.Lock()
+		changed := ip != m.currentIP
+		m.currentIP = ip
+		links := m.quic
+		m.mu.Unlock()
+
+		if changed {
+			log.Printf("Mesh: локальный адрес изменился на %s, соединения с пирами открываются заново", ip)
+			if links != nil {
+				links.reset()
+			}
+			go m.gossipAll()
+		}
+	}
+}
+
+// usable сообщает, стоит ли пробовать QUIC с пиром
+func (l *quicLinkSet) usable(peer string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, fallback := l.tcpOnly[peer]
+	return !fallback || time.Now().After(until)
+}
+
+// markFailed отправляет пира на TCP, если QUIC с ним не работает
+func (l *quicLinkSet) markFailed(peer string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if conn, exists := l.conns[peer]; exists {
+		conn.Abort(nil)
+		delete(l.conns, peer)
+	}
+	l.tcpOnly[peer] = time.Now().Add(quicFallbackTTL)
+	log.Printf("Mesh: QUIC с %s недоступен (%v), используется TCP", peer, err)
+}
+
+// reset закрывает все соединения; следующие запросы откроют новые
+func (l *quicLinkSet) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for peer, conn := range l.conns {
+		conn.Abort(nil)
+		delete(l.conns, peer)
+	}
+	l.tcpOnly = make(map[string]time.Time)
+}
+
+// roundTrip отправляет кадр в новом потоке соединения с пиром. Разорванное соединение
+// (например, после простоя или смены адреса пира) переоткрывается один раз.
+func (l *quicLinkSet) roundTrip(peer string, typ byte, payload []byte) (*frame, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+	defer cancel()
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := l.conn(ctx, peer)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := conn.NewStream(ctx)
+		if err != nil {
+			l.drop(peer, conn)
+			lastErr = err
+			continue
+		}
+		stream.SetReadContext(ctx)
+		stream.SetWriteContext(ctx)
+
+		reply, err := roundTrip(stream, typ, payload)
+		stream.Close()
+		if err == nil || errors.Is(err, context.DeadlineExceeded) {
+			return reply, err
+		}
+		l.drop(peer, conn)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (l *quicLinkSet) conn(ctx context.Context, peer string) (*quic.Conn, error) {
+	l.mu.Lock()
+	conn, exists := l.conns[peer]
+	l.mu.Unlock()
+	if exists {
+		return conn, nil
+	}
+
+	conn, err := l.endpoint.Dial(ctx, "udp", peer, l.config)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if existing, exists := l.conns[peer]; exists {
+		conn.Abort(nil)
+		return existing, nil
+	}
+	l.conns[peer] = conn
+	return conn, nil
+}
+
+func (l *quicLinkSet) drop(peer string, conn *quic.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[peer] == conn {
+		delete(l.conns, peer)
+	}
+	conn.Abort(nil)
+}
+
+// quicCertificate создает самоподписанный сертификат на ключе узла
+func quicCertificate(key ed25519.PrivateKey) (tls.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create mesh QUIC certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
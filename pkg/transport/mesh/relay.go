@@ -0,0 +1,254 @@
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Формат конверта mesh сообщения: magic | ID (16 байт) | TTL (1 байт) | данные.
+// Соединения без magic считаются прямой отправкой старых клиентов и не ретранслируются.
+var envelopeMagic = []byte("HYM1")
+
+const (
+	messageIDSize  = 16
+	envelopeHeader = 4 + messageIDSize + 1
+
+	// DefaultTTL - число переходов, которое сообщение проходит по mesh сети
+	DefaultTTL = 4
+	// seenTTL - сколько помнить ID сообщений для защиты от петель
+	seenTTL = 10 * time.Minute
+)
+
+// envelope - сообщение mesh сети с ограничением числа переходов
+type envelope struct {
+	ID   [messageIDSize]byte
+	TTL  uint8
+	Data []byte
+}
+
+func newEnvelope(data []byte, ttl uint8) (*envelope, error) {
+	env := &envelope{TTL: ttl, Data: data}
+	if _, err := rand.Read(env.ID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate message id: %w", err)
+	}
+	return env, nil
+}
+
+func (e *envelope) marshal() []byte {
+	buf := make([]byte, 0, envelopeHeader+len(e.Data))
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, e.ID[:]...)
+	buf = append(buf, e.TTL)
+	return append(buf, e.Data...)
+}
+
+// parseEnvelope разбирает конверт; ok=false означает сообщение без конверта
+func parseEnvelope(raw []byte) (env *envelope, ok bool) {
+	if len(raw) < envelopeHeader || !bytes.Equal(raw[:len(envelopeMagic)], envelopeMagic) {
+		return nil, false
+	}
+	env = &envelope{TTL: raw[envelopeHeader-1], Data: raw[envelopeHeader:]}
+	copy(env.ID[:], raw[len(envelopeMagic):])
+	return env, true
+}
+
+// seenCache помнит ID недавно обработанных сообщений
+type seenCache struct {
+	mu    sync.Mutex
+	items map[[messageIDSize]byte]time.Time
+}
+
+func newSeenCache() *seenCache {
+	return &seenCache{items: make(map[[messageIDSize]byte]time.Time)}
+}
+
+// add отмечает сообщение как обработанное; возвращает false, если оно уже встречалось
+func (c *seenCache) add(id [messageIDSize]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if seenAt, exists := c.items[id]; exists && now.Sub(seenAt) < seenTTL {
+		return false
+	}
+	c.items[id] = now
+
+	for key, seenAt := range c.items {
+		if now.Sub(seenAt) >= seenTTL {
+			delete(c.items, key)
+		}
+	}
+	return true
+}
+
+// OnMessage задает обработчик сообщений, полученных из mesh сети (напрямую или через ретрансляцию)
+func (m *MeshTransport) OnMessage(handler func(data []byte)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onMessage = handler
+}
+
+// SetTTL задает число переходов для исходящих сообщений
+func (m *MeshTransport) SetTTL(ttl uint8) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+}
+
+// serve принимает входящие соединения пиров
+func (m *MeshTransport) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.handleConn(conn)
+	}
+}
+
+func (m *MeshTransport) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(frameTimeout))
+	m.handleStream(conn, conn.RemoteAddr().String())
+}
+
+// handleStream обрабатывает один запрос пира (соединение TCP или поток QUIC)
+func (m *MeshTransport) handleStream(rw io.ReadWriter, remote string) {
+	r := bufio.NewReader(rw)
+	head, err := r.Peek(4)
+	if err != nil {
+		return
+	}
+	if isLegacyFrame(head) {
+		m.handleLegacy(rw, r, remote)
+		return
+	}
+
+	f, err := readFrame(r)
+	if err != nil {
+		if errors.Is(err, ErrChecksum) {
+			writeFrame(rw, frameNack, []byte(err.Error()))
+		}
+		return
+	}
+
+	switch f.Type {
+	case frameMessage:
+		env, ok := parseEnvelope(f.Payload)
+		if !ok {
+			writeFrame(rw, frameNack, []byte("invalid envelope"))
+			return
+		}
+		m.accept(env)
+		writeFrame(rw, frameAck, nil)
+
+	case framePEX:
+		reply, err := m.handlePEX(f.Payload, remote)
+		if err != nil {
+			writeFrame(rw, frameNack, []byte(err.Error()))
+			return
+		}
+		writeFrame(rw, framePEX, reply)
+
+	case frameDTNSummary:
+		reply, err := m.handleDTNSummary(f.Payload)
+		if err != nil {
+			writeFrame(rw, frameNack, []byte(err.Error()))
+			return
+		}
+		writeFrame(rw, frameDTNSummary, reply)
+
+	case frameBundle:
+		if err := m.handleBundles(f.Payload); err != nil {
+			writeFrame(rw, frameNack, []byte(err.Error()))
+			return
+		}
+		writeFrame(rw, frameAck, nil)
+
+	case frameRelay:
+		reply, err := m.handleRelay(f.Payload)
+		if err != nil {
+			writeFrame(rw, frameNack, []byte(err.Error()))
+			return
+		}
+		writeFrame(rw, reply.Type, reply.Payload)
+
+	default:
+		writeFrame(rw, frameNack, []byte(fmt.Sprintf("unknown frame type %d", f.Type)))
+	}
+}
+
+// handleLegacy обрабатывает соединение узла без кадров: данные читаются до закрытия записи
+func (m *MeshTransport) handleLegacy(w io.Writer, r io.Reader, remote string) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxReplySize+envelopeHeader))
+	if err != nil || len(raw) == 0 {
+		return
+	}
+
+	if bytes.HasPrefix(raw, pexMagic) {
+		if reply, err := m.handlePEX(raw[len(pexMagic):], remote); err == nil {
+			w.Write(append(append([]byte{}, pexMagic...), reply...))
+		}
+		return
+	}
+
+	env, ok := parseEnvelope(raw)
+	if !ok {
+		m.deliver(raw)
+		return
+	}
+	m.accept(env)
+}
+
+// accept доставляет сообщение из конверта и передает его дальше, пока не исчерпан TTL.
+// Повторно полученные сообщения (по ID) игнорируются.
+func (m *MeshTransport) accept(env *envelope) {
+	if !m.seen.add(env.ID) {
+		return
+	}
+
+	m.deliver(env.Data)
+
+	// В режиме DTN узел с ключом сети становится переносчиком полученного сообщения
+	if m.dtnStore() != nil && !m.batterySaver() {
+		m.storeBundle(env)
+	}
+
+	if env.TTL <= 1 {
+		return
+	}
+	env.TTL--
+	m.enqueueRelay(env)
+}
+
+func (m *MeshTransport) deliver(data []byte) {
+	m.mu.Lock()
+	handler := m.onMessage
+	m.mu.Unlock()
+
+	if handler != nil {
+		handler(data)
+	}
+}
+
+// relay пересылает сообщение всем известным пирам. Отправитель и пиры, уже получившие
+// сообщение, отбрасывают его по кэшу ID, поэтому петли не возникают.
+func (m *MeshTransport) relay(env *envelope) {
+	payload := env.marshal()
+	for _, peer := range m.GetPeers() {
+		m.relayBandwidth.wait(len(payload))
+		if _, err := m.roundTripRoute(peer, frameMessage, payload); err != nil {
+			log.Printf("Mesh: не удалось ретранслировать %s к %s: %v", hex.EncodeToString(env.ID[:4]), peer, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// Узлы-ретрансляторы: узел с публичным адресом может вызваться пересылать запросы
+// узлов, которые не могут подключиться друг к другу напрямую (разные сети, фильтрация
+// входящих соединений). Такой узел отмечается в возможностях (Capabilities.RelayNode),
+// которые передаются в PEX и mDNS. Если прямое подключение к пиру не удалось, запрос
+// отправляется ретранслятору в кадре frameRelay, и тот передает его пиру от своего имени.
+// Ретранслятор пересылает только сообщения и анонсы PEX и только известным ему пирам,
+// чтобы не становиться открытым прокси.
+
+const (
+	// maxRelayAttempts - сколько ретрансляторов пробуется для одного запроса
+	maxRelayAttempts = 2
+	// relayBackoff - сколько ретранслятор после ошибки выбирается последним
+	relayBackoff = 5 * time.Minute
+)
+
+// errUnreachable - к пиру не удалось подключиться напрямую
+var errUnreachable = errors.New("peer unreachable")
+
+// relayStat - результаты запросов через ретранслятор для выбора лучшего
+type relayStat struct {
+	rtt      time.Duration // время последнего успешного запроса
+	failedAt time.Time     // время последней ошибки
+}
+
+// EnableRelayNode включает режим ретранслятора (до Connect). Узел объявляет себя
+// ретранслятором, только если его адрес для анонсов публичный и не включен режим
+// экономии батареи.
+func (m *MeshTransport) EnableRelayNode() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relayNode = true
+}
+
+// isPublicAddr сообщает, доступен ли адрес host:port из интернета. Имя хоста (а не IP),
+// заданное вручную, считается публичным.
+func isPublicAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// relayNodeLocked сообщает, работает ли узел ретранслятором
+func (m *MeshTransport) relayNodeLocked() bool {
+	return m.relayNode && !m.resources.BatterySaver && isPublicAddr(m.advertiseAddrLocked())
+}
+
+// encodeRelay упаковывает запрос для ретранслятора:
+// длина адреса (1 байт) | адрес пира | тип кадра (1 байт) | данные кадра
+func encodeRelay(target string, typ byte, payload []byte) ([]byte, error) {
+	if len(target) == 0 || len(target) > 255 {
+		return nil, fmt.Errorf("invalid relay target %q", target)
+	}
+	buf := make([]byte, 0, 2+len(target)+len(payload))
+	buf = append(buf, byte(len(target)))
+	buf = append(buf, target...)
+	buf = append(buf, typ)
+	return append(buf, payload...), nil
+}
+
+func decodeRelay(data []byte) (target string, typ byte, payload []byte, err error) {
+	if len(data) < 2 || len(data) < 2+int(data[0]) {
+		return "", 0, nil, fmt.Errorf("truncated relay request")
+	}
+	n := int(data[0])
+	return string(data[1 : 1+n]), data[1+n], data[2+n:], nil
+}
+
+// handleRelay пересылает запрос из кадра frameRelay пиру и возвращает его ответ
+func (m *MeshTransport) handleRelay(data []byte) (*frame, error) {
+	target, typ, payload, err := decodeRelay(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	enabled := m.relayNodeLocked()
+	known := m.knownAddrLocked(target)
+	m.mu.Unlock()
+
+	if !enabled {
+		return nil, fmt.Errorf("not a relay node")
+	}
+	if typ != frameMessage && typ != framePEX {
+		return nil, fmt.Errorf("frame type %d is not relayed", typ)
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown relay target %s", target)
+	}
+
+	m.relayBandwidth.wait(len(payload))
+	return m.roundTripPeer(target, typ, payload)
+}
+
+// knownAddrLocked сообщает, известен ли узлу пир с адресом addr
+func (m *MeshTransport) knownAddrLocked(addr string) bool {
+	for _, peer := range m.peersLocked() {
+		if peer == addr {
+			return true
+		}
+	}
+	for _, info := range m.learned {
+		if info.PublicAddr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRelays выбирает ретрансляторы для запроса к target: сначала без недавних ошибок,
+// среди них - с меньшим временем ответа, затем еще не опробованные
+func (m *MeshTransport) selectRelays(target string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	own := m.advertiseAddrLocked()
+	now := time.Now()
+	type candidate struct {
+		addr   string
+		failed bool
+		rtt    time.Duration
+	}
+	var candidates []candidate
+	for _, info := range m.learned {
+		if info.Caps == nil || !info.Caps.RelayNode || !info.Caps.Compatible() {
+			continue
+		}
+		if info.Addr == "" || info.Addr == target || info.Addr == own {
+			continue
+		}
+		c := candidate{addr: info.Addr}
+		if stat := m.relayStats[info.Addr]; stat != nil {
+			c.failed = now.Sub(stat.failedAt) < relayBackoff
+			c.rtt = stat.rtt
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.failed != b.failed {
+			return !a.failed
+		}
+		if (a.rtt == 0) != (b.rtt == 0) {
+			return a.rtt != 0
+		}
+		if a.rtt != b.rtt {
+			return a.rtt < b.rtt
+		}
+		return a.addr < b.addr
+	})
+
+	relays := make([]string, 0, maxRelayAttempts)
+	for _, c := range candidates {
+		if len(relays) == maxRelayAttempts {
+			break
+		}
+		relays = append(relays, c.addr)
+	}
+	return relays
+}
+
+// recordRelay запоминает результат запроса через ретранслятор
+func (m *MeshTransport) recordRelay(relay string, rtt time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.relayStats == nil {
+		m.relayStats = make(map[string]*relayStat)
+	}
+	stat := m.relayStats[relay]
+	if stat == nil {
+		stat = &relayStat{}
+		m.relayStats[relay] = stat
+	}
+	if err != nil {
+		stat.failedAt = time.Now()
+		return
+	}
+	stat.rtt = rtt
+	stat.failedAt = time.Time{}
+}
+
+// roundTripRelayed отправляет кадр пиру через ретрансляторы
+func (m *MeshTransport) roundTripRelayed(target string, typ byte, payload []byte) (*frame, error) {
+	request, err := encodeRelay(target, typ, payload)
+	if err != nil {
+		return nil, err
+	}
+	relays := m.selectRelays(target)
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no relay nodes known")
+	}
+
+	var lastErr error
+	for _, relay := range relays {
+		start := time.Now()
+		reply, err := m.roundTripPeer(relay, frameRelay, request)
+		m.recordRelay(relay, time.Since(start), err)
+		if err == nil {
+			log.Printf("Mesh: %s недоступен напрямую, запрос передан через ретранслятор %s", target, relay)
+			return reply, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("relays failed: %w", lastErr)
+}
+
+// roundTripRoute отправляет кадр пиру напрямую, а если подключиться не удалось -
+// через ретрансляторы. Отказ самого пира через ретранслятор не повторяется.
+func (m *MeshTransport) roundTripRoute(peer string, typ byte, payload []byte) (*frame, error) {
+	reply, err := m.roundTripPeer(peer, typ, payload)
+	if err == nil || !errors.Is(err, errUnreachable) {
+		return reply, err
+	}
+	relayed, relayErr := m.roundTripRelayed(peer, typ, payload)
+	if relayErr != nil {
+		return nil, fmt.Errorf("%w; via relay: %v", err, relayErr)
+	}
+	return relayed, nil
+}
+
+// RelayNodes возвращает адреса известных ретрансляторов в порядке выбора
+func (m *MeshTransport) RelayNodes() []string {
+	return m.selectRelays("")
+}
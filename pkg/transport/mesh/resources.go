@@ -0,0 +1,207 @@
+package mesh
+
+import (
+	"sync"
+	"time"
+)
+
+// Ограничения ресурсов для слабых устройств (телефонов) в mesh сети: ретрансляция чужих
+// сообщений идет через очередь ограниченного размера и с ограничением полосы, а в режиме
+// экономии батареи узел только отправляет и принимает свои сообщения.
+//
+// При переполнении очереди первыми отбрасываются сообщения с наименьшим оставшимся TTL:
+// они уже прошли больше переходов и, вероятнее всего, доставлены другими путями.
+
+// DefaultMaxQueueBytes - память очереди ретрансляции по умолчанию
+const DefaultMaxQueueBytes = 8 << 20
+
+// ResourceLimits - ограничения ресурсов, расходуемых на ретрансляцию
+type ResourceLimits struct {
+	MaxQueueBytes     int  `json:"max_queue_bytes"`     // память очереди ретрансляции (0 - DefaultMaxQueueBytes)
+	MaxRelayBandwidth int  `json:"max_relay_bandwidth"` // байт в секунду на ретрансляцию (0 - без ограничения)
+	BatterySaver      bool `json:"battery_saver"`       // не ретранслировать и не переносить чужие сообщения
+}
+
+// ResourceStatus - ограничения и текущее использование ресурсов
+type ResourceStatus struct {
+	ResourceLimits
+	QueuedMessages int   `json:"queued_messages"`
+	QueuedBytes    int   `json:"queued_bytes"`
+	RelayedBytes   int64 `json:"relayed_bytes"`  // передано при ретрансляции с запуска
+	DroppedRelays  int64 `json:"dropped_relays"` // сообщений отброшено из-за переполнения очереди
+	SkippedRelays  int64 `json:"skipped_relays"` // не ретранслировано в режиме экономии батареи
+}
+
+type relayItem struct {
+	env  *envelope
+	size int
+}
+
+// relayQueue - очередь ретрансляции с приоритетом по оставшемуся TTL
+type relayQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []relayItem // по убыванию TTL, внутри одного TTL - в порядке поступления
+	bytes   int
+	limit   int
+	dropped int64
+	started bool
+}
+
+func newRelayQueue(limit int) *relayQueue {
+	q := &relayQueue{limit: limit}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push ставит сообщение в очередь. Если памяти не хватает, вытесняются сообщения с меньшим
+// TTL; если таких нет, сообщение отбрасывается. Возвращает true, если нужно запустить обработчик.
+func (q *relayQueue) push(env *envelope) (start bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := envelopeHeader + len(env.Data)
+	for q.bytes+size > q.limit {
+		last := len(q.items) - 1
+		if last < 0 || q.items[last].env.TTL >= env.TTL {
+			q.dropped++
+			return false
+		}
+		q.bytes -= q.items[last].size
+		q.items = q.items[:last]
+		q.dropped++
+	}
+
+	pos := len(q.items)
+	for pos > 0 && q.items[pos-1].env.TTL < env.TTL {
+		pos--
+	}
+	q.items = append(q.items, relayItem{})
+	copy(q.items[pos+1:], q.items[pos:])
+	q.items[pos] = relayItem{env: env, size: size}
+	q.bytes += size
+	q.cond.Signal()
+
+	start = !q.started
+	q.started = true
+	return start
+}
+
+// pop ждет и возвращает сообщение с наибольшим TTL
+func (q *relayQueue) pop() *envelope {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 {
+		q.cond.Wait()
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	q.bytes -= item.size
+	return item.env
+}
+
+func (q *relayQueue) setLimit(limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = limit
+}
+
+// bandwidth - ограничитель полосы ретрансляции (token bucket в байтах)
+type bandwidth struct {
+	mu     sync.Mutex
+	rate   float64 // байт в секунду; 0 - без ограничения
+	tokens float64
+	last   time.Time
+	sent   int64
+}
+
+// wait блокирует, пока не накопится бюджет на передачу n байт. Сообщение больше
+// секундного бюджета передается, когда бюджет полон, с уходом в долг.
+func (b *bandwidth) wait(n int) {
+	b.mu.Lock()
+	b.sent += int64(n)
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	var delay time.Duration
+	if need := min(float64(n), b.rate); b.tokens < need {
+		delay = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	b.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// SetResourceLimits задает ограничения ресурсов на ретрансляцию
+func (m *MeshTransport) SetResourceLimits(limits ResourceLimits) {
+	if limits.MaxQueueBytes <= 0 {
+		limits.MaxQueueBytes = DefaultMaxQueueBytes
+	}
+
+	m.mu.Lock()
+	m.resources = limits
+	m.mu.Unlock()
+
+	m.relayQueue.setLimit(limits.MaxQueueBytes)
+	m.relayBandwidth.mu.Lock()
+	m.relayBandwidth.rate = float64(limits.MaxRelayBandwidth)
+	m.relayBandwidth.mu.Unlock()
+}
+
+// ResourceStatus возвращает ограничения и текущее использование ресурсов
+func (m *MeshTransport) ResourceStatus() ResourceStatus {
+	m.mu.Lock()
+	status := ResourceStatus{ResourceLimits: m.resources, SkippedRelays: m.skippedRelays}
+	m.mu.Unlock()
+
+	m.relayQueue.mu.Lock()
+	status.QueuedMessages = len(m.relayQueue.items)
+	status.QueuedBytes = m.relayQueue.bytes
+	status.DroppedRelays = m.relayQueue.dropped
+	m.relayQueue.mu.Unlock()
+
+	m.relayBandwidth.mu.Lock()
+	status.RelayedBytes = m.relayBandwidth.sent
+	m.relayBandwidth.mu.Unlock()
+	return status
+}
+
+// batterySaver сообщает, включен ли режим экономии батареи
+func (m *MeshTransport) batterySaver() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resources.BatterySaver
+}
+
+// enqueueRelay ставит сообщение в очередь ретрансляции
+func (m *MeshTransport) enqueueRelay(env *envelope) {
+	if m.batterySaver() {
+		m.mu.Lock()
+		m.skippedRelays++
+		m.mu.Unlock()
+		return
+	}
+	if m.relayQueue.push(env) {
+		go m.runRelay()
+	}
+}
+
+// runRelay ретранслирует сообщения из очереди по одному
+func (m *MeshTransport) runRelay() {
+	for {
+		m.relay(m.relayQueue.pop())
+	}
+}
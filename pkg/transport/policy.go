@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// Виды трафика, для которых задается политика использования транспортов
+const (
+	TrafficCalls    = "calls"
+	TrafficMessages = "messages"
+)
+
+// Пути медиа звонка (ICE). Используются в политике наравне с именами транспортов.
+const (
+	PathDirect = "direct" // прямое соединение (host/srflx кандидаты)
+	PathTURN   = "turn"   // через TURN relay
+)
+
+// Policy определяет, через какие транспорты разрешено передавать каждый вид трафика.
+// Например, медиа звонков никогда не пускается через недоверенные mesh-ретрансляторы.
+// Пустой список для вида трафика разрешает все транспорты.
+// Окна отключения (Blackout) дополнительно запрещают транспорты по расписанию.
+type Policy struct {
+	allowed map[string]map[string]bool
+
+	mu        sync.RWMutex
+	blackouts []Blackout
+	now       func() time.Time
+}
+
+// NewPolicy создает политику из списков разрешенных транспортов для звонков и сообщений
+func NewPolicy(calls, messages []string) *Policy {
+	p := &Policy{allowed: make(map[string]map[string]bool), now: time.Now}
+	p.set(TrafficCalls, calls)
+	p.set(TrafficMessages, messages)
+	return p
+}
+
+func (p *Policy) set(traffic string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	p.allowed[traffic] = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.allowed[traffic][name] = true
+	}
+}
+
+// Allows сообщает, разрешен ли транспорт (или путь медиа) name для вида трафика.
+// nil политика разрешает все.
+func (p *Policy) Allows(traffic, name string) bool {
+	if p == nil {
+		return true
+	}
+	allowed, restricted := p.allowed[traffic]
+	if restricted && !allowed[name] {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := p.now()
+	for i := range p.blackouts {
+		if !p.blackouts[i].covers(traffic, name) {
+			continue
+		}
+		if active, _ := p.blackouts[i].ActiveAt(now); active {
+			return false
+		}
+	}
+	return true
+}
+
+// SetBlackouts заменяет окна отключения транспортов
+func (p *Policy) SetBlackouts(blackouts []Blackout) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blackouts = append([]Blackout(nil), blackouts...)
+}
+
+// Blackouts возвращает заданные окна отключения
+func (p *Policy) Blackouts() []Blackout {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Blackout(nil), p.blackouts...)
+}
+
+// ActiveBlackouts возвращает окна, действующие сейчас для вида трафика (пустой traffic - для любого)
+func (p *Policy) ActiveBlackouts(traffic string) []ActiveBlackout {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := p.now()
+	var active []ActiveBlackout
+	for _, b := range p.blackouts {
+		if traffic != "" && b.Traffic != "" && b.Traffic != traffic {
+			continue
+		}
+		if ok, until := b.ActiveAt(now); ok {
+			active = append(active, ActiveBlackout{Blackout: b, Until: until})
+		}
+	}
+	return active
+}
@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBlackoutOvernightWindow проверяет окно, переходящее через полночь, и дни недели
+func TestBlackoutOvernightWindow(t *testing.T) {
+	blackouts, err := ParseBlackouts("messages:mesh@22:00-06:00", "UTC")
+	if err != nil {
+		t.Fatalf("ParseBlackouts: %v", err)
+	}
+	b := blackouts[0]
+	if b.Traffic != TrafficMessages || len(b.Transports) != 1 || b.Transports[0] != "mesh" {
+		t.Fatalf("unexpected blackout: %+v", b)
+	}
+
+	// Пятница 2026-10-16
+	at := func(day, hour int) time.Time { return time.Date(2026, 10, day, hour, 30, 0, 0, time.UTC) }
+	if active, _ := b.ActiveAt(at(16, 21)); active {
+		t.Error("window must not be active at 21:30")
+	}
+	active, until := b.ActiveAt(at(16, 23))
+	if !active || !until.Equal(time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("at 23:30 expected active until 06:00 next day, got %v %v", active, until)
+	}
+	if active, _ := b.ActiveAt(at(17, 5)); !active {
+		t.Error("window must still be active at 05:30")
+	}
+
+	// Окно начинается только по пятницам: в субботу 05:30 оно еще действует, в воскресенье нет
+	b.Days = []time.Weekday{time.Friday}
+	if active, _ := b.ActiveAt(at(17, 5)); !active {
+		t.Error("friday window must be active saturday 05:30")
+	}
+	if active, _ := b.ActiveAt(at(18, 5)); active {
+		t.Error("friday window must not be active sunday 05:30")
+	}
+
+	for _, spec := range []string{"mesh", "mesh@25:00-06:00", "@22:00-06:00", "video:mesh@22:00-06:00"} {
+		if _, err := ParseBlackouts(spec, ""); err == nil {
+			t.Errorf("ParseBlackouts(%q) must fail", spec)
+		}
+	}
+}
+
+// TestPolicyBlackout проверяет, что политика запрещает транспорт только внутри окна
+func TestPolicyBlackout(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	p := NewPolicy(nil, nil)
+	p.now = func() time.Time { return now }
+	p.SetBlackouts([]Blackout{{Transports: []string{"mesh"}, Traffic: TrafficMessages, Start: "11:00", End: "13:00", Timezone: "UTC"}})
+
+	if p.Allows(TrafficMessages, "mesh") {
+		t.Error("mesh must be blacked out for messages")
+	}
+	if !p.Allows(TrafficCalls, "mesh") || !p.Allows(TrafficMessages, "domain-fronting") {
+		t.Error("blackout must not affect other traffic or transports")
+	}
+	if active := p.ActiveBlackouts(TrafficMessages); len(active) != 1 || active[0].Until.Hour() != 13 {
+		t.Errorf("unexpected active blackouts: %+v", active)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if !p.Allows(TrafficMessages, "mesh") || len(p.ActiveBlackouts("")) != 0 {
+		t.Error("mesh must be allowed after the window")
+	}
+}
@@ -14,6 +14,10 @@ type Transport interface {
 	// address может быть ID получателя или специфичный для транспорта адрес.
 	Send(ctx context.Context, data []byte) error
 
+	// Exchange отправляет данные и возвращает ответ удаленной стороны
+	// (ID доставки, входящие сообщения). Пустой ответ не считается ошибкой.
+	Exchange(ctx context.Context, data []byte) ([]byte, error)
+
 	// IsAvailable проверяет, доступен ли данный транспорт в текущий момент.
 	IsAvailable() bool
 }
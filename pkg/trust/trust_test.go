@@ -0,0 +1,83 @@
+package trust
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		InviterMinLevel: Verified,
+		InviteDecay:     1,
+		PromoteAfter:    30 * 24 * time.Hour,
+		ChallengeBelow:  Invited,
+		SendPerMinute:   map[Level]int{Unknown: 10, Invited: 60},
+	}
+}
+
+func TestInviteeLevel(t *testing.T) {
+	p := testPolicy()
+
+	cases := []struct {
+		inviter    Level
+		hasInviter bool
+		want       Level
+	}{
+		{Verified, true, Invited},
+		{Invited, true, Unknown}, // доверие не распространяется от непроверенных участников
+		{Unknown, true, Unknown},
+		{Verified, false, Unknown},
+	}
+	for _, tc := range cases {
+		if got := p.InviteeLevel(tc.inviter, tc.hasInviter); got != tc.want {
+			t.Errorf("InviteeLevel(%s, %v) = %s, want %s", tc.inviter, tc.hasInviter, got, tc.want)
+		}
+	}
+
+	// Без затухания приглашенный получает уровень пригласившего
+	p.InviteDecay = 0
+	if got := p.InviteeLevel(Verified, true); got != Verified {
+		t.Errorf("Expected verified invitee without decay, got %s", got)
+	}
+}
+
+func TestEffectivePromotion(t *testing.T) {
+	p := testPolicy()
+	now := time.Now()
+
+	if got := p.Effective(Invited, now.Add(-time.Hour), now); got != Invited {
+		t.Errorf("Expected recent invitee to stay invited, got %s", got)
+	}
+	if got := p.Effective(Invited, now.Add(-31*24*time.Hour), now); got != Verified {
+		t.Errorf("Expected invitee to be promoted, got %s", got)
+	}
+	if got := p.Effective(Unknown, now.Add(-365*24*time.Hour), now); got != Unknown {
+		t.Errorf("Unknown users must not be promoted automatically, got %s", got)
+	}
+}
+
+func TestFrictionByLevel(t *testing.T) {
+	p := testPolicy()
+	if !p.NeedsChallenge(Unknown) || p.NeedsChallenge(Invited) {
+		t.Error("Expected CAPTCHA only for unknown users")
+	}
+	if p.SendLimit(Unknown) != 10 || p.SendLimit(Verified) != 0 {
+		t.Errorf("Unexpected send limits: unknown=%d verified=%d", p.SendLimit(Unknown), p.SendLimit(Verified))
+	}
+}
+
+func TestLevelJSON(t *testing.T) {
+	data, _ := json.Marshal(map[string]Level{"level": Invited})
+	if string(data) != `{"level":"invited"}` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	var decoded struct{ Level Level }
+	if err := json.Unmarshal([]byte(`{"Level":"verified"}`), &decoded); err != nil || decoded.Level != Verified {
+		t.Errorf("Failed to decode level: %v %v", decoded.Level, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Level":"admin"}`), &decoded); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
@@ -0,0 +1,266 @@
+package validate
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Проверка и нормализация данных, которые пользователь вводит при регистрации и входе:
+// телефоны приводятся к E.164, email - к адресу без имени с доменом в нижнем регистре,
+// имена очищаются от управляющих символов. Ошибки привязаны к полям запроса, чтобы
+// клиент мог показать их рядом с полем ввода.
+
+// Коды ошибок проверки
+const (
+	CodeRequired     = "required"
+	CodeInvalidPhone = "invalid_phone"
+	CodeInvalidEmail = "invalid_email"
+	CodeInvalidName  = "invalid_name"
+	CodeTooShort     = "too_short"
+	CodeTooLong      = "too_long"
+	CodeWeakPassword = "weak_password"
+)
+
+// Ограничения полей
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+	MaxNameLength     = 64
+	maxEmailLength    = 254
+	maxEmailLocal     = 64
+	minPhoneDigits    = 8
+	maxPhoneDigits    = 15 // E.164
+)
+
+// Error - ошибка проверки поля запроса; Message - текст для пользователя на английском
+// (переводится вместе с остальными сообщениями API)
+type Error struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors - ошибки проверки полей одного запроса
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Check добавляет ошибку проверки поля field; nil пропускается
+func (errs *Errors) Check(field string, err error) {
+	if err == nil {
+		return
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Code: CodeRequired, Message: err.Error()}
+	}
+	c := *e
+	c.Field = field
+	*errs = append(*errs, &c)
+}
+
+// Field привязывает ошибку проверки к полю field; nil - нет ошибки
+func Field(field string, err error) error {
+	var errs Errors
+	errs.Check(field, err)
+	return errs.Err()
+}
+
+// Err возвращает ошибки как error; nil - ошибок нет
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Phone приводит номер к E.164: +, код страны и номер без разделителей. Префикс 00
+// считается международным; номера без кода страны не принимаются.
+func Phone(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", &Error{Code: CodeRequired, Message: "Phone number is required"}
+	}
+	if rest, ok := strings.CutPrefix(value, "00"); ok {
+		value = "+" + rest
+	}
+	invalid := &Error{Code: CodeInvalidPhone, Message: "Phone number must be in international format, e.g. +15551234567"}
+	digits, ok := strings.CutPrefix(value, "+")
+	if !ok {
+		return "", invalid
+	}
+	var b strings.Builder
+	b.WriteByte('+')
+	for _, r := range digits {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", invalid
+		}
+	}
+	phone := b.String()
+	if n := len(phone) - 1; n < minPhoneDigits || n > maxPhoneDigits || phone[1] == '0' {
+		return "", invalid
+	}
+	return phone, nil
+}
+
+// Email проверяет синтаксис адреса и возвращает его с доменом в нижнем регистре.
+// Адрес с отображаемым именем ("Alice <a@example.com>") не принимается.
+func Email(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", &Error{Code: CodeRequired, Message: "Email is required"}
+	}
+	invalid := &Error{Code: CodeInvalidEmail, Message: "Email address is invalid"}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Name != "" || addr.Address != value || len(value) > maxEmailLength {
+		return "", invalid
+	}
+	at := strings.LastIndex(value, "@")
+	local, domain := value[:at], strings.ToLower(value[at+1:])
+	if len(local) > maxEmailLocal || !validDomain(domain) {
+		return "", invalid
+	}
+	return local + "@" + domain, nil
+}
+
+// validDomain проверяет домен email: не меньше двух меток из букв, цифр и дефисов
+func validDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Contact проверяет email или телефон: значение с '@' считается email
+func Contact(value string) (string, error) {
+	if strings.Contains(value, "@") {
+		return Email(value)
+	}
+	if strings.TrimSpace(value) == "" {
+		return "", &Error{Code: CodeRequired, Message: "Email or phone required"}
+	}
+	return Phone(value)
+}
+
+// Name очищает отображаемое имя: нормализация Unicode (NFC), удаление управляющих и
+// невидимых символов, схлопывание пробелов. Имя должно содержать букву.
+func Name(value string) (string, error) {
+	value = norm.NFC.String(value)
+	var b strings.Builder
+	space, letter := false, false
+	for _, r := range value {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError:
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		letter = letter || unicode.IsLetter(r)
+		b.WriteRune(r)
+	}
+	name := b.String()
+	switch {
+	case name == "":
+		return "", &Error{Code: CodeRequired, Message: "Name is required"}
+	case utf8.RuneCountInString(name) > MaxNameLength:
+		return "", &Error{Code: CodeTooLong, Message: "Name must be at most 64 characters"}
+	case !letter:
+		return "", &Error{Code: CodeInvalidName, Message: "Name must contain a letter"}
+	}
+	return name, nil
+}
+
+// commonPasswords - самые распространенные пароли, проходящие формальные правила
+var commonPasswords = map[string]bool{
+	"password1": true, "password12": true, "password123": true, "passw0rd": true,
+	"qwerty123": true, "qwerty12": true, "qwertyuiop1": true, "1q2w3e4r": true,
+	"1qaz2wsx": true, "abc12345": true, "abcd1234": true, "admin123": true,
+	"iloveyou1": true, "letmein1": true, "welcome1": true, "123qweasd": true,
+	"zaq12wsx": true, "p@ssw0rd": true, "trustno1": true, "monkey123": true,
+}
+
+// Password проверяет стойкость пароля: длина, не меньше двух классов символов (буквы,
+// цифры, прочие), не из списка распространенных и без имени или контакта
+// пользователя (hints - имя, email, телефон)
+func Password(value string, hints ...string) error {
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n == 0:
+		return &Error{Code: CodeRequired, Message: "Password is required"}
+	case n < MinPasswordLength:
+		return &Error{Code: CodeTooShort, Message: "Password must be at least 8 characters"}
+	case n > MaxPasswordLength:
+		return &Error{Code: CodeTooLong, Message: "Password must be at most 128 characters"}
+	}
+
+	var letters, digits, others bool
+	for _, r := range value {
+		switch {
+		case unicode.IsLetter(r):
+			letters = true
+		case unicode.IsDigit(r):
+			digits = true
+		default:
+			others = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{letters, digits, others} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 2 {
+		return &Error{Code: CodeWeakPassword, Message: "Password must mix letters with digits or symbols"}
+	}
+
+	lower := strings.ToLower(value)
+	if commonPasswords[lower] {
+		return &Error{Code: CodeWeakPassword, Message: "Password is too common"}
+	}
+	for _, hint := range hints {
+		// Для email сравнивается имя ящика, для телефона - цифры без +
+		hint, _, _ = strings.Cut(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(hint), "+")), "@")
+		if utf8.RuneCountInString(hint) >= 4 && strings.Contains(lower, hint) {
+			return &Error{Code: CodeWeakPassword, Message: "Password must not contain your name, email or phone"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPhone(t *testing.T) {
+	for in, want := range map[string]string{
+		"+1 (555) 123-4567":  "+15551234567",
+		"0049 30 1234567":    "+49301234567",
+		" +7.916.123.45.67 ": "+79161234567",
+		"+15550100":          "+15550100",
+		"5551234567":         "",
+		"+0123456789":        "",
+		"+1555":              "",
+		"+1234567890123456":  "",
+		"+1555123456x7":      "",
+		"":                   "",
+	} {
+		got, err := Phone(in)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("Phone(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"Alice@Example.COM":          "Alice@example.com",
+		" bob.smith+tag@mail.co.uk ": "bob.smith+tag@mail.co.uk",
+		"user@пример.рф":             "user@пример.рф",
+		"Alice <alice@example.com>":  "",
+		"alice@localhost":            "",
+		"alice@-example.com":         "",
+		"alice@exa_mple.com":         "",
+		"alice.example.com":          "",
+		"a b@example.com":            "",
+	} {
+		got, err := Email(in)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("Email(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	for in, want := range map[string]string{
+		"  Алиса   Иванова ":      "Алиса Иванова",
+		"Bob\u200b\u202eevil\x00": "Bobevil",
+		"Jose\u0301":              "José",
+		"Tab\tand\nnewline":       "Tab and newline",
+		"42":                      "",
+		"\u200b":                  "",
+		"12345678901234567890123456789012345678901234567890123456789012345": "",
+	} {
+		got, err := Name(in)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("Name(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	for _, c := range []struct {
+		password string
+		hints    []string
+		code     string
+	}{
+		{"correct-horse-42", nil, ""},
+		{"Пароль2024", nil, ""},
+		{"", nil, CodeRequired},
+		{"a1b2c3", nil, CodeTooShort},
+		{"onlyletters", nil, CodeWeakPassword},
+		{"Password123", nil, CodeWeakPassword},
+		{"alice-rocks-1", []string{"Alice", "alice@example.com"}, CodeWeakPassword},
+		{"x15551234567!", []string{"+15551234567"}, CodeWeakPassword},
+		{"al-is-fine-1", []string{"Al"}, ""},
+	} {
+		err := Password(c.password, c.hints...)
+		var e *Error
+		if c.code == "" && err != nil || c.code != "" && (!errors.As(err, &e) || e.Code != c.code) {
+			t.Errorf("Password(%q): %v, want code %q", c.password, err, c.code)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	_, err := Phone("123")
+	errs.Check("phone", err)
+	errs.Check("name", nil)
+	errs.Check("password", Password("short"))
+	if len(errs) != 2 || errs[0].Field != "phone" || errs[0].Code != CodeInvalidPhone || errs[1].Code != CodeTooShort {
+		t.Fatalf("unexpected errors: %+v", errs)
+	}
+	if Errors(nil).Err() != nil || errs.Err() == nil {
+		t.Error("Err must be nil only without errors")
+	}
+}
@@ -0,0 +1,247 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Локальное хранилище клиента с двумя независимыми зашифрованными разделами: настоящим и
+// подставным. Каждый открывается своей парольной фразой, и под давлением пользователь
+// открывает подставной раздел с безобидными беседами.
+//
+// Файл - два слота одинакового размера:
+//
+//	slot: соль (16 байт) | nonce (12 байт) | AES-256-GCM(длина данных (4 байта) | данные | нули)
+//
+// Ключ слота выводится из парольной фразы и соли (Argon2id). Заголовков, версий и
+// признаков нет, поэтому слот без раздела (случайные байты) неотличим от зашифрованного:
+// по файлу нельзя сказать, один в нем раздел или два. Размер файла зависит только от
+// емкости, данные дополняются до нее нулями.
+//
+// Ограничение: раздел перезаписывает только свой слот, поэтому тот, у кого есть две копии
+// файла в разные моменты, видит, какой слот менялся.
+
+const (
+	saltSize  = 16
+	nonceSize = 12
+	tagSize   = 16
+	lenSize   = 4
+
+	slotOverhead = saltSize + nonceSize + tagSize + lenSize
+	slots        = 2
+)
+
+// Параметры Argon2id (переменные, чтобы тесты не тратили на вывод ключа секунды)
+var (
+	kdfTime    uint32 = 3
+	kdfMemory  uint32 = 64 << 10 // КиБ
+	kdfThreads uint8  = 4
+)
+
+var (
+	// ErrWrongPassphrase - ни один слот не открывается этой фразой (или файл - не хранилище)
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrTooLarge - данные не помещаются в емкость раздела
+	ErrTooLarge = errors.New("data exceeds vault capacity")
+)
+
+// Store - открытый раздел хранилища
+type Store struct {
+	mu       sync.Mutex
+	file     *os.File
+	slot     int
+	capacity int
+	salt     []byte
+	aead     cipher.AEAD
+	data     []byte
+}
+
+// Create создает файл хранилища емкостью capacity байт на раздел с настоящим разделом
+// (passphrase) и подставным (decoy). Если decoy пуст, второй слот заполняется случайными
+// байтами. Слоты разделов выбираются случайно.
+func Create(path string, capacity int, passphrase, decoy []byte) error {
+	if capacity <= 0 {
+		return fmt.Errorf("vault capacity must be positive")
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("passphrase is required")
+	}
+	if bytes.Equal(passphrase, decoy) {
+		return fmt.Errorf("decoy passphrase must differ from the real one")
+	}
+
+	var pick [1]byte
+	if _, err := rand.Read(pick[:]); err != nil {
+		return fmt.Errorf("failed to choose vault slot: %w", err)
+	}
+	realSlot := int(pick[0] & 1)
+
+	slotSize := slotOverhead + capacity
+	buf := make([]byte, slots*slotSize)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to fill vault: %w", err)
+	}
+	for slot, phrase := range map[int][]byte{realSlot: passphrase, 1 - realSlot: decoy} {
+		if len(phrase) == 0 {
+			continue
+		}
+		salt := buf[slot*slotSize : slot*slotSize+saltSize]
+		aead, err := newAEAD(phrase, salt)
+		if err != nil {
+			return err
+		}
+		sealed, err := seal(aead, slot, salt, nil, capacity)
+		if err != nil {
+			return err
+		}
+		copy(buf[slot*slotSize:], sealed)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create vault: %w", err)
+	}
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	return file.Close()
+}
+
+// Open открывает раздел, к которому подходит парольная фраза. Ключи выводятся для обоих
+// слотов, чтобы время открытия не выдавало, в каком слоте раздел.
+func Open(path string, passphrase []byte) (*Store, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrWrongPassphrase
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+	if len(raw)%slots != 0 || len(raw)/slots <= slotOverhead {
+		return nil, ErrWrongPassphrase
+	}
+	slotSize := len(raw) / slots
+
+	var store *Store
+	for slot := 0; slot < slots; slot++ {
+		sealed := raw[slot*slotSize : (slot+1)*slotSize]
+		salt := append([]byte(nil), sealed[:saltSize]...)
+		aead, err := newAEAD(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := open(aead, slot, sealed)
+		if ok && store == nil {
+			store = &Store{slot: slot, capacity: slotSize - slotOverhead, salt: salt, aead: aead, data: data}
+		}
+	}
+	if store == nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	store.file, err = os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+	return store, nil
+}
+
+// Capacity возвращает емкость раздела в байтах
+func (s *Store) Capacity() int {
+	return s.capacity
+}
+
+// Data возвращает содержимое раздела
+func (s *Store) Data() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.data...)
+}
+
+// Save заменяет содержимое раздела. Перезаписывается только слот этого раздела.
+func (s *Store) Save(data []byte) error {
+	if len(data) > s.capacity {
+		return ErrTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("vault is closed")
+	}
+	sealed, err := seal(s.aead, s.slot, s.salt, data, s.capacity)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.WriteAt(sealed, int64(s.slot*(slotOverhead+s.capacity))); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+// Close закрывает раздел
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, kdfTime, kdfMemory, kdfThreads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init vault cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal шифрует данные, дополненные нулями до capacity, в слот. Номер слота входит в AAD,
+// поэтому слоты нельзя поменять местами.
+func seal(aead cipher.AEAD, slot int, salt, data []byte, capacity int) ([]byte, error) {
+	plain := make([]byte, lenSize+capacity)
+	binary.BigEndian.PutUint32(plain, uint32(len(data)))
+	copy(plain[lenSize:], data)
+
+	out := make([]byte, saltSize+nonceSize, slotOverhead+capacity)
+	copy(out, salt)
+	if _, err := rand.Read(out[saltSize:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(out, out[saltSize:], plain, []byte{byte(slot)}), nil
+}
+
+func open(aead cipher.AEAD, slot int, sealed []byte) ([]byte, bool) {
+	nonce := sealed[saltSize : saltSize+nonceSize]
+	plain, err := aead.Open(nil, nonce, sealed[saltSize+nonceSize:], []byte{byte(slot)})
+	if err != nil {
+		return nil, false
+	}
+	size := binary.BigEndian.Uint32(plain)
+	if int(size) > len(plain)-lenSize {
+		return nil, false
+	}
+	return plain[lenSize : lenSize+int(size)], true
+}
@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	kdfTime, kdfMemory, kdfThreads = 1, 64, 1
+}
+
+func TestRealAndDecoy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault")
+	real, decoy := []byte("настоящая фраза"), []byte("подставная фраза")
+	if err := Create(path, 1024, real, decoy); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	realStore, err := Open(path, real)
+	if err != nil {
+		t.Fatalf("Open real failed: %v", err)
+	}
+	defer realStore.Close()
+	decoyStore, err := Open(path, decoy)
+	if err != nil {
+		t.Fatalf("Open decoy failed: %v", err)
+	}
+	defer decoyStore.Close()
+	if realStore.slot == decoyStore.slot {
+		t.Fatal("real and decoy stores share a slot")
+	}
+	if len(realStore.Data()) != 0 || realStore.Capacity() != 1024 {
+		t.Fatalf("new store: %d bytes, capacity %d", len(realStore.Data()), realStore.Capacity())
+	}
+
+	if err := realStore.Save([]byte("секретная переписка")); err != nil {
+		t.Fatalf("Save real failed: %v", err)
+	}
+	if err := decoyStore.Save([]byte("список покупок")); err != nil {
+		t.Fatalf("Save decoy failed: %v", err)
+	}
+	if err := realStore.Save(make([]byte, 1025)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized save: %v", err)
+	}
+
+	for phrase, want := range map[string]string{string(real): "секретная переписка", string(decoy): "список покупок"} {
+		store, err := Open(path, []byte(phrase))
+		if err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+		if got := string(store.Data()); got != want {
+			t.Errorf("reopened store has %q, want %q", got, want)
+		}
+		store.Close()
+	}
+
+	if _, err := Open(path, []byte("чужая фраза")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+}
+
+// TestDecoyOnlyIndistinguishable проверяет, что хранилище с одним разделом устроено так же,
+// как с двумя, а сохранение подставного раздела не трогает другой слот
+func TestDecoyOnlyIndistinguishable(t *testing.T) {
+	dir := t.TempDir()
+	single, double := filepath.Join(dir, "single"), filepath.Join(dir, "double")
+	if err := Create(single, 256, []byte("единственная"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Create(double, 256, []byte("настоящая"), []byte("подставная")); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := os.ReadFile(single)
+	b, _ := os.ReadFile(double)
+	if len(a) != len(b) {
+		t.Fatalf("vault sizes differ: %d and %d", len(a), len(b))
+	}
+
+	decoy, err := Open(double, []byte("подставная"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoy.Close()
+	before, _ := os.ReadFile(double)
+	if err := decoy.Save(bytes.Repeat([]byte("x"), 200)); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(double)
+	slotSize := len(after) / slots
+	other := 1 - decoy.slot
+	if !bytes.Equal(before[other*slotSize:(other+1)*slotSize], after[other*slotSize:(other+1)*slotSize]) {
+		t.Error("saving the decoy store modified the other slot")
+	}
+
+	store, err := Open(double, []byte("настоящая"))
+	if err != nil {
+		t.Fatalf("real store lost after decoy save: %v", err)
+	}
+	store.Close()
+}
+
+func TestCreateValidation(t *testing.T) {
+	dir := t.TempDir()
+	if err := Create(filepath.Join(dir, "a"), 64, []byte("same"), []byte("same")); err == nil {
+		t.Error("identical passphrases accepted")
+	}
+	if err := Create(filepath.Join(dir, "b"), 64, nil, []byte("decoy")); err == nil {
+		t.Error("empty passphrase accepted")
+	}
+	path := filepath.Join(dir, "c")
+	if err := Create(path, 64, []byte("one"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Create(path, 64, []byte("two"), nil); err == nil {
+		t.Error("existing vault overwritten")
+	}
+}
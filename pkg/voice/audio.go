@@ -0,0 +1,416 @@
+package voice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+// ErrUnknownFormat - контейнер аудио не распознан
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// AudioInfo - параметры аудио из метаданных контейнера
+type AudioInfo struct {
+	Format   string  // MIME-тип контейнера: audio/ogg, audio/webm, audio/mpeg
+	Codec    string  // opus, vorbis, mp3
+	Duration float64 // Длительность в секундах
+	Bitrate  int     // Средний битрейт, бит/с
+}
+
+// Probe определяет контейнер аудио и вычисляет длительность по его метаданным:
+// гранулам страниц Ogg, меткам времени блоков WebM или кадрам MP3
+func Probe(data []byte) (*AudioInfo, error) {
+	var info *AudioInfo
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		info, err = probeOgg(data)
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		info, err = probeWebM(data)
+	default:
+		info, err = probeMP3(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Duration <= 0 {
+		return nil, errors.New("audio has no samples")
+	}
+	if info.Bitrate == 0 {
+		info.Bitrate = int(math.Round(float64(len(data)) * 8 / info.Duration))
+	}
+	return info, nil
+}
+
+// probeOgg читает Ogg с Opus или Vorbis. Гранула последней страницы потока - число
+// сэмплов от начала (для Opus - на частоте 48 кГц, включая pre-skip).
+func probeOgg(data []byte) (*AudioInfo, error) {
+	info := &AudioInfo{Format: "audio/ogg"}
+	var serial uint32
+	var rate float64
+	var preSkip, granule int64
+	first := true
+	for pos := 0; pos+27 <= len(data) && bytes.Equal(data[pos:pos+4], []byte("OggS")); {
+		segments := int(data[pos+26])
+		if pos+27+segments > len(data) {
+			break
+		}
+		size := 0
+		for _, n := range data[pos+27 : pos+27+segments] {
+			size += int(n)
+		}
+		body := data[pos+27+segments:]
+		if len(body) < size {
+			break // обрезанная последняя страница
+		}
+		body = body[:size]
+		pageSerial := binary.LittleEndian.Uint32(data[pos+14:])
+		pageGranule := int64(binary.LittleEndian.Uint64(data[pos+6:]))
+
+		if first {
+			first = false
+			serial = pageSerial
+			switch {
+			case len(body) >= 19 && bytes.HasPrefix(body, []byte("OpusHead")):
+				info.Codec, rate = "opus", 48000
+				preSkip = int64(binary.LittleEndian.Uint16(body[10:]))
+			case len(body) >= 30 && bytes.HasPrefix(body, []byte("\x01vorbis")):
+				info.Codec, rate = "vorbis", float64(binary.LittleEndian.Uint32(body[12:]))
+				// Номинальный битрейт; 0 - не задан
+				if bitrate := int32(binary.LittleEndian.Uint32(body[20:])); bitrate > 0 {
+					info.Bitrate = int(bitrate)
+				}
+			default:
+				return nil, ErrUnknownFormat
+			}
+			if rate == 0 {
+				return nil, errors.New("invalid ogg sample rate")
+			}
+		} else if pageSerial == serial && pageGranule > 0 {
+			// -1 - на странице не заканчивается ни один пакет
+			granule = pageGranule
+		}
+		pos += 27 + segments + size
+	}
+	if first {
+		return nil, ErrUnknownFormat
+	}
+	info.Duration = float64(granule-preSkip) / rate
+	return info, nil
+}
+
+// Элементы Matroska (WebM), нужные для вычисления длительности
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlTrackNumber   = 0xD7
+	ebmlCodecID       = 0x86
+	ebmlCluster       = 0x1F43B675
+	ebmlTimecode      = 0xE7
+	ebmlBlockGroup    = 0xA0
+	ebmlBlock         = 0xA1
+	ebmlSimpleBlock   = 0xA3
+	ebmlDocType       = 0x4282
+	ebmlHeader        = 0x1A45DFA3
+)
+
+// ebmlMasters - элементы, внутрь которых заходит разбор WebM
+var ebmlMasters = map[uint64]bool{
+	ebmlHeader: true, ebmlSegment: true, ebmlInfo: true, ebmlTracks: true, ebmlTrackEntry: true,
+	ebmlCluster: true, ebmlBlockGroup: true,
+}
+
+// probeWebM читает WebM. MediaRecorder в браузерах пишет поток без Duration и с
+// неизвестными размерами Segment и Cluster, поэтому элементы-контейнеры не
+// пропускаются, а разбираются подряд, и длительность берется из метки времени
+// последнего блока дорожки плюс длительность его пакета. Duration из Info - только
+// если блоков нет.
+func probeWebM(data []byte) (*AudioInfo, error) {
+	info := &AudioInfo{Format: "audio/webm"}
+	scale := 1000000.0 // TimecodeScale по умолчанию - 1 мс
+	var declared, end float64
+	var cluster int64
+	var track, entryTrack uint64
+	var entryCodec string
+
+	for pos := 0; pos < len(data); {
+		id, n := readVint(data[pos:], false)
+		if n == 0 {
+			break
+		}
+		size, m := readVint(data[pos+n:], true)
+		if m == 0 {
+			break
+		}
+		pos += n + m
+		if ebmlMasters[id] {
+			if id == ebmlTrackEntry {
+				entryTrack, entryCodec = 0, ""
+			}
+			continue
+		}
+		if size > uint64(len(data)-pos) {
+			break // обрезанный или неизвестного размера элемент с данными
+		}
+		body := data[pos : pos+int(size)]
+		pos += int(size)
+
+		switch id {
+		case ebmlDocType:
+			if string(body) != "webm" && string(body) != "matroska" {
+				return nil, ErrUnknownFormat
+			}
+		case ebmlTimecodeScale:
+			if v := readUint(body); v > 0 {
+				scale = float64(v)
+			}
+		case ebmlDuration:
+			declared = readFloat(body)
+		case ebmlTrackNumber:
+			entryTrack = readUint(body)
+		case ebmlCodecID:
+			entryCodec = string(body)
+		case ebmlTimecode:
+			cluster = int64(readUint(body))
+		case ebmlSimpleBlock, ebmlBlock:
+			blockTrack, k := readVint(body, true)
+			if k == 0 || len(body) < k+3 || track == 0 || blockTrack != track {
+				continue
+			}
+			at := float64(cluster+int64(int16(binary.BigEndian.Uint16(body[k:])))) * scale / 1e9
+			if info.Codec == "opus" {
+				at += opusPacketDuration(body[k+3:])
+			}
+			end = math.Max(end, at)
+		}
+		// Берется первая дорожка Opus или Vorbis
+		if track == 0 && entryTrack != 0 && (entryCodec == "A_OPUS" || entryCodec == "A_VORBIS") {
+			info.Codec = strings.ToLower(strings.TrimPrefix(entryCodec, "A_"))
+			track = entryTrack
+		}
+	}
+	if info.Codec == "" {
+		return nil, ErrUnknownFormat
+	}
+	info.Duration = end
+	if end == 0 {
+		info.Duration = declared * scale / 1e9
+	}
+	return info, nil
+}
+
+// readVint читает целое переменной длины EBML. Для размеров маркер длины снимается и
+// неизвестный размер (все биты значения - единицы) возвращается как MaxUint64.
+func readVint(data []byte, size bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if len(data) < n {
+		return 0, 0
+	}
+	value := uint64(data[0])
+	if size {
+		value &= uint64(0xFF >> n)
+	}
+	unknown := value == uint64(0xFF>>n)
+	for _, b := range data[1:n] {
+		value = value<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+	if size && unknown {
+		return math.MaxUint64, n
+	}
+	return value, n
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func readFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// opusPacketDuration возвращает длительность пакета Opus в секундах по его TOC (RFC 6716, 3.1)
+func opusPacketDuration(packet []byte) float64 {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame float64
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60 мс
+		frame = []float64{0.01, 0.02, 0.04, 0.06}[config%4]
+	case config < 16: // Hybrid: 10, 20 мс
+		frame = []float64{0.01, 0.02}[config%2]
+	default: // CELT: 2.5, 5, 10, 20 мс
+		frame = []float64{0.0025, 0.005, 0.01, 0.02}[config%4]
+	}
+	switch packet[0] & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+	if len(packet) < 2 {
+		return 0
+	}
+	return float64(packet[1]&0x3F) * frame
+}
+
+// Таблицы заголовка кадра MP3 (ISO 11172-3, ISO 13818-3)
+var (
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // зарезервировано
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+	// Битрейты в кбит/с: [MPEG 1][слой], индекс 0 - свободный формат, 15 - недопустимый
+	mp3Bitrates = [2][4][16]int{
+		{ // MPEG 2 и 2.5
+			{},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},      // Layer III
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},      // Layer II
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0}, // Layer I
+		},
+		{ // MPEG 1
+			{},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},     // Layer III
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},    // Layer II
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0}, // Layer I
+		},
+	}
+)
+
+// mp3Frame - разобранный заголовок кадра MPEG Audio
+type mp3Frame struct {
+	size       int
+	samples    int
+	sampleRate int
+	sideInfo   int // размер side info Layer III - после него идет заголовок Xing/Info
+}
+
+func parseMP3Frame(header []byte) (mp3Frame, bool) {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := header[1] >> 3 & 3
+	layer := header[1] >> 1 & 3
+	bitrateIndex := header[2] >> 4
+	rateIndex := header[2] >> 2 & 3
+	if version == 1 || layer == 0 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	mpeg1 := 0
+	if version == 3 {
+		mpeg1 = 1
+	}
+	bitrate := mp3Bitrates[mpeg1][layer][bitrateIndex] * 1000
+	if bitrate == 0 {
+		return mp3Frame{}, false
+	}
+	f := mp3Frame{sampleRate: mp3SampleRates[version][rateIndex]}
+	padding := int(header[2] >> 1 & 1)
+	switch layer {
+	case 3: // Layer I
+		f.samples = 384
+		f.size = (12*bitrate/f.sampleRate + padding) * 4
+	case 2: // Layer II
+		f.samples = 1152
+		f.size = 144*bitrate/f.sampleRate + padding
+	default: // Layer III
+		f.samples = 576 + 576*mpeg1
+		f.size = f.samples/8*bitrate/f.sampleRate + padding
+		mono := header[3]>>6 == 3
+		switch {
+		case mpeg1 == 1 && mono:
+			f.sideInfo = 17
+		case mpeg1 == 1:
+			f.sideInfo = 32
+		case mono:
+			f.sideInfo = 9
+		default:
+			f.sideInfo = 17
+		}
+	}
+	return f, true
+}
+
+// probeMP3 читает MP3: пропускает тег ID3v2 и суммирует длительность кадров, что
+// верно и для переменного битрейта. Кадр Xing/Info кодировщика звука не содержит.
+func probeMP3(data []byte) (*AudioInfo, error) {
+	pos := 0
+	if len(data) >= 10 && bytes.HasPrefix(data, []byte("ID3")) {
+		pos = 10 + (int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F))
+		if data[5]&0x10 != 0 {
+			pos += 10 // футер тега
+		}
+	}
+	// Начало потока - кадр, за которым следует еще один кадр (или конец файла), чтобы
+	// не принять случайные байты за синхронизацию
+	const maxSkip = 64 << 10
+	start := -1
+	for limit := min(len(data), pos+maxSkip); pos < limit; pos++ {
+		f, ok := parseMP3Frame(data[pos:])
+		if !ok {
+			continue
+		}
+		next := pos + f.size
+		if _, ok := parseMP3Frame(data[min(next, len(data)):]); ok || next == len(data) {
+			start = pos
+			break
+		}
+	}
+	if start < 0 {
+		return nil, ErrUnknownFormat
+	}
+
+	info := &AudioInfo{Format: "audio/mpeg", Codec: "mp3"}
+	var seconds float64
+	var bytesTotal int
+	for pos = start; pos < len(data); {
+		f, ok := parseMP3Frame(data[pos:])
+		if !ok || pos+f.size > len(data) {
+			break // тег ID3v1, мусор или обрезанный кадр
+		}
+		if pos != start || !isXingFrame(data[pos:pos+f.size], f) {
+			seconds += float64(f.samples) / float64(f.sampleRate)
+			bytesTotal += f.size
+		}
+		pos += f.size
+	}
+	info.Duration = seconds
+	if seconds > 0 {
+		info.Bitrate = int(math.Round(float64(bytesTotal) * 8 / seconds))
+	}
+	return info, nil
+}
+
+func isXingFrame(frame []byte, f mp3Frame) bool {
+	if f.sideInfo == 0 || len(frame) < 4+f.sideInfo+4 {
+		return false
+	}
+	tag := string(frame[4+f.sideInfo : 4+f.sideInfo+4])
+	return tag == "Xing" || tag == "Info"
+}
@@ -0,0 +1,170 @@
+package voice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+// oggPage собирает страницу Ogg с одним пакетом (контрольная сумма не проверяется)
+func oggPage(granule int64, packet []byte) []byte {
+	page := []byte("OggS\x00\x00")
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, 7) // serial
+	page = binary.LittleEndian.AppendUint32(page, 0) // sequence
+	page = binary.LittleEndian.AppendUint32(page, 0) // crc
+	var lacing []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			lacing = append(lacing, byte(n))
+			break
+		}
+		lacing = append(lacing, 255)
+	}
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, packet...)
+}
+
+// ebml собирает элемент EBML; size < 0 - неизвестный размер
+func ebml(id uint64, size int, body ...[]byte) []byte {
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	data := bytes.Join(body, nil)
+	if size < 0 {
+		out = append(out, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	} else {
+		out = append(out, 0x08)
+		out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	}
+	return append(out, data...)
+}
+
+func simpleBlock(track byte, timecode int16, toc byte) []byte {
+	block := []byte{0x80 | track}
+	block = binary.BigEndian.AppendUint16(block, uint16(timecode))
+	return ebml(ebmlSimpleBlock, 0, append(block, 0x80, toc, 0x11, 0x22))
+}
+
+func mp3Frames(header []byte, size, count int) []byte {
+	var out []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, size)
+		copy(frame, header)
+		out = append(out, frame...)
+	}
+	return out
+}
+
+func TestProbe(t *testing.T) {
+	opusHead := append([]byte("OpusHead\x01\x01"), 0x38, 0x01, 0x80, 0xBB, 0, 0, 0, 0, 0) // pre-skip 312
+	ogg := bytes.Join([][]byte{
+		oggPage(0, opusHead),
+		oggPage(0, []byte("OpusTags")),
+		oggPage(312+48000, bytes.Repeat([]byte{0xFC}, 300)),
+		oggPage(-1, bytes.Repeat([]byte{0xFC}, 600)),
+		oggPage(312+3*48000, bytes.Repeat([]byte{0xFC}, 100)),
+		oggPage(312+4*48000, make([]byte, 100))[:60], // обрезанная страница
+	}, nil)
+
+	vorbisHead := []byte("\x01vorbis\x00\x00\x00\x00\x01")
+	vorbisHead = binary.LittleEndian.AppendUint32(vorbisHead, 44100)
+	vorbisHead = binary.LittleEndian.AppendUint32(vorbisHead, 0)
+	vorbisHead = binary.LittleEndian.AppendUint32(vorbisHead, 96000)
+	vorbisHead = append(vorbisHead, 0, 0, 0, 0, 0xB8, 0x01)
+	vorbis := append(oggPage(0, vorbisHead), oggPage(2*44100, make([]byte, 100))...)
+
+	header := ebml(ebmlHeader, 0, ebml(ebmlDocType, 0, []byte("webm")))
+	tracks := ebml(ebmlTracks, 0, ebml(ebmlTrackEntry, 0,
+		ebml(ebmlTrackNumber, 0, []byte{1}), ebml(ebmlCodecID, 0, []byte("A_OPUS"))))
+	// Как пишет MediaRecorder: без Duration, Segment и Cluster неизвестного размера
+	webm := bytes.Join([][]byte{header, ebml(ebmlSegment, -1,
+		ebml(ebmlInfo, 0, ebml(ebmlTimecodeScale, 0, []byte{0x0F, 0x42, 0x40})),
+		tracks,
+		ebml(ebmlCluster, -1, ebml(ebmlTimecode, 0, []byte{0}), simpleBlock(1, 0, 0xFC), simpleBlock(1, 20, 0xFC)),
+		ebml(ebmlCluster, -1, ebml(ebmlTimecode, 0, []byte{0x03, 0xE8}), simpleBlock(2, 990, 0xFC), simpleBlock(1, 960, 0xFC)),
+	)}, nil)
+	// 40 мс пакет: два кадра по 20 мс
+	webm = append(webm, ebml(ebmlBlockGroup, 0, ebml(ebmlBlock, 0, []byte{0x81, 0x03, 0xC0, 0x00, 0xFD, 0x00}))...)
+	webm = append(webm, ebml(ebmlCluster, 0)[:3]...) // обрезанный элемент
+
+	declared := bytes.Join([][]byte{header, ebml(ebmlSegment, 0,
+		ebml(ebmlInfo, 0, ebml(ebmlDuration, 0, binary.BigEndian.AppendUint64(nil, math.Float64bits(1500)))),
+		tracks,
+	)}, nil)
+
+	// MPEG 1 Layer III, 128 кбит/с, 44,1 кГц, стерео: кадр 417 байт, 1152 сэмпла
+	frame := []byte{0xFF, 0xFB, 0x90, 0x00}
+	xing := make([]byte, 417)
+	copy(xing, frame)
+	copy(xing[36:], "Xing")
+	mp3 := bytes.Join([][]byte{
+		{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5}, []byte("title"),
+		xing,
+		mp3Frames(frame, 417, 100),
+		append([]byte("TAG"), make([]byte, 125)...),
+	}, nil)
+
+	for _, tt := range []struct {
+		name     string
+		data     []byte
+		format   string
+		codec    string
+		duration float64
+		bitrate  int
+	}{
+		{"ogg opus", ogg, "audio/ogg", "opus", 3, len(ogg) * 8 / 3},
+		{"ogg vorbis", vorbis, "audio/ogg", "vorbis", 2, 96000},
+		{"webm stream", webm, "audio/webm", "opus", 2, len(webm) * 8 / 2},
+		{"webm duration", declared, "audio/webm", "opus", 1.5, len(declared) * 8 * 2 / 3},
+		{"mp3", mp3, "audio/mpeg", "mp3", 100 * 1152 / 44100.0, 127706},
+	} {
+		info, err := Probe(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if info.Format != tt.format || info.Codec != tt.codec || math.Abs(info.Duration-tt.duration) > 1e-9 ||
+			math.Abs(float64(info.Bitrate-tt.bitrate)) > 1 {
+			t.Errorf("%s: %+v, want %s %s %.3fs %d bit/s", tt.name, info, tt.format, tt.codec, tt.duration, tt.bitrate)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"wav":         append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 64)...),
+		"empty":       nil,
+		"ogg flac":    oggPage(0, []byte("\x7fFLAC\x01\x00")),
+		"webm video":  bytes.Join([][]byte{header, ebml(ebmlTrackEntry, 0, ebml(ebmlTrackNumber, 0, []byte{1}), ebml(ebmlCodecID, 0, []byte("V_VP8")))}, nil),
+		"lone frame":  append(make([]byte, 10), frame...),
+		"ogg no data": oggPage(0, opusHead),
+	} {
+		if info, err := Probe(data); err == nil {
+			t.Errorf("%s: probed as %+v", name, info)
+		} else if name != "ogg no data" && !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestReceiveProbesDuration(t *testing.T) {
+	vp := New(nil, t.TempDir())
+	opusHead := append([]byte("OpusHead\x01\x01"), 0, 0, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	data := append(oggPage(0, opusHead), oggPage(96000, []byte{0xFC})...)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type": "voice", "id": "vm_1", "duration": 42.0, "format": "webm", "data": data,
+	})
+	msg, err := vp.Receive(t.Context(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Duration != 2 || msg.Format != "audio/ogg" || msg.Codec != "opus" || msg.Bitrate == 0 {
+		t.Errorf("received: %+v", msg)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hydra/pkg/ids"
 	"hydra/pkg/transport"
 	"io"
 	"log"
@@ -20,10 +21,12 @@ type VoiceMessage struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	Timestamp time.Time `json:"timestamp"`
-	Duration  float64   `json:"duration"`  // Длительность в секундах
-	Format    string    `json:"format"`    // audio/webm, audio/mp3, etc.
-	Data      []byte    `json:"-"`         // Бинарные данные аудио
-	FilePath  string    `json:"file_path"` // Путь к файлу (если сохранено)
+	Duration  float64   `json:"duration"`          // Длительность в секундах
+	Format    string    `json:"format"`            // audio/webm, audio/mp3, etc.
+	Codec     string    `json:"codec,omitempty"`   // opus, vorbis, mp3; пусто - контейнер не распознан
+	Bitrate   int       `json:"bitrate,omitempty"` // Средний битрейт, бит/с
+	Data      []byte    `json:"-"`                 // Бинарные данные аудио
+	FilePath  string    `json:"file_path"`         // Путь к файлу (если сохранено)
 }
 
 // VoiceProcessor обрабатывает голосовые сообщения
@@ -71,7 +74,7 @@ func (vp *VoiceProcessor) Record(ctx context.Context, fileHeader *multipart.File
 	}
 
 	// Создаем уникальное имя файла
-	filename := fmt.Sprintf("voice_%d_%s", time.Now().UnixNano(), fileHeader.Filename)
+	filename := "voice_" + ids.New() + "_" + fileHeader.Filename
 	filePath := filepath.Join(vp.storageDir, filename)
 
 	// Сохраняем файл
@@ -83,11 +86,13 @@ func (vp *VoiceProcessor) Record(ctx context.Context, fileHeader *multipart.File
 	voiceMsg := &VoiceMessage{
 		ID:        generateID(),
 		Timestamp: time.Now(),
-		Duration:  estimateDuration(len(audioData)), // Примерная оценка длительности
 		Format:    fileHeader.Header.Get("Content-Type"),
 		Data:      audioData,
 		FilePath:  filePath,
 	}
+	if !voiceMsg.probe() {
+		voiceMsg.Duration = estimateDuration(len(audioData)) // Примерная оценка длительности
+	}
 
 	return voiceMsg, nil
 }
@@ -102,6 +107,8 @@ func (vp *VoiceProcessor) Send(ctx context.Context, voiceMsg *VoiceMessage) erro
 		"timestamp": voiceMsg.Timestamp,
 		"duration":  voiceMsg.Duration,
 		"format":    voiceMsg.Format,
+		"codec":     voiceMsg.Codec,
+		"bitrate":   voiceMsg.Bitrate,
 		"data":      voiceMsg.Data, // Бинарные данные
 	}
 
@@ -123,6 +130,8 @@ func (vp *VoiceProcessor) Receive(ctx context.Context, data []byte) (*VoiceMessa
 		Timestamp time.Time `json:"timestamp"`
 		Duration  float64   `json:"duration"`
 		Format    string    `json:"format"`
+		Codec     string    `json:"codec"`
+		Bitrate   int       `json:"bitrate"`
 		Data      []byte    `json:"data"`
 	}
 
@@ -148,9 +157,13 @@ func (vp *VoiceProcessor) Receive(ctx context.Context, data []byte) (*VoiceMessa
 		Timestamp: message.Timestamp,
 		Duration:  message.Duration,
 		Format:    message.Format,
+		Codec:     message.Codec,
+		Bitrate:   message.Bitrate,
 		Data:      message.Data,
 		FilePath:  filePath,
 	}
+	// Длительность из самих данных точнее присланной отправителем
+	voiceMsg.probe()
 
 	return voiceMsg, nil
 }
@@ -225,10 +238,21 @@ func (vp *VoiceProcessor) Cleanup(maxAge time.Duration) {
 
 // generateID генерирует уникальный ID для сообщения
 func generateID() string {
-	return fmt.Sprintf("vm_%d", time.Now().UnixNano())
+	return "vm_" + ids.New()
+}
+
+// probe заполняет длительность, формат, кодек и битрейт по метаданным контейнера;
+// false - контейнер не распознан
+func (vm *VoiceMessage) probe() bool {
+	info, err := Probe(vm.Data)
+	if err != nil {
+		return false
+	}
+	vm.Duration, vm.Format, vm.Codec, vm.Bitrate = info.Duration, info.Format, info.Codec, info.Bitrate
+	return true
 }
 
-// estimateDuration оценивает длительность аудио на основе размера
+// estimateDuration оценивает длительность аудио нераспознанного формата на основе размера
 func estimateDuration(dataSize int) float64 {
 	// Примерная оценка: 1KB ≈ 0.06 секунды для opus/ogg
 	return float64(dataSize) / 1024 * 0.06
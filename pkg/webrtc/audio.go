@@ -0,0 +1,148 @@
+package webrtc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+// AudioConfig - параметры аудио звонка. Звонки Hydra часто идут через резервные
+// пути с потерями, где настройки по умолчанию работают плохо.
+type AudioConfig struct {
+	// JitterBufferTargetMs - целевая задержка буфера джиттера. Применяется клиентом
+	// (RTCRtpReceiver.jitterBufferTarget); 0 - выбор браузера.
+	JitterBufferTargetMs int `json:"jitter_buffer_target_ms"`
+	// FEC - внутриполосная коррекция ошибок Opus (восстановление потерянных пакетов)
+	FEC bool `json:"fec"`
+	// DTX - прерывистая передача: в паузах речи пакеты почти не отправляются
+	DTX bool `json:"dtx"`
+	// MaxBitrate - максимальный средний битрейт Opus в бит/с; 0 - по умолчанию
+	MaxBitrate int `json:"max_bitrate"`
+}
+
+// DefaultAudioConfig возвращает настройки, рассчитанные на каналы с потерями
+func DefaultAudioConfig() AudioConfig {
+	return AudioConfig{
+		JitterBufferTargetMs: 80,
+		FEC:                  true,
+		DTX:                  false,
+		MaxBitrate:           32000,
+	}
+}
+
+// Validate проверяет диапазоны параметров
+func (c AudioConfig) Validate() error {
+	if c.JitterBufferTargetMs < 0 || c.JitterBufferTargetMs > 4000 {
+		return fmt.Errorf("jitter_buffer_target_ms must be between 0 and 4000")
+	}
+	if c.MaxBitrate != 0 && (c.MaxBitrate < 6000 || c.MaxBitrate > 510000) {
+		return fmt.Errorf("max_bitrate must be between 6000 and 510000 bps")
+	}
+	return nil
+}
+
+// fmtp возвращает параметры формата Opus для SDP (a=fmtp)
+func (c AudioConfig) fmtp() string {
+	params := []string{"minptime=10", "useinbandfec=" + boolParam(c.FEC), "usedtx=" + boolParam(c.DTX)}
+	if c.MaxBitrate > 0 {
+		params = append(params, fmt.Sprintf("maxaveragebitrate=%d", c.MaxBitrate))
+	}
+	return strings.Join(params, ";")
+}
+
+// opusPayloadType - payload type Opus в SDP (как в пресетах pion и браузеров)
+const opusPayloadType = 111
+
+// newPeerConnection создает соединение, в котором Opus зарегистрирован с параметрами звонка.
+// Параметры кодека передаются собеседнику в SDP и задаются при создании соединения.
+// В роли отвечающего pion повторяет параметры из предложения собеседника, поэтому клиент
+// получает те же настройки через API и применяет их у себя.
+func newPeerConnection(config webrtc.Configuration, audio AudioConfig) (*webrtc.PeerConnection, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: audio.fmtp(),
+		},
+		PayloadType: opusPayloadType,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register opus codec: %w", err)
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry))
+	return api.NewPeerConnection(config)
+}
+
+func boolParam(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// SetDefaultAudioConfig задает настройки аудио для новых звонков
+func (cm *CallManager) SetDefaultAudioConfig(cfg AudioConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.defaultAudio = cfg
+	return nil
+}
+
+// GetAudioConfig возвращает действующие настройки аудио звонка. Для неизвестного
+// звонка возвращаются заданные заранее или настройки по умолчанию.
+func (cm *CallManager) GetAudioConfig(callID string) AudioConfig {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.audioConfigLocked(callID)
+}
+
+// SetCallAudioConfig задает настройки аудио конкретного звонка. Если звонок еще не создан,
+// настройки применяются при его создании. У активного звонка можно менять только
+// буфер джиттера: параметры кодека согласуются при установке соединения.
+func (cm *CallManager) SetCallAudioConfig(callID string, cfg AudioConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		cm.pendingAudio[callID] = cfg
+		return nil
+	}
+
+	current := session.Audio
+	current.JitterBufferTargetMs = cfg.JitterBufferTargetMs
+	if current != cfg {
+		return fmt.Errorf("fec, dtx and max_bitrate can only be set before the call is established")
+	}
+	session.Audio = cfg
+	return nil
+}
+
+// audioConfigLocked возвращает настройки аудио звонка. Вызывается под cm.mu.
+func (cm *CallManager) audioConfigLocked(callID string) AudioConfig {
+	if session, exists := cm.activeCalls[callID]; exists {
+		return session.Audio
+	}
+	if cfg, exists := cm.pendingAudio[callID]; exists {
+		return cfg
+	}
+	return cm.defaultAudio
+}
@@ -0,0 +1,233 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"hydra/pkg/ids"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// chatChannelLabel - метка канала данных для чата внутри звонка
+const chatChannelLabel = "chat"
+
+// maxChatLog - максимальное число сообщений чата, хранимых за один звонок
+const maxChatLog = 1000
+
+// Типы сообщений чата звонка
+const (
+	ChatTypeText       = "text"
+	ChatTypeReaction   = "reaction"
+	ChatTypeQuickReply = "quick_reply"
+
+	// Служебные сообщения записи: сервер рассылает ChatTypeRecording,
+	// участник отвечает ChatTypeRecordingConsent с Body granted/denied
+	ChatTypeRecording        = "recording"
+	ChatTypeRecordingConsent = "recording_consent"
+)
+
+// ChatMessage - сообщение, реакция или быстрый ответ внутри звонка
+type ChatMessage struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	SenderID string    `json:"sender_id"`
+	Body     string    `json:"body"`               // текст или emoji реакции
+	ReplyTo  string    `json:"reply_to,omitempty"` // ID сообщения, на которое отвечают
+	SentAt   time.Time `json:"sent_at"`
+
+	RecordingID string `json:"recording_id,omitempty"` // для служебных сообщений записи
+}
+
+// OnCallEnded задает обработчик завершения звонка. Используется для сохранения
+// чата звонка в соответствующую беседу.
+func (cm *CallManager) OnCallEnded(handler func(session *CallSession)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onCallEnded = handler
+}
+
+// SetConversation привязывает звонок к беседе, в которую будет сохранен чат
+func (cm *CallManager) SetConversation(callID, conversationID string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return fmt.Errorf("call session not found")
+	}
+	session.ConversationID = conversationID
+	return nil
+}
+
+// SendChat отправляет сообщение от локального участника в чат звонка
+// (в групповой комнате - всем участникам).
+func (cm *CallManager) SendChat(callID string, msg ChatMessage) (*ChatMessage, error) {
+	if err := validateChatMessage(&msg); err != nil {
+		return nil, err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call session not found")
+	}
+
+	msg.ID = "cm_" + ids.New()
+	msg.SentAt = time.Now()
+	appendChatLog(session, msg)
+
+	targets := []*CallSession{session}
+	if session.RoomID != "" {
+		targets = cm.roomSessionsLocked(session.RoomID, "")
+	}
+	for _, target := range targets {
+		sendChatLocked(target, msg)
+	}
+
+	return &msg, nil
+}
+
+// GetChatLog возвращает сообщения чата звонка
+func (cm *CallManager) GetChatLog(callID string) ([]ChatMessage, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call session not found")
+	}
+
+	chatLog := make([]ChatMessage, len(session.ChatLog))
+	copy(chatLog, session.ChatLog)
+	return chatLog, nil
+}
+
+// setChatChannel сохраняет канал чата, открытый удаленной стороной
+func (cm *CallManager) setChatChannel(callID string, dc *webrtc.DataChannel) {
+	cm.mu.Lock()
+	if session, exists := cm.activeCalls[callID]; exists {
+		session.ChatChannel = dc
+	}
+	cm.mu.Unlock()
+
+	cm.attachChatChannel(callID, dc)
+}
+
+// attachChatChannel подписывается на входящие сообщения канала чата.
+// Не блокирует cm.mu, поэтому может вызываться при создании звонка.
+func (cm *CallManager) attachChatChannel(callID string, dc *webrtc.DataChannel) {
+	dc.OnMessage(func(raw webrtc.DataChannelMessage) {
+		var msg ChatMessage
+		if err := json.Unmarshal(raw.Data, &msg); err != nil {
+			log.Printf("Call %s: invalid chat message: %v", callID, err)
+			return
+		}
+		if msg.Type == ChatTypeRecordingConsent {
+			if _, err := cm.SetRecordingConsent(callID, msg.Body == RecordingConsentGranted); err != nil {
+				log.Printf("Call %s: recording consent ignored: %v", callID, err)
+			}
+			return
+		}
+		if err := validateChatMessage(&msg); err != nil {
+			log.Printf("Call %s: rejected chat message: %v", callID, err)
+			return
+		}
+		cm.handleChatMessage(callID, msg)
+	})
+}
+
+// handleChatMessage сохраняет сообщение собеседника и пересылает его другим участникам комнаты
+func (cm *CallManager) handleChatMessage(callID string, msg ChatMessage) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return
+	}
+
+	if msg.ID == "" {
+		msg.ID = "cm_" + ids.New()
+	}
+	msg.SentAt = time.Now()
+	appendChatLog(session, msg)
+
+	if session.RoomID != "" {
+		for _, other := range cm.roomSessionsLocked(session.RoomID, callID) {
+			appendChatLog(other, msg)
+			sendChatLocked(other, msg)
+		}
+	}
+}
+
+// roomSessionsLocked возвращает сессии участников комнаты, кроме exclude
+func (cm *CallManager) roomSessionsLocked(roomID, exclude string) []*CallSession {
+	room, exists := cm.rooms[roomID]
+	if !exists {
+		return nil
+	}
+
+	var sessions []*CallSession
+	for id := range room.forwards {
+		if id == exclude {
+			continue
+		}
+		if s := cm.activeCalls[id]; s != nil {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// notifyCallEnded передает завершенную сессию обработчику. Вызывается под cm.mu.
+func (cm *CallManager) notifyCallEnded(session *CallSession) {
+	if cm.onCallEnded == nil {
+		return
+	}
+	go cm.onCallEnded(session)
+}
+
+func sendChatLocked(session *CallSession, msg ChatMessage) {
+	if session.ChatChannel == nil || session.ChatChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if err := session.ChatChannel.SendText(string(data)); err != nil {
+		log.Printf("Call %s: failed to send chat message: %v", session.ID, err)
+	}
+}
+
+func appendChatLog(session *CallSession, msg ChatMessage) {
+	if len(session.ChatLog) >= maxChatLog {
+		session.ChatLog = session.ChatLog[1:]
+	}
+	session.ChatLog = append(session.ChatLog, msg)
+}
+
+func validateChatMessage(msg *ChatMessage) error {
+	switch msg.Type {
+	case "":
+		msg.Type = ChatTypeText
+	case ChatTypeText, ChatTypeReaction, ChatTypeQuickReply:
+	default:
+		return fmt.Errorf("unknown chat message type: %s", msg.Type)
+	}
+
+	if msg.Body == "" {
+		return fmt.Errorf("empty chat message")
+	}
+	if msg.Type == ChatTypeReaction && len([]rune(msg.Body)) > 8 {
+		return fmt.Errorf("reaction must be a single emoji")
+	}
+	if len(msg.Body) > 4096 {
+		return fmt.Errorf("chat message too long")
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package webrtc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// CallState - состояние звонка с точки зрения управления вызовом
+type CallState string
+
+const (
+	CallStateActive      CallState = "active"
+	CallStateHeld        CallState = "held"
+	CallStateTransferred CallState = "transferred"
+)
+
+// Room - групповая комната SFU. Медиа каждого участника пересылается остальным
+// через отдельные локальные треки, добавленные в их PeerConnection.
+type Room struct {
+	ID        string
+	CreatedAt time.Time
+
+	// forwards[получатель][источник] - трек, через который получателю пересылается аудио источника
+	forwards map[string]map[string]*webrtc.TrackLocalStaticRTP
+}
+
+// Hold ставит звонок на удержание: локальное аудио перестает отправляться,
+// пересылка в комнате для этого участника приостанавливается. Соединение сохраняется.
+func (cm *CallManager) Hold(callID string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return fmt.Errorf("call session not found")
+	}
+	if session.State == CallStateHeld {
+		return nil
+	}
+	if session.State != CallStateActive {
+		return fmt.Errorf("call %s cannot be held in state %s", callID, session.State)
+	}
+
+	if err := session.AudioSender.ReplaceTrack(nil); err != nil {
+		return fmt.Errorf("failed to pause audio: %w", err)
+	}
+	session.State = CallStateHeld
+	log.Printf("Call %s on hold", callID)
+	return nil
+}
+
+// Resume снимает звонок с удержания
+func (cm *CallManager) Resume(callID string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return fmt.Errorf("call session not found")
+	}
+	if session.State != CallStateHeld {
+		return fmt.Errorf("call %s is not on hold", callID)
+	}
+
+	if err := session.AudioSender.ReplaceTrack(session.AudioTrack); err != nil {
+		return fmt.Errorf("failed to resume audio: %w", err)
+	}
+	session.State = CallStateActive
+	log.Printf("Call %s resumed", callID)
+	return nil
+}
+
+// Transfer выполняет сопровождаемый перевод: звонок callID соединяется с
+// консультационным звонком consultCallID, а локальный участник выходит из разговора.
+// Возвращает новые предложения SDP, на которые клиенты должны ответить через HandleAnswer.
+func (cm *CallManager) Transfer(callID, consultCallID string) (map[string]*CallOffer, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call session not found")
+	}
+	consult, exists := cm.activeCalls[consultCallID]
+	if !exists {
+		return nil, fmt.Errorf("consult call session not found")
+	}
+	if session.RoomID != "" || consult.RoomID != "" {
+		return nil, fmt.Errorf("calls in a group room cannot be transferred")
+	}
+
+	// Локальный участник перестает говорить в оба звонка
+	for _, s := range []*CallSession{session, consult} {
+		if err := s.AudioSender.ReplaceTrack(nil); err != nil {
+			return nil, fmt.Errorf("failed to detach local audio from %s: %w", s.ID, err)
+		}
+	}
+
+	// Соединяем собеседников через скрытую комнату
+	roomID := "transfer-" + callID
+	offers, err := cm.joinRoomLocked(roomID, session)
+	if err != nil {
+		return nil, err
+	}
+	consultOffers, err := cm.joinRoomLocked(roomID, consult)
+	if err != nil {
+		return nil, err
+	}
+	for id, offer := range consultOffers {
+		offers[id] = offer
+	}
+
+	session.State = CallStateTransferred
+	session.TransferTo = consultCallID
+	consult.State = CallStateTransferred
+	consult.TransferTo = callID
+
+	log.Printf("Call %s transferred to %s", callID, consultCallID)
+	return offers, nil
+}
+
+// UpgradeToRoom переводит звонок 1:1 в групповую комнату без разрыва соединения.
+// Существующий PeerConnection сохраняется, поэтому медиа не прерывается.
+func (cm *CallManager) UpgradeToRoom(callID string) (string, map[string]*CallOffer, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return "", nil, fmt.Errorf("call session not found")
+	}
+	if session.RoomID != "" {
+		return session.RoomID, map[string]*CallOffer{}, nil
+	}
+
+	roomID := "room-" + callID
+	offers, err := cm.joinRoomLocked(roomID, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return roomID, offers, nil
+}
+
+// JoinRoom добавляет звонок в существующую групповую комнату
+func (cm *CallManager) JoinRoom(roomID, callID string) (map[string]*CallOffer, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call session not found")
+	}
+	if _, exists := cm.rooms[roomID]; !exists {
+		return nil, fmt.Errorf("room not found")
+	}
+	return cm.joinRoomLocked(roomID, session)
+}
+
+// GetRoomParticipants возвращает ID звонков-участников комнаты
+func (cm *CallManager) GetRoomParticipants(roomID string) ([]string, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	room, exists := cm.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room not found")
+	}
+
+	ids := make([]string, 0, len(room.forwards))
+	for id := range room.forwards {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetCallState возвращает состояние звонка
+func (cm *CallManager) GetCallState(callID string) (CallState, string, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return "", "", fmt.Errorf("call session not found")
+	}
+	return session.State, session.RoomID, nil
+}
+
+// SetCallUser запоминает пользователя, чей это звонок: по нему проверяются запросы
+// управления записью и доступ к сохраненной записи
+func (cm *CallManager) SetCallUser(callID, userID string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return fmt.Errorf("call session not found")
+	}
+	session.UserID = userID
+	return nil
+}
+
+// CallUser возвращает пользователя звонка; пусто - неизвестен
+func (cm *CallManager) CallUser(callID string) (string, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return "", fmt.Errorf("call session not found")
+	}
+	return session.UserID, nil
+}
+
+// joinRoomLocked добавляет сессию в комнату (создавая ее при необходимости),
+// настраивает пересылку между участниками и готовит предложения для пересогласования.
+// Вызывается под cm.mu.
+func (cm *CallManager) joinRoomLocked(roomID string, session *CallSession) (map[string]*CallOffer, error) {
+	if session.RoomID != "" && session.RoomID != roomID {
+		return nil, fmt.Errorf("call %s is already in room %s", session.ID, session.RoomID)
+	}
+
+	room, exists := cm.rooms[roomID]
+	if !exists {
+		room = &Room{
+			ID:        roomID,
+			CreatedAt: time.Now(),
+			forwards:  make(map[string]map[string]*webrtc.TrackLocalStaticRTP),
+		}
+		cm.rooms[roomID] = room
+	}
+	if _, joined := room.forwards[session.ID]; joined {
+		return map[string]*CallOffer{}, nil
+	}
+
+	room.forwards[session.ID] = make(map[string]*webrtc.TrackLocalStaticRTP)
+	session.RoomID = roomID
+
+	affected := map[string]*CallSession{}
+	for otherID := range room.forwards {
+		if otherID == session.ID {
+			continue
+		}
+		other := cm.activeCalls[otherID]
+		if other == nil {
+			continue
+		}
+
+		// other <- session и session <- other
+		if err := cm.addForwardLocked(room, other, session.ID); err != nil {
+			return nil, err
+		}
+		if err := cm.addForwardLocked(room, session, otherID); err != nil {
+			return nil, err
+		}
+		affected[otherID] = other
+		affected[session.ID] = session
+	}
+
+	offers := make(map[string]*CallOffer)
+	for id, s := range affected {
+		offer, err := renegotiate(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to renegotiate call %s: %w", id, err)
+		}
+		offers[id] = offer
+	}
+
+	cm.requestConsentLocked(roomID, session)
+
+	log.Printf("Call %s joined room %s (%d participants)", session.ID, roomID, len(room.forwards))
+	return offers, nil
+}
+
+// addForwardLocked добавляет получателю трек для пересылки аудио источника
+func (cm *CallManager) addForwardLocked(room *Room, target *CallSession, sourceID string) error {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+		"audio-"+sourceID,
+		sourceID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create forward track: %w", err)
+	}
+	if _, err := target.PeerConn.AddTrack(track); err != nil {
+		return fmt.Errorf("failed to add forward track to %s: %w", target.ID, err)
+	}
+	room.forwards[target.ID][sourceID] = track
+	return nil
+}
+
+// leaveRoomLocked убирает сессию из комнаты; пустые комнаты удаляются. Вызывается под cm.mu.
+func (cm *CallManager) leaveRoomLocked(session *CallSession) {
+	room, exists := cm.rooms[session.RoomID]
+	if !exists {
+		return
+	}
+
+	delete(room.forwards, session.ID)
+	for _, forwards := range room.forwards {
+		delete(forwards, session.ID)
+	}
+	session.RoomID = ""
+
+	if rec, exists := cm.recordings[room.ID]; exists {
+		cm.closeRecordingTrackLocked(rec, session.ID)
+		if len(room.forwards) == 0 && (rec.State == RecordingPending || rec.State == RecordingActive) {
+			cm.stopRecordingLocked(rec)
+		} else {
+			cm.maybeStartRecordingLocked(rec) // возможно, ждали согласия только от вышедшего
+		}
+	}
+
+	if len(room.forwards) == 0 {
+		delete(cm.rooms, room.ID)
+		delete(cm.recordings, room.ID)
+	}
+}
+
+// renegotiate создает новое предложение SDP для существующего соединения
+func renegotiate(session *CallSession) (*CallOffer, error) {
+	offer, err := session.PeerConn.CreateOffer(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.PeerConn.SetLocalDescription(offer); err != nil {
+		return nil, err
+	}
+	return &CallOffer{SDP: offer.SDP, Type: offer.Type.String()}, nil
+}
+
+// handleRemoteTrack читает входящее аудио собеседника и пересылает его участникам комнаты
+func (cm *CallManager) handleRemoteTrack(callID string, track *webrtc.TrackRemote) {
+	cm.mu.Lock()
+	if session, exists := cm.activeCalls[callID]; exists {
+		session.RemoteTrack = track
+	}
+	cm.mu.Unlock()
+
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Call %s: remote track read error: %v", callID, err)
+			}
+			return
+		}
+
+		for _, target := range cm.forwardTargets(callID) {
+			if err := target.WriteRTP(packet); err != nil && err != io.ErrClosedPipe {
+				log.Printf("Call %s: forward error: %v", callID, err)
+			}
+		}
+
+		if rec := cm.recordingTarget(callID); rec != nil {
+			if err := rec.write(packet); err != nil {
+				log.Printf("Call %s: recording error: %v", callID, err)
+			}
+		}
+	}
+}
+
+// forwardTargets возвращает треки, в которые нужно переслать пакет источника.
+// Участники на удержании не слышат других и не слышны сами.
+func (cm *CallManager) forwardTargets(sourceID string) []*webrtc.TrackLocalStaticRTP {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	source, exists := cm.activeCalls[sourceID]
+	if !exists || source.RoomID == "" || source.State == CallStateHeld {
+		return nil
+	}
+	room, exists := cm.rooms[source.RoomID]
+	if !exists {
+		return nil
+	}
+
+	var targets []*webrtc.TrackLocalStaticRTP
+	for targetID, forwards := range room.forwards {
+		target := cm.activeCalls[targetID]
+		if target == nil || target.State == CallStateHeld {
+			continue
+		}
+		if track, ok := forwards[sourceID]; ok {
+			targets = append(targets, track)
+		}
+	}
+	return targets
+}
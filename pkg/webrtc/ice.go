@@ -0,0 +1,166 @@
+package webrtc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hydra/pkg/transport"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+)
+
+// EphemeralCredentials генерирует временные учетные данные по схеме TURN REST API
+// (совместимо с coturn use-auth-secret): username = "<expiry>:<user>", credential = base64(HMAC-SHA1(secret, username)).
+func EphemeralCredentials(secret, user string, ttl time.Duration) (username, credential string) {
+	expiry := time.Now().Add(ttl).Unix()
+	username = strconv.FormatInt(expiry, 10)
+	if user != "" {
+		username += ":" + user
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, credential
+}
+
+// CheckICEServer проверяет доступность STUN/TURN сервера и возвращает время ответа.
+// Для stun/turn по UDP/TCP отправляется STUN Binding запрос, для turns проверяется TLS рукопожатие.
+func CheckICEServer(ctx context.Context, rawURL string) (time.Duration, error) {
+	uri, err := stun.ParseURI(rawURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ICE server URL: %w", err)
+	}
+
+	addr := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+	network := "udp"
+	if uri.Proto == stun.ProtoTypeTCP {
+		network = "tcp"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var dialer net.Dialer
+
+	if uri.Scheme == stun.SchemeTypeTURNS || uri.Scheme == stun.SchemeTypeSTUNS {
+		conn, err := (&tls.Dialer{NetDialer: &dialer, Config: &tls.Config{ServerName: uri.Host}}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return 0, fmt.Errorf("TLS dial to %s failed: %w", addr, err)
+		}
+		conn.Close()
+		return time.Since(start), nil
+	}
+
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return 0, fmt.Errorf("dial to %s failed: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.Write(request.Raw); err != nil {
+		return 0, fmt.Errorf("failed to send binding request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, fmt.Errorf("no binding response from %s: %w", addr, err)
+	}
+
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		return 0, fmt.Errorf("invalid STUN response from %s: %w", addr, err)
+	}
+	if response.TransactionID != request.TransactionID {
+		return 0, fmt.Errorf("unexpected STUN transaction from %s", addr)
+	}
+
+	return time.Since(start), nil
+}
+
+// ErrNoCallPath - политика транспорта не оставляет разрешенного пути для медиа звонка
+var ErrNoCallPath = errors.New("no approved path for call media")
+
+// SetMediaPolicy применяет политику транспорта к медиа звонков: без PathDirect звонки идут
+// только через TURN (relay), без PathTURN TURN серверы не используются.
+func (cm *CallManager) SetMediaPolicy(policy *transport.Policy) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.allowDirect = policy.Allows(transport.TrafficCalls, transport.PathDirect)
+	cm.allowTURN = policy.Allows(transport.TrafficCalls, transport.PathTURN)
+}
+
+// CallPathAvailable проверяет, есть ли разрешенный политикой путь для медиа звонка
+func (cm *CallManager) CallPathAvailable() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	_, err := cm.iceConfigLocked()
+	return err
+}
+
+// iceConfigLocked строит конфигурацию ICE с учетом политики. Вызывается под cm.mu.
+func (cm *CallManager) iceConfigLocked() (webrtc.Configuration, error) {
+	config := webrtc.Configuration{}
+	for _, server := range cm.iceServers {
+		var urls []string
+		for _, u := range server.URLs {
+			if IsTURNURL(u) && !cm.allowTURN {
+				continue
+			}
+			urls = append(urls, u)
+		}
+		if len(urls) > 0 {
+			server.URLs = urls
+			config.ICEServers = append(config.ICEServers, server)
+		}
+	}
+
+	if cm.allowDirect {
+		return config, nil
+	}
+
+	// Только relay: нужен хотя бы один разрешенный TURN сервер
+	config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	for _, server := range config.ICEServers {
+		for _, u := range server.URLs {
+			if IsTURNURL(u) {
+				return config, nil
+			}
+		}
+	}
+	return config, ErrNoCallPath
+}
+
+// IsTURNURL сообщает, является ли URL ICE сервера TURN relay
+func IsTURNURL(u string) bool {
+	return strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:")
+}
+
+// SetICEServers обновляет список ICE серверов для новых звонков
+func (cm *CallManager) SetICEServers(servers []webrtc.ICEServer) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if len(servers) == 0 {
+		return
+	}
+	cm.iceServers = servers
+}
@@ -0,0 +1,336 @@
+package webrtc
+
+import (
+	"fmt"
+	"hydra/pkg/ids"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// RecordingSink - хранилище, в которое пишутся записи звонков (например, blobstore.Store)
+type RecordingSink interface {
+	Create(key string) (io.WriteCloser, error)
+}
+
+// RecordingState - состояние записи комнаты
+type RecordingState string
+
+const (
+	RecordingPending  RecordingState = "pending"   // ожидание согласия участников
+	RecordingActive   RecordingState = "recording" // идет запись
+	RecordingStopped  RecordingState = "stopped"
+	RecordingDeclined RecordingState = "declined" // кто-то из участников отказался
+)
+
+// Значения Body служебных сообщений ChatTypeRecording, рассылаемых участникам
+const (
+	recordingEventRequested = "consent_requested"
+	recordingEventStarted   = "started"
+	recordingEventStopped   = "stopped"
+	recordingEventDeclined  = "declined"
+)
+
+// Значения Body сообщения ChatTypeRecordingConsent от клиента
+const (
+	RecordingConsentGranted = "granted"
+	RecordingConsentDenied  = "denied"
+)
+
+// Recording - серверная запись групповой комнаты. Записывается только аудио участников,
+// явно давших согласие; каждый участник пишется в отдельный файл Ogg/Opus.
+type Recording struct {
+	ID          string            `json:"id"`
+	RoomID      string            `json:"room_id"`
+	RequestedBy string            `json:"requested_by"`
+	State       RecordingState    `json:"state"`
+	Consents    map[string]bool   `json:"consents"` // callID -> согласие
+	Files       map[string]string `json:"files"`    // callID -> ключ в хранилище
+	Users       map[string]string `json:"users"`    // callID -> пользователь записанного звонка
+	RequestedAt time.Time         `json:"requested_at"`
+	StartedAt   time.Time         `json:"started_at,omitempty"`
+	StoppedAt   time.Time         `json:"stopped_at,omitempty"`
+
+	tracks map[string]*recordingTrack
+}
+
+// recordingTrack - запись аудио одного участника
+type recordingTrack struct {
+	mu     sync.Mutex
+	writer *oggwriter.OggWriter
+	closed bool
+}
+
+func (t *recordingTrack) write(packet *rtp.Packet) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	return t.writer.WriteRTP(packet)
+}
+
+func (t *recordingTrack) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	return t.writer.Close()
+}
+
+// SetRecordingSink включает серверную запись комнат. nil отключает запись.
+func (cm *CallManager) SetRecordingSink(sink RecordingSink) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.recordingSink = sink
+}
+
+// OnRecordingFinished задает обработчик завершенной записи (для сохранения метаданных)
+func (cm *CallManager) OnRecordingFinished(handler func(rec *Recording)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onRecordingFinished = handler
+}
+
+// RequestRecording запрашивает запись комнаты. Всем участникам рассылается запрос согласия;
+// запись начинается только после того, как согласятся все. Запросивший участник
+// считается согласившимся.
+func (cm *CallManager) RequestRecording(roomID, requestedBy string) (*Recording, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.recordingSink == nil {
+		return nil, fmt.Errorf("recording is disabled")
+	}
+	room, exists := cm.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room not found")
+	}
+	if _, joined := room.forwards[requestedBy]; !joined {
+		return nil, fmt.Errorf("call %s is not in room %s", requestedBy, roomID)
+	}
+	if rec, exists := cm.recordings[roomID]; exists && (rec.State == RecordingPending || rec.State == RecordingActive) {
+		return nil, fmt.Errorf("recording already %s", rec.State)
+	}
+
+	rec := &Recording{
+		ID:          "rec-" + ids.New(),
+		RoomID:      roomID,
+		RequestedBy: requestedBy,
+		State:       RecordingPending,
+		Consents:    map[string]bool{requestedBy: true},
+		Files:       make(map[string]string),
+		Users:       make(map[string]string),
+		RequestedAt: time.Now(),
+		tracks:      make(map[string]*recordingTrack),
+	}
+	cm.recordings[roomID] = rec
+
+	cm.broadcastRecordingLocked(rec, recordingEventRequested)
+	log.Printf("Recording %s requested in room %s by %s", rec.ID, roomID, requestedBy)
+
+	cm.maybeStartRecordingLocked(rec)
+	return rec.snapshot(), nil
+}
+
+// SetRecordingConsent фиксирует ответ участника на запрос записи.
+// Отказ до начала записи отменяет ее; отказ во время записи исключает участника из нее.
+func (cm *CallManager) SetRecordingConsent(callID string, granted bool) (*Recording, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists {
+		return nil, fmt.Errorf("call session not found")
+	}
+	rec, exists := cm.recordings[session.RoomID]
+	if !exists || (rec.State != RecordingPending && rec.State != RecordingActive) {
+		return nil, fmt.Errorf("no recording in progress")
+	}
+
+	rec.Consents[callID] = granted
+
+	switch {
+	case !granted && rec.State == RecordingPending:
+		rec.State = RecordingDeclined
+		rec.StoppedAt = time.Now()
+		cm.broadcastRecordingLocked(rec, recordingEventDeclined)
+		log.Printf("Recording %s declined by %s", rec.ID, callID)
+	case !granted:
+		cm.closeRecordingTrackLocked(rec, callID)
+	case rec.State == RecordingActive:
+		cm.addRecordingTrackLocked(rec, callID)
+	default:
+		cm.maybeStartRecordingLocked(rec)
+	}
+	return rec.snapshot(), nil
+}
+
+// StopRecording останавливает запись комнаты
+func (cm *CallManager) StopRecording(roomID string) (*Recording, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	rec, exists := cm.recordings[roomID]
+	if !exists || (rec.State != RecordingPending && rec.State != RecordingActive) {
+		return nil, fmt.Errorf("no recording in progress")
+	}
+	cm.stopRecordingLocked(rec)
+	return rec.snapshot(), nil
+}
+
+// GetRecording возвращает текущее состояние записи комнаты
+func (cm *CallManager) GetRecording(roomID string) (*Recording, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	rec, exists := cm.recordings[roomID]
+	if !exists {
+		return nil, fmt.Errorf("no recording for room")
+	}
+	return rec.snapshot(), nil
+}
+
+// maybeStartRecordingLocked начинает запись, когда согласились все участники комнаты
+func (cm *CallManager) maybeStartRecordingLocked(rec *Recording) {
+	room, exists := cm.rooms[rec.RoomID]
+	if !exists || rec.State != RecordingPending {
+		return
+	}
+	for id := range room.forwards {
+		if !rec.Consents[id] {
+			return
+		}
+	}
+
+	rec.State = RecordingActive
+	rec.StartedAt = time.Now()
+	for id := range room.forwards {
+		cm.addRecordingTrackLocked(rec, id)
+	}
+	cm.broadcastRecordingLocked(rec, recordingEventStarted)
+	log.Printf("Recording %s started in room %s", rec.ID, rec.RoomID)
+}
+
+// addRecordingTrackLocked открывает файл записи для участника
+func (cm *CallManager) addRecordingTrackLocked(rec *Recording, callID string) {
+	if _, exists := rec.tracks[callID]; exists {
+		return
+	}
+
+	key := fmt.Sprintf("recordings/%s/%s.ogg", rec.ID, callID)
+	out, err := cm.recordingSink.Create(key)
+	if err != nil {
+		log.Printf("Recording %s: failed to create file for %s: %v", rec.ID, callID, err)
+		return
+	}
+	writer, err := oggwriter.NewWith(out, 48000, 2)
+	if err != nil {
+		out.Close()
+		log.Printf("Recording %s: failed to init ogg writer for %s: %v", rec.ID, callID, err)
+		return
+	}
+
+	rec.tracks[callID] = &recordingTrack{writer: writer}
+	rec.Files[callID] = key
+	if session, exists := cm.activeCalls[callID]; exists && session.UserID != "" {
+		rec.Users[callID] = session.UserID
+	}
+}
+
+func (cm *CallManager) closeRecordingTrackLocked(rec *Recording, callID string) {
+	track, exists := rec.tracks[callID]
+	if !exists {
+		return
+	}
+	if err := track.close(); err != nil {
+		log.Printf("Recording %s: failed to close file for %s: %v", rec.ID, callID, err)
+	}
+}
+
+// stopRecordingLocked закрывает все файлы записи и передает ее обработчику
+func (cm *CallManager) stopRecordingLocked(rec *Recording) {
+	wasActive := rec.State == RecordingActive
+	for id := range rec.tracks {
+		cm.closeRecordingTrackLocked(rec, id)
+	}
+	rec.State = RecordingStopped
+	rec.StoppedAt = time.Now()
+	cm.broadcastRecordingLocked(rec, recordingEventStopped)
+	log.Printf("Recording %s stopped in room %s", rec.ID, rec.RoomID)
+
+	if wasActive && len(rec.Files) > 0 && cm.onRecordingFinished != nil {
+		go cm.onRecordingFinished(rec.snapshot())
+	}
+}
+
+// requestConsentLocked запрашивает согласие у участника, вошедшего во время записи.
+// До согласия его аудио не записывается.
+func (cm *CallManager) requestConsentLocked(roomID string, session *CallSession) {
+	rec, exists := cm.recordings[roomID]
+	if !exists || (rec.State != RecordingPending && rec.State != RecordingActive) {
+		return
+	}
+	sendChatLocked(session, recordingEvent(rec, recordingEventRequested))
+}
+
+// recordingTarget возвращает запись участника, если его аудио сейчас записывается
+func (cm *CallManager) recordingTarget(callID string) *recordingTrack {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session, exists := cm.activeCalls[callID]
+	if !exists || session.RoomID == "" || session.State == CallStateHeld {
+		return nil
+	}
+	rec, exists := cm.recordings[session.RoomID]
+	if !exists || rec.State != RecordingActive {
+		return nil
+	}
+	return rec.tracks[callID]
+}
+
+// broadcastRecordingLocked оповещает всех участников комнаты об изменении состояния записи
+func (cm *CallManager) broadcastRecordingLocked(rec *Recording, event string) {
+	msg := recordingEvent(rec, event)
+	for _, session := range cm.roomSessionsLocked(rec.RoomID, "") {
+		appendChatLog(session, msg)
+		sendChatLocked(session, msg)
+	}
+}
+
+func recordingEvent(rec *Recording, event string) ChatMessage {
+	return ChatMessage{
+		ID:          "cm_" + ids.New(),
+		Type:        ChatTypeRecording,
+		SenderID:    rec.RequestedBy,
+		Body:        event,
+		RecordingID: rec.ID,
+		SentAt:      time.Now(),
+	}
+}
+
+// snapshot возвращает копию записи, безопасную для использования вне cm.mu
+func (rec *Recording) snapshot() *Recording {
+	cp := *rec
+	cp.tracks = nil
+	cp.Consents = make(map[string]bool, len(rec.Consents))
+	for id, v := range rec.Consents {
+		cp.Consents[id] = v
+	}
+	cp.Files = make(map[string]string, len(rec.Files))
+	for id, key := range rec.Files {
+		cp.Files[id] = key
+	}
+	cp.Users = make(map[string]string, len(rec.Users))
+	for id, userID := range rec.Users {
+		cp.Users[id] = userID
+	}
+	return &cp
+}
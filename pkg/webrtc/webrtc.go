@@ -14,7 +14,24 @@ import (
 type CallManager struct {
 	mu          sync.Mutex
 	activeCalls map[string]*CallSession
+	rooms       map[string]*Room
 	iceServers  []webrtc.ICEServer
+
+	// Политика транспорта для медиа звонков (см. SetMediaPolicy)
+	allowDirect bool
+	allowTURN   bool
+
+	// Настройки аудио: по умолчанию и заданные заранее для еще не созданных звонков
+	defaultAudio AudioConfig
+	pendingAudio map[string]AudioConfig
+
+	// onCallEnded вызывается после завершения звонка (для сохранения чата звонка)
+	onCallEnded func(session *CallSession)
+
+	// Серверная запись комнат (включается через SetRecordingSink)
+	recordingSink       RecordingSink
+	recordings          map[string]*Recording // roomID -> текущая или последняя запись
+	onRecordingFinished func(rec *Recording)
 }
 
 // CallSession представляет активный звонок
@@ -24,7 +41,21 @@ type CallSession struct {
 	AudioTrack  *webrtc.TrackLocalStaticSample
 	IsInitiator bool
 	CreatedAt   time.Time
-	mu          sync.Mutex
+	UserID      string // пользователь, чей это звонок (см. SetCallUser); пусто - неизвестен
+
+	// Состояние управления звонком
+	State       CallState
+	RoomID      string              // комната SFU, если звонок переведен в групповой режим
+	TransferTo  string              // ID звонка, с которым соединен при переводе
+	AudioSender *webrtc.RTPSender   // отправитель локального аудио (для удержания)
+	RemoteTrack *webrtc.TrackRemote // входящее аудио собеседника
+	Audio       AudioConfig         // FEC/DTX/битрейт/буфер джиттера звонка
+
+	// Чат внутри звонка
+	ConversationID string              // беседа, в которую сохраняется чат после звонка
+	ChatChannel    *webrtc.DataChannel // канал данных "chat"
+	ChatLog        []ChatMessage       // сообщения и реакции за время звонка
+	mu             sync.Mutex
 }
 
 // CallOffer содержит данные для установки звонка
@@ -45,7 +76,11 @@ func NewCallManager(iceServersURLs []string) *CallManager {
 		iceServersURLs = []string{"stun:stun.l.google.com:19302"}
 	}
 	return &CallManager{
-		activeCalls: make(map[string]*CallSession),
+		activeCalls:  make(map[string]*CallSession),
+		rooms:        make(map[string]*Room),
+		recordings:   make(map[string]*Recording),
+		defaultAudio: DefaultAudioConfig(),
+		pendingAudio: make(map[string]AudioConfig),
 		iceServers: []webrtc.ICEServer{
 			{
 				URLs: iceServersURLs,
@@ -59,12 +94,17 @@ func (cm *CallManager) CreateOffer(ctx context.Context, callID string) (*CallOff
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	// Создаем peer connection
-	config := webrtc.Configuration{
-		ICEServers: cm.iceServers,
+	// Создаем peer connection с путями медиа, разрешенными политикой
+	config, err := cm.iceConfigLocked()
+	if err != nil {
+		return nil, err
 	}
 
-	peerConnection, err := webrtc.NewPeerConnection(config)
+	// Параметры Opus звонка (FEC, DTX, битрейт)
+	audioConfig := cm.audioConfigLocked(callID)
+	delete(cm.pendingAudio, callID)
+
+	peerConnection, err := newPeerConnection(config, audioConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
@@ -81,7 +121,7 @@ func (cm *CallManager) CreateOffer(ctx context.Context, callID string) (*CallOff
 	}
 
 	// Добавляем трек в соединение
-	_, err = peerConnection.AddTrack(audioTrack)
+	audioSender, err := peerConnection.AddTrack(audioTrack)
 	if err != nil {
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to add audio track: %w", err)
@@ -99,6 +139,18 @@ func (cm *CallManager) CreateOffer(ctx context.Context, callID string) (*CallOff
 		log.Printf("Call %s ICE connection state: %s", callID, s.String())
 	})
 
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		cm.handleRemoteTrack(callID, track)
+	})
+
+	// Канал для текстового чата и реакций внутри звонка
+	chatChannel, err := peerConnection.CreateDataChannel(chatChannelLabel, nil)
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to create chat channel: %w", err)
+	}
+	cm.attachChatChannel(callID, chatChannel)
+
 	// Создаем предложение
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
@@ -120,6 +172,10 @@ func (cm *CallManager) CreateOffer(ctx context.Context, callID string) (*CallOff
 		AudioTrack:  audioTrack,
 		IsInitiator: true,
 		CreatedAt:   time.Now(),
+		State:       CallStateActive,
+		AudioSender: audioSender,
+		Audio:       audioConfig,
+		ChatChannel: chatChannel,
 	}
 
 	cm.activeCalls[callID] = session
@@ -155,12 +211,17 @@ func (cm *CallManager) CreateAnswer(ctx context.Context, callID string, offer Ca
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	// Создаем peer connection
-	config := webrtc.Configuration{
-		ICEServers: cm.iceServers,
+	// Создаем peer connection с путями медиа, разрешенными политикой
+	config, err := cm.iceConfigLocked()
+	if err != nil {
+		return nil, err
 	}
 
-	peerConnection, err := webrtc.NewPeerConnection(config)
+	// Параметры Opus звонка (FEC, DTX, битрейт)
+	audioConfig := cm.audioConfigLocked(callID)
+	delete(cm.pendingAudio, callID)
+
+	peerConnection, err := newPeerConnection(config, audioConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
@@ -177,7 +238,7 @@ func (cm *CallManager) CreateAnswer(ctx context.Context, callID string, offer Ca
 	}
 
 	// Добавляем трек в соединение
-	_, err = peerConnection.AddTrack(audioTrack)
+	audioSender, err := peerConnection.AddTrack(audioTrack)
 	if err != nil {
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to add audio track: %w", err)
@@ -191,6 +252,17 @@ func (cm *CallManager) CreateAnswer(ctx context.Context, callID string, offer Ca
 		}
 	})
 
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		cm.handleRemoteTrack(callID, track)
+	})
+
+	// Канал чата создает инициатор звонка
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() == chatChannelLabel {
+			cm.setChatChannel(callID, dc)
+		}
+	})
+
 	// Устанавливаем удаленное описание (предложение)
 	offerSD := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
@@ -224,6 +296,9 @@ func (cm *CallManager) CreateAnswer(ctx context.Context, callID string, offer Ca
 		AudioTrack:  audioTrack,
 		IsInitiator: false,
 		CreatedAt:   time.Now(),
+		State:       CallStateActive,
+		AudioSender: audioSender,
+		Audio:       audioConfig,
 	}
 
 	cm.activeCalls[callID] = session
@@ -253,9 +328,11 @@ func (cm *CallManager) EndCall(callID string) {
 	defer cm.mu.Unlock()
 
 	if session, exists := cm.activeCalls[callID]; exists {
+		cm.leaveRoomLocked(session)
 		session.PeerConn.Close()
 		delete(cm.activeCalls, callID)
 		log.Printf("Call %s ended", callID)
+		cm.notifyCallEnded(session)
 	}
 }
 
@@ -274,9 +351,11 @@ func (cm *CallManager) cleanupCall(callID string) {
 	defer cm.mu.Unlock()
 
 	if session, exists := cm.activeCalls[callID]; exists {
+		cm.leaveRoomLocked(session)
 		session.PeerConn.Close()
 		delete(cm.activeCalls, callID)
 		log.Printf("Cleaned up call %s", callID)
+		cm.notifyCallEnded(session)
 	}
 }
 
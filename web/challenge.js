@@ -0,0 +1,31 @@
+// Испытание перед регистрацией и отправкой кода (GET /api/v1/challenge). Доказательство
+// работы решается в браузере: подбирается счетчик, при котором у SHA-256("испытание:счетчик")
+// не меньше difficulty нулевых старших бит. Ответ CAPTCHA дает ее виджет, здесь - пустая строка.
+async function solveChallenge() {
+    const res = await fetch('/api/v1/challenge');
+    const data = await res.json();
+    const challenge = data.challenge || {};
+    if (challenge.kind !== 'pow') {
+        return '';
+    }
+
+    const encoder = new TextEncoder();
+    for (let counter = 0; ; counter++) {
+        const response = challenge.challenge + ':' + counter;
+        const hash = new Uint8Array(await crypto.subtle.digest('SHA-256', encoder.encode(response)));
+        if (leadingZeroBits(hash) >= challenge.difficulty) {
+            return response;
+        }
+    }
+}
+
+function leadingZeroBits(hash) {
+    let n = 0;
+    for (const b of hash) {
+        if (b !== 0) {
+            return n + Math.clz32(b) - 24;
+        }
+        n += 8;
+    }
+    return n;
+}